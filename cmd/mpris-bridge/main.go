@@ -0,0 +1,227 @@
+// mpris-bridge 在 Linux 桌面上注册一个 MPRIS 播放器，显示点歌台正在播放的歌曲，
+// 并把媒体键（播放/暂停、上一首、下一首）转发给点歌台，控制所有人共享的播放队列
+//
+//	JUKEBOX_PASSWORD=secret mpris-bridge -server http://jukebox.lan:8880 -user alice
+//
+// 密码也可以是“记住此设备”或扫码配对得到的登录令牌
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yeeeck/sync-jukebox/internal/mpris"
+)
+
+// 断线重连的等待时间，按倍数增长到上限
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+	// requestTimeout 转发控制命令的超时时间
+	requestTimeout = 10 * time.Second
+)
+
+// stateFrame 状态帧中桥接需要的字段，事件帧带有 type，忽略
+type stateFrame struct {
+	Type        string `json:"type"`
+	IsPlaying   bool   `json:"isPlaying"`
+	CurrentSong *struct {
+		ID         string `json:"id"`
+		Title      string `json:"title"`
+		Artist     string `json:"artist"`
+		Album      string `json:"album"`
+		DurationMs int    `json:"duration_ms"`
+	} `json:"currentSong"`
+	ProgressMs   int64   `json:"progressMs"`
+	PlaybackRate float64 `json:"playbackRate"`
+}
+
+// jukebox 点歌台的 HTTP 接口，实现 mpris.Controls
+type jukebox struct {
+	base     *url.URL
+	username string
+	password string
+	client   *http.Client
+}
+
+func main() {
+	server := flag.String("server", "http://localhost:8880", "Jukebox address")
+	username := flag.String("user", "", "Jukebox username")
+	password := flag.String("password", "", "Password or login token (defaults to $JUKEBOX_PASSWORD)")
+	name := flag.String("name", "syncjukebox", "Bus name suffix, registered as "+mpris.BusNamePrefix+"<name>")
+	flag.Parse()
+
+	if *password == "" {
+		*password = os.Getenv("JUKEBOX_PASSWORD")
+	}
+	if *username == "" || *password == "" {
+		log.Fatal("'-user' and a password ('-password' or $JUKEBOX_PASSWORD) are required")
+	}
+	base, err := url.Parse(strings.TrimSuffix(*server, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		log.Fatalf("Invalid server address %q, expected something like http://jukebox.lan:8880", *server)
+	}
+	j := &jukebox{base: base, username: *username, password: *password, client: &http.Client{Timeout: requestTimeout}}
+
+	player, err := mpris.New(*name, "SyncJukebox", j)
+	if err != nil {
+		log.Fatalf("Failed to register MPRIS player: %v", err)
+	}
+	defer player.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	log.Printf("Registered %s%s, following %s", mpris.BusNamePrefix, *name, base)
+	j.run(ctx, player)
+}
+
+// run 保持与点歌台的 WebSocket 连接，断开后按退避时间重连，直到 ctx 结束
+func (j *jukebox) run(ctx context.Context, player *mpris.Player) {
+	delay := minReconnectDelay
+	for {
+		err := j.follow(ctx, player)
+		if ctx.Err() != nil {
+			return
+		}
+		// 断开期间不显示过时的歌曲
+		player.Update(mpris.Status{})
+		log.Printf("Connection to %s lost: %v, retrying in %v", j.base, err, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// follow 建立一次连接，把收到的状态帧同步到 MPRIS 播放器
+func (j *jukebox) follow(ctx context.Context, player *mpris.Player) error {
+	wsURL := *j.base
+	wsURL.Scheme = strings.Replace(j.base.Scheme, "http", "ws", 1)
+	wsURL.Path += "/ws"
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(j.username+":"+j.password)))
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, wsURL.String(), header)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return errors.New("invalid username or password")
+		}
+		return err
+	}
+	defer conn.Close()
+	// ctx 结束时关闭连接，让 ReadMessage 返回
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	var songID, artURL string
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var frame stateFrame
+		if err := json.Unmarshal(data, &frame); err != nil || frame.Type != "" {
+			continue // 只处理状态帧
+		}
+		status := mpris.Status{
+			Playing:  frame.IsPlaying,
+			Position: time.Duration(frame.ProgressMs) * time.Millisecond,
+			Rate:     frame.PlaybackRate,
+		}
+		if song := frame.CurrentSong; song != nil {
+			if song.ID != songID {
+				songID, artURL = song.ID, j.artworkURL(ctx, song.Title)
+			}
+			status.SongID = song.ID
+			status.Title = song.Title
+			status.Artist = song.Artist
+			status.Album = song.Album
+			status.ArtURL = artURL
+			status.Length = time.Duration(song.DurationMs) * time.Millisecond
+		}
+		player.Update(status)
+	}
+}
+
+// artworkURL 从公开的正在播放信息中取封面地址，当前歌曲已经不是 title 时返回空
+func (j *jukebox) artworkURL(ctx context.Context, title string) string {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.base.String()+"/nowplaying.json", nil)
+	if err != nil {
+		return ""
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	var np struct {
+		Title      string `json:"title"`
+		ArtworkURL string `json:"artworkUrl"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&np) != nil || np.Title != title {
+		return ""
+	}
+	return np.ArtworkURL
+}
+
+func (j *jukebox) Play() error     { return j.post("/api/player/play", nil) }
+func (j *jukebox) Pause() error    { return j.post("/api/player/pause", nil) }
+func (j *jukebox) Next() error     { return j.post("/api/player/next", nil) }
+func (j *jukebox) Previous() error { return j.post("/api/player/prev", nil) }
+
+func (j *jukebox) SeekTo(position time.Duration) error {
+	return j.post("/api/player/seek", map[string]int64{"positionMs": position.Milliseconds()})
+}
+
+// post 发送控制命令，失败时返回服务端给出的错误信息，例如切歌额度已用完
+func (j *jukebox) post(path string, payload any) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(http.MethodPost, j.base.String()+path, body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(j.username, j.password)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		log.Printf("Failed to send %s: %v", path, err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	var e struct {
+		Error string `json:"error"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&e) != nil || e.Error == "" {
+		e.Error = resp.Status
+	}
+	log.Printf("Jukebox rejected %s: %s", path, e.Error)
+	return fmt.Errorf("jukebox: %s", e.Error)
+}
@@ -0,0 +1,23 @@
+// openapi-gen 生成 internal/api 嵌入的 OpenAPI 文档，由 go generate 调用
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/yeeeck/sync-jukebox/internal/api"
+)
+
+func main() {
+	output := flag.String("o", "openapi.json", "output file")
+	flag.Parse()
+
+	spec, err := api.BuildOpenAPISpec()
+	if err != nil {
+		log.Fatalf("Failed to build OpenAPI spec: %v", err)
+	}
+	if err := os.WriteFile(*output, append(spec, '\n'), 0644); err != nil {
+		log.Fatalf("Failed to write %s: %v", *output, err)
+	}
+}
@@ -3,16 +3,32 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
 	"log"
 	"mime"
+	"net"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/cors" // 1. 引入 Gin 的 CORS 库
 	"github.com/gin-gonic/gin"    // 2. 引入 Gin
+	"github.com/redis/go-redis/v9"
 	"github.com/yeeeck/sync-jukebox/internal/api"
+	"github.com/yeeeck/sync-jukebox/internal/cluster"
+	"github.com/yeeeck/sync-jukebox/internal/compress"
+	"github.com/yeeeck/sync-jukebox/internal/config"
+	"github.com/yeeeck/sync-jukebox/internal/crash"
 	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/hooks"
+	"github.com/yeeeck/sync-jukebox/internal/logfile"
+	"github.com/yeeeck/sync-jukebox/internal/mdns"
 	"github.com/yeeeck/sync-jukebox/internal/state"
+	"github.com/yeeeck/sync-jukebox/internal/store"
 	"github.com/yeeeck/sync-jukebox/internal/websocket"
 )
 
@@ -22,6 +38,7 @@ const (
 	frontendDir = "./frontend/dist"
 	serverAddr  = ":8880"
 	keyFilePath = "./invitation.key"
+	configPath  = "./config.json"
 )
 
 func main() {
@@ -39,6 +56,24 @@ func main() {
 		log.Printf("Warning: Failed to register .ts mime type: %v", err)
 	}
 
+	// --- 加载可选配置 ---
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	// --- 日志文件，由夜间维护轮转 ---
+	var logFile *logfile.File
+	if path := cfg.Maintenance.LogFile; path != "" {
+		if logFile, err = logfile.Open(path); err != nil {
+			log.Fatalf("Failed to open log file: %v", err)
+		}
+		defer logFile.Close()
+		log.SetOutput(io.MultiWriter(os.Stderr, logFile))
+		gin.DefaultWriter = io.MultiWriter(os.Stdout, logFile)
+		gin.DefaultErrorWriter = io.MultiWriter(os.Stderr, logFile)
+	}
+
 	// --- 初始化密钥管理器 ---
 	keyManager := api.NewInvitationKeyManager(keyFilePath)
 
@@ -55,23 +90,55 @@ func main() {
 		}
 	}()
 
+	// 转码参数有误或 ffmpeg 不支持时拒绝启动，避免每次上传都失败
+	if err := api.CheckFFmpeg(cfg.Transcode); err != nil {
+		log.Fatalf("Transcode configuration invalid: %v", err)
+	}
+
 	database, err := db.New(dbPath)
 	if err != nil {
 		log.Fatalf("DB initialization failed: %v", err)
 	}
 	defer database.Close()
 
+	// panic 只让出错的请求或连接失败，记录堆栈并按配置上报
+	reporter, err := crash.New(cfg.Reporting)
+	if err != nil {
+		log.Fatalf("Crash reporting configuration invalid: %v", err)
+	}
+
 	hub := websocket.NewHub()
+	hub.OnPanic(reporter.ReportPanic)
 	go hub.Run()
 
-	stateManager, err := state.NewManager(database, hub)
+	// 外部钩子（脚本、HTTP、插件），配置有误时拒绝启动
+	hookDispatcher, err := hooks.New(cfg.Hooks)
+	if err != nil {
+		log.Fatalf("Hook initialization failed: %v", err)
+	}
+
+	// 运行时状态存储，默认直接写 SQLite
+	stateStore, err := newStateStore(cfg, database)
+	if err != nil {
+		log.Fatalf("State store initialization failed: %v", err)
+	}
+	defer stateStore.Close()
+
+	stateManager, err := state.NewManager(database, hub, cfg, hookDispatcher, stateStore)
 	if err != nil {
 		log.Fatalf("State manager initialization failed: %v", err)
 	}
 
 	// 3. 初始化 Gin 引擎
 	// gin.SetMode(gin.ReleaseMode) // 如果在生产环境，取消这行注释以关闭调试日志
-	router := gin.Default()
+	// 不用 gin.Default() 自带的 Recovery，panic 由 reporter 返回 500 并上报
+	router := gin.New()
+	// 只采信受信任代理传来的 X-Forwarded-For，按 IP 的限流依赖真实的客户端地址
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid trustedProxies: %v", err)
+	}
+	// 访问日志隐藏查询参数中的凭证
+	router.Use(api.AccessLogger(), reporter.Middleware())
 
 	// 4. 配置 CORS 中间件 (gin-contrib/cors)
 	config := cors.DefaultConfig()
@@ -88,23 +155,51 @@ func main() {
 
 	// 5. 注册 API 路由
 	// 注意：这里需要根据之前修改的 api.go，传入 router 而不是 mux
-	apiHandler := api.New(database, stateManager, hub, mediaDir, keyManager)
+	apiHandler := api.New(database, stateManager, hub, mediaDir, keyManager, cfg, hookDispatcher)
+	if cfg.Cluster.Enabled {
+		// 多实例部署：广播经 Redis 转发，只有选出的主实例运行播放状态
+		elector, err := setupCluster(cfg.Cluster, hub, stateManager)
+		if err != nil {
+			log.Fatalf("Cluster initialization failed: %v", err)
+		}
+		apiHandler.UseCluster(elector)
+	}
+	if logFile != nil {
+		apiHandler.UseLogFile(logFile)
+	}
 	apiHandler.RegisterRoutes(router)
+	// 原地引用的文件可能在另一个挂载点上，启动时检查是否还在
+	go apiHandler.VerifyReferences()
+	// 为旧曲库补齐 ReplayGain，客户端据此统一音量
+	go apiHandler.BackfillGain()
 
 	// 6. 服务前端静态文件
 	// 注意：SPA (Vue/React) 需要特殊处理，不能简单使用 Static
 	// 任何没有匹配到 API 或 websocket 的路由，都应该返回 index.html
-	router.NoRoute(func(c *gin.Context) {
+	// 构建时生成的 .br / .gz 文件优先发送，没有时动态压缩
+	compressor, err := compress.New(cfg.Compression)
+	if err != nil {
+		log.Printf("Warning: Invalid compression config, using default encodings: %v", err)
+		fallback := cfg.Compression
+		fallback.Encodings = nil
+		compressor, _ = compress.New(fallback)
+	}
+	router.NoRoute(compressor.Middleware(), func(c *gin.Context) {
 		// 尝试直接访问文件 (例如 .js, .css, .ico)
 		path := frontendDir + c.Request.URL.Path
 		if _, err := os.Stat(path); err == nil {
-			c.File(path)
+			compressor.ServeFile(c, path)
 			return
 		}
 		// 如果文件不存在，或者是目录，则返回 index.html (SPA History Mode 支持)
-		c.File(frontendDir + "/index.html")
+		compressor.ServeFile(c, frontendDir+"/index.html")
 	})
 
+	// 局域网内的配套客户端通过 mDNS 自动发现服务器
+	if cfg.MDNS.Enabled {
+		go advertiseMDNS(cfg.MDNS)
+	}
+
 	// 启动服务器
 	log.Printf("SyncJukebox v2.0 server starting on %s with Gin & CORS enabled", serverAddr)
 	log.Printf("Serving frontend from: %s", frontendDir)
@@ -114,3 +209,87 @@ func main() {
 		log.Fatalf("Server failed to start: %v", err)
 	}
 }
+
+// setupCluster 连接 Redis，启用 Hub 的跨实例广播并开始主实例选举
+func setupCluster(cfg config.ClusterConfig, hub *websocket.Hub, stateManager *state.Manager) (*cluster.Elector, error) {
+	rdb, err := newRedisClient(cfg.RedisURL)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	prefix := redisPrefix(cfg)
+	hub.UseBackend(ctx, websocket.NewRedisBackend(rdb, prefix))
+	hub.SetForwardInbound(true)
+
+	elector := cluster.NewElector(rdb, prefix, cfg.AdvertiseURL)
+	elector.OnChange(func(leader bool) {
+		hub.SetForwardInbound(!leader)
+		if err := stateManager.SetActive(leader); err != nil {
+			log.Printf("Failed to activate state manager: %v", err)
+		}
+	})
+	go elector.Run(ctx)
+	log.Printf("Cluster mode enabled, advertising %s", cfg.AdvertiseURL)
+	return elector, nil
+}
+
+// advertiseMDNS 以 _sync-jukebox._tcp 和 _http._tcp 宣告网页端口，TXT 中给出 API 和 WebSocket 的路径
+func advertiseMDNS(cfg config.MDNSConfig) {
+	_, portStr, _ := net.SplitHostPort(serverAddr)
+	port, _ := strconv.Atoi(portStr)
+	hostname, _ := os.Hostname()
+	host, _, _ := strings.Cut(hostname, ".")
+	host = cmp.Or(host, "sync-jukebox")
+	name := cmp.Or(cfg.Name, fmt.Sprintf("SyncJukebox (%s)", host))
+	entries := []mdns.Entry{
+		{Instance: name, Service: "_sync-jukebox._tcp", Port: port, Text: []string{"version=2.0", "api=/api", "ws=/ws"}},
+		{Instance: name, Service: "_http._tcp", Port: port, Text: []string{"path=/"}},
+	}
+	log.Printf("Advertising %q on the local network via mDNS", name)
+	if err := mdns.Advertise(context.Background(), host, entries); err != nil {
+		log.Printf("Warning: mDNS advertisement stopped: %v", err)
+	}
+}
+
+// newStateStore 按配置创建运行时状态存储
+func newStateStore(cfg *config.Config, database *db.DB) (store.Store, error) {
+	switch cfg.Store.Type {
+	case "", "sqlite":
+		return store.NewSQLite(database), nil
+	case "redis":
+		redisURL := cfg.Store.RedisURL
+		if redisURL == "" {
+			redisURL = cfg.Cluster.RedisURL
+		}
+		rdb, err := newRedisClient(redisURL)
+		if err != nil {
+			return nil, err
+		}
+		interval := time.Duration(cfg.Store.SnapshotSeconds) * time.Second
+		log.Printf("Using Redis state store, snapshotting to SQLite every %v", cmp.Or(interval, store.DefaultSnapshotInterval))
+		return store.NewRedis(rdb, redisPrefix(cfg.Cluster), database, interval), nil
+	default:
+		return nil, fmt.Errorf("unknown state store type %q", cfg.Store.Type)
+	}
+}
+
+// newRedisClient 连接 Redis 并确认可用
+func newRedisClient(redisURL string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	rdb := redis.NewClient(opts)
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		return nil, err
+	}
+	return rdb, nil
+}
+
+// redisPrefix 返回 Redis 键和频道的前缀，同一个 Redis 上可以运行多个点歌台
+func redisPrefix(cfg config.ClusterConfig) string {
+	if cfg.Prefix == "" {
+		return "jukebox"
+	}
+	return cfg.Prefix
+}
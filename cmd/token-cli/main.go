@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/yeeeck/sync-jukebox/internal/db"
@@ -13,8 +14,10 @@ import (
 const dbPath = "./jukebox.db"
 
 func main() {
-	action := flag.String("action", "", "Action to perform: generate, disable, enable")
+	action := flag.String("action", "", "Action to perform: generate, list, disable, enable")
 	token := flag.String("token", "", "Token to act upon for disable/enable actions")
+	username := flag.String("user", "", "User the generated token acts as (default: server admin)")
+	playlistID := flag.Uint("playlist", 0, "Saved playlist the generated token queues by default")
 	flag.Parse()
 
 	database, err := db.New(dbPath)
@@ -25,13 +28,36 @@ func main() {
 
 	switch *action {
 	case "generate":
+		if *username != "" {
+			if _, err := database.GetUserByUsername(*username); err != nil {
+				log.Fatalf("User %s not found: %v", *username, err)
+			}
+		}
+		if *playlistID != 0 {
+			if _, err := database.GetSavedPlaylist(*playlistID); err != nil {
+				log.Fatalf("Playlist %d not found: %v", *playlistID, err)
+			}
+		}
 		newToken, _ := uuid.NewV4()
 		tokenStr := newToken.String()
-		if err := database.AddToken(tokenStr); err != nil {
+		if err := database.AddToken(&db.Token{Token: tokenStr, Username: *username, PlaylistID: *playlistID}); err != nil {
 			log.Fatalf("Failed to generate token: %v", err)
 		}
 		fmt.Println("New token generated successfully:")
 		fmt.Println(tokenStr)
+		fmt.Println("Trigger URLs: /api/trigger/{play,pause,toggle,next,queue}?token=" + tokenStr)
+	case "list":
+		tokens, err := database.GetTokens()
+		if err != nil {
+			log.Fatalf("Failed to list tokens: %v", err)
+		}
+		for _, t := range tokens {
+			user := t.Username
+			if user == "" {
+				user = "(admin)"
+			}
+			fmt.Printf("%s  active=%t  user=%s  playlist=%d  created=%s\n", t.Token, t.IsActive, user, t.PlaylistID, t.CreatedAt.Format(time.DateTime))
+		}
 	case "disable":
 		if *token == "" {
 			log.Fatal("'-token' flag is required for 'disable' action")
@@ -49,7 +75,7 @@ func main() {
 		}
 		fmt.Printf("Token %s has been enabled.\n", *token)
 	default:
-		fmt.Println("Invalid action. Use 'generate', 'disable', or 'enable'.")
+		fmt.Println("Invalid action. Use 'generate', 'list', 'disable', or 'enable'.")
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -8,9 +8,30 @@ const apiClient = axios.create({
   },
 });
 
+const CSRF_COOKIE = 'jukebox_csrf';
+const SAFE_METHODS = ['get', 'head', 'options'];
+
+const readCookie = (name) => {
+  const prefix = `${name}=`;
+  const cookie = document.cookie.split('; ').find((c) => c.startsWith(prefix));
+  return cookie ? decodeURIComponent(cookie.slice(prefix.length)) : null;
+};
+
+// 取得 CSRF 令牌：优先读取 Cookie，还没有时向服务端申请，服务端会同时写入 Cookie
+// 未启用防护时令牌为空，修改请求不带请求头即可
+export async function csrfToken() {
+  const token = readCookie(CSRF_COOKIE);
+  if (token) {
+    return token;
+  }
+  const response = await fetch('/api/csrf');
+  const data = await response.json();
+  return data.token;
+}
+
 // 创建一个请求拦截器
 apiClient.interceptors.request.use(
-    (config) => {
+    async (config) => {
       // 必须在拦截器内部获取存储实例。
       // 在顶层获取可能导致循环依赖。
       const playerStore = usePlayerStore();
@@ -18,6 +39,13 @@ apiClient.interceptors.request.use(
       if (playerStore.isAuthenticated && playerStore.authHeader) {
         config.headers['Authorization'] = playerStore.authHeader;
       }
+      // 修改请求需要带上 CSRF 令牌
+      if (!SAFE_METHODS.includes((config.method || 'get').toLowerCase())) {
+        const token = await csrfToken();
+        if (token) {
+          config.headers['X-CSRF-Token'] = token;
+        }
+      }
 
       return config; // 返回修改后的配置
     },
@@ -28,14 +56,43 @@ apiClient.interceptors.request.use(
 );
 
 export default {
+  // 登出时 store 中的凭证随即被清空，这里显式传入
+  logout(authHeader) {
+    return apiClient.post('/auth/logout', null, {headers: {Authorization: authHeader}});
+  },
+  // 已登录的设备（记住此设备、单点登录）
+  getDevices() {
+    return apiClient.get('/account/devices');
+  },
+  revokeDevice(id) {
+    return apiClient.delete(`/account/devices/${id}`);
+  },
+  // 配对二维码图片，手机扫码后直接登录当前账号，二维码两分钟内有效且只能用一次
+  getPairingQR() {
+    return apiClient.get('/pair/qr', {responseType: 'blob'});
+  },
+  // 本月的媒体流量和每个用户的上限
+  getMyBandwidth() {
+    return apiClient.get('/account/bandwidth');
+  },
+  // 举报有问题的歌曲，由管理员处理
+  reportSong(songId, reason) {
+    return apiClient.post(`/library/${songId}/report`, {reason});
+  },
   validateToken(token) {
     return apiClient.get(`/validate-token?token=${token}`);
   },
   getLibrary() {
     return apiClient.get('/library');
   },
-  uploadSong(formData) {
+  // 自己上传的歌曲，除管理员外只有这些歌曲可以删除和修改
+  getMyUploads() {
+    return apiClient.get('/library/mine');
+  },
+  // uploadId 用于对应 WebSocket 推送的 UPLOAD_PROGRESS 和 JOB_PROGRESS 事件
+  uploadSong(formData, uploadId) {
     return apiClient.post('/library/upload', formData, {
+      params: uploadId ? { uploadId } : undefined,
       headers: {
         'Content-Type': 'multipart/form-data',
       },
@@ -65,11 +122,16 @@ export default {
   playSpecific(songId) {
     return apiClient.post('/player/play-specific', { songId });
   },
+  // 从播放列表的指定位置开始播放
+  playFrom(index) {
+    return apiClient.post('/playlist/play-from', { index });
+  },
   pause() {
     return apiClient.post('/player/pause');
   },
-  next() {
-    return apiClient.post('/player/next');
+  // reason 可选：bad_quality、wrong_vibe 或 duplicate，用于切歌统计
+  next(reason) {
+    return apiClient.post('/player/next', reason ? { reason } : undefined);
   },
   prev() {
     return apiClient.post('/player/prev');
@@ -77,4 +139,36 @@ export default {
   seek(positionMs) {
     return apiClient.post('/player/seek', { positionMs });
   },
+  // 只让指定的输出设备发声，deviceId 为空时所有输出设备一起发声
+  transferPlayback(deviceId) {
+    return apiClient.post('/devices/transfer', { deviceId });
+  },
+  // 扫描局域网内的 AirPlay 音箱，需要 DJ 权限，请求要几秒钟
+  getAirPlaySpeakers() {
+    return apiClient.get('/airplay/speakers');
+  },
+  connectAirPlay(id) {
+    return apiClient.post(`/airplay/speakers/${encodeURIComponent(id)}/connect`);
+  },
+  disconnectAirPlay(id) {
+    return apiClient.post(`/airplay/speakers/${encodeURIComponent(id)}/disconnect`);
+  },
+  // --- 音效板 ---
+  getSoundClips() {
+    return apiClient.get('/soundboard');
+  },
+  // formData 包含 clipFile 和可选的 name，需要 DJ 权限
+  uploadSoundClip(formData) {
+    return apiClient.post('/soundboard', formData, {
+      headers: {
+        'Content-Type': 'multipart/form-data',
+      },
+    });
+  },
+  playSoundClip(id) {
+    return apiClient.post(`/soundboard/${id}/play`);
+  },
+  deleteSoundClip(id) {
+    return apiClient.post(`/soundboard/${id}/delete`);
+  },
 };
@@ -1,26 +1,162 @@
 import { usePlayerStore } from '@/stores/player';
+import { csrfToken } from '@/api';
 
 let socket = null;
+let lastCredentials = null;
+// 本次连接收到的最大事件序号，重连后据此请求补发错过的事件
+let lastSeq = 0;
+// 本次连接已处理的事件序号，补发与实时广播可能重叠
+let seenSeqs = new Set();
+const MAX_SEEN_SEQS = 1000;
+// 最近一次的完整状态，STATE_PATCH 在它的基础上应用
+let currentState = null;
+
+// applyMergePatch 按 JSON Merge Patch（RFC 7396）更新对象，null 表示删除字段
+const applyMergePatch = (target, patch) => {
+  const result = target && typeof target === 'object' && !Array.isArray(target) ? { ...target } : {};
+  for (const [key, value] of Object.entries(patch)) {
+    if (value === null) {
+      delete result[key];
+    } else if (typeof value === 'object' && !Array.isArray(value)) {
+      result[key] = applyMergePatch(result[key], value);
+    } else {
+      result[key] = value;
+    }
+  }
+  return result;
+};
 const WS_URL = '/ws';
+const DEVICE_ID_STORAGE_KEY = 'jukebox_device_id';
+
+// 每个浏览器持久保存一个设备 ID，用于设备角色与定向音量控制
+export const getDeviceId = () => {
+  let deviceId = localStorage.getItem(DEVICE_ID_STORAGE_KEY);
+  if (!deviceId) {
+    deviceId = crypto.randomUUID();
+    localStorage.setItem(DEVICE_ID_STORAGE_KEY, deviceId);
+  }
+  return deviceId;
+};
+
+// fetchTicket 用凭证换取一次性的连接票据，凭证无效时返回 null，以匿名身份连接
+const fetchTicket = async (credentials) => {
+  const response = await fetch('/api/ws/ticket', {
+    method: 'POST',
+    headers: {'Authorization': `Basic ${credentials}`, 'X-CSRF-Token': await csrfToken()},
+  });
+  if (response.status === 401) {
+    return null;
+  }
+  if (!response.ok) {
+    throw new Error(`Failed to get WebSocket ticket: ${response.status}`);
+  }
+  const data = await response.json();
+  return data.ticket;
+};
+
+// 媒体 Cookie 的续期间隔，服务端签发的 Cookie 有效期为 24 小时
+const MEDIA_SESSION_REFRESH_MS = 12 * 60 * 60 * 1000;
+let mediaSessionTimer = null;
+
+// refreshMediaSession 领取媒体 Cookie：<audio> 和 HLS 请求无法带 Authorization 头，服务端据此把播放计入当前用户
+const refreshMediaSession = async (credentials) => {
+  try {
+    await fetch('/api/media/session', {
+      method: 'POST',
+      headers: {'Authorization': `Basic ${credentials}`, 'X-CSRF-Token': await csrfToken()},
+    });
+  } catch (error) {
+    console.error('Failed to refresh media session:', error);
+  }
+};
 
 export const websocketService = {
-  connect() {
+  async connect(credentials) {
     // 防止重复连接
     if (socket && socket.readyState === WebSocket.OPEN) {
       return;
     }
+    // 重连时沿用上一次的凭证
+    if (credentials) {
+      lastCredentials = credentials;
+    }
 
-    socket = new WebSocket(WS_URL);
+    // 浏览器无法为 WebSocket 设置 Authorization 头，凭证又不能放进地址（会被记进访问日志），先换取一次性票据
+    let url = WS_URL;
+    if (lastCredentials) {
+      const requested = lastCredentials;
+      let ticket;
+      try {
+        ticket = await fetchTicket(requested);
+      } catch (error) {
+        console.error(error);
+        setTimeout(() => {
+          this.connect();
+        }, 3000);
+        return;
+      }
+      // 换取票据期间已经登出或换了账号
+      if (lastCredentials !== requested || (socket && socket.readyState <= WebSocket.OPEN)) {
+        return;
+      }
+      if (ticket) {
+        url = `${WS_URL}?ticket=${encodeURIComponent(ticket)}`;
+        refreshMediaSession(requested);
+        clearInterval(mediaSessionTimer);
+        mediaSessionTimer = setInterval(() => refreshMediaSession(requested), MEDIA_SESSION_REFRESH_MS);
+      }
+    }
+    socket = new WebSocket(url);
     const playerStore = usePlayerStore();
 
     socket.onopen = () => {
       console.log('WebSocket connected');
+      // 声明支持增量状态，之后的完整状态以 STATE_PATCH 发送
+      currentState = null;
+      this.send({ type: 'HELLO', capabilities: ['delta'], client: 'web' });
+      // 网页端会播放音频，登记为输出设备
+      this.send({
+        type: 'REGISTER_DEVICE',
+        deviceId: getDeviceId(),
+        name: navigator.userAgent,
+        role: 'output',
+      });
+      // 序号只在同一个服务实例内有效，每次连接重新计数
+      const resumeFrom = lastSeq;
+      lastSeq = 0;
+      seenSeqs = new Set();
+      if (resumeFrom) {
+        this.send({ type: 'RESUME', lastSeq: resumeFrom });
+      }
     };
 
     socket.onmessage = (event) => {
-      const state = JSON.parse(event.data);
+      const message = JSON.parse(event.data);
+      // 带 type 字段的是事件，其余是完整状态
+      if (message.type === 'STATE_PATCH') {
+        if (currentState) {
+          currentState = applyMergePatch(currentState, message.data);
+          playerStore.setGlobalState(currentState);
+        }
+        return;
+      }
+      if (message.type) {
+        if (message.seq) {
+          if (seenSeqs.has(message.seq)) {
+            return;
+          }
+          if (seenSeqs.size >= MAX_SEEN_SEQS) {
+            seenSeqs.clear();
+          }
+          seenSeqs.add(message.seq);
+          lastSeq = Math.max(lastSeq, message.seq);
+        }
+        playerStore.handleEvent(message);
+        return;
+      }
       // 将收到的完整状态交给 Pinia store 处理
-      playerStore.setGlobalState(state);
+      currentState = message;
+      playerStore.setGlobalState(message);
     };
 
     socket.onclose = () => {
@@ -37,7 +173,18 @@ export const websocketService = {
     };
   },
 
+  // 发送上行消息，例如 { type: 'VOTE', songId }
+  send(message) {
+    if (socket && socket.readyState === WebSocket.OPEN) {
+      socket.send(JSON.stringify(message));
+    }
+  },
+
   disconnect() {
+    lastCredentials = null;
+    clearInterval(mediaSessionTimer);
+    mediaSessionTimer = null;
+    lastSeq = 0;
     if (socket) {
       socket.close();
       socket = null;
@@ -1,7 +1,7 @@
 import {defineStore} from 'pinia';
 // api 和 websocketService 的导入保持不变
-import api from '@/api';
-import {websocketService} from '@/services/websocket';
+import api, {csrfToken} from '@/api';
+import {websocketService, getDeviceId} from '@/services/websocket';
 
 const VOLUME_STORAGE_KEY = 'jukebox_volume';
 const AUTH_HEADER_STORAGE_KEY = 'jukebox_auth_header';
@@ -21,18 +21,47 @@ export const usePlayerStore = defineStore('player', {
         currentPlaylistIdx: -1,
         progressMs: 0,
         playMode: 'REPEAT_ALL',
+        playbackRate: 1.0,
+        outputDevices: [],
+        // 转移播放后唯一发声的设备，为 null 时所有输出设备一起发声
+        activeOutputId: null,
+        // 管理员发布的公告，没有时为 null
+        announcement: null,
+        // 维护模式，开启时播放暂停、修改操作会被拒绝
+        maintenance: null,
+        // 只读副本只能收听，点歌和播放控制要到主实例上操作
+        readOnly: false,
+        // 房间设置（交叉淡化时长、歌曲时长和来源限制等），由管理员修改
+        settings: null,
         isAuthenticated: !!localStorage.getItem(AUTH_HEADER_STORAGE_KEY),
         authHeader: localStorage.getItem(AUTH_HEADER_STORAGE_KEY) || null,
         authError: null,
         mediaLibrary: [],
+        // 按 uploadId 记录上传和转码进度：{ phase: 'uploading' | 'queued' | 'transcoding' | 'done' | 'failed' | 'cancelled', percent }
+        uploadProgress: {},
         localVolume: loadInitialVolume(),
         previousVolume: null,
+        remoteVolume: null,
         playbackError: null,
     }),
 
     getters: {
         // ... getters 保持不变 ...
+        // 播放转移到其他设备时本设备继续同步播放但静音，切换回来时无需重新缓冲
+        isAudibleHere: (state) => !state.activeOutputId || state.activeOutputId === getDeviceId(),
         currentSongUrl: (state) => {
+            // 跟随远程实例时，本地没有的歌曲直接播放远程的 HLS
+            if (state.currentSong && state.currentSong.stream_url) {
+                return state.currentSong.stream_url;
+            }
+            // 服务器没有 ffmpeg 时歌曲未转码，直接播放原始文件
+            if (state.currentSong && state.currentSong.direct_url) {
+                return state.currentSong.direct_url;
+            }
+            // 转码后的文件按内容哈希存放，使用服务器给出的地址
+            if (state.currentSong && state.currentSong.hls_url) {
+                return state.currentSong.hls_url;
+            }
             if (state.currentSong && state.currentSong.id) {
                 return `/static/audio/${state.currentSong.id}/index.m3u8`;
             }
@@ -49,6 +78,33 @@ export const usePlayerStore = defineStore('player', {
             this.currentPlaylistIdx = newState.currentPlaylistIdx;
             this.progressMs = newState.progressMs;
             this.playMode = newState.playMode;
+            this.playbackRate = newState.playbackRate || 1.0;
+            this.outputDevices = newState.outputDevices || [];
+            this.activeOutputId = newState.activeOutputId || null;
+            this.announcement = newState.announcement || null;
+            this.maintenance = newState.maintenance || null;
+            this.settings = newState.settings || null;
+            this.readOnly = !!newState.readOnly;
+            // 服务端定向调整了本设备的音量
+            const self = this.outputDevices.find((d) => d.id === getDeviceId());
+            if (self && self.volume !== this.remoteVolume) {
+                // 首次登记时只记录，不覆盖本地保存的音量
+                if (this.remoteVolume !== null) {
+                    this.setLocalVolume(self.volume);
+                }
+                this.remoteVolume = self.volume;
+            }
+        },
+
+        // 处理服务端推送的事件
+        handleEvent(event) {
+            const data = event.data || {};
+            if (event.type === 'UPLOAD_PROGRESS' && this.uploadProgress[data.uploadId]) {
+                const percent = data.totalBytes > 0 ? Math.round(data.receivedBytes * 100 / data.totalBytes) : 0;
+                this.uploadProgress[data.uploadId] = {phase: 'uploading', percent};
+            } else if (event.type === 'JOB_PROGRESS' && this.uploadProgress[data.uploadId]) {
+                this.uploadProgress[data.uploadId] = {phase: data.status, percent: data.progress};
+            }
         },
 
         // --- 认证与连接 ---
@@ -59,7 +115,7 @@ export const usePlayerStore = defineStore('player', {
             try {
                 const response = await fetch('/api/register', {
                     method: 'POST',
-                    headers: {'Content-Type': 'application/json'},
+                    headers: {'Content-Type': 'application/json', 'X-CSRF-Token': await csrfToken()},
                     // 修改: 在请求体中包含 key
                     body: JSON.stringify({username, password, key: invitationKey}),
                 });
@@ -75,20 +131,26 @@ export const usePlayerStore = defineStore('player', {
         },
 
         // loginAndConnect, logout, initializeAuthAndConnect 等其他 actions 保持不变
-        async loginAndConnect(username, password) {
+        // remember 为 true 时服务端为这台设备签发长期令牌，之后保存令牌而不是密码
+        async loginAndConnect(username, password, remember = false) {
             this.authError = null;
-            const credentials = btoa(`${username}:${password}`);
-            const authHeader = `Basic ${credentials}`;
+            let credentials = btoa(`${username}:${password}`);
+            let authHeader = `Basic ${credentials}`;
 
             try {
                 const response = await fetch('/api/login', {
                     method: 'POST',
-                    headers: {'Authorization': authHeader},
+                    headers: {'Authorization': authHeader, 'Content-Type': 'application/json', 'X-CSRF-Token': await csrfToken()},
+                    body: JSON.stringify({rememberDevice: remember}),
                 });
+                const data = await response.json();
                 if (!response.ok) {
-                    const data = await response.json();
                     throw new Error(data.error || 'Authentication failed');
                 }
+                if (data.token) {
+                    credentials = btoa(`${username}:${data.token}`);
+                    authHeader = `Basic ${credentials}`;
+                }
                 this.authHeader = authHeader;
                 this.isAuthenticated = true;
                 localStorage.setItem(AUTH_HEADER_STORAGE_KEY, authHeader);
@@ -101,7 +163,64 @@ export const usePlayerStore = defineStore('player', {
                 return false;
             }
         },
+        // 认证代理模式：代理已经确认了身份，不需要凭证，WebSocket 连接同样由代理带上身份
+        async loginViaProxy() {
+            try {
+                const response = await fetch('/api/auth/proxy');
+                if (!response.ok) {
+                    return false;
+                }
+                this.isAuthenticated = true;
+                websocketService.connect();
+                this.fetchLibrary();
+                return true;
+            } catch (error) {
+                return false;
+            }
+        },
+        // 单点登录：用回调带回的一次性代码换取登录令牌，之后与密码登录一样使用 Basic Auth
+        async loginWithSSO(code) {
+            this.authError = null;
+            try {
+                const response = await fetch('/api/auth/oidc/exchange', {
+                    method: 'POST',
+                    headers: {'Content-Type': 'application/json', 'X-CSRF-Token': await csrfToken()},
+                    body: JSON.stringify({code}),
+                });
+                const data = await response.json();
+                if (!response.ok) {
+                    throw new Error(data.error || 'Single sign-on failed');
+                }
+                return await this.loginAndConnect(data.username, data.token);
+            } catch (error) {
+                this.authError = error.message;
+                return false;
+            }
+        },
+        // 扫描配对二维码打开登录页时，用其中的配对令牌领取凭证
+        async loginWithPairing(token) {
+            this.authError = null;
+            try {
+                const response = await fetch('/api/pair', {
+                    method: 'POST',
+                    headers: {'Content-Type': 'application/json', 'X-CSRF-Token': await csrfToken()},
+                    body: JSON.stringify({token}),
+                });
+                const data = await response.json();
+                if (!response.ok) {
+                    throw new Error(data.error || 'Pairing failed');
+                }
+                return await this.loginAndConnect(data.username, data.token);
+            } catch (error) {
+                this.authError = error.message;
+                return false;
+            }
+        },
         logout() {
+            // 登录令牌需要在服务端注销，密码登录时服务端什么也不做
+            if (this.authHeader) {
+                api.logout(this.authHeader).catch(() => {});
+            }
             this.isAuthenticated = false;
             this.authHeader = null;
             this.authError = null;
@@ -151,6 +270,13 @@ export const usePlayerStore = defineStore('player', {
         seekTo(positionMs) {
             api.seek(positionMs);
         },
+        async transferPlayback(deviceId) {
+            try {
+                await api.transferPlayback(deviceId);
+            } catch (error) {
+                console.error('Failed to transfer playback:', error);
+            }
+        },
         async addToPlaylist(songId) {
             try {
                 await api.addToPlaylist(songId);
@@ -187,14 +313,21 @@ export const usePlayerStore = defineStore('player', {
                 console.error('Failed to fetch library:', error);
             }
         },
-        async uploadSong(file) {
+        async uploadSong(file, uploadId) {
             const formData = new FormData();
             formData.append('audioFile', file);
+            if (uploadId) {
+                this.uploadProgress[uploadId] = {phase: 'uploading', percent: 0};
+            }
             try {
-                await api.uploadSong(formData);
+                await api.uploadSong(formData, uploadId);
             } catch (error) {
                 console.error('Failed to upload song:', error);
                 throw error;
+            } finally {
+                if (uploadId) {
+                    delete this.uploadProgress[uploadId];
+                }
             }
         },
         async removeSongFromLibrary(songId) {
@@ -202,6 +335,7 @@ export const usePlayerStore = defineStore('player', {
                 await api.removeSong(songId);
             } catch (error) {
                 console.error('Failed to remove song:', error);
+                throw error;
             }
         },
         setLocalVolume(newVolume) {
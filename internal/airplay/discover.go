@@ -0,0 +1,72 @@
+package airplay
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yeeeck/sync-jukebox/internal/mdns"
+)
+
+// raopService AirPlay 音频接收端在 mDNS 中的服务类型
+const raopService = "_raop._tcp"
+
+// Speaker 一个通过 mDNS 发现的 AirPlay 音箱
+type Speaker struct {
+	// ID 实例名中 @ 之前的设备 MAC 地址（小写），重启后不变
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Host  string `json:"host"`
+	Port  int    `json:"port"`
+	Model string `json:"model,omitempty"`
+	// Supported 接收端接受不加密的音频流（TXT 中 et 含 0），本实现只能推送到这类接收端
+	Supported bool `json:"supported"`
+}
+
+// Addr 音箱的 RTSP 地址
+func (s Speaker) Addr() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// Discover 在局域网内查找 AirPlay 音箱，直到 ctx 结束，按名字排序
+func Discover(ctx context.Context) ([]Speaker, error) {
+	services, err := mdns.Browse(ctx, raopService)
+	if err != nil {
+		return nil, err
+	}
+	speakers := make([]Speaker, 0, len(services))
+	for _, service := range services {
+		if len(service.Addrs) == 0 {
+			continue
+		}
+		id, name, ok := strings.Cut(service.Instance, "@")
+		if !ok {
+			id, name = service.Instance, service.Instance
+		}
+		speakers = append(speakers, Speaker{
+			ID:        strings.ToLower(id),
+			Name:      name,
+			Host:      service.Addrs[0].String(),
+			Port:      service.Port,
+			Model:     service.Text["am"],
+			Supported: acceptsUnencrypted(service.Text["et"]),
+		})
+	}
+	sort.Slice(speakers, func(i, j int) bool { return speakers[i].Name < speakers[j].Name })
+	return speakers, nil
+}
+
+// acceptsUnencrypted 判断 TXT 中的加密方式列表（例如 "0,3,5"）是否包含不加密；没有该字段时视为支持
+func acceptsUnencrypted(et string) bool {
+	if et == "" {
+		return true
+	}
+	for _, v := range strings.Split(et, ",") {
+		if strings.TrimSpace(v) == "0" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,390 @@
+// Package airplay 把音频推送到 AirPlay（RAOP）音箱：通过 mDNS 发现音箱，用 RTSP 建立会话，
+// 以 RTP 发送不压缩的 ALAC 帧，并回应接收端的时钟同步请求
+// 只实现不加密的 AirPlay 1 音频流，需要配对或加密的接收端（HomePod、老款 AirPort Express）不支持
+package airplay
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 音频格式：44.1 kHz 16 位立体声，每个 RTP 包 352 个采样帧
+const (
+	SampleRate      = 44100
+	Channels        = 2
+	FramesPerPacket = 352
+	// BytesPerFrame 一个采样帧（左右声道各一个 16 位采样）的字节数
+	BytesPerFrame = 4
+)
+
+const (
+	// defaultLatency 接收端没有在 RECORD 响应中给出 Audio-Latency 时的缓冲延迟（采样帧）
+	defaultLatency = 11025
+	// streamLead 音频包比播放时间提前发送的量，吸收网络抖动
+	streamLead = 500 * time.Millisecond
+	// syncInterval 发送时间同步包的间隔
+	syncInterval = time.Second
+	// rtspTimeout 一次 RTSP 请求的超时
+	rtspTimeout = 10 * time.Second
+	// userAgent 部分接收端只接受 iTunes 的请求
+	userAgent = "iTunes/7.6.2 (Windows; N;)"
+	// ntpEpochOffset NTP 纪元（1900 年）到 Unix 纪元的秒数
+	ntpEpochOffset = 2208988800
+	// minVolumeDb AirPlay 音量的范围是 -30 到 0 dB，-144 表示静音
+	minVolumeDb  = -30.0
+	muteVolumeDb = -144.0
+)
+
+// RTP 负载类型
+const (
+	rtpAudio         = 0x60
+	rtpSync          = 0x54
+	rtpTimingRequest = 0x52
+	rtpTimingReply   = 0x53
+	rtpMarker        = 0x80
+)
+
+// Client 与一个 AirPlay 接收端的会话
+// RTSP 请求可以并发调用；Stream 同时只能有一个在运行，Flush 应在 Stream 返回后调用
+type Client struct {
+	mu             sync.Mutex
+	conn           net.Conn
+	reader         *textproto.Reader
+	cseq           int
+	url            string
+	session        string
+	clientInstance string
+
+	audio         *net.UDPConn
+	control       *net.UDPConn
+	timing        *net.UDPConn
+	remoteControl *net.UDPAddr
+	latency       uint32
+
+	streamMu sync.Mutex
+	seq      uint16
+	rtpTime  uint32
+	ssrc     uint32
+	// first 连接或清空缓冲后的第一个音频包和同步包需要带标记
+	first bool
+
+	closeOnce sync.Once
+}
+
+// Dial 连接接收端并建立播放会话，之后可以调用 Stream 发送音频
+func Dial(ctx context.Context, addr string) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	localIP := conn.LocalAddr().(*net.TCPAddr).IP
+	remoteIP := conn.RemoteAddr().(*net.TCPAddr).IP
+	sessionID := rand.Uint32()
+	instance := make([]byte, 8)
+	binary.BigEndian.PutUint64(instance, rand.Uint64())
+	c := &Client{
+		conn:           conn,
+		reader:         textproto.NewReader(bufio.NewReader(conn)),
+		url:            fmt.Sprintf("rtsp://%s/%d", localIP, sessionID),
+		clientInstance: strings.ToUpper(hex.EncodeToString(instance)),
+		latency:        defaultLatency,
+		seq:            uint16(rand.Uint32()),
+		rtpTime:        rand.Uint32(),
+		ssrc:           rand.Uint32(),
+		first:          true,
+	}
+	if err := c.setup(localIP, remoteIP, sessionID); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// setup 依次发送 OPTIONS、ANNOUNCE、SETUP 和 RECORD
+func (c *Client) setup(localIP, remoteIP net.IP, sessionID uint32) error {
+	var err error
+	if c.control, err = net.ListenUDP("udp4", &net.UDPAddr{IP: localIP}); err != nil {
+		return err
+	}
+	if c.timing, err = net.ListenUDP("udp4", &net.UDPAddr{IP: localIP}); err != nil {
+		return err
+	}
+	go c.serveTiming()
+
+	if _, err := c.request("OPTIONS", "*", nil, "", nil); err != nil {
+		return err
+	}
+	sdp := fmt.Sprintf("v=0\r\n"+
+		"o=iTunes %d 0 IN IP4 %s\r\n"+
+		"s=iTunes\r\n"+
+		"c=IN IP4 %s\r\n"+
+		"t=0 0\r\n"+
+		"m=audio 0 RTP/AVP 96\r\n"+
+		"a=rtpmap:96 AppleLossless\r\n"+
+		"a=fmtp:96 %d 0 16 40 10 14 %d 255 0 0 %d\r\n",
+		sessionID, localIP, remoteIP, FramesPerPacket, Channels, SampleRate)
+	if _, err := c.request("ANNOUNCE", c.url, nil, "application/sdp", []byte(sdp)); err != nil {
+		return err
+	}
+
+	transport := fmt.Sprintf("RTP/AVP/UDP;unicast;interleaved=0-1;mode=record;control_port=%d;timing_port=%d",
+		c.control.LocalAddr().(*net.UDPAddr).Port, c.timing.LocalAddr().(*net.UDPAddr).Port)
+	resp, err := c.request("SETUP", c.url, [][2]string{{"Transport", transport}}, "", nil)
+	if err != nil {
+		return err
+	}
+	session, _, _ := strings.Cut(resp.Get("Session"), ";")
+	c.session = strings.TrimSpace(session)
+	ports := transportPorts(resp.Get("Transport"))
+	if ports["server_port"] == 0 {
+		return errors.New("receiver did not return an audio port")
+	}
+	if c.audio, err = net.DialUDP("udp4", nil, &net.UDPAddr{IP: remoteIP, Port: ports["server_port"]}); err != nil {
+		return err
+	}
+	if port := ports["control_port"]; port != 0 {
+		c.remoteControl = &net.UDPAddr{IP: remoteIP, Port: port}
+	}
+
+	resp, err = c.request("RECORD", c.url, [][2]string{{"Range", "npt=0-"}, {"RTP-Info", c.rtpInfo()}}, "", nil)
+	if err != nil {
+		return err
+	}
+	if latency, err := strconv.ParseUint(resp.Get("Audio-Latency"), 10, 32); err == nil && latency > 0 {
+		c.latency = uint32(latency)
+	}
+	return nil
+}
+
+// request 发送一个 RTSP 请求并读取响应头，非 200 的响应作为错误返回
+func (c *Client) request(method, uri string, headers [][2]string, contentType string, body []byte) (textproto.MIMEHeader, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cseq++
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s RTSP/1.0\r\n", method, uri)
+	fmt.Fprintf(&b, "CSeq: %d\r\n", c.cseq)
+	fmt.Fprintf(&b, "User-Agent: %s\r\n", userAgent)
+	fmt.Fprintf(&b, "Client-Instance: %s\r\n", c.clientInstance)
+	if c.session != "" {
+		fmt.Fprintf(&b, "Session: %s\r\n", c.session)
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&b, "%s: %s\r\n", h[0], h[1])
+	}
+	if len(body) > 0 {
+		fmt.Fprintf(&b, "Content-Type: %s\r\nContent-Length: %d\r\n", contentType, len(body))
+	}
+	b.WriteString("\r\n")
+	b.Write(body)
+
+	c.conn.SetDeadline(time.Now().Add(rtspTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, err
+	}
+	status, err := c.reader.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	header, err := c.reader.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := strconv.Atoi(header.Get("Content-Length")); n > 0 {
+		if _, err := io.CopyN(io.Discard, c.reader.R, int64(n)); err != nil {
+			return nil, err
+		}
+	}
+	if fields := strings.Fields(status); len(fields) < 2 || fields[1] != "200" {
+		return nil, fmt.Errorf("%s failed: %s", method, status)
+	}
+	return header, nil
+}
+
+// transportPorts 解析 SETUP 响应中 Transport 头里的端口
+func transportPorts(transport string) map[string]int {
+	ports := make(map[string]int)
+	for _, part := range strings.Split(transport, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || !strings.HasSuffix(key, "_port") {
+			continue
+		}
+		if port, err := strconv.Atoi(value); err == nil {
+			ports[key] = port
+		}
+	}
+	return ports
+}
+
+func (c *Client) rtpInfo() string {
+	return fmt.Sprintf("seq=%d;rtptime=%d", c.seq, c.rtpTime)
+}
+
+// SetVolume 设置接收端的音量，volume 范围 0.0 - 1.0，0 为静音
+func (c *Client) SetVolume(volume float64) error {
+	gain := muteVolumeDb
+	if volume > 0 {
+		gain = minVolumeDb * (1 - min(volume, 1))
+	}
+	body := fmt.Sprintf("volume: %.6f\r\n", gain)
+	_, err := c.request("SET_PARAMETER", c.url, nil, "text/parameters", []byte(body))
+	return err
+}
+
+// Flush 丢弃接收端缓冲中还没播放的音频，用于暂停、切歌和跳转
+func (c *Client) Flush() error {
+	c.streamMu.Lock()
+	defer c.streamMu.Unlock()
+	c.first = true
+	_, err := c.request("FLUSH", c.url, [][2]string{{"RTP-Info", c.rtpInfo()}}, "", nil)
+	return err
+}
+
+// Stream 按实时的速度发送 16 位小端立体声 PCM，直到 pcm 读完或 ctx 结束
+func (c *Client) Stream(ctx context.Context, pcm io.Reader) error {
+	c.streamMu.Lock()
+	defer c.streamMu.Unlock()
+
+	buf := make([]byte, FramesPerPacket*BytesPerFrame)
+	start := time.Now()
+	startRTP := c.rtpTime
+	var frames int64
+	var nextSync time.Time
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		n, err := io.ReadFull(pcm, buf)
+		if n == 0 {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		// 最后一个不完整的包用静音补齐
+		clear(buf[n:])
+
+		due := start.Add(framesDuration(frames) - streamLead)
+		if wait := time.Until(due); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+			}
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		now := time.Now()
+		if !now.Before(nextSync) {
+			elapsed := uint32(now.Sub(start) * SampleRate / time.Second)
+			c.sendSync(startRTP+elapsed, now)
+			nextSync = now.Add(syncInterval)
+		}
+		if err := c.sendAudio(encodeALAC(buf)); err != nil {
+			return err
+		}
+		frames += FramesPerPacket
+	}
+}
+
+func framesDuration(frames int64) time.Duration {
+	return time.Duration(frames) * time.Second / SampleRate
+}
+
+// sendAudio 发送一个音频包，调用方需持有 streamMu
+func (c *Client) sendAudio(frame []byte) error {
+	packet := make([]byte, 12+len(frame))
+	packet[0] = 0x80
+	packet[1] = rtpAudio
+	if c.first {
+		packet[1] |= rtpMarker
+	}
+	binary.BigEndian.PutUint16(packet[2:], c.seq)
+	binary.BigEndian.PutUint32(packet[4:], c.rtpTime)
+	binary.BigEndian.PutUint32(packet[8:], c.ssrc)
+	copy(packet[12:], frame)
+	c.seq++
+	c.rtpTime += FramesPerPacket
+	c.first = false
+	_, err := c.audio.Write(packet)
+	return err
+}
+
+// sendSync 告诉接收端 now 时刻应当播放到的位置，接收端据此对齐时钟，调用方需持有 streamMu
+func (c *Client) sendSync(rtpNow uint32, now time.Time) {
+	if c.remoteControl == nil {
+		return
+	}
+	packet := make([]byte, 20)
+	packet[0] = 0x80
+	if c.first {
+		packet[0] = 0x90
+	}
+	packet[1] = rtpMarker | rtpSync
+	binary.BigEndian.PutUint16(packet[2:], 7)
+	binary.BigEndian.PutUint32(packet[4:], rtpNow-c.latency)
+	putNTP(packet[8:], now)
+	binary.BigEndian.PutUint32(packet[16:], rtpNow)
+	c.control.WriteToUDP(packet, c.remoteControl)
+}
+
+// serveTiming 回应接收端的时钟同步请求，直到连接关闭
+func (c *Client) serveTiming() {
+	buf := make([]byte, 128)
+	for {
+		n, addr, err := c.timing.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		received := time.Now()
+		if n < 32 || buf[1]&^rtpMarker != rtpTimingRequest {
+			continue
+		}
+		reply := make([]byte, 32)
+		reply[0] = 0x80
+		reply[1] = rtpMarker | rtpTimingReply
+		binary.BigEndian.PutUint16(reply[2:], 7)
+		// 原始时间戳是请求中的发送时间
+		copy(reply[8:16], buf[24:32])
+		putNTP(reply[16:], received)
+		putNTP(reply[24:], time.Now())
+		c.timing.WriteToUDP(reply, addr)
+	}
+}
+
+// putNTP 以 NTP 格式（自 1900 年的秒数和 2^-32 秒的小数部分）写入时间
+func putNTP(b []byte, t time.Time) {
+	binary.BigEndian.PutUint32(b, uint32(t.Unix()+ntpEpochOffset))
+	binary.BigEndian.PutUint32(b[4:], uint32(uint64(t.Nanosecond())<<32/uint64(time.Second)))
+}
+
+// Close 结束会话并关闭连接，可以重复调用
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		if c.session != "" {
+			c.request("TEARDOWN", c.url, nil, "", nil)
+		}
+		c.conn.Close()
+		for _, conn := range []*net.UDPConn{c.audio, c.control, c.timing} {
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	})
+	return nil
+}
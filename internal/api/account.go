@@ -0,0 +1,144 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"gorm.io/gorm"
+)
+
+// AccountExport 用户可以下载的个人数据：账号信息、偏好设置、上传的歌曲、点歌的播放记录和创建的歌单
+type AccountExport struct {
+	ExportedAt  time.Time          `json:"exportedAt"`
+	Username    string             `json:"username"`
+	Role        string             `json:"role"`
+	CreatedAt   time.Time          `json:"createdAt"`
+	Preferences AccountPreferences `json:"preferences"`
+	Uploads     []db.Song          `json:"uploads"`
+	History     []AccountPlay      `json:"history"`
+	Playlists   []db.SavedPlaylist `json:"playlists"`
+	Comments    []db.SongComment   `json:"comments"`
+}
+
+// AccountPreferences 用户的偏好设置
+type AccountPreferences struct {
+	Language string `json:"language"`
+}
+
+// AccountPlay 一次由该用户点播的播放，歌曲已被永久删除时只有 SongID
+type AccountPlay struct {
+	SongID    string    `json:"songId"`
+	Title     string    `json:"title,omitempty"`
+	Artist    string    `json:"artist,omitempty"`
+	PlayedAt  time.Time `json:"playedAt"`
+	SessionID *uint     `json:"sessionId,omitempty"`
+}
+
+// handleAccountExport 以 JSON 附件返回当前用户的个人数据
+func (a *API) handleAccountExport(c *gin.Context) {
+	user, err := a.db.GetUserByUsername(c.GetString("username"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	export := AccountExport{
+		ExportedAt:  time.Now(),
+		Username:    user.Username,
+		Role:        user.Role,
+		CreatedAt:   user.CreatedAt,
+		Preferences: AccountPreferences{Language: user.Language},
+		History:     []AccountPlay{},
+	}
+	if export.Uploads, err = a.db.GetSongsUploadedBy(user.Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export account"})
+		return
+	}
+	if export.Playlists, err = a.db.GetSavedPlaylistsCreatedBy(user.Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export account"})
+		return
+	}
+	if export.Comments, err = a.db.GetCommentsByAuthor(user.Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export account"})
+		return
+	}
+	history, err := a.db.GetPlayHistoryRequestedBy(user.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export account"})
+		return
+	}
+	titles := make(map[string]*db.Song)
+	for _, h := range history {
+		song, ok := titles[h.SongID]
+		if !ok {
+			// 回收站中的歌曲 GetSong 查不到，只记录 ID
+			song, _ = a.db.GetSong(h.SongID)
+			titles[h.SongID] = song
+		}
+		play := AccountPlay{SongID: h.SongID, PlayedAt: h.PlayedAt, SessionID: h.SessionID}
+		if song != nil {
+			play.Title, play.Artist = song.Title, song.Artist
+		}
+		export.History = append(export.History, play)
+	}
+	filename := "jukebox-account-" + export.ExportedAt.Format("20060102") + ".json"
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, export)
+}
+
+// handleDeleteAccount 删除当前用户，播放历史等记录中的用户名被清空；?deleteUploads=true 时同时把上传的歌曲移入回收站
+func (a *API) handleDeleteAccount(c *gin.Context) {
+	username := c.GetString("username")
+	deleteUploads, _ := strconv.ParseBool(c.Query("deleteUploads"))
+	user, err := a.db.GetUserByUsername(username)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	// 先检查，避免唯一的管理员在上传被删除之后才发现账号删不掉
+	if user.IsAdmin() {
+		if admins, err := a.db.CountAdmins(); err != nil || admins <= 1 {
+			c.JSON(http.StatusConflict, gin.H{"error": "The last admin account cannot be deleted"})
+			return
+		}
+	}
+
+	removed := 0
+	if deleteUploads {
+		uploads, err := a.db.GetSongsUploadedBy(username)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+			return
+		}
+		ids := make([]string, 0, len(uploads))
+		for _, song := range uploads {
+			ids = append(ids, song.ID)
+		}
+		if len(ids) > 0 {
+			if removed, err = a.state.RemoveSongsFromLibrary(ids); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+				return
+			}
+		}
+	}
+	a.state.ForgetRequester(username)
+	if err := a.db.DeleteUser(username); err != nil {
+		switch {
+		case errors.Is(err, db.ErrLastAdmin):
+			c.JSON(http.StatusConflict, gin.H{"error": "The last admin account cannot be deleted"})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		}
+		return
+	}
+	log.Printf("Action: Deleted account %s (%d uploads moved to trash)", username, removed)
+	c.JSON(http.StatusOK, gin.H{"removedUploads": removed})
+}
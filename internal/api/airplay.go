@@ -0,0 +1,359 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/airplay"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/state"
+)
+
+const (
+	// airplayDiscoveryTimeout 搜索音箱的时长，音箱通常在一秒内应答
+	airplayDiscoveryTimeout = 3 * time.Second
+	// airplayDialTimeout 连接音箱并建立会话的超时
+	airplayDialTimeout = 10 * time.Second
+	// airplayDevicePrefix 音箱登记为输出设备时的设备 ID 和连接 ID 前缀
+	airplayDevicePrefix = "airplay:"
+	// airplayResyncThreshold 音箱的进度与共享进度相差超过该值时重新定位，与网页播放器的阈值一致
+	airplayResyncThreshold = 2000
+)
+
+// AirPlaySpeaker 音箱及其连接状态
+type AirPlaySpeaker struct {
+	airplay.Speaker
+	Connected bool `json:"connected"`
+}
+
+// airplayOutputs 最近一次搜索到的音箱和已连接的音箱
+// 连接的音箱登记为输出设备，播放、暂停、跳转、音量和转移播放都跟随共享状态
+type airplayOutputs struct {
+	mu         sync.Mutex
+	discovered map[string]airplay.Speaker
+	sessions   map[string]*airplaySession
+}
+
+func newAirPlayOutputs() *airplayOutputs {
+	return &airplayOutputs{
+		discovered: make(map[string]airplay.Speaker),
+		sessions:   make(map[string]*airplaySession),
+	}
+}
+
+// airplaySession 一个已连接的音箱，run 在单独的协程中跟随状态推送音频
+type airplaySession struct {
+	speaker airplay.Speaker
+	client  *airplay.Client
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func (s *airplaySession) deviceID() string {
+	return airplayDevicePrefix + s.speaker.ID
+}
+
+// handleGetAirPlaySpeakers 搜索局域网内的 AirPlay 音箱，已连接的音箱即使这次没有应答也会列出
+func (a *API) handleGetAirPlaySpeakers(c *gin.Context) {
+	if a.airplay == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "AirPlay output is not enabled"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), airplayDiscoveryTimeout)
+	defer cancel()
+	found, err := airplay.Discover(ctx)
+	if err != nil {
+		log.Printf("AirPlay discovery failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search for AirPlay speakers"})
+		return
+	}
+
+	outputs := a.airplay
+	outputs.mu.Lock()
+	defer outputs.mu.Unlock()
+	speakers := make([]AirPlaySpeaker, 0, len(found))
+	for _, speaker := range found {
+		outputs.discovered[speaker.ID] = speaker
+		_, connected := outputs.sessions[speaker.ID]
+		speakers = append(speakers, AirPlaySpeaker{Speaker: speaker, Connected: connected})
+	}
+	for id, session := range outputs.sessions {
+		if !containsSpeaker(found, id) {
+			speakers = append(speakers, AirPlaySpeaker{Speaker: session.speaker, Connected: true})
+		}
+	}
+	c.JSON(http.StatusOK, speakers)
+}
+
+func containsSpeaker(speakers []airplay.Speaker, id string) bool {
+	for _, speaker := range speakers {
+		if speaker.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// handleConnectAirPlay 连接一个搜索到的音箱，之后它作为输出设备跟随播放
+func (a *API) handleConnectAirPlay(c *gin.Context) {
+	if a.airplay == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "AirPlay output is not enabled"})
+		return
+	}
+	if !a.ffmpeg {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "AirPlay output requires ffmpeg"})
+		return
+	}
+	id := c.Param("id")
+	outputs := a.airplay
+	outputs.mu.Lock()
+	speaker, found := outputs.discovered[id]
+	session, connected := outputs.sessions[id]
+	outputs.mu.Unlock()
+	if connected {
+		c.JSON(http.StatusOK, AirPlaySpeaker{Speaker: session.speaker, Connected: true})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Speaker not found, search for speakers first"})
+		return
+	}
+	if !speaker.Supported {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This speaker requires an encrypted AirPlay stream, which is not supported"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), airplayDialTimeout)
+	client, err := airplay.Dial(ctx, speaker.Addr())
+	cancel()
+	if err != nil {
+		log.Printf("Failed to connect to AirPlay speaker %s (%s): %v", speaker.Name, speaker.Addr(), err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to connect to speaker"})
+		return
+	}
+	runCtx, stop := context.WithCancel(context.Background())
+	session = &airplaySession{speaker: speaker, client: client, cancel: stop, done: make(chan struct{})}
+
+	outputs.mu.Lock()
+	if _, exists := outputs.sessions[id]; exists {
+		// 并发的连接请求已经先完成
+		outputs.mu.Unlock()
+		stop()
+		client.Close()
+		c.JSON(http.StatusOK, AirPlaySpeaker{Speaker: speaker, Connected: true})
+		return
+	}
+	outputs.sessions[id] = session
+	outputs.mu.Unlock()
+
+	if err := a.state.RegisterDevice(session.deviceID(), session.deviceID(), speaker.Name, state.DeviceOutput, c.GetString("username")); err != nil {
+		a.disconnectAirPlay(id)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to speaker"})
+		return
+	}
+	go a.runAirPlay(runCtx, session)
+	log.Printf("Action: %s connected AirPlay speaker %s", c.GetString("username"), speaker.Name)
+	c.JSON(http.StatusOK, AirPlaySpeaker{Speaker: speaker, Connected: true})
+}
+
+// handleDisconnectAirPlay 断开一个音箱
+func (a *API) handleDisconnectAirPlay(c *gin.Context) {
+	if a.airplay == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "AirPlay output is not enabled"})
+		return
+	}
+	if !a.disconnectAirPlay(c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Speaker is not connected"})
+		return
+	}
+	log.Printf("Action: %s disconnected AirPlay speaker %s", c.GetString("username"), c.Param("id"))
+	c.Status(http.StatusNoContent)
+}
+
+// disconnectAirPlay 停止推送、结束会话并注销输出设备，音箱没有连接时返回 false
+func (a *API) disconnectAirPlay(id string) bool {
+	outputs := a.airplay
+	outputs.mu.Lock()
+	session, ok := outputs.sessions[id]
+	delete(outputs.sessions, id)
+	outputs.mu.Unlock()
+	if !ok {
+		return false
+	}
+	session.cancel()
+	<-session.done
+	session.client.Close()
+	a.state.DisconnectDevices(session.deviceID())
+	return true
+}
+
+// runAirPlay 跟随共享状态向音箱推送音频，直到会话被断开或音箱不再响应
+func (a *API) runAirPlay(ctx context.Context, session *airplaySession) {
+	updates, unsubscribe := a.state.Subscribe()
+	defer unsubscribe()
+	player := &airplayPlayer{a: a, session: session, volume: -1}
+	err := player.apply(a.state.Snapshot())
+	for err == nil {
+		select {
+		case <-ctx.Done():
+			player.stop()
+			close(session.done)
+			return
+		case snapshot, ok := <-updates:
+			if ok {
+				err = player.apply(snapshot)
+			}
+		case streamErr := <-player.ended:
+			player.finished(streamErr)
+		}
+	}
+	player.stop()
+	close(session.done)
+	log.Printf("AirPlay speaker %s stopped responding, disconnecting: %v", session.speaker.Name, err)
+	go a.disconnectAirPlay(session.speaker.ID)
+}
+
+// airplayPlayer 一个音箱的播放进度，只在 runAirPlay 的协程中使用
+type airplayPlayer struct {
+	a       *API
+	session *airplaySession
+	volume  float64
+
+	// 正在推送的歌曲：从 startMs 开始、startedAt 时刻开始推送，rate 为推送时的播放速度
+	songID    string
+	startMs   int64
+	startedAt time.Time
+	rate      float64
+	cancel    context.CancelFunc
+	ended     chan error
+	// doneSongID 已经推送完的歌曲，状态切到下一首之前不重复推送
+	doneSongID string
+}
+
+// apply 让音箱跟上最新的状态，RTSP 请求失败（音箱关机或离线）时返回错误
+func (p *airplayPlayer) apply(snapshot *state.Snapshot) error {
+	client := p.session.client
+	deviceID := p.session.deviceID()
+	volume := -1.0
+	for _, device := range snapshot.OutputDevices {
+		if device.ID == deviceID {
+			volume = device.Volume
+		}
+	}
+	// 播放转移到了其他设备
+	if snapshot.ActiveOutputID != "" && snapshot.ActiveOutputID != deviceID {
+		volume = 0
+	}
+	if volume >= 0 && volume != p.volume {
+		if err := client.SetVolume(volume); err != nil {
+			return err
+		}
+		p.volume = volume
+	}
+
+	song := snapshot.CurrentSong
+	if !snapshot.IsPlaying || song == nil {
+		if p.cancel != nil {
+			p.stop()
+			return client.Flush()
+		}
+		return nil
+	}
+	if p.cancel != nil {
+		expected := p.startMs + int64(float64(time.Since(p.startedAt).Milliseconds())*p.rate)
+		drift := expected - snapshot.ProgressMs
+		if p.songID == song.ID && p.rate == snapshot.PlaybackRate && drift > -airplayResyncThreshold && drift < airplayResyncThreshold {
+			return nil
+		}
+		p.stop()
+		if err := client.Flush(); err != nil {
+			return err
+		}
+	} else if p.doneSongID == song.ID {
+		return nil
+	}
+	p.start(song, snapshot.ProgressMs, snapshot.PlaybackRate)
+	return nil
+}
+
+// start 从 positionMs 开始解码并推送歌曲
+func (p *airplayPlayer) start(song *db.Song, positionMs int64, rate float64) {
+	input := p.a.mediaInput(song)
+	if input == "" {
+		input = song.StreamURL
+	}
+	if input == "" {
+		log.Printf("AirPlay: song %s has no playable source", song.ID)
+		p.doneSongID = song.ID
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := pcmDecoder(ctx, input, positionMs, rate)
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		cancel()
+		log.Printf("AirPlay: failed to decode song %s: %v", song.ID, err)
+		p.doneSongID = song.ID
+		return
+	}
+	ended := make(chan error, 1)
+	go func() {
+		err := p.session.client.Stream(ctx, stdout)
+		cancel()
+		cmd.Wait()
+		ended <- err
+	}()
+	p.songID, p.startMs, p.startedAt, p.rate = song.ID, positionMs, time.Now(), rate
+	p.cancel, p.ended, p.doneSongID = cancel, ended, ""
+}
+
+// stop 停止当前的推送并等待其结束
+func (p *airplayPlayer) stop() {
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+	<-p.ended
+	p.cancel, p.ended = nil, nil
+}
+
+// finished 歌曲推送完毕（或解码失败），状态切到下一首时再开始推送
+func (p *airplayPlayer) finished(err error) {
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("AirPlay: streaming song %s to %s failed: %v", p.songID, p.session.speaker.Name, err)
+	}
+	p.doneSongID = p.songID
+	p.cancel, p.ended = nil, nil
+}
+
+// pcmDecoder 用 ffmpeg 从 positionMs 开始把歌曲解码为 AirPlay 需要的 PCM，非原速播放时用 atempo 变速不变调
+func pcmDecoder(ctx context.Context, input string, positionMs int64, rate float64) *exec.Cmd {
+	args := []string{
+		"-nostdin",
+		"-loglevel", "error",
+		"-ss", strconv.FormatFloat(float64(positionMs)/1000, 'f', 3, 64),
+		"-i", input,
+		"-vn",
+	}
+	if rate > 0 && rate != 1 {
+		args = append(args, "-af", fmt.Sprintf("atempo=%.3f", rate))
+	}
+	args = append(args,
+		"-f", "s16le",
+		"-ar", strconv.Itoa(airplay.SampleRate),
+		"-ac", strconv.Itoa(airplay.Channels),
+		"pipe:1",
+	)
+	return exec.CommandContext(ctx, "ffmpeg", args...)
+}
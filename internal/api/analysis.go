@@ -0,0 +1,281 @@
+package api
+
+import (
+	"cmp"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"gorm.io/gorm"
+)
+
+const (
+	// 外部分析任务的默认租期和派发次数，见 config.AnalysisConfig
+	defaultAnalysisLease       = 600 * time.Second
+	defaultAnalysisMaxAttempts = 3
+	// maxAnalysisLease 一次最多领取的任务数
+	maxAnalysisLease = 20
+	// analysisFailedShown 管理页面显示的最近失败任务数
+	analysisFailedShown = 50
+)
+
+// AnalysisTaskInfo worker 领取到的一个任务：歌曲的基本信息和下载地址
+type AnalysisTaskInfo struct {
+	ID         uint   `json:"id"`
+	SongID     string `json:"songId"`
+	Title      string `json:"title"`
+	Artist     string `json:"artist"`
+	Album      string `json:"album"`
+	DurationMs int    `json:"durationMs"`
+	// MediaURL 歌曲的原始文件、HLS 索引或远程地址，/static/audio 无需认证；开启 bandwidth.requireUser 时下载也要带上 worker 令牌
+	MediaURL string `json:"mediaUrl"`
+}
+
+// AnalysisLease 一次领取的结果，worker 需要在 LeaseSeconds 内回传结果，否则任务会重新派发
+type AnalysisLease struct {
+	Tasks        []AnalysisTaskInfo `json:"tasks"`
+	LeaseSeconds int                `json:"leaseSeconds"`
+}
+
+// AnalysisStatus 管理页面显示的分析队列状态
+type AnalysisStatus struct {
+	Enabled bool              `json:"enabled"`
+	Counts  map[string]int64  `json:"counts"`
+	Failed  []db.AnalysisTask `json:"failed"`
+}
+
+// analysisEnabled 配置了 worker 令牌时启用外部分析
+func (a *API) analysisEnabled() bool {
+	return a.analysisCfg.WorkerToken != ""
+}
+
+// analysisLease 已补齐默认值的租期
+func (a *API) analysisLease() time.Duration {
+	return cmp.Or(time.Duration(a.analysisCfg.LeaseSeconds)*time.Second, defaultAnalysisLease)
+}
+
+func (a *API) analysisMaxAttempts() int {
+	return cmp.Or(a.analysisCfg.MaxAttempts, defaultAnalysisMaxAttempts)
+}
+
+// AnalysisWorkerMiddleware 检查 worker 在 Authorization: Bearer 中携带的令牌，未配置令牌时外部分析关闭
+func (a *API) AnalysisWorkerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.analysisEnabled() {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "External analysis is not enabled"})
+			return
+		}
+		if !a.isAnalysisWorker(c.Request) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid analysis worker token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// isAnalysisWorker 判断请求是否带着外部分析 worker 的令牌
+func (a *API) isAnalysisWorker(r *http.Request) bool {
+	if !a.analysisEnabled() {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(a.analysisCfg.WorkerToken)) == 1
+}
+
+// queueExternalAnalysis 启用外部分析时为新入库的歌曲创建分析任务
+func (a *API) queueExternalAnalysis(songID string) {
+	if !a.analysisEnabled() {
+		return
+	}
+	if _, err := a.db.EnqueueAnalysis([]string{songID}); err != nil {
+		log.Printf("Error queueing analysis for song %s: %v", songID, err)
+	}
+}
+
+// handleAnalysisLease worker 领取待分析的歌曲，没有任务时 tasks 为空，worker 应稍后再试
+func (a *API) handleAnalysisLease(c *gin.Context) {
+	var payload AnalysisLeasePayload
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.Worker == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "worker is required"})
+		return
+	}
+	limit := max(1, min(payload.Max, maxAnalysisLease))
+	tasks, err := a.db.LeaseAnalysisTasks(payload.Worker, limit, a.analysisLease(), a.analysisMaxAttempts())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lease analysis tasks"})
+		return
+	}
+	baseURL := requestBaseURL(c)
+	lease := AnalysisLease{Tasks: make([]AnalysisTaskInfo, 0, len(tasks)), LeaseSeconds: int(a.analysisLease().Seconds())}
+	for _, task := range tasks {
+		song, err := a.db.GetSong(task.SongID)
+		if err != nil {
+			// 歌曲已被删除，任务不再需要
+			a.db.FailAnalysisTask(task.ID, "song not found", 0)
+			continue
+		}
+		mediaURL := song.StreamURL
+		if path := cmp.Or(song.DirectURL, song.HLSURL); path != "" {
+			mediaURL = baseURL + path
+		}
+		lease.Tasks = append(lease.Tasks, AnalysisTaskInfo{
+			ID:         task.ID,
+			SongID:     song.ID,
+			Title:      song.Title,
+			Artist:     song.Artist,
+			Album:      song.Album,
+			DurationMs: song.DurationMs,
+			MediaURL:   mediaURL,
+		})
+	}
+	if len(lease.Tasks) > 0 {
+		log.Printf("Analysis worker %s leased %d tasks", payload.Worker, len(lease.Tasks))
+	}
+	c.JSON(http.StatusOK, lease)
+}
+
+// leasedAnalysisTask 读取路径中的任务，任务不存在或已结束时写出错误响应并返回 nil
+func (a *API) leasedAnalysisTask(c *gin.Context) *db.AnalysisTask {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+		return nil
+	}
+	task, err := a.db.GetAnalysisTask(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Analysis task not found"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get analysis task"})
+		return nil
+	}
+	// 租约过期后任务可能已交给另一个 worker，迟到的结果仍然有效；只拒绝已结束的任务
+	if task.Status == db.AnalysisDone || task.Status == db.AnalysisFailed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Analysis task is already finished"})
+		return nil
+	}
+	return task
+}
+
+// handleAnalysisResult worker 回传一首歌的分析结果，只更新提供的字段
+// 调性统一保存为 Camelot 记法；流派只在歌曲没有流派标签、也没有手动修改过时填入
+func (a *API) handleAnalysisResult(c *gin.Context) {
+	task := a.leasedAnalysisTask(c)
+	if task == nil {
+		return
+	}
+	var payload AnalysisResultPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid analysis result"})
+		return
+	}
+	song, err := a.db.GetSong(task.SongID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Song not found"})
+		return
+	}
+	updates := make(map[string]interface{})
+	if payload.BPM != nil {
+		if *payload.BPM <= 0 || *payload.BPM > 400 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bpm must be between 0 and 400"})
+			return
+		}
+		updates["bpm"] = *payload.BPM
+	}
+	if payload.MusicalKey != nil {
+		key := db.CamelotKey(*payload.MusicalKey)
+		if key == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unrecognized musical key"})
+			return
+		}
+		updates["musical_key"] = key
+	}
+	if payload.Genre != nil && song.Genre == "" && !song.ManualTags {
+		updates["genre"] = strings.TrimSpace(*payload.Genre)
+	}
+	if payload.Fingerprint != nil {
+		updates["fingerprint"] = *payload.Fingerprint
+	}
+	if len(updates) > 0 {
+		if err := a.db.SetSongAnalysis(song.ID, updates); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save analysis result"})
+			return
+		}
+	}
+	if err := a.db.FinishAnalysisTask(task.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save analysis result"})
+		return
+	}
+	// 播放列表中的副本也要更新，自动 DJ 按新的 BPM 和调性选歌
+	a.state.RefreshSong(song.ID)
+	log.Printf("Analysis result for %s from %s: %d fields", song.ID, task.Worker, len(updates))
+	c.Status(http.StatusNoContent)
+}
+
+// handleAnalysisFail worker 报告无法分析，任务在达到最多派发次数前会重新派发
+func (a *API) handleAnalysisFail(c *gin.Context) {
+	task := a.leasedAnalysisTask(c)
+	if task == nil {
+		return
+	}
+	var payload AnalysisFailPayload
+	c.ShouldBindJSON(&payload)
+	status, err := a.db.FailAnalysisTask(task.ID, cmp.Or(payload.Error, "unknown error"), a.analysisMaxAttempts())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update analysis task"})
+		return
+	}
+	log.Printf("Analysis of %s failed on %s (%s): %s", task.SongID, task.Worker, status, payload.Error)
+	c.JSON(http.StatusOK, gin.H{"status": status})
+}
+
+// handleGetAnalysis 分析队列各状态的任务数和最近失败的任务
+func (a *API) handleGetAnalysis(c *gin.Context) {
+	counts, err := a.db.CountAnalysisTasks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get analysis tasks"})
+		return
+	}
+	failed, err := a.db.GetFailedAnalysisTasks(analysisFailedShown)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get analysis tasks"})
+		return
+	}
+	c.JSON(http.StatusOK, AnalysisStatus{Enabled: a.analysisEnabled(), Counts: counts, Failed: failed})
+}
+
+// handleEnqueueAnalysis 把指定的歌曲或所有还没有 BPM 的歌曲加入分析队列，已在队列中的跳过
+func (a *API) handleEnqueueAnalysis(c *gin.Context) {
+	var payload AnalysisEnqueuePayload
+	if err := c.ShouldBindJSON(&payload); err != nil || (len(payload.SongIDs) == 0 && !payload.Missing) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "songIds or missing is required"})
+		return
+	}
+	if !a.analysisEnabled() {
+		c.JSON(http.StatusConflict, gin.H{"error": "External analysis is not enabled"})
+		return
+	}
+	songIDs := payload.SongIDs
+	if payload.Missing {
+		missing, err := a.db.SongIDsWithoutAnalysis()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get library"})
+			return
+		}
+		songIDs = append(songIDs, missing...)
+	}
+	queued, err := a.db.EnqueueAnalysis(songIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue analysis"})
+		return
+	}
+	log.Printf("Action: queued %d songs for analysis by %s", queued, c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"queued": queued})
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// artworkFileName 封面保存在歌曲的 HLS 目录中，随 /static/audio 一起提供
+const artworkFileName = "cover.jpg"
+
+// extractArtwork 从音频文件的内嵌图片中提取封面到 dir，没有封面时返回错误，调用方可以忽略
+func extractArtwork(ctx context.Context, inputFile, dir string) error {
+	// -an              : 只要图片流
+	// -frames:v 1      : 只取一帧
+	// scale            : 长边不超过 512，分享卡片和徽章都用不到更大的尺寸
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y",
+		"-i", inputFile,
+		"-an",
+		"-frames:v", "1",
+		"-vf", "scale=512:512:force_original_aspect_ratio=decrease",
+		filepath.Join(dir, artworkFileName),
+	)
+	return cmd.Run()
+}
+
+// artworkPath 返回歌曲封面在磁盘上的路径，没有封面时返回空字符串
+func (a *API) artworkPath(song *db.Song) string {
+	if song == nil || song.StreamURL != "" {
+		return ""
+	}
+	relDir := filepath.Dir(filepath.FromSlash(song.FilePath))
+	if relDir == "." {
+		return ""
+	}
+	p := filepath.Join(a.mediaDir, relDir, artworkFileName)
+	if _, err := os.Stat(p); err != nil {
+		return ""
+	}
+	return p
+}
+
+// artworkURL 返回封面的访问地址，调用方需先用 artworkPath 确认封面存在
+func artworkURL(song *db.Song, baseURL string) string {
+	return baseURL + "/static/audio/" + path.Dir(song.FilePath) + "/" + artworkFileName
+}
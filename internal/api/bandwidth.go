@@ -0,0 +1,382 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/config"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/hooks"
+)
+
+const (
+	// bandwidthFlushInterval 流量先在内存中累计，定期写入数据库，进程退出时最多丢失这段时间的统计
+	bandwidthFlushInterval = time.Minute
+	// defaultBandwidthWarnPercent 未配置时用量达到上限的百分之多少发出警告
+	defaultBandwidthWarnPercent = 80
+	// bandwidthTopSongs 流量统计中返回的歌曲数
+	bandwidthTopSongs = 50
+	bytesPerMB        = 1 << 20
+)
+
+var (
+	errBandwidthCapReached     = errors.New("monthly bandwidth limit reached")
+	errUserBandwidthCapReached = errors.New("your monthly bandwidth limit has been reached")
+)
+
+// BandwidthStats 一个月的媒体流量，CapBytes 为 0 表示不限制
+type BandwidthStats struct {
+	Month    string `json:"month"`
+	Bytes    int64  `json:"bytes"`
+	CapBytes int64  `json:"capBytes"`
+}
+
+// BandwidthReport 管理接口返回的一个月的流量统计
+type BandwidthReport struct {
+	BandwidthStats
+	UserCapBytes int64 `json:"userCapBytes"`
+	// Songs 流量最多的歌曲，Users 每个用户的流量，用户名为空的一项是不带凭证的请求
+	Songs []db.SongBandwidth `json:"songs"`
+	Users []db.UserBandwidth `json:"users"`
+}
+
+// BandwidthWarning bandwidth_warning 钩子的数据，Username 为空表示整个实例
+type BandwidthWarning struct {
+	Month    string `json:"month"`
+	Username string `json:"username,omitempty"`
+	Bytes    int64  `json:"bytes"`
+	CapBytes int64  `json:"capBytes"`
+	// Exceeded 已经达到上限，媒体请求开始被拒绝；为 false 表示达到了警告的比例
+	Exceeded bool `json:"exceeded"`
+}
+
+type bandwidthKey struct {
+	month, media, user string
+}
+
+type bandwidthWarningKey struct {
+	user     string
+	exceeded bool
+}
+
+// bandwidthMeter 统计发送的媒体流量并检查每月上限
+// 本月的总量和各用户的用量在内存中维护，每次写入数据库后从数据库重新加载，多实例共享数据库时也包括其他实例的流量
+type bandwidthMeter struct {
+	mu           sync.Mutex
+	capBytes     int64
+	userCapBytes int64
+	warnPercent  int64
+	// requireUser 拒绝无法识别用户的媒体请求
+	requireUser bool
+	// persist 为 false 时（只读副本）不写数据库，只在内存中累计用于检查上限
+	persist bool
+	month   string
+	total   int64
+	users   map[string]int64
+	pending map[bandwidthKey]int64
+	warned  map[bandwidthWarningKey]bool
+
+	// flushMu 保证同时只有一次写入，songIDs 只在写入时使用
+	flushMu sync.Mutex
+	songIDs map[string]string
+}
+
+func newBandwidthMeter(cfg config.BandwidthConfig, persist bool) *bandwidthMeter {
+	warnPercent := int64(defaultBandwidthWarnPercent)
+	if cfg.WarnPercent > 0 {
+		warnPercent = int64(cfg.WarnPercent)
+	}
+	return &bandwidthMeter{
+		capBytes:     cfg.MonthlyCapMB * bytesPerMB,
+		userCapBytes: cfg.UserMonthlyCapMB * bytesPerMB,
+		warnPercent:  warnPercent,
+		requireUser:  cfg.RequireUser,
+		persist:      persist,
+		month:        currentBandwidthMonth(),
+		users:        make(map[string]int64),
+		pending:      make(map[bandwidthKey]int64),
+		warned:       make(map[bandwidthWarningKey]bool),
+		songIDs:      make(map[string]string),
+	}
+}
+
+func currentBandwidthMonth() string {
+	return time.Now().Format(db.BandwidthMonthLayout)
+}
+
+// rolloverLocked 进入新的月份时清零用量，还没写入的流量仍记在原来的月份，调用方需持有锁
+func (m *bandwidthMeter) rolloverLocked() {
+	month := currentBandwidthMonth()
+	if month == m.month {
+		return
+	}
+	m.month = month
+	m.total = 0
+	m.users = make(map[string]int64)
+	m.warned = make(map[bandwidthWarningKey]bool)
+}
+
+// allow 检查实例和用户本月的用量是否已达到上限
+func (m *bandwidthMeter) allow(user string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rolloverLocked()
+	if m.capBytes > 0 && m.total >= m.capBytes {
+		return errBandwidthCapReached
+	}
+	if m.userCapBytes > 0 && user != "" && m.users[user] >= m.userCapBytes {
+		return errUserBandwidthCapReached
+	}
+	return nil
+}
+
+// record 记录一次媒体请求发送的字节数，返回因此越过警告比例或上限的警告
+func (m *bandwidthMeter) record(media, user string, bytes int64) []BandwidthWarning {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rolloverLocked()
+	if m.persist {
+		m.pending[bandwidthKey{month: m.month, media: media, user: user}] += bytes
+	}
+	m.total += bytes
+	if user != "" {
+		m.users[user] += bytes
+	}
+	var warnings []BandwidthWarning
+	m.warnLocked(&warnings, "", m.total, m.capBytes)
+	if user != "" {
+		m.warnLocked(&warnings, user, m.users[user], m.userCapBytes)
+	}
+	return warnings
+}
+
+// warnLocked 用量越过警告比例或上限时追加一条警告，每个月每种警告只发出一次，调用方需持有锁
+func (m *bandwidthMeter) warnLocked(warnings *[]BandwidthWarning, user string, used, limit int64) {
+	if limit <= 0 {
+		return
+	}
+	exceeded := used >= limit
+	if !exceeded && used*100 < limit*m.warnPercent {
+		return
+	}
+	key := bandwidthWarningKey{user: user, exceeded: exceeded}
+	if m.warned[key] {
+		return
+	}
+	m.warned[key] = true
+	// 一次越过两个阈值时只发出达到上限的警告
+	m.warned[bandwidthWarningKey{user: user}] = true
+	*warnings = append(*warnings, BandwidthWarning{Month: m.month, Username: user, Bytes: used, CapBytes: limit, Exceeded: exceeded})
+}
+
+// flush 把累计的流量写入数据库，然后从数据库重新加载本月的用量
+func (m *bandwidthMeter) flush(d *db.DB) ([]BandwidthWarning, error) {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = make(map[bandwidthKey]int64)
+	m.mu.Unlock()
+
+	if len(pending) > 0 {
+		usages := make([]db.BandwidthUsage, 0, len(pending))
+		for key, bytes := range pending {
+			songID, ok := m.songIDs[key.media]
+			if !ok {
+				var err error
+				if songID, err = d.GetSongIDByMediaPath(key.media); err != nil {
+					m.restore(pending)
+					return nil, err
+				}
+				m.songIDs[key.media] = songID
+			}
+			usages = append(usages, db.BandwidthUsage{Month: key.month, SongID: songID, Username: key.user, Bytes: bytes})
+		}
+		if err := d.AddBandwidthUsage(usages); err != nil {
+			m.restore(pending)
+			return nil, err
+		}
+	}
+
+	month := currentBandwidthMonth()
+	total, err := d.GetMonthBandwidth(month)
+	if err != nil {
+		return nil, err
+	}
+	users, err := d.GetUserBandwidth(month)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rolloverLocked()
+	if m.month != month {
+		return nil, nil
+	}
+	m.total = total
+	m.users = make(map[string]int64, len(users))
+	for _, u := range users {
+		if u.Username != "" {
+			m.users[u.Username] = u.Bytes
+		}
+	}
+	// 加载期间新记录的流量还没有写入数据库
+	for key, bytes := range m.pending {
+		if key.month != month {
+			continue
+		}
+		m.total += bytes
+		if key.user != "" {
+			m.users[key.user] += bytes
+		}
+	}
+	var warnings []BandwidthWarning
+	m.warnLocked(&warnings, "", m.total, m.capBytes)
+	for username, used := range m.users {
+		m.warnLocked(&warnings, username, used, m.userCapBytes)
+	}
+	return warnings, nil
+}
+
+// restore 写入失败时把流量放回去，下次再写
+func (m *bandwidthMeter) restore(pending map[bandwidthKey]int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, bytes := range pending {
+		m.pending[key] += bytes
+	}
+}
+
+// stats 返回本月实例的用量
+func (m *bandwidthMeter) stats() BandwidthStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rolloverLocked()
+	return BandwidthStats{Month: m.month, Bytes: m.total, CapBytes: m.capBytes}
+}
+
+// userStats 返回本月用户的用量
+func (m *bandwidthMeter) userStats(user string) BandwidthStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rolloverLocked()
+	return BandwidthStats{Month: m.month, Bytes: m.users[user], CapBytes: m.userCapBytes}
+}
+
+// bandwidthLoop 定期写入流量统计；只读副本不写数据库，只在启动时加载本月已有的用量
+func (a *API) bandwidthLoop() {
+	a.flushBandwidth()
+	if a.readOnly {
+		return
+	}
+	ticker := time.NewTicker(bandwidthFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		a.flushBandwidth()
+	}
+}
+
+func (a *API) flushBandwidth() {
+	warnings, err := a.bandwidth.flush(a.db)
+	if err != nil {
+		log.Printf("Failed to save bandwidth usage: %v", err)
+		return
+	}
+	a.fireBandwidthWarnings(warnings)
+}
+
+// fireBandwidthWarnings 记录警告日志并触发 bandwidth_warning 钩子
+func (a *API) fireBandwidthWarnings(warnings []BandwidthWarning) {
+	for _, w := range warnings {
+		who := "instance"
+		if w.Username != "" {
+			who = "user " + w.Username
+		}
+		if w.Exceeded {
+			log.Printf("Warning: Bandwidth usage of %s reached the monthly cap (%d MB), media requests are rejected until next month", who, w.CapBytes/bytesPerMB)
+		} else {
+			log.Printf("Warning: Bandwidth usage of %s is at %d%% of the monthly cap (%d MB)", who, w.Bytes*100/w.CapBytes, w.CapBytes/bytesPerMB)
+		}
+		a.hooks.Fire(hooks.BandwidthWarning, w)
+	}
+}
+
+// publicMedia 开启 requireUser 后仍然允许匿名访问的媒体请求：封面图片和分析 worker 的下载
+func (a *API) publicMedia(r *http.Request) bool {
+	return path.Base(r.URL.Path) == artworkFileName || a.isAnalysisWorker(r)
+}
+
+// bandwidthMiddleware 达到每月流量上限时以 429 拒绝媒体请求，否则统计发送的字节数（压缩后）
+// 开启 requireUser 时无法识别用户的请求以 401 拒绝，用户不能靠不带凭证绕过自己的上限
+func (a *API) bandwidthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		media := mediaKey(c.Request.URL.Path)
+		if media == "" {
+			c.Next()
+			return
+		}
+		user := a.mediaUser(c)
+		if user == "" && a.bandwidth.requireUser && !a.publicMedia(c.Request) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Sign in to play media"})
+			return
+		}
+		if err := a.bandwidth.allow(user); err != nil {
+			now := time.Now()
+			nextMonth := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location())
+			c.Header("Retry-After", strconv.Itoa(int(time.Until(nextMonth).Seconds())+1))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		w := c.Writer
+		c.Next()
+		if size := w.Size(); size > 0 {
+			a.fireBandwidthWarnings(a.bandwidth.record(media, user, int64(size)))
+		}
+	}
+}
+
+// handleGetBandwidth 返回一个月（?month=2006-01，默认本月）按歌曲和用户统计的媒体流量
+func (a *API) handleGetBandwidth(c *gin.Context) {
+	month := c.DefaultQuery("month", currentBandwidthMonth())
+	if _, err := time.Parse(db.BandwidthMonthLayout, month); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "month must be in YYYY-MM format"})
+		return
+	}
+	if !a.readOnly {
+		a.flushBandwidth()
+	}
+	total, err := a.db.GetMonthBandwidth(month)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get bandwidth usage"})
+		return
+	}
+	songs, err := a.db.GetSongBandwidth(month, bandwidthTopSongs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get bandwidth usage"})
+		return
+	}
+	users, err := a.db.GetUserBandwidth(month)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get bandwidth usage"})
+		return
+	}
+	c.JSON(http.StatusOK, BandwidthReport{
+		BandwidthStats: BandwidthStats{Month: month, Bytes: total, CapBytes: a.bandwidth.capBytes},
+		UserCapBytes:   a.bandwidth.userCapBytes,
+		Songs:          songs,
+		Users:          users,
+	})
+}
+
+// handleGetMyBandwidth 返回当前用户本月的媒体流量和上限
+func (a *API) handleGetMyBandwidth(c *gin.Context) {
+	c.JSON(http.StatusOK, a.bandwidth.userStats(c.GetString("username")))
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/yeeeck/sync-jukebox/internal/config"
+)
+
+// skipWindow 切歌预算的滑动窗口长度
+const skipWindow = time.Hour
+
+// Budget 是返回给前端的剩余额度，-1 表示不限制
+type Budget struct {
+	SkipsRemaining    int `json:"skipsRemaining"`
+	RequestsRemaining int `json:"requestsRemaining"`
+}
+
+// BudgetTracker 记录每个用户最近的切歌时间，实现按小时滑动窗口的切歌限制。
+// 排队数量直接由播放列表统计，不需要在这里记录。
+type BudgetTracker struct {
+	mu     sync.Mutex
+	limits config.FairnessConfig
+	skips  map[string][]time.Time
+}
+
+// NewBudgetTracker 创建一个新的预算记录器
+func NewBudgetTracker(limits config.FairnessConfig) *BudgetTracker {
+	return &BudgetTracker{
+		limits: limits,
+		skips:  make(map[string][]time.Time),
+	}
+}
+
+// SetLimits 修改额度，房间设置变化时调用，已有的切歌记录保留
+func (b *BudgetTracker) SetLimits(limits config.FairnessConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limits = limits
+}
+
+// TryConsumeSkip 如果用户还有切歌额度，则记录一次切歌并返回 true
+func (b *BudgetTracker) TryConsumeSkip(username string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limits.MaxSkipsPerHour <= 0 {
+		return true
+	}
+	recent := b.pruneLocked(username)
+	if len(recent) >= b.limits.MaxSkipsPerHour {
+		return false
+	}
+	b.skips[username] = append(recent, time.Now())
+	return true
+}
+
+// SkipsRemaining 返回用户在当前窗口内剩余的切歌次数
+func (b *BudgetTracker) SkipsRemaining(username string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limits.MaxSkipsPerHour <= 0 {
+		return -1
+	}
+	return max(b.limits.MaxSkipsPerHour-len(b.pruneLocked(username)), 0)
+}
+
+// RequestsRemaining 根据用户当前排队的歌曲数返回剩余可点歌数
+func (b *BudgetTracker) RequestsRemaining(pending int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limits.MaxPendingRequests <= 0 {
+		return -1
+	}
+	return max(b.limits.MaxPendingRequests-pending, 0)
+}
+
+// pruneLocked 丢弃窗口之外的切歌记录，调用方需持有锁
+func (b *BudgetTracker) pruneLocked(username string) []time.Time {
+	cutoff := time.Now().Add(-skipWindow)
+	recent := b.skips[username][:0]
+	for _, t := range b.skips[username] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) == 0 {
+		delete(b.skips, username)
+		return nil
+	}
+	b.skips[username] = recent
+	return recent
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 批量操作的类型
+const (
+	BulkDelete        = "delete"
+	BulkRetag         = "retag"
+	BulkAddToPlaylist = "add-to-playlist"
+)
+
+// maxBulkSongs 单次批量操作的歌曲数上限
+const maxBulkSongs = 2000
+
+// handleLibraryBulk 对一组歌曲执行同一个操作，数据库改动在一个事务里完成，只广播一次
+func (a *API) handleLibraryBulk(c *gin.Context) {
+	var payload LibraryBulkPayload
+	if err := c.ShouldBindJSON(&payload); err != nil || len(payload.SongIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "action and songIds are required"})
+		return
+	}
+	if len(payload.SongIDs) > maxBulkSongs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many songs in one request"})
+		return
+	}
+
+	switch payload.Action {
+	case BulkDelete:
+		// 与单首删除一样只移入回收站，非管理员只能删除自己上传的歌曲
+		if !a.checkSongsOwnership(c, payload.SongIDs) {
+			return
+		}
+		removed, err := a.state.RemoveSongsFromLibrary(payload.SongIDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove songs"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"affected": removed})
+	case BulkRetag:
+		if payload.Artist == nil && payload.AlbumArtist == nil && payload.Genre == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "artist, albumArtist or genre is required for retag"})
+			return
+		}
+		if !a.checkSongsOwnership(c, payload.SongIDs) {
+			return
+		}
+		updated, err := a.state.RetagSongs(payload.SongIDs, payload.Artist, payload.AlbumArtist, payload.Genre)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update songs"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"affected": updated})
+	case BulkAddToPlaylist:
+		username := c.GetString("username")
+		if remaining := a.budgetFor(username).RequestsRemaining; remaining >= 0 && remaining < len(payload.SongIDs) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Not enough request budget for all songs", "budget": a.budgetFor(username)})
+			return
+		}
+		added, err := a.state.AddManyToPlaylist(payload.SongIDs, actorFrom(c))
+		if err != nil {
+			respondStateError(c, err, http.StatusBadRequest, err.Error())
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"affected": added, "budget": a.budgetFor(username)})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "action must be delete, retag or add-to-playlist"})
+	}
+}
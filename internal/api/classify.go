@@ -0,0 +1,109 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/classifier"
+	"gorm.io/gorm"
+)
+
+// classifyQueueSize 等待分类的歌曲数上限，超出时跳过，可以在之后替换文件或手动设置
+const classifyQueueSize = 1024
+
+// queueClassification 配置了分类服务时把歌曲放入分类队列，队列满时跳过
+func (a *API) queueClassification(songID string) {
+	if a.classifier == nil {
+		return
+	}
+	select {
+	case a.classifyQueue <- songID:
+	default:
+		log.Printf("Classification queue is full, song %s will not be classified", songID)
+	}
+}
+
+// classifySongs 逐首调用分类服务，只为缺少流派或情绪的歌曲补上置信度足够的结果
+func (a *API) classifySongs() {
+	for songID := range a.classifyQueue {
+		song, err := a.db.GetSong(songID)
+		if err != nil || song.ManualTags || (song.Genre != "" && song.Mood != "") {
+			continue // 已被删除、手动设置过或标签齐全
+		}
+		mediaURL := ""
+		if !song.Unavailable {
+			mediaURL = a.classifier.MediaURL(cmp.Or(song.DirectURL, song.HLSURL))
+		}
+		result, err := a.classifier.Classify(context.Background(), classifier.Request{
+			SongID:     song.ID,
+			Title:      song.Title,
+			Artist:     song.Artist,
+			Album:      song.Album,
+			Year:       song.Year,
+			DurationMs: song.DurationMs,
+			Genre:      song.Genre,
+			Mood:       song.Mood,
+			BPM:        song.BPM,
+			MusicalKey: song.MusicalKey,
+			MediaURL:   mediaURL,
+		})
+		if err != nil {
+			log.Printf("Classification of song %s failed: %v", songID, err)
+			continue
+		}
+		tags := song.Tags()
+		minConfidence := a.ingestCfg.Classifier.MinConfidence
+		if tags.Genre == "" && result.Genre != "" && result.GenreConfidence >= minConfidence {
+			tags.Genre, tags.GenreConfidence = result.Genre, result.GenreConfidence
+		}
+		if tags.Mood == "" && result.Mood != "" && result.MoodConfidence >= minConfidence {
+			tags.Mood, tags.MoodConfidence = result.Mood, result.MoodConfidence
+		}
+		if tags == song.Tags() {
+			continue
+		}
+		if err := a.state.SetSongTags(songID, tags); err != nil {
+			log.Printf("Failed to save classification of song %s: %v", songID, err)
+			continue
+		}
+		log.Printf("Song classified: %s genre=%q (%.2f) mood=%q (%.2f)", song.Title, tags.Genre, tags.GenreConfidence, tags.Mood, tags.MoodConfidence)
+	}
+}
+
+// handleSetSongTags 手动设置歌曲的流派和情绪，覆盖自动分类的结果；之后自动分类不再改动这首歌
+func (a *API) handleSetSongTags(c *gin.Context) {
+	var payload SongTagsPayload
+	if err := c.ShouldBindJSON(&payload); err != nil || (payload.Genre == nil && payload.Mood == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "genre or mood is required"})
+		return
+	}
+	song, err := a.db.GetSong(c.Param("id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Song not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get song"})
+		return
+	}
+	tags := song.Tags()
+	tags.Manual = true
+	if payload.Genre != nil {
+		tags.Genre, tags.GenreConfidence = strings.TrimSpace(*payload.Genre), 0
+	}
+	if payload.Mood != nil {
+		tags.Mood, tags.MoodConfidence = strings.TrimSpace(*payload.Mood), 0
+	}
+	if err := a.state.SetSongTags(song.ID, tags); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update song"})
+		return
+	}
+	song.SetTags(tags)
+	log.Printf("Action: %s set tags of %s: genre=%q mood=%q", c.GetString("username"), song.ID, tags.Genre, tags.Mood)
+	c.JSON(http.StatusOK, song)
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/cluster"
+)
+
+// UseCluster 启用多实例模式，需在 RegisterRoutes 之前调用
+// 从实例把所有 /api 请求转发给主实例，自己只维护 WebSocket 连接
+func (a *API) UseCluster(elector *cluster.Elector) {
+	a.cluster = elector
+}
+
+// leaderProxyMiddleware 从实例上把请求反向代理到主实例
+func (a *API) leaderProxyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.cluster == nil || a.cluster.IsLeader() {
+			c.Next()
+			return
+		}
+		leaderURL := a.cluster.LeaderURL(c.Request.Context())
+		target, err := url.Parse(leaderURL)
+		if leaderURL == "" || err != nil {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "No leader instance available, try again later"})
+			return
+		}
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("Failed to proxy %s to leader %s: %v", r.URL.Path, leaderURL, err)
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		proxy.ServeHTTP(c.Writer, c.Request)
+		c.Abort()
+	}
+}
+
+// initialState 返回新 WebSocket 连接的初始状态，从实例使用最近收到的广播
+func (a *API) initialState() interface{} {
+	if a.cluster != nil && !a.cluster.IsLeader() {
+		return a.hub.LastState()
+	}
+	return a.state.GetFullState()
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxCommentLength 评论的最大长度（字符）
+	maxCommentLength = 280
+	// recentCommentsLimit 管理员审核时列出的最近评论条数
+	recentCommentsLimit = 200
+)
+
+// songComments 返回一首歌的评论，管理员能看到被隐藏的评论
+func (a *API) songComments(c *gin.Context, songID string) ([]db.SongComment, error) {
+	return a.db.GetSongComments(songID, c.GetString("role") == db.RoleAdmin)
+}
+
+// handleGetSongComments 返回一首歌的评论，最早的在前
+func (a *API) handleGetSongComments(c *gin.Context) {
+	comments, err := a.songComments(c, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get comments"})
+		return
+	}
+	c.JSON(http.StatusOK, comments)
+}
+
+// handleAddSongComment 给曲库中的一首歌写评论，flash 为 true 时歌曲开始播放时向客户端闪现
+func (a *API) handleAddSongComment(c *gin.Context) {
+	var payload SongCommentPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	text := strings.TrimSpace(payload.Text)
+	if text == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "text is required"})
+		return
+	}
+	if utf8.RuneCountInString(text) > maxCommentLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "text must be at most 280 characters"})
+		return
+	}
+	song, err := a.db.GetSong(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Song not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get song"})
+		return
+	}
+	comment := &db.SongComment{SongID: song.ID, Text: text, Author: c.GetString("username"), Flash: payload.Flash}
+	if err := a.db.AddSongComment(comment); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save comment"})
+		return
+	}
+	log.Printf("Action: %s commented on song %s (%s)", comment.Author, song.ID, song.Title)
+	c.JSON(http.StatusCreated, comment)
+}
+
+// handleDeleteSongComment 删除一条评论，只有作者和管理员可以删除
+func (a *API) handleDeleteSongComment(c *gin.Context) {
+	comment, ok := a.commentParam(c, "commentId")
+	if !ok {
+		return
+	}
+	if comment.SongID != c.Param("id") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+		return
+	}
+	username := c.GetString("username")
+	if comment.Author != username && c.GetString("role") != db.RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the author or an admin can delete this comment"})
+		return
+	}
+	if err := a.db.DeleteSongComment(comment.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete comment"})
+		return
+	}
+	log.Printf("Action: %s deleted comment %d on song %s", username, comment.ID, comment.SongID)
+	c.Status(http.StatusNoContent)
+}
+
+// handleGetRecentComments 最近的评论，包括被隐藏的，供管理员审核
+func (a *API) handleGetRecentComments(c *gin.Context) {
+	comments, err := a.db.GetRecentComments(recentCommentsLimit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get comments"})
+		return
+	}
+	c.JSON(http.StatusOK, comments)
+}
+
+// handleHideComment 隐藏或恢复一条评论，隐藏的评论不再显示也不再闪现，作者仍可以删除
+func (a *API) handleHideComment(c *gin.Context) {
+	var payload CommentHidePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "hidden is required"})
+		return
+	}
+	comment, ok := a.commentParam(c, "id")
+	if !ok {
+		return
+	}
+	username := c.GetString("username")
+	if err := a.db.SetCommentHidden(comment.ID, *payload.Hidden, username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update comment"})
+		return
+	}
+	log.Printf("Action: %s set comment %d hidden=%v", username, comment.ID, *payload.Hidden)
+	comment, err := a.db.GetSongComment(comment.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get comments"})
+		return
+	}
+	c.JSON(http.StatusOK, comment)
+}
+
+// commentParam 按路径参数 name 查找评论，找不到时写出错误响应并返回 false
+func (a *API) commentParam(c *gin.Context, name string) (*db.SongComment, bool) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+		return nil, false
+	}
+	comment, err := a.db.GetSongComment(uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get comments"})
+		return nil, false
+	}
+	return comment, true
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"gorm.io/gorm"
+)
+
+const (
+	// rememberDeviceTTL 登录时选择“记住此设备”签发的令牌的有效期
+	rememberDeviceTTL = 180 * 24 * time.Hour
+	// maxDeviceNameLength 设备名称的最大长度，超出部分截断
+	maxDeviceNameLength = 100
+)
+
+// DeviceSession 一台已登录的设备，Current 表示本次请求就来自这台设备
+type DeviceSession struct {
+	db.LoginToken
+	Current bool `json:"current"`
+}
+
+// RememberedLogin 记住此设备时签发的令牌，以 用户名:令牌 作为 Basic Auth 凭证代替密码
+type RememberedLogin struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// rememberDevice 为用密码登录的用户签发长期令牌；已经在用令牌登录的设备、访客和认证代理的用户不需要，只读副本不签发
+func (a *API) rememberDevice(c *gin.Context, user *db.User, device string) (*RememberedLogin, error) {
+	_, pass, ok := c.Request.BasicAuth()
+	// 只读副本不写数据库，仍然用密码登录
+	if !ok || a.readOnly || db.IsLoginToken(pass) || db.IsGuestUsername(user.Username) {
+		return nil, nil
+	}
+	if device == "" {
+		device = deviceName(c.Request)
+	}
+	token, expiresAt, err := a.db.CreateLoginToken(user.Username, db.LoginMethodRemember, truncateDeviceName(device), rememberDeviceTTL)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Action: %s signed in and remembered device %q", user.Username, device)
+	return &RememberedLogin{Token: token, ExpiresAt: expiresAt}, nil
+}
+
+// handleListDevices 列出当前用户已登录且令牌仍有效的设备，用密码登录的请求不会出现在列表中
+func (a *API) handleListDevices(c *gin.Context) {
+	tokens, err := a.db.GetLoginTokens(c.GetString("username"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list devices"})
+		return
+	}
+	var current uint
+	if _, pass, ok := c.Request.BasicAuth(); ok && db.IsLoginToken(pass) {
+		current = a.db.LoginTokenID(pass)
+	}
+	devices := make([]DeviceSession, 0, len(tokens))
+	for _, t := range tokens {
+		devices = append(devices, DeviceSession{LoginToken: t, Current: t.ID == current})
+	}
+	c.JSON(http.StatusOK, devices)
+}
+
+// handleRevokeDevice 注销当前用户的一台设备，该设备下次请求时需要重新登录
+func (a *API) handleRevokeDevice(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+	username := c.GetString("username")
+	if err := a.db.RevokeLoginTokenByID(username, uint(id)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out device"})
+		return
+	}
+	log.Printf("Action: %s signed out device %d", username, id)
+	c.Status(http.StatusOK)
+}
+
+// deviceName 根据 User-Agent 生成便于辨认的设备名称，例如 "Firefox on Windows"
+func deviceName(r *http.Request) string {
+	ua := r.UserAgent()
+	if ua == "" {
+		return "Unknown device"
+	}
+	var browser, platform string
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"), strings.Contains(ua, "CriOS/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	}
+	// iOS 和 Android 的 User-Agent 中同时带有 Mac OS X 或 Linux，要先判断
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		platform = "iOS"
+	case strings.Contains(ua, "Android"):
+		platform = "Android"
+	case strings.Contains(ua, "Windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "Mac OS X"), strings.Contains(ua, "Macintosh"):
+		platform = "macOS"
+	case strings.Contains(ua, "CrOS"):
+		platform = "ChromeOS"
+	case strings.Contains(ua, "Linux"):
+		platform = "Linux"
+	}
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	}
+	// 不是浏览器（命令行工具、脚本等），直接使用 User-Agent
+	return truncateDeviceName(ua)
+}
+
+func truncateDeviceName(name string) string {
+	name = strings.TrimSpace(name)
+	if r := []rune(name); len(r) > maxDeviceNameLength {
+		return string(r[:maxDeviceNameLength])
+	}
+	return name
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/yeeeck/sync-jukebox/internal/audiotag"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// hlsFileName 转码后的索引文件名
+	hlsFileName = "index.m3u8"
+	// originalFileName 没有 ffmpeg 时原样保存的音频文件名，扩展名按识别出的格式决定
+	originalFileName = "original"
+	// maxArtworkSize 封面长边的像素上限，与 ffmpeg 提取封面时的缩放一致
+	maxArtworkSize = 512
+)
+
+var errNeedsFFmpeg = errors.New("ffmpeg is not installed, only MP3, FLAC and Ogg files can be added")
+
+// ffmpegAvailable 判断 ffmpeg 和 ffprobe 是否都在 PATH 中，缺少任何一个时进入不转码的降级模式
+func ffmpegAvailable() bool {
+	for _, name := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(name); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// mediaFileName 决定歌曲目录中播放文件的名字：有 ffmpeg 时为 HLS 索引，否则为原始文件
+func (a *API) mediaFileName(input string) (string, error) {
+	if a.ffmpeg {
+		return hlsFileName, nil
+	}
+	info, err := audiotag.Read(input)
+	if err != nil {
+		return "", errNeedsFFmpeg
+	}
+	return originalFileName + "." + info.Format, nil
+}
+
+// technicalInfo 源文件的技术信息加上将要生成的 HLS 码率，各码率索引的命名与 convertToHLS 一致
+func (a *API) technicalInfo(meta *audioMetadata, mediaFileName string) *db.TechnicalInfo {
+	info := meta.Technical
+	if mediaFileName != hlsFileName {
+		return &info
+	}
+	cfg := a.transcodeCfg
+	for i, kbps := range cfg.BitratesKbps {
+		playlist := hlsFileName
+		if len(cfg.BitratesKbps) > 1 {
+			playlist = fmt.Sprintf("stream_%d.m3u8", i)
+		}
+		info.Renditions = append(info.Renditions, db.Rendition{
+			Codec:          cfg.Codec,
+			BitrateKbps:    kbps,
+			Playlist:       playlist,
+			SegmentSeconds: cfg.SegmentSeconds,
+		})
+	}
+	return &info
+}
+
+// prepareMedia 执行排队的任务生成歌曲的播放文件：output 是 HLS 索引时转码，否则复制原始文件并提取内嵌封面
+// 任务有超时，可以通过任务接口取消
+func (a *API) prepareMedia(job *Job, input, output string) error {
+	ctx, err := a.jobs.Begin(job, a.transcodeTimeout())
+	if err != nil {
+		return err
+	}
+	if filepath.Base(output) == hlsFileName {
+		err = a.transcode(ctx, job, input, output)
+	} else {
+		err = copyOriginal(input, output)
+	}
+	return a.jobs.Finish(job, ctx, err)
+}
+
+// copyOriginal 原样复制音频文件，内嵌封面另存为 cover.jpg
+func copyOriginal(input, output string) error {
+	if err := copyFile(input, output); err != nil {
+		return err
+	}
+	if info, err := audiotag.Read(input); err == nil && info.Picture != nil {
+		// 封面可有可无，图片无法解码时忽略
+		if err := saveArtwork(info.Picture, filepath.Join(filepath.Dir(output), artworkFileName)); err != nil {
+			log.Printf("Warning: Failed to save embedded artwork of %s: %v", input, err)
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// saveArtwork 把内嵌图片缩放后保存为 JPEG，与 ffmpeg 提取的封面格式相同
+func saveArtwork(data []byte, dest string) error {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w > maxArtworkSize || h > maxArtworkSize {
+		if w >= h {
+			w, h = maxArtworkSize, h*maxArtworkSize/w
+		} else {
+			w, h = w*maxArtworkSize/h, maxArtworkSize
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, max(w, 1), max(h, 1)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(f, dst, &jpeg.Options{Quality: 90}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// nativeMetadata 没有 ffprobe 时用纯 Go 的解析器读取元数据，不支持章节
+func nativeMetadata(filePath string) (*audioMetadata, error) {
+	info, err := audiotag.Read(filePath)
+	if err != nil {
+		return nil, err
+	}
+	tags := info.Tags
+	return &audioMetadata{
+		DurationMs:  info.DurationMs,
+		Title:       tag(tags, "title"),
+		Artist:      tag(tags, "artist"),
+		Album:       tag(tags, "album"),
+		AlbumArtist: tag(tags, "album_artist", "albumartist", "album artist"),
+		Compilation: isCompilation(tags),
+		Genre:       tag(tags, "genre"),
+		Mood:        tag(tags, "mood", "TMOO"),
+		Year:        parseYear(tag(tags, "date", "year", "originaldate")),
+		Explicit:    isExplicit(tags),
+		GainDb:      replayGainTag(tags),
+		BPM:         bpmTag(tags),
+		MusicalKey:  db.CamelotKey(tag(tags, "initialkey", "key", "TKEY")),
+		Technical: db.TechnicalInfo{
+			Container:   info.Format,
+			Codec:       info.Codec,
+			BitrateKbps: info.BitrateKbps,
+			SampleRate:  info.SampleRate,
+			Channels:    info.Channels,
+			FileSize:    info.Size,
+		},
+	}, nil
+}
@@ -0,0 +1,392 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/yeeeck/sync-jukebox/internal/config"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/dlna"
+)
+
+const (
+	dlnaDefaultName = "SyncJukebox"
+	dlnaDefaultPort = 8200
+	// dlnaTranscodeBitrate HLS 歌曲和客户端普遍不支持的格式实时转码为 MP3 的码率
+	dlnaTranscodeBitrate = "320k"
+	// dlnaSongs 根目录下的全部歌曲
+	dlnaSongs = "songs"
+	// dlnaSongPrefix 歌曲条目的 ID 前缀，后接歌曲 ID
+	dlnaSongPrefix = "song/"
+)
+
+// dlnaFolders 根目录下的分组目录，分组容器的 ID 为 <目录 ID>/<分组键>
+var dlnaFolders = []struct {
+	id    string
+	title string
+	class string
+}{
+	{"artists", "Artists", dlna.ClassArtist},
+	{"albums", "Albums", dlna.ClassAlbum},
+	{"genres", "Genres", dlna.ClassGenre},
+	{"playlists", "Playlists", dlna.ClassPlaylist},
+}
+
+// dlnaMimeTypes 电视和功放普遍支持、原样发送的格式，其他格式实时转码为 MP3
+var dlnaMimeTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".flac": "audio/flac",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".wav":  "audio/wav",
+}
+
+// serveDLNA 在单独的端口上提供 DLNA 媒体服务器并通过 SSDP 宣告，启动失败时只记录日志
+func (a *API) serveDLNA(cfg config.DLNAConfig) {
+	name := cmp.Or(cfg.Name, dlnaDefaultName)
+	port := cmp.Or(cfg.Port, dlnaDefaultPort)
+	ln, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		log.Printf("Failed to start DLNA media server: %v", err)
+		return
+	}
+	id := dlnaUUID(port)
+	mux := http.NewServeMux()
+	mux.Handle("/", dlna.NewServer(id, name, dlnaLibrary{a: a, name: name}))
+	mux.HandleFunc("GET /media/{id}", a.serveDLNAMedia)
+	mux.HandleFunc("GET /artwork/{id}", a.serveDLNAArtwork)
+	go func() {
+		if err := dlna.Advertise(context.Background(), id, port); err != nil {
+			log.Printf("Warning: DLNA media server will not be discovered automatically: %v", err)
+		}
+	}()
+	log.Printf("DLNA media server %q listening on port %d", name, port)
+	if err := http.Serve(ln, dlnaLANOnly(mux)); err != nil {
+		log.Printf("DLNA media server stopped: %v", err)
+	}
+}
+
+// dlnaUUID 由主机名和端口生成固定的设备 UUID，重启后电视仍能认出同一台服务器
+func dlnaUUID(port int) string {
+	hostname, _ := os.Hostname()
+	return uuid.NewV5(uuid.NamespaceURL, fmt.Sprintf("sync-jukebox-dlna://%s:%d", hostname, port)).String()
+}
+
+// dlnaLANOnly 媒体服务器不需要登录，只接受局域网和本机地址的请求
+func dlnaLANOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		ip := net.ParseIP(host)
+		if ip == nil || !(ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast()) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// dlnaFormat 按文件扩展名决定提供给客户端的格式，需要转码而没有 ffmpeg 时 ok 为 false
+func (a *API) dlnaFormat(path string) (mimeType string, transcoded, ok bool) {
+	if mimeType, ok := dlnaMimeTypes[strings.ToLower(filepath.Ext(path))]; ok {
+		return mimeType, false, true
+	}
+	return "audio/mpeg", true, a.ffmpeg
+}
+
+// dlnaItem 歌曲对应的音乐条目，远程歌曲、不可用的歌曲和无法提供的格式返回 false
+// 与 mediaInput 一样优先使用原地引用的源文件，这里不检查文件是否存在，缺失时播放请求返回 404
+func (a *API) dlnaItem(song *db.Song, parentID string) (dlna.Object, bool) {
+	if song.StreamURL != "" || (song.Unavailable && song.SourcePath == "") {
+		return dlna.Object{}, false
+	}
+	mimeType, transcoded, ok := a.dlnaFormat(cmp.Or(song.SourcePath, song.FilePath))
+	if !ok {
+		return dlna.Object{}, false
+	}
+	item := dlna.Object{
+		ID:         dlnaSongPrefix + song.ID,
+		ParentID:   parentID,
+		Title:      song.Title,
+		Artist:     song.Artist,
+		Album:      song.Album,
+		Genre:      song.Genre,
+		Year:       song.Year,
+		Duration:   time.Duration(song.DurationMs) * time.Millisecond,
+		URL:        "/media/" + url.PathEscape(song.ID),
+		MimeType:   mimeType,
+		Transcoded: transcoded,
+	}
+	if !transcoded && song.Technical != nil {
+		item.Size = song.Technical.FileSize
+	}
+	if a.artworkPath(song) != "" {
+		item.ArtURL = "/artwork/" + url.PathEscape(song.ID)
+	}
+	return item, true
+}
+
+// serveDLNAMedia 提供歌曲的音频：支持的格式原样发送并支持按范围请求，其他格式（包括 HLS）实时转码为 MP3
+func (a *API) serveDLNAMedia(w http.ResponseWriter, r *http.Request) {
+	song, err := a.db.GetSong(r.PathValue("id"))
+	if err != nil || song.StreamURL != "" {
+		http.NotFound(w, r)
+		return
+	}
+	input := a.mediaInput(song)
+	if input == "" {
+		http.NotFound(w, r)
+		return
+	}
+	mimeType, transcoded, ok := a.dlnaFormat(input)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("transferMode.dlna.org", "Streaming")
+	w.Header().Set("contentFeatures.dlna.org", dlna.ContentFeatures(transcoded))
+
+	if !transcoded {
+		f, err := os.Open(input)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "", info.ModTime(), f)
+		return
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	// -map 0:a:0 : 只要第一条音轨，忽略内嵌封面
+	cmd := exec.CommandContext(r.Context(), "ffmpeg",
+		"-v", "error",
+		"-i", input,
+		"-map", "0:a:0",
+		"-c:a", "libmp3lame",
+		"-b:a", dlnaTranscodeBitrate,
+		"-f", "mp3",
+		"pipe:1",
+	)
+	cmd.Stdout = w
+	if err := cmd.Run(); err != nil && r.Context().Err() == nil {
+		log.Printf("DLNA transcode of song %s failed: %v", song.ID, err)
+	}
+}
+
+// serveDLNAArtwork 提供歌曲封面
+func (a *API) serveDLNAArtwork(w http.ResponseWriter, r *http.Request) {
+	song, err := a.db.GetSong(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	p := a.artworkPath(song)
+	if p == "" {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, p)
+}
+
+// dlnaLibrary 以曲库为内容的目录树：全部歌曲，以及按歌手、专辑、流派和歌单分组的歌曲
+// 目录不缓存，每次浏览都从数据库读取，曲库的变化立即可见
+type dlnaLibrary struct {
+	a    *API
+	name string
+}
+
+// dlnaGroup 一个歌手、专辑、流派或歌单容器及其中的歌曲
+type dlnaGroup struct {
+	id     string
+	title  string
+	artist string
+	songs  []*db.Song
+}
+
+func (l dlnaLibrary) Browse(id string) (dlna.Object, []dlna.Object, error) {
+	all, err := l.a.db.GetAllSongs()
+	if err != nil {
+		return dlna.Object{}, nil, err
+	}
+	// 只有能播放的歌曲出现在目录中，分组也只按这些歌曲计算
+	var songs []db.Song
+	for i := range all {
+		if _, ok := l.a.dlnaItem(&all[i], ""); ok {
+			songs = append(songs, all[i])
+		}
+	}
+
+	switch {
+	case id == dlna.RootID:
+		children := []dlna.Object{{ID: dlnaSongs, ParentID: id, Title: "All Songs", Container: true, ChildCount: len(songs)}}
+		for _, folder := range dlnaFolders {
+			groups, err := l.groups(folder.id, songs)
+			if err != nil {
+				return dlna.Object{}, nil, err
+			}
+			children = append(children, dlna.Object{ID: folder.id, ParentID: id, Title: folder.title, Container: true, ChildCount: len(groups)})
+		}
+		return dlna.Object{ID: id, ParentID: "-1", Title: l.name, Container: true}, children, nil
+	case id == dlnaSongs:
+		self := dlna.Object{ID: id, ParentID: dlna.RootID, Title: "All Songs", Container: true}
+		items := make([]dlna.Object, 0, len(songs))
+		for i := range songs {
+			item, _ := l.a.dlnaItem(&songs[i], id)
+			items = append(items, item)
+		}
+		return self, items, nil
+	case strings.HasPrefix(id, dlnaSongPrefix):
+		for i := range songs {
+			if songs[i].ID == strings.TrimPrefix(id, dlnaSongPrefix) {
+				item, _ := l.a.dlnaItem(&songs[i], dlnaSongs)
+				return item, nil, nil
+			}
+		}
+		return dlna.Object{}, nil, dlna.ErrNoSuchObject
+	}
+
+	folderID, _, grouped := strings.Cut(id, "/")
+	for _, folder := range dlnaFolders {
+		if folder.id != folderID {
+			continue
+		}
+		groups, err := l.groups(folder.id, songs)
+		if err != nil {
+			return dlna.Object{}, nil, err
+		}
+		if !grouped {
+			self := dlna.Object{ID: id, ParentID: dlna.RootID, Title: folder.title, Container: true}
+			children := make([]dlna.Object, 0, len(groups))
+			for _, g := range groups {
+				children = append(children, l.groupContainer(g, folder.id, folder.class))
+			}
+			return self, children, nil
+		}
+		for _, g := range groups {
+			if g.id != id {
+				continue
+			}
+			items := make([]dlna.Object, 0, len(g.songs))
+			for _, song := range g.songs {
+				item, _ := l.a.dlnaItem(song, id)
+				items = append(items, item)
+			}
+			return l.groupContainer(g, folder.id, folder.class), items, nil
+		}
+	}
+	return dlna.Object{}, nil, dlna.ErrNoSuchObject
+}
+
+// groupContainer 分组的容器对象，专辑使用第一首有封面的歌曲的封面
+func (l dlnaLibrary) groupContainer(g dlnaGroup, parentID, class string) dlna.Object {
+	obj := dlna.Object{ID: g.id, ParentID: parentID, Title: g.title, Class: class, Artist: g.artist, Container: true, ChildCount: len(g.songs)}
+	if class == dlna.ClassAlbum {
+		for _, song := range g.songs {
+			if l.a.artworkPath(song) != "" {
+				obj.ArtURL = "/artwork/" + url.PathEscape(song.ID)
+				break
+			}
+		}
+	}
+	return obj
+}
+
+// groups 按目录分组歌曲，歌手和专辑的分组与 GraphQL 接口一致
+func (l dlnaLibrary) groups(folderID string, songs []db.Song) ([]dlnaGroup, error) {
+	var groups []dlnaGroup
+	switch folderID {
+	case "artists":
+		for _, artist := range groupArtists(songs) {
+			groups = append(groups, dlnaGroup{
+				id:    folderID + "/" + strconv.FormatUint(uint64(artist.artist.ID), 10),
+				title: artist.artist.Name,
+				songs: unwrapSongs(artist.songs),
+			})
+		}
+	case "albums":
+		for _, album := range groupAlbums(songs) {
+			// 专辑没有 ID，用专辑名和歌手的哈希作为分组键
+			h := fnv.New64a()
+			h.Write([]byte(strings.ToLower(album.title) + "\x00" + db.ArtistKey(album.artist)))
+			groups = append(groups, dlnaGroup{
+				id:     fmt.Sprintf("%s/%x", folderID, h.Sum64()),
+				title:  album.title,
+				artist: album.artist,
+				songs:  unwrapSongs(album.songs),
+			})
+		}
+	case "genres":
+		index := make(map[string]int)
+		for i := range songs {
+			key := strings.ToLower(strings.TrimSpace(songs[i].Genre))
+			if key == "" {
+				continue
+			}
+			n, ok := index[key]
+			if !ok {
+				n = len(groups)
+				index[key] = n
+				groups = append(groups, dlnaGroup{id: folderID + "/" + url.PathEscape(key), title: songs[i].Genre})
+			}
+			groups[n].songs = append(groups[n].songs, &songs[i])
+		}
+	case "playlists":
+		playlists, err := l.a.db.GetSavedPlaylists()
+		if err != nil {
+			return nil, err
+		}
+		byID := make(map[string]*db.Song, len(songs))
+		for i := range songs {
+			byID[songs[i].ID] = &songs[i]
+		}
+		for _, playlist := range playlists {
+			g := dlnaGroup{id: folderID + "/" + strconv.FormatUint(uint64(playlist.ID), 10), title: playlist.Name}
+			if playlist.Rules != nil {
+				// 智能歌单不打乱顺序，分页浏览时每页的内容才一致
+				rules := *playlist.Rules
+				rules.Shuffle = false
+				for _, song := range rules.Select(songs) {
+					g.songs = append(g.songs, byID[song.ID])
+				}
+			} else {
+				for _, entry := range playlist.Songs {
+					if song, ok := byID[entry.SongID]; ok {
+						g.songs = append(g.songs, song)
+					}
+				}
+			}
+			groups = append(groups, g)
+		}
+	}
+	return groups, nil
+}
+
+func unwrapSongs(songs []*gqlSong) []*db.Song {
+	result := make([]*db.Song, len(songs))
+	for i, song := range songs {
+		result[i] = song.s
+	}
+	return result
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// handleScheduleDrop DJ 预定在某个时刻开始播放歌曲
+func (a *API) handleScheduleDrop(c *gin.Context) {
+	var payload DropPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "songId and at are required"})
+		return
+	}
+	drop, err := a.state.ScheduleDrop(payload.SongID, *payload.At, actorFrom(c))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Song not found"})
+		return
+	}
+	if err != nil {
+		respondStateError(c, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, drop)
+}
+
+// handleCancelDrop 取消预定的歌曲
+func (a *API) handleCancelDrop(c *gin.Context) {
+	if err := a.state.CancelDrop(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
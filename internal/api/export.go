@@ -0,0 +1,235 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"gorm.io/gorm"
+)
+
+// ExportManifest 导出包中的 manifest.json
+type ExportManifest struct {
+	ExportedAt time.Time      `json:"exported_at"`
+	Media      string         `json:"media"`
+	Songs      []ExportedSong `json:"songs"`
+}
+
+// ExportedSong 一首歌的元数据及其在包内的文件，引用远程实例的歌曲没有文件
+type ExportedSong struct {
+	db.Song
+	Files []string `json:"files"`
+}
+
+// exportFile 包内路径到磁盘路径的映射
+type exportFile struct {
+	name string
+	path string
+}
+
+// archiveWriter 统一 zip 和 tar 的写入方式
+type archiveWriter interface {
+	add(name string, size int64, modTime time.Time, r io.Reader) error
+	Close() error
+}
+
+type zipArchive struct{ w *zip.Writer }
+
+func (z zipArchive) add(name string, size int64, modTime time.Time, r io.Reader) error {
+	// HLS 切片已经是压缩过的 AAC，再压缩没有意义，直接存储
+	w, err := z.w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: modTime})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (z zipArchive) Close() error { return z.w.Close() }
+
+type tarArchive struct{ w *tar.Writer }
+
+func (t tarArchive) add(name string, size int64, modTime time.Time, r io.Reader) error {
+	if err := t.w.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, ModTime: modTime}); err != nil {
+		return err
+	}
+	_, err := io.Copy(t.w, r)
+	return err
+}
+
+func (t tarArchive) Close() error { return t.w.Close() }
+
+// handleLibraryExport 把曲库打包下载，用于备份或迁移到其他播放器
+// 查询参数：format=zip|tar，media=hls|original，playlist=<歌单 ID>，genre、artist 过滤
+// 只有原地引用的歌曲保留了原始文件，其余歌曲在 media=original 时仍导出 HLS
+func (a *API) handleLibraryExport(c *gin.Context) {
+	format := c.DefaultQuery("format", "zip")
+	if format != "zip" && format != "tar" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be zip or tar"})
+		return
+	}
+	media := c.DefaultQuery("media", "hls")
+	if media != "hls" && media != "original" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "media must be hls or original"})
+		return
+	}
+	songs, err := a.exportSongs(c)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Playlist not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	manifest := ExportManifest{ExportedAt: time.Now().UTC(), Media: media, Songs: make([]ExportedSong, 0, len(songs))}
+	var files []exportFile
+	for _, song := range songs {
+		songFiles := a.exportFiles(&song, media)
+		entry := ExportedSong{Song: song, Files: []string{}}
+		for _, f := range songFiles {
+			entry.Files = append(entry.Files, f.name)
+		}
+		manifest.Songs = append(manifest.Songs, entry)
+		files = append(files, songFiles...)
+	}
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build manifest"})
+		return
+	}
+
+	filename := fmt.Sprintf("jukebox-export-%s.%s", manifest.ExportedAt.Format("20060102-150405"), format)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	var archive archiveWriter
+	if format == "zip" {
+		c.Header("Content-Type", "application/zip")
+		archive = zipArchive{zip.NewWriter(c.Writer)}
+	} else {
+		c.Header("Content-Type", "application/x-tar")
+		archive = tarArchive{tar.NewWriter(c.Writer)}
+	}
+	c.Status(http.StatusOK)
+
+	// 先写清单，解包工具边读边处理时也能先拿到元数据
+	if err := archive.add("manifest.json", int64(len(manifestJSON)), manifest.ExportedAt, bytes.NewReader(manifestJSON)); err != nil {
+		log.Printf("Library export aborted: %v", err)
+		return
+	}
+	for _, f := range files {
+		if err := addFile(archive, f); err != nil {
+			// 响应头已经发出，只能中断传输，客户端会得到不完整的包
+			log.Printf("Library export aborted at %s: %v", f.path, err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Library export aborted: %v", err)
+		return
+	}
+	log.Printf("Library exported by %s: %d songs, %d files", c.GetString("username"), len(songs), len(files))
+}
+
+// exportSongs 按查询参数选出要导出的歌曲，指定歌单时保持歌单顺序
+func (a *API) exportSongs(c *gin.Context) ([]db.Song, error) {
+	var songs []db.Song
+	if raw := c.Query("playlist"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return nil, errors.New("playlist must be a saved playlist ID")
+		}
+		playlist, err := a.db.GetSavedPlaylist(uint(id))
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range playlist.Songs {
+			if item.Song != nil {
+				songs = append(songs, *item.Song)
+			}
+		}
+	} else {
+		all, err := a.db.GetAllSongs()
+		if err != nil {
+			return nil, err
+		}
+		songs = all
+	}
+	genre, artist := c.Query("genre"), c.Query("artist")
+	year, _ := strconv.Atoi(c.Query("year"))
+	decade, _ := strconv.Atoi(c.Query("decade"))
+	if genre == "" && artist == "" && year == 0 && decade == 0 {
+		return songs, nil
+	}
+	filtered := songs[:0]
+	for _, song := range songs {
+		if genre != "" && !strings.EqualFold(song.Genre, genre) {
+			continue
+		}
+		if artist != "" && !song.CreditedTo(artist) {
+			continue
+		}
+		if !db.InYearRange(song.Year, decade/10*10, year, year) {
+			continue
+		}
+		filtered = append(filtered, song)
+	}
+	return filtered, nil
+}
+
+// exportFiles 返回一首歌要打包的文件，包内路径为 songs/<id>/<文件名>
+func (a *API) exportFiles(song *db.Song, media string) []exportFile {
+	prefix := path.Join("songs", song.ID)
+	if song.StreamURL != "" {
+		return nil
+	}
+	if media == "original" && song.SourcePath != "" {
+		if _, err := os.Stat(song.SourcePath); err == nil {
+			return []exportFile{{name: path.Join(prefix, filepath.Base(song.SourcePath)), path: song.SourcePath}}
+		}
+	}
+	relDir := filepath.Dir(filepath.FromSlash(song.FilePath))
+	if relDir == "." {
+		return nil // 不是 <id>/index.m3u8 形式的旧数据，避免把整个媒体目录打包进去
+	}
+	songDir := filepath.Join(a.mediaDir, relDir)
+	entries, err := os.ReadDir(songDir)
+	if err != nil {
+		log.Printf("Warning: Skipping media of song %s in export: %v", song.ID, err)
+		return nil
+	}
+	var files []exportFile
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			files = append(files, exportFile{name: path.Join(prefix, entry.Name()), path: filepath.Join(songDir, entry.Name())})
+		}
+	}
+	return files
+}
+
+func addFile(archive archiveWriter, f exportFile) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return archive.add(f.name, info.Size(), info.ModTime(), file)
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/federation"
+)
+
+// handleGetFederation 返回跟随远程实例的状态
+func (a *API) handleGetFederation(c *gin.Context) {
+	c.JSON(http.StatusOK, a.follower.Status())
+}
+
+// handleFederationFollow 开始跟随另一个实例，替换正在跟随的实例
+func (a *API) handleFederationFollow(c *gin.Context) {
+	var payload FederationFollowPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url is required"})
+		return
+	}
+	if err := a.follower.Start(payload.URL, payload.Username, payload.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, a.follower.Status())
+}
+
+// handleFederationUnfollow 停止跟随，恢复本地播放控制
+func (a *API) handleFederationUnfollow(c *gin.Context) {
+	a.follower.Stop()
+	c.Status(http.StatusOK)
+}
+
+// handleImportRemote 从另一个实例导入曲库，并把它的播放列表合并到本地播放列表末尾
+func (a *API) handleImportRemote(c *gin.Context) {
+	var payload ImportRemotePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url is required"})
+		return
+	}
+	result, err := a.importer.Import(c.Request.Context(), federation.ImportOptions{
+		URL:       payload.URL,
+		APIKey:    payload.APIKey,
+		Reference: payload.Reference,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	response := ImportRemoteResult{ImportResult: *result}
+	if len(result.Playlist) > 0 {
+		added, err := a.state.AddManyToPlaylist(result.Playlist, actorFrom(c))
+		if err != nil {
+			// 曲库已经导入，播放列表合并失败只在结果中说明
+			response.PlaylistError = err.Error()
+		}
+		response.PlaylistAdded = added
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// notMirroringMiddleware 跟随远程实例时拒绝本地播放控制
+func (a *API) notMirroringMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if source := a.state.Mirroring(); source != "" {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "Playback is mirrored from " + source, "code": "MIRRORING"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// 被遗忘的好歌默认和最多返回的数量
+const (
+	defaultForgottenLimit = 50
+	maxForgottenLimit     = 200
+)
+
+// handleGetForgotten 返回曾经常播放或被收藏进歌单、但很久没有播放的歌曲，让大曲库保持新鲜
+// 查询参数：days（多少天没有播放）、minPlays（至少播放过多少次）、limit，未指定时使用配置的标准
+func (a *API) handleGetForgotten(c *gin.Context) {
+	params := map[string]int{"days": 0, "minPlays": 0, "limit": defaultForgottenLimit}
+	for name := range params {
+		raw := c.Query(name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days, minPlays and limit must be positive numbers"})
+			return
+		}
+		params[name] = n
+	}
+	songs, err := a.state.ForgottenSongs(params["days"], params["minPlays"], min(params["limit"], maxForgottenLimit))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get forgotten songs"})
+		return
+	}
+	if songs == nil {
+		songs = []db.ForgottenSong{}
+	}
+	c.JSON(http.StatusOK, songs)
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+const (
+	// replayGainReference ReplayGain 2.0 的参考响度
+	replayGainReference = -18.0
+	// maxGainDb 限制补偿范围，避免几乎无声的音轨被放大到失真
+	maxGainDb = 24.0
+	// gainQueueSize 等待分析的歌曲数上限，超出时由下次启动的补齐任务处理
+	gainQueueSize = 1024
+)
+
+// integratedLoudness 匹配 ebur128 滤镜摘要中的整体响度，例如 "I:         -14.2 LUFS"
+var integratedLoudness = regexp.MustCompile(`I:\s+(-?[0-9.]+|-inf) LUFS`)
+
+// measureGain 用 ffmpeg 的 ebur128 滤镜测量整体响度，返回相对参考响度的补偿值
+// 只解码不转码，比重新生成 HLS 快得多
+func measureGain(ctx context.Context, input string) (float64, error) {
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-nostats",
+		"-i", input,
+		"-vn",
+		"-af", "ebur128=framelog=quiet",
+		"-f", "null",
+		"-",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("ffmpeg error: %v", err)
+	}
+	// 摘要在输出末尾，取最后一次匹配
+	matches := integratedLoudness.FindAllStringSubmatch(stderr.String(), -1)
+	if len(matches) == 0 {
+		return 0, errors.New("no loudness summary in ffmpeg output")
+	}
+	loudness, err := strconv.ParseFloat(matches[len(matches)-1][1], 64)
+	if err != nil {
+		// -inf：整首都是静音，不做补偿
+		return 0, nil
+	}
+	gain := math.Max(-maxGainDb, math.Min(maxGainDb, replayGainReference-loudness))
+	// 保留两位小数，与 ReplayGain 标签的精度一致
+	return math.Round(gain*100) / 100, nil
+}
+
+// queueGainAnalysis 把歌曲放入分析队列，队列满时跳过
+func (a *API) queueGainAnalysis(songID string) {
+	if !a.ffmpeg {
+		return // 测量需要 ffmpeg
+	}
+	select {
+	case a.gainQueue <- songID:
+	default:
+		log.Printf("Gain analysis queue is full, song %s will be analyzed on next start", songID)
+	}
+}
+
+// analyzeGain 逐个测量歌曲响度，一次只运行一个 ffmpeg，避免影响上传和转码
+func (a *API) analyzeGain() {
+	for songID := range a.gainQueue {
+		song, err := a.db.GetSong(songID)
+		if err != nil || song.GainDb != nil || song.StreamURL != "" {
+			continue // 已被删除、已有补偿或不在本地
+		}
+		input := a.mediaInput(song)
+		if input == "" {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), a.transcodeTimeout())
+		gain, err := measureGain(ctx, input)
+		cancel()
+		if err != nil {
+			log.Printf("Gain analysis of song %s failed: %v", songID, err)
+			continue
+		}
+		if err := a.state.SetSongGain(songID, gain); err != nil {
+			log.Printf("Failed to save gain of song %s: %v", songID, err)
+			continue
+		}
+		log.Printf("Gain analyzed: %s %+.2f dB", song.Title, gain)
+	}
+}
+
+// mediaInput 优先使用原地引用的源文件，否则使用已生成的 HLS，用于响度分析和重新扫描标签
+func (a *API) mediaInput(song *db.Song) string {
+	if song.SourcePath != "" {
+		if _, err := os.Stat(song.SourcePath); err == nil {
+			return song.SourcePath
+		}
+	}
+	if song.Unavailable {
+		return ""
+	}
+	p := filepath.Join(a.mediaDir, filepath.FromSlash(song.FilePath))
+	if _, err := os.Stat(p); err != nil {
+		return ""
+	}
+	return p
+}
+
+// BackfillGain 为已有曲库中还没有音量补偿的歌曲排队分析，无需重新转码
+func (a *API) BackfillGain() {
+	if !a.ffmpeg {
+		return
+	}
+	songs, err := a.db.GetSongsWithoutGain()
+	if err != nil {
+		log.Printf("Failed to load songs without gain: %v", err)
+		return
+	}
+	if len(songs) == 0 {
+		return
+	}
+	log.Printf("Analyzing loudness of %d songs in the background", len(songs))
+	for _, song := range songs {
+		// 阻塞等待队列空位，补齐任务不需要跳过
+		a.gainQueue <- song.ID
+	}
+}
@@ -0,0 +1,660 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/state"
+)
+
+// GraphQL 接口：一次请求即可按需获取曲库、专辑、播放列表、历史和统计，
+// 以 Accept: text/event-stream 发送 subscription 可以持续接收状态更新（SSE）
+const graphqlSchema = `
+schema {
+	query: Query
+	subscription: Subscription
+}
+
+type Query {
+	songs(filter: SongFilter, first: Int = 50, offset: Int = 0): SongPage!
+	song(id: ID!): Song
+	albums(artist: String, first: Int = 50, offset: Int = 0): [Album!]!
+	artists(search: String, first: Int = 50, offset: Int = 0): [Artist!]!
+	playlist: [PlaylistItem!]!
+	history(first: Int = 50, offset: Int = 0, session: ID): [Play!]!
+	stats: Stats!
+}
+
+type Subscription {
+	state: PlayerState!
+}
+
+input SongFilter {
+	search: String
+	artist: String
+	album: String
+	genre: String
+	explicit: Boolean
+	year: Int
+	decade: Int
+}
+
+type SongPage {
+	totalCount: Int!
+	items: [Song!]!
+}
+
+type Song {
+	id: ID!
+	title: String!
+	artist: String!
+	album: String!
+	albumArtist: String
+	compilation: Boolean!
+	genre: String!
+	year: Int
+	durationMs: Int!
+	explicit: Boolean!
+	gainDb: Float
+	chapters: [Chapter!]!
+	skipRegions: [SkipRegion!]!
+	credits: [Credit!]!
+}
+
+type Credit {
+	artistId: ID!
+	name: String!
+	role: String!
+}
+
+type SkipRegion {
+	startMs: Float!
+	endMs: Float!
+	label: String!
+}
+
+type Chapter {
+	index: Int!
+	title: String!
+	startMs: Float!
+	endMs: Float!
+}
+
+type Album {
+	title: String!
+	artist: String!
+	compilation: Boolean!
+	durationMs: Float!
+	songs: [Song!]!
+}
+
+type Artist {
+	id: ID!
+	name: String!
+	songCount: Int!
+	featuredCount: Int!
+	songs: [Song!]!
+}
+
+type PlaylistItem {
+	song: Song!
+	addedBy: String!
+	estimatedStartAt: String
+}
+
+type Play {
+	song: Song!
+	requestedBy: String!
+	playedAt: String!
+}
+
+type Stats {
+	songCount: Int!
+	totalDurationMs: Float!
+	albumCount: Int!
+	artistCount: Int!
+	playCount: Float!
+	userCount: Int!
+}
+
+type PlayerState {
+	status: String!
+	isPlaying: Boolean!
+	currentSong: Song
+	progressMs: Float!
+	playbackRate: Float!
+	playlist: [PlaylistItem!]!
+	playlistVersion: Float!
+}
+`
+
+// graphqlMaxPageSize 单次查询最多返回的条数
+const graphqlMaxPageSize = 500
+
+// GraphQLRequest 是标准的 GraphQL 请求体
+type GraphQLRequest struct {
+	Query         string                 `json:"query" binding:"required"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+func newGraphQLSchema(a *API) *graphql.Schema {
+	return graphql.MustParseSchema(graphqlSchema, &gqlResolver{a: a})
+}
+
+// handleGraphQL 执行 GraphQL 查询，请求 text/event-stream 时以 SSE 推送订阅结果
+func (a *API) handleGraphQL(c *gin.Context) {
+	var req GraphQLRequest
+	if c.Request.Method == http.MethodGet {
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+		if vars := c.Query("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid variables"})
+				return
+			}
+		}
+	} else if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "query is required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if !strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		c.JSON(http.StatusOK, a.graphql.Exec(ctx, req.Query, req.OperationName, req.Variables))
+		return
+	}
+	responses, err := a.graphql.Subscribe(ctx, req.Query, req.OperationName, req.Variables)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "no-cache")
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case resp, ok := <-responses:
+			if !ok {
+				c.SSEvent("complete", "")
+				return false
+			}
+			c.SSEvent("next", resp)
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+}
+
+// --- 解析器 ---
+
+type gqlResolver struct {
+	a *API
+}
+
+type songFilter struct {
+	Search   *string
+	Artist   *string
+	Album    *string
+	Genre    *string
+	Explicit *bool
+	Year     *int32
+	// Decade 年代的起始年份，例如 1990
+	Decade *int32
+}
+
+func (f *songFilter) match(song *db.Song) bool {
+	if f == nil {
+		return true
+	}
+	if f.Search != nil {
+		q := strings.ToLower(*f.Search)
+		if !strings.Contains(strings.ToLower(song.Title), q) &&
+			!strings.Contains(strings.ToLower(song.Artist), q) &&
+			!strings.Contains(strings.ToLower(song.Album), q) {
+			return false
+		}
+	}
+	if f.Artist != nil && !song.CreditedTo(*f.Artist) {
+		return false
+	}
+	if f.Album != nil && !strings.EqualFold(song.Album, *f.Album) {
+		return false
+	}
+	if f.Genre != nil && !strings.EqualFold(song.Genre, *f.Genre) {
+		return false
+	}
+	if f.Explicit != nil && song.Explicit != *f.Explicit {
+		return false
+	}
+	if f.Year != nil && song.Year != int(*f.Year) {
+		return false
+	}
+	if f.Decade != nil && !db.InYearRange(song.Year, int(*f.Decade)/10*10, 0, 0) {
+		return false
+	}
+	return true
+}
+
+// page 把 first/offset 参数换算成切片范围
+func page(total int, first, offset int32) (int, int) {
+	if first < 0 || first > graphqlMaxPageSize {
+		first = graphqlMaxPageSize
+	}
+	start := int(offset)
+	if start < 0 {
+		start = 0
+	}
+	if start > total {
+		start = total
+	}
+	end := start + int(first)
+	if end > total {
+		end = total
+	}
+	return start, end
+}
+
+type songPage struct {
+	total int
+	items []*gqlSong
+}
+
+func (p *songPage) TotalCount() int32 { return int32(p.total) }
+func (p *songPage) Items() []*gqlSong { return p.items }
+
+func (r *gqlResolver) Songs(args struct {
+	Filter *songFilter
+	First  int32
+	Offset int32
+}) (*songPage, error) {
+	songs, err := r.a.db.GetAllSongs()
+	if err != nil {
+		return nil, err
+	}
+	var matched []*gqlSong
+	for i := range songs {
+		if args.Filter.match(&songs[i]) {
+			matched = append(matched, &gqlSong{&songs[i]})
+		}
+	}
+	start, end := page(len(matched), args.First, args.Offset)
+	return &songPage{total: len(matched), items: matched[start:end]}, nil
+}
+
+func (r *gqlResolver) Song(args struct{ ID graphql.ID }) (*gqlSong, error) {
+	song, err := r.a.db.GetSong(string(args.ID))
+	if err != nil {
+		return nil, nil
+	}
+	return &gqlSong{song}, nil
+}
+
+func (r *gqlResolver) Albums(args struct {
+	Artist *string
+	First  int32
+	Offset int32
+}) ([]*gqlAlbum, error) {
+	songs, err := r.a.db.GetAllSongs()
+	if err != nil {
+		return nil, err
+	}
+	albums := groupAlbums(songs)
+	if args.Artist != nil {
+		filtered := albums[:0]
+		for _, album := range albums {
+			if album.creditedTo(*args.Artist) {
+				filtered = append(filtered, album)
+			}
+		}
+		albums = filtered
+	}
+	start, end := page(len(albums), args.First, args.Offset)
+	return albums[start:end], nil
+}
+
+// groupAlbums 按专辑名和专辑歌手分组，没有专辑歌手时按主唱分组，
+// 合辑中不同歌手的歌曲归入同一张专辑，没有专辑名的歌曲不计入
+func groupAlbums(songs []db.Song) []*gqlAlbum {
+	byKey := make(map[string]*gqlAlbum)
+	var albums []*gqlAlbum
+	for i := range songs {
+		song := &songs[i]
+		if song.Album == "" {
+			continue
+		}
+		artist := song.AlbumGroupArtist()
+		key := strings.ToLower(song.Album) + "\x00" + db.ArtistKey(artist)
+		album, ok := byKey[key]
+		if !ok {
+			album = &gqlAlbum{title: song.Album, artist: artist, compilation: song.Compilation}
+			byKey[key] = album
+			albums = append(albums, album)
+		}
+		album.songs = append(album.songs, &gqlSong{song})
+	}
+	sort.Slice(albums, func(i, j int) bool { return strings.ToLower(albums[i].title) < strings.ToLower(albums[j].title) })
+	return albums
+}
+
+func (r *gqlResolver) Artists(args struct {
+	Search *string
+	First  int32
+	Offset int32
+}) ([]*gqlArtist, error) {
+	songs, err := r.a.db.GetAllSongs()
+	if err != nil {
+		return nil, err
+	}
+	artists := groupArtists(songs)
+	if args.Search != nil {
+		q := strings.ToLower(*args.Search)
+		filtered := artists[:0]
+		for _, artist := range artists {
+			if strings.Contains(strings.ToLower(artist.artist.Name), q) {
+				filtered = append(filtered, artist)
+			}
+		}
+		artists = filtered
+	}
+	start, end := page(len(artists), args.First, args.Offset)
+	return artists[start:end], nil
+}
+
+// groupArtists 按署名汇总每位歌手的歌曲，同一位歌手的不同写法已在入库时合并
+func groupArtists(songs []db.Song) []*gqlArtist {
+	byID := make(map[uint]*gqlArtist)
+	var artists []*gqlArtist
+	for i := range songs {
+		song := &songs[i]
+		for _, credit := range song.Credits {
+			if credit.Artist == nil {
+				continue
+			}
+			artist, ok := byID[credit.ArtistID]
+			if !ok {
+				artist = &gqlArtist{artist: credit.Artist}
+				byID[credit.ArtistID] = artist
+				artists = append(artists, artist)
+			}
+			if credit.Role == db.CreditFeatured {
+				artist.featured++
+			}
+			artist.songs = append(artist.songs, &gqlSong{song})
+		}
+	}
+	sort.Slice(artists, func(i, j int) bool { return artists[i].artist.Key < artists[j].artist.Key })
+	return artists
+}
+
+func (r *gqlResolver) Playlist() []*gqlPlaylistItem {
+	return playlistItems(r.a.state.Snapshot().Playlist)
+}
+
+func (r *gqlResolver) History(args struct {
+	First   int32
+	Offset  int32
+	Session *graphql.ID
+}) ([]*gqlPlay, error) {
+	start, end := page(int(args.Offset)+graphqlMaxPageSize, args.First, args.Offset)
+	var sessionID uint64
+	if args.Session != nil {
+		id, err := strconv.ParseUint(string(*args.Session), 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid session ID")
+		}
+		sessionID = id
+	}
+	plays, err := r.a.db.RecentPlays(start, end-start, uint(sessionID))
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*gqlPlay, len(plays))
+	for i := range plays {
+		result[i] = &gqlPlay{&plays[i]}
+	}
+	return result, nil
+}
+
+func (r *gqlResolver) Stats() (*gqlStats, error) {
+	songs, err := r.a.db.GetAllSongs()
+	if err != nil {
+		return nil, err
+	}
+	plays, err := r.a.db.CountPlays()
+	if err != nil {
+		return nil, err
+	}
+	users, err := r.a.db.CountUsers()
+	if err != nil {
+		return nil, err
+	}
+	stats := &gqlStats{
+		songCount:   int32(len(songs)),
+		albumCount:  int32(len(groupAlbums(songs))),
+		artistCount: int32(len(groupArtists(songs))),
+		playCount:   float64(plays),
+		userCount:   int32(users),
+	}
+	for _, song := range songs {
+		stats.totalDurationMs += float64(song.DurationMs)
+	}
+	return stats, nil
+}
+
+// State 订阅播放器状态：先推送当前状态，之后每次广播推送一次
+func (r *gqlResolver) State(ctx context.Context) <-chan *gqlPlayerState {
+	updates, cancel := r.a.state.Subscribe()
+	out := make(chan *gqlPlayerState)
+	go func() {
+		defer close(out)
+		defer cancel()
+		snapshot := r.a.state.Snapshot()
+		for {
+			select {
+			case out <- &gqlPlayerState{snapshot}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case next, ok := <-updates:
+				if !ok {
+					return
+				}
+				snapshot = next
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+type gqlSong struct{ s *db.Song }
+
+func (s *gqlSong) ID() graphql.ID    { return graphql.ID(s.s.ID) }
+func (s *gqlSong) Title() string     { return s.s.Title }
+func (s *gqlSong) Artist() string    { return s.s.Artist }
+func (s *gqlSong) Album() string     { return s.s.Album }
+func (s *gqlSong) Compilation() bool { return s.s.Compilation }
+func (s *gqlSong) Genre() string     { return s.s.Genre }
+func (s *gqlSong) DurationMs() int32 { return int32(s.s.DurationMs) }
+func (s *gqlSong) Explicit() bool    { return s.s.Explicit }
+func (s *gqlSong) GainDb() *float64  { return s.s.GainDb }
+func (s *gqlSong) Year() *int32 {
+	if s.s.Year == 0 {
+		return nil
+	}
+	year := int32(s.s.Year)
+	return &year
+}
+func (s *gqlSong) AlbumArtist() *string {
+	if s.s.AlbumArtist == "" {
+		return nil
+	}
+	return &s.s.AlbumArtist
+}
+
+func (s *gqlSong) Chapters() []*gqlChapter {
+	chapters := make([]*gqlChapter, len(s.s.Chapters))
+	for i := range s.s.Chapters {
+		chapters[i] = &gqlChapter{&s.s.Chapters[i]}
+	}
+	return chapters
+}
+
+func (s *gqlSong) Credits() []*gqlCredit {
+	credits := make([]*gqlCredit, 0, len(s.s.Credits))
+	for i := range s.s.Credits {
+		if s.s.Credits[i].Artist != nil {
+			credits = append(credits, &gqlCredit{&s.s.Credits[i]})
+		}
+	}
+	return credits
+}
+
+type gqlCredit struct{ c *db.SongCredit }
+
+func (c *gqlCredit) ArtistID() graphql.ID {
+	return graphql.ID(strconv.FormatUint(uint64(c.c.ArtistID), 10))
+}
+func (c *gqlCredit) Name() string { return c.c.Artist.Name }
+func (c *gqlCredit) Role() string { return c.c.Role }
+
+func (s *gqlSong) SkipRegions() []*gqlSkipRegion {
+	regions := make([]*gqlSkipRegion, len(s.s.SkipRegions))
+	for i := range s.s.SkipRegions {
+		regions[i] = &gqlSkipRegion{&s.s.SkipRegions[i]}
+	}
+	return regions
+}
+
+type gqlSkipRegion struct{ r *db.SkipRegion }
+
+func (r *gqlSkipRegion) StartMs() float64 { return float64(r.r.StartMs) }
+func (r *gqlSkipRegion) EndMs() float64   { return float64(r.r.EndMs) }
+func (r *gqlSkipRegion) Label() string    { return r.r.Label }
+
+type gqlChapter struct{ c *db.Chapter }
+
+func (c *gqlChapter) Index() int32     { return int32(c.c.Index) }
+func (c *gqlChapter) Title() string    { return c.c.Title }
+func (c *gqlChapter) StartMs() float64 { return float64(c.c.StartMs) }
+func (c *gqlChapter) EndMs() float64   { return float64(c.c.EndMs) }
+
+type gqlAlbum struct {
+	title       string
+	artist      string
+	compilation bool
+	songs       []*gqlSong
+}
+
+func (a *gqlAlbum) Title() string     { return a.title }
+func (a *gqlAlbum) Artist() string    { return a.artist }
+func (a *gqlAlbum) Compilation() bool { return a.compilation }
+func (a *gqlAlbum) Songs() []*gqlSong { return a.songs }
+
+// creditedTo 专辑歌手或其中任意一首歌的署名包含该歌手
+func (a *gqlAlbum) creditedTo(artist string) bool {
+	if db.ArtistKey(a.artist) == db.ArtistKey(artist) {
+		return true
+	}
+	for _, s := range a.songs {
+		if s.s.CreditedTo(artist) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *gqlAlbum) DurationMs() float64 {
+	var total float64
+	for _, s := range a.songs {
+		total += float64(s.s.DurationMs)
+	}
+	return total
+}
+
+type gqlArtist struct {
+	artist   *db.Artist
+	featured int32
+	songs    []*gqlSong
+}
+
+func (a *gqlArtist) ID() graphql.ID       { return graphql.ID(strconv.FormatUint(uint64(a.artist.ID), 10)) }
+func (a *gqlArtist) Name() string         { return a.artist.Name }
+func (a *gqlArtist) SongCount() int32     { return int32(len(a.songs)) }
+func (a *gqlArtist) FeaturedCount() int32 { return a.featured }
+func (a *gqlArtist) Songs() []*gqlSong    { return a.songs }
+
+type gqlPlaylistItem struct{ item db.PlaylistItem }
+
+func (p *gqlPlaylistItem) Song() *gqlSong  { return &gqlSong{p.item.Song} }
+func (p *gqlPlaylistItem) AddedBy() string { return p.item.AddedBy }
+func (p *gqlPlaylistItem) EstimatedStartAt() *string {
+	if p.item.EstimatedStartAt == nil {
+		return nil
+	}
+	at := p.item.EstimatedStartAt.Format(time.RFC3339)
+	return &at
+}
+
+func playlistItems(items []db.PlaylistItem) []*gqlPlaylistItem {
+	result := make([]*gqlPlaylistItem, 0, len(items))
+	for _, item := range items {
+		if item.Song != nil {
+			result = append(result, &gqlPlaylistItem{item})
+		}
+	}
+	return result
+}
+
+type gqlPlay struct{ p *db.RecentPlay }
+
+func (p *gqlPlay) Song() *gqlSong      { return &gqlSong{&p.p.Song} }
+func (p *gqlPlay) RequestedBy() string { return p.p.RequestedBy }
+func (p *gqlPlay) PlayedAt() string    { return p.p.PlayedAt.Format(time.RFC3339) }
+
+type gqlStats struct {
+	songCount       int32
+	totalDurationMs float64
+	albumCount      int32
+	artistCount     int32
+	playCount       float64
+	userCount       int32
+}
+
+func (s *gqlStats) SongCount() int32         { return s.songCount }
+func (s *gqlStats) TotalDurationMs() float64 { return s.totalDurationMs }
+func (s *gqlStats) AlbumCount() int32        { return s.albumCount }
+func (s *gqlStats) ArtistCount() int32       { return s.artistCount }
+func (s *gqlStats) PlayCount() float64       { return s.playCount }
+func (s *gqlStats) UserCount() int32         { return s.userCount }
+
+type gqlPlayerState struct{ s *state.Snapshot }
+
+func (p *gqlPlayerState) Status() string               { return string(p.s.Status) }
+func (p *gqlPlayerState) IsPlaying() bool              { return p.s.IsPlaying }
+func (p *gqlPlayerState) ProgressMs() float64          { return float64(p.s.ProgressMs) }
+func (p *gqlPlayerState) PlaybackRate() float64        { return p.s.PlaybackRate }
+func (p *gqlPlayerState) PlaylistVersion() float64     { return float64(p.s.PlaylistVersion) }
+func (p *gqlPlayerState) Playlist() []*gqlPlaylistItem { return playlistItems(p.s.Playlist) }
+func (p *gqlPlayerState) CurrentSong() *gqlSong {
+	if p.s.CurrentSong == nil {
+		return nil
+	}
+	return &gqlSong{p.s.CurrentSong}
+}
@@ -0,0 +1,322 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+const (
+	// defaultJoinLinkTTL 未指定有效期时加入链接的有效时间，足够覆盖一整晚的派对
+	defaultJoinLinkTTL = 12 * time.Hour
+	maxJoinLinkTTL     = 7 * 24 * time.Hour
+	maxNicknameLength  = 24
+)
+
+var (
+	errJoinLinkInvalid = errors.New("join link is invalid or expired")
+	errJoinLinkFull    = errors.New("join link has reached its guest limit")
+	errNicknameInvalid = errors.New("nickname must be 1-24 printable characters without ':'")
+	errNicknameTaken   = errors.New("nickname is already taken")
+)
+
+// guestRoutes 访客可以访问的接口：浏览曲库、点歌和投票
+var guestRoutes = map[string]bool{
+	"GET /api/graphql":            true,
+	"POST /api/graphql":           true,
+	"GET /api/me/budget":          true,
+	"GET /api/library":            true,
+	"POST /api/playlist/add":      true,
+	"POST /api/playlist/add-many": true,
+	"POST /api/poll/vote":         true,
+	"POST /api/ws/ticket":         true,
+	"POST /api/media/session":     true,
+}
+
+// JoinLink 派对加入链接，适合做成二维码分享
+type JoinLink struct {
+	Token     string    `json:"token"`
+	URL       string    `json:"url"`
+	CreatedBy string    `json:"createdBy"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// MaxGuests 通过该链接加入的访客上限，0 表示不限制
+	MaxGuests int `json:"maxGuests,omitempty"`
+	Guests    int `json:"guests"`
+}
+
+type guestSession struct {
+	secret string
+	link   *JoinLink
+}
+
+// GuestManager 管理加入链接和访客身份，只保存在内存中，派对结束或服务重启后全部失效
+type GuestManager struct {
+	mu       sync.Mutex
+	links    map[string]*JoinLink
+	sessions map[string]*guestSession // 以访客用户名为键
+}
+
+// NewGuestManager 创建一个空的访客管理器
+func NewGuestManager() *GuestManager {
+	return &GuestManager{
+		links:    make(map[string]*JoinLink),
+		sessions: make(map[string]*guestSession),
+	}
+}
+
+// CreateLink 生成一个新的加入链接
+func (g *GuestManager) CreateLink(createdBy string, ttl time.Duration, maxGuests int) (*JoinLink, error) {
+	token, err := randomToken(9)
+	if err != nil {
+		return nil, err
+	}
+	link := &JoinLink{
+		Token:     token,
+		CreatedBy: createdBy,
+		ExpiresAt: time.Now().Add(ttl),
+		MaxGuests: maxGuests,
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pruneLocked(time.Now())
+	g.links[token] = link
+	return link, nil
+}
+
+// Links 返回仍然有效的加入链接，按过期时间排序
+func (g *GuestManager) Links() []JoinLink {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pruneLocked(time.Now())
+	links := make([]JoinLink, 0, len(g.links))
+	for _, link := range g.links {
+		links = append(links, *link)
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].ExpiresAt.Before(links[j].ExpiresAt) })
+	return links
+}
+
+// Join 使用加入链接以给定昵称创建访客身份，返回用户名和密码，有效期与链接相同
+func (g *GuestManager) Join(token, nickname string) (username, secret string, expiresAt time.Time, err error) {
+	nickname = strings.TrimSpace(nickname)
+	if !validNickname(nickname) {
+		return "", "", time.Time{}, errNicknameInvalid
+	}
+	secret, err = randomToken(24)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pruneLocked(time.Now())
+	link, ok := g.links[token]
+	if !ok {
+		return "", "", time.Time{}, errJoinLinkInvalid
+	}
+	if link.MaxGuests > 0 && link.Guests >= link.MaxGuests {
+		return "", "", time.Time{}, errJoinLinkFull
+	}
+	username = db.GuestPrefix + nickname
+	if _, taken := g.sessions[username]; taken {
+		return "", "", time.Time{}, errNicknameTaken
+	}
+	link.Guests++
+	g.sessions[username] = &guestSession{secret: secret, link: link}
+	return username, secret, link.ExpiresAt, nil
+}
+
+// Authenticate 校验访客凭证，访客不存在于数据库中，返回一个临时的用户对象
+func (g *GuestManager) Authenticate(username, secret string) (*db.User, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	session, ok := g.sessions[username]
+	if !ok || time.Now().After(session.link.ExpiresAt) ||
+		subtle.ConstantTimeCompare([]byte(session.secret), []byte(secret)) != 1 {
+		return nil, errors.New("invalid guest credentials")
+	}
+	return &db.User{Username: username, Role: db.RoleGuest}, nil
+}
+
+// Active 判断访客身份是否仍然有效，用于已经建立的 WebSocket 连接
+func (g *GuestManager) Active(username string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	session, ok := g.sessions[username]
+	return ok && time.Now().Before(session.link.ExpiresAt)
+}
+
+// Revoke 作废一个加入链接及通过它加入的所有访客
+func (g *GuestManager) Revoke(token string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	link, ok := g.links[token]
+	if !ok {
+		return false
+	}
+	delete(g.links, token)
+	for username, session := range g.sessions {
+		if session.link == link {
+			delete(g.sessions, username)
+		}
+	}
+	return true
+}
+
+// Close 结束派对：作废所有加入链接和访客，返回被移除的访客数
+func (g *GuestManager) Close() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	guests := len(g.sessions)
+	g.links = make(map[string]*JoinLink)
+	g.sessions = make(map[string]*guestSession)
+	return guests
+}
+
+// pruneLocked 清理过期的链接及其访客
+func (g *GuestManager) pruneLocked(now time.Time) {
+	for token, link := range g.links {
+		if now.After(link.ExpiresAt) {
+			delete(g.links, token)
+		}
+	}
+	for username, session := range g.sessions {
+		if now.After(session.link.ExpiresAt) {
+			delete(g.sessions, username)
+		}
+	}
+}
+
+func validNickname(nickname string) bool {
+	if nickname == "" || utf8.RuneCountInString(nickname) > maxNicknameLength || strings.Contains(nickname, ":") {
+		return false
+	}
+	for _, r := range nickname {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// randomToken 生成 URL 安全的随机字符串，不带填充，方便放进链接和二维码
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// guestRestrictionMiddleware 访客只能访问 guestRoutes 中的接口，需在认证之后使用
+func guestRestrictionMiddleware(c *gin.Context) {
+	if c.GetString("role") == db.RoleGuest && !guestRoutes[c.Request.Method+" "+c.FullPath()] {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Guests can only queue songs and vote"})
+		return
+	}
+	c.Next()
+}
+
+// handleCreateJoinLink 生成派对加入链接，访客用它以昵称加入，无需注册
+func (a *API) handleCreateJoinLink(c *gin.Context) {
+	var payload JoinLinkPayload
+	if err := c.ShouldBindJSON(&payload); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	ttl := defaultJoinLinkTTL
+	if payload.TTLMinutes > 0 {
+		ttl = time.Duration(payload.TTLMinutes) * time.Minute
+	}
+	if ttl > maxJoinLinkTTL || payload.MaxGuests < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ttlMinutes must be at most 7 days and maxGuests must not be negative"})
+		return
+	}
+	link, err := a.guests.CreateLink(c.GetString("username"), ttl, payload.MaxGuests)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate join link"})
+		return
+	}
+	log.Printf("Join link created by %s, expires at %s", link.CreatedBy, link.ExpiresAt.Format(time.RFC3339))
+	result := *link
+	result.URL = joinURL(c, link.Token)
+	c.JSON(http.StatusCreated, result)
+}
+
+// handleGetJoinLinks 列出仍然有效的加入链接
+func (a *API) handleGetJoinLinks(c *gin.Context) {
+	links := a.guests.Links()
+	for i := range links {
+		links[i].URL = joinURL(c, links[i].Token)
+	}
+	c.JSON(http.StatusOK, links)
+}
+
+// handleRevokeJoinLink 作废一个加入链接，通过它加入的访客随之失效
+func (a *API) handleRevokeJoinLink(c *gin.Context) {
+	var payload JoinTokenPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+	if !a.guests.Revoke(payload.Token) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Join link not found"})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// handleCloseParty 结束派对，所有加入链接和访客身份立即失效
+func (a *API) handleCloseParty(c *gin.Context) {
+	removed := a.guests.Close()
+	log.Printf("Party closed by %s, %d guests removed", c.GetString("username"), removed)
+	c.JSON(http.StatusOK, gin.H{"guestsRemoved": removed})
+}
+
+// handleJoinParty 访客凭加入链接和昵称换取临时凭证，之后按 Basic 认证使用
+func (a *API) handleJoinParty(c *gin.Context) {
+	var payload JoinPartyPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token and nickname are required"})
+		return
+	}
+	username, secret, expiresAt, err := a.guests.Join(payload.Token, payload.Nickname)
+	switch {
+	case errors.Is(err, errJoinLinkInvalid):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid or expired join link"})
+		return
+	case errors.Is(err, errJoinLinkFull), errors.Is(err, errNicknameTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errNicknameInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to join party"})
+		return
+	}
+	log.Printf("Guest %s joined the party", username)
+	c.JSON(http.StatusOK, gin.H{
+		"username":  username,
+		"password":  secret,
+		"role":      db.RoleGuest,
+		"expiresAt": expiresAt,
+	})
+}
+
+// joinURL 前端的加入页面地址
+func joinURL(c *gin.Context, token string) string {
+	return requestBaseURL(c) + "/join/" + token
+}
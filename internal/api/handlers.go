@@ -1,19 +1,36 @@
 package api
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"gorm.io/gorm"
+	"io"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gofrs/uuid"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/yeeeck/sync-jukebox/internal/classifier"
+	"github.com/yeeeck/sync-jukebox/internal/cluster"
+	"github.com/yeeeck/sync-jukebox/internal/compress"
+	"github.com/yeeeck/sync-jukebox/internal/config"
+	"github.com/yeeeck/sync-jukebox/internal/csrf"
 	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/federation"
+	"github.com/yeeeck/sync-jukebox/internal/hooks"
+	"github.com/yeeeck/sync-jukebox/internal/i18n"
+	"github.com/yeeeck/sync-jukebox/internal/logfile"
+	"github.com/yeeeck/sync-jukebox/internal/oidc"
+	"github.com/yeeeck/sync-jukebox/internal/playlistimport"
 	"github.com/yeeeck/sync-jukebox/internal/state"
 	"github.com/yeeeck/sync-jukebox/internal/websocket"
 )
@@ -24,15 +41,432 @@ type API struct {
 	hub        *websocket.Hub
 	mediaDir   string
 	keyManager *InvitationKeyManager
+	budget     *BudgetTracker
+	hooks      *hooks.Dispatcher
+	graphql    *graphql.Schema
+	// cluster 多实例模式下的主实例选举，单实例部署时为 nil
+	cluster *cluster.Elector
+	// follower 跟随远程实例播放，importer 从远程实例导入曲库
+	follower *federation.Follower
+	importer *federation.Importer
+	// importCfg 外部歌单导入（Spotify 凭证等）
+	importCfg config.ImportConfig
+	ingestCfg config.IngestConfig
+	// libraryCfg 原地引用允许的目录，references 等待生成 HLS 缓存的引用歌曲
+	libraryCfg config.LibraryConfig
+	// analysisCfg 外部分析 worker 的令牌和租期，见 analysis.go
+	analysisCfg config.AnalysisConfig
+	references  chan *Job
+	// transcodeCfg 已补齐默认值的转码参数，jobs 进行中和最近结束的转码任务
+	transcodeCfg config.TranscodeConfig
+	jobs         *JobManager
+	// ffmpeg 为 false 时不转码，上传的 MP3/FLAC/Ogg 原样保存并直接提供
+	ffmpeg bool
+	// gainQueue 等待测量响度的歌曲
+	gainQueue chan string
+	// classifier 外部流派/情绪分类服务，没有配置时为 nil；classifyQueue 等待分类的歌曲
+	classifier    *classifier.Client
+	classifyQueue chan string
+	// guests 派对加入链接和临时访客
+	guests *GuestManager
+	// rescanning 标签重新扫描正在进行
+	rescanning atomic.Bool
+	// storageMu 串行化播放文件的复用、入库和按引用数删除
+	storageMu sync.Mutex
+	// compressor 响应压缩，配置关闭时为 nil
+	compressor *compress.Compressor
+	// csrf 浏览器修改请求的令牌校验，配置关闭时为 nil
+	csrf *csrf.Protector
+	// oidc 单点登录的身份提供方，没有配置时为 nil；ssoFlows 进行中的登录
+	oidc     *oidc.Provider
+	oidcCfg  config.OIDCConfig
+	ssoFlows *ssoFlows
+	// pairings 等待新设备扫码领取的配对令牌
+	pairings *pairings
+	// proxyAuth 信任认证代理传来的用户名，没有启用时为 nil
+	proxyAuth *proxyAuth
+	// readOnly 本实例是只读副本，见 readonly.go
+	readOnly bool
+	// streams 正在播放的媒体流及每个 IP、用户的数量限制
+	streams *streamLimiter
+	// mediaSigningKey 媒体 Cookie 的签名密钥，为 nil 时不签发，见 mediasession.go
+	mediaSigningKey []byte
+	// bandwidth 每月的媒体流量统计和上限
+	bandwidth *bandwidthMeter
+	// airplay 已连接的 AirPlay 音箱，未开启时为 nil
+	airplay *airplayOutputs
+	// maintenance 夜间维护任务，logFile 由维护任务轮转的日志文件，没有配置时为 nil
+	maintenance *maintenanceScheduler
+	logFile     *logfile.File
+	// startedAt 服务启动的时间，recentErrors 最近的错误日志，usageCache 存储占用的统计，用于管理总览
+	startedAt    time.Time
+	recentErrors *recentErrorLog
+	usageCache   storageUsageCache
+}
+
+// MaintenanceTaskPayload 指定维护任务，打开或关闭定时执行时需要 enabled
+type MaintenanceTaskPayload struct {
+	Task    string `json:"task"`
+	Enabled *bool  `json:"enabled,omitempty"`
+}
+
+// MaintenanceModePayload 开启或关闭维护模式，Message 为客户端显示的说明
+type MaintenanceModePayload struct {
+	Enabled *bool  `json:"enabled"`
+	Message string `json:"message"`
+}
+
+// LanguagePayload 用户的语言设置，空字符串表示跟随浏览器
+type LanguagePayload struct {
+	Language string `json:"language"`
+}
+
+// LanguageSettings 用户的语言设置及本次请求实际使用的语言
+type LanguageSettings struct {
+	Language  string   `json:"language"`
+	Effective string   `json:"effective"`
+	Supported []string `json:"supported"`
+}
+
+// LoginPayload 登录时的可选参数，DeviceName 为空时根据 User-Agent 生成
+type LoginPayload struct {
+	RememberDevice bool   `json:"rememberDevice"`
+	DeviceName     string `json:"deviceName"`
+}
+
+// SSOExchangePayload 单点登录回调带回前端的一次性代码
+type SSOExchangePayload struct {
+	Code string `json:"code"`
+}
+
+// SongTagsPayload 手动设置的流派和情绪，不修改的字段留空，空字符串表示清除
+type SongTagsPayload struct {
+	Genre *string `json:"genre"`
+	Mood  *string `json:"mood"`
+}
+
+// AnalysisLeasePayload 外部分析 worker 领取任务，Worker 为 worker 名称，Max 为最多领取的任务数（默认 1，最多 20）
+type AnalysisLeasePayload struct {
+	Worker string `json:"worker"`
+	Max    int    `json:"max"`
+}
+
+// AnalysisResultPayload 一首歌的分析结果，没有分析的字段留空
+type AnalysisResultPayload struct {
+	BPM *float64 `json:"bpm"`
+	// MusicalKey 可以是 Camelot（8A）、Open Key（1m）或标准记法（Am）
+	MusicalKey *string `json:"musicalKey"`
+	Genre      *string `json:"genre"`
+	// Fingerprint Chromaprint 指纹（fpcalc 的输出）
+	Fingerprint *string `json:"fingerprint"`
+}
+
+// AnalysisFailPayload worker 无法分析时报告的原因
+type AnalysisFailPayload struct {
+	Error string `json:"error"`
+}
+
+// AnalysisEnqueuePayload 加入分析队列的歌曲，Missing 为 true 时加入所有还没有 BPM 的歌曲
+type AnalysisEnqueuePayload struct {
+	SongIDs []string `json:"songIds"`
+	Missing bool     `json:"missing"`
+}
+
+// HACommandPayload Home Assistant 的 media_player 命令，参数名与对应服务的字段一致
+type HACommandPayload struct {
+	Command string `json:"command"`
+	// SeekPosition media_seek 的目标位置（秒）
+	SeekPosition *float64 `json:"seek_position,omitempty"`
+	// VolumeLevel volume_set 的音量，0 到 1
+	VolumeLevel *float64 `json:"volume_level,omitempty"`
+}
+
+// PairPayload 配对二维码中的一次性令牌
+type PairPayload struct {
+	Token string `json:"token"`
+}
+
+// CSRFToken 浏览器修改请求需要在 Header 请求头中携带的令牌，没有启用防护时 Token 为空
+type CSRFToken struct {
+	Token  string `json:"token"`
+	Header string `json:"header"`
+}
+
+type FamilyModePayload struct {
+	Enabled bool `json:"enabled"`
+}
+
+// SettingsPayload 修改房间设置，省略的字段保持不变
+type SettingsPayload struct {
+	CrossfadeMs        *int      `json:"crossfadeMs"`
+	FadeMs             *int      `json:"fadeMs"`
+	FamilyMode         *bool     `json:"familyMode"`
+	QueueMode          *string   `json:"queueMode"`
+	MaxSkipsPerHour    *int      `json:"maxSkipsPerHour"`
+	MaxPendingRequests *int      `json:"maxPendingRequests"`
+	MaxSongMinutes     *int      `json:"maxSongMinutes"`
+	AllowedSources     *[]string `json:"allowedSources"`
+	JingleEvery        *int      `json:"jingleEvery"`
+}
+
+type SongExplicitPayload struct {
+	SongID   string `json:"songId"`
+	Explicit bool   `json:"explicit"`
+}
+
+type SongJinglePayload struct {
+	SongID string `json:"songId"`
+	Jingle bool   `json:"jingle"`
+}
+
+type BlocklistAddPayload struct {
+	SongID        string `json:"songId"`
+	ArtistPattern string `json:"artistPattern"`
+	Reason        string `json:"reason"`
+}
+
+type BlocklistRemovePayload struct {
+	ID uint `json:"id" binding:"required"`
+}
+
+// AnnouncementPayload 管理员发布的公告，Level 为 info 或 warning，ExpiresAt 为空时一直显示到被清除
+type AnnouncementPayload struct {
+	Message   string     `json:"message"`
+	Level     string     `json:"level"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// DropPayload 预定在 At 时刻开始播放的歌曲
+type DropPayload struct {
+	SongID string     `json:"songId" binding:"required"`
+	At     *time.Time `json:"at" binding:"required"`
+}
+
+// SongReportPayload 举报歌曲的理由
+type SongReportPayload struct {
+	Reason string `json:"reason"`
+}
+
+// SongCommentPayload 评论的内容，Flash 为 true 时歌曲开始播放时向客户端闪现
+type SongCommentPayload struct {
+	Text  string `json:"text"`
+	Flash bool   `json:"flash"`
+}
+
+// CommentHidePayload 隐藏或恢复评论
+type CommentHidePayload struct {
+	Hidden *bool `json:"hidden" binding:"required"`
+}
+
+// ReportResolvePayload 管理员处理举报的方式：dismiss、blocklist 或 delete
+type ReportResolvePayload struct {
+	Action string `json:"action"`
+}
+
+type PollStartPayload struct {
+	SongIDs     []string `json:"songIds" binding:"required"`
+	DurationSec int      `json:"durationSec"` // 0 表示使用默认时长
+}
+
+type PollVotePayload struct {
+	SongID string `json:"songId" binding:"required"`
+}
+
+type UserRolePayload struct {
+	Username string `json:"username" binding:"required"`
+	Role     string `json:"role"     binding:"required"`
+}
+
+type PlaylistAddAtPayload struct {
+	SongID string `json:"songId" binding:"required"`
+	Index  *int   `json:"index"  binding:"required"`
+}
+
+// LibraryBulkPayload 对多首歌曲的批量操作，Action 为 delete、retag 或 add-to-playlist
+type LibraryBulkPayload struct {
+	Action  string   `json:"action"  binding:"required"`
+	SongIDs []string `json:"songIds" binding:"required"`
+	// Artist、AlbumArtist 和 Genre 仅用于 retag，为空表示不修改
+	// AlbumArtist 设为 "Various Artists" 时标记为合辑，设为空字符串时清除
+	Artist      *string `json:"artist"`
+	AlbumArtist *string `json:"albumArtist"`
+	Genre       *string `json:"genre"`
+}
+
+type PlaylistAddManyPayload struct {
+	SongIDs []string `json:"songIds" binding:"required"`
+}
+
+// PlaylistReorderPayload 完整的目标顺序，BaseVersion 为客户端看到的 playlistVersion
+type PlaylistReorderPayload struct {
+	SongIDs     []string `json:"songIds"     binding:"required"`
+	BaseVersion int64    `json:"baseVersion"`
+}
+
+type QueueModePayload struct {
+	Mode state.QueueMode `json:"mode" binding:"required"`
+}
+
+// PlayModePayload Mode 为 REPEAT_ALL、REPEAT_ONE、SHUFFLE 或 AUTO_DJ
+type PlayModePayload struct {
+	Mode state.PlayMode `json:"mode" binding:"required"`
+}
+
+type DeviceVolumePayload struct {
+	DeviceID string   `json:"deviceId" binding:"required"`
+	Volume   *float64 `json:"volume"   binding:"required"`
+}
+
+// TransferPlaybackPayload 转移播放的目标设备，为空表示恢复所有输出设备一起发声
+type TransferPlaybackPayload struct {
+	DeviceID string `json:"deviceId"`
 }
 
 type SeekPayload struct {
 	PositionMs int64 `json:"positionMs"`
+	// Scrubbing 为 true 表示仍在拖动进度条，只更新其他客户端看到的预览位置，松开时再发送 false
+	Scrubbing bool `json:"scrubbing"`
+}
+
+type PlaybackRatePayload struct {
+	Rate float64 `json:"rate" binding:"required"`
+}
+
+// EqualizerPayload 切换均衡器预设，preset 为 CUSTOM 时 gains 依次给出每个频段的增益（dB）
+type EqualizerPayload struct {
+	Preset state.EQPreset `json:"preset" binding:"required"`
+	Gains  []float64      `json:"gains"`
+}
+
+// SkipRegionsPayload 替换歌曲的全部跳过区间，空数组表示清除
+type SkipRegionsPayload struct {
+	Regions []SkipRegionInput `json:"regions"`
+}
+
+type SkipRegionInput struct {
+	StartMs int64  `json:"startMs"`
+	EndMs   int64  `json:"endMs"   binding:"required"`
+	Label   string `json:"label"`
+}
+
+// SeekChapterPayload 跳转章节：指定 Index 直接跳转，或通过 Direction ("next"/"prev") 相对跳转
+type SeekChapterPayload struct {
+	Index     *int   `json:"index"`
+	Direction string `json:"direction"`
+}
+
+// SongIDPayload 只包含一首歌曲 ID 的请求体
+type SongIDPayload struct {
+	SongID string `json:"songId"`
+}
+
+// FederationFollowPayload 要跟随的远程实例及其账号（账号可以为空）
+type FederationFollowPayload struct {
+	URL      string `json:"url"      binding:"required"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// ImportRemotePayload 要导入的远程实例，APIKey 为远程实例上的 "username:password"
+// Reference 为 true 时不复制媒体文件，播放时直接从远程拉流
+type ImportRemotePayload struct {
+	URL       string `json:"url"    binding:"required"`
+	APIKey    string `json:"apiKey"`
+	Reference bool   `json:"reference"`
+}
+
+// ImportRemoteResult 导入结果，包括合并到本地播放列表的歌曲数
+type ImportRemoteResult struct {
+	federation.ImportResult
+	PlaylistAdded int    `json:"playlistAdded"`
+	PlaylistError string `json:"playlistError,omitempty"`
+}
+
+// PlaylistImportPayload 外部歌单链接，Name 为空时使用原歌单名
+type PlaylistImportPayload struct {
+	URL  string `json:"url"  binding:"required"`
+	Name string `json:"name"`
+}
+
+// PlaylistImportResult 导入后创建的歌单，以及本地曲库中找不到的歌曲
+type PlaylistImportResult struct {
+	Playlist  *db.SavedPlaylist      `json:"playlist"`
+	Matched   int                    `json:"matched"`
+	Unmatched []playlistimport.Track `json:"unmatched"`
+}
+
+// SmartPlaylistPayload 智能歌单的名称和选歌条件，例如 {"name": "90s night", "rules": {"decade": 1990, "shuffle": true}}
+type SmartPlaylistPayload struct {
+	Name  string        `json:"name"  binding:"required"`
+	Rules db.SmartRules `json:"rules"`
+}
+
+// GeneratePlaylistPayload 按情绪生成歌单，例如 {"mood": "chill", "minutes": 60}
+// Action 为 load（默认，加入播放列表）、save（保存为命名歌单，名称默认为“<情绪> mix”）或 preview（只返回选出的歌曲）
+type GeneratePlaylistPayload struct {
+	Mood    string `json:"mood" binding:"required"`
+	Minutes int    `json:"minutes"`
+	Action  string `json:"action"`
+	Name    string `json:"name"`
+}
+
+// GeneratedPlaylist 按情绪选出的歌曲，保存时 Playlist 为新建的歌单，加入播放列表时 Added 为加入的歌曲数
+type GeneratedPlaylist struct {
+	Mood       string            `json:"mood"`
+	Songs      []db.Song         `json:"songs"`
+	DurationMs int64             `json:"durationMs"`
+	Playlist   *db.SavedPlaylist `json:"playlist,omitempty"`
+	Added      int               `json:"added,omitempty"`
+	Budget     *Budget           `json:"budget,omitempty"`
+}
+
+type SavedPlaylistIDPayload struct {
+	PlaylistID uint `json:"playlistId" binding:"required"`
+}
+
+type ImportFileURLPayload struct {
+	URL string `json:"url" binding:"required"`
+}
+
+// TrashedSong 回收站中的歌曲，PurgeAt 之后会被永久删除
+type TrashedSong struct {
+	db.Song
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
+
+// SongDetail 歌曲详情，Technical 为空表示歌曲在记录技术信息之前入库
+type SongDetail struct {
+	db.Song
+	Technical *db.TechnicalInfo `json:"technical"`
+	Comments  []db.SongComment  `json:"comments"`
+}
+
+// LibraryReferencePayload 原地引用的文件或目录，必须是绝对路径
+type LibraryReferencePayload struct {
+	Path string `json:"path" binding:"required"`
+}
+
+// LibraryReferenceResult 新登记的歌曲在 HLS 缓存生成前处于 unavailable 状态
+type LibraryReferenceResult struct {
+	Added   []db.Song `json:"added"`
+	Skipped int       `json:"skipped"`
 }
 
 type PlaySpecificPayload struct {
 	SongID string `json:"songId"`
 }
+
+// SkipPayload 切歌的原因，可以省略：bad_quality、wrong_vibe 或 duplicate
+type SkipPayload struct {
+	Reason string `json:"reason"`
+}
+
+// PlayFromPayload Index 为播放列表中的位置，从 0 开始；Reason 与 SkipPayload 相同，切走当前歌曲时记录
+type PlayFromPayload struct {
+	Index  *int   `json:"index" binding:"required"`
+	Reason string `json:"reason,omitempty"`
+}
 type ReorderPlaylistPayload struct {
 	SongID   string `json:"songId"`
 	NewIndex int    `json:"newIndex"`
@@ -43,55 +477,290 @@ type AuthPayload struct {
 	Password string `json:"password" binding:"required"`
 }
 
+type StartSessionPayload struct {
+	Name string `json:"name"`
+}
+
+// JoinLinkPayload 生成加入链接的参数，均可省略
+type JoinLinkPayload struct {
+	TTLMinutes int `json:"ttlMinutes"`
+	MaxGuests  int `json:"maxGuests"`
+}
+
+type JoinTokenPayload struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type JoinPartyPayload struct {
+	Token    string `json:"token"    binding:"required"`
+	Nickname string `json:"nickname" binding:"required"`
+}
+
 type RegisterPayload struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
 	Key      string `json:"key"      binding:"required"` // 前端发送的邀请密钥
 }
 
-func New(db *db.DB, state *state.Manager, hub *websocket.Hub, mediaDir string, keyManager *InvitationKeyManager) *API {
-	return &API{db, state, hub, mediaDir, keyManager}
+func New(db *db.DB, state *state.Manager, hub *websocket.Hub, mediaDir string, keyManager *InvitationKeyManager, cfg *config.Config, hookDispatcher *hooks.Dispatcher) *API {
+	a := &API{
+		db:            db,
+		state:         state,
+		hub:           hub,
+		mediaDir:      mediaDir,
+		keyManager:    keyManager,
+		budget:        NewBudgetTracker(fairnessLimits(state.Settings())),
+		hooks:         hookDispatcher,
+		follower:      federation.NewFollower(db, state),
+		importer:      federation.NewImporter(db, mediaDir),
+		importCfg:     cfg.Import,
+		ingestCfg:     cfg.Ingest,
+		libraryCfg:    cfg.Library,
+		analysisCfg:   cfg.Analysis,
+		references:    make(chan *Job, referenceQueueSize),
+		gainQueue:     make(chan string, gainQueueSize),
+		classifyQueue: make(chan string, classifyQueueSize),
+		guests:        NewGuestManager(),
+		jobs:          NewJobManager(),
+		ffmpeg:        ffmpegAvailable(),
+		readOnly:      cfg.ReadOnly,
+		streams:       newStreamLimiter(cfg.Streams),
+		bandwidth:     newBandwidthMeter(cfg.Bandwidth, !cfg.ReadOnly),
+
+		startedAt:    time.Now(),
+		recentErrors: newRecentErrorLog(maxRecentErrors),
+	}
+	// 之后的日志同时保留最近的错误，管理总览据此显示
+	log.SetOutput(io.MultiWriter(log.Writer(), a.recentErrors))
+	transcodeCfg, err := transcodeSettings(cfg.Transcode)
+	if err != nil {
+		log.Printf("Warning: Invalid transcode config, using defaults: %v", err)
+		transcodeCfg, _ = transcodeSettings(config.TranscodeConfig{})
+	}
+	a.transcodeCfg = transcodeCfg
+	if a.compressor, err = compress.New(cfg.Compression); err != nil {
+		log.Printf("Warning: Invalid compression config, using default encodings: %v", err)
+		fallback := cfg.Compression
+		fallback.Encodings = nil
+		a.compressor, _ = compress.New(fallback)
+	}
+	if a.csrf, err = csrf.New(cfg.CSRF); err != nil {
+		log.Printf("Warning: Invalid CSRF config, using defaults: %v", err)
+		a.csrf, _ = csrf.New(config.CSRFConfig{})
+	}
+	if a.oidc, err = oidc.New(cfg.OIDC); err != nil {
+		log.Printf("Warning: Invalid OIDC config, single sign-on is disabled: %v", err)
+	}
+	// 单点登录需要签发登录令牌，只读副本不写数据库
+	if a.readOnly && a.oidc != nil {
+		log.Println("Single sign-on is disabled on a read-only replica")
+		a.oidc = nil
+	}
+	a.oidcCfg = cfg.OIDC
+	if cfg.AirPlay.Enabled {
+		a.airplay = newAirPlayOutputs()
+	}
+	if a.classifier, err = classifier.New(cfg.Ingest.Classifier); err != nil {
+		log.Printf("Warning: Invalid classifier config, genre classification is disabled: %v", err)
+	}
+	if a.proxyAuth, err = newProxyAuth(cfg.ProxyAuth); err != nil {
+		log.Printf("Warning: Invalid proxy auth config, proxy authentication is disabled: %v", err)
+	}
+	a.ssoFlows = newSSOFlows()
+	a.pairings = newPairings()
+	a.mediaSigningKey = a.loadMediaSigningKey()
+	a.maintenance = a.newMaintenanceScheduler(cfg.Maintenance)
+	a.jobs.OnUpdate(func(job Job) { a.hub.BroadcastEvent(EventJobProgress, job) })
+	a.graphql = newGraphQLSchema(a)
+	go a.transcodeReferences()
+	go a.analyzeGain()
+	go a.classifySongs()
+	go a.bandwidthLoop()
+	if cfg.DLNA.Enabled {
+		go a.serveDLNA(cfg.DLNA)
+	}
+	// 只读副本的曲库由主实例维护
+	if !a.readOnly {
+		go a.purgeTrashLoop()
+	}
+	if !cfg.Maintenance.Disabled && !a.readOnly {
+		go a.maintenanceLoop()
+	}
+	if fed := cfg.Federation; fed.FollowURL != "" {
+		if err := a.follower.Start(fed.FollowURL, fed.Username, fed.Password); err != nil {
+			log.Printf("Warning: Failed to follow %s: %v", fed.FollowURL, err)
+		}
+	} else if a.readOnly {
+		log.Println("Warning: Read-only replica is not following a primary (federation.followUrl), playback will not change")
+	}
+	// 处理客户端通过 WebSocket 发来的投票等消息
+	hub.OnMessage(a.handleWSMessage)
+	hub.OnDisconnect(a.handleWSDisconnect)
+	return a
 }
 
 // RegisterRoutes 注册 Gin 路由
 func (a *API) RegisterRoutes(router *gin.Engine) {
 
 	// Static files
-	router.Static("/static/audio", a.mediaDir)
+	// HLS 索引是文本，多码率、长歌曲的索引压缩后小很多；切片和原始音频不在压缩的内容类型中
+	// 统计流量并检查每月上限，限制每个 IP 和用户同时播放的流数量
+	router.Group("/static/audio", a.bandwidthMiddleware(), a.streamLimitMiddleware(), a.compressor.Middleware()).Static("/", a.mediaDir)
 
 	// API Group
 	apiGroup := router.Group("/api")
+	// 错误信息按用户设置或 Accept-Language 翻译，需在压缩之后（内层）执行
+	apiGroup.Use(a.compressor.Middleware(), a.leaderProxyMiddleware(), i18n.Middleware(), a.csrf.Middleware(), a.readOnlyMiddleware(), a.maintenanceModeMiddleware())
 	{
 		// Web Sockets
 		// WebSocket 通常需要直接操作 http.ResponseWriter 和 *http.Request
 		router.GET("/ws", a.handleWebSocket)
+		// 公开的正在播放页面（OpenGraph 预览）、JSON 和 PNG 徽章，按 IP 限流
+		nowPlayingLimit := newIPRateLimiter(nowPlayingPerMinute, nowPlayingBurst).middleware()
+		router.GET("/nowplaying", a.compressor.Middleware(), a.leaderProxyMiddleware(), nowPlayingLimit, a.handleNowPlayingPage)
+		router.GET("/nowplaying.json", a.compressor.Middleware(), a.leaderProxyMiddleware(), nowPlayingLimit, a.handleNowPlayingJSON)
+		router.GET("/nowplaying.png", a.leaderProxyMiddleware(), nowPlayingLimit, a.handleNowPlayingBadge)
 
 		// --- 公开路由 (无需认证) ---
 		apiGroup.POST("/register", a.handleRegister)
 		apiGroup.POST("/login", a.handleLogin) // 用于前端验证凭证
+		// 访客凭加入链接和昵称获取临时凭证
+		apiGroup.POST("/join", a.handleJoinParty)
+		// 前端在第一次修改请求之前取得 CSRF 令牌
+		apiGroup.GET("/csrf", a.handleCSRFToken)
+		// 单点登录：身份提供方回调后前端用一次性代码领取凭证
+		apiGroup.GET("/auth/oidc", a.handleSSOInfo)
+		apiGroup.GET("/auth/oidc/login", a.handleSSOLogin)
+		apiGroup.GET("/auth/oidc/callback", a.handleSSOCallback)
+		apiGroup.POST("/auth/oidc/exchange", a.handleSSOExchange)
+		apiGroup.GET("/auth/proxy", a.handleProxyLogin)
+		// 自动化触发地址：IFTTT、Zapier 和智能按钮凭 token-cli 生成的静态令牌控制播放
+		triggerLimit := newIPRateLimiter(triggerPerMinute, triggerBurst).middleware()
+		apiGroup.GET("/trigger/:action", triggerLimit, a.handleTrigger)
+		apiGroup.POST("/trigger/:action", triggerLimit, a.handleTrigger)
+		// 外部分析 worker：领取歌曲，在另一台机器上计算指纹、BPM/调性和流派后回传，凭配置的令牌访问
+		analysisGroup := apiGroup.Group("/analysis", a.AnalysisWorkerMiddleware())
+		{
+			analysisGroup.POST("/lease", a.handleAnalysisLease)
+			analysisGroup.POST("/tasks/:id/result", a.handleAnalysisResult)
+			analysisGroup.POST("/tasks/:id/fail", a.handleAnalysisFail)
+		}
+		// 扫码配对：新设备用二维码中的配对令牌领取凭证
+		apiGroup.POST("/pair", a.handlePairRedeem)
+		// 机器可读的接口文档及 Swagger UI
+		apiGroup.GET("/openapi.json", a.handleOpenAPISpec)
+		apiGroup.GET("/docs", a.handleSwaggerUI)
 		// --- 受保护的路由组 ---
 		// 使用 BasicAuthMiddleware 中间件
 		protected := apiGroup.Group("")
-		protected.Use(a.BasicAuthMiddleware())
+		protected.Use(a.BasicAuthMiddleware(), guestRestrictionMiddleware)
 		{
-			libraryGroup := apiGroup.Group("/library")
+			// GraphQL：按需查询曲库、播放列表、历史和统计，支持 SSE 订阅状态
+			protected.GET("/graphql", a.handleGraphQL)
+			protected.POST("/graphql", a.handleGraphQL)
+
+			// WebSocket 连接票据，凭证不必出现在连接地址中
+			protected.POST("/ws/ticket", a.handleWSTicket)
+			// 媒体 Cookie，浏览器的播放请求据此计入用户的流数量和流量
+			protected.POST("/media/session", a.handleMediaSession)
+			// 当前用户剩余的切歌/点歌额度
+			protected.GET("/me/budget", a.handleGetBudget)
+			// 房间设置：交叉淡化、家庭模式、切歌和排队额度、歌曲时长和来源限制
+			protected.GET("/settings", a.handleGetSettings)
+			protected.POST("/settings", a.AdminMiddleware(), a.handleUpdateSettings)
+			// 服务端消息的语言
+			protected.GET("/me/language", a.handleGetLanguage)
+			protected.POST("/me/language", a.handleSetLanguage)
+			// 删除自己的账号、导出个人数据
+			protected.POST("/auth/logout", a.handleLogout)
+			protected.DELETE("/account", a.handleDeleteAccount)
+			protected.GET("/account/export", a.handleAccountExport)
+			protected.GET("/account/devices", a.handleListDevices)
+			protected.DELETE("/account/devices/:id", a.handleRevokeDevice)
+			protected.GET("/account/bandwidth", a.handleGetMyBandwidth)
+			// 生成配对二维码，手机扫码后直接登录当前账号
+			protected.GET("/pair/qr", a.handlePairQR)
+
+			libraryGroup := protected.Group("/library")
 			{
 				libraryGroup.GET("", a.handleGetLibrary)
+				// 自己上传的歌曲；除管理员外只能删除、恢复和修改这些歌曲
+				libraryGroup.GET("/mine", a.handleGetMyUploads)
 				libraryGroup.POST("/upload", a.handleUpload)
 				libraryGroup.POST("/remove", a.handleLibraryRemove)
+				// 批量删除、改标签、加入播放列表，整理大量导入的歌曲
+				libraryGroup.POST("/bulk", a.DJMiddleware(), a.handleLibraryBulk)
+				// 用新文件替换歌曲，保留 ID 及其关联的播放列表、历史等
+				libraryGroup.POST("/:id/replace", a.songOwnerMiddleware(), a.handleLibraryReplace)
+				// 标记自动跳过的区间（长静音、口播开场、片尾）
+				libraryGroup.POST("/:id/skip-regions", a.songOwnerMiddleware(), a.handleSetSkipRegions)
+				// 手动设置流派和情绪，覆盖自动分类
+				libraryGroup.POST("/:id/tags", a.songOwnerMiddleware(), a.handleSetSongTags)
+				// 回收站：查看和恢复误删的歌曲
+				libraryGroup.GET("/trash", a.handleGetTrash)
+				// 很久没有播放的好歌
+				libraryGroup.GET("/forgotten", a.handleGetForgotten)
+				// 单首歌曲的详情和技术信息（编码、码率、采样率、HLS 码率），排查音质问题
+				libraryGroup.GET("/:id", a.handleGetSong)
+				// 举报有问题的歌曲（版权、冒犯性内容），由管理员处理
+				libraryGroup.POST("/:id/report", a.handleReportSong)
+				// 歌曲的评论，作者和管理员可以删除
+				libraryGroup.GET("/:id/comments", a.handleGetSongComments)
+				libraryGroup.POST("/:id/comments", a.handleAddSongComment)
+				libraryGroup.POST("/:id/comments/:commentId/delete", a.handleDeleteSongComment)
+				libraryGroup.POST("/restore", a.handleLibraryRestore)
+				// 从直链下载（播客、Bandcamp 购买、NAS 分享链接），进度通过事件推送
+				libraryGroup.POST("/import-file-url", a.DJMiddleware(), a.handleImportFileURL)
 			}
 
-			playlistGroup := apiGroup.Group("/playlist")
+			playlistGroup := protected.Group("/playlist")
 			{
 				playlistGroup.POST("/add", a.handlePlaylistAdd)
+				// 插入到指定位置 / 一次添加多首
+				playlistGroup.POST("/add-at", a.handlePlaylistAddAt)
+				playlistGroup.POST("/add-many", a.handlePlaylistAddMany)
 				playlistGroup.POST("/remove", a.handlePlaylistRemove)
 				// 移动播放列表中的歌曲位置
 				playlistGroup.POST("/move", a.handlePlaylistMove)
+				// 按完整顺序批量重排（拖拽排序）
+				playlistGroup.POST("/reorder", a.handlePlaylistReorder)
 				// 打乱播放列表
 				playlistGroup.POST("/shuffle", a.handlePlaylistShuffle)
+				// 从指定位置开始播放，大幅调整顺序后使用；跟随远程实例时播放由远程控制
+				playlistGroup.POST("/play-from", a.notMirroringMiddleware(), a.handlePlayFrom)
+				// 切换排队方式（FIFO / 按用户轮流）
+				playlistGroup.POST("/queue-mode", a.DJMiddleware(), a.handleSetQueueMode)
+				// 待播歌曲不多时的推荐：任何听众都可以确认加入，DJ 可以撤下
+				playlistGroup.POST("/suggestions/approve", a.handleApproveSuggestion)
+				playlistGroup.POST("/suggestions/dismiss", a.DJMiddleware(), a.handleDismissSuggestions)
 			}
 
-			playerGroup := apiGroup.Group("/player")
+			// 转码任务：查看 ffmpeg 输出，取消卡住的转换
+			jobsGroup := protected.Group("/jobs")
+			{
+				jobsGroup.GET("", a.handleGetJobs)
+				jobsGroup.GET("/:id", a.handleGetJob)
+				jobsGroup.POST("/:id/cancel", a.handleCancelJob)
+			}
+
+			// 命名歌单：从 Spotify / Apple Music / CSV 导入，整体加入播放列表
+			savedGroup := protected.Group("/playlists")
+			{
+				savedGroup.GET("", a.handleGetSavedPlaylists)
+				savedGroup.POST("/import", a.handleImportPlaylist)
+				// 智能歌单：按年代、年份、流派或歌手选歌
+				savedGroup.POST("/smart", a.handleCreateSmartPlaylist)
+				savedGroup.POST("/enqueue", a.handleEnqueueSavedPlaylist)
+				// 按情绪标签和分析得到的 BPM 生成指定时长的歌单，一键开场
+				savedGroup.POST("/generate", a.handleGeneratePlaylist)
+				// 固定“今晚主题”歌单：播放列表播完后按顺序从中取歌
+				savedGroup.POST("/pin", a.DJMiddleware(), a.handlePinPlaylist)
+				savedGroup.POST("/unpin", a.DJMiddleware(), a.handleUnpinPlaylist)
+			}
+
+			playerGroup := protected.Group("/player")
+			// 跟随远程实例时播放由远程控制
+			playerGroup.Use(a.notMirroringMiddleware())
 			{
 				playerGroup.POST("/play", a.handlePlay)
 				// 播放列表中指定的歌曲
@@ -100,6 +769,136 @@ func (a *API) RegisterRoutes(router *gin.Engine) {
 				playerGroup.POST("/next", a.handleNext)
 				playerGroup.POST("/prev", a.handlePrev)
 				playerGroup.POST("/seek", a.handleSeek)
+				// 调整共享播放速度
+				playerGroup.POST("/rate", a.handlePlaybackRate)
+				// 共享的均衡器预设，由 DJ 决定整场派对的音色
+				playerGroup.POST("/equalizer", a.DJMiddleware(), a.handleSetEqualizer)
+				// 在长音轨（混音、有声书）的章节间跳转
+				playerGroup.POST("/seek-chapter", a.handleSeekChapter)
+				// 播放模式：列表循环、单曲循环、随机或按节奏和调性衔接的自动 DJ
+				playerGroup.POST("/mode", a.DJMiddleware(), a.handleSetPlayMode)
+			}
+
+			// 设备控制：调整指定输出设备的音量
+			protected.POST("/devices/volume", a.handleDeviceVolume)
+			// 把发声的角色交给另一台输出设备，播放不中断
+			protected.POST("/devices/transfer", a.handleTransferPlayback)
+
+			// 按星期和小时统计的收听热力图
+			protected.GET("/stats/activity", a.handleGetActivity)
+			// 一段时间内播放最多的歌手和专辑，带封面和听众数
+			protected.GET("/stats/top/artists", a.handleGetTopArtists)
+			protected.GET("/stats/top/albums", a.handleGetTopAlbums)
+			// 切歌率最高的歌曲和切歌原因
+			protected.GET("/stats/skips", a.handleGetSkipStats)
+			// 收听记录导出：Last.fm CSV / JSON 和 .scrobbler.log，不配置实时 scrobble 也能导入其他服务
+			protected.GET("/scrobbles", a.handleGetScrobbles)
+			protected.GET("/scrobbles/scrobbler.log", a.handleGetScrobblerLog)
+
+			// Home Assistant 集成：稳定的状态结构、media_player 命令和等待状态变化的长轮询
+			haGroup := protected.Group("/ha")
+			{
+				haGroup.GET("/state", a.handleHAState)
+				haGroup.GET("/poll", a.handleHAPoll)
+				haGroup.POST("/command", a.notMirroringMiddleware(), a.handleHACommand)
+			}
+
+			// AirPlay 音箱：搜索、连接和断开，连接后作为输出设备出现在设备列表中
+			airplayGroup := protected.Group("/airplay", a.DJMiddleware())
+			{
+				airplayGroup.GET("/speakers", a.handleGetAirPlaySpeakers)
+				airplayGroup.POST("/speakers/:id/connect", a.handleConnectAirPlay)
+				airplayGroup.POST("/speakers/:id/disconnect", a.handleDisconnectAirPlay)
+			}
+
+			// “下一首放什么”投票，DJ 发起，所有人可投
+			pollGroup := protected.Group("/poll")
+			{
+				pollGroup.POST("/start", a.DJMiddleware(), a.handlePollStart)
+				pollGroup.POST("/vote", a.handlePollVote)
+				pollGroup.POST("/cancel", a.DJMiddleware(), a.handlePollCancel)
+			}
+
+			// 音效板：DJ 上传和触发短片段，客户端叠加在当前歌曲上播放
+			soundboardGroup := protected.Group("/soundboard")
+			{
+				soundboardGroup.GET("", a.handleGetSoundClips)
+				soundboardGroup.POST("", a.DJMiddleware(), a.handleUploadSoundClip)
+				soundboardGroup.POST("/:id/play", a.DJMiddleware(), a.handlePlaySoundClip)
+				soundboardGroup.POST("/:id/delete", a.DJMiddleware(), a.handleDeleteSoundClip)
+			}
+
+			// 预定在某个精确时刻开始播放的歌曲（例如零点倒计时），到点打断当前歌曲
+			dropGroup := protected.Group("/drop", a.DJMiddleware())
+			{
+				dropGroup.POST("/schedule", a.notMirroringMiddleware(), a.handleScheduleDrop)
+				dropGroup.POST("/cancel", a.handleCancelDrop)
+			}
+
+			partyGroup := protected.Group("/party")
+			{
+				// 派对加入链接：DJ 生成分享给访客，结束派对时全部作废
+				partyGroup.GET("/links", a.DJMiddleware(), a.handleGetJoinLinks)
+				partyGroup.POST("/links", a.DJMiddleware(), a.handleCreateJoinLink)
+				partyGroup.POST("/links/revoke", a.DJMiddleware(), a.handleRevokeJoinLink)
+				partyGroup.POST("/close", a.DJMiddleware(), a.handleCloseParty)
+				// 派对场次：划分播放历史，结束时生成回顾
+				partyGroup.GET("/sessions", a.handleGetSessions)
+				partyGroup.GET("/sessions/:id", a.handleGetSession)
+				partyGroup.POST("/sessions/start", a.DJMiddleware(), a.handleStartSession)
+				partyGroup.POST("/sessions/end", a.DJMiddleware(), a.handleEndSession)
+			}
+
+			// --- 管理员路由 ---
+			adminGroup := protected.Group("/admin")
+			adminGroup.Use(a.AdminMiddleware())
+			{
+				// 家庭模式：禁止露骨内容
+				adminGroup.POST("/family-mode", a.handleSetFamilyMode)
+				adminGroup.POST("/library/explicit", a.handleSetSongExplicit)
+				adminGroup.POST("/library/jingle", a.handleSetSongJingle)
+				// 原地引用 NAS 等挂载点上的文件，不复制到媒体目录
+				adminGroup.POST("/library/reference", a.handleLibraryReference)
+				// 打包导出曲库（HLS 或原始文件）及元数据清单
+				adminGroup.GET("/library/export", a.handleLibraryExport)
+				// 重新读取年份未知的歌曲的标签
+				adminGroup.POST("/library/rescan", a.handleRescanLibrary)
+				// 黑名单管理
+				adminGroup.GET("/blocklist", a.handleGetBlocklist)
+				adminGroup.POST("/blocklist/add", a.handleBlocklistAdd)
+				adminGroup.POST("/blocklist/remove", a.handleBlocklistRemove)
+				// 举报队列：忽略、加入黑名单或删除被举报的歌曲
+				adminGroup.GET("/reports", a.handleGetReports)
+				adminGroup.POST("/reports/:id/resolve", a.handleResolveReport)
+				// 评论审核：查看最近的评论，隐藏不当的评论
+				adminGroup.GET("/comments", a.handleGetRecentComments)
+				adminGroup.POST("/comments/:id/hide", a.handleHideComment)
+				// 所有客户端显示的公告横幅
+				adminGroup.POST("/announcement", a.handleSetAnnouncement)
+				adminGroup.POST("/announcement/clear", a.handleClearAnnouncement)
+				// 用户角色管理（admin / dj / user）
+				adminGroup.POST("/users/role", a.handleSetUserRole)
+				// 跟随另一个实例的播放（联邦）
+				adminGroup.GET("/federation", a.handleGetFederation)
+				adminGroup.POST("/federation/follow", a.handleFederationFollow)
+				adminGroup.POST("/federation/unfollow", a.handleFederationUnfollow)
+				// 从另一个实例导入曲库和播放列表（合并服务器、迁移硬件）
+				adminGroup.POST("/import-remote", a.handleImportRemote)
+				// 管理页面的总览：客户端、播放、存储、任务和最近的错误
+				adminGroup.GET("/overview", a.handleAdminOverview)
+				// 正在播放的媒体流，按 IP 和用户统计
+				adminGroup.GET("/streams", a.handleGetStreams)
+				// 每月按歌曲和用户统计的媒体流量
+				adminGroup.GET("/bandwidth", a.handleGetBandwidth)
+				// 夜间维护：查看各任务的结果，开关定时执行或立即执行
+				adminGroup.GET("/maintenance", a.handleGetMaintenance)
+				adminGroup.POST("/maintenance/tasks", a.handleSetMaintenanceTask)
+				adminGroup.POST("/maintenance/run", a.handleRunMaintenanceTask)
+				// 维护模式：备份、迁移期间暂停播放并拒绝修改请求
+				adminGroup.POST("/maintenance-mode", a.handleSetMaintenanceMode)
+				// 外部分析队列：查看进度和失败的任务，把已有的歌曲加入队列
+				adminGroup.GET("/analysis", a.handleGetAnalysis)
+				adminGroup.POST("/analysis/enqueue", a.handleEnqueueAnalysis)
 			}
 		}
 
@@ -107,9 +906,12 @@ func (a *API) RegisterRoutes(router *gin.Engine) {
 }
 
 func (a *API) handleWebSocket(c *gin.Context) {
+	// 浏览器无法为 WebSocket 设置请求头，先通过 /api/ws/ticket 换取一次性票据，以 ?ticket= 传递
+	// 认证是可选的：匿名连接只能接收状态，不能投票
+	username := a.wsUsername(c.Request)
 	// Gin 的 Context 提供了 Writer 和 Request，可以直接传递给 WebSocket 升级器
 	// 传递一个函数，当新用户连接时，会调用此函数获取当前状态并发送
-	a.hub.ServeWs(c.Writer, c.Request, a.state.GetFullState)
+	a.hub.ServeWs(c.Writer, c.Request, username, a.initialState)
 }
 
 //func (a *API) handleValidateToken(c *gin.Context) {
@@ -130,27 +932,123 @@ func (a *API) handleWebSocket(c *gin.Context) {
 // BasicAuthMiddleware 是一个 Gin 中间件，用于验证 Basic Authentication
 func (a *API) BasicAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user, pass, ok := c.Request.BasicAuth()
-		if !ok {
+		dbUser, err := a.requestUser(c.Request)
+		if errors.Is(err, errNoCredentials) {
 			c.Header("WWW-Authenticate", `Basic realm="Restricted"`)
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header not provided"})
 			return
 		}
-		dbUser, err := a.db.GetUserByUsername(user)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 			return
 		}
-		if !dbUser.CheckPassword(pass) {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
-			return
-		}
 		// 可选：将用户信息存入 context
 		c.Set("username", dbUser.Username)
+		c.Set("role", dbUser.Role)
+		c.Set(i18n.ContextKey, dbUser.Language)
 		c.Next()
 	}
 }
 
+// errNoCredentials 请求既没有代理身份也没有 Basic Auth 凭证
+var errNoCredentials = errors.New("no credentials")
+
+// requestUser 返回发起请求的用户：受信任的认证代理传来的身份优先，其次是 Basic Auth
+func (a *API) requestUser(r *http.Request) (*db.User, error) {
+	dbUser, err := a.proxyUser(r)
+	if err != nil {
+		log.Printf("Proxy authentication failed: %v", err)
+		return nil, err
+	}
+	if dbUser != nil {
+		return dbUser, nil
+	}
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return nil, errNoCredentials
+	}
+	return a.authenticate(user, pass)
+}
+
+// authenticate 校验用户名和密码
+func (a *API) authenticate(username, password string) (*db.User, error) {
+	if db.IsGuestUsername(username) {
+		return a.guests.Authenticate(username, password)
+	}
+	if db.IsLoginToken(password) {
+		return a.db.AuthenticateLoginToken(username, password)
+	}
+	dbUser, err := a.db.GetUserByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	if !dbUser.CheckPassword(password) {
+		return nil, errors.New("invalid password")
+	}
+	return dbUser, nil
+}
+
+// RequireRole 要求当前用户拥有给定角色之一，需在 BasicAuthMiddleware 之后使用
+func (a *API) RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, r := range roles {
+			if role == r {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient privileges"})
+	}
+}
+
+// AdminMiddleware 要求当前用户为管理员
+func (a *API) AdminMiddleware() gin.HandlerFunc {
+	return a.RequireRole(db.RoleAdmin)
+}
+
+// DJMiddleware 要求当前用户为 DJ 或管理员
+func (a *API) DJMiddleware() gin.HandlerFunc {
+	return a.RequireRole(db.RoleAdmin, db.RoleDJ)
+}
+
+// actorFrom 从 context 中取出当前用户，用于状态层的规则校验
+func actorFrom(c *gin.Context) state.Actor {
+	return state.Actor{
+		Username: c.GetString("username"),
+		IsAdmin:  c.GetString("role") == db.RoleAdmin,
+	}
+}
+
+// skipActor 与 actorFrom 相同，但提前结束当前歌曲时消耗切歌额度，管理员不受限制
+func (a *API) skipActor(c *gin.Context) state.Actor {
+	actor := actorFrom(c)
+	if !actor.IsAdmin {
+		actor.TrySkip = func() bool { return a.budget.TryConsumeSkip(actor.Username) }
+	}
+	return actor
+}
+
+// respondSkipLimit 切歌额度用完时返回 429 和剩余额度，与 /player/next 一致；返回是否已经响应
+func (a *API) respondSkipLimit(c *gin.Context, err error) bool {
+	if !errors.Is(err, state.ErrSkipLimit) {
+		return false
+	}
+	username := c.GetString("username")
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Skip limit reached, try again later", "budget": a.budgetFor(username)})
+	return true
+}
+
+// respondStateError 将状态层返回的错误转换为响应，规则违例统一返回 409 和错误代码
+func respondStateError(c *gin.Context, err error, status int, message string) {
+	var violation *state.RuleViolation
+	if errors.As(err, &violation) {
+		c.JSON(http.StatusConflict, gin.H{"error": violation.Message, "code": violation.Code})
+		return
+	}
+	c.JSON(status, gin.H{"error": message})
+}
+
 // handleRegister 处理用户注册
 func (a *API) handleRegister(c *gin.Context) {
 	var payload RegisterPayload
@@ -158,6 +1056,11 @@ func (a *API) handleRegister(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Username, password, and key are required"})
 		return
 	}
+	// 访客前缀保留给派对访客
+	if db.IsGuestUsername(payload.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username must not start with " + db.GuestPrefix})
+		return
+	}
 	// 1. 验证邀请密钥
 	if !a.keyManager.ValidateAndConsumeKey(payload.Key) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired invitation key"})
@@ -174,35 +1077,61 @@ func (a *API) handleRegister(c *gin.Context) {
 		return
 	}
 
-	_, err = a.db.CreateUser(payload.Username, payload.Password)
+	user, err := a.db.CreateUser(payload.Username, payload.Password)
 	if err != nil {
 		log.Printf("Failed to create user: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
+	a.hooks.Fire(hooks.UserRegistered, gin.H{"username": user.Username, "role": user.Role})
 
 	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully"})
 }
 
-// handleLogin 验证用户凭证 (主要用于前端检查)
+// handleLogin 验证用户凭证 (主要用于前端检查)，请求体中 rememberDevice 为 true 时为这台设备签发长期令牌
 func (a *API) handleLogin(c *gin.Context) {
-	// 复用中间件的逻辑
-	user, pass, ok := c.Request.BasicAuth()
-	if !ok {
+	var payload LoginPayload
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+	// 复用中间件的逻辑，认证代理模式下不带凭证也能登录，前端据此跳过登录表单
+	dbUser, err := a.requestUser(c.Request)
+	if errors.Is(err, errNoCredentials) {
 		c.Header("WWW-Authenticate", `Basic realm="Restricted"`)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header not provided"})
 		return
 	}
-	dbUser, err := a.db.GetUserByUsername(user)
-	if err != nil || !dbUser.CheckPassword(pass) {
+	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Login successful"})
+	response := gin.H{"message": "Login successful", "role": dbUser.Role, "username": dbUser.Username}
+	if payload.RememberDevice {
+		remembered, err := a.rememberDevice(c, dbUser, payload.DeviceName)
+		if err != nil {
+			log.Printf("Error remembering device for %s: %v", dbUser.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remember device"})
+			return
+		}
+		if remembered != nil {
+			response["token"], response["expiresAt"] = remembered.Token, remembered.ExpiresAt
+		}
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 func (a *API) handleGetLibrary(c *gin.Context) {
-	songs, err := a.db.GetAllSongs()
+	var songs []db.Song
+	var err error
+	// ?uploader= 只返回某个用户上传的歌曲
+	if uploader := c.Query("uploader"); uploader != "" {
+		songs, err = a.db.GetSongsUploadedBy(uploader)
+	} else {
+		songs, err = a.db.GetAllSongs()
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get library"})
 		return
@@ -210,17 +1139,38 @@ func (a *API) handleGetLibrary(c *gin.Context) {
 	c.JSON(http.StatusOK, songs)
 }
 
+// handleGetSong 返回一首歌的完整元数据（章节、跳过区间、署名）和入库时记录的技术信息
+func (a *API) handleGetSong(c *gin.Context) {
+	song, err := a.db.GetSong(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Song not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get song"})
+		return
+	}
+	comments, err := a.songComments(c, song.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get comments"})
+		return
+	}
+	c.JSON(http.StatusOK, SongDetail{Song: *song, Technical: song.Technical, Comments: comments})
+}
+
 func (a *API) handleUpload(c *gin.Context) {
-	// 1. 获取上传的文件
+	songUUID, _ := uuid.NewV4()
+	songID := songUUID.String()
+	// 1. 获取上传的文件，接收过程中推送上传进度
+	uploadID := a.trackUploadProgress(c, songID)
 	fileHeader, err := c.FormFile("audioFile")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Error retrieving the file"})
 		return
 	}
-	songUUID, _ := uuid.NewV4()
-	songID := songUUID.String()
 	// 2. 保存原始文件到临时路径 (例如 media/temp_<uuid>.mp3)
-	tempFileName := fmt.Sprintf("temp_%s%s", songID, filepath.Ext(fileHeader.Filename))
+	filename := normalizeFilename(fileHeader.Filename)
+	tempFileName := fmt.Sprintf("temp_%s%s", songID, filepath.Ext(filename))
 	tempFilePath := filepath.Join(a.mediaDir, tempFileName)
 	if err := c.SaveUploadedFile(fileHeader, tempFilePath); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving temporary file"})
@@ -228,86 +1178,35 @@ func (a *API) handleUpload(c *gin.Context) {
 	}
 	// 确保函数退出时删除临时文件
 	defer os.Remove(tempFilePath)
-	// 3. 提取元数据 (Duration, Title, Artist)
-	// 在转换前从源文件提取通常更准确
-	title, artist, album, durationMs, err := getAudioMetadata(tempFilePath)
+	song, err := a.ingestFile(songID, uploadID, tempFilePath, uploadOrigin{
+		Filename:   filename,
+		Source:     db.SourceLocal,
+		UploadedBy: c.GetString("username"),
+	})
 	if err != nil {
-		log.Printf("Warning: Metadata extraction failed: %v", err)
-		durationMs = 0 // 转换失败降级处理
-	}
-	// 如果元数据中没有标题，使用文件名
-	if title == "" {
-		title = strings.TrimSuffix(fileHeader.Filename, filepath.Ext(fileHeader.Filename))
-	}
-	// 4. 创建该歌曲的 HLS 输出目录 (media/<uuid>/)
-	songDir := filepath.Join(a.mediaDir, songID)
-	if err := os.MkdirAll(songDir, 0755); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create song directory"})
-		return
-	}
-	// 5. 执行 FFmpeg 转换为 HLS
-	// output: media/<uuid>/index.m3u8
-	hlsFileName := "index.m3u8"
-	hlsFilePath := filepath.Join(songDir, hlsFileName)
-	if err := convertToHLS(tempFilePath, hlsFilePath); err != nil {
-		// 失败时清理创建的目录
-		os.RemoveAll(songDir)
-		log.Printf("FFmpeg conversion failed: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert audio to HLS"})
-		return
-	}
-	// 6. 存入数据库
-	// FilePath 存储相对路径: <uuid>/index.m3u8
-	relativeFilePath := filepath.Join(songID, hlsFileName)
-	// 注意：Windows 下 Join 会用反斜杠，web 访问需要正斜杠，这里做个替换以防万一
-	relativeFilePath = filepath.ToSlash(relativeFilePath)
-	song := &db.Song{
-		ID:         songID,
-		Title:      title,
-		Artist:     artist,
-		Album:      album,
-		DurationMs: durationMs,
-		Source:     "local",
-		FilePath:   relativeFilePath, // 指向 .m3u8
-	}
-	if err := a.db.AddSong(song); err != nil {
-		os.RemoveAll(songDir) // 数据库失败，清理目录
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error adding song to database"})
-		return
-	}
-	log.Printf("New song uploaded and converted to HLS: %s (%dms)", song.Title, song.DurationMs)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusCreated, song)
 }
 
-func convertToHLS(inputFile, outputFile string) error {
-	// ffmpeg 命令参数：
-	// -i input.mp3    : 输入
-	// -c:a aac        : 音频编码 AAC (HLS 标准)
-	// -b:a 192k       : 码率
-	// -vn             : 不处理视频流
-	// -hls_time 10    : 每个切片约 10 秒
-	// -hls_list_size 0: 索引文件包含所有切片（不覆盖）
-	// -f hls          : 输出格式
-	cmd := exec.Command("ffmpeg",
-		"-i", inputFile,
-		"-c:a", "aac",
-		"-b:a", "320k",
-		"-vn",
-		"-hls_time", "10",
-		"-hls_list_size", "0",
-		"-f", "hls",
-		outputFile,
-	)
-	// 将 stderr 输出到日志以便调试 ffmpeg 错误
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// fileSHA256 计算文件内容的 SHA-256，返回十六进制字符串
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 // handleLibraryRemove 处理删除歌曲的请求
 func (a *API) handleLibraryRemove(c *gin.Context) {
-	var payload struct {
-		SongID string `json:"songId"`
-	}
+	var payload SongIDPayload
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
 		return
@@ -322,42 +1221,132 @@ func (a *API) handleLibraryRemove(c *gin.Context) {
 		c.Status(http.StatusOK)
 		return
 	}
+	if !canModifySong(c, song) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only change songs you uploaded"})
+		return
+	}
+	// 只移入回收站，误删后可以恢复，文件在保留期过后由 purgeExpiredTrash 删除
 	if err := a.state.RemoveSongFromLibrary(payload.SongID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove song: " + err.Error()})
 		return
 	}
-	// 关键修改：因为现在每个歌曲是一个目录，不仅是 .m3u8 文件
-	// 数据库存的是 "uuid/index.m3u8"，我们需要删除 "media/uuid"
-	relDir := filepath.Dir(song.FilePath) // 获取 "uuid"
-	absDir := filepath.Join(a.mediaDir, relDir)
-	// 使用 RemoveAll 递归删除目录及其内容 (.m3u8 和 .ts)
-	if err := os.RemoveAll(absDir); err != nil {
-		log.Printf("Warning: failed to delete audio directory %s: %v", absDir, err)
-	}
 	c.Status(http.StatusOK)
 }
 
 func (a *API) handlePlaylistAdd(c *gin.Context) {
-	var payload struct {
-		SongID string `json:"songId"`
-	}
+	var payload SongIDPayload
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
 		return
 	}
 
-	if err := a.state.AddToPlaylist(payload.SongID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add song to playlist"})
+	username := c.GetString("username")
+	if a.budgetFor(username).RequestsRemaining == 0 {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many pending requests, wait for your songs to play", "budget": a.budgetFor(username)})
 		return
 	}
-	c.Status(http.StatusOK)
+	if err := a.state.AddToPlaylist(payload.SongID, actorFrom(c)); err != nil {
+		respondStateError(c, err, http.StatusInternalServerError, "Failed to add song to playlist")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"budget": a.budgetFor(username)})
+}
+
+// handlePlaylistAddAt 将歌曲插入到播放列表的指定位置
+func (a *API) handlePlaylistAddAt(c *gin.Context) {
+	var payload PlaylistAddAtPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "songId and index are required"})
+		return
+	}
+
+	username := c.GetString("username")
+	if a.budgetFor(username).RequestsRemaining == 0 {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many pending requests, wait for your songs to play", "budget": a.budgetFor(username)})
+		return
+	}
+	if err := a.state.AddToPlaylistAt(payload.SongID, *payload.Index, actorFrom(c)); err != nil {
+		respondStateError(c, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"budget": a.budgetFor(username)})
+}
+
+// handlePlaylistAddMany 一次添加多首歌曲
+func (a *API) handlePlaylistAddMany(c *gin.Context) {
+	var payload PlaylistAddManyPayload
+	if err := c.ShouldBindJSON(&payload); err != nil || len(payload.SongIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "songIds is required"})
+		return
+	}
+
+	username := c.GetString("username")
+	if remaining := a.budgetFor(username).RequestsRemaining; remaining >= 0 && remaining < len(payload.SongIDs) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Not enough request budget for all songs", "budget": a.budgetFor(username)})
+		return
+	}
+	added, err := a.state.AddManyToPlaylist(payload.SongIDs, actorFrom(c))
+	if err != nil {
+		respondStateError(c, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"added": added, "budget": a.budgetFor(username)})
+}
+
+// budgetFor 计算用户当前剩余的切歌/点歌额度
+func (a *API) budgetFor(username string) Budget {
+	return Budget{
+		SkipsRemaining:    a.budget.SkipsRemaining(username),
+		RequestsRemaining: a.budget.RequestsRemaining(a.state.PendingRequestCount(username)),
+	}
+}
+
+// handleGetBudget 返回当前用户剩余的额度
+func (a *API) handleGetBudget(c *gin.Context) {
+	c.JSON(http.StatusOK, a.budgetFor(c.GetString("username")))
+}
+
+// handleCSRFToken 返回本次会话的 CSRF 令牌，令牌同时写在 Cookie 中
+func (a *API) handleCSRFToken(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, CSRFToken{Token: csrf.Token(c), Header: csrf.HeaderName})
+}
+
+// handleGetLanguage 返回当前用户的语言设置
+func (a *API) handleGetLanguage(c *gin.Context) {
+	c.JSON(http.StatusOK, LanguageSettings{
+		Language:  c.GetString(i18n.ContextKey),
+		Effective: i18n.Language(c),
+		Supported: i18n.Supported,
+	})
+}
+
+// handleSetLanguage 设置当前用户的语言，之后的错误信息等按该语言返回
+func (a *API) handleSetLanguage(c *gin.Context) {
+	var payload LanguagePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	language := ""
+	if payload.Language != "" {
+		var ok bool
+		if language, ok = i18n.Normalize(payload.Language); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported language"})
+			return
+		}
+	}
+	if err := a.db.SetUserLanguage(c.GetString("username"), language); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save language"})
+		return
+	}
+	c.Set(i18n.ContextKey, language)
+	a.handleGetLanguage(c)
 }
 
 // handlePlaylistRemove 处理从播放列表中移除歌曲的请求
 func (a *API) handlePlaylistRemove(c *gin.Context) {
-	var payload struct {
-		SongID string `json:"songId"`
-	}
+	var payload SongIDPayload
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
 		return
@@ -367,7 +1356,10 @@ func (a *API) handlePlaylistRemove(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "songId is required"})
 		return
 	}
-	if err := a.state.RemoveFromPlaylist(payload.SongID); err != nil {
+	if err := a.state.RemoveFromPlaylist(payload.SongID, a.skipActor(c)); err != nil {
+		if a.respondSkipLimit(c, err) {
+			return
+		}
 		// 记录错误日志
 		log.Printf("Failed to remove song from playlist: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove song from playlist"})
@@ -389,9 +1381,26 @@ func (a *API) handlePause(c *gin.Context) {
 	c.Status(http.StatusAccepted)
 }
 
+// handleNext 切到下一首，请求体可选，reason 记录在被切走歌曲的收听记录中，供切歌统计使用
 func (a *API) handleNext(c *gin.Context) {
-	a.state.NextSong()
-	c.Status(http.StatusAccepted)
+	var payload SkipPayload
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+	if payload.Reason != "" && !db.ValidSkipReason(payload.Reason) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason must be bad_quality, wrong_vibe or duplicate"})
+		return
+	}
+	username := c.GetString("username")
+	if !a.budget.TryConsumeSkip(username) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Skip limit reached, try again later", "budget": a.budgetFor(username)})
+		return
+	}
+	a.state.NextSong(username, payload.Reason)
+	c.JSON(http.StatusAccepted, gin.H{"budget": a.budgetFor(username)})
 }
 
 func (a *API) handlePrev(c *gin.Context) {
@@ -406,7 +1415,8 @@ func (a *API) handleSeek(c *gin.Context) {
 		return
 	}
 
-	if err := a.state.SeekTo(payload.PositionMs); err != nil {
+	// 拖动时连续的请求在状态层合并，不逐个写库和广播
+	if err := a.state.RequestSeek(payload.PositionMs, payload.Scrubbing, c.GetString("username")); err != nil {
 		// This error is returned if no song is playing.
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -414,6 +1424,84 @@ func (a *API) handleSeek(c *gin.Context) {
 	c.Status(http.StatusAccepted)
 }
 
+// handlePlaybackRate 处理修改播放速度的请求
+func (a *API) handlePlaybackRate(c *gin.Context) {
+	var payload PlaybackRatePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if err := a.state.SetPlaybackRate(payload.Rate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// handleSetEqualizer 切换所有客户端共享的均衡器
+func (a *API) handleSetEqualizer(c *gin.Context) {
+	var payload EqualizerPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if err := a.state.SetEqualizer(payload.Preset, payload.Gains); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// handleSetSkipRegions 替换歌曲的跳过区间，播放时自动越过
+func (a *API) handleSetSkipRegions(c *gin.Context) {
+	var payload SkipRegionsPayload
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.Regions == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "regions is required"})
+		return
+	}
+	regions := make([]db.SkipRegion, 0, len(payload.Regions))
+	for _, r := range payload.Regions {
+		regions = append(regions, db.SkipRegion{StartMs: r.StartMs, EndMs: r.EndMs, Label: r.Label})
+	}
+	songID := c.Param("id")
+	if err := a.state.SetSkipRegions(songID, regions); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Song not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, regions)
+}
+
+// handleSeekChapter 处理章节跳转请求
+func (a *API) handleSeekChapter(c *gin.Context) {
+	var payload SeekChapterPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	var err error
+	switch {
+	case payload.Index != nil:
+		err = a.state.SeekToChapter(*payload.Index)
+	case payload.Direction == "next":
+		err = a.state.StepChapter(1)
+	case payload.Direction == "prev":
+		err = a.state.StepChapter(-1)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "index or direction (next/prev) is required"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
 // handlePlaySpecific 处理播放指定歌曲的请求
 func (a *API) handlePlaySpecific(c *gin.Context) {
 	var payload PlaySpecificPayload
@@ -425,8 +1513,32 @@ func (a *API) handlePlaySpecific(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "songId is required"})
 		return
 	}
-	if err := a.state.PlaySpecificSong(payload.SongID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := a.state.PlaySpecificSong(payload.SongID, a.skipActor(c)); err != nil {
+		if a.respondSkipLimit(c, err) {
+			return
+		}
+		respondStateError(c, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// handlePlayFrom 处理从指定位置开始播放的请求
+func (a *API) handlePlayFrom(c *gin.Context) {
+	var payload PlayFromPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "index is required"})
+		return
+	}
+	if payload.Reason != "" && !db.ValidSkipReason(payload.Reason) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason must be bad_quality, wrong_vibe or duplicate"})
+		return
+	}
+	if err := a.state.PlayFrom(*payload.Index, a.skipActor(c), payload.Reason); err != nil {
+		if a.respondSkipLimit(c, err) {
+			return
+		}
+		respondStateError(c, err, http.StatusBadRequest, err.Error())
 		return
 	}
 	c.Status(http.StatusAccepted)
@@ -455,6 +1567,24 @@ func (a *API) handlePlaylistMove(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
+// handlePlaylistReorder 处理按完整顺序重排播放列表的请求
+func (a *API) handlePlaylistReorder(c *gin.Context) {
+	var payload PlaylistReorderPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "songIds is required"})
+		return
+	}
+	if err := a.state.SetPlaylistOrder(payload.SongIDs, payload.BaseVersion); err != nil {
+		if errors.Is(err, state.ErrVersionConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": "VERSION_CONFLICT"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
 // handlePlaylistShuffle 处理打乱播放列表的请求
 func (a *API) handlePlaylistShuffle(c *gin.Context) {
 	// 该接口不需要请求体参数
@@ -464,3 +1594,204 @@ func (a *API) handlePlaylistShuffle(c *gin.Context) {
 	}
 	c.Status(http.StatusOK)
 }
+
+// --- Admin ---
+
+// handleSetFamilyMode 开启或关闭家庭模式
+func (a *API) handleSetFamilyMode(c *gin.Context) {
+	var payload FamilyModePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	a.state.SetFamilyMode(payload.Enabled)
+	c.Status(http.StatusOK)
+}
+
+// handleSetSongExplicit 手动标记歌曲是否为露骨内容
+func (a *API) handleSetSongExplicit(c *gin.Context) {
+	var payload SongExplicitPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if payload.SongID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "songId is required"})
+		return
+	}
+	if err := a.state.SetSongExplicit(payload.SongID, payload.Explicit); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Song not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update song"})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// handleSetSongJingle 标记歌曲是否为台标
+func (a *API) handleSetSongJingle(c *gin.Context) {
+	var payload SongJinglePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if payload.SongID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "songId is required"})
+		return
+	}
+	if err := a.state.SetSongJingle(payload.SongID, payload.Jingle); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Song not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update song"})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// handleGetBlocklist 返回黑名单
+func (a *API) handleGetBlocklist(c *gin.Context) {
+	c.JSON(http.StatusOK, a.state.Blocklist())
+}
+
+// handleBlocklistAdd 新增黑名单条目
+func (a *API) handleBlocklistAdd(c *gin.Context) {
+	var payload BlocklistAddPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	entry := &db.BlocklistEntry{
+		SongID:        payload.SongID,
+		ArtistPattern: strings.TrimSpace(payload.ArtistPattern),
+		Reason:        payload.Reason,
+		CreatedBy:     c.GetString("username"),
+	}
+	if err := a.state.AddBlocklistEntry(entry); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, entry)
+}
+
+// handleSetAnnouncement 发布公告，所有客户端通过 WebSocket 收到并显示横幅
+func (a *API) handleSetAnnouncement(c *gin.Context) {
+	var payload AnnouncementPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	announcement, err := a.state.SetAnnouncement(payload.Message, payload.Level, payload.ExpiresAt, actorFrom(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, announcement)
+}
+
+// handleClearAnnouncement 撤下公告
+func (a *API) handleClearAnnouncement(c *gin.Context) {
+	a.state.ClearAnnouncement()
+	c.Status(http.StatusOK)
+}
+
+// handleBlocklistRemove 删除黑名单条目
+func (a *API) handleBlocklistRemove(c *gin.Context) {
+	var payload BlocklistRemovePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id is required"})
+		return
+	}
+	if err := a.state.RemoveBlocklistEntry(payload.ID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Blocklist entry not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove blocklist entry"})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// handleSetUserRole 修改用户角色
+func (a *API) handleSetUserRole(c *gin.Context) {
+	var payload UserRolePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username and role are required"})
+		return
+	}
+	if !db.ValidRole(payload.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+	if err := a.db.SetUserRole(payload.Username, payload.Role); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+	log.Printf("User %s role set to %s by %s", payload.Username, payload.Role, c.GetString("username"))
+	c.Status(http.StatusOK)
+}
+
+// handleSetQueueMode 切换排队方式
+func (a *API) handleSetQueueMode(c *gin.Context) {
+	var payload QueueModePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode is required"})
+		return
+	}
+	if err := a.state.SetQueueMode(payload.Mode); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// handleSetPlayMode 切换播放模式
+func (a *API) handleSetPlayMode(c *gin.Context) {
+	var payload PlayModePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode is required"})
+		return
+	}
+	if err := a.state.SetPlayMode(payload.Mode); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// handleDeviceVolume 调整指定设备的音量
+func (a *API) handleDeviceVolume(c *gin.Context) {
+	var payload DeviceVolumePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "deviceId and volume are required"})
+		return
+	}
+	if err := a.state.SetDeviceVolume(payload.DeviceID, *payload.Volume); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// handleTransferPlayback 把播放转移到指定的输出设备
+func (a *API) handleTransferPlayback(c *gin.Context) {
+	var payload TransferPlaybackPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	transfer, err := a.state.TransferPlayback(payload.DeviceID, actorFrom(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, transfer)
+}
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/state"
+)
+
+const (
+	// defaultHAPollTimeout 长轮询在状态没有变化时的默认等待时间，低于 Home Assistant 常见的请求超时设置
+	defaultHAPollTimeout = 25 * time.Second
+	maxHAPollTimeout     = 55 * time.Second
+	// haPositionTolerance 进度与版本号中的进度相差不超过该值时不算跳转，避免时钟误差让长轮询反复返回
+	haPositionTolerance = 1500 * time.Millisecond
+	// haVolumeStep volume_up / volume_down 每次调整的音量
+	haVolumeStep = 0.1
+)
+
+// HAState Home Assistant 的 rest 传感器和模板 media_player 使用的状态，字段名与 media_player 的属性一致，后续版本只增不改
+type HAState struct {
+	// State playing、paused、buffering（切歌后等待客户端缓冲）或 idle（没有当前歌曲）
+	State            string `json:"state"`
+	MediaContentID   string `json:"media_content_id,omitempty"`
+	MediaContentType string `json:"media_content_type,omitempty"`
+	MediaTitle       string `json:"media_title,omitempty"`
+	MediaArtist      string `json:"media_artist,omitempty"`
+	MediaAlbumName   string `json:"media_album_name,omitempty"`
+	// MediaDuration 和 MediaPosition 的单位是秒，MediaPositionUpdatedAt 是读取进度的时间
+	MediaDuration          float64   `json:"media_duration,omitempty"`
+	MediaPosition          float64   `json:"media_position"`
+	MediaPositionUpdatedAt time.Time `json:"media_position_updated_at"`
+	EntityPicture          string    `json:"entity_picture,omitempty"`
+	// VolumeLevel 输出设备的平均音量，没有输出设备时为空
+	VolumeLevel *float64 `json:"volume_level,omitempty"`
+	Shuffle     bool     `json:"shuffle"`
+	// Repeat off、all 或 one
+	Repeat       string  `json:"repeat"`
+	PlaybackRate float64 `json:"playback_rate"`
+	QueueSize    int     `json:"queue_size"`
+	// Version 状态的版本号，传给 /api/ha/poll 等待下一次变化；进度随时间正常增长不算变化
+	Version string `json:"version"`
+}
+
+// haState 把状态快照转换为 Home Assistant 使用的结构
+func (a *API) haState(snapshot *state.Snapshot, baseURL string) HAState {
+	now := time.Now()
+	s := HAState{
+		State:                  "idle",
+		MediaPositionUpdatedAt: now.UTC(),
+		Shuffle:                snapshot.PlayMode == state.Shuffle,
+		Repeat:                 "off",
+		PlaybackRate:           snapshot.PlaybackRate,
+		QueueSize:              len(snapshot.Playlist),
+	}
+	switch snapshot.PlayMode {
+	case state.RepeatAll:
+		s.Repeat = "all"
+	case state.RepeatOne:
+		s.Repeat = "one"
+	}
+	if len(snapshot.OutputDevices) > 0 {
+		var total float64
+		for _, device := range snapshot.OutputDevices {
+			total += device.Volume
+		}
+		volume := math.Round(total/float64(len(snapshot.OutputDevices))*100) / 100
+		s.VolumeLevel = &volume
+	}
+	if song := snapshot.CurrentSong; song != nil {
+		switch snapshot.Status {
+		case state.Playing:
+			s.State = "playing"
+		case state.Loading:
+			s.State = "buffering"
+		default:
+			s.State = "paused"
+		}
+		s.MediaContentID = song.ID
+		s.MediaContentType = "music"
+		s.MediaTitle = song.Title
+		s.MediaArtist = song.Artist
+		s.MediaAlbumName = song.Album
+		s.MediaDuration = float64(song.DurationMs) / 1000
+		s.MediaPosition = float64(snapshot.ProgressMs) / 1000
+		if a.artworkPath(song) != "" {
+			s.EntityPicture = artworkURL(song, baseURL)
+		}
+	}
+	s.Version = haVersion(s, snapshot, now)
+	return s
+}
+
+// haVersion 由状态内容的哈希和进度锚点组成：播放中锚点是按进度推算的开始时间，暂停时是带 p 前缀的进度本身
+func haVersion(s HAState, snapshot *state.Snapshot, now time.Time) string {
+	h := fnv.New64a()
+	volume := -1.0
+	if s.VolumeLevel != nil {
+		volume = *s.VolumeLevel
+	}
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s\x00%g\x00%s\x00%g\x00%t\x00%s\x00%g\x00%d",
+		s.State, s.MediaContentID, s.MediaTitle, s.MediaArtist, s.MediaAlbumName, s.MediaDuration,
+		s.EntityPicture, volume, s.Shuffle, s.Repeat, s.PlaybackRate, s.QueueSize)
+	anchor := "p" + strconv.FormatInt(snapshot.ProgressMs, 10)
+	if snapshot.Status == state.Playing {
+		rate := snapshot.PlaybackRate
+		if rate <= 0 {
+			rate = 1
+		}
+		anchor = strconv.FormatInt(now.UnixMilli()-int64(float64(snapshot.ProgressMs)/rate), 10)
+	}
+	return strconv.FormatUint(h.Sum64(), 16) + "." + anchor
+}
+
+// haChanged 判断状态相对客户端持有的版本是否有变化：暂停时进度不变才算没有变化，播放中推算的开始时间相差在容差内算没有变化
+func haChanged(version, current string) bool {
+	oldHash, oldAnchor, ok1 := strings.Cut(version, ".")
+	newHash, newAnchor, ok2 := strings.Cut(current, ".")
+	if !ok1 || !ok2 || oldHash != newHash {
+		return true
+	}
+	if strings.HasPrefix(newAnchor, "p") {
+		return oldAnchor != newAnchor
+	}
+	oldMs, err1 := strconv.ParseInt(oldAnchor, 10, 64)
+	newMs, err2 := strconv.ParseInt(newAnchor, 10, 64)
+	if err1 != nil || err2 != nil {
+		return true
+	}
+	return (time.Duration(newMs-oldMs) * time.Millisecond).Abs() > haPositionTolerance
+}
+
+// handleHAState 返回 Home Assistant 使用的播放状态
+func (a *API) handleHAState(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, a.haState(a.state.Snapshot(), requestBaseURL(c)))
+}
+
+// handleHAPoll 长轮询：状态相对 version 有变化时立即返回，否则等到变化或超时后返回当前状态
+func (a *API) handleHAPoll(c *gin.Context) {
+	timeout := defaultHAPollTimeout
+	if raw := c.Query("timeout"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "timeout must be a number of seconds"})
+			return
+		}
+		timeout = min(time.Duration(seconds)*time.Second, maxHAPollTimeout)
+	}
+	version := c.Query("version")
+	baseURL := requestBaseURL(c)
+	c.Header("Cache-Control", "no-store")
+
+	updates, unsubscribe := a.state.Subscribe()
+	defer unsubscribe()
+	current := a.haState(a.state.Snapshot(), baseURL)
+	if version == "" || haChanged(version, current.Version) {
+		c.JSON(http.StatusOK, current)
+		return
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-timer.C:
+			c.JSON(http.StatusOK, a.haState(a.state.Snapshot(), baseURL))
+			return
+		case snapshot, ok := <-updates:
+			if !ok {
+				return
+			}
+			current = a.haState(snapshot, baseURL)
+			if haChanged(version, current.Version) {
+				c.JSON(http.StatusOK, current)
+				return
+			}
+		}
+	}
+}
+
+// handleHACommand 执行 media_player 服务对应的命令，返回执行后的状态；命令名可以带 media_player. 前缀
+func (a *API) handleHACommand(c *gin.Context) {
+	var payload HACommandPayload
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.Command == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "command is required"})
+		return
+	}
+	username := c.GetString("username")
+	command := strings.TrimPrefix(payload.Command, "media_player.")
+	switch command {
+	case "media_play":
+		a.state.Play()
+	case "media_pause", "media_stop":
+		// 停止会清空当前歌曲，这里按暂停处理，保留进度
+		a.state.Pause()
+	case "media_play_pause":
+		if a.state.Snapshot().IsPlaying {
+			a.state.Pause()
+		} else {
+			a.state.Play()
+		}
+	case "media_next_track":
+		if !a.budget.TryConsumeSkip(username) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Skip limit reached, try again later", "budget": a.budgetFor(username)})
+			return
+		}
+		a.state.NextSong(username, "")
+	case "media_previous_track":
+		a.state.PrevSong()
+	case "media_seek":
+		if payload.SeekPosition == nil || *payload.SeekPosition < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "seek_position is required"})
+			return
+		}
+		if err := a.state.SeekTo(int64(*payload.SeekPosition * 1000)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	case "volume_set":
+		if payload.VolumeLevel == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "volume_level is required"})
+			return
+		}
+		if !a.setOutputVolumes(c, func(float64) float64 { return *payload.VolumeLevel }) {
+			return
+		}
+	case "volume_up", "volume_down":
+		step := haVolumeStep
+		if command == "volume_down" {
+			step = -step
+		}
+		if !a.setOutputVolumes(c, func(v float64) float64 { return max(0, min(1, v+step)) }) {
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported command, use media_play, media_pause, media_play_pause, media_stop, media_next_track, media_previous_track, media_seek, volume_set, volume_up or volume_down"})
+		return
+	}
+	c.JSON(http.StatusOK, a.haState(a.state.Snapshot(), requestBaseURL(c)))
+}
+
+// setOutputVolumes 按 volume 计算并设置每台输出设备的新音量，失败时写出错误响应并返回 false
+func (a *API) setOutputVolumes(c *gin.Context, volume func(current float64) float64) bool {
+	devices := a.state.Snapshot().OutputDevices
+	if len(devices) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "No output devices are connected"})
+		return false
+	}
+	for _, device := range devices {
+		if err := a.state.SetDeviceVolume(device.ID, volume(device.Volume)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/hooks"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxFilenameBytes 记录的原始文件名的长度上限，与常见文件系统一致
+const maxFilenameBytes = 255
+
+// uploadOrigin 文件的来源，作为来源记录保存在歌曲上
+type uploadOrigin struct {
+	// Filename 用户给出的文件名，入库前会规范化
+	Filename string
+	// Source 歌曲的来源类型（local、url）
+	Source     string
+	SourceURL  string
+	UploadedBy string
+}
+
+// ingestFile 把已保存到临时路径的音频文件加入曲库：提取元数据、转换为 HLS、写入数据库
+// uploadID 记录在转码任务中，客户端据此把上传进度和转码进度对应起来
+// 返回的错误信息可以直接展示给用户，调用方负责删除临时文件
+func (a *API) ingestFile(songID, uploadID, tempFilePath string, origin uploadOrigin) (*db.Song, error) {
+	filename := normalizeFilename(origin.Filename)
+	meta, contentHash := probeFile(tempFilePath, filename)
+	// 执行 FFmpeg 转换为 HLS，没有 ffmpeg 时原样保存
+	// output: media/content/ab/<hash>/index.m3u8 或 .../original.mp3，同一文件再次上传时直接复用
+	mediaFileName, err := a.mediaFileName(tempFilePath)
+	if err != nil {
+		return nil, err
+	}
+	uploadedAt := time.Now()
+	song := &db.Song{
+		ID:          songID,
+		Title:       meta.Title,
+		Artist:      meta.Artist,
+		Album:       meta.Album,
+		AlbumArtist: meta.AlbumArtist,
+		Compilation: meta.Compilation,
+		Genre:       meta.Genre,
+		Mood:        meta.Mood,
+		Year:        meta.Year,
+		DurationMs:  meta.DurationMs,
+		Source:      origin.Source,
+		Explicit:    meta.Explicit,
+		FilePath:    contentFilePath(songID, contentHash, mediaFileName), // 指向 .m3u8 或原始文件
+		Chapters:    meta.Chapters,
+		ContentHash: contentHash,
+		GainDb:      meta.GainDb,
+		BPM:         meta.BPM,
+		MusicalKey:  meta.MusicalKey,
+		Technical:   a.technicalInfo(meta, mediaFileName),
+
+		OriginalFilename: filename,
+		UploadedBy:       origin.UploadedBy,
+		UploadedAt:       &uploadedAt,
+		SourceURL:        origin.SourceURL,
+	}
+	var dbErr error
+	err = a.storeMedia(song.FilePath, func(dir string) error {
+		job := a.jobs.Queue(Job{
+			Kind:       JobKindUpload,
+			SongID:     songID,
+			UploadID:   uploadID,
+			Name:       filename,
+			CreatedBy:  origin.UploadedBy,
+			DurationMs: meta.DurationMs,
+		})
+		return a.prepareMedia(job, tempFilePath, filepath.Join(dir, mediaFileName))
+	}, func() error {
+		dbErr = a.db.AddSong(song)
+		return dbErr
+	})
+	if err != nil {
+		return nil, storeError(err, dbErr, "Error adding song to database")
+	}
+	log.Printf("New song uploaded as %s: %s (%dms)", mediaFileName, song.Title, song.DurationMs)
+	// 标签中没有 ReplayGain 时在后台测量
+	if song.GainDb == nil {
+		a.queueGainAnalysis(song.ID)
+	}
+	a.queueExternalAnalysis(song.ID)
+	a.queueClassification(song.ID)
+	a.hooks.Fire(hooks.UploadCompleted, gin.H{"song": song, "uploadedBy": origin.UploadedBy})
+	return song, nil
+}
+
+// storeError 把 storeMedia 的错误转换为可以展示给用户的信息
+func storeError(err, dbErr error, dbMessage string) error {
+	switch {
+	case dbErr != nil:
+		return errors.New(dbMessage)
+	case errors.Is(err, errJobCanceled):
+		return errors.New("Conversion was cancelled")
+	}
+	return errors.New("Failed to convert audio to HLS")
+}
+
+// probeFile 计算内容哈希并提取元数据，元数据中没有标题时使用文件名
+func probeFile(tempFilePath, filename string) (*audioMetadata, string) {
+	// 内容哈希用于在联邦实例之间识别同一首歌
+	contentHash, err := fileSHA256(tempFilePath)
+	if err != nil {
+		log.Printf("Warning: Failed to hash uploaded file: %v", err)
+	}
+	// 提取元数据 (Duration, Title, Artist)
+	// 在转换前从源文件提取通常更准确
+	meta, err := getAudioMetadata(tempFilePath)
+	if err != nil {
+		log.Printf("Warning: Metadata extraction failed: %v", err)
+		meta = &audioMetadata{} // 转换失败降级处理
+	}
+	// 如果元数据中没有标题，使用文件名
+	if meta.Title == "" {
+		meta.Title = strings.TrimSuffix(filename, filepath.Ext(filename))
+	}
+	return meta, contentHash
+}
+
+// normalizeFilename 规范化客户端给出的文件名：去掉目录部分（包括 Windows 的 C:\fakepath\）、
+// 转为 NFC、去掉控制字符并合并空白，过长时保留扩展名截断，结果为空时返回 "upload"
+func normalizeFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = norm.NFC.String(strings.ToValidUTF8(name, ""))
+	name = strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	}), " ")
+	// "song .mp3" -> "song.mp3"
+	ext := filepath.Ext(name)
+	name = strings.TrimSpace(strings.TrimSuffix(name, ext)) + ext
+	if name == "" || name == "." || name == ".." {
+		return "upload"
+	}
+	if len(name) > maxFilenameBytes {
+		if len(ext) > 16 {
+			ext = ""
+		}
+		base := strings.ToValidUTF8(name[:maxFilenameBytes-len(ext)], "")
+		name = base + ext
+	}
+	return name
+}
@@ -0,0 +1,340 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// 转码任务的状态
+const (
+	JobQueued      = "queued"
+	JobTranscoding = "transcoding"
+	JobDone        = "done"
+	JobFailed      = "failed"
+	JobCancelled   = "cancelled"
+)
+
+// EventJobProgress 任务状态或转码进度变化时推送，数据为不含 ffmpeg 输出的 Job
+const EventJobProgress = "JOB_PROGRESS"
+
+// 转码任务的类型
+const (
+	JobKindUpload    = "upload"
+	JobKindReplace   = "replace"
+	JobKindReference = "reference"
+)
+
+const (
+	// maxFinishedJobs 保留的已结束任务数，更早的任务被丢弃
+	maxFinishedJobs = 100
+	// maxJobStderr 每个任务保留的 ffmpeg 输出字节数，只保留末尾，错误信息通常在最后
+	maxJobStderr = 16 << 10
+)
+
+var (
+	errJobNotFound = errors.New("job not found")
+	errJobFinished = errors.New("job has already finished")
+	errJobCanceled = errors.New("job was cancelled")
+)
+
+// Job 一次转码任务，只保存在内存中
+type Job struct {
+	ID     string `json:"id"`
+	Kind   string `json:"kind"`
+	SongID string `json:"songId"`
+	// UploadID 上传时客户端给出的标识，与 UPLOAD_PROGRESS 事件对应
+	UploadID  string `json:"uploadId,omitempty"`
+	Name      string `json:"name"`
+	CreatedBy string `json:"createdBy,omitempty"`
+	Status    string `json:"status"`
+	// DurationMs 源文件时长，用于计算进度，未知时 Progress 只在结束时变为 100
+	DurationMs int `json:"durationMs,omitempty"`
+	// Progress 转码进度百分比
+	Progress   float64    `json:"progress"`
+	QueuedAt   time.Time  `json:"queuedAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	// Stderr ffmpeg 输出的末尾部分，用于排查转码失败
+	Stderr string `json:"stderr,omitempty"`
+
+	cancel     context.CancelFunc
+	output     *tailBuffer
+	lastReport time.Time
+}
+
+// JobManager 跟踪排队、进行中和最近结束的转码任务，支持取消
+type JobManager struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+	// order 按排队时间排列的任务 ID
+	order []string
+	// onUpdate 任务状态或进度变化时调用，不持有锁
+	onUpdate func(Job)
+}
+
+// NewJobManager 创建一个空的任务管理器
+func NewJobManager() *JobManager {
+	return &JobManager{jobs: make(map[string]*Job)}
+}
+
+// OnUpdate 设置任务变化的回调，需在登记任务之前调用
+func (m *JobManager) OnUpdate(fn func(Job)) {
+	m.onUpdate = fn
+}
+
+// Queue 登记一个排队中的任务，spec 提供类型、歌曲、名称等描述字段
+func (m *JobManager) Queue(spec Job) *Job {
+	id, _ := uuid.NewV4()
+	job := &Job{
+		ID:         id.String(),
+		Kind:       spec.Kind,
+		SongID:     spec.SongID,
+		UploadID:   spec.UploadID,
+		Name:       spec.Name,
+		CreatedBy:  spec.CreatedBy,
+		DurationMs: spec.DurationMs,
+		Status:     JobQueued,
+		QueuedAt:   time.Now(),
+		output:     &tailBuffer{max: maxJobStderr},
+	}
+	m.mu.Lock()
+	m.jobs[job.ID] = job
+	m.order = append(m.order, job.ID)
+	m.pruneLocked()
+	snapshot := m.snapshotLocked(job)
+	m.mu.Unlock()
+	m.notify(snapshot)
+	return job
+}
+
+// Begin 开始执行排队的任务，返回的 context 在超时或任务被取消时结束；排队期间已被取消时返回错误
+func (m *JobManager) Begin(job *Job, timeout time.Duration) (context.Context, error) {
+	m.mu.Lock()
+	if job.finished() {
+		m.mu.Unlock()
+		return nil, errJobCanceled
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	now := time.Now()
+	job.cancel = cancel
+	job.StartedAt = &now
+	job.Status = JobTranscoding
+	snapshot := m.snapshotLocked(job)
+	m.mu.Unlock()
+	m.notify(snapshot)
+	return ctx, nil
+}
+
+// SetProgress 按已转码的时长更新进度，事件最多每 progressInterval 推送一次
+func (m *JobManager) SetProgress(job *Job, done time.Duration) {
+	if job.DurationMs <= 0 {
+		return
+	}
+	m.mu.Lock()
+	// 时长来自标签，可能略短于实际音频，结束前最多显示 99%
+	job.Progress = math.Min(99, math.Floor(float64(done.Milliseconds())*1000/float64(job.DurationMs))/10)
+	if job.finished() || time.Since(job.lastReport) < progressInterval {
+		m.mu.Unlock()
+		return
+	}
+	job.lastReport = time.Now()
+	snapshot := m.snapshotLocked(job)
+	m.mu.Unlock()
+	m.notify(snapshot)
+}
+
+// Finish 记录任务结果，取消和超时优先于 ffmpeg 返回的错误
+func (m *JobManager) Finish(job *Job, ctx context.Context, err error) error {
+	m.mu.Lock()
+	ctxErr := ctx.Err()
+	job.cancel()
+	now := time.Now()
+	job.FinishedAt = &now
+	switch {
+	case errors.Is(ctxErr, context.Canceled):
+		job.Status, err = JobCancelled, errJobCanceled
+	case errors.Is(ctxErr, context.DeadlineExceeded):
+		job.Status, err = JobFailed, fmt.Errorf("ffmpeg timed out after %s", now.Sub(*job.StartedAt).Round(time.Second))
+	case err != nil:
+		job.Status = JobFailed
+	default:
+		job.Status = JobDone
+		job.Progress = 100
+	}
+	if err != nil {
+		job.Error = err.Error()
+		log.Printf("Job %s (%s %s) %s: %v", job.ID, job.Kind, job.Name, job.Status, err)
+	}
+	snapshot := m.snapshotLocked(job)
+	m.mu.Unlock()
+	m.notify(snapshot)
+	return err
+}
+
+// List 返回所有任务，最新的在前
+func (m *JobManager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]Job, 0, len(m.order))
+	for i := len(m.order) - 1; i >= 0; i-- {
+		jobs = append(jobs, m.snapshotLocked(m.jobs[m.order[i]]))
+	}
+	return jobs
+}
+
+// Get 返回一个任务
+func (m *JobManager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return m.snapshotLocked(job), true
+}
+
+// Cancel 取消任务：进行中的任务终止 ffmpeg 进程，排队中的任务直接结束
+func (m *JobManager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		return Job{}, errJobNotFound
+	}
+	if job.finished() {
+		m.mu.Unlock()
+		return Job{}, errJobFinished
+	}
+	if job.Status == JobTranscoding {
+		// 由执行任务的一方在 Finish 中记录结果
+		job.cancel()
+		snapshot := m.snapshotLocked(job)
+		m.mu.Unlock()
+		return snapshot, nil
+	}
+	now := time.Now()
+	job.FinishedAt = &now
+	job.Status = JobCancelled
+	job.Error = errJobCanceled.Error()
+	snapshot := m.snapshotLocked(job)
+	m.mu.Unlock()
+	m.notify(snapshot)
+	return snapshot, nil
+}
+
+func (m *JobManager) snapshotLocked(job *Job) Job {
+	c := *job
+	c.Stderr = job.output.String()
+	return c
+}
+
+// notify 推送任务变化，事件中不带 ffmpeg 输出，需要时通过接口查看
+func (m *JobManager) notify(job Job) {
+	if m.onUpdate == nil {
+		return
+	}
+	job.Stderr = ""
+	m.onUpdate(job)
+}
+
+func (j *Job) finished() bool {
+	return j.FinishedAt != nil
+}
+
+// pruneLocked 丢弃超出数量的已结束任务，排队和进行中的任务总是保留
+func (m *JobManager) pruneLocked() {
+	finished := 0
+	for _, id := range m.order {
+		if m.jobs[id].finished() {
+			finished++
+		}
+	}
+	kept := m.order[:0]
+	for _, id := range m.order {
+		if finished > maxFinishedJobs && m.jobs[id].finished() {
+			delete(m.jobs, id)
+			finished--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	m.order = kept
+}
+
+// tailBuffer 只保留最后 max 字节的输出
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.max; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
+
+// transcodeTimeout 单个转码任务的时间上限
+func (a *API) transcodeTimeout() time.Duration {
+	return time.Duration(a.transcodeCfg.TimeoutMinutes) * time.Minute
+}
+
+// handleGetJobs 列出进行中和最近结束的转码任务
+func (a *API) handleGetJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, a.jobs.List())
+}
+
+// handleGetJob 返回单个任务，包括 ffmpeg 的输出
+func (a *API) handleGetJob(c *gin.Context) {
+	job, ok := a.jobs.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// handleCancelJob 取消进行中的任务，只有任务的发起人和 DJ 可以取消
+func (a *API) handleCancelJob(c *gin.Context) {
+	job, ok := a.jobs.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	role := c.GetString("role")
+	if job.CreatedBy != c.GetString("username") && role != db.RoleAdmin && role != db.RoleDJ {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the uploader or a DJ can cancel this job"})
+		return
+	}
+	job, err := a.jobs.Cancel(job.ID)
+	switch {
+	case errors.Is(err, errJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+	case errors.Is(err, errJobFinished):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Job %s cancelled by %s", job.ID, c.GetString("username"))
+		c.JSON(http.StatusOK, job)
+	}
+}
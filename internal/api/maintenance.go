@@ -0,0 +1,496 @@
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/config"
+	"github.com/yeeeck/sync-jukebox/internal/logfile"
+)
+
+// 夜间维护的任务，按此顺序执行：先清理再整理数据库，最后备份整理好的数据库
+const (
+	TaskMediaGC   = "media_gc"
+	TaskHLSCache  = "hls_cache"
+	TaskVacuum    = "vacuum"
+	TaskBackup    = "backup"
+	TaskLogRotate = "log_rotate"
+)
+
+const (
+	defaultMaintenanceTime  = "04:00"
+	defaultBackupKeep       = 7
+	defaultHLSCacheIdleDays = 30
+	defaultLogMaxMB         = 50
+	defaultLogKeep          = 5
+	// orphanGracePeriod 无人引用的文件超过这个时间才删除，避免删掉正在上传或转码的文件
+	orphanGracePeriod = 24 * time.Hour
+	// maintenanceTasksKey 系统状态中保存管理员开关的键，值为任务名到是否启用的 JSON
+	maintenanceTasksKey = "maintenance_tasks"
+	// backupPrefix 备份文件名的前缀，后面跟时间戳，按名字排序即按时间排序
+	backupPrefix = "jukebox-"
+)
+
+var errMaintenanceTaskRunning = errors.New("task is already running")
+
+// MaintenanceTaskStatus 一个维护任务的开关和最近一次执行的结果
+type MaintenanceTaskStatus struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Running bool   `json:"running"`
+	// Shared 任务处理共享的数据库或媒体目录，多实例部署时只由主实例执行
+	Shared        bool       `json:"shared"`
+	LastStartedAt *time.Time `json:"lastStartedAt,omitempty"`
+	LastDuration  string     `json:"lastDuration,omitempty"`
+	// LastResult 执行结果的简短描述，例如删除了多少文件
+	LastResult string `json:"lastResult,omitempty"`
+	LastError  string `json:"lastError,omitempty"`
+}
+
+// MaintenanceStatus 夜间维护的计划和各任务的状态
+type MaintenanceStatus struct {
+	Scheduled bool `json:"scheduled"`
+	// Time 每天开始维护的本地时间
+	Time      string                  `json:"time"`
+	NextRunAt *time.Time              `json:"nextRunAt,omitempty"`
+	Tasks     []MaintenanceTaskStatus `json:"tasks"`
+}
+
+// maintenanceTask 一个维护任务，run 返回结果描述
+type maintenanceTask struct {
+	run    func() (string, error)
+	status MaintenanceTaskStatus
+}
+
+// maintenanceScheduler 记录维护任务的开关和执行状态
+type maintenanceScheduler struct {
+	mu        sync.Mutex
+	cfg       config.MaintenanceConfig
+	at        time.Time
+	tasks     map[string]*maintenanceTask
+	order     []string
+	nextRunAt *time.Time
+}
+
+// newMaintenanceScheduler 按配置和管理员保存的开关登记维护任务
+func (a *API) newMaintenanceScheduler(cfg config.MaintenanceConfig) *maintenanceScheduler {
+	at, err := time.Parse("15:04", cmp.Or(cfg.Time, defaultMaintenanceTime))
+	if err != nil {
+		log.Printf("Warning: Invalid maintenance time %q, using %s", cfg.Time, defaultMaintenanceTime)
+		at, _ = time.Parse("15:04", defaultMaintenanceTime)
+	}
+	s := &maintenanceScheduler{cfg: cfg, at: at, tasks: make(map[string]*maintenanceTask)}
+	s.add(TaskMediaGC, true, a.collectOrphanMedia)
+	s.add(TaskHLSCache, true, a.evictReferenceCaches)
+	s.add(TaskVacuum, true, a.vacuumDatabase)
+	s.add(TaskBackup, true, a.backupDatabase)
+	s.add(TaskLogRotate, false, a.rotateLog)
+	for _, name := range cfg.DisabledTasks {
+		if task, ok := s.tasks[name]; ok {
+			task.status.Enabled = false
+		} else {
+			log.Printf("Warning: Unknown maintenance task %q in disabledTasks", name)
+		}
+	}
+	if saved, err := a.db.GetSystemState(maintenanceTasksKey); err == nil && saved != "" {
+		var enabled map[string]bool
+		if err := json.Unmarshal([]byte(saved), &enabled); err != nil {
+			log.Printf("Warning: Failed to parse saved maintenance settings: %v", err)
+		}
+		for name, on := range enabled {
+			if task, ok := s.tasks[name]; ok {
+				task.status.Enabled = on
+			}
+		}
+	}
+	return s
+}
+
+func (s *maintenanceScheduler) add(name string, shared bool, run func() (string, error)) {
+	s.tasks[name] = &maintenanceTask{run: run, status: MaintenanceTaskStatus{Name: name, Enabled: true, Shared: shared}}
+	s.order = append(s.order, name)
+}
+
+// nextRun 返回 now 之后下一次到达维护时间的时刻
+func (s *maintenanceScheduler) nextRun(now time.Time) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), s.at.Hour(), s.at.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// status 返回维护计划和各任务状态的副本
+func (s *maintenanceScheduler) status() MaintenanceStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := MaintenanceStatus{
+		Scheduled: !s.cfg.Disabled,
+		Time:      s.at.Format("15:04"),
+		NextRunAt: s.nextRunAt,
+		Tasks:     make([]MaintenanceTaskStatus, 0, len(s.order)),
+	}
+	for _, name := range s.order {
+		status.Tasks = append(status.Tasks, s.tasks[name].status)
+	}
+	return status
+}
+
+// begin 把任务标记为执行中，已经在执行时返回错误
+func (s *maintenanceScheduler) begin(name string) (*maintenanceTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task := s.tasks[name]
+	if task.status.Running {
+		return nil, errMaintenanceTaskRunning
+	}
+	task.status.Running = true
+	now := time.Now()
+	task.status.LastStartedAt = &now
+	return task, nil
+}
+
+func (s *maintenanceScheduler) finish(task *maintenanceTask, result string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task.status.Running = false
+	task.status.LastDuration = time.Since(*task.status.LastStartedAt).Round(time.Millisecond).String()
+	task.status.LastResult = result
+	task.status.LastError = ""
+	if err != nil {
+		task.status.LastError = err.Error()
+	}
+}
+
+// runMaintenanceTask 执行 begin 标记过的任务并记录结果
+func (a *API) runMaintenanceTask(task *maintenanceTask) {
+	result, err := task.run()
+	a.maintenance.finish(task, result, err)
+	if err != nil {
+		log.Printf("Maintenance task %s failed: %v", task.status.Name, err)
+	} else {
+		log.Printf("Maintenance task %s finished: %s", task.status.Name, result)
+	}
+}
+
+// maintenanceLoop 每天在配置的时间依次执行启用的任务
+// 多实例部署时数据库和媒体目录是共享的，相关任务只由主实例执行，日志轮转每个实例各自执行
+func (a *API) maintenanceLoop() {
+	s := a.maintenance
+	for {
+		next := s.nextRun(time.Now())
+		s.mu.Lock()
+		s.nextRunAt = &next
+		s.mu.Unlock()
+		time.Sleep(time.Until(next))
+
+		leader := a.cluster == nil || a.cluster.IsLeader()
+		for _, name := range s.order {
+			s.mu.Lock()
+			status := s.tasks[name].status
+			s.mu.Unlock()
+			skip := !status.Enabled || (status.Shared && !leader)
+			if skip {
+				continue
+			}
+			task, err := s.begin(name)
+			if err != nil {
+				log.Printf("Maintenance task %s skipped, a manual run is still in progress", name)
+				continue
+			}
+			a.runMaintenanceTask(task)
+		}
+	}
+}
+
+// setMaintenanceTaskEnabled 打开或关闭任务的定时执行，保存在数据库中，重启后仍然有效
+func (a *API) setMaintenanceTaskEnabled(name string, enabled bool) (MaintenanceTaskStatus, error) {
+	s := a.maintenance
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks[name].status.Enabled = enabled
+	saved := make(map[string]bool, len(s.tasks))
+	for taskName, task := range s.tasks {
+		saved[taskName] = task.status.Enabled
+	}
+	data, _ := json.Marshal(saved)
+	return s.tasks[name].status, a.db.SetSystemState(maintenanceTasksKey, string(data))
+}
+
+// UseLogFile 让维护任务按配置轮转服务端的日志文件
+func (a *API) UseLogFile(lf *logfile.File) {
+	a.logFile = lf
+}
+
+// handleGetMaintenance 返回维护计划和各任务最近一次执行的结果
+func (a *API) handleGetMaintenance(c *gin.Context) {
+	c.JSON(http.StatusOK, a.maintenance.status())
+}
+
+// handleSetMaintenanceTask 打开或关闭一个任务的定时执行
+func (a *API) handleSetMaintenanceTask(c *gin.Context) {
+	var payload MaintenanceTaskPayload
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.Enabled == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "task and enabled are required"})
+		return
+	}
+	if _, ok := a.maintenance.tasks[payload.Task]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown maintenance task"})
+		return
+	}
+	status, err := a.setMaintenanceTaskEnabled(payload.Task, *payload.Enabled)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save maintenance settings"})
+		return
+	}
+	log.Printf("Action: Maintenance task %s enabled=%t by %s", payload.Task, *payload.Enabled, c.GetString("username"))
+	c.JSON(http.StatusOK, status)
+}
+
+// handleRunMaintenanceTask 立即在后台执行一个任务，关闭定时执行的任务也可以手动执行
+func (a *API) handleRunMaintenanceTask(c *gin.Context) {
+	var payload MaintenanceTaskPayload
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.Task == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "task is required"})
+		return
+	}
+	if _, ok := a.maintenance.tasks[payload.Task]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown maintenance task"})
+		return
+	}
+	task, err := a.maintenance.begin(payload.Task)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Task is already running"})
+		return
+	}
+	log.Printf("Action: Maintenance task %s started by %s", payload.Task, c.GetString("username"))
+	go a.runMaintenanceTask(task)
+	c.JSON(http.StatusAccepted, a.maintenance.status())
+}
+
+// collectOrphanMedia 删除媒体目录中没有任何歌曲（包括回收站中的歌曲）引用的目录，
+// 以及中断的上传、转换留下的临时文件和目录
+func (a *API) collectOrphanMedia() (string, error) {
+	// 入库在持有存储锁时写入数据库，先加锁再读取引用，刚入库的目录不会被误删
+	a.storageMu.Lock()
+	defer a.storageMu.Unlock()
+	paths, err := a.db.GetMediaFilePaths()
+	if err != nil {
+		return "", err
+	}
+	referenced := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		referenced[path.Dir(p)] = true
+	}
+	cutoff := time.Now().Add(-orphanGracePeriod)
+	var removed int
+	var freed int64
+	remove := func(relPath string) {
+		absPath := filepath.Join(a.mediaDir, filepath.FromSlash(relPath))
+		info, err := os.Stat(absPath)
+		if err != nil || info.ModTime().After(cutoff) {
+			return
+		}
+		size := diskUsage(absPath)
+		if err := os.RemoveAll(absPath); err != nil {
+			log.Printf("Warning: failed to delete orphaned media %s: %v", absPath, err)
+			return
+		}
+		log.Printf("Deleted orphaned media %s", relPath)
+		removed++
+		freed += size
+	}
+
+	entries, err := os.ReadDir(a.mediaDir)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case strings.HasPrefix(name, "."):
+		case name == soundboardDir && entry.IsDir():
+			// 音效板片段不属于任何歌曲，删除片段时一并删除文件
+		case name == contentRoot && entry.IsDir():
+			shards, err := os.ReadDir(filepath.Join(a.mediaDir, contentRoot))
+			if err != nil {
+				return "", err
+			}
+			for _, shard := range shards {
+				shardPath := path.Join(contentRoot, shard.Name())
+				dirs, err := os.ReadDir(filepath.Join(a.mediaDir, filepath.FromSlash(shardPath)))
+				if err != nil {
+					continue
+				}
+				for _, dir := range dirs {
+					if dirPath := path.Join(shardPath, dir.Name()); !referenced[dirPath] {
+						remove(dirPath)
+					}
+				}
+				// 空的分片目录顺手删掉，非空时 Remove 失败，忽略
+				os.Remove(filepath.Join(a.mediaDir, filepath.FromSlash(shardPath)))
+			}
+		case entry.IsDir():
+			// 按歌曲 ID 命名的旧目录、原地引用的 HLS 缓存、替换和转换中断留下的临时目录
+			if !referenced[name] {
+				remove(name)
+			}
+		case strings.HasPrefix(name, "temp_"):
+			remove(name)
+		}
+	}
+	return fmt.Sprintf("removed %d entries, freed %s", removed, formatBytes(freed)), nil
+}
+
+// evictReferenceCaches 重新检查原地引用的源文件，并删除已经不再有用的 HLS 缓存：
+// 源文件不在了（删除或移走）且超过保留天数没有播放，源文件重新出现时会重新转换
+func (a *API) evictReferenceCaches() (string, error) {
+	a.VerifyReferences()
+	songs, err := a.db.GetReferencedSongs()
+	if err != nil {
+		return "", err
+	}
+	idleDays := a.maintenance.cfg.HLSCacheIdleDays
+	if idleDays <= 0 {
+		idleDays = defaultHLSCacheIdleDays
+	}
+	cutoff := time.Now().AddDate(0, 0, -idleDays)
+	var evicted int
+	var freed int64
+	for _, song := range songs {
+		if _, err := os.Stat(song.SourcePath); err == nil {
+			continue
+		}
+		lastUsed, err := a.db.LastPlayedAt(song.ID)
+		if err != nil {
+			continue
+		}
+		// 从未播放过时按登记时间计算，升级前登记的歌曲没有记录，视为很久以前
+		if lastUsed.IsZero() && song.UploadedAt != nil {
+			lastUsed = *song.UploadedAt
+		}
+		if lastUsed.After(cutoff) {
+			continue
+		}
+		// 引用歌曲的缓存总是按歌曲 ID 命名，不与其他歌曲共享
+		relDir := path.Dir(song.FilePath)
+		if relDir != song.ID {
+			continue
+		}
+		cacheDir := filepath.Join(a.mediaDir, song.ID)
+		if _, err := os.Stat(cacheDir); err != nil {
+			continue
+		}
+		size := diskUsage(cacheDir)
+		if err := os.RemoveAll(cacheDir); err != nil {
+			log.Printf("Warning: failed to evict HLS cache of %s: %v", song.SourcePath, err)
+			continue
+		}
+		evicted++
+		freed += size
+	}
+	return fmt.Sprintf("evicted %d caches, freed %s", evicted, formatBytes(freed)), nil
+}
+
+// vacuumDatabase 整理数据库文件并更新统计信息
+func (a *API) vacuumDatabase() (string, error) {
+	if err := a.db.Vacuum(); err != nil {
+		return "", err
+	}
+	return "database vacuumed and analyzed", nil
+}
+
+// backupDatabase 把数据库快照写入备份目录，只保留最近的几份
+func (a *API) backupDatabase() (string, error) {
+	cfg := a.maintenance.cfg
+	if cfg.BackupDir == "" {
+		return "skipped, backupDir is not configured", nil
+	}
+	if err := os.MkdirAll(cfg.BackupDir, 0755); err != nil {
+		return "", err
+	}
+	name := backupPrefix + time.Now().Format("20060102-150405") + ".db"
+	if err := a.db.BackupTo(filepath.Join(cfg.BackupDir, name)); err != nil {
+		return "", err
+	}
+	keep := cfg.BackupKeep
+	if keep <= 0 {
+		keep = defaultBackupKeep
+	}
+	backups, err := filepath.Glob(filepath.Join(cfg.BackupDir, backupPrefix+"*.db"))
+	if err != nil {
+		return "", err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	for _, old := range backups[min(keep, len(backups)):] {
+		if err := os.Remove(old); err != nil {
+			log.Printf("Warning: failed to delete old backup %s: %v", old, err)
+		}
+	}
+	return "saved " + name, nil
+}
+
+// rotateLog 日志文件超过大小上限时轮转
+func (a *API) rotateLog() (string, error) {
+	if a.logFile == nil {
+		return "skipped, logFile is not configured", nil
+	}
+	cfg := a.maintenance.cfg
+	maxBytes := int64(cfg.LogMaxMB) << 20
+	if maxBytes <= 0 {
+		maxBytes = defaultLogMaxMB << 20
+	}
+	size := a.logFile.Size()
+	if size < maxBytes {
+		return fmt.Sprintf("%s is %s, below the limit", a.logFile.Path(), formatBytes(size)), nil
+	}
+	keep := cfg.LogKeep
+	if keep <= 0 {
+		keep = defaultLogKeep
+	}
+	if err := a.logFile.Rotate(keep); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("rotated %s (%s)", a.logFile.Path(), formatBytes(size)), nil
+}
+
+// diskUsage 返回文件或目录下所有文件的总字节数
+func diskUsage(p string) int64 {
+	var total int64
+	filepath.WalkDir(p, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// formatBytes 把字节数格式化为便于阅读的大小
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maintenanceRetryAfter 维护模式下拒绝请求时建议客户端重试的间隔（秒）
+const maintenanceRetryAfter = "60"
+
+// maintenanceAllowedRoutes 维护模式下仍然接受的修改请求：登录、退出、只读的 GraphQL 查询和关闭维护模式本身
+var maintenanceAllowedRoutes = map[string]bool{
+	"POST /api/login":                  true,
+	"POST /api/join":                   true,
+	"POST /api/auth/oidc/exchange":     true,
+	"POST /api/pair":                   true,
+	"POST /api/auth/logout":            true,
+	"POST /api/graphql":                true,
+	"POST /api/admin/maintenance-mode": true,
+}
+
+// maintenanceModeMiddleware 维护模式下以 503 拒绝修改请求，读取请求照常处理
+func (a *API) maintenanceModeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		if maintenanceAllowedRoutes[c.Request.Method+" "+c.FullPath()] || !a.state.InMaintenance() {
+			c.Next()
+			return
+		}
+		c.Header("Retry-After", maintenanceRetryAfter)
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "The jukebox is in maintenance mode, try again later"})
+	}
+}
+
+// handleSetMaintenanceMode 开启或关闭维护模式，开启时暂停播放，关闭时恢复之前的播放
+func (a *API) handleSetMaintenanceMode(c *gin.Context) {
+	var payload MaintenanceModePayload
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.Enabled == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "enabled is required"})
+		return
+	}
+	c.JSON(http.StatusOK, a.state.SetMaintenanceMode(*payload.Enabled, payload.Message, actorFrom(c)))
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 浏览器的 <audio> 和 HLS 请求无法带 Authorization 头，登录后签发只对媒体路径有效的签名 Cookie，
+// 流数量限制和流量统计据此识别用户
+const (
+	mediaCookieName = "jukebox_media"
+	// mediaSessionTTL Cookie 的有效期，前端每次建立 WebSocket 连接时续期
+	mediaSessionTTL = 24 * time.Hour
+	// mediaSigningKeyState 签名密钥在系统状态中的键，集群的各个实例共用同一个密钥
+	mediaSigningKeyState = "media_signing_key"
+)
+
+// MediaSession 签发的媒体 Cookie 的有效期
+type MediaSession struct {
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// loadMediaSigningKey 读取签名密钥，还没有时生成一个；只读副本只读取，主实例没有生成过时不签发媒体 Cookie
+func (a *API) loadMediaSigningKey() []byte {
+	value, err := a.db.GetSystemState(mediaSigningKeyState)
+	if err == nil && value == "" && !a.readOnly {
+		b := make([]byte, 32)
+		if _, err = rand.Read(b); err == nil {
+			value, err = a.db.InitSystemState(mediaSigningKeyState, hex.EncodeToString(b))
+		}
+	}
+	if err != nil || value == "" {
+		log.Printf("Warning: Media cookies are disabled, media requests are only identified by the Authorization header: %v", err)
+		return nil
+	}
+	key, err := hex.DecodeString(value)
+	if err != nil {
+		log.Printf("Warning: Invalid media signing key, media cookies are disabled: %v", err)
+		return nil
+	}
+	return key
+}
+
+func (a *API) signMedia(payload string) string {
+	mac := hmac.New(sha256.New, a.mediaSigningKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// mediaCookieValue 生成 Cookie 的值：base64(用户名).过期时间.签名
+func (a *API) mediaCookieValue(username string, expiresAt time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(username)) + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return payload + "." + a.signMedia(payload)
+}
+
+// mediaCookieUser 校验媒体 Cookie 并返回其中的用户名，无效或过期时返回空
+func (a *API) mediaCookieUser(r *http.Request) string {
+	if a.mediaSigningKey == nil {
+		return ""
+	}
+	cookie, err := r.Cookie(mediaCookieName)
+	if err != nil {
+		return ""
+	}
+	i := strings.LastIndex(cookie.Value, ".")
+	if i < 0 {
+		return ""
+	}
+	payload, sig := cookie.Value[:i], cookie.Value[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(a.signMedia(payload))) {
+		return ""
+	}
+	encodedUser, expiry, ok := strings.Cut(payload, ".")
+	if !ok {
+		return ""
+	}
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() >= expiresAt {
+		return ""
+	}
+	username, err := base64.RawURLEncoding.DecodeString(encodedUser)
+	if err != nil {
+		return ""
+	}
+	return string(username)
+}
+
+// clearMediaCookie 登出时删除媒体 Cookie，之后的播放不再计入这个用户
+func clearMediaCookie(c *gin.Context) {
+	http.SetCookie(c.Writer, &http.Cookie{Name: mediaCookieName, Path: mediaPathPrefix, MaxAge: -1, HttpOnly: true})
+}
+
+// handleMediaSession 为当前用户签发媒体 Cookie，之后浏览器的媒体请求都会带上它
+func (a *API) handleMediaSession(c *gin.Context) {
+	if a.mediaSigningKey == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Media cookies are not available on this server"})
+		return
+	}
+	expiresAt := time.Now().Add(mediaSessionTTL)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     mediaCookieName,
+		Value:    a.mediaCookieValue(c.GetString("username"), expiresAt),
+		Path:     mediaPathPrefix,
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, MediaSession{ExpiresAt: expiresAt})
+}
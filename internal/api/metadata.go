@@ -4,29 +4,76 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
 	"os/exec"
 	"strconv"
+	"strings"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
 )
 
 // ffprobeOutput 定义了我们关心的 ffprobe JSON 输出结构
 type ffprobeOutput struct {
 	Format struct {
-		Duration string `json:"duration"`
-		Tags     struct {
-			Title  string `json:"title"`
-			Artist string `json:"artist"`
-			Album  string `json:"album"`
-		} `json:"tags"`
+		FormatName string            `json:"format_name"`
+		Duration   string            `json:"duration"`
+		Size       string            `json:"size"`
+		BitRate    string            `json:"bit_rate"`
+		Tags       map[string]string `json:"tags"` // 不同容器的标签大小写不一致，统一用 tag() 读取
 	} `json:"format"`
+	Streams []struct {
+		CodecType  string `json:"codec_type"`
+		CodecName  string `json:"codec_name"`
+		SampleRate string `json:"sample_rate"`
+		Channels   int    `json:"channels"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"streams"`
+	Chapters []struct {
+		StartTime string `json:"start_time"`
+		EndTime   string `json:"end_time"`
+		Tags      struct {
+			Title string `json:"title"`
+		} `json:"tags"`
+	} `json:"chapters"`
+}
+
+// audioMetadata 是从音频文件中提取出的元数据
+type audioMetadata struct {
+	Title  string
+	Artist string
+	Album  string
+	// AlbumArtist 和 Compilation 来自 album_artist 与 compilation（iTunes 的 cpil、ID3 的 TCMP）标签
+	AlbumArtist string
+	Compilation bool
+	Genre       string
+	// Mood 来自 mood 标签（ID3 的 TMOO），没有时由分类服务补齐
+	Mood string
+	// Year 发行年份，没有 date/year 标签时为 0
+	Year       int
+	DurationMs int
+	Explicit   bool
+	Chapters   []db.Chapter
+	// GainDb 标签中已有的 ReplayGain，没有时为空，由后台分析补齐
+	GainDb *float64
+	// BPM 和 MusicalKey（Camelot 记法）来自 DJ 软件写入的标签，没有时为零值
+	BPM        float64
+	MusicalKey string
+	// Technical 源文件的编码参数，HLS 码率由调用方在转码后补上
+	Technical db.TechnicalInfo
 }
 
-// getAudioMetadata 使用 ffprobe 读取音频文件的元数据
-func getAudioMetadata(filePath string) (title, artist, album string, durationMs int, err error) {
-	// ffprobe -v quiet -print_format json -show_format "path/to/file"
+// getAudioMetadata 使用 ffprobe 读取音频文件的元数据，没有安装 ffprobe 时改用纯 Go 的解析器
+func getAudioMetadata(filePath string) (*audioMetadata, error) {
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return nativeMetadata(filePath)
+	}
+	// ffprobe -v quiet -print_format json -show_format -show_streams -show_chapters "path/to/file"
 	cmd := exec.Command("ffprobe",
 		"-v", "quiet",
 		"-print_format", "json",
 		"-show_format",
+		"-show_streams",
+		"-show_chapters",
 		filePath,
 	)
 
@@ -35,23 +82,153 @@ func getAudioMetadata(filePath string) (title, artist, album string, durationMs
 	cmd.Stdout = &out
 	cmd.Stderr = &stderr
 
-	if err = cmd.Run(); err != nil {
-		return "", "", "", 0, fmt.Errorf("ffprobe error: %v, details: %s", err, stderr.String())
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffprobe error: %v, details: %s", err, stderr.String())
 	}
 
 	var ffData ffprobeOutput
-	if err = json.Unmarshal(out.Bytes(), &ffData); err != nil {
-		return "", "", "", 0, fmt.Errorf("error parsing ffprobe output: %w", err)
+	if err := json.Unmarshal(out.Bytes(), &ffData); err != nil {
+		return nil, fmt.Errorf("error parsing ffprobe output: %w", err)
+	}
+
+	meta := &audioMetadata{
+		// 解析时长（字符串转为毫秒）
+		DurationMs: int(parseSeconds(ffData.Format.Duration)),
+		// 优先使用元数据中的标题，如果为空，则由调用方使用文件名
+		Title:  tag(ffData.Format.Tags, "title"),
+		Artist: tag(ffData.Format.Tags, "artist"),
+		Album:  tag(ffData.Format.Tags, "album"),
+		// ffprobe 把 ID3 的 TPE2 和 Vorbis 的 ALBUMARTIST 都映射为 album_artist
+		AlbumArtist: tag(ffData.Format.Tags, "album_artist", "albumartist", "album artist"),
+		Compilation: isCompilation(ffData.Format.Tags),
+		Genre:       tag(ffData.Format.Tags, "genre"),
+		Mood:        tag(ffData.Format.Tags, "mood", "TMOO"),
+		Year:        parseYear(tag(ffData.Format.Tags, "date", "year", "originaldate", "TDRC", "TYER", "TORY")),
+		Explicit:    isExplicit(ffData.Format.Tags),
+		GainDb:      replayGainTag(ffData.Format.Tags),
+		BPM:         bpmTag(ffData.Format.Tags),
+		MusicalKey:  db.CamelotKey(tag(ffData.Format.Tags, "initialkey", "key", "TKEY")),
+		Technical: db.TechnicalInfo{
+			Container:   ffData.Format.FormatName,
+			BitrateKbps: atoi(ffData.Format.BitRate) / 1000,
+		},
+	}
+	meta.Technical.FileSize, _ = strconv.ParseInt(ffData.Format.Size, 10, 64)
+	// 只记录第一条音频流，封面等视频流忽略
+	for _, s := range ffData.Streams {
+		if s.CodecType != "audio" {
+			continue
+		}
+		meta.Technical.Codec = s.CodecName
+		meta.Technical.SampleRate = atoi(s.SampleRate)
+		meta.Technical.Channels = s.Channels
+		// 有些容器只在格式层给出总码率
+		if kbps := atoi(s.BitRate) / 1000; kbps > 0 {
+			meta.Technical.BitrateKbps = kbps
+		}
+		break
 	}
 
-	// 解析时长（字符串转为毫秒）
-	durationFloat, _ := strconv.ParseFloat(ffData.Format.Duration, 64)
-	durationMs = int(durationFloat * 1000)
+	// 章节标记（混音、有声书等）
+	for i, ch := range ffData.Chapters {
+		title := ch.Tags.Title
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", i+1)
+		}
+		meta.Chapters = append(meta.Chapters, db.Chapter{
+			Index:   i,
+			Title:   title,
+			StartMs: parseSeconds(ch.StartTime),
+			EndMs:   parseSeconds(ch.EndTime),
+		})
+	}
+
+	return meta, nil
+}
 
-	// 优先使用元数据中的标题，如果为空，则使用文件名
-	title = ffData.Format.Tags.Title
-	artist = ffData.Format.Tags.Artist
-	album = ffData.Format.Tags.Album
+// tag 不区分大小写地读取标签，按给定顺序返回第一个非空值
+func tag(tags map[string]string, keys ...string) string {
+	for _, key := range keys {
+		for k, v := range tags {
+			if strings.EqualFold(k, key) && strings.TrimSpace(v) != "" {
+				return strings.TrimSpace(v)
+			}
+		}
+	}
+	return ""
+}
+
+// isExplicit 根据常见的内容分级标签判断是否为露骨内容
+// iTunes: ITUNESADVISORY / rating (1 或 4 为 explicit，2 为 clean)；Vorbis: EXPLICIT
+func isExplicit(tags map[string]string) bool {
+	switch tag(tags, "ITUNESADVISORY", "rating") {
+	case "1", "4":
+		return true
+	}
+	switch strings.ToLower(tag(tags, "EXPLICIT")) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// parseYear 从日期标签（"1997"、"1997-05-21"、"1997/05"）中取出年份，无法识别时返回 0
+func parseYear(date string) int {
+	if len(date) < 4 {
+		return 0
+	}
+	year, err := strconv.Atoi(date[:4])
+	if err != nil || year < 1000 {
+		return 0
+	}
+	return year
+}
+
+// isCompilation 读取合辑标记
+func isCompilation(tags map[string]string) bool {
+	switch strings.ToLower(tag(tags, "compilation", "cpil", "TCMP")) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// bpmTag 读取 DJ 软件写入的 BPM（ID3 的 TBPM、Vorbis 的 BPM），保留一位小数，没有或无效时返回 0
+func bpmTag(tags map[string]string) float64 {
+	bpm, err := strconv.ParseFloat(strings.ReplaceAll(tag(tags, "bpm", "TBPM", "tempo"), ",", "."), 64)
+	if err != nil || bpm <= 0 || bpm > 1000 {
+		return 0
+	}
+	return math.Round(bpm*10) / 10
+}
+
+// replayGainTag 读取 REPLAYGAIN_TRACK_GAIN（例如 "-6.52 dB"）或 Opus 的 R128_TRACK_GAIN
+// R128 是相对 -23 LUFS 的 Q7.8 定点数，换算到 ReplayGain 的 -18 LUFS 参考需要加 5 dB
+
+func replayGainTag(tags map[string]string) *float64 {
+	if v := tag(tags, "REPLAYGAIN_TRACK_GAIN"); v != "" {
+		v = strings.TrimSpace(strings.TrimSuffix(strings.ToLower(v), "db"))
+		if gain, err := strconv.ParseFloat(v, 64); err == nil {
+			return &gain
+		}
+	}
+	if v := tag(tags, "R128_TRACK_GAIN"); v != "" {
+		if q, err := strconv.Atoi(v); err == nil {
+			gain := float64(q)/256 + 5
+			return &gain
+		}
+	}
+	return nil
+}
+
+// parseSeconds 将 ffprobe 输出的秒数字符串转换为毫秒
+func parseSeconds(s string) int64 {
+	seconds, _ := strconv.ParseFloat(s, 64)
+	return int64(seconds * 1000)
+}
 
-	return title, artist, album, durationMs, nil
+// atoi 解析 ffprobe 以字符串输出的整数（采样率、码率），缺失或为 "N/A" 时返回 0
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
 }
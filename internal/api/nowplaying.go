@@ -0,0 +1,263 @@
+package api
+
+import (
+	"bytes"
+	"html/template"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/i18n"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// 公开的正在播放页面每个 IP 的请求上限，聊天软件抓取预览时会连续请求页面和图片
+const (
+	nowPlayingPerMinute = 60
+	nowPlayingBurst     = 20
+	// nowPlayingMaxAge 允许浏览器和聊天软件缓存的秒数
+	nowPlayingMaxAge = 15
+)
+
+// NowPlaying 公开的当前播放信息，只包含当前歌曲，不包含播放列表和用户
+type NowPlaying struct {
+	IsPlaying  bool   `json:"isPlaying"`
+	Title      string `json:"title,omitempty"`
+	Artist     string `json:"artist,omitempty"`
+	Album      string `json:"album,omitempty"`
+	DurationMs int    `json:"durationMs"`
+	ProgressMs int64  `json:"progressMs"`
+	Listeners  int    `json:"listeners"`
+	ArtworkURL string `json:"artworkUrl,omitempty"`
+	// artworkPath 封面在磁盘上的路径，用于绘制徽章
+	artworkPath string
+}
+
+// nowPlaying 读取当前歌曲，baseURL 用于生成封面的绝对地址
+func (a *API) nowPlaying(baseURL string) NowPlaying {
+	snapshot := a.state.Snapshot()
+	np := NowPlaying{Listeners: a.hub.ClientCount()}
+	song := snapshot.CurrentSong
+	if song == nil {
+		return np
+	}
+	np.IsPlaying = snapshot.IsPlaying
+	np.Title = song.Title
+	np.Artist = song.Artist
+	np.Album = song.Album
+	np.DurationMs = song.DurationMs
+	np.ProgressMs = snapshot.ProgressMs
+	if p := a.artworkPath(song); p != "" {
+		np.artworkPath = p
+		np.ArtworkURL = artworkURL(song, baseURL)
+	}
+	return np
+}
+
+// requestBaseURL 按请求推算外部访问地址，反向代理需要传 X-Forwarded-Proto
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// handleNowPlayingJSON 返回当前播放信息
+func (a *API) handleNowPlayingJSON(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age="+strconv.Itoa(nowPlayingMaxAge))
+	c.JSON(http.StatusOK, a.nowPlaying(requestBaseURL(c)))
+}
+
+var nowPlayingPage = template.Must(template.New("nowplaying").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="30">
+<title>{{.Heading}}</title>
+<meta property="og:type" content="music.song">
+<meta property="og:site_name" content="SyncJukebox">
+<meta property="og:title" content="{{.Heading}}">
+<meta property="og:description" content="{{.Description}}">
+<meta property="og:image" content="{{.Image}}">
+<meta property="og:url" content="{{.URL}}">
+<meta name="twitter:card" content="summary">
+<style>
+body { font-family: system-ui, sans-serif; background: #1e1e2e; color: #eee; display: flex; justify-content: center; padding: 2em; }
+.card { display: flex; gap: 1.5em; align-items: center; max-width: 40em; }
+img { width: 160px; height: 160px; object-fit: cover; border-radius: 8px; background: #333; }
+a { color: #8ab4f8; }
+</style>
+</head>
+<body>
+<div class="card">
+<img src="{{.Image}}" alt="">
+<div>
+<p>{{.Status}}</p>
+{{with .NowPlaying}}{{if .Title}}<h1>{{.Title}}</h1>{{if .Artist}}<h2>{{.Artist}}</h2>{{end}}{{end}}{{end}}
+<p>{{.Description}}</p>
+<p><a href="/">{{.JoinText}}</a></p>
+</div>
+</div>
+</body>
+</html>
+`))
+
+// handleNowPlayingPage 带 OpenGraph 标签的分享页面，聊天软件据此生成预览卡片
+func (a *API) handleNowPlayingPage(c *gin.Context) {
+	base := requestBaseURL(c)
+	np := a.nowPlaying(base)
+	heading := "SyncJukebox"
+	if np.Title != "" {
+		heading = np.Title
+		if np.Artist != "" {
+			heading += " — " + np.Artist
+		}
+	}
+	preview := base + "/nowplaying.png"
+	if np.ArtworkURL != "" {
+		preview = np.ArtworkURL
+	}
+	// 公开页面没有登录用户，按浏览器（或聊天软件抓取预览时）的 Accept-Language 选择语言
+	lang := i18n.Negotiate(c.GetHeader("Accept-Language"))
+	status := "Nothing playing"
+	switch {
+	case np.IsPlaying && np.Title != "":
+		status = "Now playing"
+	case np.Title != "":
+		status = "Paused"
+	}
+	var buf bytes.Buffer
+	err := nowPlayingPage.Execute(&buf, gin.H{
+		"NowPlaying":  np,
+		"Lang":        lang,
+		"Status":      i18n.T(lang, status),
+		"JoinText":    i18n.T(lang, "Join the party"),
+		"Heading":     heading,
+		"Description": i18n.T(lang, listenersText(np.Listeners)),
+		"Image":       preview,
+		"URL":         base + "/nowplaying",
+	})
+	if err != nil {
+		log.Printf("Failed to render now playing page: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Header("Cache-Control", "public, max-age="+strconv.Itoa(nowPlayingMaxAge))
+	c.Header("Vary", "Accept-Language")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
+
+func listenersText(n int) string {
+	if n == 1 {
+		return "1 listener"
+	}
+	return strconv.Itoa(n) + " listeners"
+}
+
+// 徽章尺寸和配色
+const (
+	badgeWidth   = 480
+	badgeHeight  = 120
+	badgePadding = 12
+	badgeArtSize = badgeHeight - 2*badgePadding
+)
+
+var (
+	badgeBackground = color.RGBA{0x1e, 0x1e, 0x2e, 0xff}
+	badgeAccent     = color.RGBA{0x8a, 0xb4, 0xf8, 0xff}
+	badgeText       = color.RGBA{0xee, 0xee, 0xee, 0xff}
+	badgeMuted      = color.RGBA{0x99, 0x99, 0xaa, 0xff}
+)
+
+// handleNowPlayingBadge 生成 PNG 徽章：封面、歌名、歌手和在线人数
+func (a *API) handleNowPlayingBadge(c *gin.Context) {
+	np := a.nowPlaying(requestBaseURL(c))
+	img := image.NewRGBA(image.Rect(0, 0, badgeWidth, badgeHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(badgeBackground), image.Point{}, draw.Src)
+
+	art := image.Rect(badgePadding, badgePadding, badgePadding+badgeArtSize, badgePadding+badgeArtSize)
+	if cover := loadArtwork(np.artworkPath); cover != nil {
+		draw.CatmullRom.Scale(img, art, cover, cover.Bounds(), draw.Over, nil)
+	} else {
+		draw.Draw(img, art, image.NewUniform(badgeAccent), image.Point{}, draw.Src)
+	}
+
+	status := "NOTHING PLAYING"
+	switch {
+	case np.Title != "" && np.IsPlaying:
+		status = "NOW PLAYING"
+	case np.Title != "":
+		status = "PAUSED"
+	}
+	x := badgePadding*2 + badgeArtSize
+	maxChars := (badgeWidth - x - badgePadding) / basicfont.Face7x13.Advance
+	lines := []struct {
+		text  string
+		color color.Color
+	}{
+		{status, badgeAccent},
+		{np.Title, badgeText},
+		{np.Artist, badgeMuted},
+		{listenersText(np.Listeners), badgeMuted},
+	}
+	for i, line := range lines {
+		drawer := &font.Drawer{
+			Dst:  img,
+			Src:  image.NewUniform(line.color),
+			Face: basicfont.Face7x13,
+			Dot:  fixed.P(x, badgePadding+13+i*24),
+		}
+		drawer.DrawString(badgeString(line.text, maxChars))
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Header("Cache-Control", "public, max-age="+strconv.Itoa(nowPlayingMaxAge))
+	c.Data(http.StatusOK, "image/png", buf.Bytes())
+}
+
+// badgeString 内置点阵字体只有 ASCII，其余字符显示为 ?，超出宽度时截断
+func badgeString(s string, maxChars int) string {
+	runes := []rune(strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII {
+			return '?'
+		}
+		return r
+	}, s))
+	if len(runes) > maxChars {
+		runes = append(runes[:maxChars-3], '.', '.', '.')
+	}
+	return string(runes)
+}
+
+func loadArtwork(p string) image.Image {
+	if p == "" {
+		return nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	img, err := jpeg.Decode(f)
+	if err != nil {
+		return nil
+	}
+	return img
+}
@@ -0,0 +1,448 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/federation"
+	"github.com/yeeeck/sync-jukebox/internal/state"
+)
+
+// OpenAPI 文档在构建前由 cmd/openapi-gen 根据路由表和请求体结构生成并嵌入，
+// 新增或修改接口后运行 go generate ./internal/api 更新
+
+//go:generate go run ../../cmd/openapi-gen -o openapi.json
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// routeDoc 描述一个接口，用于生成 OpenAPI 文档
+type routeDoc struct {
+	Summary   string
+	Request   interface{} // 请求体类型的零值，nil 表示没有请求体
+	Response  interface{} // 成功响应体类型的零值，nil 表示只返回状态码
+	Role      string      // 需要的最低角色，空表示任意已登录用户
+	Multipart bool        // 请求体为 multipart/form-data
+}
+
+// routeDocs 以 "METHOD 路径" 为键；没有登记的路由仍会出现在文档中，但没有说明
+var routeDocs = map[string]routeDoc{
+	"GET /ws":                                          {Summary: "WebSocket connection for state updates and events; authenticate with an Authorization header or ?ticket= from POST /api/ws/ticket"},
+	"POST /api/ws/ticket":                              {Summary: "Single-use ticket for opening the WebSocket as the current user, valid for 30 seconds; pass it as /ws?ticket= so credentials stay out of the URL", Response: WSTicket{}},
+	"POST /api/media/session":                          {Summary: "Set a signed jukebox_media cookie for /static/audio, valid for 24 hours, so the browser's media requests count toward the user's stream and bandwidth limits", Response: MediaSession{}},
+	"POST /api/register":                               {Summary: "Register with an invitation key", Request: RegisterPayload{}},
+	"POST /api/login":                                  {Summary: "Check credentials and return the user's role; behind a trusted auth proxy no credentials are needed. With rememberDevice a long-lived token is returned to use as the Basic Auth password instead of the real one (not on read-only replicas)", Request: LoginPayload{}},
+	"GET /api/csrf":                                    {Summary: "CSRF token (also set as the jukebox_csrf cookie); browsers must send it in X-CSRF-Token on POST/PUT/PATCH/DELETE", Response: CSRFToken{}},
+	"GET /api/auth/oidc":                               {Summary: "Whether single sign-on is enabled and the label for the login button", Response: SSOInfo{}},
+	"GET /api/auth/oidc/login":                         {Summary: "Redirect to the identity provider to sign in"},
+	"GET /api/auth/oidc/callback":                      {Summary: "Identity provider callback; redirects to /login?sso=<code> on success or /login?ssoError=<message>"},
+	"POST /api/auth/oidc/exchange":                     {Summary: "Redeem the one-time code from the callback for credentials; use username and token as Basic Auth username and password", Request: SSOExchangePayload{}, Response: SSOLogin{}},
+	"GET /api/auth/proxy":                              {Summary: "The user signed in by a trusted authentication proxy (Remote-User / X-Forwarded-User), 401 when there is none", Response: ProxyLogin{}},
+	"GET /api/trigger/:action":                         {Summary: "Automation trigger for IFTTT, Zapier and smart buttons: action is play, pause, toggle, next or queue (adds the playlist given by ?playlist=<id> or the token's default). Authenticate with ?token=<static token from token-cli> or Authorization: Bearer; rate limited per IP"},
+	"POST /api/trigger/:action":                        {Summary: "Same as GET /api/trigger/:action, for platforms that only send POST webhooks"},
+	"POST /api/analysis/lease":                         {Summary: "External analysis worker: lease up to max songs (default 1, max 20) for fingerprinting, BPM/key detection or genre classification; results must be posted back within leaseSeconds or the task is handed out again. Authenticate with Authorization: Bearer <analysis.workerToken>", Request: AnalysisLeasePayload{}, Response: AnalysisLease{}},
+	"POST /api/analysis/tasks/:id/result":              {Summary: "Post the analysis result for a leased song; only the given fields are updated, keys are stored in Camelot notation and genre is only filled in when the song has none", Request: AnalysisResultPayload{}},
+	"POST /api/analysis/tasks/:id/fail":                {Summary: "Report that a leased song could not be analyzed; it is retried until analysis.maxAttempts is reached, the response holds the task's new status", Request: AnalysisFailPayload{}},
+	"POST /api/pair":                                   {Summary: "Redeem the token from a pairing QR code for credentials on a new device; use username and token as Basic Auth username and password", Request: PairPayload{}, Response: SSOLogin{}},
+	"GET /api/pair/qr":                                 {Summary: "PNG QR code linking to /login?pair=<token> that signs a phone in to the current account without a password; single use, expires after 2 minutes. X-Pairing-URL holds the link and X-Pairing-Expires its expiry"},
+	"POST /api/auth/logout":                            {Summary: "Revoke the login token used for this request (no-op for password logins)"},
+	"GET /api/openapi.json":                            {Summary: "This OpenAPI document"},
+	"GET /api/docs":                                    {Summary: "Swagger UI for this API"},
+	"GET /api/graphql":                                 {Summary: "Run a GraphQL query (query, operationName, variables as query parameters)"},
+	"POST /api/graphql":                                {Summary: "Run a GraphQL query; send Accept: text/event-stream for subscriptions", Request: GraphQLRequest{}},
+	"GET /api/me/budget":                               {Summary: "Remaining skips and requests for the current user", Response: Budget{}},
+	"GET /api/settings":                                {Summary: "Room settings (crossfade, fade on skip and pause, family mode, skip and request limits, queue fairness, max song length, allowed sources); also included in the full state as settings", Response: state.Settings{}},
+	"POST /api/settings":                               {Summary: "Update room settings; omitted fields are left unchanged. Family mode and queue mode changes take effect as with their own endpoints", Request: SettingsPayload{}, Response: state.Settings{}, Role: db.RoleAdmin},
+	"GET /api/me/language":                             {Summary: "Language of server messages for the current user", Response: LanguageSettings{}},
+	"POST /api/me/language":                            {Summary: "Set the language of server messages, empty to follow Accept-Language", Request: LanguagePayload{}, Response: LanguageSettings{}},
+	"DELETE /api/account":                              {Summary: "Delete the current account; history, queue, party and playlist entries keep their records with the username cleared. Pass ?deleteUploads=true to also move the user's uploads to the trash"},
+	"GET /api/account/devices":                         {Summary: "Devices signed in with a remembered-device, pairing or single sign-on token; current marks the one making this request", Response: []DeviceSession{}},
+	"DELETE /api/account/devices/:id":                  {Summary: "Sign out a device by revoking its token"},
+	"GET /api/account/bandwidth":                       {Summary: "Media bandwidth used by the current user this month and the per-user monthly cap (0 means no cap)", Response: BandwidthStats{}},
+	"GET /api/account/export":                          {Summary: "Download the current user's data (profile, preferences, uploads, requested plays, saved playlists) as JSON", Response: AccountExport{}},
+	"GET /api/scrobbles":                               {Summary: "Export the scrobble log: format=json (default) lists every play with eligible marking Last.fm's rules (longer than 30s, played half or 4 minutes); format=csv exports eligible plays as Last.fm CSV (artist,album,title,date in UTC, no header). Filter with from and to (2006-01-02 in UTC, to includes that day, or RFC 3339) and requestedBy", Response: []ScrobbleEntry{}},
+	"GET /api/scrobbles/scrobbler.log":                 {Summary: "Export the scrobble log as an Audioscrobbler 1.1 .scrobbler.log for offline scrobbling tools; plays that miss Last.fm's rules are rated S (skipped). Accepts the same filters as /api/scrobbles"},
+	"GET /api/stats/activity":                          {Summary: "Plays and listening minutes bucketed by day of week (0 is Sunday) and hour of day for a heatmap. Filter with from and to (2006-01-02 or RFC 3339, default the last 30 days) and requestedBy; tz is an IANA time zone for the buckets, default the server's. A play counts in the hour it started", Response: ListeningActivity{}},
+	"GET /api/stats/top/artists":                       {Summary: "Most played artists (featured artists count for the primary artist) with play count, listening minutes, distinct requesting users and artwork from their most played song. Only plays that meet Last.fm's scrobble rules count. Accepts the same range filters as /api/stats/activity and limit (default 20, max 100)", Response: TopArtists{}},
+	"GET /api/stats/skips":                             {Summary: "Songs with the highest skip rate (manual skips via next divided by plays) and how often each skip reason was given, to help decide what to blocklist. Accepts the same parameters as /api/stats/top/artists plus minPlays (default 3)", Response: SkipStats{}},
+	"GET /api/stats/top/albums":                        {Summary: "Most played albums, grouped by title and album artist like the library's album list, with play count, listening minutes, distinct requesting users and artwork. Accepts the same parameters as /api/stats/top/artists", Response: TopAlbums{}},
+	"GET /api/ha/state":                                {Summary: "Playback state for Home Assistant rest sensors and template media players; field names match media_player attributes and the schema only grows", Response: HAState{}},
+	"GET /api/ha/poll":                                 {Summary: "Long-poll for the next state change: pass the version from the last state and an optional timeout in seconds (default 25, max 55); returns at once if the state already differs, normal playback progress is not a change", Response: HAState{}},
+	"POST /api/ha/command":                             {Summary: "Run a media_player command (media_play, media_pause, media_play_pause, media_stop, media_next_track, media_previous_track, media_seek with seek_position in seconds, volume_set with volume_level, volume_up, volume_down; the media_player. prefix is optional) and return the new state; volume commands apply to every output device", Request: HACommandPayload{}, Response: HAState{}},
+	"GET /nowplaying":                                  {Summary: "Public now-playing page with OpenGraph tags for link previews (rate limited per IP)"},
+	"GET /nowplaying.json":                             {Summary: "Public now-playing info: current song, progress, artwork and listener count (rate limited per IP)", Response: NowPlaying{}},
+	"GET /nowplaying.png":                              {Summary: "Public now-playing PNG badge (rate limited per IP)"},
+	"GET /api/library":                                 {Summary: "List all songs in the library; pass ?uploader= to list only songs uploaded by that user", Response: []db.Song{}},
+	"GET /api/library/mine":                            {Summary: "Songs uploaded by the current user, by title. Non-admins can delete, restore, replace, retag and set skip regions only on these songs; admins can change any song", Response: []db.Song{}},
+	"GET /api/library/forgotten":                       {Summary: "Forgotten gems: songs not played in the last days (default from config, 90) that were played at least minPlays times (default 3) or are saved in a playlist, most played first; limit defaults to 50, max 200. With library.forgottenGems.every set, auto-advance also slips one in every that many songs", Response: []db.ForgottenSong{}},
+	"POST /api/library/upload":                         {Summary: "Upload an audio file (form field audioFile); pass ?uploadId= to match UPLOAD_PROGRESS and JOB_PROGRESS events", Response: db.Song{}, Multipart: true},
+	"POST /api/library/import-file-url":                {Summary: "Download an audio file from a direct URL into the library; progress and result arrive as URL_IMPORT_* events", Request: ImportFileURLPayload{}, Role: db.RoleDJ},
+	"POST /api/library/remove":                         {Summary: "Move a song to the trash; it is deleted permanently after the retention period. Non-admins can only remove songs they uploaded", Request: SongIDPayload{}},
+	"POST /api/library/:id/replace":                    {Summary: "Replace a song's audio with a new upload (form field audioFile), keeping its ID, playlist entries and play history; accepts ?uploadId= like upload. Non-admins can only replace songs they uploaded", Response: db.Song{}, Multipart: true},
+	"POST /api/library/bulk":                           {Summary: "Delete, retag (artist/album artist/genre) or enqueue many songs at once with a single broadcast. Non-admins can only delete or retag songs they uploaded", Request: LibraryBulkPayload{}, Role: db.RoleDJ},
+	"POST /api/playlists/generate":                     {Summary: "Pick roughly minutes (default 60) of songs for a mood from mood tags, falling back to analyzed BPM for untagged songs; action load (default) adds them to the queue within the request budget, save creates a named playlist, preview only returns the songs", Request: GeneratePlaylistPayload{}, Response: GeneratedPlaylist{}},
+	"POST /api/library/:id/tags":                       {Summary: "Set a song's genre and/or mood by hand, overriding automatic classification (confidence is cleared); the classifier and analysis workers will not change the song's tags afterwards. Non-admins can only tag songs they uploaded", Request: SongTagsPayload{}, Response: db.Song{}},
+	"POST /api/library/:id/skip-regions":               {Summary: "Replace a song's skip regions (silence, spoken intro, outro); playback auto-seeks past them. Non-admins can only change songs they uploaded", Request: SkipRegionsPayload{}, Response: []db.SkipRegion{}},
+	"GET /api/library/:id/comments":                    {Summary: "Comments on a song, oldest first; admins also see hidden comments", Response: []db.SongComment{}},
+	"POST /api/library/:id/comments":                   {Summary: "Comment on a song (at most 280 characters). With flash the comment is sent as a SONG_COMMENTS event to clients that declared the chat capability when the song starts playing", Request: SongCommentPayload{}, Response: db.SongComment{}},
+	"POST /api/library/:id/comments/:commentId/delete": {Summary: "Delete a comment; only its author or an admin can"},
+	"POST /api/library/:id/report":                     {Summary: "Report a song for an admin to review (e.g. copyright or offensive content); one open report per user and song", Request: SongReportPayload{}, Response: db.SongReport{}},
+	"GET /api/library/:id":                             {Summary: "Get a song's full metadata, provenance (original filename, uploader, upload time, source URL) and technical details recorded at ingest (codec, bitrate, sample rate, channels, file size, content hash, HLS renditions)", Response: SongDetail{}},
+	"GET /api/library/trash":                           {Summary: "List songs in the trash with their purge time", Response: []TrashedSong{}},
+	"POST /api/library/restore":                        {Summary: "Restore a song from the trash; non-admins can only restore songs they uploaded", Request: SongIDPayload{}, Response: db.Song{}},
+	"POST /api/playlist/add":                           {Summary: "Add a song to the playlist", Request: SongIDPayload{}},
+	"POST /api/playlist/add-at":                        {Summary: "Insert a song at a playlist position", Request: PlaylistAddAtPayload{}},
+	"POST /api/playlist/add-many":                      {Summary: "Add several songs to the playlist", Request: PlaylistAddManyPayload{}},
+	"POST /api/playlist/remove":                        {Summary: "Remove a song from the playlist. Removing the current song counts against the caller's skip budget (429 when it is used up; admins are exempt)", Request: SongIDPayload{}},
+	"POST /api/playlist/move":                          {Summary: "Move a song to a new playlist position", Request: ReorderPlaylistPayload{}},
+	"POST /api/playlist/reorder":                       {Summary: "Replace the playlist order (checked against playlistVersion)", Request: PlaylistReorderPayload{}},
+	"POST /api/playlist/shuffle":                       {Summary: "Shuffle the playlist"},
+	"POST /api/player/mode":                            {Summary: "Set the play mode: REPEAT_ALL, REPEAT_ONE (the song restarts when it ends, skipping still advances), SHUFFLE (every song plays once in random order before any repeats), or AUTO_DJ (moves up the first of the next few songs whose BPM and Camelot key blend with the current one)", Request: PlayModePayload{}, Role: db.RoleDJ},
+	"POST /api/playlist/queue-mode":                    {Summary: "Switch between FIFO and round-robin queueing", Request: QueueModePayload{}, Role: db.RoleDJ},
+	"GET /api/playlists":                               {Summary: "List saved playlists", Response: []db.SavedPlaylist{}},
+	"GET /api/jobs":                                    {Summary: "List running and recently finished transcode jobs", Response: []Job{}},
+	"GET /api/jobs/:id":                                {Summary: "Get a transcode job including the tail of ffmpeg's output", Response: Job{}},
+	"POST /api/jobs/:id/cancel":                        {Summary: "Cancel a running transcode job; only its uploader or a DJ may cancel", Response: Job{}},
+	"POST /api/playlists/smart":                        {Summary: "Create a smart playlist whose songs are picked by decade, year range, genre or artist when it is enqueued", Request: SmartPlaylistPayload{}, Response: db.SavedPlaylist{}},
+	"POST /api/playlists/import":                       {Summary: "Import a Spotify/Apple Music playlist URL (JSON) or CSV export (form fields csvFile, name), matched against the library", Request: PlaylistImportPayload{}, Response: PlaylistImportResult{}},
+	"POST /api/playlists/pin":                          {Summary: "Pin a saved playlist as the theme of the night: once no playable songs are left after the current one, its songs are added and played in order (not in shuffle mode). Smart playlists pick their songs when pinned. Shown in the state as pinnedPlaylist", Request: SavedPlaylistIDPayload{}, Response: state.PinnedPlaylist{}, Role: db.RoleDJ},
+	"POST /api/playlists/unpin":                        {Summary: "Unpin the pinned playlist", Role: db.RoleDJ},
+	"POST /api/playlists/enqueue":                      {Summary: "Add a saved playlist's songs to the playlist", Request: SavedPlaylistIDPayload{}},
+	"POST /api/player/play":                            {Summary: "Resume playback"},
+	"POST /api/player/play-specific":                   {Summary: "Play a song from the playlist. Switching away from the current song counts against the caller's skip budget (429 when it is used up; admins are exempt)", Request: PlaySpecificPayload{}},
+	"POST /api/playlist/play-from":                     {Summary: "Start playback from a position in the playlist (the first playable song at or after index) and continue from there in the current play mode. Switching away from the current song counts against the caller's skip budget like /api/player/next (429 when it is used up; admins are exempt) and records the optional reason with the skipped play", Request: PlayFromPayload{}},
+	"POST /api/player/pause":                           {Summary: "Pause playback"},
+	"POST /api/player/next":                            {Summary: "Skip to the next song. The body is optional; reason (bad_quality, wrong_vibe or duplicate) is recorded with the skipped play for /api/stats/skips", Request: SkipPayload{}},
+	"POST /api/player/prev":                            {Summary: "Go back to the previous song"},
+	"POST /api/player/seek":                            {Summary: "Seek within the current song; rapid seeks are coalesced and applied once. Send scrubbing=true while dragging the seek bar to only update the preview position other clients see, then scrubbing=false on release", Request: SeekPayload{}},
+	"POST /api/player/rate":                            {Summary: "Set the shared playback rate", Request: PlaybackRatePayload{}},
+	"POST /api/player/equalizer":                       {Summary: "Set the shared equalizer: FLAT, BASS_BOOST, TREBLE_BOOST, VOCAL or CUSTOM with 10 band gains (32Hz-16kHz, ±12 dB)", Request: EqualizerPayload{}, Role: db.RoleDJ},
+	"POST /api/player/seek-chapter":                    {Summary: "Jump to a chapter of the current song", Request: SeekChapterPayload{}},
+	"POST /api/devices/volume":                         {Summary: "Set the volume of an output device", Request: DeviceVolumePayload{}},
+	"GET /api/airplay/speakers":                        {Summary: "Search the LAN for AirPlay speakers (takes a few seconds); connected speakers are always listed", Response: []AirPlaySpeaker{}, Role: db.RoleDJ},
+	"POST /api/airplay/speakers/:id/connect":           {Summary: "Connect a discovered AirPlay speaker; it becomes an output device that follows play/pause, seeking, volume and playback transfer (requires ffmpeg)", Response: AirPlaySpeaker{}, Role: db.RoleDJ},
+	"POST /api/airplay/speakers/:id/disconnect":        {Summary: "Stop streaming to an AirPlay speaker and remove it from the output devices", Role: db.RoleDJ},
+	"POST /api/devices/transfer":                       {Summary: "Make one output device the only audible one (others keep playing muted so the handoff is seamless); an empty deviceId lets all outputs play again", Request: TransferPlaybackPayload{}, Response: state.PlaybackTransfer{}},
+	"POST /api/poll/start":                             {Summary: "Start a next-song poll", Request: PollStartPayload{}, Role: db.RoleDJ},
+	"POST /api/playlist/suggestions/approve":           {Summary: "Add a song suggested by the last QUEUE_LOW event to the queue as the approving listener's request; same budget and rules as adding a song", Request: SongIDPayload{}},
+	"POST /api/playlist/suggestions/dismiss":           {Summary: "Withdraw the current queue suggestions; no new ones are made until the queue has been topped up", Role: db.RoleDJ},
+	"POST /api/poll/vote":                              {Summary: "Vote in the running poll", Request: PollVotePayload{}},
+	"POST /api/poll/cancel":                            {Summary: "Cancel the running poll", Role: db.RoleDJ},
+	"GET /api/soundboard":                              {Summary: "Clips on the soundboard, by name", Response: []db.SoundClip{}},
+	"POST /api/soundboard":                             {Summary: "Upload a soundboard clip (form fields clipFile and optional name; at most 15 seconds and 5 MB); the file is served as is", Response: db.SoundClip{}, Multipart: true, Role: db.RoleDJ},
+	"POST /api/soundboard/:id/play":                    {Summary: "Play a clip over the current song: a SOUNDBOARD event with the clip URL is sent to clients that declared the soundboard capability, playback state is not changed. DJs can also send {\"type\":\"SOUNDBOARD\",\"clipId\":1} over the WebSocket", Role: db.RoleDJ},
+	"POST /api/soundboard/:id/delete":                  {Summary: "Delete a clip and its file", Role: db.RoleDJ},
+	"POST /api/drop/schedule":                          {Summary: "Schedule a song to start exactly at a wall-clock instant (e.g. midnight), replacing any earlier schedule; a COUNTDOWN event is sent every second during the last minute and the song preempts the queue at that moment", Request: DropPayload{}, Response: state.Drop{}, Role: db.RoleDJ},
+	"POST /api/drop/cancel":                            {Summary: "Cancel the scheduled drop", Role: db.RoleDJ},
+	"GET /api/party/links":                             {Summary: "List active party join links", Response: []JoinLink{}, Role: db.RoleDJ},
+	"POST /api/party/links":                            {Summary: "Create a QR-friendly join link for guests (defaults: 12h, no guest limit)", Request: JoinLinkPayload{}, Response: JoinLink{}, Role: db.RoleDJ},
+	"POST /api/party/links/revoke":                     {Summary: "Revoke a join link and the guests who joined through it", Request: JoinTokenPayload{}, Role: db.RoleDJ},
+	"POST /api/party/close":                            {Summary: "Close the party: revoke all join links and guest identities", Role: db.RoleDJ},
+	"GET /api/party/sessions":                          {Summary: "List party sessions, most recent first", Response: []db.PartySession{}},
+	"GET /api/party/sessions/:id":                      {Summary: "Get a party session with its recap (live recap while running)", Response: db.PartySession{}},
+	"POST /api/party/sessions/start":                   {Summary: "Start a party session that scopes play history", Request: StartSessionPayload{}, Response: db.PartySession{}, Role: db.RoleDJ},
+	"POST /api/party/sessions/end":                     {Summary: "End the running party session, revoke guest access and return the recap", Response: db.PartySession{}, Role: db.RoleDJ},
+	"POST /api/join":                                   {Summary: "Join the party with a join link token and nickname; returns Basic credentials limited to queueing and voting", Request: JoinPartyPayload{}},
+	"POST /api/admin/family-mode":                      {Summary: "Turn family mode on or off", Request: FamilyModePayload{}, Role: db.RoleAdmin},
+	"POST /api/admin/library/explicit":                 {Summary: "Mark a song as explicit", Request: SongExplicitPayload{}, Role: db.RoleAdmin},
+	"POST /api/admin/library/jingle":                   {Summary: "Mark a song as a jingle played between songs every settings.jingleEvery songs", Request: SongJinglePayload{}, Role: db.RoleAdmin},
+	"POST /api/admin/library/reference":                {Summary: "Reference a file or directory under a configured root in place; songs become playable once their HLS cache is built", Request: LibraryReferencePayload{}, Response: LibraryReferenceResult{}, Role: db.RoleAdmin},
+	"GET /api/admin/library/export":                    {Summary: "Download the library as a zip or tar with a manifest.json; query: format=zip|tar, media=hls|original, playlist, genre, artist, year, decade", Role: db.RoleAdmin},
+	"POST /api/admin/library/rescan":                   {Summary: "Re-read tags in the background to fill in release years of songs ingested before years were stored", Role: db.RoleAdmin},
+	"GET /api/admin/blocklist":                         {Summary: "List blocklist entries", Response: []db.BlocklistEntry{}, Role: db.RoleAdmin},
+	"POST /api/admin/blocklist/add":                    {Summary: "Block a song or artist pattern", Request: BlocklistAddPayload{}, Role: db.RoleAdmin},
+	"POST /api/admin/blocklist/remove":                 {Summary: "Remove a blocklist entry", Request: BlocklistRemovePayload{}, Role: db.RoleAdmin},
+	"POST /api/admin/announcement":                     {Summary: "Show an announcement banner on all clients (e.g. \"Server restarting at 22:00\"); it is pushed in the WebSocket state and removed automatically at expiresAt", Request: AnnouncementPayload{}, Response: state.Announcement{}, Role: db.RoleAdmin},
+	"POST /api/admin/announcement/clear":               {Summary: "Remove the announcement banner", Role: db.RoleAdmin},
+	"GET /api/admin/reports":                           {Summary: "List song reports, open ones by default; ?status= open, dismissed, blocklisted, deleted or all", Response: []db.SongReport{}, Role: db.RoleAdmin},
+	"GET /api/admin/comments":                          {Summary: "The most recent comments on all songs, newest first and including hidden ones, for moderation", Response: []db.SongComment{}, Role: db.RoleAdmin},
+	"POST /api/admin/comments/:id/hide":                {Summary: "Hide a comment so it is no longer shown or flashed, or show it again", Request: CommentHidePayload{}, Response: db.SongComment{}, Role: db.RoleAdmin},
+	"POST /api/admin/reports/:id/resolve":              {Summary: "Resolve a report by dismissing it, blocklisting the song or moving it to the trash; other open reports on the same song are resolved too", Request: ReportResolvePayload{}, Role: db.RoleAdmin},
+	"POST /api/admin/users/role":                       {Summary: "Change a user's role", Request: UserRolePayload{}, Role: db.RoleAdmin},
+	"GET /api/admin/federation":                        {Summary: "Show whether playback is mirrored from another jukebox", Response: federation.Status{}, Role: db.RoleAdmin},
+	"POST /api/admin/federation/follow":                {Summary: "Mirror another jukebox's playback", Request: FederationFollowPayload{}, Response: federation.Status{}, Role: db.RoleAdmin},
+	"POST /api/admin/import-remote":                    {Summary: "Import another jukebox's library and merge its playlist", Request: ImportRemotePayload{}, Response: ImportRemoteResult{}, Role: db.RoleAdmin},
+	"POST /api/admin/federation/unfollow":              {Summary: "Stop mirroring and restore local playback control", Role: db.RoleAdmin},
+	"GET /api/admin/overview":                          {Summary: "Summarize clients, playback, storage, jobs and recent errors for the admin page", Response: AdminOverview{}, Role: db.RoleAdmin},
+	"GET /api/admin/bandwidth":                         {Summary: "Media bandwidth for a month (?month=YYYY-MM, default this month): total, caps, top songs and per-user usage", Response: BandwidthReport{}, Role: db.RoleAdmin},
+	"GET /api/admin/streams":                           {Summary: "Media streams being played right now (one per IP, user and song) and how many requests the per-IP/per-user limits have rejected. Media is public, so requests without the media cookie or an Authorization header are only limited per IP", Response: StreamReport{}, Role: db.RoleAdmin},
+	"GET /api/admin/maintenance":                       {Summary: "Show the nightly maintenance schedule and the last result of each task", Response: MaintenanceStatus{}, Role: db.RoleAdmin},
+	"POST /api/admin/maintenance/tasks":                {Summary: "Enable or disable a maintenance task in the nightly run", Request: MaintenanceTaskPayload{}, Response: MaintenanceTaskStatus{}, Role: db.RoleAdmin},
+	"POST /api/admin/maintenance-mode":                 {Summary: "Enable or disable maintenance mode: playback pauses, other mutating requests get 503 while reads and WebSocket connections keep working, and a MAINTENANCE event is pushed; disabling resumes playback if it was playing", Request: MaintenanceModePayload{}, Response: state.MaintenanceMode{}, Role: db.RoleAdmin},
+	"GET /api/admin/analysis":                          {Summary: "External analysis queue: task counts by status and the most recently failed tasks", Response: AnalysisStatus{}, Role: db.RoleAdmin},
+	"POST /api/admin/analysis/enqueue":                 {Summary: "Queue songs for external analysis by ID, or every song without a BPM with missing=true; songs already queued are skipped", Request: AnalysisEnqueuePayload{}, Role: db.RoleAdmin},
+	"POST /api/admin/maintenance/run":                  {Summary: "Run a maintenance task now in the background", Request: MaintenanceTaskPayload{}, Response: MaintenanceStatus{}, Role: db.RoleAdmin},
+}
+
+// publicRoutes 不需要登录即可访问的路由
+var publicRoutes = map[string]bool{
+	"GET /ws":               true,
+	"POST /api/register":    true,
+	"POST /api/login":       true,
+	"POST /api/join":        true,
+	"GET /api/openapi.json": true,
+	"GET /api/docs":         true,
+	"GET /nowplaying":       true,
+	"GET /nowplaying.json":  true,
+	"GET /nowplaying.png":   true,
+}
+
+// BuildOpenAPISpec 根据注册的路由和请求体结构生成 OpenAPI 3 文档
+func BuildOpenAPISpec() ([]byte, error) {
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	(&API{}).RegisterRoutes(engine)
+
+	schemas := make(map[string]interface{})
+	paths := make(map[string]map[string]interface{})
+	routes := engine.Routes()
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Path < routes[j].Path })
+	for _, route := range routes {
+		if strings.HasPrefix(route.Path, "/static/") || route.Method == http.MethodHead {
+			continue
+		}
+		key := route.Method + " " + route.Path
+		doc := routeDocs[key]
+		op := map[string]interface{}{
+			"operationId": operationID(route.Handler),
+			"responses":   responses(doc, schemas),
+		}
+		if doc.Summary != "" {
+			op["summary"] = doc.Summary
+		}
+		if tag := routeTag(route.Path); tag != "" {
+			op["tags"] = []string{tag}
+		}
+		if params := pathParameters(route.Path); len(params) > 0 {
+			op["parameters"] = params
+		}
+		if publicRoutes[key] {
+			op["security"] = []interface{}{}
+		}
+		if doc.Role != "" {
+			op["description"] = fmt.Sprintf("Requires the %s role or higher.", doc.Role)
+		}
+		if doc.Multipart {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"multipart/form-data": map[string]interface{}{
+						"schema": map[string]interface{}{
+							"type":       "object",
+							"properties": map[string]interface{}{"audioFile": map[string]interface{}{"type": "string", "format": "binary"}},
+							"required":   []string{"audioFile"},
+						},
+					},
+				},
+			}
+		} else if doc.Request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schemaFor(reflect.TypeOf(doc.Request), schemas)},
+				},
+			}
+		}
+		path := openAPIPath(route.Path)
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+		paths[path][strings.ToLower(route.Method)] = op
+	}
+
+	schemas["Error"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"error": map[string]interface{}{"type": "string"},
+			"code":  map[string]interface{}{"type": "string", "description": "Machine-readable rule violation code, when applicable"},
+		},
+	}
+	spec := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "SyncJukebox API",
+			"version": "2.0",
+		},
+		"servers":  []interface{}{map[string]interface{}{"url": "/"}},
+		"security": []interface{}{map[string]interface{}{"basicAuth": []string{}}},
+		"paths":    paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"basicAuth": map[string]interface{}{"type": "http", "scheme": "basic"},
+			},
+			"schemas": schemas,
+		},
+	}
+	return json.MarshalIndent(spec, "", "  ")
+}
+
+func responses(doc routeDoc, schemas map[string]interface{}) map[string]interface{} {
+	ok := map[string]interface{}{"description": "OK"}
+	if doc.Response != nil {
+		ok["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schemaFor(reflect.TypeOf(doc.Response), schemas)},
+		}
+	}
+	errorResponse := map[string]interface{}{
+		"description": "Error",
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"}},
+		},
+	}
+	return map[string]interface{}{"200": ok, "default": errorResponse}
+}
+
+// operationID 用处理函数名作为 operationId，例如 handlePlaylistAdd -> playlistAdd
+func operationID(handler string) string {
+	name := handler[strings.LastIndex(handler, ".")+1:]
+	name = strings.TrimSuffix(name, "-fm")
+	name = strings.TrimPrefix(name, "handle")
+	if name == "" {
+		return handler
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// routeTag 以 /api 之后的第一段路径作为分组
+func routeTag(path string) string {
+	parts := strings.Split(strings.TrimPrefix(path, "/api/"), "/")
+	if len(parts) == 0 || parts[0] == "" || strings.HasPrefix(path, "/ws") {
+		return "realtime"
+	}
+	return parts[0]
+}
+
+// openAPIPath 把 gin 的 :param 和 *param 转成 {param}
+func openAPIPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		if strings.HasPrefix(p, ":") || strings.HasPrefix(p, "*") {
+			parts[i] = "{" + p[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// pathParameters 为 gin 路径中的 :name 生成 OpenAPI 路径参数
+func pathParameters(path string) []interface{} {
+	var params []interface{}
+	for _, p := range strings.Split(path, "/") {
+		if strings.HasPrefix(p, ":") || strings.HasPrefix(p, "*") {
+			params = append(params, map[string]interface{}{
+				"name":     p[1:],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	return params
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor 把 Go 类型转换为 JSON Schema，具名结构体放入 components 并返回引用
+func schemaFor(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct:
+		name := t.Name()
+		if name == "" {
+			return structSchema(t, schemas)
+		}
+		if _, ok := schemas[name]; !ok {
+			schemas[name] = nil // 先占位，防止递归类型无限展开
+			schemas[name] = structSchema(t, schemas)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case t.Kind() == reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			// 内嵌结构体的字段提升到外层
+			if embedded, ok := structSchema(field.Type, schemas)["properties"].(map[string]interface{}); ok {
+				for k, v := range embedded {
+					properties[k] = v
+				}
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaFor(field.Type, schemas)
+		if strings.Contains(field.Tag.Get("binding"), "required") {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// handleOpenAPISpec 返回嵌入的 OpenAPI 文档
+func (a *API) handleOpenAPISpec(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", openAPISpec)
+}
+
+// swaggerUIPage 从 CDN 加载 Swagger UI 展示 /api/openapi.json
+const swaggerUIPage = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>SyncJukebox API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: '/api/openapi.json', dom_id: '#swagger-ui' });
+  </script>
+</body>
+</html>`
+
+// handleSwaggerUI 返回 Swagger UI 页面
+func (a *API) handleSwaggerUI(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage))
+}
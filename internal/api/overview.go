@@ -0,0 +1,186 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/state"
+)
+
+const (
+	// maxRecentErrors 总览中保留的最近错误日志条数
+	maxRecentErrors = 50
+	// storageUsageTTL 遍历媒体目录较慢，统计结果缓存一段时间
+	storageUsageTTL = 5 * time.Minute
+	// logTimeLayout 标准库 log 默认的时间前缀
+	logTimeLayout = "2006/01/02 15:04:05"
+)
+
+// AdminOverview 管理页面需要的运行状态，一次请求全部返回
+type AdminOverview struct {
+	StartedAt     time.Time `json:"startedAt"`
+	UptimeSeconds int64     `json:"uptimeSeconds"`
+	// ConnectedClients 连接到本实例的 WebSocket 客户端数，多实例部署时不包括其他实例
+	ConnectedClients int                  `json:"connectedClients"`
+	Status           state.PlaybackStatus `json:"status"`
+	IsPlaying        bool                 `json:"isPlaying"`
+	CurrentSong      *db.Song             `json:"currentSong"`
+	QueueLength      int                  `json:"queueLength"`
+	Storage          StorageUsage         `json:"storage"`
+	Jobs             JobCounts            `json:"jobs"`
+	// Streams 正在播放的媒体流，多实例部署时不包括其他实例
+	Streams StreamStats `json:"streams"`
+	// Bandwidth 本月的媒体流量和上限
+	Bandwidth BandwidthStats `json:"bandwidth"`
+	// RecentErrors 最近的错误日志，最新的在前
+	RecentErrors []LogEntry `json:"recentErrors"`
+}
+
+// StorageUsage 曲库占用的空间
+type StorageUsage struct {
+	Songs         int64 `json:"songs"`
+	MediaBytes    int64 `json:"mediaBytes"`
+	DatabaseBytes int64 `json:"databaseBytes"`
+	// MeasuredAt 统计的时间，结果会缓存几分钟
+	MeasuredAt time.Time `json:"measuredAt"`
+}
+
+// JobCounts 按状态统计的转码任务数，已结束的任务只保留最近的一部分
+type JobCounts struct {
+	Queued      int `json:"queued"`
+	Transcoding int `json:"transcoding"`
+	Failed      int `json:"failed"`
+}
+
+// LogEntry 一行日志
+type LogEntry struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// handleAdminOverview 汇总客户端、播放、存储、任务和最近的错误，供管理页面一次加载
+func (a *API) handleAdminOverview(c *gin.Context) {
+	snapshot := a.state.Snapshot()
+	storage, err := a.storageUsage()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to measure storage"})
+		return
+	}
+	var jobs JobCounts
+	for _, job := range a.jobs.List() {
+		switch job.Status {
+		case JobQueued:
+			jobs.Queued++
+		case JobTranscoding:
+			jobs.Transcoding++
+		case JobFailed:
+			jobs.Failed++
+		}
+	}
+	c.JSON(http.StatusOK, AdminOverview{
+		StartedAt:        a.startedAt,
+		UptimeSeconds:    int64(time.Since(a.startedAt).Seconds()),
+		ConnectedClients: a.hub.ClientCount(),
+		Status:           snapshot.Status,
+		IsPlaying:        snapshot.IsPlaying,
+		CurrentSong:      snapshot.CurrentSong,
+		QueueLength:      len(snapshot.Playlist),
+		Storage:          storage,
+		Jobs:             jobs,
+		Streams:          a.streams.stats(),
+		Bandwidth:        a.bandwidth.stats(),
+		RecentErrors:     a.recentErrors.entries(),
+	})
+}
+
+// storageUsageCache 最近一次统计的存储占用
+type storageUsageCache struct {
+	mu    sync.Mutex
+	usage StorageUsage
+}
+
+// storageUsage 返回存储占用，超过缓存时间时重新统计
+func (a *API) storageUsage() (StorageUsage, error) {
+	cache := &a.usageCache
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if time.Since(cache.usage.MeasuredAt) < storageUsageTTL {
+		return cache.usage, nil
+	}
+	songs, err := a.db.CountSongs()
+	if err != nil {
+		return StorageUsage{}, err
+	}
+	dbBytes, err := a.db.Size()
+	if err != nil {
+		return StorageUsage{}, err
+	}
+	cache.usage = StorageUsage{
+		Songs:         songs,
+		MediaBytes:    diskUsage(a.mediaDir),
+		DatabaseBytes: dbBytes,
+		MeasuredAt:    time.Now(),
+	}
+	return cache.usage, nil
+}
+
+// recentErrorLog 作为标准库 log 的附加输出，保留最近的错误日志，警告不算错误
+type recentErrorLog struct {
+	mu   sync.Mutex
+	max  int
+	logs []LogEntry
+}
+
+func newRecentErrorLog(max int) *recentErrorLog {
+	return &recentErrorLog{max: max}
+}
+
+func (l *recentErrorLog) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		l.add(string(line))
+	}
+	return len(p), nil
+}
+
+func (l *recentErrorLog) add(line string) {
+	entry := LogEntry{Time: time.Now(), Message: line}
+	if len(line) > len(logTimeLayout) {
+		if t, err := time.ParseInLocation(logTimeLayout, line[:len(logTimeLayout)], time.Local); err == nil {
+			entry = LogEntry{Time: t, Message: strings.TrimSpace(line[len(logTimeLayout):])}
+		}
+	}
+	if !isErrorLog(entry.Message) {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logs = append(l.logs, entry)
+	if len(l.logs) > l.max {
+		l.logs = l.logs[len(l.logs)-l.max:]
+	}
+}
+
+// isErrorLog 按内容判断一行日志是不是错误：包含 error、failed 或 panic，且不是以 Warning 开头
+func isErrorLog(message string) bool {
+	lower := strings.ToLower(message)
+	if strings.HasPrefix(lower, "warning") {
+		return false
+	}
+	return strings.Contains(lower, "error") || strings.Contains(lower, "failed") || strings.Contains(lower, "panic")
+}
+
+// entries 返回保留的错误日志，最新的在前
+func (l *recentErrorLog) entries() []LogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := make([]LogEntry, len(l.logs))
+	for i, entry := range l.logs {
+		entries[len(l.logs)-1-i] = entry
+	}
+	return entries
+}
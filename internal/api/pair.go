@@ -0,0 +1,122 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+const (
+	// pairingTTL 配对二维码的有效期，过期后需要重新生成
+	pairingTTL = 2 * time.Minute
+	// pairingQRSize 配对二维码图片的边长（像素）
+	pairingQRSize = 320
+	// pairingPage 扫码后打开的前端页面，配对令牌放在 pair 参数中
+	pairingPage = "/login"
+)
+
+// pairing 等待新设备领取的配对令牌，签发给生成二维码的用户
+type pairing struct {
+	username  string
+	expiresAt time.Time
+}
+
+// pairings 进行中的扫码配对，只保存在内存中；集群模式下所有 /api 请求都由主实例处理
+type pairings struct {
+	mu      sync.Mutex
+	pending map[string]pairing
+}
+
+func newPairings() *pairings {
+	return &pairings{pending: make(map[string]pairing)}
+}
+
+func (p *pairings) pruneLocked(now time.Time) {
+	for token, pending := range p.pending {
+		if now.After(pending.expiresAt) {
+			delete(p.pending, token)
+		}
+	}
+}
+
+func (p *pairings) start(token, username string, expiresAt time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pruneLocked(time.Now())
+	p.pending[token] = pairing{username: username, expiresAt: expiresAt}
+}
+
+// take 取出并删除配对令牌，每个二维码只能使用一次
+func (p *pairings) take(token string) (pairing, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pruneLocked(time.Now())
+	pending, ok := p.pending[token]
+	delete(p.pending, token)
+	return pending, ok
+}
+
+// handlePairQR 生成配对二维码：内容是带一次性配对令牌的登录页地址，手机扫码后无需输入密码即可登录
+func (a *API) handlePairQR(c *gin.Context) {
+	// 只读副本不写数据库，无法为新设备签发令牌
+	if a.readOnly {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This instance is a read-only replica", "code": "READ_ONLY"})
+		return
+	}
+	username := c.GetString("username")
+	token, err := randomToken(24)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate pairing code"})
+		return
+	}
+	link := requestBaseURL(c) + pairingPage + "?" + url.Values{"pair": {token}}.Encode()
+	png, err := qrcode.Encode(link, qrcode.Medium, pairingQRSize)
+	if err != nil {
+		log.Printf("Error encoding pairing QR code: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate pairing code"})
+		return
+	}
+	expiresAt := time.Now().Add(pairingTTL)
+	a.pairings.start(token, username, expiresAt)
+	c.Header("Cache-Control", "no-store")
+	// 方便无法扫码时复制链接，以及前端显示倒计时
+	c.Header("X-Pairing-URL", link)
+	c.Header("X-Pairing-Expires", expiresAt.UTC().Format(time.RFC3339))
+	c.Header("X-Pairing-Expires-In", strconv.Itoa(int(pairingTTL.Seconds())))
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+// handlePairRedeem 新设备用扫码得到的配对令牌领取登录令牌
+func (a *API) handlePairRedeem(c *gin.Context) {
+	var payload PairPayload
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+	pending, ok := a.pairings.take(payload.Token)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Pairing code expired, please scan a new one"})
+		return
+	}
+	user, err := a.db.GetUserByUsername(pending.username)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Pairing code expired, please scan a new one"})
+		return
+	}
+	token, expiresAt, err := a.db.CreateLoginToken(user.Username, db.LoginMethodPair, deviceName(c.Request), rememberDeviceTTL)
+	if err != nil {
+		log.Printf("Error creating login token for %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return
+	}
+	log.Printf("Action: %s paired a new device by QR code", user.Username)
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, SSOLogin{Username: user.Username, Token: token, Role: user.Role, ExpiresAt: expiresAt})
+}
@@ -0,0 +1,271 @@
+package api
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/playlistimport"
+	"gorm.io/gorm"
+)
+
+// handleGetSavedPlaylists 列出所有命名歌单
+func (a *API) handleGetSavedPlaylists(c *gin.Context) {
+	playlists, err := a.db.GetSavedPlaylists()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get playlists"})
+		return
+	}
+	c.JSON(http.StatusOK, playlists)
+}
+
+// handleImportPlaylist 导入外部歌单并与本地曲库匹配，用匹配到的歌曲创建命名歌单
+// 请求可以是 JSON {url, name}（Spotify / Apple Music 链接），也可以是上传 CSV 的表单（csvFile, name）
+func (a *API) handleImportPlaylist(c *gin.Context) {
+	var (
+		name   string
+		tracks []playlistimport.Track
+	)
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		fileHeader, err := c.FormFile("csvFile")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error retrieving the file"})
+			return
+		}
+		f, err := fileHeader.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error reading the file"})
+			return
+		}
+		defer f.Close()
+		if tracks, err = playlistimport.ParseCSV(f); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		name = c.PostForm("name")
+		if name == "" {
+			name = strings.TrimSuffix(fileHeader.Filename, ".csv")
+		}
+	} else {
+		var payload PlaylistImportPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "url is required"})
+			return
+		}
+		remoteName, fetched, err := playlistimport.Fetch(c.Request.Context(), payload.URL, a.importCfg)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tracks = fetched
+		name = payload.Name
+		if name == "" {
+			name = remoteName
+		}
+	}
+	if name == "" {
+		name = "Imported playlist"
+	}
+
+	library, err := a.db.GetAllSongs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get library"})
+		return
+	}
+	result := playlistimport.Match(tracks, library)
+	songIDs := make([]string, 0, len(result.Matched))
+	for _, song := range result.Matched {
+		songIDs = append(songIDs, song.ID)
+	}
+	playlist, err := a.db.CreateSavedPlaylist(name, c.GetString("username"), songIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save playlist"})
+		return
+	}
+	c.JSON(http.StatusCreated, PlaylistImportResult{Playlist: playlist, Matched: len(result.Matched), Unmatched: result.Unmatched})
+}
+
+// handleCreateSmartPlaylist 创建按年代、年份、流派或歌手选歌的智能歌单
+func (a *API) handleCreateSmartPlaylist(c *gin.Context) {
+	var payload SmartPlaylistPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and rules are required"})
+		return
+	}
+	if err := payload.Rules.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	playlist, err := a.db.CreateSmartPlaylist(payload.Name, c.GetString("username"), payload.Rules)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save playlist"})
+		return
+	}
+	c.JSON(http.StatusCreated, playlist)
+}
+
+// 按情绪生成歌单的默认和最长时长（分钟）
+const (
+	defaultGenerateMinutes = 60
+	maxGenerateMinutes     = 600
+)
+
+// handleGeneratePlaylist 按情绪标签（没有标签时按分析得到的 BPM）从曲库中选出约 minutes 分钟的歌曲，
+// 加入播放列表、保存为命名歌单或只预览；加入播放列表时受点歌额度和规则限制
+func (a *API) handleGeneratePlaylist(c *gin.Context) {
+	var payload GeneratePlaylistPayload
+	if err := c.ShouldBindJSON(&payload); err != nil || strings.TrimSpace(payload.Mood) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mood is required"})
+		return
+	}
+	if payload.Minutes < 0 || payload.Minutes > maxGenerateMinutes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "minutes must be between 1 and 600"})
+		return
+	}
+	action := cmp.Or(payload.Action, "load")
+	if action != "load" && action != "save" && action != "preview" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "action must be load, save or preview"})
+		return
+	}
+	library, err := a.db.GetAllSongs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get library"})
+		return
+	}
+	mood := strings.TrimSpace(payload.Mood)
+	minutes := cmp.Or(payload.Minutes, defaultGenerateMinutes)
+	songs := db.MoodPlaylist(library, mood, int64(minutes)*60*1000)
+	if len(songs) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No songs match this mood"})
+		return
+	}
+	result := GeneratedPlaylist{Mood: mood, Songs: songs}
+	songIDs := make([]string, 0, len(songs))
+	for _, song := range songs {
+		songIDs = append(songIDs, song.ID)
+		result.DurationMs += int64(song.DurationMs)
+	}
+
+	username := c.GetString("username")
+	switch action {
+	case "save":
+		name := cmp.Or(strings.TrimSpace(payload.Name), mood+" mix")
+		if result.Playlist, err = a.db.CreateSavedPlaylist(name, username, songIDs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save playlist"})
+			return
+		}
+		log.Printf("Action: %s generated %q playlist %q (%d songs)", username, mood, name, len(songIDs))
+		c.JSON(http.StatusCreated, result)
+		return
+	case "load":
+		if remaining := a.budgetFor(username).RequestsRemaining; remaining >= 0 && remaining < len(songIDs) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Not enough request budget for all songs", "budget": a.budgetFor(username)})
+			return
+		}
+		if result.Added, err = a.state.AddManyToPlaylist(songIDs, actorFrom(c)); err != nil {
+			respondStateError(c, err, http.StatusBadRequest, err.Error())
+			return
+		}
+		budget := a.budgetFor(username)
+		result.Budget = &budget
+		log.Printf("Action: %s loaded a %d minute %q playlist (%d songs)", username, minutes, mood, result.Added)
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// savedPlaylistSongIDs 歌单加入播放列表时的歌曲：智能歌单按条件从曲库中选，普通歌单跳过已移入回收站的歌曲
+func (a *API) savedPlaylistSongIDs(playlist *db.SavedPlaylist) ([]string, error) {
+	songIDs := make([]string, 0, len(playlist.Songs))
+	if playlist.Rules != nil {
+		library, err := a.db.GetAllSongs()
+		if err != nil {
+			return nil, err
+		}
+		for _, song := range playlist.Rules.Select(library) {
+			songIDs = append(songIDs, song.ID)
+		}
+	}
+	for _, item := range playlist.Songs {
+		// 已移入回收站的歌曲预加载不到，跳过
+		if item.Song != nil {
+			songIDs = append(songIDs, item.SongID)
+		}
+	}
+	return songIDs, nil
+}
+
+// handlePinPlaylist 固定命名歌单作为后备歌源，播放列表播完后按顺序从中取歌
+// 智能歌单在此时按条件从曲库中选歌
+func (a *API) handlePinPlaylist(c *gin.Context) {
+	var payload SavedPlaylistIDPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "playlistId is required"})
+		return
+	}
+	playlist, err := a.db.GetSavedPlaylist(payload.PlaylistID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Playlist not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get playlist"})
+		return
+	}
+	songIDs, err := a.savedPlaylistSongIDs(playlist)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get library"})
+		return
+	}
+	a.state.PinPlaylist(playlist.ID, playlist.Name, songIDs)
+	c.JSON(http.StatusOK, a.state.Snapshot().PinnedPlaylist)
+}
+
+// handleUnpinPlaylist 取消固定的歌单
+func (a *API) handleUnpinPlaylist(c *gin.Context) {
+	a.state.UnpinPlaylist()
+	c.Status(http.StatusNoContent)
+}
+
+// handleEnqueueSavedPlaylist 把命名歌单中的歌曲加入播放列表，受点歌额度和规则限制
+// 智能歌单在此时按条件从曲库中选歌
+func (a *API) handleEnqueueSavedPlaylist(c *gin.Context) {
+	var payload SavedPlaylistIDPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "playlistId is required"})
+		return
+	}
+	playlist, err := a.db.GetSavedPlaylist(payload.PlaylistID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Playlist not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get playlist"})
+		return
+	}
+	songIDs, err := a.savedPlaylistSongIDs(playlist)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get library"})
+		return
+	}
+	if len(songIDs) == 0 {
+		c.JSON(http.StatusOK, gin.H{"added": 0, "budget": a.budgetFor(c.GetString("username"))})
+		return
+	}
+
+	username := c.GetString("username")
+	if remaining := a.budgetFor(username).RequestsRemaining; remaining >= 0 && remaining < len(songIDs) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Not enough request budget for all songs", "budget": a.budgetFor(username)})
+		return
+	}
+	added, err := a.state.AddManyToPlaylist(songIDs, actorFrom(c))
+	if err != nil {
+		respondStateError(c, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"added": added, "budget": a.budgetFor(username)})
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handlePollStart DJ 发起“下一首放什么”投票
+func (a *API) handlePollStart(c *gin.Context) {
+	var payload PollStartPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "songIds is required"})
+		return
+	}
+	duration := time.Duration(payload.DurationSec) * time.Second
+	if err := a.state.StartPoll(payload.SongIDs, duration, actorFrom(c)); err != nil {
+		respondStateError(c, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	c.Status(http.StatusCreated)
+}
+
+// handlePollVote 为候选歌曲投票
+func (a *API) handlePollVote(c *gin.Context) {
+	var payload PollVotePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "songId is required"})
+		return
+	}
+	if err := a.state.Vote(c.GetString("username"), payload.SongID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// handlePollCancel 取消当前投票
+func (a *API) handlePollCancel(c *gin.Context) {
+	if err := a.state.CancelPoll(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
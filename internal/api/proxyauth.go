@@ -0,0 +1,146 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/config"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"gorm.io/gorm"
+)
+
+var (
+	defaultProxyUserHeaders   = []string{"Remote-User", "X-Forwarded-User"}
+	defaultProxyGroupsHeaders = []string{"Remote-Groups", "X-Forwarded-Groups"}
+)
+
+// proxyAuth 认证代理模式的设置，nil 表示没有启用
+type proxyAuth struct {
+	trusted       []netip.Prefix
+	userHeaders   []string
+	groupsHeaders []string
+	adminGroups   []string
+	djGroups      []string
+	disableSignup bool
+}
+
+// newProxyAuth 按配置创建，没有启用时返回 nil，没有填写或写错代理地址时返回错误
+func newProxyAuth(cfg config.ProxyAuthConfig) (*proxyAuth, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if len(cfg.TrustedProxies) == 0 {
+		return nil, errors.New("proxyAuth.trustedProxies is required")
+	}
+	p := &proxyAuth{
+		userHeaders:   cfg.UserHeaders,
+		groupsHeaders: cfg.GroupsHeaders,
+		adminGroups:   cfg.AdminGroups,
+		djGroups:      cfg.DJGroups,
+		disableSignup: cfg.DisableSignup,
+	}
+	for _, entry := range cfg.TrustedProxies {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		p.trusted = append(p.trusted, prefix.Masked())
+	}
+	if len(p.userHeaders) == 0 {
+		p.userHeaders = defaultProxyUserHeaders
+	}
+	if len(p.groupsHeaders) == 0 {
+		p.groupsHeaders = defaultProxyGroupsHeaders
+	}
+	return p, nil
+}
+
+// fromTrustedProxy 判断请求是否直接来自受信任的代理，只看连接的对端地址，不看 X-Forwarded-For
+func (p *proxyAuth) fromTrustedProxy(r *http.Request) bool {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range p.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// identity 返回代理传来的用户名和组，请求不是来自受信任的代理或没有带用户名时 ok 为 false
+func (p *proxyAuth) identity(r *http.Request) (username string, groups []string, ok bool) {
+	if p == nil || !p.fromTrustedProxy(r) {
+		return "", nil, false
+	}
+	for _, header := range p.userHeaders {
+		if username = strings.TrimSpace(r.Header.Get(header)); username != "" {
+			break
+		}
+	}
+	if username == "" {
+		return "", nil, false
+	}
+	for _, header := range p.groupsHeaders {
+		for _, group := range strings.Split(r.Header.Get(header), ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				groups = append(groups, group)
+			}
+		}
+	}
+	return username, groups, true
+}
+
+// ProxyLogin 认证代理确认的当前用户
+type ProxyLogin struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// handleProxyLogin 返回认证代理传来的用户，前端据此跳过登录表单
+// 没有代理身份时返回 401 但不带 WWW-Authenticate，避免浏览器弹出密码框
+func (a *API) handleProxyLogin(c *gin.Context) {
+	user, err := a.proxyUser(c.Request)
+	if err != nil || user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not signed in through an authentication proxy"})
+		return
+	}
+	c.JSON(http.StatusOK, ProxyLogin{Username: user.Username, Role: user.Role})
+}
+
+// proxyUser 按代理传来的身份找到本地账号，需要时创建并按组设置角色
+// 请求没有代理身份时返回 nil, nil，由调用方继续使用 Basic Auth
+func (a *API) proxyUser(r *http.Request) (*db.User, error) {
+	username, groups, ok := a.proxyAuth.identity(r)
+	if !ok {
+		return nil, nil
+	}
+	if !usableExternalUsername(username) {
+		return nil, fmt.Errorf("proxy user %q is not a valid username", username)
+	}
+	user, err := a.db.GetUserByUsername(username)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound) && !a.proxyAuth.disableSignup:
+		if user, err = a.createExternalUser(username, "proxy"); err != nil {
+			return nil, err
+		}
+		log.Printf("Action: Created account %s for proxy-authenticated user", username)
+	case err != nil:
+		return nil, err
+	}
+	if err := a.applyMappedRole(user, groups, a.proxyAuth.adminGroups, a.proxyAuth.djGroups); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
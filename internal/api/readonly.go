@@ -0,0 +1,34 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errReadOnlySignup 只读副本不能为外部认证的新用户创建账号
+var errReadOnlySignup = errors.New("accounts cannot be created on a read-only replica")
+
+// readOnlyAllowedRoutes 只读副本仍然接受的非 GET 请求：登录检查、访客加入和只读的 GraphQL 查询
+var readOnlyAllowedRoutes = map[string]bool{
+	"POST /api/login":   true,
+	"POST /api/join":    true,
+	"POST /api/graphql": true,
+}
+
+// readOnlyMiddleware 只读副本拒绝修改请求，客户端应到主实例上点歌和控制播放
+func (a *API) readOnlyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.readOnly || readOnlyAllowedRoutes[c.Request.Method+" "+c.FullPath()] {
+			c.Next()
+			return
+		}
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This instance is a read-only replica", "code": "READ_ONLY"})
+	}
+}
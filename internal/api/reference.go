@@ -0,0 +1,260 @@
+package api
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// referenceQueueSize 等待转换的引用歌曲缓冲区大小，满了之后登记请求的后台协程会等待
+const referenceQueueSize = 64
+
+// handleLibraryReference 原地引用配置目录下的文件或整个目录
+// 只记录源文件的绝对路径，HLS 在后台转换到媒体目录作为缓存，转换完成前歌曲不能播放
+func (a *API) handleLibraryReference(c *gin.Context) {
+	var payload LibraryReferencePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path is required"})
+		return
+	}
+	if len(a.libraryCfg.ReferenceRoots) == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Reference mode is not enabled, set library.referenceRoots in the config"})
+		return
+	}
+	if !filepath.IsAbs(payload.Path) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path must be absolute"})
+		return
+	}
+	// 解析符号链接后再比较，防止通过链接引用允许目录之外的文件
+	target, err := filepath.EvalSymlinks(payload.Path)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "path does not exist"})
+		return
+	}
+	if !a.underReferenceRoot(target) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path is not inside a configured reference root"})
+		return
+	}
+
+	files, err := collectAudioFiles(target)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result := LibraryReferenceResult{Added: []db.Song{}}
+	var queued []db.Song
+	for _, file := range files {
+		if _, err := a.db.FindSongBySourcePath(file); err == nil {
+			result.Skipped++
+			continue
+		}
+		song, err := a.registerReference(file, c.GetString("username"))
+		if err != nil {
+			log.Printf("Failed to reference %s: %v", file, err)
+			continue
+		}
+		result.Added = append(result.Added, *song)
+		queued = append(queued, *song)
+	}
+	log.Printf("Referenced %s: %d added, %d already in library", target, len(result.Added), result.Skipped)
+	a.queueReferences(queued)
+	c.JSON(http.StatusOK, result)
+}
+
+// underReferenceRoot 判断路径是否位于某个允许原地引用的目录下
+func (a *API) underReferenceRoot(p string) bool {
+	for _, root := range a.libraryCfg.ReferenceRoots {
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			root = resolved
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			continue
+		}
+		if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectAudioFiles 返回 p 本身（文件）或其下所有音频文件（目录），跳过隐藏文件
+func collectAudioFiles(p string) ([]string, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		if !audioExtensions[strings.ToLower(filepath.Ext(p))] {
+			return nil, errors.New("path is not an audio file")
+		}
+		return []string{p}, nil
+	}
+	var files []string
+	err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// 单个子目录读不了时不影响其他文件
+			log.Printf("Warning: Skipping %s: %v", path, err)
+			return nil
+		}
+		// macOS 在网络共享上留下的 ._xxx.mp3、.AppleDouble 等
+		if path != p && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && audioExtensions[strings.ToLower(filepath.Ext(path))] {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// registerReference 读取元数据并登记一首原地引用的歌曲，HLS 缓存生成前标记为不可播放
+func (a *API) registerReference(sourcePath, username string) (*db.Song, error) {
+	meta, err := getAudioMetadata(sourcePath)
+	if err != nil {
+		log.Printf("Warning: Metadata extraction failed: %v", err)
+		meta = &audioMetadata{}
+	}
+	if meta.Title == "" {
+		meta.Title = strings.TrimSuffix(filepath.Base(sourcePath), filepath.Ext(sourcePath))
+	}
+	mediaFileName, err := a.mediaFileName(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	songUUID, _ := uuid.NewV4()
+	songID := songUUID.String()
+	uploadedAt := time.Now()
+	song := &db.Song{
+		ID:          songID,
+		Title:       meta.Title,
+		Artist:      meta.Artist,
+		Album:       meta.Album,
+		AlbumArtist: meta.AlbumArtist,
+		Compilation: meta.Compilation,
+		Genre:       meta.Genre,
+		Mood:        meta.Mood,
+		Year:        meta.Year,
+		DurationMs:  meta.DurationMs,
+		Source:      db.SourceReference,
+		Explicit:    meta.Explicit,
+		FilePath:    songID + "/" + mediaFileName, // 媒体目录中的 HLS 缓存，没有 ffmpeg 时为源文件的副本
+		Chapters:    meta.Chapters,
+		GainDb:      meta.GainDb,
+		BPM:         meta.BPM,
+		MusicalKey:  meta.MusicalKey,
+		Technical:   a.technicalInfo(meta, mediaFileName),
+		SourcePath:  sourcePath,
+		Unavailable: true,
+
+		OriginalFilename: normalizeFilename(filepath.Base(sourcePath)),
+		UploadedBy:       username,
+		UploadedAt:       &uploadedAt,
+	}
+	if err := a.db.AddSong(song); err != nil {
+		return nil, err
+	}
+	return song, nil
+}
+
+// queueReferences 为每首歌登记排队中的任务并交给后台转换，不阻塞调用方
+func (a *API) queueReferences(songs []db.Song) {
+	if len(songs) == 0 {
+		return
+	}
+	jobs := make([]*Job, len(songs))
+	for i, song := range songs {
+		jobs[i] = a.jobs.Queue(Job{Kind: JobKindReference, SongID: song.ID, Name: song.SourcePath, DurationMs: song.DurationMs})
+	}
+	go func() {
+		for _, job := range jobs {
+			a.references <- job
+		}
+	}()
+}
+
+// transcodeReferences 逐个把引用的源文件转换为媒体目录中的 HLS 缓存，完成后歌曲变为可播放
+func (a *API) transcodeReferences() {
+	for job := range a.references {
+		songID := job.SongID
+		song, err := a.db.GetSong(songID)
+		if err != nil {
+			a.jobs.Cancel(job.ID) // 转换前已被删除
+			continue
+		}
+		songDir := filepath.Join(a.mediaDir, songID)
+		if err := os.MkdirAll(songDir, 0755); err != nil {
+			log.Printf("Failed to create HLS cache for %s: %v", song.SourcePath, err)
+			continue
+		}
+		err = a.prepareMedia(job, song.SourcePath, filepath.Join(a.mediaDir, filepath.FromSlash(song.FilePath)))
+		if err != nil {
+			os.RemoveAll(songDir)
+			log.Printf("FFmpeg conversion of referenced file %s failed: %v", song.SourcePath, err)
+			continue
+		}
+		if err := a.state.SetSongUnavailable(songID, false); err != nil {
+			log.Printf("Failed to mark song %s available: %v", songID, err)
+			continue
+		}
+		log.Printf("Referenced song converted to HLS: %s", song.Title)
+		if song.GainDb == nil {
+			a.queueGainAnalysis(songID)
+		}
+		a.queueClassification(songID)
+	}
+}
+
+// VerifyReferences 检查所有原地引用的源文件是否还在：
+// 缺失（例如 NAS 没有挂载）时标记为不可播放，文件重新出现或 HLS 缓存被清理时重新转换
+func (a *API) VerifyReferences() {
+	songs, err := a.db.GetReferencedSongs()
+	if err != nil {
+		log.Printf("Failed to load referenced songs: %v", err)
+		return
+	}
+	var missing int
+	var queued []db.Song
+	for _, song := range songs {
+		if _, err := os.Stat(song.SourcePath); err != nil {
+			missing++
+			if !song.Unavailable {
+				if err := a.state.SetSongUnavailable(song.ID, true); err != nil {
+					log.Printf("Failed to mark song %s unavailable: %v", song.ID, err)
+				}
+			}
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(a.mediaDir, filepath.FromSlash(song.FilePath))); err != nil {
+			if !song.Unavailable {
+				if err := a.state.SetSongUnavailable(song.ID, true); err != nil {
+					log.Printf("Failed to mark song %s unavailable: %v", song.ID, err)
+				}
+			}
+			queued = append(queued, song)
+			continue
+		}
+		if song.Unavailable {
+			if err := a.state.SetSongUnavailable(song.ID, false); err != nil {
+				log.Printf("Failed to mark song %s available: %v", song.ID, err)
+			}
+		}
+	}
+	if len(songs) > 0 {
+		log.Printf("Verified %d referenced songs: %d missing, %d queued for conversion", len(songs), missing, len(queued))
+	}
+	a.queueReferences(queued)
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"gorm.io/gorm"
+)
+
+// handleLibraryReplace 用新上传的文件（更好的音源、修正过的标签）替换歌曲，ID 不变
+// 播放列表、歌单、播放历史都按 ID 关联，替换后全部保留
+func (a *API) handleLibraryReplace(c *gin.Context) {
+	songID := c.Param("id")
+	song, err := a.db.GetSong(songID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Song not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get song"})
+		return
+	}
+	if song.StreamURL != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Song is streamed from another jukebox and has no local media to replace"})
+		return
+	}
+	// 每次替换使用独立的临时文件和目录，同一首歌并发替换时互不覆盖
+	tmpUUID, _ := uuid.NewV4()
+	uploadID := a.trackUploadProgress(c, tmpUUID.String())
+	fileHeader, err := c.FormFile("audioFile")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error retrieving the file"})
+		return
+	}
+	filename := normalizeFilename(fileHeader.Filename)
+	tempFilePath := filepath.Join(a.mediaDir, fmt.Sprintf("temp_%s%s", tmpUUID, filepath.Ext(filename)))
+	if err := c.SaveUploadedFile(fileHeader, tempFilePath); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving temporary file"})
+		return
+	}
+	defer os.Remove(tempFilePath)
+
+	meta, contentHash := probeFile(tempFilePath, filename)
+	mediaFileName, err := a.mediaFileName(tempFilePath)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// 新文件放在自己的内容目录中，写入数据库之前旧文件照常播放
+	// 没有哈希时使用本次替换独有的目录，避免与旧文件所在的 <id>/ 目录混在一起
+	oldFilePath := song.FilePath
+	song.FilePath = contentFilePath(songID+".replace-"+tmpUUID.String(), contentHash, mediaFileName)
+	song.Title = meta.Title
+	song.Artist = meta.Artist
+	song.Album = meta.Album
+	song.AlbumArtist = meta.AlbumArtist
+	song.Compilation = meta.Compilation
+	// 手动设置的流派和情绪不随文件替换
+	if !song.ManualTags {
+		song.Genre, song.Mood = meta.Genre, meta.Mood
+		song.GenreConfidence, song.MoodConfidence = 0, 0
+	}
+	song.Year = meta.Year
+	song.DurationMs = meta.DurationMs
+	song.Explicit = meta.Explicit
+	song.Chapters = meta.Chapters
+	song.ContentHash = contentHash
+	song.GainDb = meta.GainDb
+	song.BPM = meta.BPM
+	song.MusicalKey = meta.MusicalKey
+	song.Technical = a.technicalInfo(meta, mediaFileName)
+	// 来源记录跟随新文件
+	uploadedAt := time.Now()
+	song.OriginalFilename = filename
+	song.UploadedBy = c.GetString("username")
+	song.UploadedAt = &uploadedAt
+	song.SourceURL = ""
+	// 原地引用的歌曲替换后变为普通的本地歌曲
+	if song.SourcePath != "" {
+		song.Source = db.SourceLocal
+		song.SourcePath = ""
+		song.Unavailable = false
+	}
+	var dbErr error
+	err = a.storeMedia(song.FilePath, func(dir string) error {
+		job := a.jobs.Queue(Job{
+			Kind:       JobKindReplace,
+			SongID:     songID,
+			UploadID:   uploadID,
+			Name:       filename,
+			CreatedBy:  c.GetString("username"),
+			DurationMs: meta.DurationMs,
+		})
+		return a.prepareMedia(job, tempFilePath, filepath.Join(dir, mediaFileName))
+	}, func() error {
+		dbErr = a.db.ReplaceSongMedia(song)
+		return dbErr
+	})
+	if err != nil {
+		switch {
+		case dbErr != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating song in database"})
+		case errors.Is(err, errJobCanceled):
+			c.JSON(http.StatusConflict, gin.H{"error": "Conversion was cancelled"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert audio to HLS"})
+		}
+		return
+	}
+	// 旧文件没有其他歌曲共享时删除
+	if oldFilePath != song.FilePath {
+		a.releaseMedia(oldFilePath)
+	}
+	if err := a.state.RefreshSong(songID); err != nil {
+		log.Printf("Warning: Failed to refresh replaced song %s: %v", songID, err)
+	}
+	if song.GainDb == nil {
+		a.queueGainAnalysis(songID)
+	}
+	a.queueClassification(songID)
+	log.Printf("Song %s replaced by %s: %s (%dms)", songID, c.GetString("username"), song.Title, song.DurationMs)
+	c.JSON(http.StatusOK, song)
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/hooks"
+	"gorm.io/gorm"
+)
+
+// maxReportReasonLength 举报理由的最大长度（字符）
+const maxReportReasonLength = 500
+
+// 管理员处理举报的方式
+const (
+	reportActionDismiss   = "dismiss"
+	reportActionBlocklist = "blocklist"
+	reportActionDelete    = "delete"
+)
+
+// handleReportSong 举报曲库中的一首歌，等待管理员处理
+func (a *API) handleReportSong(c *gin.Context) {
+	var payload SongReportPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	reason := strings.TrimSpace(payload.Reason)
+	if reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required"})
+		return
+	}
+	if utf8.RuneCountInString(reason) > maxReportReasonLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason must be at most 500 characters"})
+		return
+	}
+	song, err := a.db.GetSong(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Song not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get song"})
+		return
+	}
+	report := &db.SongReport{
+		SongID:     song.ID,
+		SongTitle:  song.Title,
+		SongArtist: song.Artist,
+		Reason:     reason,
+		ReportedBy: c.GetString("username"),
+	}
+	if err := a.db.CreateSongReport(report); err != nil {
+		if errors.Is(err, db.ErrAlreadyReported) {
+			c.JSON(http.StatusConflict, gin.H{"error": "You have already reported this song"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save report"})
+		return
+	}
+	log.Printf("Action: %s reported song %s (%s)", report.ReportedBy, song.ID, song.Title)
+	a.hooks.Fire(hooks.SongReported, report)
+	c.JSON(http.StatusCreated, report)
+}
+
+// handleGetReports 返回举报，默认只返回未处理的，?status=all 返回全部
+func (a *API) handleGetReports(c *gin.Context) {
+	status := c.DefaultQuery("status", db.ReportOpen)
+	switch status {
+	case "all":
+		status = ""
+	case db.ReportOpen, db.ReportDismissed, db.ReportBlocklisted, db.ReportDeleted:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown report status"})
+		return
+	}
+	reports, err := a.db.GetSongReports(status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reports"})
+		return
+	}
+	c.JSON(http.StatusOK, reports)
+}
+
+// handleResolveReport 处理一条举报：忽略、把歌曲加入黑名单或移入回收站，同一首歌其他未处理的举报一并结案
+func (a *API) handleResolveReport(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid report ID"})
+		return
+	}
+	var payload ReportResolvePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	report, err := a.db.GetSongReport(uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Report not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reports"})
+		return
+	}
+	if report.Status != db.ReportOpen {
+		c.JSON(http.StatusConflict, gin.H{"error": "Report has already been resolved"})
+		return
+	}
+
+	username := c.GetString("username")
+	var status string
+	switch payload.Action {
+	case reportActionDismiss:
+		status = db.ReportDismissed
+	case reportActionBlocklist:
+		status = db.ReportBlocklisted
+		if !a.songBlocklisted(report.SongID) {
+			entry := &db.BlocklistEntry{SongID: report.SongID, Reason: "Reported: " + report.Reason, CreatedBy: username}
+			if err := a.state.AddBlocklistEntry(entry); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve report"})
+				return
+			}
+		}
+	case reportActionDelete:
+		status = db.ReportDeleted
+		// 歌曲可能已经被删除，只需要结案
+		if _, err := a.db.GetSong(report.SongID); err == nil {
+			if err := a.state.RemoveSongFromLibrary(report.SongID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve report"})
+				return
+			}
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "action must be dismiss, blocklist or delete"})
+		return
+	}
+	resolved, err := a.db.ResolveSongReports(report.SongID, status, username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve report"})
+		return
+	}
+	log.Printf("Action: %s resolved %d report(s) on song %s: %s", username, resolved, report.SongID, status)
+	c.JSON(http.StatusOK, gin.H{"status": status, "resolved": resolved})
+}
+
+// songBlocklisted 歌曲是否已经按 ID 加入黑名单
+func (a *API) songBlocklisted(songID string) bool {
+	for _, entry := range a.state.Blocklist() {
+		if entry.SongID == songID {
+			return true
+		}
+	}
+	return false
+}
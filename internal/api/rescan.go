@@ -0,0 +1,49 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// handleRescanLibrary 在后台重新读取年份未知的歌曲的标签，补齐升级前入库歌曲的发行年份
+// 上传的原始文件在转码后已删除，只有原地引用的歌曲或保留了标签的 HLS 能读到年份
+func (a *API) handleRescanLibrary(c *gin.Context) {
+	if !a.rescanning.CompareAndSwap(false, true) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A rescan is already running"})
+		return
+	}
+	songs, err := a.db.GetSongsWithoutYear()
+	if err != nil {
+		a.rescanning.Store(false)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get library"})
+		return
+	}
+	log.Printf("Rescan of %d songs without a release year started by %s", len(songs), c.GetString("username"))
+	go a.rescanYears(songs)
+	c.JSON(http.StatusAccepted, gin.H{"queued": len(songs)})
+}
+
+// rescanYears 逐首用 ffprobe 读取年份，读不到的保持未知
+func (a *API) rescanYears(songs []db.Song) {
+	defer a.rescanning.Store(false)
+	found := 0
+	for i := range songs {
+		input := a.mediaInput(&songs[i])
+		if input == "" {
+			continue
+		}
+		meta, err := getAudioMetadata(input)
+		if err != nil || meta.Year == 0 {
+			continue
+		}
+		if err := a.state.SetSongYear(songs[i].ID, meta.Year); err != nil {
+			log.Printf("Failed to save year of song %s: %v", songs[i].ID, err)
+			continue
+		}
+		found++
+	}
+	log.Printf("Rescan finished, found release years for %d of %d songs", found, len(songs))
+}
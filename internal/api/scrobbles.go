@@ -0,0 +1,148 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+const (
+	// Last.fm 的记录条件：歌曲长于 30 秒，且播放了一半或 4 分钟（先到者为准）
+	scrobbleMinDurationMs = 30 * 1000
+	scrobbleMaxListenMs   = 4 * 60 * 1000
+	// lastfmDateLayout Last.fm 导出工具生成的 CSV 中的时间格式（UTC）
+	lastfmDateLayout = "02 Jan 2006 15:04"
+	// scrobblerClient .scrobbler.log 头部的客户端名称
+	scrobblerClient = "SyncJukebox 2.0"
+)
+
+// ScrobbleEntry 导出的一条收听记录，Eligible 表示满足 Last.fm 的记录条件
+type ScrobbleEntry struct {
+	db.Scrobble
+	Eligible bool `json:"eligible"`
+}
+
+// scrobbleEligible 按 Last.fm 的规则判断一次播放是否算作收听，时长未知的歌曲不算
+func scrobbleEligible(s db.Scrobble) bool {
+	if s.DurationMs <= scrobbleMinDurationMs {
+		return false
+	}
+	return s.ListenedMs >= min(int64(s.DurationMs)/2, scrobbleMaxListenMs)
+}
+
+// scrobbleFilter 读取 from、to 和 requestedBy 查询参数，时间可以是 RFC 3339 或 loc 中的日期 2006-01-02，
+// to 是日期时包含当天
+func scrobbleFilter(c *gin.Context, loc *time.Location) (db.ScrobbleFilter, bool) {
+	filter := db.ScrobbleFilter{RequestedBy: c.Query("requestedBy")}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		raw := c.Query(p.name)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			if t, err = time.ParseInLocation(time.DateOnly, raw, loc); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be a date (2006-01-02) or an RFC 3339 time"})
+				return filter, false
+			}
+			if p.name == "to" {
+				t = t.AddDate(0, 0, 1)
+			}
+		}
+		*p.dst = t
+	}
+	return filter, true
+}
+
+// handleGetScrobbles 导出收听记录，未配置实时 scrobble 也能把收听数据导入其他服务
+// format=json（默认）返回全部播放并标注是否满足记录条件；format=csv 只导出满足条件的播放，
+// 列为 artist,album,title,date，与常见的 Last.fm 导出工具一致，没有表头
+func (a *API) handleGetScrobbles(c *gin.Context) {
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+	filter, ok := scrobbleFilter(c, time.UTC)
+	if !ok {
+		return
+	}
+	scrobbles, err := a.db.GetScrobbles(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get scrobbles"})
+		return
+	}
+	filename := "jukebox-scrobbles-" + time.Now().Format("20060102") + "." + format
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Header("Cache-Control", "no-store")
+
+	if format == "json" {
+		entries := make([]ScrobbleEntry, 0, len(scrobbles))
+		for _, s := range scrobbles {
+			entries = append(entries, ScrobbleEntry{Scrobble: s, Eligible: scrobbleEligible(s)})
+		}
+		c.JSON(http.StatusOK, entries)
+		return
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	for _, s := range scrobbles {
+		if !scrobbleEligible(s) {
+			continue
+		}
+		w.Write([]string{s.Artist, s.Album, s.Title, s.PlayedAt.UTC().Format(lastfmDateLayout)})
+	}
+	w.Flush()
+}
+
+// handleGetScrobblerLog 以 Audioscrobbler 1.1 的 .scrobbler.log 格式导出收听记录，
+// 可以用 Rockbox 等便携播放器的离线 scrobble 工具上传；不满足记录条件的播放标记为跳过（S）
+func (a *API) handleGetScrobblerLog(c *gin.Context) {
+	filter, ok := scrobbleFilter(c, time.UTC)
+	if !ok {
+		return
+	}
+	scrobbles, err := a.db.GetScrobbles(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get scrobbles"})
+		return
+	}
+	var b strings.Builder
+	b.WriteString("#AUDIOSCROBBLER/1.1\n#TZ/UTC\n#CLIENT/" + scrobblerClient + "\n")
+	for _, s := range scrobbles {
+		rating := "S"
+		if scrobbleEligible(s) {
+			rating = "L"
+		}
+		// 字段以制表符分隔：歌手、专辑、标题、音轨号、时长（秒）、评级、时间戳、MusicBrainz ID
+		fields := []string{
+			scrobblerField(s.Artist),
+			scrobblerField(s.Album),
+			scrobblerField(s.Title),
+			"",
+			strconv.Itoa(s.DurationMs / 1000),
+			rating,
+			strconv.FormatInt(s.PlayedAt.Unix(), 10),
+			"",
+		}
+		b.WriteString(strings.Join(fields, "\t") + "\n")
+	}
+	c.Header("Content-Disposition", `attachment; filename=".scrobbler.log"`)
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(b.String()))
+}
+
+// scrobblerField 去掉字段中的制表符和换行，它们是 .scrobbler.log 的分隔符
+func scrobblerField(s string) string {
+	return strings.NewReplacer("\t", " ", "\r", " ", "\n", " ").Replace(s)
+}
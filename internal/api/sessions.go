@@ -0,0 +1,86 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/state"
+	"gorm.io/gorm"
+)
+
+// handleStartSession 开始一场派对，之后的播放历史和统计归属于它
+func (a *API) handleStartSession(c *gin.Context) {
+	var payload StartSessionPayload
+	if err := c.ShouldBindJSON(&payload); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	session, err := a.state.StartSession(payload.Name, c.GetString("username"))
+	if errors.Is(err, db.ErrSessionActive) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start party session"})
+		return
+	}
+	c.JSON(http.StatusCreated, session)
+}
+
+// handleEndSession 结束派对并返回回顾，派对的加入链接和访客随之失效
+func (a *API) handleEndSession(c *gin.Context) {
+	session, err := a.state.EndSession()
+	if errors.Is(err, state.ErrNoSession) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end party session"})
+		return
+	}
+	if removed := a.guests.Close(); removed > 0 {
+		log.Printf("Party session %d ended, %d guests removed", session.ID, removed)
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+// handleGetSessions 列出所有派对，最近的在前
+func (a *API) handleGetSessions(c *gin.Context) {
+	sessions, err := a.db.GetPartySessions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get party sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+// handleGetSession 返回一场派对，进行中的派对附带截至目前的回顾
+func (a *API) handleGetSession(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+	session, err := a.db.GetPartySession(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Party session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get party session"})
+		return
+	}
+	if session.EndedAt == nil {
+		if session.Recap, err = a.db.PartySessionRecap(session.ID, time.Now()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build recap"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, session)
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/config"
+	"github.com/yeeeck/sync-jukebox/internal/state"
+)
+
+// fairnessLimits 房间设置中的切歌和排队额度
+func fairnessLimits(settings state.Settings) config.FairnessConfig {
+	return config.FairnessConfig{
+		MaxSkipsPerHour:    settings.MaxSkipsPerHour,
+		MaxPendingRequests: settings.MaxPendingRequests,
+	}
+}
+
+// handleGetSettings 返回房间设置，完整状态中也带有同样的内容
+func (a *API) handleGetSettings(c *gin.Context) {
+	c.JSON(http.StatusOK, a.state.Settings())
+}
+
+// handleUpdateSettings 修改房间设置，只修改请求中出现的字段，返回修改后的设置
+func (a *API) handleUpdateSettings(c *gin.Context) {
+	var payload SettingsPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	settings := a.state.Settings()
+	if payload.CrossfadeMs != nil {
+		settings.CrossfadeMs = *payload.CrossfadeMs
+	}
+	if payload.FadeMs != nil {
+		settings.FadeMs = *payload.FadeMs
+	}
+	if payload.FamilyMode != nil {
+		settings.FamilyMode = *payload.FamilyMode
+	}
+	if payload.QueueMode != nil {
+		settings.QueueMode = state.QueueMode(*payload.QueueMode)
+	}
+	if payload.MaxSkipsPerHour != nil {
+		settings.MaxSkipsPerHour = *payload.MaxSkipsPerHour
+	}
+	if payload.MaxPendingRequests != nil {
+		settings.MaxPendingRequests = *payload.MaxPendingRequests
+	}
+	if payload.MaxSongMinutes != nil {
+		settings.MaxSongMinutes = *payload.MaxSongMinutes
+	}
+	if payload.AllowedSources != nil {
+		settings.AllowedSources = *payload.AllowedSources
+	}
+	if payload.JingleEvery != nil {
+		settings.JingleEvery = *payload.JingleEvery
+	}
+	if err := a.state.SetSettings(settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	a.budget.SetLimits(fairnessLimits(settings))
+	c.JSON(http.StatusOK, a.state.Settings())
+}
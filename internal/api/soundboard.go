@@ -0,0 +1,160 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/state"
+	"gorm.io/gorm"
+)
+
+const (
+	// soundboardDir 媒体目录中存放音效板片段的子目录，片段原样保存，不转码
+	soundboardDir = "soundboard"
+	// maxClipBytes 和 maxClipMs 片段文件大小和时长的上限
+	maxClipBytes = 5 << 20
+	maxClipMs    = 15000
+	// maxClipNameLength 片段名称的最大长度（字符）
+	maxClipNameLength = 40
+)
+
+// handleGetSoundClips 返回音效板上的所有片段
+func (a *API) handleGetSoundClips(c *gin.Context) {
+	clips, err := a.db.GetSoundClips()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get clips"})
+		return
+	}
+	c.JSON(http.StatusOK, clips)
+}
+
+// handleUploadSoundClip DJ 上传一个片段，name 为空时使用文件名
+func (a *API) handleUploadSoundClip(c *gin.Context) {
+	fileHeader, err := c.FormFile("clipFile")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error retrieving the file"})
+		return
+	}
+	if fileHeader.Size > maxClipBytes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "clip must be at most 5 MB"})
+		return
+	}
+	filename := normalizeFilename(fileHeader.Filename)
+	ext := strings.ToLower(filepath.Ext(filename))
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		name = strings.TrimSuffix(filename, filepath.Ext(filename))
+	}
+	if name == "" || utf8.RuneCountInString(name) > maxClipNameLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 1 to 40 characters"})
+		return
+	}
+
+	clipUUID, _ := uuid.NewV4()
+	tempFilePath := filepath.Join(a.mediaDir, fmt.Sprintf("temp_%s%s", clipUUID, ext))
+	if err := c.SaveUploadedFile(fileHeader, tempFilePath); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving temporary file"})
+		return
+	}
+	defer os.Remove(tempFilePath)
+	meta, err := getAudioMetadata(tempFilePath)
+	if err != nil || meta.DurationMs <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is not a supported audio file"})
+		return
+	}
+	if meta.DurationMs > maxClipMs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "clip must be at most 15 seconds"})
+		return
+	}
+
+	relPath := path.Join(soundboardDir, clipUUID.String()+ext)
+	absPath := filepath.Join(a.mediaDir, filepath.FromSlash(relPath))
+	if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save clip"})
+		return
+	}
+	if err := os.Rename(tempFilePath, absPath); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save clip"})
+		return
+	}
+	clip := &db.SoundClip{Name: name, FilePath: relPath, DurationMs: meta.DurationMs, UploadedBy: c.GetString("username")}
+	if err := a.db.AddSoundClip(clip); err != nil {
+		os.Remove(absPath)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save clip"})
+		return
+	}
+	log.Printf("Action: %s uploaded soundboard clip %d (%s, %dms)", clip.UploadedBy, clip.ID, clip.Name, clip.DurationMs)
+	c.JSON(http.StatusCreated, clip)
+}
+
+// handlePlaySoundClip DJ 触发一个片段，所有声明了 soundboard 功能的客户端叠加在当前歌曲上播放
+func (a *API) handlePlaySoundClip(c *gin.Context) {
+	clip, ok := a.soundClipParam(c)
+	if !ok {
+		return
+	}
+	a.state.PlayClip(clip, actorFrom(c))
+	c.Status(http.StatusAccepted)
+}
+
+// handleDeleteSoundClip 从音效板删除一个片段及其文件
+func (a *API) handleDeleteSoundClip(c *gin.Context) {
+	clip, ok := a.soundClipParam(c)
+	if !ok {
+		return
+	}
+	if err := a.db.DeleteSoundClip(clip.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete clip"})
+		return
+	}
+	if err := os.Remove(filepath.Join(a.mediaDir, filepath.FromSlash(clip.FilePath))); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Warning: failed to delete clip file %s: %v", clip.FilePath, err)
+	}
+	log.Printf("Action: %s deleted soundboard clip %d (%s)", c.GetString("username"), clip.ID, clip.Name)
+	c.Status(http.StatusNoContent)
+}
+
+// playSoundClipWS 处理 WebSocket 上行的 SOUNDBOARD 消息，只有 DJ 和管理员可以触发
+func (a *API) playSoundClipWS(username string, clipID uint) {
+	user, err := a.db.GetUserByUsername(username)
+	if err != nil || (user.Role != db.RoleAdmin && user.Role != db.RoleDJ) {
+		log.Printf("WS soundboard from %q rejected: insufficient privileges", username)
+		return
+	}
+	clip, err := a.db.GetSoundClip(clipID)
+	if err != nil {
+		log.Printf("WS soundboard from %q rejected: clip %d not found", username, clipID)
+		return
+	}
+	a.state.PlayClip(clip, state.Actor{Username: username, IsAdmin: user.Role == db.RoleAdmin})
+}
+
+// soundClipParam 按路径参数 id 查找片段，找不到时写出错误响应并返回 false
+func (a *API) soundClipParam(c *gin.Context) (*db.SoundClip, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid clip ID"})
+		return nil, false
+	}
+	clip, err := a.db.GetSoundClip(uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Clip not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get clips"})
+		return nil, false
+	}
+	return clip, true
+}
@@ -0,0 +1,373 @@
+package api
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/config"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/hooks"
+	"github.com/yeeeck/sync-jukebox/internal/i18n"
+	"github.com/yeeeck/sync-jukebox/internal/oidc"
+	"gorm.io/gorm"
+)
+
+const (
+	// ssoFlowTTL 从跳转到身份提供方到回调、从回调到前端换取凭证的时间上限
+	ssoFlowTTL = 10 * time.Minute
+	// defaultSSOSessionHours 单点登录后令牌的默认有效期
+	defaultSSOSessionHours = 30 * 24
+	// ssoLoginPage 回调结束后返回的前端页面，结果放在 sso（一次性代码）或 ssoError 参数中
+	ssoLoginPage = "/login"
+)
+
+// ssoRefusal 按配置拒绝登录的原因，可以直接显示给用户
+type ssoRefusal string
+
+func (e ssoRefusal) Error() string { return string(e) }
+
+const (
+	errSSOUsernameInvalid ssoRefusal = "The identity provider did not return a usable username"
+	errSSONotAllowed      ssoRefusal = "Your account is not allowed to sign in to this jukebox"
+	errSSOSignupDisabled  ssoRefusal = "No account is linked to this identity, ask an admin to link it"
+	errSSOUsernameTaken   ssoRefusal = "A local account with this username already exists"
+)
+
+// SSOInfo 登录页显示单点登录按钮所需的信息
+type SSOInfo struct {
+	Enabled  bool   `json:"enabled"`
+	Label    string `json:"label,omitempty"`
+	LoginURL string `json:"loginUrl,omitempty"`
+}
+
+// SSOLogin 单点登录成功后前端使用的凭证：以 Username 和 Token 作为 Basic Auth 的用户名和密码
+type SSOLogin struct {
+	Username  string    `json:"username"`
+	Token     string    `json:"token"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ssoPending 跳转到身份提供方时记下的校验信息，以 state 为键
+type ssoPending struct {
+	nonce       string
+	verifier    string
+	redirectURI string
+	expiresAt   time.Time
+}
+
+// ssoFlows 进行中的单点登录，只保存在内存中；集群模式下所有 /api 请求都由主实例处理
+type ssoFlows struct {
+	mu      sync.Mutex
+	pending map[string]ssoPending
+	// results 回调完成后等待前端领取的凭证，以一次性代码为键，凭证不出现在跳转地址中
+	results map[string]SSOLogin
+	// resultExpiry 凭证的领取期限
+	resultExpiry map[string]time.Time
+}
+
+func newSSOFlows() *ssoFlows {
+	return &ssoFlows{
+		pending:      make(map[string]ssoPending),
+		results:      make(map[string]SSOLogin),
+		resultExpiry: make(map[string]time.Time),
+	}
+}
+
+func (f *ssoFlows) pruneLocked(now time.Time) {
+	for state, p := range f.pending {
+		if now.After(p.expiresAt) {
+			delete(f.pending, state)
+		}
+	}
+	for code, expiry := range f.resultExpiry {
+		if now.After(expiry) {
+			delete(f.results, code)
+			delete(f.resultExpiry, code)
+		}
+	}
+}
+
+func (f *ssoFlows) start(state string, p ssoPending) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pruneLocked(time.Now())
+	f.pending[state] = p
+}
+
+// take 取出并删除 state 对应的记录，每个 state 只能使用一次
+func (f *ssoFlows) take(state string) (ssoPending, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pruneLocked(time.Now())
+	p, ok := f.pending[state]
+	delete(f.pending, state)
+	return p, ok
+}
+
+func (f *ssoFlows) finish(code string, login SSOLogin) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[code] = login
+	f.resultExpiry[code] = time.Now().Add(ssoFlowTTL)
+}
+
+// redeem 用一次性代码领取凭证
+func (f *ssoFlows) redeem(code string) (SSOLogin, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pruneLocked(time.Now())
+	login, ok := f.results[code]
+	delete(f.results, code)
+	delete(f.resultExpiry, code)
+	return login, ok
+}
+
+// ssoRedirectURI 身份提供方回调的地址
+func (a *API) ssoRedirectURI(c *gin.Context) string {
+	if a.oidcCfg.RedirectURL != "" {
+		return a.oidcCfg.RedirectURL
+	}
+	return requestBaseURL(c) + "/api/auth/oidc/callback"
+}
+
+// handleSSOInfo 返回是否启用了单点登录
+func (a *API) handleSSOInfo(c *gin.Context) {
+	if a.oidc == nil {
+		c.JSON(http.StatusOK, SSOInfo{})
+		return
+	}
+	label := a.oidcCfg.ButtonLabel
+	if label == "" {
+		label = "SSO"
+	}
+	c.JSON(http.StatusOK, SSOInfo{Enabled: true, Label: label, LoginURL: "/api/auth/oidc/login"})
+}
+
+// handleSSOLogin 跳转到身份提供方的登录页
+func (a *API) handleSSOLogin(c *gin.Context) {
+	if a.oidc == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not enabled"})
+		return
+	}
+	state, nonce, verifier := oidc.NewChallenge()
+	redirectURI := a.ssoRedirectURI(c)
+	target, err := a.oidc.AuthURL(c.Request.Context(), state, nonce, verifier, redirectURI)
+	if err != nil {
+		log.Printf("Error starting single sign-on: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+	a.ssoFlows.start(state, ssoPending{nonce: nonce, verifier: verifier, redirectURI: redirectURI, expiresAt: time.Now().Add(ssoFlowTTL)})
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, target)
+}
+
+// handleSSOCallback 身份提供方登录完成后的回调：换取身份、创建或更新账号、签发令牌，然后回到前端登录页
+func (a *API) handleSSOCallback(c *gin.Context) {
+	if a.oidc == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not enabled"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	// 跳转后不经过错误翻译中间件，这里直接翻译
+	fail := func(message string) {
+		message = i18n.T(i18n.Language(c), message)
+		c.Redirect(http.StatusFound, ssoLoginPage+"?"+url.Values{"ssoError": {message}}.Encode())
+	}
+	if e := c.Query("error"); e != "" {
+		log.Printf("Single sign-on was rejected by the identity provider: %s %s", e, c.Query("error_description"))
+		fail("Sign-in was cancelled or rejected by the identity provider")
+		return
+	}
+	pending, ok := a.ssoFlows.take(c.Query("state"))
+	if !ok || c.Query("code") == "" {
+		fail("Sign-in link expired, please try again")
+		return
+	}
+	identity, err := a.oidc.Exchange(c.Request.Context(), c.Query("code"), pending.verifier, pending.redirectURI, pending.nonce)
+	if err != nil {
+		log.Printf("Error completing single sign-on: %v", err)
+		fail("Failed to verify the identity provider's response")
+		return
+	}
+	user, err := a.provisionSSOUser(identity)
+	if err != nil {
+		log.Printf("Single sign-on for subject %s refused: %v", identity.Subject, err)
+		var refusal ssoRefusal
+		if errors.As(err, &refusal) {
+			fail(refusal.Error())
+		} else {
+			fail("Failed to sign in")
+		}
+		return
+	}
+	hours := a.oidcCfg.SessionHours
+	if hours <= 0 {
+		hours = defaultSSOSessionHours
+	}
+	token, expiresAt, err := a.db.CreateLoginToken(user.Username, db.LoginMethodSSO, deviceName(c.Request), time.Duration(hours)*time.Hour)
+	if err != nil {
+		log.Printf("Error creating login token for %s: %v", user.Username, err)
+		fail("Failed to sign in")
+		return
+	}
+	code, err := randomToken(24)
+	if err != nil {
+		fail("Failed to sign in")
+		return
+	}
+	a.ssoFlows.finish(code, SSOLogin{Username: user.Username, Token: token, Role: user.Role, ExpiresAt: expiresAt})
+	log.Printf("Action: %s signed in via single sign-on", user.Username)
+	c.Redirect(http.StatusFound, ssoLoginPage+"?"+url.Values{"sso": {code}}.Encode())
+}
+
+// handleSSOExchange 前端用回调带回的一次性代码领取凭证
+func (a *API) handleSSOExchange(c *gin.Context) {
+	var payload SSOExchangePayload
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+	login, ok := a.ssoFlows.redeem(payload.Code)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign-in link expired, please try again"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, login)
+}
+
+// handleLogout 注销当前使用的登录令牌并删除媒体 Cookie；使用密码登录时没有需要注销的令牌
+func (a *API) handleLogout(c *gin.Context) {
+	clearMediaCookie(c)
+	if _, pass, ok := c.Request.BasicAuth(); ok && db.IsLoginToken(pass) {
+		if err := a.db.RevokeLoginToken(pass); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out"})
+			return
+		}
+	}
+	c.Status(http.StatusOK)
+}
+
+// provisionSSOUser 按身份找到已关联的账号，没有时按配置关联同名账号或新建，并按声明映射角色
+func (a *API) provisionSSOUser(identity *oidc.Identity) (*db.User, error) {
+	cfg := a.oidcCfg
+	groups := identity.Strings(cmp.Or(cfg.RolesClaim, "groups"))
+	if len(cfg.AllowedGroups) > 0 && !containsAny(groups, cfg.AllowedGroups) {
+		return nil, errSSONotAllowed
+	}
+
+	user, err := a.db.GetUserByOIDCSubject(identity.Subject)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if user == nil {
+		username := ssoUsername(identity, cfg)
+		if username == "" {
+			return nil, errSSOUsernameInvalid
+		}
+		existing, err := a.db.GetUserByUsername(username)
+		switch {
+		case err == nil && existing.OIDCSubject == "" && cfg.LinkExistingUsers:
+			user = existing
+		case err == nil:
+			return nil, errSSOUsernameTaken
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, err
+		case cfg.DisableSignup:
+			return nil, errSSOSignupDisabled
+		default:
+			if user, err = a.createExternalUser(username, "oidc"); err != nil {
+				return nil, err
+			}
+		}
+		if err := a.db.SetUserOIDCSubject(user.Username, identity.Subject); err != nil {
+			return nil, err
+		}
+		user.OIDCSubject = identity.Subject
+	}
+
+	if err := a.applyMappedRole(user, groups, cfg.AdminGroups, cfg.DJGroups); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// createExternalUser 为外部认证（单点登录、认证代理）的用户创建本地账号，source 记录在注册事件中
+// 密码随机生成且不告诉任何人，账号只能通过外部认证进入
+func (a *API) createExternalUser(username, source string) (*db.User, error) {
+	if a.readOnly {
+		return nil, errReadOnlySignup
+	}
+	password, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	user, err := a.db.CreateUser(username, password)
+	if err != nil {
+		return nil, err
+	}
+	a.hooks.Fire(hooks.UserRegistered, gin.H{"username": user.Username, "role": user.Role, "source": source})
+	return user, nil
+}
+
+// applyMappedRole 配置了组映射时按外部认证给出的组设置角色，管理员优先；都没有配置时保留本地设置的角色
+func (a *API) applyMappedRole(user *db.User, groups, adminGroups, djGroups []string) error {
+	if len(adminGroups) == 0 && len(djGroups) == 0 {
+		return nil
+	}
+	role := db.RoleUser
+	switch {
+	case containsAny(groups, adminGroups):
+		role = db.RoleAdmin
+	case containsAny(groups, djGroups):
+		role = db.RoleDJ
+	}
+	if role == user.Role {
+		return nil
+	}
+	if err := a.db.SetUserRole(user.Username, role); err != nil {
+		return err
+	}
+	log.Printf("Action: Set role of %s to %s from external groups", user.Username, role)
+	user.Role = role
+	return nil
+}
+
+// usableExternalUsername 外部认证给出的用户名不能含冒号（Basic Auth 的分隔符），也不能冒充访客
+func usableExternalUsername(username string) bool {
+	return username != "" && !strings.Contains(username, ":") && !db.IsGuestUsername(username)
+}
+
+// ssoUsername 按配置的声明取用户名，依次回退到 email 和 sub
+func ssoUsername(identity *oidc.Identity, cfg config.OIDCConfig) string {
+	for _, claim := range []string{cmp.Or(cfg.UsernameClaim, "preferred_username"), "email", "sub"} {
+		username := strings.TrimSpace(identity.String(claim))
+		if username == "" {
+			continue
+		}
+		if !usableExternalUsername(username) {
+			return ""
+		}
+		return username
+	}
+	return ""
+}
+
+func containsAny(values, wanted []string) bool {
+	for _, v := range values {
+		if slices.Contains(wanted, v) {
+			return true
+		}
+	}
+	return false
+}
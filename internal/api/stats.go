@@ -0,0 +1,388 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+const (
+	// defaultStatsDays 没有指定 from 时统计最近多少天
+	defaultStatsDays = 30
+	// 排行榜默认和最多返回的条数
+	defaultTopLimit = 20
+	maxTopLimit     = 100
+)
+
+// ListeningActivity 按星期和小时统计的收听情况，用于“家里什么时候最热闹”热力图
+// Plays 和 Minutes 的第一维是星期（0 为周日），第二维是小时（0-23），时间按 Timezone 计算
+type ListeningActivity struct {
+	From     time.Time      `json:"from"`
+	To       time.Time      `json:"to"`
+	Timezone string         `json:"timezone"`
+	Plays    [7][24]int     `json:"plays"`
+	Minutes  [7][24]float64 `json:"minutes"`
+	// ByHour 和 ByDay 是热力图按行、按列的合计
+	ByHour       [24]ActivityTotal `json:"byHour"`
+	ByDay        [7]ActivityTotal  `json:"byDay"`
+	TotalPlays   int               `json:"totalPlays"`
+	TotalMinutes float64           `json:"totalMinutes"`
+}
+
+// ActivityTotal 一个时段的播放次数和收听分钟数
+type ActivityTotal struct {
+	Plays   int     `json:"plays"`
+	Minutes float64 `json:"minutes"`
+}
+
+// statsRange 读取统计接口共用的查询参数：from、to（日期或 RFC 3339，默认最近 30 天）、
+// tz（IANA 时区，日期和时段按它计算，默认服务器时区）、requestedBy；参数无效时写出错误响应并返回 false
+func statsRange(c *gin.Context) (db.ScrobbleFilter, *time.Location, bool) {
+	loc := time.Local
+	if tz := c.Query("tz"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tz must be an IANA time zone such as Europe/Berlin"})
+			return db.ScrobbleFilter{}, nil, false
+		}
+	}
+	filter, ok := scrobbleFilter(c, loc)
+	if !ok {
+		return filter, nil, false
+	}
+	if filter.To.IsZero() {
+		filter.To = time.Now()
+	}
+	if filter.From.IsZero() {
+		filter.From = filter.To.AddDate(0, 0, -defaultStatsDays)
+	}
+	if !filter.From.Before(filter.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return filter, nil, false
+	}
+	return filter, loc, true
+}
+
+// handleGetActivity 统计一段时间内每周各时段的播放次数和收听分钟数，查询参数见 statsRange
+// 一次收听全部计入开始播放的时段
+func (a *API) handleGetActivity(c *gin.Context) {
+	filter, loc, ok := statsRange(c)
+	if !ok {
+		return
+	}
+	scrobbles, err := a.db.GetScrobbleTimes(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get listening activity"})
+		return
+	}
+
+	activity := ListeningActivity{From: filter.From.In(loc), To: filter.To.In(loc), Timezone: loc.String()}
+	if loc == time.Local {
+		// 服务器时区没有 IANA 名称可用，返回时区缩写，例如 CST
+		activity.Timezone, _ = activity.To.Zone()
+	}
+	// 先按毫秒累加，最后再换算为分钟，避免合计中累积舍入误差
+	var cellMs [7][24]int64
+	var hourMs [24]int64
+	var dayMs [7]int64
+	var totalMs int64
+	for _, s := range scrobbles {
+		t := s.PlayedAt.In(loc)
+		day, hour := int(t.Weekday()), t.Hour()
+		activity.Plays[day][hour]++
+		activity.ByHour[hour].Plays++
+		activity.ByDay[day].Plays++
+		cellMs[day][hour] += s.ListenedMs
+		hourMs[hour] += s.ListenedMs
+		dayMs[day] += s.ListenedMs
+		totalMs += s.ListenedMs
+	}
+	for day := range cellMs {
+		for hour, ms := range cellMs[day] {
+			activity.Minutes[day][hour] = activityMinutes(ms)
+		}
+		activity.ByDay[day].Minutes = activityMinutes(dayMs[day])
+	}
+	for hour, ms := range hourMs {
+		activity.ByHour[hour].Minutes = activityMinutes(ms)
+	}
+	activity.TotalPlays = len(scrobbles)
+	activity.TotalMinutes = activityMinutes(totalMs)
+	c.JSON(http.StatusOK, activity)
+}
+
+// activityMinutes 把毫秒换算为分钟，保留一位小数
+func activityMinutes(ms int64) float64 {
+	return math.Round(float64(ms)/6000) / 10
+}
+
+// TopArtist 排行榜中的一位歌手，合作歌曲计入主唱
+type TopArtist struct {
+	Name    string  `json:"name"`
+	Plays   int     `json:"plays"`
+	Minutes float64 `json:"minutes"`
+	// Listeners 点播过的不同用户数，自动播放不计
+	Listeners int `json:"listeners"`
+	// Songs 播放过的不同歌曲数
+	Songs int `json:"songs"`
+	// ArtworkURL 播放最多且有封面的歌曲的封面，都没有封面时为空
+	ArtworkURL string `json:"artworkUrl,omitempty"`
+}
+
+// TopAlbum 排行榜中的一张专辑，按专辑名和专辑歌手区分
+type TopAlbum struct {
+	Title      string  `json:"title"`
+	Artist     string  `json:"artist"`
+	Plays      int     `json:"plays"`
+	Minutes    float64 `json:"minutes"`
+	Listeners  int     `json:"listeners"`
+	ArtworkURL string  `json:"artworkUrl,omitempty"`
+}
+
+// TopArtists 一段时间内的歌手排行
+type TopArtists struct {
+	From    time.Time   `json:"from"`
+	To      time.Time   `json:"to"`
+	Artists []TopArtist `json:"artists"`
+}
+
+// TopAlbums 一段时间内的专辑排行
+type TopAlbums struct {
+	From   time.Time  `json:"from"`
+	To     time.Time  `json:"to"`
+	Albums []TopAlbum `json:"albums"`
+}
+
+// topGroup 排行中一项的累计数据
+type topGroup struct {
+	name       string
+	artist     string
+	plays      int
+	listenedMs int64
+	listeners  map[string]bool
+	// songPlays 每首歌的播放次数，按播放次数挑选封面
+	songPlays map[string]int
+}
+
+// groupTop 把满足 Last.fm 记录条件的播放按 key 分组，按播放次数、收听时长排序后返回前 limit 项
+// key 返回空字符串的播放不计入
+func groupTop(scrobbles []db.Scrobble, limit int, key func(song *db.Song) (key, name, artist string)) []*topGroup {
+	byKey := make(map[string]*topGroup)
+	var groups []*topGroup
+	for _, s := range scrobbles {
+		if !scrobbleEligible(s) {
+			continue
+		}
+		k, name, artist := key(&db.Song{Title: s.Title, Artist: s.Artist, Album: s.Album, AlbumArtist: s.AlbumArtist})
+		if k == "" {
+			continue
+		}
+		g, ok := byKey[k]
+		if !ok {
+			g = &topGroup{listeners: make(map[string]bool), songPlays: make(map[string]int)}
+			byKey[k] = g
+			groups = append(groups, g)
+		}
+		// 大小写等写法不同时显示最近一次播放的写法
+		g.name, g.artist = name, artist
+		g.plays++
+		g.listenedMs += s.ListenedMs
+		g.songPlays[s.SongID]++
+		if s.RequestedBy != "" {
+			g.listeners[s.RequestedBy] = true
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].plays != groups[j].plays {
+			return groups[i].plays > groups[j].plays
+		}
+		return groups[i].listenedMs > groups[j].listenedMs
+	})
+	return groups[:min(limit, len(groups))]
+}
+
+// topArtwork 返回分组中播放最多且仍在曲库中、有封面的歌曲的封面地址
+func (a *API) topArtwork(g *topGroup, songs map[string]*db.Song, baseURL string) string {
+	ids := make([]string, 0, len(g.songPlays))
+	for id := range g.songPlays {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if g.songPlays[ids[i]] != g.songPlays[ids[j]] {
+			return g.songPlays[ids[i]] > g.songPlays[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	for _, id := range ids {
+		song, ok := songs[id]
+		if !ok {
+			// 已删除的歌曲查不到，记为 nil 避免重复查询
+			song, _ = a.db.GetSong(id)
+			songs[id] = song
+		}
+		if a.artworkPath(song) != "" {
+			return artworkURL(song, baseURL)
+		}
+	}
+	return ""
+}
+
+// topScrobbles 读取排行接口的查询参数和对应的收听记录，参数无效或查询失败时写出错误响应并返回 false
+// 除 statsRange 的参数外，limit 指定返回的条数（默认 20，最多 100）
+func (a *API) topScrobbles(c *gin.Context) (db.ScrobbleFilter, []db.Scrobble, int, bool) {
+	limit := defaultTopLimit
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return db.ScrobbleFilter{}, nil, 0, false
+		}
+		limit = min(n, maxTopLimit)
+	}
+	filter, _, ok := statsRange(c)
+	if !ok {
+		return filter, nil, 0, false
+	}
+	scrobbles, err := a.db.GetScrobbles(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get top lists"})
+		return filter, nil, 0, false
+	}
+	return filter, scrobbles, limit, true
+}
+
+// handleGetTopArtists 一段时间内播放最多的歌手，只计满足 Last.fm 记录条件的播放
+func (a *API) handleGetTopArtists(c *gin.Context) {
+	filter, scrobbles, limit, ok := a.topScrobbles(c)
+	if !ok {
+		return
+	}
+	groups := groupTop(scrobbles, limit, func(song *db.Song) (string, string, string) {
+		artist := song.PrimaryArtist()
+		return db.ArtistKey(artist), artist, ""
+	})
+	baseURL := requestBaseURL(c)
+	songs := make(map[string]*db.Song)
+	top := TopArtists{From: filter.From, To: filter.To, Artists: make([]TopArtist, 0, len(groups))}
+	for _, g := range groups {
+		top.Artists = append(top.Artists, TopArtist{
+			Name:       g.name,
+			Plays:      g.plays,
+			Minutes:    activityMinutes(g.listenedMs),
+			Listeners:  len(g.listeners),
+			Songs:      len(g.songPlays),
+			ArtworkURL: a.topArtwork(g, songs, baseURL),
+		})
+	}
+	c.JSON(http.StatusOK, top)
+}
+
+// handleGetTopAlbums 一段时间内播放最多的专辑，分组方式与曲库的专辑列表一致，没有专辑名的歌曲不计入
+func (a *API) handleGetTopAlbums(c *gin.Context) {
+	filter, scrobbles, limit, ok := a.topScrobbles(c)
+	if !ok {
+		return
+	}
+	groups := groupTop(scrobbles, limit, func(song *db.Song) (string, string, string) {
+		if song.Album == "" {
+			return "", "", ""
+		}
+		artist := song.AlbumGroupArtist()
+		return strings.ToLower(song.Album) + "\x00" + db.ArtistKey(artist), song.Album, artist
+	})
+	baseURL := requestBaseURL(c)
+	songs := make(map[string]*db.Song)
+	top := TopAlbums{From: filter.From, To: filter.To, Albums: make([]TopAlbum, 0, len(groups))}
+	for _, g := range groups {
+		top.Albums = append(top.Albums, TopAlbum{
+			Title:      g.name,
+			Artist:     g.artist,
+			Plays:      g.plays,
+			Minutes:    activityMinutes(g.listenedMs),
+			Listeners:  len(g.listeners),
+			ArtworkURL: a.topArtwork(g, songs, baseURL),
+		})
+	}
+	c.JSON(http.StatusOK, top)
+}
+
+// defaultSkipMinPlays 切歌统计默认只列出至少播放过这么多次的歌曲，避免只放过一两次的歌曲切歌率失真
+const defaultSkipMinPlays = 3
+
+// SongSkips 一首歌在一段时间内的切歌情况
+type SongSkips struct {
+	SongID string `json:"songId"`
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+	Plays  int    `json:"plays"`
+	Skips  int    `json:"skips"`
+	// SkipRate 被切掉的比例，0 到 1
+	SkipRate float64 `json:"skipRate"`
+	// Reasons 各个切歌原因的次数，没有给出原因的切歌不计入
+	Reasons map[string]int `json:"reasons"`
+}
+
+// SkipStats 一段时间内切歌率最高的歌曲，供加入黑名单或随机播放降权时参考
+type SkipStats struct {
+	From  time.Time   `json:"from"`
+	To    time.Time   `json:"to"`
+	Songs []SongSkips `json:"songs"`
+}
+
+// handleGetSkipStats 按切歌率从高到低列出歌曲，除 topScrobbles 的参数外，minPlays 指定最少播放次数（默认 3）
+func (a *API) handleGetSkipStats(c *gin.Context) {
+	minPlays := defaultSkipMinPlays
+	if raw := c.Query("minPlays"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "minPlays must be a positive number"})
+			return
+		}
+		minPlays = n
+	}
+	filter, scrobbles, limit, ok := a.topScrobbles(c)
+	if !ok {
+		return
+	}
+	bySong := make(map[string]*SongSkips)
+	var songs []*SongSkips
+	for _, s := range scrobbles {
+		song, ok := bySong[s.SongID]
+		if !ok {
+			song = &SongSkips{SongID: s.SongID, Reasons: make(map[string]int)}
+			bySong[s.SongID] = song
+			songs = append(songs, song)
+		}
+		// 显示最近一次播放时的标题
+		song.Title, song.Artist = s.Title, s.Artist
+		song.Plays++
+		if s.Skipped {
+			song.Skips++
+			if s.SkipReason != "" {
+				song.Reasons[s.SkipReason]++
+			}
+		}
+	}
+	stats := SkipStats{From: filter.From, To: filter.To, Songs: []SongSkips{}}
+	for _, song := range songs {
+		if song.Plays < minPlays || song.Skips == 0 {
+			continue
+		}
+		song.SkipRate = math.Round(float64(song.Skips)/float64(song.Plays)*100) / 100
+		stats.Songs = append(stats.Songs, *song)
+	}
+	sort.SliceStable(stats.Songs, func(i, j int) bool {
+		if stats.Songs[i].SkipRate != stats.Songs[j].SkipRate {
+			return stats.Songs[i].SkipRate > stats.Songs[j].SkipRate
+		}
+		return stats.Songs[i].Skips > stats.Songs[j].Skips
+	})
+	stats.Songs = stats.Songs[:min(limit, len(stats.Songs))]
+	c.JSON(http.StatusOK, stats)
+}
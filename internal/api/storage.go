@@ -0,0 +1,117 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/gofrs/uuid"
+)
+
+// contentRoot 按内容哈希存放播放文件的目录，同一个文件上传多次只转换和保存一次
+const contentRoot = "content"
+
+// contentFilePath 返回新上传文件的播放文件相对路径：content/<哈希前两位>/<哈希>/<文件名>
+// 没有哈希时（计算失败）退回到按歌曲 ID 命名的目录，不与其他歌曲共享
+func contentFilePath(songID, contentHash, mediaFileName string) string {
+	if len(contentHash) < 2 {
+		return path.Join(songID, mediaFileName)
+	}
+	return path.Join(contentRoot, contentHash[:2], contentHash, mediaFileName)
+}
+
+// storeMedia 生成 filePath 指向的播放文件并由 commit 写入数据库
+// 文件已经存在时（同一内容之前上传过）直接复用，不调用 produce；否则 produce 在临时目录中生成文件，完成后移入目标目录
+// commit 在持有存储锁时执行，与 releaseMedia 互斥，保证刚被引用的目录不会被当作无人引用删掉
+func (a *API) storeMedia(filePath string, produce func(dir string) error, commit func() error) error {
+	target := filepath.Join(a.mediaDir, filepath.FromSlash(filePath))
+	dir := filepath.Dir(target)
+
+	a.storageMu.Lock()
+	if _, err := os.Stat(target); err == nil {
+		defer a.storageMu.Unlock()
+		log.Printf("Reusing stored media %s", filePath)
+		return commit()
+	}
+	a.storageMu.Unlock()
+
+	stagingUUID, _ := uuid.NewV4()
+	staging := dir + ".staging-" + stagingUUID.String()
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	if err := produce(staging); err != nil {
+		return err
+	}
+
+	a.storageMu.Lock()
+	defer a.storageMu.Unlock()
+	// 同一内容的并发上传已经先完成时丢弃这一份
+	if _, err := os.Stat(target); err != nil {
+		if err := moveDirContents(staging, dir, filepath.Base(target)); err != nil {
+			return err
+		}
+	}
+	if err := commit(); err != nil {
+		a.removeUnreferenced(path.Dir(filePath))
+		return err
+	}
+	return nil
+}
+
+// releaseMedia 歌曲不再使用 filePath 后调用（永久删除、替换为新文件），目录没有其他歌曲引用时删除
+// 回收站中的歌曲仍然算作引用，恢复后可以照常播放
+func (a *API) releaseMedia(filePath string) {
+	a.storageMu.Lock()
+	defer a.storageMu.Unlock()
+	a.removeUnreferenced(path.Dir(filePath))
+}
+
+// removeUnreferenced 在持有存储锁时按引用数删除目录
+func (a *API) removeUnreferenced(relDir string) {
+	// 不是 <目录>/<文件> 形式的旧数据，避免删除整个媒体目录
+	if relDir == "." || relDir == "/" || relDir == contentRoot {
+		return
+	}
+	refs, err := a.db.CountSongsInMediaDir(relDir)
+	if err != nil {
+		log.Printf("Warning: failed to count references to %s, keeping it: %v", relDir, err)
+		return
+	}
+	if refs > 0 {
+		return
+	}
+	absDir := filepath.Join(a.mediaDir, filepath.FromSlash(relDir))
+	// 使用 RemoveAll 递归删除目录及其内容 (.m3u8 和 .ts)
+	if err := os.RemoveAll(absDir); err != nil {
+		log.Printf("Warning: failed to delete audio directory %s: %v", absDir, err)
+	}
+}
+
+// moveDirContents 把 src 中的文件逐个移动到 dst，dst 不存在时创建
+// last（索引或原始文件）最后移动，它存在就说明切片都已就位
+// 目录中可能已有另一种播放文件（例如安装 ffmpeg 之前保存的原始文件），同名文件被覆盖
+func moveDirContents(src, dst, last string) error {
+	if _, err := os.Stat(filepath.Join(src, last)); err != nil {
+		return errors.New("conversion did not produce " + last)
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == last {
+			continue
+		}
+		if err := os.Rename(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return os.Rename(filepath.Join(src, last), filepath.Join(dst, last))
+}
@@ -0,0 +1,250 @@
+package api
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/config"
+)
+
+const (
+	// defaultStreamIdle 未配置时多久没有请求认为流已结束，大于 HLS 切片的时长
+	defaultStreamIdle = 30 * time.Second
+	// streamCredentialTTL 媒体请求中凭证的验证结果缓存时间，避免每个切片都做 bcrypt
+	streamCredentialTTL = 5 * time.Minute
+	// mediaPathPrefix 媒体文件的路径前缀
+	mediaPathPrefix = "/static/audio/"
+	// mediaUserKey 请求上下文中保存媒体请求用户的键
+	mediaUserKey = "mediaUser"
+)
+
+// StreamStats 当前的播放流统计，Rejected 为启动以来因超出限制被拒绝的请求数
+type StreamStats struct {
+	Active     int    `json:"active"`
+	Clients    int    `json:"clients"`
+	Users      int    `json:"users"`
+	Rejected   uint64 `json:"rejected"`
+	MaxPerIP   int    `json:"maxPerIp"`
+	MaxPerUser int    `json:"maxPerUser"`
+}
+
+// ActiveStream 一个正在播放的流，Username 为空表示请求没有带凭证
+type ActiveStream struct {
+	IP       string    `json:"ip"`
+	Username string    `json:"username,omitempty"`
+	Media    string    `json:"media"`
+	Since    time.Time `json:"since"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// StreamReport 管理接口返回的统计和流列表
+type StreamReport struct {
+	StreamStats
+	Streams []ActiveStream `json:"streams"`
+}
+
+type streamKey struct {
+	ip, user, media string
+}
+
+type streamCredential struct {
+	username string
+	expires  time.Time
+}
+
+// streamLimiter 记录每个 IP 和用户正在播放的流，新的流超出上限时拒绝
+// 同一 IP（或用户）重复请求同一首歌的切片只算一个流，多个设备在同一网络下同步收听不会互相挤占
+type streamLimiter struct {
+	mu          sync.Mutex
+	perIP       int
+	perUser     int
+	idle        time.Duration
+	streams     map[streamKey]*ActiveStream
+	rejected    uint64
+	credentials map[[sha256.Size]byte]streamCredential
+	// lastPrune 上次清理的时间，登记请求时每秒最多清理一次
+	lastPrune time.Time
+}
+
+func newStreamLimiter(cfg config.StreamLimitConfig) *streamLimiter {
+	idle := defaultStreamIdle
+	if cfg.IdleSeconds > 0 {
+		idle = time.Duration(cfg.IdleSeconds) * time.Second
+	}
+	return &streamLimiter{
+		perIP:       cfg.MaxPerIP,
+		perUser:     cfg.MaxPerUser,
+		idle:        idle,
+		streams:     make(map[streamKey]*ActiveStream),
+		credentials: make(map[[sha256.Size]byte]streamCredential),
+		lastPrune:   time.Now(),
+	}
+}
+
+// admit 登记一次媒体请求，属于已有的流时总是放行，新的流超出 IP 或用户的上限时返回 false
+func (l *streamLimiter) admit(ip, user, media string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.lastPrune) >= time.Second {
+		l.lastPrune = now
+		l.pruneLocked(now)
+	}
+	key := streamKey{ip: ip, user: user, media: media}
+	if s, ok := l.streams[key]; ok {
+		s.LastSeen = now
+		return true
+	}
+	// 按不同的歌曲计数，同一 IP 下不同用户收听同一首歌不额外占用
+	ipMedia := make(map[string]bool)
+	userMedia := make(map[string]bool)
+	for k := range l.streams {
+		if k.ip == ip {
+			ipMedia[k.media] = true
+		}
+		if user != "" && k.user == user {
+			userMedia[k.media] = true
+		}
+	}
+	overIP := l.perIP > 0 && !ipMedia[media] && len(ipMedia) >= l.perIP
+	overUser := l.perUser > 0 && user != "" && !userMedia[media] && len(userMedia) >= l.perUser
+	if overIP || overUser {
+		l.rejected++
+		return false
+	}
+	l.streams[key] = &ActiveStream{IP: ip, Username: user, Media: media, Since: now, LastSeen: now}
+	return true
+}
+
+// pruneLocked 移除空闲的流和过期的凭证缓存，调用方需持有锁
+func (l *streamLimiter) pruneLocked(now time.Time) {
+	for key, s := range l.streams {
+		if now.Sub(s.LastSeen) > l.idle {
+			delete(l.streams, key)
+		}
+	}
+	for key, cred := range l.credentials {
+		if now.After(cred.expires) {
+			delete(l.credentials, key)
+		}
+	}
+}
+
+// stats 返回当前统计
+func (l *streamLimiter) stats() StreamStats {
+	return l.report().StreamStats
+}
+
+// report 返回当前统计和正在播放的流，最早开始的在前
+func (l *streamLimiter) report() StreamReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pruneLocked(time.Now())
+	report := StreamReport{
+		StreamStats: StreamStats{Rejected: l.rejected, MaxPerIP: l.perIP, MaxPerUser: l.perUser},
+		Streams:     make([]ActiveStream, 0, len(l.streams)),
+	}
+	ips := make(map[string]bool)
+	users := make(map[string]bool)
+	for key, s := range l.streams {
+		report.Streams = append(report.Streams, *s)
+		ips[key.ip] = true
+		if key.user != "" {
+			users[key.user] = true
+		}
+	}
+	sort.Slice(report.Streams, func(i, j int) bool { return report.Streams[i].Since.Before(report.Streams[j].Since) })
+	report.Active, report.Clients, report.Users = len(report.Streams), len(ips), len(users)
+	return report
+}
+
+// cachedUser 返回缓存的凭证验证结果
+func (l *streamLimiter) cachedUser(key [sha256.Size]byte) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cred, ok := l.credentials[key]
+	if !ok || time.Now().After(cred.expires) {
+		return "", false
+	}
+	return cred.username, true
+}
+
+func (l *streamLimiter) cacheUser(key [sha256.Size]byte, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.credentials[key] = streamCredential{username: username, expires: time.Now().Add(streamCredentialTTL)}
+}
+
+// mediaKey 返回媒体请求所属的播放文件：HLS 的列表和切片在同一个目录下，以目录为准；直接放在根目录的文件以文件名为准
+func mediaKey(urlPath string) string {
+	rel := strings.TrimPrefix(path.Clean(urlPath), mediaPathPrefix)
+	if rel == urlPath || rel == "" {
+		return ""
+	}
+	if dir := path.Dir(rel); dir != "." {
+		return dir
+	}
+	return rel
+}
+
+// mediaUser 返回媒体请求的用户，结果保存在请求上下文中，供流数量限制和流量统计共用
+func (a *API) mediaUser(c *gin.Context) string {
+	if user, ok := c.Get(mediaUserKey); ok {
+		return user.(string)
+	}
+	user := a.streamUser(c.Request)
+	c.Set(mediaUserKey, user)
+	return user
+}
+
+// streamUser 返回媒体请求的用户：认证代理传来的身份、Basic Auth 凭证或浏览器带上的媒体 Cookie，无效或没有时为空
+func (a *API) streamUser(r *http.Request) string {
+	if username, _, ok := a.proxyAuth.identity(r); ok {
+		return username
+	}
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return a.mediaCookieUser(r)
+	}
+	key := sha256.Sum256([]byte(header))
+	if username, ok := a.streams.cachedUser(key); ok {
+		return username
+	}
+	var username string
+	if user, pass, ok := r.BasicAuth(); ok {
+		if dbUser, err := a.authenticate(user, pass); err == nil {
+			username = dbUser.Username
+		}
+	}
+	a.streams.cacheUser(key, username)
+	return username
+}
+
+// streamLimitMiddleware 媒体请求超出同时播放的流数量上限时返回 429
+func (a *API) streamLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		media := mediaKey(c.Request.URL.Path)
+		if media == "" {
+			c.Next()
+			return
+		}
+		if !a.streams.admit(c.ClientIP(), a.mediaUser(c), media) {
+			c.Header("Retry-After", strconv.Itoa(int(a.streams.idle.Seconds())))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many simultaneous streams"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// handleGetStreams 返回正在播放的流和限制的统计
+func (a *API) handleGetStreams(c *gin.Context) {
+	c.JSON(http.StatusOK, a.streams.report())
+}
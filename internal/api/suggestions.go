@@ -0,0 +1,35 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleApproveSuggestion 一键确认 QUEUE_LOW 推荐的歌曲，和点歌一样受额度和规则限制
+func (a *API) handleApproveSuggestion(c *gin.Context) {
+	var payload SongIDPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "songId is required"})
+		return
+	}
+	username := c.GetString("username")
+	if a.budgetFor(username).RequestsRemaining == 0 {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many pending requests, wait for your songs to play", "budget": a.budgetFor(username)})
+		return
+	}
+	if err := a.state.ApproveSuggestion(payload.SongID, actorFrom(c)); err != nil {
+		respondStateError(c, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"budget": a.budgetFor(username)})
+}
+
+// handleDismissSuggestions 撤下当前的推荐，待播歌曲补足之前不再推荐
+func (a *API) handleDismissSuggestions(c *gin.Context) {
+	if err := a.state.DismissSuggestions(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
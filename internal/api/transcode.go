@@ -0,0 +1,217 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yeeeck/sync-jukebox/internal/config"
+)
+
+// 转码参数的默认值，与之前写死的参数一致
+const (
+	defaultCodec          = "aac"
+	defaultBitrateKbps    = 320
+	defaultSegmentSeconds = 10
+	defaultTimeoutMinutes = 30
+)
+
+// supportedCodecs 可选的音频编码器，值为是否需要 fMP4 切片（MPEG-TS 不支持 Opus）
+var supportedCodecs = map[string]bool{
+	"aac":        false,
+	"libfdk_aac": false,
+	"libmp3lame": false,
+	"libopus":    true,
+}
+
+// transcodeSettings 补齐默认值并检查配置是否合理
+func transcodeSettings(cfg config.TranscodeConfig) (config.TranscodeConfig, error) {
+	if cfg.Codec == "" {
+		cfg.Codec = defaultCodec
+	}
+	if _, ok := supportedCodecs[cfg.Codec]; !ok {
+		return cfg, fmt.Errorf("unsupported transcode codec %q", cfg.Codec)
+	}
+	if len(cfg.BitratesKbps) == 0 {
+		cfg.BitratesKbps = []int{defaultBitrateKbps}
+	}
+	for _, kbps := range cfg.BitratesKbps {
+		if kbps < 8 || kbps > 640 {
+			return cfg, fmt.Errorf("transcode bitrate %dk must be between 8k and 640k", kbps)
+		}
+	}
+	if cfg.SegmentSeconds == 0 {
+		cfg.SegmentSeconds = defaultSegmentSeconds
+	}
+	if cfg.SegmentSeconds < 1 || cfg.SegmentSeconds > 60 {
+		return cfg, errors.New("transcode segmentSeconds must be between 1 and 60")
+	}
+	if cfg.Threads < 0 {
+		return cfg, errors.New("transcode threads must not be negative")
+	}
+	if cfg.TimeoutMinutes == 0 {
+		cfg.TimeoutMinutes = defaultTimeoutMinutes
+	}
+	if cfg.TimeoutMinutes < 0 {
+		return cfg, errors.New("transcode timeoutMinutes must not be negative")
+	}
+	return cfg, nil
+}
+
+// CheckFFmpeg 启动时检查 ffmpeg 是否支持配置的编码器和硬件加速
+// 找不到 ffmpeg 时只打印警告，上传的文件不转码，直接提供原始文件
+func CheckFFmpeg(cfg config.TranscodeConfig) error {
+	cfg, err := transcodeSettings(cfg)
+	if err != nil {
+		return err
+	}
+	if !ffmpegAvailable() {
+		log.Printf("Warning: ffmpeg or ffprobe not found in PATH, serving MP3/FLAC/Ogg uploads without transcoding")
+		return nil
+	}
+	encoders, err := exec.Command("ffmpeg", "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return fmt.Errorf("failed to list ffmpeg encoders: %w", err)
+	}
+	if !listsName(encoders, cfg.Codec) {
+		return fmt.Errorf("ffmpeg was built without the %s encoder", cfg.Codec)
+	}
+	if cfg.HWAccel != "" {
+		hwaccels, err := exec.Command("ffmpeg", "-hide_banner", "-hwaccels").Output()
+		if err != nil {
+			return fmt.Errorf("failed to list ffmpeg hwaccels: %w", err)
+		}
+		if !listsName(hwaccels, cfg.HWAccel) {
+			return fmt.Errorf("ffmpeg does not support hwaccel %s", cfg.HWAccel)
+		}
+	}
+	log.Printf("Transcoding with %s at %v kbps, %ds segments", cfg.Codec, cfg.BitratesKbps, cfg.SegmentSeconds)
+	return nil
+}
+
+// listsName 判断 ffmpeg 的列表输出中是否有某个名字，编码器列表每行为 "A..... aac  AAC (Advanced Audio Coding)"
+func listsName(output []byte, name string) bool {
+	pattern := regexp.MustCompile(`(?m)(^|\s)` + regexp.QuoteMeta(name) + `(\s|$)`)
+	return pattern.Match(output)
+}
+
+// transcode 转换 HLS 并提取封面，ffmpeg 的输出记录在任务中，进度更新到任务上
+func (a *API) transcode(ctx context.Context, job *Job, inputFile, outputFile string) error {
+	progress := &progressParser{onTime: func(done time.Duration) { a.jobs.SetProgress(job, done) }}
+	if err := a.convertToHLS(ctx, job.output, progress, inputFile, outputFile); err != nil {
+		return err
+	}
+	// 封面可有可无，没有内嵌图片时忽略
+	extractArtwork(ctx, inputFile, filepath.Dir(outputFile))
+	return nil
+}
+
+// convertToHLS 按配置把音频转换为 HLS，outputFile 为索引文件路径（<dir>/index.m3u8）
+// 只有一个码率时直接输出媒体索引；多个码率时 outputFile 为主索引，各码率的索引和切片放在同一目录
+// ctx 结束时终止 ffmpeg，progress 接收 -progress 输出的 key=value 行
+func (a *API) convertToHLS(ctx context.Context, stderr, progress io.Writer, inputFile, outputFile string) error {
+	cfg := a.transcodeCfg
+	args := []string{"-nostats", "-progress", "pipe:1"}
+	if cfg.HWAccel != "" && hasVideoStream(ctx, inputFile) {
+		args = append(args, "-hwaccel", cfg.HWAccel)
+	}
+	args = append(args, "-i", inputFile)
+	if cfg.Threads > 0 {
+		args = append(args, "-threads", strconv.Itoa(cfg.Threads))
+	}
+	dir := filepath.Dir(outputFile)
+	segmentExt := ".ts"
+	if supportedCodecs[cfg.Codec] {
+		segmentExt = ".m4s"
+		args = append(args, "-hls_segment_type", "fmp4")
+	}
+	// -vn: 不处理视频流；-hls_list_size 0: 索引包含所有切片
+	args = append(args, "-vn", "-c:a", cfg.Codec,
+		"-hls_time", strconv.Itoa(cfg.SegmentSeconds),
+		"-hls_list_size", "0",
+	)
+	if len(cfg.BitratesKbps) == 1 {
+		args = append(args, "-b:a", strconv.Itoa(cfg.BitratesKbps[0])+"k", "-f", "hls", outputFile)
+	} else {
+		streams := make([]string, len(cfg.BitratesKbps))
+		for i, kbps := range cfg.BitratesKbps {
+			args = append(args, "-map", "0:a:0", fmt.Sprintf("-b:a:%d", i), strconv.Itoa(kbps)+"k")
+			streams[i] = fmt.Sprintf("a:%d", i)
+		}
+		if segmentExt == ".m4s" {
+			args = append(args, "-hls_fmp4_init_filename", "init_%v.mp4")
+		}
+		// 切片与索引平铺在歌曲目录中，导出和联邦复制只需处理一层目录
+		args = append(args,
+			"-var_stream_map", strings.Join(streams, " "),
+			"-master_pl_name", filepath.Base(outputFile),
+			"-hls_segment_filename", filepath.Join(dir, "stream_%v_%03d"+segmentExt),
+			"-f", "hls", filepath.Join(dir, "stream_%v.m3u8"),
+		)
+	}
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdout = progress
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
+
+// progressParser 解析 ffmpeg -progress 输出中已处理的时长，例如 "out_time_us=43000000"
+// 旧版本的 out_time_ms 实际单位也是微秒
+type progressParser struct {
+	onTime  func(time.Duration)
+	partial []byte
+}
+
+func (p *progressParser) Write(b []byte) (int, error) {
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		line := strings.TrimSpace(string(p.partial[:i]))
+		p.partial = p.partial[i+1:]
+		key, value, _ := strings.Cut(line, "=")
+		if key != "out_time_us" && key != "out_time_ms" {
+			continue
+		}
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+			p.onTime(time.Duration(us) * time.Microsecond)
+		}
+	}
+}
+
+// hasVideoStream 判断输入是否带有真正的视频流，内嵌封面不算
+func hasVideoStream(ctx context.Context, input string) bool {
+	out, err := exec.CommandContext(ctx, "ffprobe", "-v", "quiet", "-print_format", "json", "-show_streams", input).Output()
+	if err != nil {
+		return false
+	}
+	var probe struct {
+		Streams []struct {
+			CodecType   string `json:"codec_type"`
+			Disposition struct {
+				AttachedPic int `json:"attached_pic"`
+			} `json:"disposition"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(out), &probe); err != nil {
+		return false
+	}
+	for _, s := range probe.Streams {
+		if s.CodecType == "video" && s.Disposition.AttachedPic == 0 {
+			return true
+		}
+	}
+	return false
+}
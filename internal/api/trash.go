@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultTrashRetention 未配置时歌曲在回收站保留的时间
+	defaultTrashRetention = 7 * 24 * time.Hour
+	// trashPurgeInterval 检查回收站中过期歌曲的间隔
+	trashPurgeInterval = time.Hour
+)
+
+func (a *API) trashRetention() time.Duration {
+	if hours := a.libraryCfg.TrashRetentionHours; hours > 0 {
+		return time.Duration(hours) * time.Hour
+	}
+	return defaultTrashRetention
+}
+
+// handleGetTrash 返回回收站中的歌曲及其永久删除时间
+func (a *API) handleGetTrash(c *gin.Context) {
+	songs, err := a.db.GetTrashedSongs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get trash"})
+		return
+	}
+	trashed := make([]TrashedSong, 0, len(songs))
+	for _, song := range songs {
+		deletedAt := song.DeletedAt.Time
+		trashed = append(trashed, TrashedSong{Song: song, DeletedAt: deletedAt, PurgeAt: deletedAt.Add(a.trashRetention())})
+	}
+	c.JSON(http.StatusOK, trashed)
+}
+
+// handleLibraryRestore 把歌曲从回收站恢复到曲库，不会自动加回播放列表
+// 与删除一样，普通用户只能恢复自己上传的歌曲
+func (a *API) handleLibraryRestore(c *gin.Context) {
+	var payload SongIDPayload
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.SongID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "songId is required"})
+		return
+	}
+	trashed, err := a.db.GetTrashedSong(payload.SongID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Song is not in the trash"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore song"})
+		return
+	}
+	if !canModifySong(c, trashed) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only change songs you uploaded"})
+		return
+	}
+	if err := a.db.RestoreSong(payload.SongID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Song is not in the trash"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore song"})
+		return
+	}
+	song, err := a.db.GetSong(payload.SongID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get song"})
+		return
+	}
+	log.Printf("Action: Restored song %s from trash.", payload.SongID)
+	c.JSON(http.StatusOK, song)
+}
+
+// purgeTrashLoop 定期永久删除超过保留期的歌曲
+func (a *API) purgeTrashLoop() {
+	a.purgeExpiredTrash()
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		a.purgeExpiredTrash()
+	}
+}
+
+func (a *API) purgeExpiredTrash() {
+	songs, err := a.db.GetTrashedSongsBefore(time.Now().Add(-a.trashRetention()))
+	if err != nil {
+		log.Printf("Failed to load expired trash: %v", err)
+		return
+	}
+	for i := range songs {
+		if err := a.purgeSong(&songs[i]); err != nil {
+			log.Printf("Failed to purge song %s: %v", songs[i].ID, err)
+		}
+	}
+	if len(songs) > 0 {
+		log.Printf("Purged %d songs from trash", len(songs))
+	}
+}
+
+// purgeSong 永久删除歌曲，媒体目录没有其他歌曲共享时一并删除
+func (a *API) purgeSong(song *db.Song) error {
+	if err := a.db.PurgeSong(song.ID); err != nil {
+		return err
+	}
+	// 引用远程实例的歌曲没有本地文件，原地引用的歌曲只删除 HLS 缓存
+	if song.StreamURL != "" {
+		return nil
+	}
+	// 数据库存的是 "content/ab/<hash>/index.m3u8" 或旧的 "uuid/index.m3u8"，删除的是所在目录
+	a.releaseMedia(song.FilePath)
+	return nil
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/state"
+	"gorm.io/gorm"
+)
+
+// 触发地址按 IP 限流，防止猜测令牌；智能按钮连按几次也不会超出
+const (
+	triggerPerMinute = 30
+	triggerBurst     = 10
+)
+
+// 触发地址支持的动作，/api/trigger/<动作>
+const (
+	triggerPlay   = "play"
+	triggerPause  = "pause"
+	triggerToggle = "toggle"
+	triggerNext   = "next"
+	triggerQueue  = "queue"
+)
+
+// triggerToken 从 token 查询参数或 Bearer 认证头中读取触发令牌，无代码平台通常只能把令牌写在地址里
+func triggerToken(c *gin.Context) string {
+	if token := c.Query("token"); token != "" {
+		return token
+	}
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// handleTrigger 以静态令牌执行一个动作，GET 和 POST 均可，方便 IFTTT、Zapier 和智能按钮调用
+// 令牌绑定用户时按该用户的角色和额度执行，否则代表服务器管理员
+func (a *API) handleTrigger(c *gin.Context) {
+	tokenStr := triggerToken(c)
+	if tokenStr == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "token is required"})
+		return
+	}
+	token, err := a.db.GetActiveToken(tokenStr)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or disabled trigger token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check trigger token"})
+		return
+	}
+	// GET 请求不经过只读和维护模式的拦截，这里单独检查
+	if a.readOnly {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This instance is a read-only replica", "code": "READ_ONLY"})
+		return
+	}
+	if a.state.InMaintenance() {
+		c.Header("Retry-After", maintenanceRetryAfter)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "The jukebox is in maintenance mode, try again later"})
+		return
+	}
+	actor := state.Actor{IsAdmin: true}
+	if token.Username != "" {
+		user, err := a.db.GetUserByUsername(token.Username)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or disabled trigger token"})
+			return
+		}
+		actor = state.Actor{Username: user.Username, IsAdmin: user.Role == db.RoleAdmin}
+	}
+
+	action := c.Param("action")
+	if action != triggerQueue {
+		if source := a.state.Mirroring(); source != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Playback is mirrored from " + source, "code": "MIRRORING"})
+			return
+		}
+	}
+	switch action {
+	case triggerPlay:
+		a.state.Play()
+	case triggerPause:
+		a.state.Pause()
+	case triggerToggle:
+		if a.state.Snapshot().IsPlaying {
+			a.state.Pause()
+		} else {
+			a.state.Play()
+		}
+	case triggerNext:
+		if actor.Username != "" && !a.budget.TryConsumeSkip(actor.Username) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Skip limit reached, try again later", "budget": a.budgetFor(actor.Username)})
+			return
+		}
+		a.state.NextSong(actor.Username, "")
+	case triggerQueue:
+		a.triggerQueue(c, token, actor)
+		return
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown trigger, use play, pause, toggle, next or queue"})
+		return
+	}
+	log.Printf("Action: trigger %s by token %s", action, tokenLabel(token))
+	c.Status(http.StatusAccepted)
+}
+
+// triggerQueue 把歌单加入播放列表，歌单取 playlist 参数，没有时取令牌的默认歌单
+func (a *API) triggerQueue(c *gin.Context, token *db.Token, actor state.Actor) {
+	playlistID := token.PlaylistID
+	if raw := c.Query("playlist"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "playlist must be a playlist ID"})
+			return
+		}
+		playlistID = uint(id)
+	}
+	if playlistID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "playlist is required, the token has no default playlist"})
+		return
+	}
+	playlist, err := a.db.GetSavedPlaylist(playlistID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Playlist not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get playlist"})
+		return
+	}
+	songIDs, err := a.savedPlaylistSongIDs(playlist)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get library"})
+		return
+	}
+	if actor.Username != "" {
+		if remaining := a.budgetFor(actor.Username).RequestsRemaining; remaining >= 0 && remaining < len(songIDs) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Not enough request budget for all songs", "budget": a.budgetFor(actor.Username)})
+			return
+		}
+	}
+	added := 0
+	if len(songIDs) > 0 {
+		added, err = a.state.AddManyToPlaylist(songIDs, actor)
+		if err != nil {
+			respondStateError(c, err, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	log.Printf("Action: trigger queued playlist %d (%d songs) by token %s", playlistID, added, tokenLabel(token))
+	c.JSON(http.StatusOK, gin.H{"added": added})
+}
+
+// tokenLabel 日志中显示的令牌：前 8 位和绑定的用户，不记录完整令牌
+func tokenLabel(token *db.Token) string {
+	label := token.Token
+	if len(label) > 8 {
+		label = label[:8] + "…"
+	}
+	if token.Username != "" {
+		label += " (" + token.Username + ")"
+	}
+	return label
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"io"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EventUploadProgress 上传文件时已接收字节数的 WebSocket 事件
+const EventUploadProgress = "UPLOAD_PROGRESS"
+
+// maxUploadIDLength 客户端给出的上传标识的长度上限
+const maxUploadIDLength = 64
+
+// UploadProgress 上传进度，TotalBytes 为 -1 表示请求没有给出长度（分块传输）
+type UploadProgress struct {
+	UploadID      string `json:"uploadId"`
+	Username      string `json:"username"`
+	ReceivedBytes int64  `json:"receivedBytes"`
+	TotalBytes    int64  `json:"totalBytes"`
+}
+
+// trackUploadProgress 在解析 multipart 表单之前包装请求体，边接收边推送 UPLOAD_PROGRESS 事件
+// 客户端可以用 ?uploadId= 给出自己的标识，没有或不合法时使用 fallback，返回实际使用的标识
+func (a *API) trackUploadProgress(c *gin.Context, fallback string) string {
+	uploadID := c.Query("uploadId")
+	if !validUploadID(uploadID) {
+		uploadID = fallback
+	}
+	c.Request.Body = &uploadProgressReader{
+		ReadCloser: c.Request.Body,
+		a:          a,
+		progress: UploadProgress{
+			UploadID:   uploadID,
+			Username:   c.GetString("username"),
+			TotalBytes: c.Request.ContentLength,
+		},
+	}
+	return uploadID
+}
+
+func validUploadID(id string) bool {
+	if id == "" || len(id) > maxUploadIDLength {
+		return false
+	}
+	for _, r := range id {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// uploadProgressReader 统计已读取的请求体字节数，按固定间隔推送进度，读完时再推送一次
+type uploadProgressReader struct {
+	io.ReadCloser
+	a        *API
+	progress UploadProgress
+	last     time.Time
+	done     bool
+}
+
+func (r *uploadProgressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.progress.ReceivedBytes += int64(n)
+	switch {
+	case err == io.EOF && !r.done:
+		r.done = true
+		r.report()
+	case err == nil && time.Since(r.last) >= progressInterval:
+		r.report()
+	}
+	return n, err
+}
+
+func (r *uploadProgressReader) report() {
+	r.last = time.Now()
+	r.a.hub.BroadcastEvent(EventUploadProgress, r.progress)
+}
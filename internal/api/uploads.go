@@ -0,0 +1,68 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"gorm.io/gorm"
+)
+
+// canModifySong 判断当前用户能否修改、删除或恢复歌曲：管理员可以处理任何歌曲，其他用户只能处理自己上传的
+func canModifySong(c *gin.Context, song *db.Song) bool {
+	if c.GetString("role") == db.RoleAdmin {
+		return true
+	}
+	username := c.GetString("username")
+	return username != "" && song.UploadedBy == username
+}
+
+// songOwnerMiddleware 按路径参数 id 查找歌曲，只允许 canModifySong 的用户继续
+func (a *API) songOwnerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		song, err := a.db.GetSong(c.Param("id"))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Song not found"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get song"})
+			return
+		}
+		if !canModifySong(c, song) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "You can only change songs you uploaded"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// checkSongsOwnership 检查当前用户能否修改所有给定的歌曲，不能时写入错误响应并返回 false
+func (a *API) checkSongsOwnership(c *gin.Context, songIDs []string) bool {
+	if c.GetString("role") == db.RoleAdmin {
+		return true
+	}
+	songs, err := a.db.GetSongsByIDs(songIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get library"})
+		return false
+	}
+	for i := range songs {
+		if !canModifySong(c, &songs[i]) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only change songs you uploaded"})
+			return false
+		}
+	}
+	return true
+}
+
+// handleGetMyUploads 返回当前用户上传的歌曲，按标题排序
+func (a *API) handleGetMyUploads(c *gin.Context) {
+	songs, err := a.db.GetSongsUploadedBy(c.GetString("username"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get library"})
+		return
+	}
+	c.JSON(http.StatusOK, songs)
+}
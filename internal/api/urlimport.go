@@ -0,0 +1,194 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// URL 导入相关的 WebSocket 事件
+const (
+	EventURLImportProgress = "URL_IMPORT_PROGRESS"
+	EventURLImportDone     = "URL_IMPORT_DONE"
+	EventURLImportFailed   = "URL_IMPORT_FAILED"
+)
+
+const (
+	// defaultMaxDownloadMB 未配置时单个文件的大小上限
+	defaultMaxDownloadMB = 500
+	// urlImportTimeout 整个下载的超时时间
+	urlImportTimeout = 30 * time.Minute
+	// progressInterval 下载进度事件的最小间隔
+	progressInterval = time.Second
+)
+
+// audioExtensions 服务器没有给出明确的音频类型时，按扩展名判断是否为音频
+var audioExtensions = map[string]bool{
+	".mp3": true, ".flac": true, ".ogg": true, ".oga": true, ".opus": true, ".m4a": true,
+	".aac": true, ".wav": true, ".aiff": true, ".aif": true, ".wma": true, ".mp4": true, ".webm": true,
+}
+
+var urlImportClient = &http.Client{Timeout: urlImportTimeout}
+
+// URLImportProgress 下载进度，TotalBytes 为 -1 表示服务器没有给出大小
+type URLImportProgress struct {
+	ImportID      string `json:"importId"`
+	URL           string `json:"url"`
+	ReceivedBytes int64  `json:"receivedBytes"`
+	TotalBytes    int64  `json:"totalBytes"`
+}
+
+// handleImportFileURL 从直链下载音频文件并加入曲库
+// 先同步检查状态码、类型和大小，通过后返回 202 和 importId，
+// 之后的下载进度和结果通过 URL_IMPORT_* 事件推送
+func (a *API) handleImportFileURL(c *gin.Context) {
+	var payload ImportFileURLPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url is required"})
+		return
+	}
+	u, err := url.Parse(payload.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url must be an http(s) link to an audio file"})
+		return
+	}
+
+	resp, err := urlImportClient.Get(u.String())
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch URL: " + err.Error()})
+		return
+	}
+	filename := downloadFilename(resp, u)
+	if err := a.checkDownload(resp, filename); err != nil {
+		resp.Body.Close()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	songUUID, _ := uuid.NewV4()
+	songID := songUUID.String()
+	go a.downloadAndIngest(songID, u.String(), filename, resp, c.GetString("username"))
+	c.JSON(http.StatusAccepted, gin.H{"importId": songID})
+}
+
+// checkDownload 检查响应是否为大小合适的音频文件
+func (a *API) checkDownload(resp *http.Response, filename string) error {
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("URL returned %s", resp.Status)
+	}
+	if resp.ContentLength > a.maxDownloadBytes() {
+		return fmt.Errorf("file is larger than %d MB", a.maxDownloadBytes()>>20)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case strings.HasPrefix(mediaType, "audio/"), mediaType == "application/ogg", mediaType == "video/mp4", mediaType == "video/webm":
+		return nil
+	case mediaType == "" || mediaType == "application/octet-stream" || mediaType == "binary/octet-stream":
+		// NAS 和对象存储经常不给具体类型，只能看扩展名
+		if audioExtensions[strings.ToLower(filepath.Ext(filename))] {
+			return nil
+		}
+	}
+	return fmt.Errorf("URL does not point to an audio file (Content-Type %q)", mediaType)
+}
+
+func (a *API) maxDownloadBytes() int64 {
+	mb := a.ingestCfg.MaxDownloadMB
+	if mb <= 0 {
+		mb = defaultMaxDownloadMB
+	}
+	return int64(mb) << 20
+}
+
+// downloadAndIngest 在后台完成下载并加入曲库
+func (a *API) downloadAndIngest(songID, rawURL, filename string, resp *http.Response, username string) {
+	defer resp.Body.Close()
+	tempFilePath := filepath.Join(a.mediaDir, fmt.Sprintf("temp_%s%s", songID, filepath.Ext(filename)))
+	defer os.Remove(tempFilePath)
+
+	fail := func(err error) {
+		log.Printf("URL import of %s failed: %v", rawURL, err)
+		a.hub.BroadcastEvent(EventURLImportFailed, gin.H{"importId": songID, "url": rawURL, "error": err.Error()})
+	}
+	f, err := os.Create(tempFilePath)
+	if err != nil {
+		fail(errors.New("Error saving temporary file"))
+		return
+	}
+	progress := &progressWriter{
+		a:        a,
+		progress: URLImportProgress{ImportID: songID, URL: rawURL, TotalBytes: resp.ContentLength},
+	}
+	// 多读 1 字节，用来发现没有 Content-Length 或谎报大小的超大文件
+	limit := a.maxDownloadBytes()
+	n, err := io.Copy(f, io.TeeReader(io.LimitReader(resp.Body, limit+1), progress))
+	f.Close()
+	if err != nil {
+		fail(fmt.Errorf("download interrupted: %w", err))
+		return
+	}
+	if n > limit {
+		fail(fmt.Errorf("file is larger than %d MB", limit>>20))
+		return
+	}
+	progress.report()
+
+	song, err := a.ingestFile(songID, songID, tempFilePath, uploadOrigin{
+		Filename:   filename,
+		Source:     db.SourceURL,
+		SourceURL:  rawURL,
+		UploadedBy: username,
+	})
+	if err != nil {
+		fail(err)
+		return
+	}
+	a.hub.BroadcastEvent(EventURLImportDone, gin.H{"importId": songID, "url": rawURL, "song": song})
+}
+
+// progressWriter 统计已下载的字节数，按固定间隔推送进度事件
+type progressWriter struct {
+	a        *API
+	progress URLImportProgress
+	last     time.Time
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.progress.ReceivedBytes += int64(len(p))
+	if time.Since(w.last) >= progressInterval {
+		w.report()
+	}
+	return len(p), nil
+}
+
+func (w *progressWriter) report() {
+	w.last = time.Now()
+	w.a.hub.BroadcastEvent(EventURLImportProgress, w.progress)
+}
+
+// downloadFilename 优先使用 Content-Disposition 中的文件名，否则取 URL 路径的最后一段
+func downloadFilename(resp *http.Response, u *url.URL) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		return normalizeFilename(params["filename"])
+	}
+	if name := path.Base(u.Path); name != "/" && name != "." {
+		if unescaped, err := url.PathUnescape(name); err == nil {
+			return normalizeFilename(unescaped)
+		}
+		return normalizeFilename(name)
+	}
+	return "download"
+}
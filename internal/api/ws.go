@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/websocket"
+)
+
+// wsTicketTTL WebSocket 票据的有效期，客户端拿到后应立即连接
+const wsTicketTTL = 30 * time.Second
+
+// WSTicket 建立 WebSocket 连接用的一次性票据，以 ?ticket= 传给 /ws
+type WSTicket struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// 客户端上行消息类型
+const (
+	wsTypeVote           = "VOTE"
+	wsTypeRegisterDevice = "REGISTER_DEVICE"
+	wsTypeSoundboard     = "SOUNDBOARD"
+)
+
+// wsMessage 是客户端通过 WebSocket 发送的消息
+type wsMessage struct {
+	Type     string `json:"type"`
+	SongID   string `json:"songId,omitempty"`
+	DeviceID string `json:"deviceId,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Role     string `json:"role,omitempty"`
+	ClipID   uint   `json:"clipId,omitempty"`
+}
+
+// handleWSMessage 分发客户端上行消息
+func (a *API) handleWSMessage(client *websocket.Client, raw []byte) {
+	var msg wsMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return // 忽略无法解析的消息（例如心跳）
+	}
+	// 访客只能投票；派对结束后访客的连接仍然保留，但不再接受其操作
+	if db.IsGuestUsername(client.Username()) && (msg.Type != wsTypeVote || !a.guests.Active(client.Username())) {
+		return
+	}
+	switch msg.Type {
+	case wsTypeVote:
+		if a.readOnly || a.state.InMaintenance() {
+			return
+		}
+		if err := a.state.Vote(client.Username(), msg.SongID); err != nil {
+			log.Printf("WS vote from %q rejected: %v", client.Username(), err)
+		}
+	case wsTypeRegisterDevice:
+		if err := a.state.RegisterDevice(client.ID(), msg.DeviceID, msg.Name, msg.Role, client.Username()); err != nil {
+			log.Printf("WS device registration rejected: %v", err)
+		}
+	case wsTypeSoundboard:
+		if a.readOnly || a.state.InMaintenance() {
+			return
+		}
+		a.playSoundClipWS(client.Username(), msg.ClipID)
+	}
+}
+
+// handleWSDisconnect 连接断开时注销其登记的设备
+func (a *API) handleWSDisconnect(client *websocket.Client) {
+	a.state.DisconnectDevices(client.ID())
+}
+
+// handleWSTicket 为当前用户签发 WebSocket 票据，浏览器用它代替放在地址中的凭证
+func (a *API) handleWSTicket(c *gin.Context) {
+	ticket, expiresAt, err := a.db.CreateWSTicket(c.GetString("username"), wsTicketTTL)
+	if err != nil {
+		log.Printf("Error creating websocket ticket: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create websocket ticket"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, WSTicket{Ticket: ticket, ExpiresAt: expiresAt})
+}
+
+// wsUsername 返回 WebSocket 连接的用户：认证代理的身份、?ticket= 票据或 Authorization 头，都没有时为匿名
+func (a *API) wsUsername(r *http.Request) string {
+	if dbUser, err := a.proxyUser(r); err == nil && dbUser != nil {
+		return dbUser.Username
+	}
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		username, err := a.db.RedeemWSTicket(ticket)
+		if err != nil {
+			return ""
+		}
+		return username
+	}
+	if user, pass, ok := r.BasicAuth(); ok {
+		if dbUser, err := a.authenticate(user, pass); err == nil {
+			return dbUser.Username
+		}
+	}
+	return ""
+}
@@ -0,0 +1,110 @@
+// Package audiotag 不依赖 ffmpeg 读取 MP3、FLAC 和 Ogg（Vorbis、Opus）文件的时长、标签和封面
+// 用于没有安装 ffmpeg 的环境，此时上传的文件不转码，直接提供原始文件
+package audiotag
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// 支持的格式，同时用作保存原始文件时的扩展名
+const (
+	FormatMP3  = "mp3"
+	FormatFLAC = "flac"
+	FormatOgg  = "ogg"
+)
+
+// ErrUnsupported 不是可以识别的 MP3、FLAC 或 Ogg 文件
+var ErrUnsupported = errors.New("unsupported audio format")
+
+// maxBlockSize 单个标签块或注释包的上限，防止损坏的文件让我们分配过多内存
+const maxBlockSize = 16 << 20
+
+// Info 从文件中读出的信息
+type Info struct {
+	Format     string
+	DurationMs int
+	// Codec 音频编码（mp3、flac、vorbis、opus），Channels 和 SampleRate 取自第一个帧头或识别包
+	Codec      string
+	SampleRate int
+	Channels   int
+	// BitrateKbps CBR 的 MP3 为帧头中的码率，其他情况按文件大小和时长估算的平均码率
+	BitrateKbps int
+	Size        int64
+	// Tags 标签名统一为小写，ID3 帧映射为 Vorbis 注释的名称（title、artist、album_artist 等）
+	// 同一标签出现多次时用 "; " 连接
+	Tags map[string]string
+	// Picture 内嵌封面，优先使用封面类型的图片，没有时为空
+	Picture     []byte
+	PictureMIME string
+
+	pictureIsCover bool
+}
+
+// Read 按文件头识别格式并读取信息
+func Read(path string) (*Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, ErrUnsupported
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var info *Info
+	switch {
+	case string(header) == "fLaC":
+		info, err = readFLAC(f)
+	case string(header) == "OggS":
+		info, err = readOgg(f, stat.Size())
+	case string(header[:3]) == "ID3", header[0] == 0xFF && header[1]&0xE0 == 0xE0:
+		info, err = readMP3(f, stat.Size())
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	info.Size = stat.Size()
+	if info.BitrateKbps == 0 && info.DurationMs > 0 {
+		// 字节数 * 8 / 毫秒 = kbps
+		info.BitrateKbps = int(info.Size * 8 / int64(info.DurationMs))
+	}
+	return info, nil
+}
+
+// addTag 记录一个标签，重复出现的值追加在后面
+func (info *Info) addTag(key, value string) {
+	key = strings.ToLower(strings.TrimSpace(strings.Trim(key, "\x00")))
+	value = strings.TrimSpace(strings.Trim(value, "\x00"))
+	if key == "" || value == "" {
+		return
+	}
+	if info.Tags == nil {
+		info.Tags = make(map[string]string)
+	}
+	if old, ok := info.Tags[key]; ok {
+		value = old + "; " + value
+	}
+	info.Tags[key] = value
+}
+
+// setPicture 记录内嵌图片，封面（类型 3）优先于其他类型
+func (info *Info) setPicture(pictureType byte, mime string, data []byte) {
+	if len(data) == 0 || info.pictureIsCover || (info.Picture != nil && pictureType != 3) {
+		return
+	}
+	info.Picture = data
+	info.PictureMIME = mime
+	info.pictureIsCover = pictureType == 3
+}
@@ -0,0 +1,129 @@
+package audiotag
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+// FLAC 元数据块类型
+const (
+	flacStreamInfo    = 0
+	flacVorbisComment = 4
+	flacPicture       = 6
+)
+
+var errTruncated = errors.New("truncated metadata block")
+
+// readFLAC 读取 STREAMINFO 中的采样率和总采样数，以及 Vorbis 注释和图片块
+func readFLAC(r io.ReadSeeker) (*Info, error) {
+	if _, err := r.Seek(4, io.SeekStart); err != nil {
+		return nil, err
+	}
+	info := &Info{Format: FormatFLAC, Codec: "flac"}
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7F
+		size := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		switch blockType {
+		case flacStreamInfo, flacVorbisComment, flacPicture:
+			block := make([]byte, size)
+			if _, err := io.ReadFull(r, block); err != nil {
+				return nil, err
+			}
+			switch blockType {
+			case flacStreamInfo:
+				if len(block) < 18 {
+					return nil, errTruncated
+				}
+				sampleRate := int64(block[10])<<12 | int64(block[11])<<4 | int64(block[12])>>4
+				samples := int64(block[13]&0x0F)<<32 | int64(binary.BigEndian.Uint32(block[14:18]))
+				info.SampleRate = int(sampleRate)
+				info.Channels = int(block[12]>>1&0x07) + 1
+				if sampleRate > 0 {
+					info.DurationMs = int(samples * 1000 / sampleRate)
+				}
+			case flacVorbisComment:
+				parseVorbisComment(info, block)
+			case flacPicture:
+				parsePictureBlock(info, block)
+			}
+		default:
+			if _, err := r.Seek(size, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+		}
+		if last {
+			return info, nil
+		}
+	}
+}
+
+// parseVorbisComment 解析 Vorbis 注释：厂商字符串和若干 "KEY=value"，长度均为小端序
+// FLAC 的注释块和 Ogg 中去掉包头后的注释包格式相同，损坏时保留已经读到的部分
+func parseVorbisComment(info *Info, b []byte) {
+	_, b, ok := lengthPrefixed(b, binary.LittleEndian)
+	if !ok || len(b) < 4 {
+		return
+	}
+	count := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	for i := uint32(0); i < count; i++ {
+		var comment []byte
+		comment, b, ok = lengthPrefixed(b, binary.LittleEndian)
+		if !ok {
+			return
+		}
+		key, value, found := strings.Cut(string(comment), "=")
+		if !found {
+			continue
+		}
+		if strings.EqualFold(key, "METADATA_BLOCK_PICTURE") {
+			if data, err := base64.StdEncoding.DecodeString(value); err == nil {
+				parsePictureBlock(info, data)
+			}
+			continue
+		}
+		info.addTag(key, value)
+	}
+}
+
+// parsePictureBlock 解析 FLAC 图片块（Ogg 中以 base64 存在 METADATA_BLOCK_PICTURE 注释里），长度均为大端序
+func parsePictureBlock(info *Info, b []byte) {
+	if len(b) < 4 || binary.BigEndian.Uint32(b) > 0xFF {
+		return
+	}
+	pictureType := b[3]
+	mime, b, ok := lengthPrefixed(b[4:], binary.BigEndian)
+	if !ok {
+		return
+	}
+	if _, b, ok = lengthPrefixed(b, binary.BigEndian); !ok || len(b) < 16 {
+		return
+	}
+	// 跳过宽、高、色深和调色板颜色数
+	data, _, ok := lengthPrefixed(b[16:], binary.BigEndian)
+	if !ok {
+		return
+	}
+	info.setPicture(pictureType, string(mime), data)
+}
+
+// lengthPrefixed 读取 4 字节长度加内容，返回内容和剩余部分
+func lengthPrefixed(b []byte, order binary.ByteOrder) (value, rest []byte, ok bool) {
+	if len(b) < 4 {
+		return nil, b, false
+	}
+	n := order.Uint32(b)
+	b = b[4:]
+	if uint64(n) > uint64(len(b)) {
+		return nil, b, false
+	}
+	return b[:n], b[n:], true
+}
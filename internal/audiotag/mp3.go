@@ -0,0 +1,366 @@
+package audiotag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// id3Frames ID3v2 文本帧对应的标签名，与 ffprobe 和 Vorbis 注释一致，v2.2 使用三字符的帧名
+var id3Frames = map[string]string{
+	"TIT2": "title", "TT2": "title",
+	"TPE1": "artist", "TP1": "artist",
+	"TALB": "album", "TAL": "album",
+	"TPE2": "album_artist", "TP2": "album_artist",
+	"TCON": "genre", "TCO": "genre",
+	"TDRC": "date", "TYER": "date", "TYE": "date",
+	"TDOR": "originaldate", "TORY": "originaldate", "TOR": "originaldate",
+	"TCMP": "compilation", "TCP": "compilation",
+}
+
+// MPEG 音频帧头中的码率（kbps）和采样率表，下标来自帧头
+var (
+	mpegBitrates = map[[2]int][16]int{
+		{1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		{2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	}
+	mpegSampleRates = map[int][3]int{
+		1:  {44100, 48000, 32000},
+		2:  {22050, 24000, 16000},
+		25: {11025, 12000, 8000},
+	}
+)
+
+// mpegFrame 解析出的 MPEG 音频帧头
+type mpegFrame struct {
+	version    int // 1、2 或 25（MPEG 2.5）
+	layer      int
+	bitrate    int // bps
+	sampleRate int
+	samples    int // 每帧采样数
+	size       int // 整帧字节数
+	mono       bool
+}
+
+// readMP3 读取 ID3v2（没有时读 ID3v1）标签，时长优先使用 Xing/Info 或 VBRI 头中的帧数，否则按首帧码率估算
+func readMP3(r io.ReadSeeker, size int64) (*Info, error) {
+	info := &Info{Format: FormatMP3}
+	audioStart, err := readID3v2(r, info)
+	if err != nil {
+		return nil, err
+	}
+	audioEnd := size
+	if tail, ok := readID3v1(r, size); ok {
+		audioEnd -= 128
+		if len(info.Tags) == 0 {
+			for k, v := range tail {
+				info.addTag(k, v)
+			}
+		}
+	}
+
+	// 在标签之后的一段数据中找第一个有效的帧，要求紧接着还有一个帧头以排除误判
+	if _, err := r.Seek(audioStart, io.SeekStart); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 64<<10)
+	n, _ := io.ReadFull(r, buf)
+	buf = buf[:n]
+	for i := 0; i+4 <= len(buf); i++ {
+		frame, ok := parseFrameHeader(buf[i:])
+		if !ok {
+			continue
+		}
+		if next := i + frame.size; next+4 <= len(buf) {
+			if _, ok := parseFrameHeader(buf[next:]); !ok {
+				continue
+			}
+		}
+		info.Codec = fmt.Sprintf("mp%d", frame.layer)
+		info.SampleRate = frame.sampleRate
+		info.Channels = 2
+		if frame.mono {
+			info.Channels = 1
+		}
+		if frames := vbrFrames(buf[i:], frame); frames > 0 {
+			info.DurationMs = int(int64(frames) * int64(frame.samples) * 1000 / int64(frame.sampleRate))
+		} else {
+			info.DurationMs = int((audioEnd - audioStart - int64(i)) * 8 * 1000 / int64(frame.bitrate))
+			info.BitrateKbps = frame.bitrate / 1000
+		}
+		return info, nil
+	}
+	return nil, ErrUnsupported
+}
+
+// parseFrameHeader 解析 4 字节的 MPEG 音频帧头
+func parseFrameHeader(b []byte) (mpegFrame, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return mpegFrame{}, false
+	}
+	var f mpegFrame
+	switch (b[1] >> 3) & 3 {
+	case 0:
+		f.version = 25
+	case 2:
+		f.version = 2
+	case 3:
+		f.version = 1
+	default:
+		return f, false
+	}
+	f.layer = 4 - int((b[1]>>1)&3)
+	bitrateIndex, rateIndex := int(b[2]>>4), int((b[2]>>2)&3)
+	if f.layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return f, false
+	}
+	table := 1
+	if f.version != 1 {
+		table = 2
+	}
+	f.bitrate = mpegBitrates[[2]int{table, f.layer}][bitrateIndex] * 1000
+	f.sampleRate = mpegSampleRates[f.version][rateIndex]
+	padding := int((b[2] >> 1) & 1)
+	f.mono = b[3]>>6 == 3
+	switch {
+	case f.layer == 1:
+		f.samples = 384
+		f.size = (12*f.bitrate/f.sampleRate + padding) * 4
+	case f.layer == 3 && f.version != 1:
+		f.samples = 576
+		f.size = 72*f.bitrate/f.sampleRate + padding
+	default:
+		f.samples = 1152
+		f.size = 144*f.bitrate/f.sampleRate + padding
+	}
+	return f, f.size > 4
+}
+
+// vbrFrames 读取首帧中 Xing/Info 或 VBRI 头记录的总帧数，没有时返回 0
+func vbrFrames(b []byte, f mpegFrame) uint32 {
+	// Xing 头位于帧头和 side information 之后
+	offset := 4 + 32
+	switch {
+	case f.version == 1 && f.mono:
+		offset = 4 + 17
+	case f.version != 1 && f.mono:
+		offset = 4 + 9
+	case f.version != 1:
+		offset = 4 + 17
+	}
+	if len(b) >= offset+12 {
+		tag := string(b[offset : offset+4])
+		flags := binary.BigEndian.Uint32(b[offset+4:])
+		if (tag == "Xing" || tag == "Info") && flags&1 != 0 {
+			return binary.BigEndian.Uint32(b[offset+8:])
+		}
+	}
+	// VBRI 头固定在帧头之后 32 字节处
+	if len(b) >= 4+32+18 && string(b[36:40]) == "VBRI" {
+		return binary.BigEndian.Uint32(b[36+14:])
+	}
+	return 0
+}
+
+// readID3v2 读取文件开头的 ID3v2 标签，返回音频数据的起始位置，没有标签时为 0
+func readID3v2(r io.ReadSeeker, info *Info) (int64, error) {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+	if string(header[:3]) != "ID3" {
+		return 0, nil
+	}
+	version, flags := header[3], header[5]
+	size := int64(syncsafe(header[6:10]))
+	end := 10 + size
+	if flags&0x10 != 0 {
+		end += 10 // v2.4 的页脚
+	}
+	if size > maxBlockSize || version < 2 || version > 4 {
+		return end, nil
+	}
+	tag := make([]byte, size)
+	if _, err := io.ReadFull(r, tag); err != nil {
+		return 0, err
+	}
+	// v2.4 之前的 unsynchronisation 作用于整个标签
+	if flags&0x80 != 0 && version < 4 {
+		tag = bytes.ReplaceAll(tag, []byte{0xFF, 0x00}, []byte{0xFF})
+	}
+	if flags&0x40 != 0 && version >= 3 && len(tag) >= 4 {
+		ext := int(binary.BigEndian.Uint32(tag))
+		if version == 4 {
+			ext = int(syncsafe(tag[:4]))
+		} else {
+			ext += 4
+		}
+		if ext > len(tag) {
+			return end, nil
+		}
+		tag = tag[ext:]
+	}
+	parseID3Frames(info, tag, version)
+	return end, nil
+}
+
+// parseID3Frames 逐帧读取文本、TXXX 和图片帧，遇到填充或损坏的帧时停止
+func parseID3Frames(info *Info, tag []byte, version byte) {
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+	for len(tag) >= headerLen && tag[0] != 0 {
+		id := string(tag[:idLen])
+		var size int
+		switch version {
+		case 2:
+			size = int(tag[3])<<16 | int(tag[4])<<8 | int(tag[5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(tag[4:8]))
+		default:
+			size = int(syncsafe(tag[4:8]))
+		}
+		if size < 0 || headerLen+size > len(tag) {
+			return
+		}
+		data := tag[headerLen : headerLen+size]
+		skip := false
+		if version == 3 {
+			// 压缩或加密的帧
+			skip = tag[9]&0xC0 != 0
+		} else if version == 4 {
+			formatFlags := tag[9]
+			skip = formatFlags&0x0C != 0
+			if formatFlags&0x40 != 0 && len(data) >= 1 {
+				data = data[1:] // 分组标识
+			}
+			if formatFlags&0x01 != 0 && len(data) >= 4 {
+				data = data[4:] // 数据长度指示
+			}
+			if formatFlags&0x02 != 0 {
+				data = bytes.ReplaceAll(data, []byte{0xFF, 0x00}, []byte{0xFF})
+			}
+		}
+		tag = tag[headerLen+size:]
+		if skip || len(data) == 0 {
+			continue
+		}
+		switch {
+		case id == "TXXX" || id == "TXX":
+			if desc, value, ok := splitEncoded(data[0], data[1:]); ok {
+				info.addTag(decodeText(data[0], desc), decodeText(data[0], value))
+			}
+		case id == "APIC":
+			parseAPIC(info, data)
+		case id == "PIC" && len(data) >= 5:
+			mime := "image/" + strings.ToLower(string(data[1:4]))
+			if mime == "image/jpg" {
+				mime = "image/jpeg"
+			}
+			if _, picture, ok := splitEncoded(data[0], data[5:]); ok {
+				info.setPicture(data[4], mime, picture)
+			}
+		case id3Frames[id] != "":
+			// v2.4 的多值文本帧用 NUL 分隔
+			values := strings.Split(strings.TrimRight(decodeText(data[0], data[1:]), "\x00"), "\x00")
+			for _, v := range values {
+				info.addTag(id3Frames[id], v)
+			}
+		}
+	}
+}
+
+// parseAPIC 解析图片帧：编码、MIME 类型、图片类型、描述和图片数据
+func parseAPIC(info *Info, data []byte) {
+	encoding := data[0]
+	mimeEnd := bytes.IndexByte(data[1:], 0)
+	if mimeEnd < 0 || 1+mimeEnd+2 > len(data) {
+		return
+	}
+	mime := string(data[1 : 1+mimeEnd])
+	pictureType := data[1+mimeEnd+1]
+	if _, picture, ok := splitEncoded(encoding, data[1+mimeEnd+2:]); ok {
+		info.setPicture(pictureType, mime, picture)
+	}
+}
+
+// splitEncoded 在第一个字符串结束符处切开，UTF-16 的结束符是两个对齐的 0 字节
+func splitEncoded(encoding byte, b []byte) (before, after []byte, ok bool) {
+	if encoding == 1 || encoding == 2 {
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				return b[:i], b[i+2:], true
+			}
+		}
+		return nil, nil, false
+	}
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return nil, nil, false
+	}
+	return b[:i], b[i+1:], true
+}
+
+// decodeText 按 ID3 的文本编码转为 UTF-8：0 ISO-8859-1、1 带 BOM 的 UTF-16、2 UTF-16BE、3 UTF-8
+func decodeText(encoding byte, b []byte) string {
+	switch encoding {
+	case 1, 2:
+		var order binary.ByteOrder = binary.BigEndian
+		if encoding == 1 && len(b) >= 2 {
+			if b[0] == 0xFF && b[1] == 0xFE {
+				order = binary.LittleEndian
+			}
+			if (b[0] == 0xFF && b[1] == 0xFE) || (b[0] == 0xFE && b[1] == 0xFF) {
+				b = b[2:]
+			}
+		}
+		units := make([]uint16, len(b)/2)
+		for i := range units {
+			units[i] = order.Uint16(b[2*i:])
+		}
+		return string(utf16.Decode(units))
+	case 3:
+		return string(b)
+	default:
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return string(runes)
+	}
+}
+
+// readID3v1 读取文件末尾 128 字节的 ID3v1 标签
+func readID3v1(r io.ReadSeeker, size int64) (map[string]string, bool) {
+	if size < 128 {
+		return nil, false
+	}
+	b := make([]byte, 128)
+	if _, err := r.Seek(size-128, io.SeekStart); err != nil {
+		return nil, false
+	}
+	if _, err := io.ReadFull(r, b); err != nil || string(b[:3]) != "TAG" {
+		return nil, false
+	}
+	field := func(from, to int) string {
+		return strings.TrimRight(decodeText(0, bytes.TrimRight(b[from:to], "\x00")), " ")
+	}
+	return map[string]string{
+		"title":  field(3, 33),
+		"artist": field(33, 63),
+		"album":  field(63, 93),
+		"date":   field(93, 97),
+	}, true
+}
+
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7F)<<21 | uint32(b[1]&0x7F)<<14 | uint32(b[2]&0x7F)<<7 | uint32(b[3]&0x7F)
+}
@@ -0,0 +1,122 @@
+package audiotag
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// oggMaxPage 一个 Ogg 页的最大长度：27 字节页头、255 个分段长度和 255 个满分段
+const oggMaxPage = 27 + 255 + 255*255
+
+// readOgg 读取第一个逻辑流的识别包和注释包，时长由最后一页的 granule position 算出
+func readOgg(r io.ReadSeeker, size int64) (*Info, error) {
+	br := bufio.NewReader(r)
+	var serial uint32
+	var packets [][]byte
+	var current []byte
+	for page := 0; len(packets) < 2; page++ {
+		header, segments, payload, err := readOggPage(br)
+		if err != nil {
+			return nil, err
+		}
+		if s := binary.LittleEndian.Uint32(header[14:18]); page == 0 {
+			serial = s
+		} else if s != serial {
+			continue // 其他逻辑流（例如内嵌的视频）
+		}
+		offset := 0
+		for _, n := range segments {
+			current = append(current, payload[offset:offset+int(n)]...)
+			offset += int(n)
+			if len(current) > maxBlockSize {
+				return nil, errTruncated
+			}
+			// 长度为 255 的分段表示包在下一个分段继续
+			if n < 255 {
+				packets = append(packets, current)
+				current = nil
+				if len(packets) == 2 {
+					break
+				}
+			}
+		}
+	}
+
+	info := &Info{Format: FormatOgg}
+	var rate, preSkip int64
+	id, comments := packets[0], packets[1]
+	switch {
+	case len(id) >= 16 && bytes.HasPrefix(id, []byte("\x01vorbis")):
+		rate = int64(binary.LittleEndian.Uint32(id[12:16]))
+		info.Codec, info.SampleRate, info.Channels = "vorbis", int(rate), int(id[11])
+		if bytes.HasPrefix(comments, []byte("\x03vorbis")) {
+			parseVorbisComment(info, comments[7:])
+		}
+	case len(id) >= 12 && bytes.HasPrefix(id, []byte("OpusHead")):
+		// Opus 的 granule position 总是以 48 kHz 计，开头的 pre-skip 不算时长
+		rate = 48000
+		preSkip = int64(binary.LittleEndian.Uint16(id[10:12]))
+		info.Codec, info.SampleRate, info.Channels = "opus", 48000, int(id[9])
+		if bytes.HasPrefix(comments, []byte("OpusTags")) {
+			parseVorbisComment(info, comments[8:])
+		}
+	default:
+		return nil, ErrUnsupported
+	}
+	if granule, err := lastGranule(r, size, serial); err == nil && rate > 0 && granule > preSkip {
+		info.DurationMs = int((granule - preSkip) * 1000 / rate)
+	}
+	return info, nil
+}
+
+// readOggPage 读取一页，返回页头、分段长度表和页内数据
+func readOggPage(r io.Reader) (header, segments, payload []byte, err error) {
+	header = make([]byte, 27)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return nil, nil, nil, err
+	}
+	if string(header[:4]) != "OggS" {
+		return nil, nil, nil, errors.New("invalid ogg page")
+	}
+	segments = make([]byte, header[26])
+	if _, err = io.ReadFull(r, segments); err != nil {
+		return nil, nil, nil, err
+	}
+	size := 0
+	for _, n := range segments {
+		size += int(n)
+	}
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return nil, nil, nil, err
+	}
+	return header, segments, payload, nil
+}
+
+// lastGranule 从文件末尾向前找属于该逻辑流的最后一页，返回它的 granule position
+func lastGranule(r io.ReadSeeker, size int64, serial uint32) (int64, error) {
+	tail := int64(2 * oggMaxPage)
+	if tail > size {
+		tail = size
+	}
+	if _, err := r.Seek(size-tail, io.SeekStart); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, tail)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, err
+	}
+	for i := bytes.LastIndex(buf, []byte("OggS")); i >= 0; i = bytes.LastIndex(buf[:i], []byte("OggS")) {
+		if len(buf)-i < 27 || binary.LittleEndian.Uint32(buf[i+14:i+18]) != serial {
+			continue
+		}
+		// -1 表示这一页没有结束任何包
+		if granule := int64(binary.LittleEndian.Uint64(buf[i+6 : i+14])); granule >= 0 {
+			return granule, nil
+		}
+	}
+	return 0, errors.New("no final ogg page")
+}
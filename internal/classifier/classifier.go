@@ -0,0 +1,124 @@
+// Package classifier 调用外部的流派/情绪分类服务（例如自建的机器学习模型），
+// 为缺少标签的歌曲推断流派和情绪，并给出置信度
+package classifier
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/yeeeck/sync-jukebox/internal/config"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+	// maxResponseBytes 分类服务响应的大小上限
+	maxResponseBytes = 1 << 20
+)
+
+// Request 发送给分类服务的歌曲信息，已有的流派和情绪标签一并发送，服务可以只补充缺少的部分
+type Request struct {
+	SongID     string  `json:"songId"`
+	Title      string  `json:"title"`
+	Artist     string  `json:"artist"`
+	Album      string  `json:"album,omitempty"`
+	Year       int     `json:"year,omitempty"`
+	DurationMs int     `json:"durationMs"`
+	Genre      string  `json:"genre,omitempty"`
+	Mood       string  `json:"mood,omitempty"`
+	BPM        float64 `json:"bpm,omitempty"`
+	MusicalKey string  `json:"musicalKey,omitempty"`
+	// MediaURL 服务下载音频的地址，没有配置 mediaBaseUrl 时为空，只能按标签分类
+	MediaURL string `json:"mediaUrl,omitempty"`
+}
+
+// Result 分类服务的响应，置信度在 0 到 1 之间，无法判断的字段留空
+type Result struct {
+	Genre           string  `json:"genre"`
+	GenreConfidence float64 `json:"genreConfidence"`
+	Mood            string  `json:"mood"`
+	MoodConfidence  float64 `json:"moodConfidence"`
+}
+
+// Client 分类服务的客户端
+type Client struct {
+	url          string
+	token        string
+	mediaBaseURL string
+	client       *http.Client
+}
+
+// New 按配置创建，没有配置 URL 时返回 nil
+func New(cfg config.ClassifierConfig) (*Client, error) {
+	if cfg.URL == "" {
+		return nil, nil
+	}
+	for _, raw := range []string{cfg.URL, cfg.MediaBaseURL} {
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid classifier url %q", raw)
+		}
+	}
+	return &Client{
+		url:          cfg.URL,
+		token:        cfg.Token,
+		mediaBaseURL: strings.TrimSuffix(cfg.MediaBaseURL, "/"),
+		client:       &http.Client{Timeout: cmp.Or(time.Duration(cfg.TimeoutSeconds)*time.Second, defaultTimeout)},
+	}, nil
+}
+
+// MediaURL 把 /static/audio 下的相对地址转换为分类服务可以访问的地址，没有配置 mediaBaseUrl 时返回空
+func (c *Client) MediaURL(path string) string {
+	if c.mediaBaseURL == "" || path == "" {
+		return ""
+	}
+	return c.mediaBaseURL + path
+}
+
+// Classify 请求分类服务，置信度超出 0 到 1 时截断
+func (c *Client) Classify(ctx context.Context, r Request) (*Result, error) {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var result Result
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("invalid classifier response: %w", err)
+	}
+	result.Genre = strings.TrimSpace(result.Genre)
+	result.Mood = strings.TrimSpace(result.Mood)
+	result.GenreConfidence = max(0, min(1, result.GenreConfidence))
+	result.MoodConfidence = max(0, min(1, result.MoodConfidence))
+	return &result, nil
+}
@@ -0,0 +1,130 @@
+// Package cluster 在多个服务实例之间选出唯一的主实例。
+// 主实例运行播放状态机并处理所有 API 请求，其他实例只负责 WebSocket 连接，
+// 并把 API 请求转发给主实例。
+package cluster
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// 租约时长和续约间隔，主实例失联后最多 leaseTTL 内完成切换
+const (
+	leaseTTL      = 10 * time.Second
+	renewInterval = leaseTTL / 3
+)
+
+// renewScript 只有仍持有租约时才续期，避免覆盖其他实例刚获得的租约
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// Elector 通过 Redis 租约进行主实例选举
+type Elector struct {
+	rdb   *redis.Client
+	key   string
+	value string // "<实例 ID> <对外地址>"
+
+	leader atomic.Bool
+	mu     sync.Mutex
+	// onChange 在成为或不再是主实例时调用
+	onChange func(leader bool)
+}
+
+// NewElector 创建选举器，advertiseURL 是其他实例转发请求时使用的本实例地址
+func NewElector(rdb *redis.Client, prefix, advertiseURL string) *Elector {
+	id, _ := uuid.NewV4()
+	return &Elector{
+		rdb:   rdb,
+		key:   prefix + ":leader",
+		value: id.String() + " " + advertiseURL,
+	}
+}
+
+// OnChange 设置角色变化的回调，需在 Run 之前调用
+func (e *Elector) OnChange(fn func(leader bool)) {
+	e.onChange = fn
+}
+
+// IsLeader 返回本实例当前是否为主实例
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// LeaderURL 返回当前主实例的对外地址，没有主实例时返回空字符串
+func (e *Elector) LeaderURL(ctx context.Context) string {
+	value, err := e.rdb.Get(ctx, e.key).Result()
+	if err != nil {
+		return ""
+	}
+	_, url, _ := strings.Cut(value, " ")
+	return url
+}
+
+// Run 周期性地尝试获取或续约租约，直到 ctx 结束；退出时主动释放租约
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(renewInterval)
+	defer ticker.Stop()
+	for {
+		e.tick(ctx)
+		select {
+		case <-ctx.Done():
+			if e.leader.Load() {
+				// 使用新的 context，原 context 已取消
+				e.rdb.Eval(context.Background(), `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`, []string{e.key}, e.value)
+				e.setLeader(false)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Elector) tick(ctx context.Context) {
+	if e.leader.Load() {
+		renewed, err := renewScript.Run(ctx, e.rdb, []string{e.key}, e.value, leaseTTL.Milliseconds()).Int()
+		if err != nil {
+			log.Printf("Cluster: failed to renew leadership: %v", err)
+		}
+		// 续约失败（包括 Redis 不可达）时立即让出，宁可短暂无主也不能双主
+		if err != nil || renewed == 0 {
+			e.setLeader(false)
+		}
+		return
+	}
+	acquired, err := e.rdb.SetNX(ctx, e.key, e.value, leaseTTL).Result()
+	if err != nil {
+		log.Printf("Cluster: failed to acquire leadership: %v", err)
+		return
+	}
+	if acquired {
+		e.setLeader(true)
+	}
+}
+
+func (e *Elector) setLeader(leader bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leader.Load() == leader {
+		return
+	}
+	e.leader.Store(leader)
+	if leader {
+		log.Println("Cluster: this instance is now the leader")
+	} else {
+		log.Println("Cluster: this instance is no longer the leader")
+	}
+	if e.onChange != nil {
+		e.onChange(leader)
+	}
+}
@@ -0,0 +1,293 @@
+// Package compress 按客户端的 Accept-Encoding 用 Brotli 或 gzip 压缩响应，
+// 并优先提供构建时生成的 .br / .gz 静态文件
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/config"
+)
+
+// 支持的编码
+const (
+	Brotli = "br"
+	Gzip   = "gzip"
+)
+
+const (
+	defaultMinBytes = 1024
+	// 动态压缩追求速度，静态文件应在构建时用最高级别预先压缩
+	gzipLevel   = 5
+	brotliLevel = 4
+)
+
+// compressibleTypes 值得压缩的内容类型；音频切片、图片、压缩包本身已经压缩，SSE 需要逐条发送，都不在其中
+var compressibleTypes = map[string]bool{
+	"application/json":              true,
+	"application/javascript":        true,
+	"application/xml":               true,
+	"application/vnd.apple.mpegurl": true,
+	"application/x-mpegurl":         true,
+	"audio/mpegurl":                 true,
+	"audio/x-mpegurl":               true,
+	"image/svg+xml":                 true,
+	"text/css":                      true,
+	"text/csv":                      true,
+	"text/html":                     true,
+	"text/javascript":               true,
+	"text/plain":                    true,
+	"text/xml":                      true,
+}
+
+// fileExtensions 预压缩文件的扩展名
+var fileExtensions = map[string]string{Brotli: ".br", Gzip: ".gz"}
+
+// Compressor 按配置压缩响应，nil 表示不压缩
+type Compressor struct {
+	encodings    []string
+	minBytes     int
+	excludePaths []string
+	gzipPool     sync.Pool
+	brotliPool   sync.Pool
+}
+
+// New 创建压缩器，配置关闭压缩时返回 nil，编码名称无效时返回错误
+func New(cfg config.CompressionConfig) (*Compressor, error) {
+	if cfg.Disabled {
+		return nil, nil
+	}
+	c := &Compressor{
+		encodings:    cfg.Encodings,
+		minBytes:     cfg.MinBytes,
+		excludePaths: cfg.ExcludePaths,
+	}
+	if len(c.encodings) == 0 {
+		c.encodings = []string{Brotli, Gzip}
+	}
+	for _, enc := range c.encodings {
+		if _, ok := fileExtensions[enc]; !ok {
+			return nil, fmt.Errorf("unsupported compression encoding %q", enc)
+		}
+	}
+	if c.minBytes <= 0 {
+		c.minBytes = defaultMinBytes
+	}
+	return c, nil
+}
+
+// Middleware 返回压缩中间件，内容类型不适合或长度太短的响应原样发送
+func (c *Compressor) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		encoding := c.negotiate(ctx.Request)
+		if encoding == "" {
+			ctx.Next()
+			return
+		}
+		w := &writer{ResponseWriter: ctx.Writer, c: c, encoding: encoding}
+		ctx.Writer = w
+		defer w.finish()
+		ctx.Next()
+	}
+}
+
+// ServeFile 发送静态文件，客户端接受且存在 path.br / path.gz 时发送预先压缩的版本
+func (c *Compressor) ServeFile(ctx *gin.Context, path string) {
+	if encoding := c.negotiate(ctx.Request); encoding != "" {
+		compressed := path + fileExtensions[encoding]
+		if info, err := os.Stat(compressed); err == nil && info.Mode().IsRegular() {
+			if ct := mime.TypeByExtension(filepath.Ext(path)); ct != "" {
+				ctx.Header("Content-Type", ct)
+			}
+			ctx.Header("Content-Encoding", encoding)
+			ctx.Header("Vary", "Accept-Encoding")
+			ctx.File(compressed)
+			return
+		}
+	}
+	ctx.File(path)
+}
+
+// negotiate 按 Accept-Encoding 的权重选择编码，权重相同时按配置的顺序，不压缩时返回空字符串
+func (c *Compressor) negotiate(r *http.Request) string {
+	if c == nil || r.Header.Get("Range") != "" || r.Header.Get("Upgrade") != "" {
+		return ""
+	}
+	for _, prefix := range c.excludePaths {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return ""
+		}
+	}
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		weights[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+	best, bestQ := "", 0.0
+	for _, enc := range c.encodings {
+		q, ok := weights[enc]
+		if !ok {
+			q = weights["*"]
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// encoder 压缩流，Flush 用于逐段发送（反向代理转发时）
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func (c *Compressor) newEncoder(encoding string, w io.Writer) encoder {
+	if encoding == Brotli {
+		if bw, ok := c.brotliPool.Get().(*brotli.Writer); ok {
+			bw.Reset(w)
+			return bw
+		}
+		return brotli.NewWriterLevel(w, brotliLevel)
+	}
+	if gw, ok := c.gzipPool.Get().(*gzip.Writer); ok {
+		gw.Reset(w)
+		return gw
+	}
+	gw, _ := gzip.NewWriterLevel(w, gzipLevel)
+	return gw
+}
+
+func (c *Compressor) release(enc encoder) {
+	switch e := enc.(type) {
+	case *brotli.Writer:
+		c.brotliPool.Put(e)
+	case *gzip.Writer:
+		c.gzipPool.Put(e)
+	}
+}
+
+// writer 先缓存响应的开头，达到长度下限时才决定压缩，此时处理函数已经设置好状态码和内容类型
+type writer struct {
+	gin.ResponseWriter
+	c        *Compressor
+	encoding string
+	buf      []byte
+	decided  bool
+	enc      encoder
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if !w.decided {
+		if !w.compressible() {
+			if err := w.decide(false); err != nil {
+				return 0, err
+			}
+		} else if len(w.buf)+len(p) < w.c.minBytes {
+			w.buf = append(w.buf, p...)
+			return len(p), nil
+		} else if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *writer) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush 立即发送已缓存的内容，还没决定是否压缩时按已有的内容决定
+func (w *writer) Flush() {
+	if !w.decided {
+		if err := w.decide(w.compressible() && len(w.buf) > 0); err != nil {
+			return
+		}
+	}
+	if w.enc != nil {
+		if err := w.enc.Flush(); err != nil {
+			return
+		}
+	}
+	w.ResponseWriter.Flush()
+}
+
+// compressible 判断响应是否适合压缩：有响应体、没有被编码过、内容类型合适
+func (w *writer) compressible() bool {
+	if w.ResponseWriter.Written() {
+		return false
+	}
+	switch status := w.Status(); {
+	case status < http.StatusOK, status == http.StatusNoContent, status == http.StatusPartialContent, status == http.StatusNotModified:
+		return false
+	}
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < w.c.minBytes {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	return err == nil && compressibleTypes[strings.ToLower(mediaType)]
+}
+
+// decide 决定是否压缩并发出缓存的内容
+func (w *writer) decide(compress bool) error {
+	w.decided = true
+	buf := w.buf
+	w.buf = nil
+	if compress {
+		h := w.Header()
+		h.Set("Content-Encoding", w.encoding)
+		h.Add("Vary", "Accept-Encoding")
+		h.Del("Content-Length")
+		w.enc = w.c.newEncoder(w.encoding, w.ResponseWriter)
+		if len(buf) > 0 {
+			_, err := w.enc.Write(buf)
+			return err
+		}
+		return nil
+	}
+	if len(buf) > 0 {
+		_, err := w.ResponseWriter.Write(buf)
+		return err
+	}
+	return nil
+}
+
+// finish 处理函数返回后发出剩余内容，结束压缩流
+func (w *writer) finish() {
+	if !w.decided {
+		if err := w.decide(false); err != nil {
+			return
+		}
+	}
+	if w.enc != nil {
+		if err := w.enc.Close(); err != nil {
+			log.Printf("Warning: failed to finish %s response: %v", w.encoding, err)
+		}
+		w.c.release(w.enc)
+		w.enc = nil
+	}
+}
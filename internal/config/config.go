@@ -0,0 +1,400 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Config 是服务端的可选配置，从 JSON 文件加载
+// 文件不存在时使用默认值，因此零配置也能直接运行
+type Config struct {
+	Fairness FairnessConfig `json:"fairness"`
+	Queue    QueueConfig    `json:"queue"`
+	Hooks    []HookConfig   `json:"hooks"`
+	Cluster  ClusterConfig  `json:"cluster"`
+	Store    StoreConfig    `json:"store"`
+	// Federation 启动时自动跟随的远程实例，也可以通过管理接口随时切换
+	Federation FederationConfig `json:"federation"`
+	Import     ImportConfig     `json:"import"`
+	Ingest     IngestConfig     `json:"ingest"`
+	Library    LibraryConfig    `json:"library"`
+	Transcode  TranscodeConfig  `json:"transcode"`
+	// Compression API、HLS 索引和前端文件的响应压缩
+	Compression CompressionConfig `json:"compression"`
+	// Maintenance 每晚执行的数据库整理、文件清理、备份和日志轮转
+	Maintenance MaintenanceConfig `json:"maintenance"`
+	// Reporting 请求处理或后台协程发生 panic 时的上报
+	Reporting ReportingConfig `json:"reporting"`
+	// CSRF 浏览器发起的修改请求需要携带令牌，防止其他网站借用浏览器保存的登录凭证
+	CSRF CSRFConfig `json:"csrf"`
+	// OIDC 通过外部身份提供方（Authentik、Keycloak、Google 等）单点登录
+	OIDC OIDCConfig `json:"oidc"`
+	// ProxyAuth 由前面的认证代理（Authelia、oauth2-proxy 等）在请求头中传递登录身份
+	ProxyAuth ProxyAuthConfig `json:"proxyAuth"`
+	// Streams 同时播放的 HLS 流数量限制，防止公开的实例被当作免费的 CDN
+	Streams StreamLimitConfig `json:"streams"`
+	// Bandwidth 媒体流量的每月上限，用于按出站流量计费的云服务器
+	Bandwidth BandwidthConfig `json:"bandwidth"`
+	// AirPlay 把正在播放的内容推送到局域网内的 AirPlay 音箱
+	AirPlay AirPlayConfig `json:"airplay"`
+	// DLNA 以 DLNA/UPnP 媒体服务器的形式在局域网内公开曲库，智能电视和功放可以直接浏览和播放
+	DLNA DLNAConfig `json:"dlna"`
+	// MDNS 通过 mDNS（Bonjour/zeroconf）在局域网内宣告服务器，配套客户端可以自动发现，不需要手动输入地址
+	MDNS MDNSConfig `json:"mdns"`
+	// Analysis 外部音频分析 worker（指纹、BPM/调性、流派分类），可以运行在另一台带 GPU 的机器上
+	Analysis AnalysisConfig `json:"analysis"`
+	// TrustedProxies 前面反向代理的地址或网段（CIDR），只有直接来自这些地址的请求才按 X-Forwarded-For 取客户端 IP；
+	// 默认不信任任何代理，按连接的对端地址限流，否则任何客户端都能伪造请求头绕过按 IP 的限制。
+	// 集群模式下从实例会把请求转发给主实例，需要把各实例的地址也加进来
+	TrustedProxies []string `json:"trustedProxies"`
+	// ReadOnly 以只读副本运行：只提供曲库、状态和媒体文件，拒绝所有修改请求，不执行回收站清理和夜间维护
+	// 播放状态通过 Federation.FollowURL 跟随主实例，曲库和媒体目录应是主实例的副本，用于大型派对时分担播放流量
+	ReadOnly bool `json:"readOnly"`
+}
+
+// AnalysisConfig 外部分析 worker 通过 /api/analysis 领取任务、下载音频并回传结果，服务器本身不做重型分析
+type AnalysisConfig struct {
+	// WorkerToken worker 在 Authorization: Bearer 中携带的令牌，为空表示不启用；启用后新入库的歌曲自动加入分析队列
+	WorkerToken string `json:"workerToken"`
+	// LeaseSeconds 领取的任务多久没有回传结果就重新派发，0 表示使用默认值（600）
+	LeaseSeconds int `json:"leaseSeconds"`
+	// MaxAttempts 同一首歌最多派发几次，之后标记为失败，0 表示使用默认值（3）
+	MaxAttempts int `json:"maxAttempts"`
+}
+
+// FairnessConfig 派对公平性限制，防止个别用户霸占播放列表
+type FairnessConfig struct {
+	// MaxSkipsPerHour 每个用户每小时最多切歌次数，0 表示不限制
+	MaxSkipsPerHour int `json:"maxSkipsPerHour"`
+	// MaxPendingRequests 每个用户最多同时排队的歌曲数，0 表示不限制
+	MaxPendingRequests int `json:"maxPendingRequests"`
+}
+
+// QueueConfig 点歌规则
+type QueueConfig struct {
+	// SongCooldownMinutes 歌曲播放后多少分钟内不能再次点播，0 表示不限制，管理员不受限制
+	SongCooldownMinutes int `json:"songCooldownMinutes"`
+	// PolicyScript Lua 策略脚本路径，用于点歌准入和选择下一首，为空表示不启用
+	PolicyScript string `json:"policyScript"`
+	// PolicyTimeoutMs 单次执行脚本的时间上限，0 表示使用默认值
+	PolicyTimeoutMs int `json:"policyTimeoutMs"`
+	// AutoDJ 自动 DJ 播放模式挑选下一首的条件
+	AutoDJ AutoDJConfig `json:"autoDJ"`
+	// LowQueue 待播的歌曲快放完时推荐几首歌，由听众确认后加入
+	LowQueue LowQueueConfig `json:"lowQueue"`
+}
+
+// LowQueueConfig 待播时长低于 Minutes 时广播 QUEUE_LOW 事件和推荐的歌曲，与全自动的电台模式不同，歌曲要有人确认才会加入
+type LowQueueConfig struct {
+	// Disabled 关闭推荐
+	Disabled bool `json:"disabled"`
+	// Minutes 剩余待播时长低于多少分钟时推荐，0 表示使用默认值（10）
+	Minutes int `json:"minutes"`
+	// Suggestions 推荐的歌曲数，0 表示使用默认值（5）
+	Suggestions int `json:"suggestions"`
+}
+
+// AutoDJConfig 自动 DJ 在接下来的几首歌中找节奏和调性都能与当前歌曲衔接的一首，提前播放
+type AutoDJConfig struct {
+	// MaxBPMDelta 节奏最多相差多少 BPM，半速和倍速也算，0 表示使用默认值（6）
+	MaxBPMDelta float64 `json:"maxBpmDelta"`
+	// Lookahead 最多向后看多少首，0 表示使用默认值（8）
+	Lookahead int `json:"lookahead"`
+	// IgnoreKey 只比较节奏，不要求调性和谐
+	IgnoreKey bool `json:"ignoreKey"`
+}
+
+// HookConfig 一个外部钩子，在指定事件发生时被调用
+type HookConfig struct {
+	// Event 触发的事件：song_changed、upload_completed、user_registered、song_reported、bandwidth_warning
+	Event string `json:"event"`
+	// Type 钩子类型：exec、http 或 plugin
+	Type string `json:"type"`
+	// Command exec 钩子执行的命令及参数
+	Command []string `json:"command,omitempty"`
+	// URL http 钩子 POST 的地址
+	URL string `json:"url,omitempty"`
+	// Path plugin 钩子的 .so 文件路径
+	Path string `json:"path,omitempty"`
+	// TimeoutSeconds 单次调用的超时时间，0 表示使用默认值
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+// ClusterConfig 多实例部署，所有实例必须共享同一个数据库和媒体目录
+type ClusterConfig struct {
+	// Enabled 为 true 时通过 Redis 选出主实例并在实例之间转发广播
+	Enabled bool `json:"enabled"`
+	// RedisURL Redis 连接地址，例如 redis://localhost:6379/0
+	RedisURL string `json:"redisUrl"`
+	// AdvertiseURL 其他实例访问本实例使用的地址，例如 http://10.0.0.2:8880
+	AdvertiseURL string `json:"advertiseUrl"`
+	// Prefix Redis 键和频道的前缀，为空时使用 "jukebox"
+	Prefix string `json:"prefix"`
+}
+
+// StoreConfig 运行时状态（进度、当前歌曲等）的存储位置
+type StoreConfig struct {
+	// Type 存储类型：sqlite（默认）或 redis
+	Type string `json:"type"`
+	// RedisURL Redis 连接地址，为空时使用 cluster.redisUrl
+	RedisURL string `json:"redisUrl"`
+	// SnapshotSeconds 使用 Redis 时多久快照回 SQLite 一次，0 表示使用默认值
+	SnapshotSeconds int `json:"snapshotSeconds"`
+}
+
+// FederationConfig 跟随另一个点歌台，让两地听到同一首歌的同一位置
+type FederationConfig struct {
+	// FollowURL 远程实例地址，例如 http://other-host:8880，为空表示不跟随
+	FollowURL string `json:"followUrl"`
+	// Username 和 Password 是远程实例上的账号，可以为空
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// ImportConfig 从外部服务导入歌单
+type ImportConfig struct {
+	// SpotifyClientID 和 SpotifyClientSecret 来自 Spotify 开发者后台，为空时只能导入 CSV
+	SpotifyClientID     string `json:"spotifyClientId"`
+	SpotifyClientSecret string `json:"spotifyClientSecret"`
+}
+
+// IngestConfig 向曲库添加文件的限制
+type IngestConfig struct {
+	// MaxDownloadMB 通过直链导入时单个文件的大小上限，0 表示使用默认值（500 MB）
+	MaxDownloadMB int `json:"maxDownloadMb"`
+	// Classifier 入库时为缺少流派或情绪标签的歌曲调用外部分类服务
+	Classifier ClassifierConfig `json:"classifier"`
+}
+
+// ClassifierConfig 外部流派/情绪分类服务，入库后在后台 POST 歌曲信息，响应中的流派和情绪只填入缺少的标签
+type ClassifierConfig struct {
+	// URL 分类服务的地址，为空表示不启用
+	URL string `json:"url"`
+	// Token 以 Authorization: Bearer 发送，为空时不发送
+	Token string `json:"token"`
+	// MediaBaseURL 分类服务访问本服务器的地址（例如 http://jukebox.lan:8080），用于拼出音频地址；
+	// 为空时只发送标签信息
+	MediaBaseURL string `json:"mediaBaseUrl"`
+	// MinConfidence 置信度低于该值的结果不采用，0 表示全部采用
+	MinConfidence float64 `json:"minConfidence"`
+	// TimeoutSeconds 单次请求的时间上限，0 表示使用默认值（30 秒）
+	TimeoutSeconds int `json:"timeoutSeconds"`
+}
+
+// TranscodeConfig 转换为 HLS 时的 ffmpeg 参数，未设置的字段使用默认值（AAC 320k，10 秒切片）
+type TranscodeConfig struct {
+	// Codec 音频编码器：aac（默认）、libfdk_aac、libmp3lame 或 libopus，opus 使用 fMP4 切片
+	Codec string `json:"codec"`
+	// BitratesKbps 码率阶梯，多于一个时生成主索引，由播放器按网络自适应选择
+	BitratesKbps []int `json:"bitratesKbps"`
+	// SegmentSeconds 切片时长，0 表示使用默认值
+	SegmentSeconds int `json:"segmentSeconds"`
+	// Threads ffmpeg 的线程数，0 表示由 ffmpeg 决定
+	Threads int `json:"threads"`
+	// TimeoutMinutes 单个转码任务的时间上限，超时后终止 ffmpeg，0 表示使用默认值（30 分钟）
+	TimeoutMinutes int `json:"timeoutMinutes"`
+	// HWAccel 硬件解码（vaapi、cuda、qsv、videotoolbox 等），只用于带视频流的输入（例如音乐视频），为空表示不启用
+	HWAccel string `json:"hwaccel"`
+}
+
+// LibraryConfig 曲库文件的存放方式
+type LibraryConfig struct {
+	// ReferenceRoots 允许原地引用的目录（例如 NAS 挂载点），引用的文件不复制到媒体目录，
+	// 媒体目录只保存转换出的 HLS 缓存；为空表示不启用原地引用
+	ReferenceRoots []string `json:"referenceRoots"`
+	// TrashRetentionHours 删除的歌曲在回收站保留多久，之后连同文件永久删除，0 表示使用默认值（7 天）
+	TrashRetentionHours int `json:"trashRetentionHours"`
+	// ForgottenGems “被遗忘的好歌”的标准，以及自动切歌时是否穿插播放它们
+	ForgottenGems ForgottenGemsConfig `json:"forgottenGems"`
+}
+
+// ForgottenGemsConfig 被遗忘的好歌：曾经常播放或被收藏进歌单、但很久没有播放的歌曲
+type ForgottenGemsConfig struct {
+	// Days 多少天没有播放算被遗忘，0 表示使用默认值（90）
+	Days int `json:"days"`
+	// MinPlays 至少播放过多少次才算曾经流行，0 表示使用默认值（3）；被收藏进歌单的歌曲不受此限制
+	MinPlays int `json:"minPlays"`
+	// Every 自动切歌时每播放多少首穿插一首被遗忘的好歌，0 表示不穿插
+	Every int `json:"every"`
+}
+
+// CompressionConfig 响应压缩，默认开启，按客户端的 Accept-Encoding 选择 Brotli 或 gzip
+type CompressionConfig struct {
+	// Disabled 关闭压缩，例如前面的反向代理已经负责压缩
+	Disabled bool `json:"disabled"`
+	// Encodings 按优先级排列的编码，可选 br 和 gzip，为空表示两者都用（Brotli 优先）
+	Encodings []string `json:"encodings"`
+	// MinBytes 小于该长度的响应不压缩，0 表示使用默认值（1024）
+	MinBytes int `json:"minBytes"`
+	// ExcludePaths 不压缩的路径前缀，例如 "/api/graphql"
+	ExcludePaths []string `json:"excludePaths"`
+}
+
+// StreamLimitConfig 每个 IP 和每个用户同时播放的流数量上限，一首歌的索引和切片算一个流
+// 媒体文件可以匿名访问：浏览器登录后带上媒体 Cookie，其他客户端带 Authorization 头时才按用户限制，
+// 故意不带凭证的客户端只受按 IP 的限制，因此按 IP 的上限才是能强制执行的限制
+type StreamLimitConfig struct {
+	// MaxPerIP 每个 IP 同时播放的不同歌曲数，0 表示不限制；预加载下一首时会短暂占用两个
+	MaxPerIP int `json:"maxPerIp"`
+	// MaxPerUser 每个用户同时播放的不同歌曲数，0 表示不限制
+	MaxPerUser int `json:"maxPerUser"`
+	// IdleSeconds 多久没有请求后认为流已结束，0 表示使用默认值（30）
+	IdleSeconds int `json:"idleSeconds"`
+}
+
+// BandwidthConfig 每月媒体流量上限，按服务器时区的自然月统计，达到上限后拒绝媒体请求直到下个月
+type BandwidthConfig struct {
+	// MonthlyCapMB 整个实例每月的流量上限，0 表示不限制
+	MonthlyCapMB int64 `json:"monthlyCapMb"`
+	// UserMonthlyCapMB 每个用户每月的流量上限，0 表示不限制；不带凭证的请求只计入实例的总量
+	UserMonthlyCapMB int64 `json:"userMonthlyCapMb"`
+	// RequireUser 媒体请求必须识别出用户（浏览器的媒体 Cookie、Authorization 头或认证代理的身份），否则返回 401，
+	// 每个用户的上限才无法通过不带凭证绕过；分析 worker 凭令牌访问，封面图片仍然公开。
+	// 开启后分类服务、其他实例的跟随播放和曲库导入无法再匿名下载媒体文件
+	RequireUser bool `json:"requireUser"`
+	// WarnPercent 用量达到上限的百分之多少时记录警告并触发 bandwidth_warning 钩子，0 表示使用默认值（80）
+	WarnPercent int `json:"warnPercent"`
+}
+
+// AirPlayConfig AirPlay（RAOP）输出，需要 ffmpeg 解码，服务器要和音箱在同一个局域网内
+// 只支持接受不加密音频流的接收端，例如 shairport-sync 和大多数第三方 AirPlay 音箱
+type AirPlayConfig struct {
+	// Enabled 开启后 DJ 可以搜索并连接音箱，连接的音箱作为输出设备跟随播放
+	Enabled bool `json:"enabled"`
+}
+
+// DLNAConfig DLNA 媒体服务器（ContentDirectory），通过 SSDP 在局域网内宣告
+// DLNA 客户端不支持登录，因此媒体服务器使用单独的端口，只接受局域网地址的请求
+type DLNAConfig struct {
+	Enabled bool `json:"enabled"`
+	// Name 客户端中显示的服务器名称，默认为 SyncJukebox
+	Name string `json:"name"`
+	// Port 媒体服务器的 HTTP 端口，默认 8200
+	Port int `json:"port"`
+}
+
+// MDNSConfig 以 _sync-jukebox._tcp 和 _http._tcp 两种服务类型宣告服务器的网页端口
+type MDNSConfig struct {
+	Enabled bool `json:"enabled"`
+	// Name 客户端中显示的实例名，默认为 "SyncJukebox (<主机名>)"
+	Name string `json:"name"`
+}
+
+// MaintenanceConfig 夜间维护，默认开启，任务也可以通过管理接口单独开关或立即执行
+type MaintenanceConfig struct {
+	// Disabled 关闭定时维护，管理员仍然可以手动执行任务
+	Disabled bool `json:"disabled"`
+	// Time 每天开始维护的本地时间（HH:MM），为空表示 04:00
+	Time string `json:"time"`
+	// DisabledTasks 默认不执行的任务：vacuum、media_gc、hls_cache、backup、log_rotate
+	DisabledTasks []string `json:"disabledTasks"`
+	// BackupDir 数据库备份的存放目录，为空表示不备份
+	BackupDir string `json:"backupDir"`
+	// BackupKeep 保留最近几份备份，0 表示使用默认值（7）
+	BackupKeep int `json:"backupKeep"`
+	// HLSCacheIdleDays 原地引用的源文件已经不在、且超过多少天没有播放时删除其 HLS 缓存，0 表示使用默认值（30）
+	HLSCacheIdleDays int `json:"hlsCacheIdleDays"`
+	// LogFile 除标准输出外同时写入的日志文件，为空表示不写文件，也不轮转
+	LogFile string `json:"logFile"`
+	// LogMaxMB 日志文件超过该大小时轮转，0 表示使用默认值（50 MB）
+	LogMaxMB int `json:"logMaxMb"`
+	// LogKeep 保留几份轮转出的旧日志，0 表示使用默认值（5）
+	LogKeep int `json:"logKeep"`
+}
+
+// ReportingConfig panic 上报，日志中总会记录堆栈，配置地址后同时发送给外部服务
+type ReportingConfig struct {
+	// SentryDSN Sentry 项目的 DSN，例如 https://<key>@o0.ingest.sentry.io/<project>，为空表示不上报到 Sentry
+	SentryDSN string `json:"sentryDsn"`
+	// WebhookURL 以 JSON POST 发送每次 panic 的地址，为空表示不发送
+	WebhookURL string `json:"webhookUrl"`
+	// Environment 上报时附带的环境名，例如 production
+	Environment string `json:"environment"`
+}
+
+// CSRFConfig 跨站请求伪造防护，默认开启；令牌放在 Cookie 中，前端在修改请求的请求头里原样带回
+// 只校验浏览器发起的请求（带 Origin 或 Sec-Fetch-Site 头），命令行工具和其他实例的调用不受影响
+type CSRFConfig struct {
+	// Disabled 关闭校验，例如前面的反向代理已经负责防护
+	Disabled bool `json:"disabled"`
+	// CookieSameSite 令牌 Cookie 的 SameSite 属性：lax（默认）、strict 或 none，none 时总会加上 Secure
+	CookieSameSite string `json:"cookieSameSite"`
+	// CookieSecure 总是给 Cookie 加上 Secure 属性，不设置时只在 HTTPS 请求（含 X-Forwarded-Proto: https）中添加
+	CookieSecure bool `json:"cookieSecure"`
+	// TrustedOrigins 不需要令牌的跨源来源，例如单独部署的前端 https://jukebox.example.com
+	TrustedOrigins []string `json:"trustedOrigins"`
+}
+
+// OIDCConfig OpenID Connect 单点登录，使用授权码流程（PKCE），Issuer 为空表示不启用
+// 首次登录时自动创建账号，之后按 Subject 识别同一个人；账号没有可用的密码，只能通过单点登录进入
+type OIDCConfig struct {
+	// Issuer 身份提供方地址，例如 https://auth.example.com/application/o/jukebox/，
+	// 从 <Issuer>/.well-known/openid-configuration 读取各端点
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+	// RedirectURL 回调地址，需要在身份提供方登记，为空表示按请求推算 <外部地址>/api/auth/oidc/callback
+	RedirectURL string `json:"redirectUrl"`
+	// Scopes 申请的权限，为空表示 openid profile email，需要组信息时通常还要加上 groups
+	Scopes []string `json:"scopes"`
+	// ButtonLabel 登录页按钮上显示的名字，为空表示 "SSO"
+	ButtonLabel string `json:"buttonLabel"`
+	// UsernameClaim 作为用户名的声明，为空表示 preferred_username，没有时依次使用 email 和 sub
+	UsernameClaim string `json:"usernameClaim"`
+	// RolesClaim 用于角色映射的声明（字符串或字符串数组），为空表示 groups
+	RolesClaim string `json:"rolesClaim"`
+	// AdminGroups 和 DJGroups 映射为管理员和 DJ 的声明值；任一不为空时每次登录都按声明重新设置角色，
+	// 都为空时新账号是普通用户，角色由管理员在本站修改
+	AdminGroups []string `json:"adminGroups"`
+	DJGroups    []string `json:"djGroups"`
+	// AllowedGroups 不为空时只有声明中包含其中之一的人可以登录，例如限制 Google 账号的范围
+	AllowedGroups []string `json:"allowedGroups"`
+	// DisableSignup 不自动创建账号，只允许已经关联过的账号登录
+	DisableSignup bool `json:"disableSignup"`
+	// LinkExistingUsers 首次登录时关联同名的本地账号；只有确信身份提供方的用户名不能被随意注册时才应开启
+	LinkExistingUsers bool `json:"linkExistingUsers"`
+	// SessionHours 单点登录后的登录有效期，0 表示使用默认值（30 天）
+	SessionHours int `json:"sessionHours"`
+}
+
+// ProxyAuthConfig 信任认证代理传来的用户名，请求头中的用户映射为同名的本地账号，不存在时自动创建
+// 代理必须删除客户端自己发送的同名请求头，否则任何人都能冒充他人
+type ProxyAuthConfig struct {
+	// Enabled 为 false 时忽略这些请求头
+	Enabled bool `json:"enabled"`
+	// TrustedProxies 代理的地址或网段（CIDR），只采信直接来自这些地址的请求头，启用时必填；
+	// 集群模式下从实例会把请求转发给主实例，各实例的地址也要加入
+	TrustedProxies []string `json:"trustedProxies"`
+	// UserHeaders 携带用户名的请求头，取第一个非空的，为空表示 Remote-User、X-Forwarded-User
+	UserHeaders []string `json:"userHeaders"`
+	// GroupsHeaders 携带组（逗号分隔）的请求头，为空表示 Remote-Groups、X-Forwarded-Groups
+	GroupsHeaders []string `json:"groupsHeaders"`
+	// AdminGroups 和 DJGroups 映射为管理员和 DJ 的组，规则与 oidc 相同
+	AdminGroups []string `json:"adminGroups"`
+	DJGroups    []string `json:"djGroups"`
+	// DisableSignup 不自动创建账号，本地不存在的用户被拒绝
+	DisableSignup bool `json:"disableSignup"`
+}
+
+// Default 返回默认配置
+func Default() *Config {
+	return &Config{}
+}
+
+// Load 从指定路径加载配置，未设置的字段保持默认值
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,282 @@
+// Package crash 捕获请求处理和后台协程中的 panic，记录堆栈并按配置上报到 Sentry 或 webhook，
+// 单个请求或连接出错不会让整个进程退出
+package crash
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/config"
+)
+
+const (
+	// sendTimeout 单次上报的超时时间
+	sendTimeout = 10 * time.Second
+	// queueSize 等待上报的事件数上限，连续 panic 时多出的只写日志
+	queueSize = 32
+	// sentryClient 上报给 Sentry 的客户端标识
+	sentryClient = "sync-jukebox/1.0"
+)
+
+// Report 一次 panic 的记录，webhook 收到的 JSON 即此结构
+type Report struct {
+	EventID string    `json:"eventId"`
+	Time    time.Time `json:"time"`
+	// Source 发生 panic 的位置：http 表示请求处理，其他为后台协程的名字
+	Source  string `json:"source"`
+	Message string `json:"message"`
+	Stack   string `json:"stack"`
+	// Method、Path 和 Username 只在请求处理中发生时有值
+	Method      string `json:"method,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Username    string `json:"username,omitempty"`
+	ServerName  string `json:"serverName"`
+	Environment string `json:"environment,omitempty"`
+}
+
+// Reporter 记录并上报 panic，没有配置上报地址时只写日志，nil Reporter 同样只写日志
+type Reporter struct {
+	webhookURL  string
+	sentry      *sentryTarget
+	environment string
+	serverName  string
+	client      *http.Client
+	queue       chan Report
+}
+
+// sentryTarget 从 DSN 解析出的上报地址和密钥
+type sentryTarget struct {
+	endpoint string
+	key      string
+	dsn      string
+}
+
+// New 按配置创建上报器，DSN 或 webhook 地址无效时返回错误
+func New(cfg config.ReportingConfig) (*Reporter, error) {
+	r := &Reporter{
+		webhookURL:  cfg.WebhookURL,
+		environment: cfg.Environment,
+		client:      &http.Client{Timeout: sendTimeout},
+	}
+	r.serverName, _ = os.Hostname()
+	if cfg.WebhookURL != "" {
+		if u, err := url.Parse(cfg.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("invalid reporting webhook URL %q", cfg.WebhookURL)
+		}
+	}
+	if cfg.SentryDSN != "" {
+		target, err := parseDSN(cfg.SentryDSN)
+		if err != nil {
+			return nil, err
+		}
+		r.sentry = target
+	}
+	if r.webhookURL != "" || r.sentry != nil {
+		r.queue = make(chan Report, queueSize)
+		go r.sendLoop()
+	}
+	return r, nil
+}
+
+// parseDSN 解析 https://<key>@<host>[/<path>]/<project>
+func parseDSN(dsn string) (*sentryTarget, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN %q", dsn)
+	}
+	p := strings.TrimSuffix(u.Path, "/")
+	slash := strings.LastIndex(p, "/")
+	project := p[slash+1:]
+	if project == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN %q: missing project ID", dsn)
+	}
+	endpoint := fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, p[:max(slash, 0)], project)
+	return &sentryTarget{endpoint: endpoint, key: u.User.Username(), dsn: dsn}, nil
+}
+
+// Recover 在协程中以 defer r.Recover("名字") 调用，捕获 panic 并记录，协程随之正常结束
+func (r *Reporter) Recover(source string) {
+	if v := recover(); v != nil {
+		r.ReportPanic(source, v, debug.Stack())
+	}
+}
+
+// ReportPanic 记录一次已经捕获的 panic
+func (r *Reporter) ReportPanic(source string, value interface{}, stack []byte) {
+	r.report(Report{Source: source, Message: fmt.Sprint(value), Stack: string(stack)})
+}
+
+// Middleware 捕获请求处理中的 panic，返回 500 而不是断开连接；客户端已经断开引起的 panic 不上报
+func (r *Reporter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if brokenConnection(v) {
+				log.Printf("Client went away during %s %s: %v", c.Request.Method, c.Request.URL.Path, v)
+				c.Abort()
+				return
+			}
+			r.report(Report{
+				Source:   "http",
+				Message:  fmt.Sprint(v),
+				Stack:    string(debug.Stack()),
+				Method:   c.Request.Method,
+				Path:     c.Request.URL.Path,
+				Username: c.GetString("username"),
+			})
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}()
+		c.Next()
+	}
+}
+
+// brokenConnection 判断 panic 是否由客户端断开（写入时管道破裂、连接被重置）引起
+func brokenConnection(v interface{}) bool {
+	err, ok := v.(error)
+	if !ok {
+		return false
+	}
+	if errors.Is(err, http.ErrAbortHandler) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && (errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET))
+}
+
+// report 写日志并排队上报，队列满时丢弃
+func (r *Reporter) report(rep Report) {
+	rep.EventID = newEventID()
+	rep.Time = time.Now()
+	if rep.Method != "" {
+		log.Printf("Panic recovered in %s %s (event %s): %s\n%s", rep.Method, rep.Path, rep.EventID, rep.Message, rep.Stack)
+	} else {
+		log.Printf("Panic recovered in %s (event %s): %s\n%s", rep.Source, rep.EventID, rep.Message, rep.Stack)
+	}
+	if r == nil || r.queue == nil {
+		return
+	}
+	rep.ServerName = r.serverName
+	rep.Environment = r.environment
+	select {
+	case r.queue <- rep:
+	default:
+		log.Printf("Warning: crash report queue is full, event %s was not reported", rep.EventID)
+	}
+}
+
+func (r *Reporter) sendLoop() {
+	for rep := range r.queue {
+		if r.webhookURL != "" {
+			if err := r.sendWebhook(rep); err != nil {
+				log.Printf("Warning: failed to send crash report %s to webhook: %v", rep.EventID, err)
+			}
+		}
+		if r.sentry != nil {
+			if err := r.sendSentry(rep); err != nil {
+				log.Printf("Warning: failed to send crash report %s to Sentry: %v", rep.EventID, err)
+			}
+		}
+	}
+}
+
+func (r *Reporter) sendWebhook(rep Report) error {
+	body, err := json.Marshal(rep)
+	if err != nil {
+		return err
+	}
+	return r.post(r.webhookURL, "application/json", body, nil)
+}
+
+// sendSentry 以 envelope 格式发送一个事件，堆栈作为附加信息原样附上
+func (r *Reporter) sendSentry(rep Report) error {
+	event := map[string]interface{}{
+		"event_id":    rep.EventID,
+		"timestamp":   rep.Time.UTC().Format(time.RFC3339Nano),
+		"platform":    "go",
+		"level":       "fatal",
+		"logger":      rep.Source,
+		"server_name": rep.ServerName,
+		"exception": map[string]interface{}{
+			"values": []map[string]interface{}{{"type": "panic", "value": rep.Message}},
+		},
+		"extra": map[string]interface{}{"stack": rep.Stack},
+	}
+	if rep.Environment != "" {
+		event["environment"] = rep.Environment
+	}
+	if rep.Method != "" {
+		event["request"] = map[string]string{"method": rep.Method, "url": rep.Path}
+		event["transaction"] = rep.Method + " " + rep.Path
+	}
+	if rep.Username != "" {
+		event["user"] = map[string]string{"username": rep.Username}
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	envelopeHeader, _ := json.Marshal(map[string]string{
+		"event_id": rep.EventID,
+		"dsn":      r.sentry.dsn,
+		"sent_at":  time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	itemHeader, _ := json.Marshal(map[string]interface{}{"type": "event", "length": len(payload)})
+	var body bytes.Buffer
+	for _, part := range [][]byte{envelopeHeader, itemHeader, payload} {
+		body.Write(part)
+		body.WriteByte('\n')
+	}
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_key=%s, sentry_client=%s", r.sentry.key, sentryClient)
+	return r.post(r.sentry.endpoint, "application/x-sentry-envelope", body.Bytes(), map[string]string{"X-Sentry-Auth": auth})
+}
+
+func (r *Reporter) post(target, contentType string, body []byte, headers map[string]string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// newEventID 生成 32 位十六进制的事件 ID，与 Sentry 的格式一致，便于在日志和 Sentry 中对照
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
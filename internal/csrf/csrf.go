@@ -0,0 +1,132 @@
+// Package csrf 防止其他网站借用浏览器保存的 Basic Auth 凭证发起修改请求：
+// 服务端在 Cookie 中下发随机令牌，浏览器发起的修改请求必须在请求头中带回同一个令牌，
+// 其他网站读不到本站的 Cookie，也就无法伪造
+package csrf
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yeeeck/sync-jukebox/internal/config"
+)
+
+const (
+	// CookieName 保存令牌的 Cookie，前端需要读取，因此不设置 HttpOnly
+	CookieName = "jukebox_csrf"
+	// HeaderName 修改请求携带令牌的请求头
+	HeaderName = "X-CSRF-Token"
+	// contextKey 请求上下文中当前令牌的键
+	contextKey = "csrfToken"
+	// tokenBytes 令牌的随机字节数
+	tokenBytes = 32
+)
+
+// sameSiteModes 配置中 cookieSameSite 的可选值
+var sameSiteModes = map[string]http.SameSite{
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// Protector 下发和校验令牌，nil 表示不校验
+type Protector struct {
+	sameSite http.SameSite
+	secure   bool
+	trusted  map[string]bool
+}
+
+// New 按配置创建，配置关闭防护时返回 nil，SameSite 或来源无效时返回错误
+func New(cfg config.CSRFConfig) (*Protector, error) {
+	if cfg.Disabled {
+		return nil, nil
+	}
+	p := &Protector{sameSite: http.SameSiteLaxMode, secure: cfg.CookieSecure, trusted: make(map[string]bool)}
+	if cfg.CookieSameSite != "" {
+		mode, ok := sameSiteModes[strings.ToLower(cfg.CookieSameSite)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported csrf cookieSameSite %q, use lax, strict or none", cfg.CookieSameSite)
+		}
+		p.sameSite = mode
+	}
+	// 浏览器只接受带 Secure 的 SameSite=None Cookie
+	if p.sameSite == http.SameSiteNoneMode {
+		p.secure = true
+	}
+	for _, origin := range cfg.TrustedOrigins {
+		origin = strings.TrimSuffix(origin, "/")
+		if !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			return nil, fmt.Errorf("invalid csrf trusted origin %q", origin)
+		}
+		p.trusted[origin] = true
+	}
+	return p, nil
+}
+
+// Middleware 没有令牌 Cookie 时下发一个，并校验浏览器发起的 POST、PUT、PATCH、DELETE 请求
+func (p *Protector) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p == nil {
+			c.Next()
+			return
+		}
+		token, err := c.Cookie(CookieName)
+		if err != nil || !validToken(token) {
+			token = newToken()
+			p.setCookie(c, token)
+		}
+		c.Set(contextKey, token)
+
+		if safeMethod(c.Request.Method) || !fromBrowser(c.Request) || p.trusted[c.GetHeader("Origin")] {
+			c.Next()
+			return
+		}
+		sent := c.GetHeader(HeaderName)
+		if sent == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "CSRF token missing or invalid"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Token 返回本次请求使用的令牌，没有启用防护时为空
+func Token(c *gin.Context) string {
+	return c.GetString(contextKey)
+}
+
+func (p *Protector) setCookie(c *gin.Context, token string) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     CookieName,
+		Value:    token,
+		Path:     "/",
+		Secure:   p.secure || c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https",
+		SameSite: p.sameSite,
+	})
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// fromBrowser 浏览器发起的请求总会带 Origin（跨源或非 GET）或 Sec-Fetch-Site，
+// curl、token-cli 和其他实例的调用两者都没有，不需要令牌
+func fromBrowser(r *http.Request) bool {
+	return r.Header.Get("Origin") != "" || r.Header.Get("Sec-Fetch-Site") != ""
+}
+
+func newToken() string {
+	b := make([]byte, tokenBytes)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// validToken 只接受本服务生成的格式，避免把任意 Cookie 值当作令牌
+func validToken(token string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	return err == nil && len(b) == tokenBytes
+}
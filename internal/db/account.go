@@ -0,0 +1,120 @@
+package db
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// ErrLastAdmin 删除唯一的管理员后没有人能管理服务
+var ErrLastAdmin = errors.New("the last admin account cannot be deleted")
+
+// DeleteUser 删除用户并匿名化与其相关的记录：播放历史、播放列表、派对、黑名单、歌单、上传和举报记录中的用户名清空，
+// 派对回顾的点歌排行中去掉该用户；记录本身保留，统计不受影响。上传的歌曲如需删除应在调用前移入回收站
+func (db *DB) DeleteUser(username string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.Where("username = ?", username).First(&user).Error; err != nil {
+			return err
+		}
+		if user.IsAdmin() {
+			admins, err := countAdmins(tx)
+			if err != nil {
+				return err
+			}
+			if admins <= 1 {
+				return ErrLastAdmin
+			}
+		}
+		if err := tx.Delete(&user).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("username = ?", username).Delete(&LoginToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("username = ?", username).Delete(&WSTicket{}).Error; err != nil {
+			return err
+		}
+		// 代表该用户的触发令牌一并删除，否则之后注册的同名账号会继承这些令牌
+		if err := tx.Where("username = ?", username).Delete(&Token{}).Error; err != nil {
+			return err
+		}
+
+		anonymize := []struct {
+			model  interface{}
+			column string
+		}{
+			{&PlayHistory{}, "requested_by"},
+			{&PlaylistItem{}, "added_by"},
+			{&PartySession{}, "started_by"},
+			{&BlocklistEntry{}, "created_by"},
+			{&SavedPlaylist{}, "created_by"},
+			{&Song{}, "uploaded_by"},
+			{&SongReport{}, "reported_by"},
+			{&SongReport{}, "resolved_by"},
+			{&BandwidthUsage{}, "username"},
+			{&Scrobble{}, "requested_by"},
+			{&Scrobble{}, "skipped_by"},
+			{&SongComment{}, "author"},
+			{&SongComment{}, "hidden_by"},
+			{&SoundClip{}, "uploaded_by"},
+		}
+		for _, a := range anonymize {
+			// Unscoped 连同回收站中的歌曲一起处理
+			if err := tx.Unscoped().Model(a.model).Where(a.column+" = ?", username).Update(a.column, "").Error; err != nil {
+				return err
+			}
+		}
+
+		var sessions []PartySession
+		if err := tx.Where("recap IS NOT NULL").Find(&sessions).Error; err != nil {
+			return err
+		}
+		for _, session := range sessions {
+			if session.Recap == nil {
+				continue
+			}
+			kept := session.Recap.TopRequesters[:0]
+			for _, r := range session.Recap.TopRequesters {
+				if r.Username != username {
+					kept = append(kept, r)
+				}
+			}
+			if len(kept) == len(session.Recap.TopRequesters) {
+				continue
+			}
+			session.Recap.TopRequesters = kept
+			if err := tx.Model(&session).Select("recap").Updates(&session).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CountAdmins 返回管理员人数
+func (db *DB) CountAdmins() (int64, error) {
+	return countAdmins(db.DB)
+}
+
+func countAdmins(tx *gorm.DB) (int64, error) {
+	var count int64
+	err := tx.Model(&User{}).Where("role = ?", RoleAdmin).Count(&count).Error
+	return count, err
+}
+
+// GetPlayHistoryRequestedBy 返回某个用户点播的歌曲的播放记录，最早的在前
+func (db *DB) GetPlayHistoryRequestedBy(username string) ([]PlayHistory, error) {
+	var history []PlayHistory
+	err := db.Where("requested_by = ?", username).Order("played_at").Find(&history).Error
+	return history, err
+}
+
+// GetSavedPlaylistsCreatedBy 返回某个用户创建的命名歌单及其歌曲 ID
+func (db *DB) GetSavedPlaylistsCreatedBy(username string) ([]SavedPlaylist, error) {
+	var playlists []SavedPlaylist
+	err := db.Preload("Songs", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("position")
+	}).Where("created_by = ?", username).Order("name").Find(&playlists).Error
+	return playlists, err
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 分析任务的状态
+const (
+	AnalysisPending = "pending"
+	AnalysisLeased  = "leased"
+	AnalysisDone    = "done"
+	AnalysisFailed  = "failed"
+)
+
+// AnalysisTask 一首歌的外部分析任务，由 worker 领取，租约到期仍未回传结果时重新派发
+type AnalysisTask struct {
+	ID     uint   `gorm:"primaryKey" json:"id"`
+	SongID string `gorm:"not null;index" json:"song_id"`
+	Status string `gorm:"not null;index" json:"status"`
+	// Worker 最近一次领取任务的 worker 名称
+	Worker   string `json:"worker,omitempty"`
+	Attempts int    `gorm:"not null;default:0" json:"attempts"`
+	// LeaseExpiresAt 租约到期时间，只在 leased 状态下有值
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"`
+	// Error 最近一次失败的原因
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// EnqueueAnalysis 为歌曲创建分析任务，已有未完成任务的歌曲跳过，返回新建的任务数
+func (db *DB) EnqueueAnalysis(songIDs []string) (int, error) {
+	created := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, id := range songIDs {
+			var count int64
+			if err := tx.Model(&AnalysisTask{}).Where("song_id = ? AND status IN ?", id, []string{AnalysisPending, AnalysisLeased}).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+			if err := tx.Create(&AnalysisTask{SongID: id, Status: AnalysisPending}).Error; err != nil {
+				return err
+			}
+			created++
+		}
+		return nil
+	})
+	return created, err
+}
+
+// SongIDsWithoutAnalysis 返回还没有 BPM、也没有未完成分析任务的歌曲
+func (db *DB) SongIDsWithoutAnalysis() ([]string, error) {
+	open := db.Model(&AnalysisTask{}).Select("song_id").Where("status IN ?", []string{AnalysisPending, AnalysisLeased})
+	var ids []string
+	err := db.Model(&Song{}).Where("bpm = 0 AND id NOT IN (?)", open).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// LeaseAnalysisTasks 为 worker 领取最多 limit 个待处理任务，租期为 lease
+// 租约已过期的任务先放回队列，派发次数达到 maxAttempts 的标记为失败
+func (db *DB) LeaseAnalysisTasks(worker string, limit int, lease time.Duration, maxAttempts int) ([]AnalysisTask, error) {
+	var tasks []AnalysisTask
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		expired := tx.Model(&AnalysisTask{}).Where("status = ? AND lease_expires_at < ?", AnalysisLeased, now)
+		if err := expired.Session(&gorm.Session{}).Where("attempts >= ?", maxAttempts).
+			Updates(map[string]interface{}{"status": AnalysisFailed, "lease_expires_at": nil, "error": "lease expired"}).Error; err != nil {
+			return err
+		}
+		if err := expired.Session(&gorm.Session{}).
+			Updates(map[string]interface{}{"status": AnalysisPending, "lease_expires_at": nil, "error": "lease expired"}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("status = ?", AnalysisPending).Order("id").Limit(limit).Find(&tasks).Error; err != nil {
+			return err
+		}
+		expires := now.Add(lease)
+		for i := range tasks {
+			tasks[i].Status = AnalysisLeased
+			tasks[i].Worker = worker
+			tasks[i].Attempts++
+			tasks[i].LeaseExpiresAt = &expires
+			if err := tx.Save(&tasks[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return tasks, err
+}
+
+// GetAnalysisTask 按 ID 查询分析任务
+func (db *DB) GetAnalysisTask(id uint) (*AnalysisTask, error) {
+	var task AnalysisTask
+	if err := db.First(&task, id).Error; err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// FinishAnalysisTask 任务已回传结果
+func (db *DB) FinishAnalysisTask(id uint) error {
+	return db.Model(&AnalysisTask{ID: id}).Updates(map[string]interface{}{"status": AnalysisDone, "lease_expires_at": nil, "error": ""}).Error
+}
+
+// FailAnalysisTask worker 报告分析失败，派发次数未达到 maxAttempts 时放回队列重试，返回任务的新状态
+// maxAttempts 为 0 时直接标记为失败
+func (db *DB) FailAnalysisTask(id uint, reason string, maxAttempts int) (string, error) {
+	task, err := db.GetAnalysisTask(id)
+	if err != nil {
+		return "", err
+	}
+	status := AnalysisPending
+	if task.Attempts >= maxAttempts {
+		status = AnalysisFailed
+	}
+	err = db.Model(task).Updates(map[string]interface{}{"status": status, "lease_expires_at": nil, "error": reason}).Error
+	return status, err
+}
+
+// CountAnalysisTasks 返回各状态的任务数
+func (db *DB) CountAnalysisTasks() (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	err := db.Model(&AnalysisTask{}).Select("status, COUNT(*) AS count").Group("status").Scan(&rows).Error
+	counts := map[string]int64{AnalysisPending: 0, AnalysisLeased: 0, AnalysisDone: 0, AnalysisFailed: 0}
+	for _, r := range rows {
+		counts[r.Status] = r.Count
+	}
+	return counts, err
+}
+
+// GetFailedAnalysisTasks 返回最近失败的任务，最近的在前
+func (db *DB) GetFailedAnalysisTasks(limit int) ([]AnalysisTask, error) {
+	var tasks []AnalysisTask
+	err := db.Where("status = ?", AnalysisFailed).Order("updated_at DESC").Limit(limit).Find(&tasks).Error
+	return tasks, err
+}
+
+// SetSongAnalysis 保存外部分析的结果，updates 的键为列名
+func (db *DB) SetSongAnalysis(songID string, updates map[string]interface{}) error {
+	result := db.Model(&Song{}).Where("id = ?", songID).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
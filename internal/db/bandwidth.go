@@ -0,0 +1,108 @@
+package db
+
+import (
+	"gorm.io/gorm"
+)
+
+// BandwidthMonthLayout 流量按自然月统计，月份的格式
+const BandwidthMonthLayout = "2006-01"
+
+// BandwidthUsage 一个月内某首歌发送给某个用户的媒体流量
+// Username 为空表示请求没有带凭证（或用户已删除），SongID 为空表示找不到对应的歌曲
+type BandwidthUsage struct {
+	ID       uint   `gorm:"primaryKey" json:"-"`
+	Month    string `gorm:"not null;index:idx_bandwidth_usage" json:"month"`
+	SongID   string `gorm:"index:idx_bandwidth_usage" json:"song_id"`
+	Username string `gorm:"index:idx_bandwidth_usage;index" json:"username"`
+	Bytes    int64  `gorm:"not null" json:"bytes"`
+}
+
+// SongBandwidth 一首歌在一个月内的流量，歌曲已被永久删除时标题和歌手为空
+type SongBandwidth struct {
+	SongID string `json:"song_id"`
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// UserBandwidth 一个用户在一个月内的流量
+type UserBandwidth struct {
+	Username string `json:"username"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// AddBandwidthUsage 把一批流量累加到已有的记录上，没有记录时新建
+func (db *DB) AddBandwidthUsage(usages []BandwidthUsage) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, usage := range usages {
+			// 删除用户时用户名被清空，同一组可能有多条记录，累加到其中一条上
+			var ids []uint
+			err := tx.Model(&BandwidthUsage{}).
+				Where("month = ? AND song_id = ? AND username = ?", usage.Month, usage.SongID, usage.Username).
+				Limit(1).
+				Pluck("id", &ids).Error
+			if err != nil {
+				return err
+			}
+			if len(ids) > 0 {
+				if err := tx.Model(&BandwidthUsage{}).Where("id = ?", ids[0]).Update("bytes", gorm.Expr("bytes + ?", usage.Bytes)).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			usage.ID = 0
+			if err := tx.Create(&usage).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetMonthBandwidth 返回一个月的总流量
+func (db *DB) GetMonthBandwidth(month string) (int64, error) {
+	var total int64
+	err := db.Model(&BandwidthUsage{}).Where("month = ?", month).Select("COALESCE(SUM(bytes), 0)").Scan(&total).Error
+	return total, err
+}
+
+// GetUserBandwidth 返回一个月内每个用户的流量，最多的在前，未带凭证的请求合计为用户名为空的一项
+func (db *DB) GetUserBandwidth(month string) ([]UserBandwidth, error) {
+	var users []UserBandwidth
+	err := db.Model(&BandwidthUsage{}).
+		Select("username, SUM(bytes) AS bytes").
+		Where("month = ?", month).
+		Group("username").
+		Order("bytes DESC").
+		Scan(&users).Error
+	return users, err
+}
+
+// GetSongBandwidth 返回一个月内流量最多的 limit 首歌
+func (db *DB) GetSongBandwidth(month string, limit int) ([]SongBandwidth, error) {
+	var songs []SongBandwidth
+	err := db.Table("bandwidth_usages AS b").
+		Select("b.song_id, COALESCE(s.title, '') AS title, COALESCE(s.artist, '') AS artist, SUM(b.bytes) AS bytes").
+		Joins("LEFT JOIN songs s ON s.id = b.song_id").
+		Where("b.month = ?", month).
+		Group("b.song_id").
+		Order("bytes DESC").
+		Limit(limit).
+		Scan(&songs).Error
+	return songs, err
+}
+
+// GetSongIDByMediaPath 返回播放文件（或 HLS 目录）对应的歌曲 ID，包括回收站中的歌曲
+// 内容相同的歌曲共享播放文件，此时返回其中最早添加的一首；找不到时返回空字符串
+func (db *DB) GetSongIDByMediaPath(mediaPath string) (string, error) {
+	var ids []string
+	err := db.Unscoped().Model(&Song{}).
+		Where("file_path = ? OR file_path LIKE ?", mediaPath, mediaPath+"/%").
+		Order("rowid").
+		Limit(1).
+		Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return "", err
+	}
+	return ids[0], nil
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"time"
+)
+
+// SongComment 用户对一首歌的短评，显示在歌曲详情中；Flash 为 true 时歌曲开始播放时向客户端闪现
+// 管理员可以隐藏不当的评论，隐藏的评论只有管理员能看到
+type SongComment struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	SongID    string     `gorm:"not null;index" json:"song_id"`
+	Text      string     `gorm:"not null" json:"text"`
+	Author    string     `gorm:"index" json:"author"`
+	Flash     bool       `gorm:"not null;default:false" json:"flash"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	Hidden    bool       `gorm:"not null;default:false;index" json:"hidden"`
+	HiddenBy  string     `json:"hidden_by,omitempty"`
+	HiddenAt  *time.Time `json:"hidden_at,omitempty"`
+}
+
+// AddSongComment 保存一条评论
+func (db *DB) AddSongComment(comment *SongComment) error {
+	return db.Create(comment).Error
+}
+
+// GetSongComments 返回一首歌的评论，最早的在前，includeHidden 为 false 时不含被隐藏的
+func (db *DB) GetSongComments(songID string, includeHidden bool) ([]SongComment, error) {
+	var comments []SongComment
+	query := db.Where("song_id = ?", songID).Order("created_at, id")
+	if !includeHidden {
+		query = query.Where("hidden = ?", false)
+	}
+	err := query.Find(&comments).Error
+	return comments, err
+}
+
+// GetFlashComments 返回一首歌开始播放时要闪现的评论
+func (db *DB) GetFlashComments(songID string) ([]SongComment, error) {
+	var comments []SongComment
+	err := db.Where("song_id = ? AND flash = ? AND hidden = ?", songID, true, false).Order("created_at, id").Find(&comments).Error
+	return comments, err
+}
+
+// GetRecentComments 返回最近的评论，包括被隐藏的，最新的在前，供管理员审核
+func (db *DB) GetRecentComments(limit int) ([]SongComment, error) {
+	var comments []SongComment
+	err := db.Order("created_at DESC, id DESC").Limit(limit).Find(&comments).Error
+	return comments, err
+}
+
+// GetCommentsByAuthor 返回某个用户写的评论，最早的在前
+func (db *DB) GetCommentsByAuthor(username string) ([]SongComment, error) {
+	var comments []SongComment
+	err := db.Where("author = ?", username).Order("created_at, id").Find(&comments).Error
+	return comments, err
+}
+
+// GetSongComment 按 ID 查找评论
+func (db *DB) GetSongComment(id uint) (*SongComment, error) {
+	var comment SongComment
+	if err := db.First(&comment, id).Error; err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// SetCommentHidden 隐藏或恢复一条评论，by 为操作的管理员
+func (db *DB) SetCommentHidden(id uint, hidden bool, by string) error {
+	updates := map[string]interface{}{"hidden": hidden, "hidden_by": "", "hidden_at": nil}
+	if hidden {
+		updates["hidden_by"] = by
+		updates["hidden_at"] = time.Now()
+	}
+	return db.Model(&SongComment{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// DeleteSongComment 删除一条评论
+func (db *DB) DeleteSongComment(id uint) error {
+	return db.Delete(&SongComment{}, id).Error
+}
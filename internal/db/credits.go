@@ -0,0 +1,223 @@
+package db
+
+import (
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// VariousArtists 合辑统一使用的专辑歌手
+const VariousArtists = "Various Artists"
+
+// variousArtistsKeys 常见的群星写法
+var variousArtistsKeys = map[string]bool{
+	"various artists": true,
+	"various":         true,
+	"va":              true,
+	"v.a.":            true,
+	"v/a":             true,
+	"群星":              true,
+}
+
+// 署名角色
+const (
+	CreditPrimary  = "primary"
+	CreditFeatured = "featured"
+)
+
+// Artist 歌手，同一位歌手的不同写法（大小写、多余空格）通过 Key 归为一条
+type Artist struct {
+	ID   uint   `gorm:"primaryKey" json:"id"`
+	Name string `gorm:"not null" json:"name"`
+	Key  string `gorm:"column:name_key;not null;uniqueIndex" json:"-"`
+}
+
+// SongCredit 歌曲与歌手的关联，一首歌可以有多位主唱和合作歌手
+type SongCredit struct {
+	ID       uint    `gorm:"primaryKey" json:"-"`
+	SongID   string  `gorm:"not null;index" json:"-"`
+	ArtistID uint    `gorm:"not null;index" json:"artist_id"`
+	Role     string  `gorm:"not null" json:"role"`
+	Position int     `json:"position"`
+	Artist   *Artist `gorm:"foreignKey:ArtistID" json:"artist,omitempty"`
+}
+
+var (
+	// featPattern 匹配歌手标签中的 "feat. X"、"ft X"、"featuring X"，可以带括号
+	featPattern = regexp.MustCompile(`(?i)\s*[(\[]?\s*\b(?:feat\.?|ft\.?|featuring)\s+([^)\]]+)[)\]]?\s*$`)
+	// titleFeatPattern 标题中只识别带括号的写法，避免误伤歌名本身
+	titleFeatPattern = regexp.MustCompile(`(?i)\s*[(\[]\s*(?:feat\.?|ft\.?|featuring)\s+([^)\]]+)[)\]]\s*$`)
+	// primarySeparator 多值标签的分隔符，逗号和 & 常出现在乐队名中，不作为主唱分隔符
+	primarySeparator = regexp.MustCompile(`\s*;\s*|\s+/\s+`)
+	// featuredSeparator 合作歌手列表的分隔符
+	featuredSeparator = regexp.MustCompile(`(?i)\s*,\s*|\s+&\s+|\s+and\s+`)
+)
+
+// ArtistKey 歌手名的规范形式，用于合并不同写法
+func ArtistKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// ParseCredits 从歌手和标题标签中拆出主唱和合作歌手，返回去掉 feat. 部分的标题
+func ParseCredits(artist, title string) (primary, featured []string, cleanTitle string) {
+	cleanTitle = strings.TrimSpace(title)
+	if m := titleFeatPattern.FindStringSubmatchIndex(cleanTitle); m != nil && m[0] > 0 {
+		featured = append(featured, featuredSeparator.Split(cleanTitle[m[2]:m[3]], -1)...)
+		cleanTitle = strings.TrimSpace(cleanTitle[:m[0]])
+	}
+	artist = strings.TrimSpace(artist)
+	if m := featPattern.FindStringSubmatchIndex(artist); m != nil && m[0] > 0 {
+		featured = append(featuredSeparator.Split(artist[m[2]:m[3]], -1), featured...)
+		artist = artist[:m[0]]
+	}
+	seen := make(map[string]bool)
+	add := func(names []string) []string {
+		var result []string
+		for _, name := range names {
+			name = strings.Join(strings.Fields(name), " ")
+			key := ArtistKey(name)
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			result = append(result, name)
+		}
+		return result
+	}
+	primary = add(primarySeparator.Split(artist, -1))
+	featured = add(featured)
+	return primary, featured, cleanTitle
+}
+
+// FormatArtist 按统一格式拼出歌手字段，例如 "A / B feat. C, D"
+func FormatArtist(primary, featured []string) string {
+	artist := strings.Join(primary, " / ")
+	if len(featured) > 0 {
+		if artist != "" {
+			artist += " "
+		}
+		artist += "feat. " + strings.Join(featured, ", ")
+	}
+	return artist
+}
+
+// NormalizeArtist 统一歌手字段中 feat./ft. 等写法
+func NormalizeArtist(artist string) string {
+	primary, featured, _ := ParseCredits(artist, "")
+	return FormatArtist(primary, featured)
+}
+
+// AlbumArtistTag 统一专辑歌手的写法，专辑歌手是群星的各种写法时视为合辑
+func AlbumArtistTag(albumArtist string, compilation bool) (string, bool) {
+	if variousArtistsKeys[ArtistKey(albumArtist)] {
+		return VariousArtists, true
+	}
+	return NormalizeArtist(albumArtist), compilation
+}
+
+// AlbumGroupArtist 专辑分组使用的歌手：优先使用专辑歌手标签，没有时合辑为群星，否则为主唱
+func (s *Song) AlbumGroupArtist() string {
+	if s.AlbumArtist != "" {
+		return s.AlbumArtist
+	}
+	if s.Compilation {
+		return VariousArtists
+	}
+	return s.PrimaryArtist()
+}
+
+// normalizeSongCredits 统一歌手和标题的写法，标题中的合作歌手移到歌手字段
+func normalizeSongCredits(song *Song) (primary, featured []string) {
+	primary, featured, title := ParseCredits(song.Artist, song.Title)
+	if title != "" {
+		song.Title = title
+	}
+	song.Artist = FormatArtist(primary, featured)
+	song.AlbumArtist, song.Compilation = AlbumArtistTag(song.AlbumArtist, song.Compilation)
+	return primary, featured
+}
+
+// saveCredits 替换歌曲的署名，不存在的歌手会被创建
+func saveCredits(tx *gorm.DB, song *Song, primary, featured []string) error {
+	if err := tx.Delete(&SongCredit{}, "song_id = ?", song.ID).Error; err != nil {
+		return err
+	}
+	song.Credits = nil
+	for i, name := range append(append([]string(nil), primary...), featured...) {
+		artist := Artist{Name: name, Key: ArtistKey(name)}
+		if err := tx.Where(Artist{Key: artist.Key}).FirstOrCreate(&artist).Error; err != nil {
+			return err
+		}
+		role := CreditPrimary
+		if i >= len(primary) {
+			role = CreditFeatured
+		}
+		credit := SongCredit{SongID: song.ID, ArtistID: artist.ID, Role: role, Position: i}
+		if err := tx.Create(&credit).Error; err != nil {
+			return err
+		}
+		credit.Artist = &artist
+		song.Credits = append(song.Credits, credit)
+	}
+	return nil
+}
+
+// preloadCredits 按署名顺序预加载歌手
+func preloadCredits(tx *gorm.DB) *gorm.DB {
+	return tx.Order("position").Preload("Artist")
+}
+
+// CreditedTo 判断歌曲是否署名了该歌手（主唱或合作），不区分大小写和多余空格
+func (s *Song) CreditedTo(name string) bool {
+	key := ArtistKey(name)
+	if len(s.Credits) == 0 {
+		return ArtistKey(s.Artist) == key
+	}
+	for _, credit := range s.Credits {
+		if credit.Artist != nil && credit.Artist.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// PrimaryArtist 主唱署名，不含合作歌手，用于专辑分组
+func (s *Song) PrimaryArtist() string {
+	var names []string
+	for _, credit := range s.Credits {
+		if credit.Role == CreditPrimary && credit.Artist != nil {
+			names = append(names, credit.Artist.Name)
+		}
+	}
+	if len(names) == 0 {
+		primary, _, _ := ParseCredits(s.Artist, "")
+		names = primary
+	}
+	return strings.Join(names, " / ")
+}
+
+// backfillCredits 为升级前入库、还没有署名的歌曲生成署名，回收站中的歌曲也一并处理
+func (db *DB) backfillCredits() error {
+	var songs []Song
+	err := db.Unscoped().
+		Where("NOT EXISTS (SELECT 1 FROM song_credits WHERE song_credits.song_id = songs.id)").
+		Find(&songs).Error
+	if err != nil || len(songs) == 0 {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for i := range songs {
+			song := &songs[i]
+			primary, featured := normalizeSongCredits(song)
+			if err := tx.Unscoped().Model(&Song{ID: song.ID}).
+				Updates(map[string]interface{}{"title": song.Title, "artist": song.Artist, "album_artist": song.AlbumArtist, "compilation": song.Compilation}).Error; err != nil {
+				return err
+			}
+			if err := saveCredits(tx, song, primary, featured); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
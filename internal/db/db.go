@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"golang.org/x/crypto/bcrypt"
 	"log"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/glebarez/sqlite"
@@ -16,32 +18,207 @@ import (
 
 // Song 歌曲模型
 type Song struct {
-	ID         string `gorm:"primaryKey;type:text" json:"id"` // 对应原代码 ID TEXT PRIMARY KEY
-	Title      string `gorm:"not null" json:"title"`
-	Artist     string `json:"artist"`
-	Album      string `json:"album"`
+	ID     string `gorm:"primaryKey;type:text" json:"id"` // 对应原代码 ID TEXT PRIMARY KEY
+	Title  string `gorm:"not null" json:"title"`
+	Artist string `json:"artist"`
+	Album  string `json:"album"`
+	// AlbumArtist 专辑歌手标签，为空时专辑按主唱分组
+	AlbumArtist string `json:"album_artist,omitempty"`
+	// Compilation 合辑（群星专辑），同一张合辑中不同歌手的歌曲归为一张专辑
+	Compilation bool   `gorm:"not null;default:false" json:"compilation,omitempty"`
+	Genre       string `json:"genre"`
+	// Mood 情绪标签（例如 chill、energetic），来自 mood 标签或自动分类
+	Mood string `json:"mood,omitempty"`
+	// GenreConfidence 和 MoodConfidence 自动分类的置信度（0-1），0 表示来自文件标签或手动设置
+	GenreConfidence float64 `gorm:"not null;default:0" json:"genre_confidence,omitempty"`
+	MoodConfidence  float64 `gorm:"not null;default:0" json:"mood_confidence,omitempty"`
+	// ManualTags 流派或情绪经过手动修改，自动分类和外部分析不再改动
+	ManualTags bool `gorm:"not null;default:false" json:"manual_tags,omitempty"`
+	// Year 发行年份，来自 date/year 标签，0 表示未知
+	Year       int    `gorm:"not null;default:0;index" json:"year,omitempty"`
 	DurationMs int    `json:"duration_ms"`
 	Source     string `json:"source"`
-	FilePath   string `gorm:"not null;unique" json:"-"` // unique 对应原代码 UNIQUE
+	Explicit   bool   `gorm:"not null;default:false" json:"explicit"` // 来自标签或手动标记
+	// Jingle 台标（电台呼号之类的短音频），按房间设置自动穿插在歌曲之间，不进入播放列表
+	Jingle   bool   `gorm:"not null;default:false;index" json:"jingle,omitempty"`
+	FilePath string `gorm:"not null;index" json:"-"` // 内容相同的歌曲共享同一个播放文件
+	// ContentHash 原始上传文件的 SHA-256，用于在不同实例之间识别同一首歌
+	ContentHash string `gorm:"index" json:"content_hash,omitempty"`
+	// StreamURL 不在本地的歌曲（镜像或引用远程实例时）的 HLS 地址，不入库
+	StreamURL string `gorm:"-" json:"stream_url,omitempty"`
+	// DirectURL 没有 ffmpeg 时未转码的歌曲直接播放的原始文件地址，不入库
+	DirectURL string `gorm:"-" json:"direct_url,omitempty"`
+	// HLSURL 本地转码歌曲的 HLS 索引地址，播放文件按内容哈希存放，不能由歌曲 ID 推出，不入库
+	HLSURL string `gorm:"-" json:"hls_url,omitempty"`
+	// SourcePath 原地引用（例如 NAS 挂载）的源文件绝对路径，为空表示文件已转换到媒体目录
+	SourcePath string `gorm:"index" json:"-"`
+	// Unavailable 源文件缺失或 HLS 缓存尚未生成，暂时不能播放
+	Unavailable bool `gorm:"not null;default:false" json:"unavailable,omitempty"`
+	// BPM 和 MusicalKey 来自标签或音频分析，用于自动 DJ 挑选节奏和调性相近的下一首；
+	// BPM 为 0、MusicalKey 为空表示未知，MusicalKey 使用 Camelot 记法（例如 8A）
+	BPM        float64 `gorm:"not null;default:0" json:"bpm,omitempty"`
+	MusicalKey string  `json:"musical_key,omitempty"`
+	// Fingerprint 外部分析得到的 Chromaprint 指纹，用于识别重复歌曲和查询 AcoustID
+	Fingerprint string `json:"-"`
+	// GainDb 播放时建议的音量补偿（ReplayGain，参考响度 -18 LUFS），为空表示尚未分析，客户端据此统一音量
+	GainDb *float64 `json:"gain_db,omitempty"`
+	// 来源记录：上传时的原始文件名（已规范化）、上传者和时间，从网址导入时还有下载地址
+	// 更早入库的歌曲没有这些信息
+	OriginalFilename string     `json:"original_filename,omitempty"`
+	UploadedBy       string     `gorm:"index" json:"uploaded_by,omitempty"`
+	UploadedAt       *time.Time `json:"uploaded_at,omitempty"`
+	SourceURL        string     `json:"source_url,omitempty"`
+	// Technical 入库时记录的编码、码率等技术信息，只在歌曲详情中返回，更早入库的歌曲为空
+	Technical *TechnicalInfo `gorm:"serializer:json" json:"-"`
+	// DeletedAt 移入回收站的时间，回收站中的歌曲不出现在查询结果里，过了保留期才真正删除
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// 章节标记，按 Index 排序
+	Chapters []Chapter `gorm:"foreignKey:SongID;references:ID" json:"chapters,omitempty"`
+	// 播放时自动跳过的区间（长静音、口播开场等），按开始时间排序
+	SkipRegions []SkipRegion `gorm:"foreignKey:SongID;references:ID" json:"skip_regions,omitempty"`
+	// 主唱和合作歌手署名，入库时由 Artist 和标题中的 feat. 拆分得到，按 Position 排序
+	Credits []SongCredit `gorm:"foreignKey:SongID;references:ID" json:"credits,omitempty"`
+}
+
+// AfterFind 文件路径是远程地址时（引用远程实例的歌曲）直接用它作为播放地址
+func (s *Song) AfterFind(tx *gorm.DB) error {
+	s.fillURLs()
+	return nil
+}
+
+// AfterCreate 入库后同样补齐播放地址，上传接口直接返回刚创建的歌曲
+func (s *Song) AfterCreate(tx *gorm.DB) error {
+	s.fillURLs()
+	return nil
+}
+
+// fillURLs 远程歌曲使用远程地址；本地文件通过静态目录提供，HLS 索引和未转码的原始文件分别放在 HLSURL 和 DirectURL
+func (s *Song) fillURLs() {
+	s.DirectURL = ""
+	s.HLSURL = ""
+	switch {
+	case strings.HasPrefix(s.FilePath, "http://") || strings.HasPrefix(s.FilePath, "https://"):
+		s.StreamURL = s.FilePath
+	case strings.HasSuffix(s.FilePath, ".m3u8"):
+		s.HLSURL = "/static/audio/" + s.FilePath
+	case s.FilePath != "":
+		s.DirectURL = "/static/audio/" + s.FilePath
+	}
+}
+
+// PlayURL 客户端播放歌曲使用的地址，与前端选择地址的顺序一致：远程地址、原始文件、HLS 索引，都没有时按歌曲 ID 推出旧版的 HLS 目录
+func (s *Song) PlayURL() string {
+	switch {
+	case s.StreamURL != "":
+		return s.StreamURL
+	case s.DirectURL != "":
+		return s.DirectURL
+	case s.HLSURL != "":
+		return s.HLSURL
+	}
+	return "/static/audio/" + s.ID + "/index.m3u8"
+}
+
+// Chapter 章节模型，来自 ffprobe -show_chapters，用于混音、有声书等长音轨
+type Chapter struct {
+	ID      int    `gorm:"primaryKey;autoIncrement" json:"-"`
+	SongID  string `gorm:"not null;index" json:"-"`
+	Index   int    `gorm:"column:chapter_index" json:"index"` // index 是 SQL 关键字，换个列名
+	Title   string `json:"title"`
+	StartMs int64  `json:"start_ms"`
+	EndMs   int64  `json:"end_ms"`
+}
+
+// SkipRegion 歌曲中自动跳过的区间，由 DJ 标记
+type SkipRegion struct {
+	ID      int    `gorm:"primaryKey;autoIncrement" json:"-"`
+	SongID  string `gorm:"not null;index" json:"-"`
+	StartMs int64  `json:"start_ms"`
+	EndMs   int64  `json:"end_ms"`
+	Label   string `json:"label,omitempty"`
 }
 
 // PlaylistItem 播放列表项模型
 type PlaylistItem struct {
 	ID     int    `gorm:"primaryKey;autoIncrement" json:"id"`
-	SongID string `gorm:"not null;index" json:"song_id"`  // 外键
-	Order  int    `gorm:"column:item_order" json:"order"` // item_order 对应原代码 item_order
+	SongID string `gorm:"not null;index" json:"song_id"` // 外键
+	// Order 只保证相对顺序，值之间留有间隔，插入/移动时只需改动单行
+	Order int `gorm:"column:item_order" json:"order"` // item_order 对应原代码 item_order
+	// AddedBy 点歌的用户名，用于公平性限制
+	AddedBy   string    `gorm:"index" json:"added_by"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	// EstimatedStartAt 预计开始播放的时间，只出现在状态快照中，不入库
+	EstimatedStartAt *time.Time `gorm:"-" json:"estimated_start_at,omitempty"`
 
 	// 关联关系：属于 Song，外键是 SongID，引用 Song 的 ID
 	// OnDelete:CASCADE 对应原代码 FOREIGN KEY... ON DELETE CASCADE
 	Song *Song `gorm:"foreignKey:SongID;references:ID;constraint:OnDelete:CASCADE" json:"song,omitempty"`
 }
 
+// 歌曲来源，Song.Source 的取值；更早入库的歌曲为空，按本地上传处理
+const (
+	// SourceLocal 上传的文件
+	SourceLocal = "local"
+	// SourceURL 从网址下载导入
+	SourceURL = "url"
+	// SourceReference 原地引用的本地文件（例如 NAS 挂载）
+	SourceReference = "reference"
+	// SourceRemote 引用远程实例的歌曲
+	SourceRemote = "remote"
+)
+
+// ValidSource 判断来源是否是已知的取值
+func ValidSource(source string) bool {
+	switch source {
+	case SourceLocal, SourceURL, SourceReference, SourceRemote:
+		return true
+	}
+	return false
+}
+
+// 用户角色
+const (
+	RoleAdmin = "admin"
+	RoleDJ    = "dj"
+	RoleUser  = "user"
+	// RoleGuest 通过派对加入链接进入的临时访客，不对应数据库中的用户，不能通过 SetUserRole 授予
+	RoleGuest = "guest"
+)
+
+// GuestPrefix 访客用户名的前缀，注册时禁止使用，避免访客冒充已注册用户
+const GuestPrefix = "~"
+
+// IsGuestUsername 判断用户名是否属于临时访客
+func IsGuestUsername(username string) bool {
+	return strings.HasPrefix(username, GuestPrefix)
+}
+
+// ValidRole 判断角色名是否合法
+func ValidRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleDJ, RoleUser:
+		return true
+	}
+	return false
+}
+
 // User 用户模型
 type User struct {
 	ID           uint      `gorm:"primaryKey"`
 	Username     string    `gorm:"unique;not null"`
 	PasswordHash string    `gorm:"not null"`
+	Role         string    `gorm:"not null;default:user"`
 	CreatedAt    time.Time `gorm:"autoCreateTime"`
+	// Language 服务端消息使用的语言（en、zh），为空表示按浏览器的 Accept-Language
+	Language string
+	// OIDCSubject 单点登录关联的身份提供方用户 ID（sub），为空表示本地账号
+	OIDCSubject string `gorm:"column:oidc_subject;index"`
+}
+
+// IsAdmin 判断用户是否为管理员
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
 }
 
 // SetPassword 哈希并设置密码
@@ -60,12 +237,70 @@ func (u *User) CheckPassword(password string) bool {
 	return err == nil
 }
 
-//// Token 认证模型
-//type Token struct {
-//	Token     string    `gorm:"primaryKey" json:"token"`
-//	IsActive  bool      `gorm:"not null;default:true" json:"is_active"`
-//	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"` // 对应 DEFAULT CURRENT_TIMESTAMP
-//}
+// Token 自动化触发地址使用的静态令牌，由 token-cli 生成和停用，IFTTT、Zapier 和智能按钮凭它调用 /api/trigger
+type Token struct {
+	Token    string `gorm:"primaryKey" json:"token"`
+	IsActive bool   `gorm:"not null;default:true" json:"is_active"`
+	// Username 令牌代表的用户，操作按该用户的角色和额度执行；为空时代表服务器管理员
+	Username string `json:"username,omitempty"`
+	// PlaylistID queue 动作默认加入的歌单，为 0 时需要在地址中指定
+	PlaylistID uint      `json:"playlist_id,omitempty"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"` // 对应 DEFAULT CURRENT_TIMESTAMP
+}
+
+// PlayHistory 播放历史，每次切换到一首歌时记录一条
+type PlayHistory struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	SongID      string    `gorm:"not null;index" json:"song_id"`
+	RequestedBy string    `json:"requested_by"` // 点歌用户，自动播放时可能为空
+	PlayedAt    time.Time `gorm:"not null;index" json:"played_at"`
+	// SessionID 播放时正在进行的派对，不在派对中时为空
+	SessionID *uint `gorm:"index" json:"session_id,omitempty"`
+}
+
+// PartySession 一场派对，开始到结束之间的播放历史归属于它，结束时生成回顾
+type PartySession struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	Name      string     `json:"name"`
+	StartedBy string     `json:"started_by"`
+	StartedAt time.Time  `gorm:"not null;index" json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	// Recap 结束时生成并保存，之后歌曲被删除也不影响回顾
+	Recap *SessionRecap `gorm:"serializer:json" json:"recap,omitempty"`
+}
+
+// SessionRecap 派对回顾
+type SessionRecap struct {
+	Plays int `json:"plays"`
+	// TotalListeningMs 实际播放的总时长，被跳过的歌曲只计算到切歌为止
+	TotalListeningMs int64            `json:"total_listening_ms"`
+	TopSongs         []SongPlayCount  `json:"top_songs"`
+	TopRequesters    []RequesterCount `json:"top_requesters"`
+}
+
+// SongPlayCount 一首歌在派对中的播放次数
+type SongPlayCount struct {
+	SongID string `json:"song_id"`
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+	Plays  int    `json:"plays"`
+}
+
+// RequesterCount 一位用户点的歌被播放的次数
+type RequesterCount struct {
+	Username string `json:"username"`
+	Plays    int    `json:"plays"`
+}
+
+// BlocklistEntry 黑名单条目，可以按歌曲 ID 或歌手模式（不区分大小写，支持 * 通配）屏蔽
+type BlocklistEntry struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	SongID        string    `gorm:"index" json:"song_id,omitempty"`
+	ArtistPattern string    `json:"artist_pattern,omitempty"`
+	Reason        string    `json:"reason"`
+	CreatedBy     string    `json:"created_by"`
+	CreatedAt     time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
 
 // SystemState 系统状态模型
 type SystemState struct {
@@ -73,6 +308,26 @@ type SystemState struct {
 	Value string `json:"value"`
 }
 
+// SavedPlaylist 命名歌单，与正在播放的播放列表（PlaylistItem）相互独立，可以整体加入播放列表
+type SavedPlaylist struct {
+	ID        uint                `gorm:"primaryKey" json:"id"`
+	Name      string              `gorm:"not null" json:"name"`
+	CreatedBy string              `json:"created_by"`
+	CreatedAt time.Time           `gorm:"autoCreateTime" json:"created_at"`
+	Songs     []SavedPlaylistSong `gorm:"foreignKey:PlaylistID;constraint:OnDelete:CASCADE" json:"songs"`
+	// Rules 不为空表示智能歌单，不保存歌曲，加入播放列表时按条件选歌
+	Rules *SmartRules `gorm:"serializer:json" json:"rules,omitempty"`
+}
+
+// SavedPlaylistSong 命名歌单中的一首歌
+type SavedPlaylistSong struct {
+	ID         uint   `gorm:"primaryKey" json:"-"`
+	PlaylistID uint   `gorm:"not null;index" json:"-"`
+	SongID     string `gorm:"not null;index" json:"song_id"`
+	Position   int    `json:"position"`
+	Song       *Song  `gorm:"foreignKey:SongID;references:ID" json:"song,omitempty"`
+}
+
 // --- DB 封装 ---
 
 // DB 是数据库操作的封装
@@ -95,11 +350,21 @@ func New(dataSourceName string) (*DB, error) {
 
 	// 自动迁移模式 (AutoMigrate)
 	// GORM 会自动创建表、缺失的列和索引
-	err = db.AutoMigrate(&Song{}, &PlaylistItem{}, &User{}, &SystemState{})
+	err = db.AutoMigrate(&Song{}, &Chapter{}, &SkipRegion{}, &Artist{}, &SongCredit{}, &PlaylistItem{}, &User{}, &PlayHistory{}, &BlocklistEntry{}, &SystemState{}, &SavedPlaylist{}, &SavedPlaylistSong{}, &PartySession{}, &LoginToken{}, &SongReport{}, &BandwidthUsage{}, &Token{}, &Scrobble{}, &AnalysisTask{}, &SongComment{}, &SoundClip{}, &WSTicket{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to migrate schema: %w", err)
 	}
 
+	// 旧数据库升级时还没有管理员，将最早注册的用户设为管理员
+	if err := db.ensureAdmin(); err != nil {
+		return nil, fmt.Errorf("failed to ensure admin user: %w", err)
+	}
+
+	// 旧数据库升级时歌曲还没有署名，按歌手字段补齐
+	if err := db.backfillCredits(); err != nil {
+		return nil, fmt.Errorf("failed to backfill song credits: %w", err)
+	}
+
 	return db, nil
 }
 
@@ -117,40 +382,53 @@ func (db *DB) Close() error {
 }
 
 // --- Token 操作 ---
-//
-//func (db *DB) IsTokenValid(tokenStr string) (bool, error) {
-//	var token Token
-//	// SELECT is_active FROM tokens WHERE token = ?
-//	result := db.Select("is_active").First(&token, "token = ?", tokenStr)
-//
-//	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-//		return false, nil // Token不存在
-//	}
-//	if result.Error != nil {
-//		return false, result.Error
-//	}
-//	return token.IsActive, nil
-//}
-//
-//func (db *DB) AddToken(tokenStr string) error {
-//	token := Token{Token: tokenStr, IsActive: true}
-//	// INSERT INTO tokens ...
-//	return db.Create(&token).Error
-//}
-//
-//func (db *DB) SetTokenState(tokenStr string, isActive bool) error {
-//	// UPDATE tokens SET is_active = ? WHERE token = ?
-//	return db.Model(&Token{}).Where("token = ?", tokenStr).Update("is_active", isActive).Error
-//}
+
+// GetActiveToken 返回启用中的令牌，不存在或已停用时返回 gorm.ErrRecordNotFound
+func (db *DB) GetActiveToken(tokenStr string) (*Token, error) {
+	var token Token
+	if err := db.Where("token = ? AND is_active = ?", tokenStr, true).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// GetTokens 返回全部令牌，按创建时间排列
+func (db *DB) GetTokens() ([]Token, error) {
+	var tokens []Token
+	err := db.Order("created_at").Find(&tokens).Error
+	return tokens, err
+}
+
+func (db *DB) AddToken(token *Token) error {
+	token.IsActive = true
+	// INSERT INTO tokens ...
+	return db.Create(token).Error
+}
+
+func (db *DB) SetTokenState(tokenStr string, isActive bool) error {
+	// UPDATE tokens SET is_active = ? WHERE token = ?
+	result := db.Model(&Token{}).Where("token = ?", tokenStr).Update("is_active", isActive)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
 
 // --- User 操作 ---
 
-// CreateUser 创建一个新用户
+// CreateUser 创建一个新用户，第一个注册的用户自动成为管理员
 func (db *DB) CreateUser(username, password string) (*User, error) {
-	user := &User{Username: username}
+	user := &User{Username: username, Role: RoleUser}
 	if err := user.SetPassword(password); err != nil {
 		return nil, err
 	}
+	var count int64
+	if err := db.Model(&User{}).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		user.Role = RoleAdmin
+	}
 	result := db.Create(user)
 	if result.Error != nil {
 		return nil, result.Error
@@ -168,6 +446,321 @@ func (db *DB) GetUserByUsername(username string) (*User, error) {
 	return &user, nil
 }
 
+// SetUserRole 修改用户角色
+func (db *DB) SetUserRole(username, role string) error {
+	result := db.Model(&User{}).Where("username = ?", username).Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// SetUserLanguage 修改用户的界面语言，空字符串表示跟随浏览器
+func (db *DB) SetUserLanguage(username, language string) error {
+	result := db.Model(&User{}).Where("username = ?", username).Update("language", language)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetUserByOIDCSubject 根据单点登录的 Subject 查找用户
+func (db *DB) GetUserByOIDCSubject(subject string) (*User, error) {
+	var user User
+	if err := db.Where("oidc_subject = ?", subject).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetUserOIDCSubject 把用户关联到单点登录的 Subject
+func (db *DB) SetUserOIDCSubject(username, subject string) error {
+	result := db.Model(&User{}).Where("username = ?", username).Update("oidc_subject", subject)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ensureAdmin 如果存在用户但没有管理员，则提升最早注册的用户
+func (db *DB) ensureAdmin() error {
+	var admins int64
+	if err := db.Model(&User{}).Where("role = ?", RoleAdmin).Count(&admins).Error; err != nil {
+		return err
+	}
+	if admins > 0 {
+		return nil
+	}
+	var first User
+	err := db.Order("id").First(&first).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil // 还没有任何用户
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Promoting user %s to admin", first.Username)
+	return db.Model(&first).Update("role", RoleAdmin).Error
+}
+
+// --- Play History 操作 ---
+
+// AddPlayHistory 记录一次播放，sessionID 为空表示不在派对中
+func (db *DB) AddPlayHistory(songID, requestedBy string, sessionID *uint) error {
+	return db.Create(&PlayHistory{SongID: songID, RequestedBy: requestedBy, PlayedAt: time.Now(), SessionID: sessionID}).Error
+}
+
+// LastPlayedAt 返回歌曲最近一次播放的时间，从未播放过则返回零值
+func (db *DB) LastPlayedAt(songID string) (time.Time, error) {
+	var entry PlayHistory
+	err := db.Where("song_id = ?", songID).Order("played_at DESC").First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return entry.PlayedAt, nil
+}
+
+// SongIDsPlayedSince 返回 since 之后播放过的歌曲
+func (db *DB) SongIDsPlayedSince(since time.Time) ([]string, error) {
+	var ids []string
+	err := db.Model(&PlayHistory{}).Where("played_at >= ?", since).Distinct().Pluck("song_id", &ids).Error
+	return ids, err
+}
+
+// RecentPlay 一条播放记录及对应的歌曲
+type RecentPlay struct {
+	Song        Song
+	RequestedBy string
+	PlayedAt    time.Time
+}
+
+// RecentPlays 分页返回最近的播放记录，最近的在前，歌曲已被删除的记录会被跳过
+// sessionID 不为 0 时只返回该派对中的播放
+func (db *DB) RecentPlays(offset, limit int, sessionID uint) ([]RecentPlay, error) {
+	var history []PlayHistory
+	query := db.Order("played_at DESC").Offset(offset).Limit(limit)
+	if sessionID != 0 {
+		query = query.Where("session_id = ?", sessionID)
+	}
+	if err := query.Find(&history).Error; err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, 0, len(history))
+	for _, h := range history {
+		ids = append(ids, h.SongID)
+	}
+	var songs []Song
+	if err := db.Where("id IN ?", ids).Find(&songs).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[string]Song, len(songs))
+	for _, song := range songs {
+		byID[song.ID] = song
+	}
+	plays := make([]RecentPlay, 0, len(history))
+	for _, h := range history {
+		if song, ok := byID[h.SongID]; ok {
+			plays = append(plays, RecentPlay{Song: song, RequestedBy: h.RequestedBy, PlayedAt: h.PlayedAt})
+		}
+	}
+	return plays, nil
+}
+
+// CountPlays 返回播放记录总数
+func (db *DB) CountPlays() (int64, error) {
+	var count int64
+	err := db.Model(&PlayHistory{}).Count(&count).Error
+	return count, err
+}
+
+// CountSongs 返回曲库中的歌曲数，不包括回收站
+func (db *DB) CountSongs() (int64, error) {
+	var count int64
+	err := db.Model(&Song{}).Count(&count).Error
+	return count, err
+}
+
+// CountUsers 返回注册用户总数
+func (db *DB) CountUsers() (int64, error) {
+	var count int64
+	err := db.Model(&User{}).Count(&count).Error
+	return count, err
+}
+
+// --- Party Session 操作 ---
+
+// ErrSessionActive 已有进行中的派对
+var ErrSessionActive = errors.New("a party session is already running")
+
+// StartPartySession 开始一场派对，同一时间只能有一场
+func (db *DB) StartPartySession(name, startedBy string) (*PartySession, error) {
+	session := &PartySession{Name: name, StartedBy: startedBy, StartedAt: time.Now()}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var active int64
+		if err := tx.Model(&PartySession{}).Where("ended_at IS NULL").Count(&active).Error; err != nil {
+			return err
+		}
+		if active > 0 {
+			return ErrSessionActive
+		}
+		return tx.Create(session).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// GetActivePartySession 返回进行中的派对，没有时返回 nil
+func (db *DB) GetActivePartySession() (*PartySession, error) {
+	var session PartySession
+	err := db.Where("ended_at IS NULL").Order("started_at DESC").First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetPartySession 按 ID 返回派对
+func (db *DB) GetPartySession(id uint) (*PartySession, error) {
+	var session PartySession
+	if err := db.First(&session, id).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetPartySessions 返回所有派对，最近的在前
+func (db *DB) GetPartySessions() ([]PartySession, error) {
+	var sessions []PartySession
+	err := db.Order("started_at DESC").Find(&sessions).Error
+	return sessions, err
+}
+
+// EndPartySession 结束派对并保存回顾
+func (db *DB) EndPartySession(session *PartySession) error {
+	now := time.Now()
+	recap, err := db.PartySessionRecap(session.ID, now)
+	if err != nil {
+		return err
+	}
+	session.EndedAt = &now
+	session.Recap = recap
+	return db.Model(session).Select("ended_at", "recap").Updates(session).Error
+}
+
+// sessionRecapSize 回顾中排行榜的条数
+const sessionRecapSize = 5
+
+// PartySessionRecap 根据派对中的播放历史生成回顾，until 是派对结束（或当前）的时间
+func (db *DB) PartySessionRecap(sessionID uint, until time.Time) (*SessionRecap, error) {
+	var history []PlayHistory
+	if err := db.Where("session_id = ?", sessionID).Order("played_at").Find(&history).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(history))
+	for _, h := range history {
+		ids = append(ids, h.SongID)
+	}
+	var songs []Song
+	// 回收站中的歌曲也要计入，Unscoped 跳过软删除过滤
+	if err := db.Unscoped().Where("id IN ?", ids).Find(&songs).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[string]Song, len(songs))
+	for _, song := range songs {
+		byID[song.ID] = song
+	}
+
+	recap := &SessionRecap{Plays: len(history), TopSongs: []SongPlayCount{}, TopRequesters: []RequesterCount{}}
+	songPlays := make(map[string]int)
+	requesterPlays := make(map[string]int)
+	for i, h := range history {
+		songPlays[h.SongID]++
+		if h.RequestedBy != "" {
+			requesterPlays[h.RequestedBy]++
+		}
+		// 一首歌播放到下一首开始为止，且不超过歌曲时长
+		end := until
+		if i+1 < len(history) {
+			end = history[i+1].PlayedAt
+		}
+		played := end.Sub(h.PlayedAt).Milliseconds()
+		if song, ok := byID[h.SongID]; ok && song.DurationMs > 0 && played > int64(song.DurationMs) {
+			played = int64(song.DurationMs)
+		}
+		if played > 0 {
+			recap.TotalListeningMs += played
+		}
+	}
+	for songID, plays := range songPlays {
+		song := byID[songID]
+		recap.TopSongs = append(recap.TopSongs, SongPlayCount{SongID: songID, Title: song.Title, Artist: song.Artist, Plays: plays})
+	}
+	sort.Slice(recap.TopSongs, func(i, j int) bool {
+		a, b := recap.TopSongs[i], recap.TopSongs[j]
+		return a.Plays > b.Plays || (a.Plays == b.Plays && a.Title < b.Title)
+	})
+	if len(recap.TopSongs) > sessionRecapSize {
+		recap.TopSongs = recap.TopSongs[:sessionRecapSize]
+	}
+	for username, plays := range requesterPlays {
+		recap.TopRequesters = append(recap.TopRequesters, RequesterCount{Username: username, Plays: plays})
+	}
+	sort.Slice(recap.TopRequesters, func(i, j int) bool {
+		a, b := recap.TopRequesters[i], recap.TopRequesters[j]
+		return a.Plays > b.Plays || (a.Plays == b.Plays && a.Username < b.Username)
+	})
+	if len(recap.TopRequesters) > sessionRecapSize {
+		recap.TopRequesters = recap.TopRequesters[:sessionRecapSize]
+	}
+	return recap, nil
+}
+
+// --- Blocklist 操作 ---
+
+// GetBlocklist 返回所有黑名单条目
+func (db *DB) GetBlocklist() ([]BlocklistEntry, error) {
+	var entries []BlocklistEntry
+	err := db.Order("id").Find(&entries).Error
+	return entries, err
+}
+
+// AddBlocklistEntry 新增黑名单条目
+func (db *DB) AddBlocklistEntry(entry *BlocklistEntry) error {
+	return db.Create(entry).Error
+}
+
+// DeleteBlocklistEntry 删除黑名单条目
+func (db *DB) DeleteBlocklistEntry(id uint) error {
+	result := db.Delete(&BlocklistEntry{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
 // --- System State 操作 ---
 
 func (db *DB) GetSystemState(key string) (string, error) {
@@ -188,34 +781,416 @@ func (db *DB) SetSystemState(key, value string) error {
 	}).Create(&state).Error
 }
 
+// InitSystemState 键不存在时写入 value，返回最终保存的值；多个实例同时初始化时所有实例得到同一个值
+func (db *DB) InitSystemState(key, value string) (string, error) {
+	state := SystemState{Key: key, Value: value}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&state).Error; err != nil {
+		return "", err
+	}
+	return db.GetSystemState(key)
+}
+
+// SetSystemStates 在一个事务中批量写入系统状态
+func (db *DB) SetSystemStates(values map[string]string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	states := make([]SystemState, 0, len(values))
+	for key, value := range values {
+		states = append(states, SystemState{Key: key, Value: value})
+	}
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&states).Error
+}
+
 // --- Song 操作 ---
 
+// AddSong 新增歌曲，入库前统一 feat. 写法并生成署名
 func (db *DB) AddSong(song *Song) error {
-	// INSERT INTO songs ...
-	return db.Create(song).Error
+	primary, featured := normalizeSongCredits(song)
+	return db.Transaction(func(tx *gorm.DB) error {
+		// INSERT INTO songs ...
+		// GORM 会在同一事务中一并插入 Chapters 关联，署名需要先查找或创建歌手，单独保存
+		if err := tx.Omit("Credits").Create(song).Error; err != nil {
+			return err
+		}
+		return saveCredits(tx, song, primary, featured)
+	})
+}
+
+// preloadChapters 按顺序预加载章节
+func preloadChapters(tx *gorm.DB) *gorm.DB {
+	return tx.Order("chapter_index")
+}
+
+// preloadSkipRegions 按开始时间预加载跳过区间
+func preloadSkipRegions(tx *gorm.DB) *gorm.DB {
+	return tx.Order("start_ms")
 }
 
 func (db *DB) GetSong(id string) (*Song, error) {
 	var song Song
 	// SELECT * FROM songs WHERE id = ?
-	err := db.First(&song, "id = ?", id).Error
+	err := db.Preload("Chapters", preloadChapters).Preload("SkipRegions", preloadSkipRegions).Preload("Credits", preloadCredits).First(&song, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &song, nil
+}
+
+// FindSongByContentHash 按内容哈希查找歌曲，找不到时返回 gorm.ErrRecordNotFound
+func (db *DB) FindSongByContentHash(hash string) (*Song, error) {
+	var song Song
+	err := db.Preload("Chapters", preloadChapters).Preload("SkipRegions", preloadSkipRegions).Preload("Credits", preloadCredits).First(&song, "content_hash = ?", hash).Error
 	if err != nil {
 		return nil, err
 	}
 	return &song, nil
 }
 
+// FindSongByTitleArtist 按标题和歌手查找歌曲（不区分大小写），用于没有哈希的旧歌曲
+func (db *DB) FindSongByTitleArtist(title, artist string) (*Song, error) {
+	var song Song
+	err := db.Preload("Chapters", preloadChapters).Preload("SkipRegions", preloadSkipRegions).Preload("Credits", preloadCredits).First(&song, "LOWER(title) = LOWER(?) AND LOWER(artist) = LOWER(?)", title, artist).Error
+	if err != nil {
+		return nil, err
+	}
+	return &song, nil
+}
+
+// FindMatchingSong 查找与给定歌曲相同的本地歌曲：优先比较内容哈希，没有哈希时比较标题和歌手
+func (db *DB) FindMatchingSong(song *Song) (*Song, error) {
+	if song.ContentHash != "" {
+		if match, err := db.FindSongByContentHash(song.ContentHash); err == nil {
+			return match, nil
+		}
+	}
+	return db.FindSongByTitleArtist(song.Title, song.Artist)
+}
+
 func (db *DB) GetAllSongs() ([]Song, error) {
 	var songs []Song
 	// SELECT * FROM songs ORDER BY title
-	result := db.Order("title").Find(&songs)
+	result := db.Preload("Chapters", preloadChapters).Preload("SkipRegions", preloadSkipRegions).Preload("Credits", preloadCredits).Order("title").Find(&songs)
+	return songs, result.Error
+}
+
+// GetSongsByIDs 按 ID 批量查找歌曲，不存在的 ID 会被忽略
+func (db *DB) GetSongsByIDs(ids []string) ([]Song, error) {
+	var songs []Song
+	result := db.Where("id IN ?", ids).Find(&songs)
+	return songs, result.Error
+}
+
+// GetSongsUploadedBy 返回某个用户上传的歌曲，按标题排序
+func (db *DB) GetSongsUploadedBy(username string) ([]Song, error) {
+	var songs []Song
+	result := db.Preload("Chapters", preloadChapters).Preload("SkipRegions", preloadSkipRegions).Preload("Credits", preloadCredits).Where("uploaded_by = ?", username).Order("title").Find(&songs)
+	return songs, result.Error
+}
+
+// SetSongExplicit 手动标记歌曲是否为露骨内容
+func (db *DB) SetSongExplicit(id string, explicit bool) error {
+	result := db.Model(&Song{}).Where("id = ?", id).Update("explicit", explicit)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// SetSongJingle 标记歌曲是否为台标
+func (db *DB) SetSongJingle(id string, jingle bool) error {
+	result := db.Model(&Song{}).Where("id = ?", id).Update("jingle", jingle)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetJingles 返回所有当前可以播放的台标，按标题排序
+func (db *DB) GetJingles() ([]Song, error) {
+	var songs []Song
+	result := db.Preload("Chapters", preloadChapters).Preload("SkipRegions", preloadSkipRegions).Preload("Credits", preloadCredits).Where("jingle = ? AND unavailable = ?", true, false).Order("title").Find(&songs)
+	return songs, result.Error
+}
+
+// SetSongGain 保存歌曲的音量补偿
+func (db *DB) SetSongGain(id string, gainDb float64) error {
+	result := db.Model(&Song{}).Where("id = ?", id).Update("gain_db", gainDb)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetSongsWithoutGain 返回还没有音量补偿的本地歌曲，远程实例上的歌曲无法分析
+func (db *DB) GetSongsWithoutGain() ([]Song, error) {
+	var songs []Song
+	err := db.Where("gain_db IS NULL AND file_path NOT LIKE ? AND file_path NOT LIKE ?", "http://%", "https://%").
+		Find(&songs).Error
+	return songs, err
+}
+
+// SetSongYear 保存重新扫描得到的发行年份
+func (db *DB) SetSongYear(id string, year int) error {
+	result := db.Model(&Song{}).Where("id = ?", id).Update("year", year)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// SongTags 歌曲的流派和情绪标签，以及自动分类的置信度
+type SongTags struct {
+	Genre           string
+	Mood            string
+	GenreConfidence float64
+	MoodConfidence  float64
+	// Manual 标签经过手动修改，自动分类不再改动
+	Manual bool
+}
+
+// Tags 返回歌曲当前的流派和情绪标签
+func (s *Song) Tags() SongTags {
+	return SongTags{Genre: s.Genre, Mood: s.Mood, GenreConfidence: s.GenreConfidence, MoodConfidence: s.MoodConfidence, Manual: s.ManualTags}
+}
+
+// SetTags 把标签写入歌曲
+func (s *Song) SetTags(tags SongTags) {
+	s.Genre, s.Mood = tags.Genre, tags.Mood
+	s.GenreConfidence, s.MoodConfidence = tags.GenreConfidence, tags.MoodConfidence
+	s.ManualTags = tags.Manual
+}
+
+// SetSongTags 保存歌曲的流派和情绪标签
+func (db *DB) SetSongTags(id string, tags SongTags) error {
+	result := db.Model(&Song{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"genre":            tags.Genre,
+		"mood":             tags.Mood,
+		"genre_confidence": tags.GenreConfidence,
+		"mood_confidence":  tags.MoodConfidence,
+		"manual_tags":      tags.Manual,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetSongsWithoutYear 返回年份未知的本地歌曲，远程实例上的歌曲无法扫描
+func (db *DB) GetSongsWithoutYear() ([]Song, error) {
+	var songs []Song
+	err := db.Where("year = 0 AND file_path NOT LIKE ? AND file_path NOT LIKE ?", "http://%", "https://%").
+		Find(&songs).Error
+	return songs, err
+}
+
+// SetSongUnavailable 标记歌曲是否暂时不能播放
+func (db *DB) SetSongUnavailable(id string, unavailable bool) error {
+	result := db.Model(&Song{}).Where("id = ?", id).Update("unavailable", unavailable)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// FindSongBySourcePath 按原地引用的源文件路径查找歌曲，找不到时返回 gorm.ErrRecordNotFound
+func (db *DB) FindSongBySourcePath(sourcePath string) (*Song, error) {
+	var song Song
+	err := db.First(&song, "source_path = ?", sourcePath).Error
+	if err != nil {
+		return nil, err
+	}
+	return &song, nil
+}
+
+// GetReferencedSongs 返回所有原地引用的歌曲
+func (db *DB) GetReferencedSongs() ([]Song, error) {
+	var songs []Song
+	result := db.Where("source_path <> ''").Find(&songs)
+	return songs, result.Error
+}
+
+// ReplaceSongMedia 文件被替换后更新歌曲的元数据和章节，ID 不变，播放历史、歌单等引用都保留
+func (db *DB) ReplaceSongMedia(song *Song) error {
+	primary, featured := normalizeSongCredits(song)
+	// 替换前后可能一个是 HLS 一个是原始文件
+	song.fillURLs()
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Song{ID: song.ID}).
+			Select("title", "artist", "album", "album_artist", "compilation", "genre", "mood", "genre_confidence", "mood_confidence", "year", "duration_ms", "source", "explicit", "file_path", "content_hash", "source_path", "unavailable", "gain_db", "bpm", "musical_key", "technical", "original_filename", "uploaded_by", "uploaded_at", "source_url").
+			Updates(song)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err := tx.Delete(&Chapter{}, "song_id = ?", song.ID).Error; err != nil {
+			return err
+		}
+		for i := range song.Chapters {
+			song.Chapters[i].ID = 0
+			song.Chapters[i].SongID = song.ID
+		}
+		if len(song.Chapters) > 0 {
+			if err := tx.Create(&song.Chapters).Error; err != nil {
+				return err
+			}
+		}
+		return saveCredits(tx, song, primary, featured)
+	})
+}
+
+// SetSkipRegions 替换歌曲的全部跳过区间，regions 为空表示清除
+func (db *DB) SetSkipRegions(songID string, regions []SkipRegion) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&Song{}).Where("id = ?", songID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err := tx.Delete(&SkipRegion{}, "song_id = ?", songID).Error; err != nil {
+			return err
+		}
+		for i := range regions {
+			regions[i].ID = 0
+			regions[i].SongID = songID
+		}
+		if len(regions) > 0 {
+			return tx.Create(&regions).Error
+		}
+		return nil
+	})
+}
+
+// TrashSong 把歌曲移入回收站，章节和歌单中的引用保留，以便恢复
+func (db *DB) TrashSong(id string) error {
+	result := db.Delete(&Song{}, "id = ?", id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// TrashSongs 把多首歌曲一次性移入回收站，返回实际移入的数量
+func (db *DB) TrashSongs(ids []string) (int64, error) {
+	result := db.Delete(&Song{}, "id IN ?", ids)
+	return result.RowsAffected, result.Error
+}
+
+// RetagSongs 批量修改歌曲的标签字段，updates 的键为列名，修改歌手时一并重建署名
+func (db *DB) RetagSongs(ids []string, updates map[string]interface{}) (int64, error) {
+	var updated int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Song{}).Where("id IN ?", ids).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		updated = result.RowsAffected
+		artist, ok := updates["artist"].(string)
+		if !ok {
+			return nil
+		}
+		primary, featured, _ := ParseCredits(artist, "")
+		var songs []Song
+		if err := tx.Where("id IN ?", ids).Find(&songs).Error; err != nil {
+			return err
+		}
+		for i := range songs {
+			if err := saveCredits(tx, &songs[i], primary, featured); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return updated, err
+}
+
+// RestoreSong 把歌曲从回收站恢复到曲库
+func (db *DB) RestoreSong(id string) error {
+	result := db.Unscoped().Model(&Song{}).Where("id = ? AND deleted_at IS NOT NULL", id).Update("deleted_at", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetTrashedSong 按 ID 查找回收站中的歌曲
+func (db *DB) GetTrashedSong(id string) (*Song, error) {
+	var song Song
+	if err := db.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).First(&song).Error; err != nil {
+		return nil, err
+	}
+	return &song, nil
+}
+
+// GetTrashedSongs 返回回收站中的歌曲，最近删除的在前
+func (db *DB) GetTrashedSongs() ([]Song, error) {
+	var songs []Song
+	result := db.Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Find(&songs)
+	return songs, result.Error
+}
+
+// GetTrashedSongsBefore 返回在 before 之前移入回收站的歌曲
+func (db *DB) GetTrashedSongsBefore(before time.Time) ([]Song, error) {
+	var songs []Song
+	result := db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", before).Find(&songs)
 	return songs, result.Error
 }
 
-func (db *DB) DeleteSong(id string) error {
+// CountSongsInMediaDir 统计播放文件位于 dir 中的歌曲数，包括回收站中的歌曲，用于共享目录的引用计数
+func (db *DB) CountSongsInMediaDir(dir string) (int64, error) {
+	var count int64
+	err := db.Unscoped().Model(&Song{}).Where("file_path LIKE ?", dir+"/%").Count(&count).Error
+	return count, err
+}
+
+// PurgeSong 永久删除歌曲及其章节和歌单中的引用
+func (db *DB) PurgeSong(id string) error {
 	// DELETE FROM songs WHERE id = ?
 	// 注意：由于我们在 PlaylistItem 设置了 CASCADE，GORM/SQLite 会自动处理级联删除
-	return db.Delete(&Song{}, "id = ?", id).Error
+	// SQLite 默认未开启外键约束，章节需要显式删除
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&Chapter{}, "song_id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&SkipRegion{}, "song_id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&SongCredit{}, "song_id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&SavedPlaylistSong{}, "song_id = ?", id).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&Song{}, "id = ?", id).Error
+	})
 }
 
 // --- Playlist 操作 ---
@@ -224,7 +1199,7 @@ func (db *DB) GetPlaylistItems() ([]PlaylistItem, error) {
 	var items []PlaylistItem
 	// Preload("Song"): 预加载 Song 关联，相当于 SQL Join 或者先查列表再查详情
 	// Order("item_order"): 按顺序排序
-	err := db.Preload("Song").Order("item_order").Find(&items).Error
+	err := db.Preload("Song").Preload("Song.Chapters", preloadChapters).Preload("Song.SkipRegions", preloadSkipRegions).Preload("Song.Credits", preloadCredits).Order("item_order").Find(&items).Error
 
 	if err != nil {
 		return nil, err
@@ -233,47 +1208,51 @@ func (db *DB) GetPlaylistItems() ([]PlaylistItem, error) {
 	// 过滤掉 Song 为 nil 的情况 (类似原代码中的逻辑，如果在库里找不到歌曲)
 	// 虽然有了 CASCADE 外键，这种情况理论上很少发生，但为了保持逻辑一致：
 	validItems := make([]PlaylistItem, 0, len(items))
+	var orphans []int
 	for _, item := range items {
 		if item.Song != nil {
 			validItems = append(validItems, item)
 		} else {
 			log.Printf("Warning: song %s in playlist not found in library", item.SongID)
+			orphans = append(orphans, item.ID)
+		}
+	}
+	// 播放列表按差异写入，孤立的行不会再被整表重写清掉，这里顺手清理
+	if len(orphans) > 0 {
+		if err := db.Delete(&PlaylistItem{}, orphans).Error; err != nil {
+			log.Printf("Warning: failed to clean up orphaned playlist items: %v", err)
 		}
 	}
 
 	return validItems, nil
 }
 
-// UpdatePlaylist 完全重写播放列表
-func (db *DB) UpdatePlaylist(songIDs []string) error {
-	// 使用 GORM 的事务闭包
-	return db.Transaction(func(tx *gorm.DB) error {
-		// 1. 清空当前列表
-		// exec: DELETE FROM playlist_items
-		// 使用 Where("1 = 1") 这是一个防止 GORM 警告全局删除的小技巧，或者使用 AllowGlobalUpdate 模式
-		if err := tx.Exec("DELETE FROM playlist_items").Error; err != nil {
-			return err
-		}
+// PlaylistChanges 是一次播放列表变更需要写入的差异
+type PlaylistChanges struct {
+	Inserts []*PlaylistItem // 新增的行，写入后回填 ID
+	Updates []PlaylistItem  // 只更新 item_order
+	Deletes []int           // 待删除的行 ID
+}
 
-		// 2. 批量插入
-		if len(songIDs) == 0 {
-			return nil
+// ApplyPlaylistChanges 在单个事务中写入播放列表的差异，避免每次都整表重写
+func (db *DB) ApplyPlaylistChanges(changes PlaylistChanges) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if len(changes.Deletes) > 0 {
+			if err := tx.Delete(&PlaylistItem{}, changes.Deletes).Error; err != nil {
+				return err
+			}
 		}
-
-		items := make([]PlaylistItem, len(songIDs))
-		for i, songID := range songIDs {
-			items[i] = PlaylistItem{
-				SongID: songID,
-				Order:  i,
+		for _, item := range changes.Updates {
+			if err := tx.Model(&PlaylistItem{}).Where("id = ?", item.ID).Update("item_order", item.Order).Error; err != nil {
+				return err
 			}
 		}
-
-		// INSERT INTO playlist_items ... VALUES ...
-		// GORM 支持批量插入，性能较好
-		if err := tx.Create(&items).Error; err != nil {
-			return err
+		for _, item := range changes.Inserts {
+			// 只写自身字段，避免 GORM 连带写入 Song 关联
+			if err := tx.Omit("Song").Create(item).Error; err != nil {
+				return err
+			}
 		}
-
 		return nil // 提交事务
 	})
 }
@@ -284,3 +1263,47 @@ func (db *DB) RemoveSongFromPlaylist(songID string) error {
 	// 根据 song_id 字段删除
 	return db.Where("song_id = ?", songID).Delete(&PlaylistItem{}).Error
 }
+
+// --- Saved Playlist 操作 ---
+
+// CreateSavedPlaylist 创建命名歌单，歌曲按 songIDs 的顺序保存
+func (db *DB) CreateSavedPlaylist(name, createdBy string, songIDs []string) (*SavedPlaylist, error) {
+	playlist := &SavedPlaylist{Name: name, CreatedBy: createdBy}
+	for i, songID := range songIDs {
+		playlist.Songs = append(playlist.Songs, SavedPlaylistSong{SongID: songID, Position: i})
+	}
+	if err := db.Omit("Songs.Song").Create(playlist).Error; err != nil {
+		return nil, err
+	}
+	return playlist, nil
+}
+
+// CreateSmartPlaylist 创建按条件选歌的智能歌单
+func (db *DB) CreateSmartPlaylist(name, createdBy string, rules SmartRules) (*SavedPlaylist, error) {
+	playlist := &SavedPlaylist{Name: name, CreatedBy: createdBy, Rules: &rules}
+	if err := db.Create(playlist).Error; err != nil {
+		return nil, err
+	}
+	return playlist, nil
+}
+
+// GetSavedPlaylists 返回所有命名歌单及其歌曲
+func (db *DB) GetSavedPlaylists() ([]SavedPlaylist, error) {
+	var playlists []SavedPlaylist
+	err := db.Preload("Songs", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("position")
+	}).Preload("Songs.Song").Order("name").Find(&playlists).Error
+	return playlists, err
+}
+
+// GetSavedPlaylist 按 ID 返回命名歌单
+func (db *DB) GetSavedPlaylist(id uint) (*SavedPlaylist, error) {
+	var playlist SavedPlaylist
+	err := db.Preload("Songs", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("position")
+	}).Preload("Songs.Song").First(&playlist, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &playlist, nil
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"time"
+)
+
+// ForgottenSong 很久没有播放的好歌，附带曾经的播放次数和收藏情况
+type ForgottenSong struct {
+	Song
+	Plays int64 `json:"plays"`
+	// Saves 收藏了这首歌的歌单数
+	Saves int64 `json:"saves"`
+	// LastPlayedAt 最近一次播放的时间，从未播放过时为空
+	LastPlayedAt *time.Time `json:"last_played_at,omitempty"`
+}
+
+// GetForgottenSongs 返回 since 之后没有播放过、且播放次数不少于 minPlays 或被收藏进歌单的可播放歌曲（台标除外），
+// 按播放次数、收藏数从多到少排列，最多 limit 首
+func (db *DB) GetForgottenSongs(since time.Time, minPlays, limit int) ([]ForgottenSong, error) {
+	plays := db.Model(&PlayHistory{}).Select("COUNT(*)").Where("play_histories.song_id = songs.id")
+	saves := db.Model(&SavedPlaylistSong{}).Select("COUNT(DISTINCT playlist_id)").Where("saved_playlist_songs.song_id = songs.id")
+	recent := db.Model(&PlayHistory{}).Select("1").Where("play_histories.song_id = songs.id AND play_histories.played_at >= ?", since)
+	candidates := db.Model(&Song{}).
+		Select("songs.id, (?) AS plays, (?) AS saves", plays, saves).
+		Where("songs.unavailable = ? AND songs.jingle = ?", false, false).
+		Where("NOT EXISTS (?)", recent)
+	var stats []struct {
+		ID    string
+		Plays int64
+		Saves int64
+	}
+	err := db.Table("(?) AS candidates", candidates).
+		Where("plays >= ? OR saves > 0", minPlays).
+		Order("plays DESC, saves DESC, id").
+		Limit(limit).
+		Scan(&stats).Error
+	if err != nil || len(stats) == 0 {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(stats))
+	for _, s := range stats {
+		ids = append(ids, s.ID)
+	}
+	var songs []Song
+	if err := db.Preload("Chapters", preloadChapters).Preload("SkipRegions", preloadSkipRegions).Preload("Credits", preloadCredits).Where("id IN ?", ids).Find(&songs).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[string]Song, len(songs))
+	for _, song := range songs {
+		byID[song.ID] = song
+	}
+	forgotten := make([]ForgottenSong, 0, len(stats))
+	for _, s := range stats {
+		song, ok := byID[s.ID]
+		if !ok {
+			continue
+		}
+		gem := ForgottenSong{Song: song, Plays: s.Plays, Saves: s.Saves}
+		if lastPlayed, err := db.LastPlayedAt(s.ID); err == nil && !lastPlayed.IsZero() {
+			gem.LastPlayedAt = &lastPlayed
+		}
+		forgotten = append(forgotten, gem)
+	}
+	return forgotten, nil
+}
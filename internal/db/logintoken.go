@@ -0,0 +1,117 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LoginTokenPrefix 登录令牌的前缀，认证时据此区分令牌和密码，令牌不需要走 bcrypt
+const LoginTokenPrefix = "jbt_"
+
+// ErrLoginTokenInvalid 令牌不存在、已过期或不属于该用户
+var ErrLoginTokenInvalid = errors.New("login token is invalid or expired")
+
+// 登录令牌的签发方式
+const (
+	LoginMethodSSO      = "sso"
+	LoginMethodRemember = "remember"
+	LoginMethodPair     = "pair"
+)
+
+// loginTokenTouchInterval 最近使用时间的更新间隔，避免每个请求都写数据库
+const loginTokenTouchInterval = time.Hour
+
+// LoginToken 登录令牌，单点登录、扫码配对或登录时选择“记住此设备”时签发，客户端以 用户名:令牌 作为 Basic Auth 凭证
+// 每个令牌对应一台设备，用户可以查看并注销；数据库只保存令牌的哈希
+type LoginToken struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Username  string    `gorm:"not null;index" json:"-"`
+	TokenHash string    `gorm:"not null;uniqueIndex" json:"-"`
+	Method    string    `json:"method"`
+	Device    string    `json:"device"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"createdAt"`
+	// LastUsedAt 最近一次使用的时间，精确到 loginTokenTouchInterval
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	ExpiresAt  time.Time  `gorm:"not null;index" json:"expiresAt"`
+}
+
+// IsLoginToken 判断 Basic Auth 中的密码是否是登录令牌
+func IsLoginToken(password string) bool {
+	return strings.HasPrefix(password, LoginTokenPrefix)
+}
+
+func hashLoginToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateLoginToken 为用户的一台设备签发有效期为 ttl 的令牌，顺便清理该用户已过期的令牌
+func (db *DB) CreateLoginToken(username, method, device string, ttl time.Duration) (string, time.Time, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	token := LoginTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	expiresAt := time.Now().Add(ttl)
+	if err := db.Where("username = ? AND expires_at < ?", username, time.Now()).Delete(&LoginToken{}).Error; err != nil {
+		return "", time.Time{}, err
+	}
+	row := &LoginToken{Username: username, TokenHash: hashLoginToken(token), Method: method, Device: device, ExpiresAt: expiresAt}
+	if err := db.Create(row).Error; err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// AuthenticateLoginToken 校验令牌并返回对应的用户
+func (db *DB) AuthenticateLoginToken(username, token string) (*User, error) {
+	var row LoginToken
+	err := db.Where("token_hash = ? AND username = ? AND expires_at > ?", hashLoginToken(token), username, time.Now()).First(&row).Error
+	if err != nil {
+		return nil, ErrLoginTokenInvalid
+	}
+	if now := time.Now(); row.LastUsedAt == nil || now.Sub(*row.LastUsedAt) > loginTokenTouchInterval {
+		db.Model(&row).Update("last_used_at", now)
+	}
+	return db.GetUserByUsername(username)
+}
+
+// GetLoginTokens 返回用户仍然有效的令牌，最近签发的在前
+func (db *DB) GetLoginTokens(username string) ([]LoginToken, error) {
+	var tokens []LoginToken
+	err := db.Where("username = ? AND expires_at > ?", username, time.Now()).Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+// LoginTokenID 返回令牌对应的记录 ID，用于标出当前设备，令牌无效时返回 0
+func (db *DB) LoginTokenID(token string) uint {
+	var row LoginToken
+	if err := db.Select("id").Where("token_hash = ?", hashLoginToken(token)).First(&row).Error; err != nil {
+		return 0
+	}
+	return row.ID
+}
+
+// RevokeLoginTokenByID 注销用户的某个令牌，不存在或不属于该用户时返回 gorm.ErrRecordNotFound
+func (db *DB) RevokeLoginTokenByID(username string, id uint) error {
+	result := db.Where("id = ? AND username = ?", id, username).Delete(&LoginToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// RevokeLoginToken 注销一个令牌，令牌不存在时不报错
+func (db *DB) RevokeLoginToken(token string) error {
+	return db.Where("token_hash = ?", hashLoginToken(token)).Delete(&LoginToken{}).Error
+}
@@ -0,0 +1,34 @@
+package db
+
+// Vacuum 整理数据库文件、回收删除记录留下的空间，并更新查询优化器的统计信息
+// 执行期间数据库被锁定，应在没人使用时（夜间维护）调用
+func (db *DB) Vacuum() error {
+	if err := db.Exec("VACUUM").Error; err != nil {
+		return err
+	}
+	return db.Exec("ANALYZE").Error
+}
+
+// BackupTo 把数据库的一致快照写入 dest，dest 不能已经存在
+func (db *DB) BackupTo(dest string) error {
+	return db.Exec("VACUUM INTO ?", dest).Error
+}
+
+// GetMediaFilePaths 返回所有歌曲（包括回收站中的歌曲）的播放文件路径，用于找出无人引用的媒体文件
+func (db *DB) GetMediaFilePaths() ([]string, error) {
+	var paths []string
+	err := db.Unscoped().Model(&Song{}).Pluck("file_path", &paths).Error
+	return paths, err
+}
+
+// Size 返回数据库文件的字节数（页数乘以页大小）
+func (db *DB) Size() (int64, error) {
+	var pageCount, pageSize int64
+	if err := db.Raw("PRAGMA page_count").Scan(&pageCount).Error; err != nil {
+		return 0, err
+	}
+	if err := db.Raw("PRAGMA page_size").Scan(&pageSize).Error; err != nil {
+		return 0, err
+	}
+	return pageCount * pageSize, nil
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// moodTempos 常见情绪对应的 BPM 范围（上限为 0 表示不限），没有情绪标签的歌曲按分析得到的 BPM 补充
+var moodTempos = map[string][2]float64{
+	"chill":     {0, 100},
+	"relaxed":   {0, 100},
+	"calm":      {0, 95},
+	"mellow":    {0, 100},
+	"sad":       {0, 95},
+	"romantic":  {0, 110},
+	"happy":     {100, 135},
+	"upbeat":    {110, 140},
+	"energetic": {120, 0},
+	"party":     {118, 0},
+	"dance":     {118, 135},
+	"workout":   {125, 0},
+}
+
+// moodOvershootMs 生成的歌单允许比要求的时长多出的部分，最后一首歌不会被截断
+const moodOvershootMs = 5 * 60 * 1000
+
+// moodMatch 判断歌曲是否符合情绪：情绪标签相同；没有情绪标签的歌曲 BPM 落在该情绪的范围内也算
+// 返回的 tagged 表示是按标签匹配的
+func moodMatch(song *Song, mood string) (match, tagged bool) {
+	if song.Mood != "" {
+		return ArtistKey(song.Mood) == mood, true
+	}
+	tempo, ok := moodTempos[mood]
+	if !ok || song.BPM <= 0 {
+		return false, false
+	}
+	return song.BPM >= tempo[0] && (tempo[1] == 0 || song.BPM <= tempo[1]), false
+}
+
+// MoodPlaylist 从曲库中为情绪挑选总时长约为 targetMs 的歌曲，按标签匹配的优先，
+// 自动分类的按置信度从高到低，同等条件下随机；暂时不能播放和时长未知的歌曲不选
+func MoodPlaylist(songs []Song, mood string, targetMs int64) []Song {
+	mood = ArtistKey(mood)
+	type candidate struct {
+		song  Song
+		score float64
+	}
+	var candidates []candidate
+	for _, song := range songs {
+		if song.Unavailable || song.DurationMs <= 0 {
+			continue
+		}
+		match, tagged := moodMatch(&song, mood)
+		if !match {
+			continue
+		}
+		// 文件标签和手动设置的情绪没有置信度，视为确定；按 BPM 推测的排在最后
+		score := 0.0
+		if tagged {
+			score = 1
+			if song.MoodConfidence > 0 {
+				score = song.MoodConfidence
+			}
+		}
+		candidates = append(candidates, candidate{song: song, score: score})
+	}
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+
+	var picked []Song
+	var totalMs int64
+	for _, c := range candidates {
+		if totalMs >= targetMs {
+			break
+		}
+		// 跳过会让总时长超出太多的长音轨，继续找更短的歌曲
+		if totalMs+int64(c.song.DurationMs) > targetMs+moodOvershootMs {
+			continue
+		}
+		picked = append(picked, c.song)
+		totalMs += int64(c.song.DurationMs)
+	}
+	// 挑选按置信度进行，播放顺序打乱，避免确定的歌曲都挤在开头
+	rand.Shuffle(len(picked), func(i, j int) { picked[i], picked[j] = picked[j], picked[i] })
+	return picked
+}
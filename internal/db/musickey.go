@@ -0,0 +1,95 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+)
+
+// pitchClasses 音名对应的半音数，C 为 0
+var pitchClasses = map[byte]int{'C': 0, 'D': 2, 'E': 4, 'F': 5, 'G': 7, 'A': 9, 'B': 11}
+
+// CamelotKey 把调性标签统一为 Camelot 记法（小调 1A-12A，大调 1B-12B）
+// 支持 Camelot（8A、08A）、Open Key（1m、1d）和常规写法（Am、A minor、C#、Dbmaj、F♯m），无法识别时返回空字符串
+func CamelotKey(key string) string {
+	s := strings.ReplaceAll(strings.TrimSpace(key), " ", "")
+	if s == "" {
+		return ""
+	}
+	// Camelot 和 Open Key 以数字开头
+	if s[0] >= '0' && s[0] <= '9' {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		n, err := strconv.Atoi(s[:i])
+		if err != nil || n < 1 || n > 12 {
+			return ""
+		}
+		switch strings.ToLower(s[i:]) {
+		case "a":
+			return strconv.Itoa(n) + "A"
+		case "b":
+			return strconv.Itoa(n) + "B"
+		case "m":
+			return strconv.Itoa((n+6)%12+1) + "A"
+		case "d":
+			return strconv.Itoa((n+6)%12+1) + "B"
+		}
+		return ""
+	}
+
+	pc, ok := pitchClasses[strings.ToUpper(s[:1])[0]]
+	if !ok {
+		return ""
+	}
+	rest := s[1:]
+	switch {
+	case strings.HasPrefix(rest, "#"), strings.HasPrefix(rest, "♯"):
+		pc++
+		rest = strings.TrimPrefix(strings.TrimPrefix(rest, "#"), "♯")
+	case strings.HasPrefix(rest, "b"), strings.HasPrefix(rest, "♭"):
+		pc--
+		rest = strings.TrimPrefix(strings.TrimPrefix(rest, "b"), "♭")
+	}
+	minor := false
+	switch strings.ToLower(rest) {
+	case "", "maj", "major":
+	case "m", "min", "minor":
+		minor = true
+	default:
+		return ""
+	}
+	if minor {
+		// 小调与其关系大调（高小三度）在 Camelot 轮上编号相同
+		pc += 3
+	}
+	pc = (pc%12 + 12) % 12
+	// Camelot 轮按五度圈排列，C 大调为 8B
+	n := (pc*7+7)%12 + 1
+	if minor {
+		return strconv.Itoa(n) + "A"
+	}
+	return strconv.Itoa(n) + "B"
+}
+
+// KeysCompatible 判断两个 Camelot 调性能否和谐衔接：同一调、轮上相邻的调（同字母编号相差 1），或关系大小调（编号相同）
+func KeysCompatible(a, b string) bool {
+	na, la, ok1 := splitCamelot(a)
+	nb, lb, ok2 := splitCamelot(b)
+	if !ok1 || !ok2 {
+		return false
+	}
+	if la != lb {
+		return na == nb
+	}
+	diff := (na - nb + 12) % 12
+	return diff == 0 || diff == 1 || diff == 11
+}
+
+func splitCamelot(key string) (int, byte, bool) {
+	if len(key) < 2 {
+		return 0, 0, false
+	}
+	n, err := strconv.Atoi(key[:len(key)-1])
+	return n, key[len(key)-1], err == nil
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 举报的处理状态
+const (
+	ReportOpen        = "open"
+	ReportDismissed   = "dismissed"
+	ReportBlocklisted = "blocklisted"
+	ReportDeleted     = "deleted"
+)
+
+// ErrAlreadyReported 同一用户对同一首歌已有未处理的举报
+var ErrAlreadyReported = errors.New("you have already reported this song")
+
+// SongReport 用户对一首歌的举报，管理员处理后记录处理方式和处理人
+// 标题和歌手在举报时保存，歌曲被删除后仍能看出举报的是什么
+type SongReport struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	SongID     string     `gorm:"not null;index" json:"song_id"`
+	SongTitle  string     `json:"song_title"`
+	SongArtist string     `json:"song_artist"`
+	Reason     string     `gorm:"not null" json:"reason"`
+	ReportedBy string     `gorm:"index" json:"reported_by"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	Status     string     `gorm:"not null;default:open;index" json:"status"`
+	ResolvedBy string     `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// CreateSongReport 保存一条举报，同一用户对同一首歌的举报处理之前不能重复提交
+func (db *DB) CreateSongReport(report *SongReport) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&SongReport{}).Where("song_id = ? AND reported_by = ? AND status = ?", report.SongID, report.ReportedBy, ReportOpen).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrAlreadyReported
+		}
+		report.Status = ReportOpen
+		return tx.Create(report).Error
+	})
+}
+
+// GetSongReports 返回举报，status 为空时返回全部，最早的在前
+func (db *DB) GetSongReports(status string) ([]SongReport, error) {
+	var reports []SongReport
+	query := db.Order("created_at")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	err := query.Find(&reports).Error
+	return reports, err
+}
+
+// GetSongReport 按 ID 查找举报
+func (db *DB) GetSongReport(id uint) (*SongReport, error) {
+	var report SongReport
+	if err := db.First(&report, id).Error; err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// ResolveSongReports 把一首歌所有未处理的举报标记为已处理，返回处理的条数
+func (db *DB) ResolveSongReports(songID, status, resolvedBy string) (int64, error) {
+	now := time.Now()
+	result := db.Model(&SongReport{}).Where("song_id = ? AND status = ?", songID, ReportOpen).
+		Updates(map[string]interface{}{"status": status, "resolved_by": resolvedBy, "resolved_at": now})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Scrobble 一次完整的收听记录，歌曲结束、被跳过或停止时写入
+// 标题等元数据在写入时复制一份，歌曲被删除或改标签后导出的记录保持不变
+type Scrobble struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	SongID      string `gorm:"not null;index" json:"song_id"`
+	Title       string `gorm:"not null" json:"title"`
+	Artist      string `json:"artist"`
+	Album       string `json:"album"`
+	AlbumArtist string `json:"album_artist,omitempty"`
+	DurationMs  int    `json:"duration_ms"`
+	// ListenedMs 实际播放的时长，用于判断是否满足 Last.fm 的记录条件
+	ListenedMs  int64     `json:"listened_ms"`
+	RequestedBy string    `gorm:"index" json:"requested_by"` // 点歌用户，自动播放时可能为空
+	PlayedAt    time.Time `gorm:"not null;index" json:"played_at"`
+	// Skipped 被手动切到下一首，SkippedBy 为切歌的用户（自动化触发时可能为空），SkipReason 为切歌时给出的原因
+	Skipped    bool   `gorm:"not null;default:false" json:"skipped"`
+	SkippedBy  string `json:"skipped_by,omitempty"`
+	SkipReason string `json:"skip_reason,omitempty"`
+}
+
+// 切歌时可以给出的原因
+const (
+	SkipReasonBadQuality = "bad_quality"
+	SkipReasonWrongVibe  = "wrong_vibe"
+	SkipReasonDuplicate  = "duplicate"
+)
+
+// ValidSkipReason 判断切歌原因是否是已知的取值
+func ValidSkipReason(reason string) bool {
+	switch reason {
+	case SkipReasonBadQuality, SkipReasonWrongVibe, SkipReasonDuplicate:
+		return true
+	}
+	return false
+}
+
+// ScrobbleFilter 查询收听记录的条件，零值表示不限
+type ScrobbleFilter struct {
+	From        time.Time
+	To          time.Time
+	RequestedBy string
+}
+
+// AddScrobble 写入一条收听记录
+func (db *DB) AddScrobble(s *Scrobble) error {
+	return db.Create(s).Error
+}
+
+// GetScrobbles 按条件返回收听记录，最早的在前
+func (db *DB) GetScrobbles(filter ScrobbleFilter) ([]Scrobble, error) {
+	var scrobbles []Scrobble
+	err := db.scrobbleQuery(filter).Order("played_at ASC, id ASC").Find(&scrobbles).Error
+	return scrobbles, err
+}
+
+// GetScrobbleTimes 按条件返回收听记录的时间和收听时长，其余字段为空，用于按时段统计
+func (db *DB) GetScrobbleTimes(filter ScrobbleFilter) ([]Scrobble, error) {
+	var scrobbles []Scrobble
+	err := db.scrobbleQuery(filter).Select("played_at", "listened_ms").Find(&scrobbles).Error
+	return scrobbles, err
+}
+
+func (db *DB) scrobbleQuery(filter ScrobbleFilter) *gorm.DB {
+	query := db.Model(&Scrobble{})
+	if !filter.From.IsZero() {
+		query = query.Where("played_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("played_at < ?", filter.To)
+	}
+	if filter.RequestedBy != "" {
+		query = query.Where("requested_by = ?", filter.RequestedBy)
+	}
+	return query
+}
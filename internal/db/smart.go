@@ -0,0 +1,91 @@
+package db
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+)
+
+// SmartRules 智能歌单的筛选条件，歌曲在加入播放列表时才按条件从曲库中选出，新入库的歌曲自动包含在内
+type SmartRules struct {
+	// Decade 年代的起始年份，例如 1990 表示 1990-1999
+	Decade   int    `json:"decade,omitempty"`
+	YearFrom int    `json:"yearFrom,omitempty"`
+	YearTo   int    `json:"yearTo,omitempty"`
+	Genre    string `json:"genre,omitempty"`
+	Artist   string `json:"artist,omitempty"`
+	// Limit 每次最多选出的歌曲数，0 表示不限制
+	Limit int `json:"limit,omitempty"`
+	// Shuffle 随机选取和排序，否则按发行年份排序
+	Shuffle bool `json:"shuffle,omitempty"`
+}
+
+// Validate 检查条件是否有效，至少需要一个筛选条件
+func (r *SmartRules) Validate() error {
+	if r.Decade == 0 && r.YearFrom == 0 && r.YearTo == 0 && r.Genre == "" && r.Artist == "" {
+		return errors.New("at least one of decade, yearFrom, yearTo, genre or artist is required")
+	}
+	if r.Decade%10 != 0 || r.Decade < 0 {
+		return errors.New("decade must be the first year of a decade, e.g. 1990")
+	}
+	if r.YearFrom < 0 || r.YearTo < 0 || (r.YearTo != 0 && r.YearFrom > r.YearTo) {
+		return errors.New("yearFrom must not be after yearTo")
+	}
+	if r.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+	return nil
+}
+
+// Match 判断歌曲是否符合条件，有年份条件时年份未知的歌曲不符合
+func (r *SmartRules) Match(song *Song) bool {
+	if !InYearRange(song.Year, r.Decade, r.YearFrom, r.YearTo) {
+		return false
+	}
+	if r.Genre != "" && ArtistKey(song.Genre) != ArtistKey(r.Genre) {
+		return false
+	}
+	if r.Artist != "" && !song.CreditedTo(r.Artist) {
+		return false
+	}
+	return true
+}
+
+// Select 从曲库中选出符合条件的歌曲
+func (r *SmartRules) Select(songs []Song) []Song {
+	var matched []Song
+	for i := range songs {
+		if r.Match(&songs[i]) {
+			matched = append(matched, songs[i])
+		}
+	}
+	if r.Shuffle {
+		rand.Shuffle(len(matched), func(i, j int) { matched[i], matched[j] = matched[j], matched[i] })
+	} else {
+		sort.SliceStable(matched, func(i, j int) bool { return matched[i].Year < matched[j].Year })
+	}
+	if r.Limit > 0 && len(matched) > r.Limit {
+		matched = matched[:r.Limit]
+	}
+	return matched
+}
+
+// InYearRange 判断年份是否落在年代和起止年份限定的范围内，条件为 0 表示不限制
+func InYearRange(year, decade, from, to int) bool {
+	if decade == 0 && from == 0 && to == 0 {
+		return true
+	}
+	if year == 0 {
+		return false
+	}
+	if decade != 0 && (year < decade || year >= decade+10) {
+		return false
+	}
+	if from != 0 && year < from {
+		return false
+	}
+	if to != 0 && year > to {
+		return false
+	}
+	return true
+}
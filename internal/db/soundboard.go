@@ -0,0 +1,58 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SoundClip 音效板上的短音频（喇叭声、掌声之类），由 DJ 触发后叠加在当前歌曲上播放
+// 文件原样保存在媒体目录的 soundboard 子目录中，不转码
+type SoundClip struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Name       string    `gorm:"not null" json:"name"`
+	FilePath   string    `gorm:"not null" json:"-"` // 相对于媒体目录
+	DurationMs int       `json:"duration_ms"`
+	UploadedBy string    `gorm:"index" json:"uploaded_by"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+	// URL 客户端播放片段的地址，不入库
+	URL string `gorm:"-" json:"url"`
+}
+
+// AfterFind 补齐播放地址
+func (c *SoundClip) AfterFind(tx *gorm.DB) error {
+	c.URL = "/static/audio/" + c.FilePath
+	return nil
+}
+
+// AfterCreate 入库后同样补齐播放地址，上传接口直接返回刚创建的片段
+func (c *SoundClip) AfterCreate(tx *gorm.DB) error {
+	c.URL = "/static/audio/" + c.FilePath
+	return nil
+}
+
+// AddSoundClip 保存一个片段
+func (db *DB) AddSoundClip(clip *SoundClip) error {
+	return db.Create(clip).Error
+}
+
+// GetSoundClips 返回所有片段，按名称排序
+func (db *DB) GetSoundClips() ([]SoundClip, error) {
+	var clips []SoundClip
+	err := db.Order("name, id").Find(&clips).Error
+	return clips, err
+}
+
+// GetSoundClip 按 ID 查找片段
+func (db *DB) GetSoundClip(id uint) (*SoundClip, error) {
+	var clip SoundClip
+	if err := db.First(&clip, id).Error; err != nil {
+		return nil, err
+	}
+	return &clip, nil
+}
+
+// DeleteSoundClip 删除一个片段的记录，文件由调用方删除
+func (db *DB) DeleteSoundClip(id uint) error {
+	return db.Delete(&SoundClip{}, id).Error
+}
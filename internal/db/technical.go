@@ -0,0 +1,23 @@
+package db
+
+// TechnicalInfo 入库时记录的源文件参数和生成的播放文件，用于排查音质问题
+type TechnicalInfo struct {
+	// Container 源文件的容器格式，例如 "mp3"、"flac"、"mov,mp4,m4a,3gp,3g2,mj2"
+	Container   string `json:"container,omitempty"`
+	Codec       string `json:"codec,omitempty"`
+	BitrateKbps int    `json:"bitrate_kbps,omitempty"`
+	SampleRate  int    `json:"sample_rate,omitempty"`
+	Channels    int    `json:"channels,omitempty"`
+	// FileSize 源文件的字节数
+	FileSize int64 `json:"file_size,omitempty"`
+	// Renditions 转码生成的 HLS 码率，没有 ffmpeg 时原样提供源文件，为空
+	Renditions []Rendition `json:"renditions,omitempty"`
+}
+
+// Rendition 一个 HLS 码率及其索引文件
+type Rendition struct {
+	Codec          string `json:"codec"`
+	BitrateKbps    int    `json:"bitrate_kbps"`
+	Playlist       string `json:"playlist"`
+	SegmentSeconds int    `json:"segment_seconds"`
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// ErrWSTicketInvalid 票据不存在、已过期或已经使用过
+var ErrWSTicketInvalid = errors.New("websocket ticket is invalid or expired")
+
+// WSTicket WebSocket 连接票据：浏览器无法为 WebSocket 设置 Authorization 头，
+// 先用凭证换取短期、一次性的票据放在连接地址中，避免密码出现在访问日志里。
+// 保存在数据库中，集群模式下任何实例都能兑换；数据库只保存票据的哈希
+type WSTicket struct {
+	ID         uint      `gorm:"primaryKey"`
+	Username   string    `gorm:"not null"`
+	TicketHash string    `gorm:"not null;uniqueIndex"`
+	ExpiresAt  time.Time `gorm:"not null;index"`
+}
+
+// CreateWSTicket 为用户签发有效期为 ttl 的票据，顺便清理所有已过期的票据
+func (db *DB) CreateWSTicket(username string, ttl time.Duration) (string, time.Time, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	ticket := base64.RawURLEncoding.EncodeToString(b)
+	expiresAt := time.Now().Add(ttl)
+	if err := db.Where("expires_at < ?", time.Now()).Delete(&WSTicket{}).Error; err != nil {
+		return "", time.Time{}, err
+	}
+	row := &WSTicket{Username: username, TicketHash: hashLoginToken(ticket), ExpiresAt: expiresAt}
+	if err := db.Create(row).Error; err != nil {
+		return "", time.Time{}, err
+	}
+	return ticket, expiresAt, nil
+}
+
+// RedeemWSTicket 兑换票据并返回签发给的用户名，每张票据只能兑换一次
+func (db *DB) RedeemWSTicket(ticket string) (string, error) {
+	var row WSTicket
+	if err := db.Where("ticket_hash = ? AND expires_at > ?", hashLoginToken(ticket), time.Now()).First(&row).Error; err != nil {
+		return "", ErrWSTicketInvalid
+	}
+	// 只有删除成功的一方算兑换成功，并发兑换同一张票据时另一方失败
+	result := db.Delete(&WSTicket{}, row.ID)
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", ErrWSTicketInvalid
+	}
+	return row.Username, nil
+}
@@ -0,0 +1,116 @@
+package dlna
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// didlLite Browse 结果中的对象列表，元素名带命名空间前缀，直接写在标签里
+type didlLite struct {
+	XMLName xml.Name `xml:"DIDL-Lite"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	DC      string   `xml:"xmlns:dc,attr"`
+	UPnP    string   `xml:"xmlns:upnp,attr"`
+	DLNA    string   `xml:"xmlns:dlna,attr"`
+	Objects []didlObject
+}
+
+// didlObject 一个 container 或 item 元素
+type didlObject struct {
+	XMLName    xml.Name
+	ID         string   `xml:"id,attr"`
+	ParentID   string   `xml:"parentID,attr"`
+	Restricted string   `xml:"restricted,attr"`
+	ChildCount *int     `xml:"childCount,attr,omitempty"`
+	Title      string   `xml:"dc:title"`
+	Creator    string   `xml:"dc:creator,omitempty"`
+	Artist     string   `xml:"upnp:artist,omitempty"`
+	Album      string   `xml:"upnp:album,omitempty"`
+	Genre      string   `xml:"upnp:genre,omitempty"`
+	Date       string   `xml:"dc:date,omitempty"`
+	Art        *didlArt `xml:"upnp:albumArtURI,omitempty"`
+	Class      string   `xml:"upnp:class"`
+	Res        *didlRes `xml:"res,omitempty"`
+}
+
+type didlArt struct {
+	ProfileID string `xml:"dlna:profileID,attr"`
+	URL       string `xml:",chardata"`
+}
+
+type didlRes struct {
+	ProtocolInfo string `xml:"protocolInfo,attr"`
+	Duration     string `xml:"duration,attr,omitempty"`
+	Size         int64  `xml:"size,attr,omitempty"`
+	URL          string `xml:",chardata"`
+}
+
+// didl 把对象序列化为 DIDL-Lite，相对地址以 baseURL 补全
+func didl(objects []Object, baseURL string) (string, error) {
+	doc := didlLite{
+		Xmlns: "urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/",
+		DC:    "http://purl.org/dc/elements/1.1/",
+		UPnP:  "urn:schemas-upnp-org:metadata-1-0/upnp/",
+		DLNA:  "urn:schemas-dlna-org:metadata-1-0/",
+	}
+	for _, o := range objects {
+		obj := didlObject{
+			ID:         o.ID,
+			ParentID:   o.ParentID,
+			Restricted: "1",
+			Title:      o.Title,
+			Creator:    o.Artist,
+			Artist:     o.Artist,
+			Album:      o.Album,
+			Genre:      o.Genre,
+			Class:      o.Class,
+		}
+		if o.Year > 0 {
+			obj.Date = fmt.Sprintf("%04d-01-01", o.Year)
+		}
+		if o.ArtURL != "" {
+			obj.Art = &didlArt{ProfileID: "JPEG_TN", URL: absoluteURL(o.ArtURL, baseURL)}
+		}
+		if o.Container {
+			count := o.ChildCount
+			obj.XMLName.Local = "container"
+			obj.ChildCount = &count
+			if obj.Class == "" {
+				obj.Class = ClassFolder
+			}
+		} else {
+			obj.XMLName.Local = "item"
+			if obj.Class == "" {
+				obj.Class = ClassTrack
+			}
+			if o.URL != "" {
+				obj.Res = &didlRes{
+					ProtocolInfo: "http-get:*:" + o.MimeType + ":" + ContentFeatures(o.Transcoded),
+					Size:         o.Size,
+					URL:          absoluteURL(o.URL, baseURL),
+				}
+				if o.Duration > 0 {
+					obj.Res.Duration = formatDuration(o.Duration)
+				}
+			}
+		}
+		doc.Objects = append(doc.Objects, obj)
+	}
+	out, err := xml.Marshal(doc)
+	return string(out), err
+}
+
+func absoluteURL(u, baseURL string) string {
+	if strings.HasPrefix(u, "/") {
+		return baseURL + u
+	}
+	return u
+}
+
+// formatDuration 按 DIDL-Lite 的 H:MM:SS.mmm 格式输出时长
+func formatDuration(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
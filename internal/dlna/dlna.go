@@ -0,0 +1,78 @@
+// Package dlna 实现 DLNA/UPnP 媒体服务器（MediaServer:1）：通过 SSDP 在局域网内宣告，
+// 提供设备描述和 ContentDirectory、ConnectionManager 两个服务，智能电视和功放据此浏览曲库
+// 目录树和媒体文件由调用方提供，本包只负责协议部分；不支持搜索和事件通知
+package dlna
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// UPnP 设备和服务类型
+const (
+	DeviceType               = "urn:schemas-upnp-org:device:MediaServer:1"
+	ContentDirectoryService  = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	ConnectionManagerService = "urn:schemas-upnp-org:service:ConnectionManager:1"
+)
+
+// RootID 目录树根容器的 ID，由 ContentDirectory 规范规定
+const RootID = "0"
+
+// 常用的对象类别
+const (
+	ClassFolder   = "object.container.storageFolder"
+	ClassArtist   = "object.container.person.musicArtist"
+	ClassAlbum    = "object.container.album.musicAlbum"
+	ClassGenre    = "object.container.genre.musicGenre"
+	ClassPlaylist = "object.container.playlistContainer"
+	ClassTrack    = "object.item.audioItem.musicTrack"
+)
+
+// ErrNoSuchObject 请求的对象不存在，客户端会收到 701 错误
+var ErrNoSuchObject = errors.New("no such object")
+
+// Object 目录树中的一个容器或音乐条目
+type Object struct {
+	ID       string
+	ParentID string
+	Title    string
+	// Class 对象类别，为空时容器为普通目录，条目为音乐
+	Class string
+	// Container 为 true 表示容器，ChildCount 是其中的对象数
+	Container  bool
+	ChildCount int
+
+	// 以下字段只用于音乐条目，专辑容器也可以带上 Artist 和 ArtURL
+	Artist   string
+	Album    string
+	Genre    string
+	Year     int
+	Duration time.Duration
+	// URL 播放地址，以 / 开头时按请求的主机补全
+	URL      string
+	MimeType string
+	// Size 文件大小，0 表示未知
+	Size int64
+	// Transcoded 播放地址是实时转码的流，不能按字节范围跳转
+	Transcoded bool
+	// ArtURL 封面地址，与 URL 一样可以是相对地址
+	ArtURL string
+}
+
+// Directory 提供媒体服务器的目录树
+type Directory interface {
+	// Browse 返回对象本身和它的子对象（条目没有子对象），对象不存在时返回 ErrNoSuchObject
+	Browse(id string) (Object, []Object, error)
+}
+
+// ContentFeatures 媒体响应的 contentFeatures.dlna.org 头，也是 protocolInfo 的第四段
+// 文件支持按字节范围跳转，实时转码的流不支持
+func ContentFeatures(transcoded bool) string {
+	op, ci := "01", 0
+	if transcoded {
+		op, ci = "00", 1
+	}
+	// FLAGS：流式传输、后台传输和 DLNA 1.5 版本
+	return fmt.Sprintf("DLNA.ORG_OP=%s;DLNA.ORG_CI=%d;DLNA.ORG_FLAGS=01700000000000000000000000000000", op, ci)
+}
@@ -0,0 +1,253 @@
+package dlna
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxControlBody SOAP 请求体的上限，Browse 的参数很短
+	maxControlBody = 64 << 10
+	// subscriptionTimeout 事件订阅的有效期，本实现不发送事件，只为兼容会订阅的客户端
+	subscriptionTimeout = "Second-1800"
+)
+
+// sourceProtocols GetProtocolInfo 返回的来源协议，即媒体服务器可能提供的格式
+var sourceProtocols = strings.Join([]string{
+	"http-get:*:audio/mpeg:*",
+	"http-get:*:audio/flac:*",
+	"http-get:*:audio/ogg:*",
+	"http-get:*:audio/mp4:*",
+	"http-get:*:audio/aac:*",
+	"http-get:*:audio/wav:*",
+	"http-get:*:image/jpeg:*",
+}, ",")
+
+// Server 媒体服务器的 HTTP 部分：设备描述、服务描述和 SOAP 控制接口，挂载在站点根路径
+type Server struct {
+	uuid string
+	name string
+	dir  Directory
+	// updateID 目录的版本号，取启动时间，客户端缓存的目录在重启后失效
+	updateID string
+}
+
+// NewServer 创建媒体服务器，uuid 应在重启后保持不变，客户端据此识别同一台服务器
+func NewServer(uuid, name string, dir Directory) *Server {
+	return &Server{
+		uuid:     uuid,
+		name:     name,
+		dir:      dir,
+		updateID: strconv.FormatUint(uint64(uint32(time.Now().Unix())), 10),
+	}
+}
+
+// ServeHTTP 处理设备描述、服务描述、控制和事件订阅请求，其他路径返回 404
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case DescriptionPath:
+		var name strings.Builder
+		xml.EscapeText(&name, []byte(s.name))
+		writeXML(w, fmt.Sprintf(deviceDescription, name.String(), s.uuid))
+	case contentDirectorySCPDPath:
+		writeXML(w, contentDirectorySCPD)
+	case connectionManagerSCPDPath:
+		writeXML(w, connectionManagerSCPD)
+	case contentDirectoryControl:
+		s.control(w, r, ContentDirectoryService)
+	case connectionManagerControl:
+		s.control(w, r, ConnectionManagerService)
+	case contentDirectoryEvents, connectionManagerEvents:
+		subscribe(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeXML(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	io.WriteString(w, body)
+}
+
+// subscribe 接受事件订阅但不发送事件，部分电视订阅失败时会拒绝使用服务器
+func subscribe(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "SUBSCRIBE":
+		sid := r.Header.Get("SID")
+		if sid == "" {
+			b := make([]byte, 16)
+			rand.Read(b)
+			sid = "uuid:" + hex.EncodeToString(b)
+		}
+		w.Header().Set("SID", sid)
+		w.Header().Set("TIMEOUT", subscriptionTimeout)
+	case "UNSUBSCRIBE":
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// upnpError 以 SOAP Fault 返回给客户端的 UPnP 错误
+type upnpError struct {
+	code        int
+	description string
+}
+
+func (e *upnpError) Error() string {
+	return fmt.Sprintf("UPnP error %d: %s", e.code, e.description)
+}
+
+var (
+	errInvalidAction = &upnpError{401, "Invalid Action"}
+	errInvalidArgs   = &upnpError{402, "Invalid Args"}
+	errActionFailed  = &upnpError{501, "Action Failed"}
+	errNoSuchObject  = &upnpError{701, "No such object"}
+)
+
+type soapEnvelope struct {
+	Body struct {
+		Action struct {
+			XMLName xml.Name
+			Args    []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:",any"`
+	} `xml:"Body"`
+}
+
+// control 执行一个 SOAP 动作，动作名取自请求体
+func (s *Server) control(w http.ResponseWriter, r *http.Request, service string) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var env soapEnvelope
+	if err := xml.NewDecoder(io.LimitReader(r.Body, maxControlBody)).Decode(&env); err != nil {
+		writeFault(w, errInvalidAction)
+		return
+	}
+	action := env.Body.Action.XMLName.Local
+	args := make(map[string]string, len(env.Body.Action.Args))
+	for _, arg := range env.Body.Action.Args {
+		args[arg.XMLName.Local] = arg.Value
+	}
+
+	var out [][2]string
+	var err error
+	switch service + "#" + action {
+	case ContentDirectoryService + "#Browse":
+		out, err = s.browse(r, args)
+	case ContentDirectoryService + "#GetSearchCapabilities":
+		out = [][2]string{{"SearchCaps", ""}}
+	case ContentDirectoryService + "#GetSortCapabilities":
+		out = [][2]string{{"SortCaps", ""}}
+	case ContentDirectoryService + "#GetSystemUpdateID":
+		out = [][2]string{{"Id", s.updateID}}
+	case ConnectionManagerService + "#GetProtocolInfo":
+		out = [][2]string{{"Source", sourceProtocols}, {"Sink", ""}}
+	case ConnectionManagerService + "#GetCurrentConnectionIDs":
+		out = [][2]string{{"ConnectionIDs", "0"}}
+	case ConnectionManagerService + "#GetCurrentConnectionInfo":
+		out = [][2]string{
+			{"RcsID", "-1"}, {"AVTransportID", "-1"}, {"ProtocolInfo", ""},
+			{"PeerConnectionManager", ""}, {"PeerConnectionID", "-1"},
+			{"Direction", "Output"}, {"Status", "OK"},
+		}
+	default:
+		err = errInvalidAction
+	}
+	if err != nil {
+		var upnpErr *upnpError
+		if !errors.As(err, &upnpErr) {
+			log.Printf("DLNA %s failed: %v", action, err)
+			upnpErr = errActionFailed
+		}
+		writeFault(w, upnpErr)
+		return
+	}
+	writeResponse(w, service, action, out)
+}
+
+// browse 返回一个对象的元数据或它的一页子对象
+func (s *Server) browse(r *http.Request, args map[string]string) ([][2]string, error) {
+	self, children, err := s.dir.Browse(args["ObjectID"])
+	if errors.Is(err, ErrNoSuchObject) {
+		return nil, errNoSuchObject
+	}
+	if err != nil {
+		return nil, err
+	}
+	if self.Container {
+		self.ChildCount = len(children)
+	}
+
+	var objects []Object
+	var total int
+	switch args["BrowseFlag"] {
+	case "BrowseMetadata":
+		objects, total = []Object{self}, 1
+	case "BrowseDirectChildren":
+		start, err1 := strconv.Atoi(args["StartingIndex"])
+		count, err2 := strconv.Atoi(args["RequestedCount"])
+		if err1 != nil || err2 != nil || start < 0 || count < 0 {
+			return nil, errInvalidArgs
+		}
+		total = len(children)
+		start = min(start, total)
+		end := total
+		// RequestedCount 为 0 表示全部
+		if count > 0 {
+			end = min(start+count, total)
+		}
+		objects = children[start:end]
+	default:
+		return nil, errInvalidArgs
+	}
+
+	result, err := didl(objects, "http://"+r.Host)
+	if err != nil {
+		return nil, err
+	}
+	return [][2]string{
+		{"Result", result},
+		{"NumberReturned", strconv.Itoa(len(objects))},
+		{"TotalMatches", strconv.Itoa(total)},
+		{"UpdateID", s.updateID},
+	}, nil
+}
+
+// writeResponse 写出 SOAP 响应，参数按顺序输出
+func writeResponse(w http.ResponseWriter, service, action string, out [][2]string) {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&b, `<u:%sResponse xmlns:u="%s">`, action, service)
+	for _, arg := range out {
+		fmt.Fprintf(&b, "<%s>", arg[0])
+		xml.EscapeText(&b, []byte(arg[1]))
+		fmt.Fprintf(&b, "</%s>", arg[0])
+	}
+	fmt.Fprintf(&b, `</u:%sResponse></s:Body></s:Envelope>`, action)
+	w.Header().Set("EXT", "")
+	writeXML(w, b.String())
+}
+
+func writeFault(w http.ResponseWriter, e *upnpError) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>`+
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`+
+		`<s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`+
+		`<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError>`+
+		`</detail></s:Fault></s:Body></s:Envelope>`, e.code, e.description)
+}
@@ -0,0 +1,209 @@
+package dlna
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// ssdpAddr SSDP 的多播地址和端口
+var ssdpAddr = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+const (
+	// ssdpMaxAge 宣告的有效期（秒），客户端超过该时间没有收到通知就认为设备已离线
+	ssdpMaxAge = 1800
+	// ssdpNotifyInterval 重新发送上线通知的间隔，不到有效期的一半
+	ssdpNotifyInterval = 10 * time.Minute
+	// ssdpMaxDelay 回应搜索前最多等待的时间，客户端给出的 MX 更大时按它计算
+	ssdpMaxDelay = 3 * time.Second
+)
+
+// ssdpServer 写在 SSDP 消息和响应中的服务器标识
+var ssdpServer = runtime.GOOS + "/1.0 UPnP/1.0 SyncJukebox/2.0"
+
+// Advertise 在局域网内宣告媒体服务器并回应 M-SEARCH 搜索，直到 ctx 结束，结束时发送下线通知
+// port 是提供 Server 的 HTTP 端口，设备描述地址按收到请求的网卡地址生成
+func Advertise(ctx context.Context, uuid string, port int) error {
+	listener, err := net.ListenMulticastUDP("udp4", nil, ssdpAddr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	// ListenMulticastUDP 只在默认网卡上加入多播组，其余网卡单独加入，已经加入的会返回错误，忽略即可
+	group := ipv4.NewPacketConn(listener)
+	for _, iface := range multicastInterfaces() {
+		group.JoinGroup(&iface, ssdpAddr)
+	}
+	sender, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return err
+	}
+	defer sender.Close()
+
+	a := &advertiser{uuid: uuid, port: port, sender: sender}
+	go func() {
+		a.notify("ssdp:alive")
+		ticker := time.NewTicker(ssdpNotifyInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				a.notify("ssdp:byebye")
+				// 让下面的读取立即返回
+				listener.SetReadDeadline(time.Now())
+				return
+			case <-ticker.C:
+				a.notify("ssdp:alive")
+			}
+		}
+	}()
+
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := listener.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" || req.Header.Get("MAN") != `"ssdp:discover"` {
+			continue
+		}
+		go a.respond(from, req.Header.Get("ST"), req.Header.Get("MX"))
+	}
+}
+
+type advertiser struct {
+	uuid   string
+	port   int
+	sender *net.UDPConn
+}
+
+// targets 设备宣告的全部通知类型及对应的 USN
+func (a *advertiser) targets() [][2]string {
+	udn := "uuid:" + a.uuid
+	targets := [][2]string{{"upnp:rootdevice", udn + "::upnp:rootdevice"}, {udn, udn}}
+	for _, t := range []string{DeviceType, ContentDirectoryService, ConnectionManagerService} {
+		targets = append(targets, [2]string{t, udn + "::" + t})
+	}
+	return targets
+}
+
+func (a *advertiser) location(ip net.IP) string {
+	return "http://" + net.JoinHostPort(ip.String(), strconv.Itoa(a.port)) + DescriptionPath
+}
+
+// respond 按 MX 随机延迟后单播回应匹配 ST 的搜索
+func (a *advertiser) respond(to *net.UDPAddr, st, mx string) {
+	var matched [][2]string
+	for _, target := range a.targets() {
+		if st == "ssdp:all" || st == target[0] {
+			matched = append(matched, target)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+	ip := localIPFor(to)
+	if ip == nil {
+		return
+	}
+	delay := ssdpMaxDelay
+	if seconds, err := strconv.Atoi(mx); err == nil && seconds >= 0 {
+		delay = min(delay, time.Duration(seconds)*time.Second)
+	}
+	if delay > 0 {
+		time.Sleep(rand.N(delay))
+	}
+	for _, target := range matched {
+		msg := fmt.Sprintf("HTTP/1.1 200 OK\r\n"+
+			"CACHE-CONTROL: max-age=%d\r\n"+
+			"DATE: %s\r\n"+
+			"EXT:\r\n"+
+			"LOCATION: %s\r\n"+
+			"SERVER: %s\r\n"+
+			"ST: %s\r\n"+
+			"USN: %s\r\n\r\n",
+			ssdpMaxAge, time.Now().UTC().Format(http.TimeFormat), a.location(ip), ssdpServer, target[0], target[1])
+		a.sender.WriteToUDP([]byte(msg), to)
+	}
+}
+
+// notify 在每个网卡上多播上线或下线通知，LOCATION 使用该网卡的地址
+func (a *advertiser) notify(nts string) {
+	conn := ipv4.NewPacketConn(a.sender)
+	for _, iface := range multicastInterfaces() {
+		ip := interfaceIPv4(&iface)
+		if ip == nil {
+			continue
+		}
+		if err := conn.SetMulticastInterface(&iface); err != nil {
+			continue
+		}
+		for _, target := range a.targets() {
+			var msg strings.Builder
+			msg.WriteString("NOTIFY * HTTP/1.1\r\n")
+			fmt.Fprintf(&msg, "HOST: %s\r\n", ssdpAddr)
+			fmt.Fprintf(&msg, "NT: %s\r\nNTS: %s\r\nUSN: %s\r\n", target[0], nts, target[1])
+			if nts == "ssdp:alive" {
+				fmt.Fprintf(&msg, "CACHE-CONTROL: max-age=%d\r\nLOCATION: %s\r\nSERVER: %s\r\n", ssdpMaxAge, a.location(ip), ssdpServer)
+			}
+			msg.WriteString("\r\n")
+			if _, err := a.sender.WriteToUDP([]byte(msg.String()), ssdpAddr); err != nil {
+				log.Printf("Failed to send SSDP notification on %s: %v", iface.Name, err)
+				break
+			}
+		}
+	}
+}
+
+// multicastInterfaces 返回已启用、支持多播的非回环网卡
+func multicastInterfaces() []net.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var result []net.Interface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 && iface.Flags&net.FlagLoopback == 0 {
+			result = append(result, iface)
+		}
+	}
+	return result
+}
+
+func interfaceIPv4(iface *net.Interface) net.IP {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return ipNet.IP.To4()
+		}
+	}
+	return nil
+}
+
+// localIPFor 返回访问 remote 时使用的本机地址，即搜索方能访问到的地址
+func localIPFor(remote *net.UDPAddr) net.IP {
+	conn, err := net.DialUDP("udp4", nil, remote)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP
+}
@@ -0,0 +1,206 @@
+// Package federation 实现点歌台实例之间的互通
+// 跟随（follow.go）：订阅远程实例的 WebSocket，把远程的当前歌曲和进度同步到本地，
+// 本地曲库中有同一首歌时播放本地文件，否则直接播放远程的 HLS；
+// 导入（import.go）：把远程实例的曲库和播放列表合并到本地，用于合并服务器或迁移硬件
+package federation
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/state"
+)
+
+// 断线重连的等待时间，按倍数增长到上限
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// Status 跟随状态，供管理接口展示
+type Status struct {
+	Following bool   `json:"following"`
+	RemoteURL string `json:"remoteUrl,omitempty"`
+	Connected bool   `json:"connected"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// remoteState 远程状态帧中我们关心的字段
+type remoteState struct {
+	Type         string   `json:"type"` // 事件帧才有，状态帧为空
+	IsPlaying    bool     `json:"isPlaying"`
+	CurrentSong  *db.Song `json:"currentSong"`
+	ProgressMs   int64    `json:"progressMs"`
+	PlaybackRate float64  `json:"playbackRate"`
+}
+
+// Follower 管理对一个远程实例的跟随
+type Follower struct {
+	db    *db.DB
+	state *state.Manager
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	status Status
+	// songs 远程歌曲 ID 到本地歌曲的映射缓存，换一个远程实例时清空
+	songs map[string]*db.Song
+}
+
+// NewFollower 创建跟随器，调用 Start 后才开始跟随
+func NewFollower(database *db.DB, stateManager *state.Manager) *Follower {
+	return &Follower{db: database, state: stateManager}
+}
+
+// Start 开始跟随 remoteURL（例如 http://other-host:8880），已在跟随时先停止旧的
+// username 和 password 是远程实例上的账号，可以为空（匿名连接也能收到状态）
+func (f *Follower) Start(remoteURL, username, password string) error {
+	base, err := url.Parse(strings.TrimSuffix(remoteURL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return errors.New("remote URL must be an http(s) address of another jukebox")
+	}
+	wsURL := *base
+	wsURL.Scheme = strings.Replace(base.Scheme, "http", "ws", 1)
+	wsURL.Path += "/ws"
+	// 凭证放在 Authorization 头中，不出现在远程实例的访问日志里
+	header := http.Header{}
+	if username != "" {
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+	}
+
+	f.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	f.mu.Lock()
+	f.cancel = cancel
+	f.status = Status{Following: true, RemoteURL: base.String()}
+	f.songs = make(map[string]*db.Song)
+	f.mu.Unlock()
+
+	f.state.StartMirror(base.String())
+	go f.run(ctx, base.String(), wsURL.String(), header)
+	return nil
+}
+
+// Stop 停止跟随，恢复本地播放控制
+func (f *Follower) Stop() {
+	f.mu.Lock()
+	cancel := f.cancel
+	f.cancel = nil
+	f.status = Status{}
+	f.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		f.state.StopMirror()
+	}
+}
+
+// Status 返回当前跟随状态
+func (f *Follower) Status() Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status
+}
+
+// run 保持与远程实例的连接，断开后按退避时间重连，直到 ctx 结束
+func (f *Follower) run(ctx context.Context, baseURL, wsURL string, header http.Header) {
+	delay := minReconnectDelay
+	for {
+		err := f.follow(ctx, baseURL, wsURL, header)
+		if ctx.Err() != nil {
+			return
+		}
+		f.setConnected(false, err)
+		log.Printf("Federation: connection to %s lost: %v, retrying in %v", baseURL, err, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// follow 建立一次连接并持续应用收到的状态帧
+func (f *Follower) follow(ctx context.Context, baseURL, wsURL string, header http.Header) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL, header)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// ctx 结束时关闭连接，让 ReadMessage 返回
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	f.setConnected(true, nil)
+	log.Printf("Federation: following %s", baseURL)
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var msg remoteState
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Type != "" {
+			continue // 只处理状态帧
+		}
+		f.state.Mirror(f.resolve(baseURL, msg.CurrentSong), msg.IsPlaying, msg.ProgressMs, msg.PlaybackRate)
+	}
+}
+
+// resolve 把远程歌曲映射为本地歌曲：先按内容哈希，再按标题和歌手，都找不到时播放远程 HLS
+func (f *Follower) resolve(baseURL string, remote *db.Song) *db.Song {
+	if remote == nil {
+		return nil
+	}
+	f.mu.Lock()
+	cached, ok := f.songs[remote.ID]
+	f.mu.Unlock()
+	if ok {
+		return cached
+	}
+
+	song, err := f.db.FindMatchingSong(remote)
+	if err != nil {
+		song = remote
+		// 远程实例本身也在跟随别人时沿用它的地址
+		switch {
+		case song.DirectURL != "":
+			song.StreamURL = baseURL + song.DirectURL
+			song.DirectURL = ""
+		case song.HLSURL != "":
+			song.StreamURL = baseURL + song.HLSURL
+			song.HLSURL = ""
+		case song.StreamURL == "":
+			// 旧版本的远程实例按歌曲 ID 存放 HLS
+			song.StreamURL = fmt.Sprintf("%s/static/audio/%s/index.m3u8", baseURL, url.PathEscape(remote.ID))
+		}
+	}
+	f.mu.Lock()
+	if f.songs != nil {
+		f.songs[remote.ID] = song
+	}
+	f.mu.Unlock()
+	return song
+}
+
+func (f *Follower) setConnected(connected bool, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.status.Following {
+		return
+	}
+	f.status.Connected = connected
+	f.status.LastError = ""
+	if err != nil {
+		f.status.LastError = err.Error()
+	}
+}
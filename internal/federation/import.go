@@ -0,0 +1,313 @@
+package federation
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// 导入时单个请求的超时时间，HLS 切片一般只有几百 KB
+const importRequestTimeout = 2 * time.Minute
+
+// ImportOptions 从远程实例导入的参数
+type ImportOptions struct {
+	// URL 远程实例地址，例如 http://old-server:8880
+	URL string
+	// APIKey 远程实例上的凭证，格式为 "username:password"
+	APIKey string
+	// Reference 为 true 时不复制媒体文件，只记录远程地址，播放时直接从远程拉流
+	Reference bool
+}
+
+// ImportResult 导入结果
+type ImportResult struct {
+	Imported int `json:"imported"`
+	// Skipped 本地已有相同歌曲（哈希或标题+歌手相同）而跳过的数量
+	Skipped int      `json:"skipped"`
+	Failed  []string `json:"failed,omitempty"`
+	// SongIDs 远程歌曲 ID 到本地歌曲 ID 的映射，包括跳过的歌曲
+	SongIDs map[string]string `json:"-"`
+	// Playlist 远程播放列表中的歌曲对应的本地 ID，按原顺序
+	Playlist []string `json:"-"`
+}
+
+// Importer 从另一个实例拉取曲库和播放列表
+type Importer struct {
+	db       *db.DB
+	mediaDir string
+	client   *http.Client
+}
+
+// NewImporter 创建导入器，复制的媒体文件保存到 mediaDir
+func NewImporter(database *db.DB, mediaDir string) *Importer {
+	return &Importer{
+		db:       database,
+		mediaDir: mediaDir,
+		client:   &http.Client{Timeout: importRequestTimeout},
+	}
+}
+
+// Import 拉取远程曲库，按内容哈希（没有哈希时按标题+歌手）去重后写入本地
+func (im *Importer) Import(ctx context.Context, opts ImportOptions) (*ImportResult, error) {
+	base, err := url.Parse(strings.TrimSuffix(opts.URL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, errors.New("remote URL must be an http(s) address of another jukebox")
+	}
+	remote := &remoteClient{client: im.client, base: base.String(), apiKey: opts.APIKey}
+
+	var songs []db.Song
+	if err := remote.getJSON(ctx, "/api/library", &songs); err != nil {
+		return nil, fmt.Errorf("failed to fetch remote library: %w", err)
+	}
+	result := &ImportResult{SongIDs: make(map[string]string)}
+	for i := range songs {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		song := &songs[i]
+		if existing, err := im.db.FindMatchingSong(song); err == nil {
+			result.SongIDs[song.ID] = existing.ID
+			result.Skipped++
+			continue
+		}
+		localID, err := im.importSong(ctx, remote, song, opts.Reference)
+		if err != nil {
+			log.Printf("Import: failed to import %q from %s: %v", song.Title, base, err)
+			result.Failed = append(result.Failed, song.Title)
+			continue
+		}
+		result.SongIDs[song.ID] = localID
+		result.Imported++
+	}
+
+	playlist, err := remote.playlist(ctx)
+	if err != nil {
+		// 旧版本的远程实例可能没有 GraphQL，曲库已经导入，不算失败
+		log.Printf("Import: failed to fetch remote playlist: %v", err)
+	}
+	for _, remoteID := range playlist {
+		if localID, ok := result.SongIDs[remoteID]; ok {
+			result.Playlist = append(result.Playlist, localID)
+		}
+	}
+	log.Printf("Import from %s: %d imported, %d skipped, %d failed", base, result.Imported, result.Skipped, len(result.Failed))
+	return result, nil
+}
+
+// importSong 导入一首歌曲，返回本地 ID
+func (im *Importer) importSong(ctx context.Context, remote *remoteClient, song *db.Song, reference bool) (string, error) {
+	songUUID, _ := uuid.NewV4()
+	localID := songUUID.String()
+	now := time.Now()
+	// 旧版本的远程实例按歌曲 ID 存放 HLS，新版本给出 hls_url（按内容哈希存放）
+	playlistPath := "/static/audio/" + url.PathEscape(song.ID) + "/index.m3u8"
+	// 远程实例没有 ffmpeg 时歌曲是未转码的原始文件
+	for _, p := range []string{song.HLSURL, song.DirectURL} {
+		if p == "" {
+			continue
+		}
+		if !strings.HasPrefix(p, "/static/audio/") || strings.Contains(p, "..") {
+			return "", fmt.Errorf("unexpected media path %q", p)
+		}
+		playlistPath = p
+	}
+
+	imported := &db.Song{
+		ID:          localID,
+		Title:       song.Title,
+		Artist:      song.Artist,
+		Album:       song.Album,
+		AlbumArtist: song.AlbumArtist,
+		Compilation: song.Compilation,
+		Genre:       song.Genre,
+		Year:        song.Year,
+		DurationMs:  song.DurationMs,
+		Source:      song.Source,
+		Explicit:    song.Explicit,
+		ContentHash: song.ContentHash,
+		GainDb:      song.GainDb,
+		// 保留远程的原始文件名，来源地址记为远程实例
+		OriginalFilename: song.OriginalFilename,
+		UploadedAt:       &now,
+		SourceURL:        remote.base,
+	}
+	for _, ch := range song.Chapters {
+		imported.Chapters = append(imported.Chapters, db.Chapter{Index: ch.Index, Title: ch.Title, StartMs: ch.StartMs, EndMs: ch.EndMs})
+	}
+	for _, r := range song.SkipRegions {
+		imported.SkipRegions = append(imported.SkipRegions, db.SkipRegion{StartMs: r.StartMs, EndMs: r.EndMs, Label: r.Label})
+	}
+
+	// 远程歌曲本身就是引用时只能继续引用
+	if reference || song.StreamURL != "" {
+		imported.Source = db.SourceRemote
+		imported.FilePath = song.StreamURL
+		if imported.FilePath == "" {
+			imported.FilePath = remote.base + playlistPath
+		}
+		return localID, im.db.AddSong(imported)
+	}
+
+	songDir := filepath.Join(im.mediaDir, localID)
+	if err := os.MkdirAll(songDir, 0755); err != nil {
+		return "", err
+	}
+	var err error
+	if song.DirectURL != "" {
+		err = remote.download(ctx, playlistPath, filepath.Join(songDir, path.Base(playlistPath)))
+	} else {
+		err = remote.copyHLS(ctx, playlistPath, songDir)
+	}
+	if err != nil {
+		os.RemoveAll(songDir)
+		return "", err
+	}
+	imported.FilePath = localID + "/" + path.Base(playlistPath)
+	if err := im.db.AddSong(imported); err != nil {
+		os.RemoveAll(songDir)
+		return "", err
+	}
+	return localID, nil
+}
+
+// remoteClient 访问远程实例的 HTTP 接口
+type remoteClient struct {
+	client *http.Client
+	base   string
+	apiKey string
+}
+
+func (r *remoteClient) get(ctx context.Context, p string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.base+p, nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.apiKey != "" {
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(r.apiKey)))
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", p, resp.Status)
+	}
+	return resp, nil
+}
+
+func (r *remoteClient) getJSON(ctx context.Context, p string, v interface{}) error {
+	resp, err := r.get(ctx, p)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// playlist 通过远程的 GraphQL 接口读取当前播放列表，返回远程歌曲 ID
+func (r *remoteClient) playlist(ctx context.Context) ([]string, error) {
+	var resp struct {
+		Data struct {
+			Playlist []struct {
+				Song struct {
+					ID string `json:"id"`
+				} `json:"song"`
+			} `json:"playlist"`
+		} `json:"data"`
+	}
+	if err := r.getJSON(ctx, "/api/graphql?query="+url.QueryEscape("{playlist{song{id}}}"), &resp); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(resp.Data.Playlist))
+	for _, item := range resp.Data.Playlist {
+		ids = append(ids, item.Song.ID)
+	}
+	return ids, nil
+}
+
+// copyHLS 下载 HLS 索引及其引用的所有切片到 dir
+func (r *remoteClient) copyHLS(ctx context.Context, playlistPath, dir string) error {
+	return r.copyPlaylist(ctx, playlistPath, dir, true)
+}
+
+// hlsMapURI 匹配 fMP4 切片的初始化段 #EXT-X-MAP:URI="init.mp4"
+var hlsMapURI = regexp.MustCompile(`^#EXT-X-MAP:.*URI="([^"]+)"`)
+
+// copyPlaylist 复制一个索引，master 为 true 时允许引用各码率的索引（只展开一层）
+func (r *remoteClient) copyPlaylist(ctx context.Context, playlistPath, dir string, master bool) error {
+	resp, err := r.get(ctx, playlistPath)
+	if err != nil {
+		return err
+	}
+	index, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(index))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if m := hlsMapURI.FindStringSubmatch(line); m != nil {
+			line = m[1]
+		} else if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// 只接受同目录下的切片，防止远程索引写到别处
+		if line != filepath.Base(line) || line == ".." {
+			return fmt.Errorf("unexpected segment path %q", line)
+		}
+		variantPath := path.Dir(playlistPath) + "/" + url.PathEscape(line)
+		// 多码率的主索引引用同目录下各码率的索引
+		if strings.HasSuffix(line, ".m3u8") {
+			if !master {
+				return fmt.Errorf("unexpected nested playlist %q", line)
+			}
+			if err := r.copyPlaylist(ctx, variantPath, dir, false); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := r.download(ctx, variantPath, filepath.Join(dir, line)); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, path.Base(playlistPath)), index, 0644)
+}
+
+func (r *remoteClient) download(ctx context.Context, p, dest string) error {
+	resp, err := r.get(ctx, p)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,195 @@
+// Package hooks 在特定事件发生时调用运维人员配置的外部钩子，
+// 可以执行脚本、请求 HTTP 接口或加载 Go 插件，用于灯光联动、日志、审核等扩展
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"plugin"
+	"time"
+
+	"github.com/yeeeck/sync-jukebox/internal/config"
+)
+
+// 支持的事件
+const (
+	SongChanged      = "song_changed"
+	UploadCompleted  = "upload_completed"
+	UserRegistered   = "user_registered"
+	SongReported     = "song_reported"
+	BandwidthWarning = "bandwidth_warning"
+)
+
+// 钩子类型
+const (
+	TypeExec   = "exec"
+	TypeHTTP   = "http"
+	TypePlugin = "plugin"
+)
+
+// defaultTimeout 钩子未配置超时时使用的默认值
+const defaultTimeout = 10 * time.Second
+
+// Payload 是发送给钩子的 JSON 内容
+// exec 钩子从标准输入读取，http 钩子作为 POST 请求体，插件作为参数
+type Payload struct {
+	Event string      `json:"event"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+// PluginHandler 是 Go 插件需要导出的 Handle 函数的签名
+type PluginHandler = func(event string, payload []byte) error
+
+type handler func(ctx context.Context, event string, payload []byte) error
+
+type hook struct {
+	name    string
+	timeout time.Duration
+	run     handler
+}
+
+// Dispatcher 按事件分发钩子，nil Dispatcher 上的调用什么也不做
+type Dispatcher struct {
+	hooks map[string][]hook
+}
+
+// New 根据配置创建 Dispatcher，插件在此时加载，配置错误会直接返回
+func New(cfgs []config.HookConfig) (*Dispatcher, error) {
+	d := &Dispatcher{hooks: make(map[string][]hook)}
+	for i, cfg := range cfgs {
+		switch cfg.Event {
+		case SongChanged, UploadCompleted, UserRegistered, SongReported, BandwidthWarning:
+		default:
+			return nil, fmt.Errorf("hook %d: unknown event %q", i, cfg.Event)
+		}
+		run, err := newHandler(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("hook %d (%s): %w", i, cfg.Event, err)
+		}
+		timeout := defaultTimeout
+		if cfg.TimeoutSeconds > 0 {
+			timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+		}
+		d.hooks[cfg.Event] = append(d.hooks[cfg.Event], hook{
+			name:    fmt.Sprintf("%s#%d", cfg.Type, i),
+			timeout: timeout,
+			run:     run,
+		})
+	}
+	return d, nil
+}
+
+func newHandler(cfg config.HookConfig) (handler, error) {
+	switch cfg.Type {
+	case TypeExec:
+		if len(cfg.Command) == 0 {
+			return nil, fmt.Errorf("exec hook requires a command")
+		}
+		return execHandler(cfg.Command), nil
+	case TypeHTTP:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("http hook requires a url")
+		}
+		return httpHandler(cfg.URL), nil
+	case TypePlugin:
+		return pluginHandler(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unknown hook type %q", cfg.Type)
+	}
+}
+
+// Fire 异步触发某个事件的所有钩子，不会阻塞调用方
+// 钩子失败只记录日志，不影响播放器本身
+func (d *Dispatcher) Fire(event string, data interface{}) {
+	if d == nil || len(d.hooks[event]) == 0 {
+		return
+	}
+	payload, err := json.Marshal(Payload{Event: event, Time: time.Now(), Data: data})
+	if err != nil {
+		log.Printf("Hooks: failed to encode %s payload: %v", event, err)
+		return
+	}
+	for _, h := range d.hooks[event] {
+		go func(h hook) {
+			ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+			defer cancel()
+			if err := h.run(ctx, event, payload); err != nil {
+				log.Printf("Hooks: %s hook %s failed: %v", event, h.name, err)
+			}
+		}(h)
+	}
+}
+
+// execHandler 执行外部命令，事件名通过环境变量 JUKEBOX_EVENT 传入，内容写入标准输入
+func execHandler(command []string) handler {
+	return func(ctx context.Context, event string, payload []byte) error {
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+		cmd.Env = append(os.Environ(), "JUKEBOX_EVENT="+event)
+		cmd.Stdin = bytes.NewReader(payload)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+}
+
+// httpHandler 以 JSON 请求体 POST 到指定地址，非 2xx 响应视为失败
+func httpHandler(url string) handler {
+	return func(ctx context.Context, event string, payload []byte) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Jukebox-Event", event)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+// pluginHandler 加载导出 Handle 函数（签名见 PluginHandler）的 Go 插件
+// 插件在同一进程内运行，超时只能让调用方停止等待，无法中断插件本身
+func pluginHandler(path string) (handler, error) {
+	if path == "" {
+		return nil, fmt.Errorf("plugin hook requires a path")
+	}
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load plugin: %w", err)
+	}
+	sym, err := p.Lookup("Handle")
+	if err != nil {
+		return nil, err
+	}
+	handle, ok := sym.(PluginHandler)
+	if !ok {
+		if ptr, isPtr := sym.(*PluginHandler); isPtr {
+			handle = *ptr
+		} else {
+			return nil, fmt.Errorf("plugin Handle has type %T, want func(string, []byte) error", sym)
+		}
+	}
+	return func(ctx context.Context, event string, payload []byte) error {
+		done := make(chan error, 1)
+		go func() { done <- handle(event, payload) }()
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, nil
+}
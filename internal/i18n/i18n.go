@@ -0,0 +1,118 @@
+// Package i18n 把服务端产生的面向用户的文字（错误信息、分享页面等）翻译成用户的语言
+// 代码中的文字统一写英文，同时作为翻译目录的键；没有翻译的文字原样返回
+package i18n
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// 支持的语言
+const (
+	English = "en"
+	Chinese = "zh"
+)
+
+// Supported 支持的语言，第一个是默认语言
+var Supported = []string{English, Chinese}
+
+// ContextKey 请求上下文中用户偏好语言的键，由认证中间件按用户设置写入
+const ContextKey = "language"
+
+var matcher = language.NewMatcher([]language.Tag{language.English, language.Chinese})
+
+// catalogs 各语言的翻译，英文不需要翻译
+var catalogs = map[string]*catalog{
+	Chinese: newCatalog(chineseMessages, chinesePatterns),
+}
+
+// catalog 一种语言的翻译：固定文字直接查表，带参数的文字按模式匹配
+type catalog struct {
+	messages map[string]string
+	patterns []pattern
+}
+
+// pattern 带参数的文字，英文原文中的格式化动词（%s、%d 等）匹配任意内容，
+// 匹配到的参数再翻译一次后按顺序填入译文的 %s
+type pattern struct {
+	re          *regexp.Regexp
+	translation string
+}
+
+var verbPattern = regexp.MustCompile(`%(\.\d+)?[sdvqfw]`)
+
+func newCatalog(messages map[string]string, patterns [][2]string) *catalog {
+	c := &catalog{messages: messages}
+	for _, p := range patterns {
+		parts := verbPattern.Split(p[0], -1)
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+		expr := "^" + strings.Join(parts, "(.+?)") + "$"
+		c.patterns = append(c.patterns, pattern{re: regexp.MustCompile(expr), translation: p[1]})
+	}
+	return c
+}
+
+// Normalize 把语言标签（例如 zh-CN、en_US）规范为支持的语言，不支持时返回 false
+func Normalize(tag string) (string, bool) {
+	parsed, err := language.Parse(strings.ReplaceAll(tag, "_", "-"))
+	if err != nil {
+		return "", false
+	}
+	base, _ := parsed.Base()
+	for _, lang := range Supported {
+		if base.String() == lang {
+			return lang, true
+		}
+	}
+	return "", false
+}
+
+// Negotiate 按 Accept-Language 选择语言，没有可用的语言时返回默认语言
+func Negotiate(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return Supported[0]
+	}
+	_, index, confidence := matcher.Match(tags...)
+	if confidence == language.No {
+		return Supported[0]
+	}
+	return Supported[index]
+}
+
+// T 把英文文字翻译成 lang，没有翻译时原样返回
+func T(lang, message string) string {
+	c, ok := catalogs[lang]
+	if !ok || message == "" {
+		return message
+	}
+	return c.translate(message)
+}
+
+// Tf 格式化后翻译，用于带参数的文字，format 需要在目录的模式中
+func Tf(lang, format string, args ...interface{}) string {
+	return T(lang, fmt.Sprintf(format, args...))
+}
+
+func (c *catalog) translate(message string) string {
+	if translated, ok := c.messages[message]; ok {
+		return translated
+	}
+	for _, p := range c.patterns {
+		match := p.re.FindStringSubmatch(message)
+		if match == nil {
+			continue
+		}
+		args := make([]interface{}, len(match)-1)
+		for i, arg := range match[1:] {
+			args[i] = c.translate(arg)
+		}
+		return fmt.Sprintf(p.translation, args...)
+	}
+	return message
+}
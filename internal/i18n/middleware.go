@@ -0,0 +1,98 @@
+package i18n
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Language 返回请求使用的语言：用户设置的偏好优先，其次是 Accept-Language
+func Language(c *gin.Context) string {
+	if lang := c.GetString(ContextKey); lang != "" {
+		return lang
+	}
+	return Negotiate(c.GetHeader("Accept-Language"))
+}
+
+// Middleware 翻译错误响应 {"error": "..."} 中的文字，其他字段（例如 code）保持不变
+// 处理函数照常写英文，语言在响应写完时才确定，认证中间件读到的用户偏好因此也能生效
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w := &writer{ResponseWriter: c.Writer, c: c}
+		c.Writer = w
+		c.Next()
+		w.finish()
+	}
+}
+
+// writer 缓存 JSON 格式的错误响应，其余响应直接写出
+type writer struct {
+	gin.ResponseWriter
+	c       *gin.Context
+	decided bool
+	capture bool
+	buf     bytes.Buffer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.decided = true
+		w.capture = w.Status() >= http.StatusBadRequest && isJSON(w.Header().Get("Content-Type"))
+	}
+	if w.capture {
+		return w.buf.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *writer) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush 错误响应不需要逐段发送，已缓存的内容留到 finish 时翻译
+func (w *writer) Flush() {
+	if !w.capture {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *writer) finish() {
+	if !w.capture {
+		return
+	}
+	body := w.buf.Bytes()
+	if lang := Language(w.c); catalogs[lang] != nil {
+		body = translateError(lang, body)
+	}
+	w.ResponseWriter.Write(body)
+}
+
+// translateError 翻译 JSON 对象中的 error 字段，不是这种格式时原样返回
+func translateError(lang string, body []byte) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return body
+	}
+	var message string
+	if err := json.Unmarshal(fields["error"], &message); err != nil {
+		return body
+	}
+	translated, err := json.Marshal(T(lang, message))
+	if err != nil {
+		return body
+	}
+	fields["error"] = translated
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
+}
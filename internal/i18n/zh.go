@@ -0,0 +1,338 @@
+package i18n
+
+// chineseMessages 固定文字的中文翻译，键是代码中的英文原文，修改原文时需要同步修改这里
+var chineseMessages = map[string]string{
+	// 认证和权限
+	"Authorization header not provided":                              "请先登录",
+	"Invalid credentials":                                            "用户名或密码错误",
+	"Insufficient privileges":                                        "权限不足",
+	"Invalid or expired invitation key":                              "邀请密钥无效或已过期",
+	"Username already exists":                                        "用户名已存在",
+	"Username, password, and key are required":                       "请填写用户名、密码和邀请密钥",
+	"Failed to create user":                                          "创建用户失败",
+	"User not found":                                                 "用户不存在",
+	"Invalid role":                                                   "无效的角色",
+	"Failed to update role":                                          "修改角色失败",
+	"Failed to export account":                                       "导出账号数据失败",
+	"Failed to delete account":                                       "删除账号失败",
+	"The last admin account cannot be deleted":                       "不能删除唯一的管理员账号",
+	"username and role are required":                                 "请指定用户名和角色",
+	"Guests can only queue songs and vote":                           "访客只能点歌和投票",
+	"CSRF token missing or invalid":                                  "页面已过期，请刷新后重试",
+	"Token is required":                                              "缺少令牌",
+	"Single sign-on is not enabled":                                  "没有启用单点登录",
+	"Identity provider is unavailable":                               "暂时无法连接身份提供方",
+	"Failed to generate pairing code":                                "生成配对二维码失败",
+	"Pairing code expired, please scan a new one":                    "配对二维码已过期，请重新扫描",
+	"Failed to sign in":                                              "登录失败",
+	"Failed to sign out":                                             "退出登录失败",
+	"Failed to remember device":                                      "记住此设备失败",
+	"Failed to list devices":                                         "获取已登录设备失败",
+	"Failed to sign out device":                                      "注销设备失败",
+	"Invalid device ID":                                              "设备 ID 无效",
+	"Device not found":                                               "设备不存在",
+	"Not signed in through an authentication proxy":                  "没有通过认证代理登录",
+	"code is required":                                               "缺少登录代码",
+	"Sign-in link expired, please try again":                         "登录已过期，请重新登录",
+	"Sign-in was cancelled or rejected by the identity provider":     "登录被取消或被身份提供方拒绝",
+	"Failed to verify the identity provider's response":              "无法验证身份提供方的响应",
+	"The identity provider did not return a usable username":         "身份提供方没有返回可用的用户名",
+	"Your account is not allowed to sign in to this jukebox":         "你的账号不能登录这台点歌机",
+	"No account is linked to this identity, ask an admin to link it": "这个身份还没有关联账号，请联系管理员",
+	"A local account with this username already exists":              "已有同名的本地账号",
+	"token is required":                                              "缺少令牌",
+
+	// 派对和访客
+	"Invalid or expired join link":    "加入链接无效或已过期",
+	"Join link not found":             "加入链接不存在",
+	"Failed to generate join link":    "生成加入链接失败",
+	"Failed to join party":            "加入派对失败",
+	"token and nickname are required": "请填写加入链接和昵称",
+	"ttlMinutes must be at most 7 days and maxGuests must not be negative": "有效期不能超过 7 天，访客人数不能为负数",
+	"join link is invalid or expired":                                      "加入链接无效或已过期",
+	"join link has reached its guest limit":                                "加入链接的访客人数已满",
+	"nickname is already taken":                                            "昵称已被使用",
+	"nickname must be 1-24 printable characters without ':'":               "昵称须为 1 到 24 个可打印字符，且不能包含冒号",
+	"invalid guest credentials":                                            "访客凭证无效",
+	"Failed to start party session":                                        "开始派对失败",
+	"Failed to end party session":                                          "结束派对失败",
+	"Failed to get party session":                                          "获取派对失败",
+	"Failed to get party sessions":                                         "获取派对列表失败",
+	"Party session not found":                                              "派对不存在",
+	"Failed to build recap":                                                "生成派对回顾失败",
+	"Invalid session ID":                                                   "无效的派对 ID",
+	"invalid session ID":                                                   "无效的派对 ID",
+	"a party session is already running":                                   "已有正在进行的派对",
+	"no party session is running":                                          "当前没有进行中的派对",
+
+	// 曲库
+	"Song not found":                  "歌曲不存在",
+	"Failed to get song":              "获取歌曲失败",
+	"Failed to get library":           "获取曲库失败",
+	"Failed to update song":           "修改歌曲失败",
+	"Failed to update songs":          "修改歌曲失败",
+	"Failed to remove songs":          "删除歌曲失败",
+	"Failed to restore song":          "恢复歌曲失败",
+	"Failed to get trash":             "获取回收站失败",
+	"Song is not in the trash":        "歌曲不在回收站中",
+	"Error updating song in database": "保存歌曲信息失败",
+	"Error retrieving the file":       "读取上传的文件失败",
+	"Error reading the file":          "读取文件失败",
+	"Error saving temporary file":     "保存临时文件失败",
+	"Failed to convert audio to HLS":  "音频转换失败",
+	"Conversion was cancelled":        "转换已取消",
+	"Song is streamed from another jukebox and has no local media to replace": "这首歌来自另一台点歌机，没有可替换的本地文件",
+	"ffmpeg is not installed, only MP3, FLAC and Ogg files can be added":      "服务器没有安装 ffmpeg，只能添加 MP3、FLAC 和 Ogg 文件",
+	"songId is required":                                   "请指定歌曲",
+	"You can only change songs you uploaded":               "只能修改或删除自己上传的歌曲",
+	"Failed to create websocket ticket":                    "创建实时连接票据失败",
+	"Media cookies are not available on this server":       "此服务器无法签发媒体 Cookie",
+	"Sign in to play media":                                "登录后才能播放",
+	"songIds is required":                                  "请指定歌曲",
+	"id is required":                                       "缺少 ID",
+	"query is required":                                    "请输入搜索内容",
+	"Too many songs in one request":                        "一次选择的歌曲太多",
+	"action and songIds are required":                      "请指定操作和歌曲",
+	"action must be delete, retag or add-to-playlist":      "操作只能是删除、修改标签或加入播放列表",
+	"artist, albumArtist or genre is required for retag":   "修改标签时请至少填写歌手、专辑歌手或流派之一",
+	"nothing to change, set artist, album artist or genre": "没有要修改的内容，请填写歌手、专辑歌手或流派",
+	"regions is required":                                  "请指定跳过的片段",
+	"skip regions must not overlap":                        "跳过的片段不能重叠",
+	"format must be zip or tar":                            "格式只能是 zip 或 tar",
+	"media must be hls or original":                        "文件类型只能是 hls 或 original",
+	"Failed to build manifest":                             "生成清单失败",
+	"A rescan is already running":                          "重新扫描正在进行中",
+	"url is required":                                      "请输入链接",
+	"url must be an http(s) link to an audio file":         "链接必须是指向音频文件的 http(s) 地址",
+	"path is required":                                     "请指定路径",
+	"path must be absolute":                                "路径必须是绝对路径",
+	"path does not exist":                                  "路径不存在",
+	"path is not an audio file":                            "路径不是音频文件",
+	"path is not inside a configured reference root":       "路径不在允许引用的目录中",
+	"Reference mode is not enabled, set library.referenceRoots in the config": "没有启用原地引用，请在配置中设置 library.referenceRoots",
+
+	// 播放列表和播放
+	"Failed to add song to playlist":                                            "加入播放列表失败",
+	"at least one of decade, yearFrom, yearTo, genre or artist is required":     "请至少设置年代、起止年份、流派或歌手中的一项",
+	"decade must be the first year of a decade, e.g. 1990":                      "年代须为整十年份，例如 1990",
+	"yearFrom must not be after yearTo":                                         "起始年份不能晚于结束年份",
+	"limit must not be negative":                                                "数量上限不能为负数",
+	"Failed to get playlist":                                                    "获取播放列表失败",
+	"Failed to get playlists":                                                   "获取歌单失败",
+	"Failed to save playlist":                                                   "保存歌单失败",
+	"Failed to shuffle playlist":                                                "随机排序失败",
+	"Failed to remove song from playlist":                                       "从播放列表移除歌曲失败",
+	"Playlist not found":                                                        "歌单不存在",
+	"playlistId is required":                                                    "请指定歌单",
+	"name and rules are required":                                               "请填写名称和规则",
+	"songId and index are required":                                             "请指定歌曲和位置",
+	"newIndex must be >= 0":                                                     "位置不能为负数",
+	"index out of bounds":                                                       "位置超出范围",
+	"newIndex out of bounds":                                                    "位置超出范围",
+	"index is required":                                                         "请指定位置",
+	"no playable song at or after this position":                                "该位置及之后没有可以播放的歌曲",
+	"song not found in playlist":                                                "歌曲不在播放列表中",
+	"playlist must be a saved playlist ID":                                      "请指定已保存的歌单",
+	"playlist was modified concurrently, refresh and retry":                     "播放列表已被其他人修改，请刷新后重试",
+	"ordering must contain every song in the playlist exactly once":             "新的顺序必须包含播放列表中的每首歌且只出现一次",
+	"mode is required":                                                          "请指定模式",
+	"index or direction (next/prev) is required":                                "请指定章节序号或方向（next/prev）",
+	"current song has no chapters":                                              "当前歌曲没有章节",
+	"chapter index out of bounds":                                               "章节序号超出范围",
+	"no more chapters in that direction":                                        "该方向没有更多章节了",
+	"deviceId and volume are required":                                          "请指定设备和音量",
+	"deviceId is required":                                                      "请指定设备",
+	"device not connected":                                                      "设备未连接",
+	"sign in to register a device":                                              "登录后才能登记设备",
+	"device is registered by another user":                                      "该设备已被其他用户登记",
+	"device is not an output device":                                            "该设备不是输出设备",
+	"AirPlay output is not enabled":                                             "未开启 AirPlay 输出",
+	"AirPlay output requires ffmpeg":                                            "AirPlay 输出需要安装 ffmpeg",
+	"Failed to search for AirPlay speakers":                                     "搜索 AirPlay 音箱失败",
+	"Speaker not found, search for speakers first":                              "找不到该音箱，请先搜索音箱",
+	"This speaker requires an encrypted AirPlay stream, which is not supported": "该音箱需要加密的 AirPlay 音频流，暂不支持",
+	"Failed to connect to speaker":                                              "连接音箱失败",
+	"Speaker is not connected":                                                  "音箱未连接",
+	"Invalid or disabled trigger token":                                         "触发令牌无效或已停用",
+	"Failed to check trigger token":                                             "检查触发令牌失败",
+	"Unknown trigger, use play, pause, toggle, next or queue":                   "未知的触发动作，可用的有 play、pause、toggle、next 和 queue",
+	"playlist must be a playlist ID":                                            "playlist 必须是歌单 ID",
+	"playlist is required, the token has no default playlist":                   "令牌没有默认歌单，需要指定 playlist",
+	"format must be json or csv":                                                "format 必须是 json 或 csv",
+	"from and to must be a date (2006-01-02) or an RFC 3339 time":               "from 和 to 必须是日期（2006-01-02）或 RFC 3339 时间",
+	"Failed to get scrobbles":                                                   "获取收听记录失败",
+	"tz must be an IANA time zone such as Europe/Berlin":                        "tz 必须是 IANA 时区，例如 Asia/Shanghai",
+	"from must be before to":                                                    "from 必须早于 to",
+	"Failed to get listening activity":                                          "获取收听统计失败",
+	"limit must be a positive number":                                           "limit 必须是正整数",
+	"minPlays must be a positive number":                                        "minPlays 必须是正整数",
+	"reason must be bad_quality, wrong_vibe or duplicate":                       "原因必须是 bad_quality、wrong_vibe 或 duplicate",
+	"Failed to get top lists":                                                   "获取排行榜失败",
+	"days, minPlays and limit must be positive numbers":                         "days、minPlays 和 limit 必须是正整数",
+	"Failed to get forgotten songs":                                             "获取被遗忘的好歌失败",
+	"song is not a current suggestion":                                          "这首歌不在当前的推荐中",
+	"no songs are being suggested":                                              "当前没有推荐的歌曲",
+	"mood is required":                                                          "缺少 mood",
+	"minutes must be between 1 and 600":                                         "minutes 必须在 1 到 600 之间",
+	"action must be load, save or preview":                                      "action 必须是 load、save 或 preview",
+	"No songs match this mood":                                                  "没有符合这种情绪的歌曲",
+	"genre or mood is required":                                                 "缺少 genre 或 mood",
+	"External analysis is not enabled":                                          "外部分析未启用",
+	"Invalid analysis worker token":                                             "分析 worker 令牌无效",
+	"worker is required":                                                        "缺少 worker",
+	"Failed to lease analysis tasks":                                            "领取分析任务失败",
+	"Invalid task ID":                                                           "任务 ID 无效",
+	"Analysis task not found":                                                   "分析任务不存在",
+	"Failed to get analysis task":                                               "获取分析任务失败",
+	"Analysis task is already finished":                                         "分析任务已结束",
+	"Invalid analysis result":                                                   "分析结果格式无效",
+	"bpm must be between 0 and 400":                                             "bpm 必须在 0 到 400 之间",
+	"Unrecognized musical key":                                                  "无法识别的调性",
+	"Failed to save analysis result":                                            "保存分析结果失败",
+	"Failed to update analysis task":                                            "更新分析任务失败",
+	"Failed to get analysis tasks":                                              "获取分析任务失败",
+	"songIds or missing is required":                                            "需要指定 songIds 或 missing",
+	"Failed to queue analysis":                                                  "加入分析队列失败",
+	"command is required":                                                       "缺少命令",
+	"seek_position is required":                                                 "缺少 seek_position",
+	"volume_level is required":                                                  "缺少 volume_level",
+	"timeout must be a number of seconds":                                       "timeout 必须是秒数",
+	"No output devices are connected":                                           "没有连接的输出设备",
+	"Unsupported command, use media_play, media_pause, media_play_pause, media_stop, media_next_track, media_previous_track, media_seek, volume_set, volume_up or volume_down": "不支持的命令，可用的命令有 media_play、media_pause、media_play_pause、media_stop、media_next_track、media_previous_track、media_seek、volume_set、volume_up 和 volume_down",
+	"volume must be between 0 and 1":                         "音量必须在 0 到 1 之间",
+	"Skip limit reached, try again later":                    "切歌次数已用完，请稍后再试",
+	"Too many pending requests, wait for your songs to play": "你点的歌太多了，等它们播放后再点",
+	"Not enough request budget for all songs":                "剩余的点歌额度不够点这么多歌",
+	"Too many requests, slow down":                           "请求太频繁，请稍后再试",
+	"explicit songs are not allowed while family mode is on": "家庭模式下不能播放含露骨内容的歌曲",
+	"this song is blocked":                                   "这首歌已被屏蔽",
+	"this song is not allowed by the room policy":            "根据房间规则，这首歌不能点播",
+	"no song is currently playing":                           "当前没有正在播放的歌曲",
+
+	// 投票
+	"a poll is already running":            "已有进行中的投票",
+	"no poll is running":                   "当前没有进行中的投票",
+	"song is not a candidate in this poll": "这首歌不在本次投票的候选中",
+	"duplicate candidate":                  "候选歌曲重复",
+	"login required to vote":               "登录后才能投票",
+
+	// 黑名单
+	"Blocklist entry not found":           "黑名单条目不存在",
+	"Failed to remove blocklist entry":    "移除黑名单条目失败",
+	"songId or artistPattern is required": "请指定歌曲或歌手规则",
+	"invalid artist pattern":              "歌手规则无效",
+
+	// 公告
+	"message is required":             "请填写公告内容",
+	"expiresAt must be in the future": "过期时间必须晚于现在",
+
+	// 预定播放
+	"songId and at are required": "请指定歌曲和开始时间",
+	"at must be in the future":   "开始时间必须晚于现在",
+	"no drop is scheduled":       "当前没有预定的歌曲",
+
+	// 举报
+	"reason is required":                          "请填写举报理由",
+	"reason must be at most 500 characters":       "举报理由不能超过 500 个字",
+	"You have already reported this song":         "你已经举报过这首歌，请等待管理员处理",
+	"Failed to save report":                       "提交举报失败",
+	"Unknown report status":                       "未知的举报状态",
+	"Failed to get reports":                       "获取举报失败",
+	"Invalid report ID":                           "举报 ID 无效",
+	"Report not found":                            "举报不存在",
+	"Report has already been resolved":            "举报已经处理过了",
+	"Failed to resolve report":                    "处理举报失败",
+	"action must be dismiss, blocklist or delete": "处理方式必须是 dismiss、blocklist 或 delete",
+
+	// 评论
+	"text is required":                                    "请填写评论内容",
+	"text must be at most 280 characters":                 "评论不能超过 280 个字",
+	"Failed to get comments":                              "获取评论失败",
+	"Failed to save comment":                              "发表评论失败",
+	"Invalid comment ID":                                  "评论 ID 无效",
+	"Comment not found":                                   "评论不存在",
+	"Only the author or an admin can delete this comment": "只有作者或管理员可以删除这条评论",
+	"Failed to delete comment":                            "删除评论失败",
+	"hidden is required":                                  "请指定是否隐藏",
+	"Failed to update comment":                            "修改评论失败",
+
+	// 音效板
+	"clip must be at most 5 MB":          "片段不能超过 5 MB",
+	"clip must be at most 15 seconds":    "片段不能超过 15 秒",
+	"name must be 1 to 40 characters":    "名称必须为 1 到 40 个字",
+	"file is not a supported audio file": "不支持的音频文件",
+	"Failed to get clips":                "获取片段失败",
+	"Failed to save clip":                "保存片段失败",
+	"Failed to delete clip":              "删除片段失败",
+	"Invalid clip ID":                    "片段 ID 无效",
+	"Clip not found":                     "片段不存在",
+
+	// 任务和维护
+	"Job not found":            "任务不存在",
+	"job not found":            "任务不存在",
+	"job has already finished": "任务已经结束",
+	"job was cancelled":        "任务已取消",
+	"Only the uploader or a DJ can cancel this job":       "只有上传者或 DJ 可以取消这个任务",
+	"Task is already running":                             "任务正在执行中",
+	"Unknown maintenance task":                            "未知的维护任务",
+	"task is required":                                    "请指定任务",
+	"task and enabled are required":                       "请指定任务和开关",
+	"Failed to save maintenance settings":                 "保存维护设置失败",
+	"Failed to measure storage":                           "统计存储空间失败",
+	"enabled is required":                                 "请指定开关",
+	"The jukebox is in maintenance mode, try again later": "点歌机正在维护，请稍后再试",
+	"This instance is a read-only replica":                "这是只读副本，请到主实例上操作",
+
+	// 其他
+	"Invalid request body":                          "请求内容格式错误",
+	"Too many simultaneous streams":                 "同时播放的音频太多，请稍后再试",
+	"monthly bandwidth limit reached":               "本月的流量已用完",
+	"your monthly bandwidth limit has been reached": "你本月的流量已用完",
+	"month must be in YYYY-MM format":               "月份格式应为 YYYY-MM",
+	"Failed to get bandwidth usage":                 "获取流量统计失败",
+	"Invalid variables":                             "GraphQL 变量格式错误",
+	"Unsupported language":                          "不支持的语言",
+	"Failed to save language":                       "保存语言设置失败",
+	"Database error":                                "数据库错误",
+	"Internal server error":                         "服务器内部错误",
+	"No leader instance available, try again later": "暂时没有可用的主实例，请稍后再试",
+	"record not found":                              "记录不存在",
+
+	// 正在播放页面
+	"Now playing":     "正在播放",
+	"Paused":          "已暂停",
+	"Nothing playing": "没有在播放",
+	"Join the party":  "加入派对",
+	"1 listener":      "1 人在听",
+}
+
+// chinesePatterns 带参数的文字，参数位置在译文中统一写 %s，顺序不同时用 %[n]s
+var chinesePatterns = [][2]string{
+	{"Failed to fetch URL: %s", "获取链接失败：%s"},
+	{"Failed to remove song: %s", "删除歌曲失败：%s"},
+	{"message must be at most %d characters", "公告内容不能超过 %s 个字"},
+	{"unknown announcement level %q", "未知的公告级别 %s"},
+	{"Playback is mirrored from %s", "正在跟随 %s 播放"},
+	{"Username must not start with %s", "用户名不能以 %s 开头"},
+	{"this song is blocked: %s", "这首歌已被屏蔽：%s"},
+	{"this song is not allowed by the room policy: %s", "根据房间规则，这首歌不能点播：%s"},
+	{"song was played recently, it can be requested again in %d min", "这首歌刚播放过，%s 分钟后才能再点"},
+	{"song %s not found", "歌曲 %s 不存在"},
+	{"file is larger than %d MB", "文件超过 %s MB"},
+	{"URL returned %s", "链接返回 %s"},
+	{"URL does not point to an audio file (Content-Type %q)", "链接指向的不是音频文件（Content-Type %s）"},
+	{"download interrupted: %w", "下载中断：%s"},
+	{"a poll needs %d to %d candidates", "投票需要 %s 到 %s 首候选歌曲"},
+	{"poll duration must be between %v and %v", "投票时长必须在 %s 到 %s 之间"},
+	{"at must be within %d days", "只能预定 %s 天以内的时间"},
+	{"at most %d skip regions per song", "每首歌最多 %s 个跳过片段"},
+	{"skip region %d-%d is invalid", "跳过片段 %s-%s 无效"},
+	{"playback rate must be between %.1f and %.1f", "播放速度必须在 %s 到 %s 之间"},
+	{"custom equalizer needs %d band gains", "自定义均衡器需要 %s 个频段增益"},
+	{"band gains must be between -%.0f and %.0f dB", "频段增益必须在 -%s 到 %s dB 之间"},
+	{"unknown equalizer preset %q", "未知的均衡器预设 %s"},
+	{"unknown queue mode %q", "未知的排队模式 %s"},
+	{"unknown device role %q", "未知的设备角色 %s"},
+	{"at most %d devices per connection", "每个连接最多登记 %s 个设备"},
+	{"%d listeners", "%s 人在听"},
+}
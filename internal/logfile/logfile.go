@@ -0,0 +1,85 @@
+// Package logfile 可轮转的日志文件：轮转时把当前文件改名为 .1（旧的依次后移），再重新打开原路径继续写入
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// File 并发安全的日志文件，可以作为 log.SetOutput 和 gin.DefaultWriter 的输出
+type File struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	size int64
+}
+
+// Open 以追加方式打开日志文件，不存在时创建
+func Open(path string) (*File, error) {
+	lf := &File{path: path}
+	if err := lf.open(); err != nil {
+		return nil, err
+	}
+	return lf, nil
+}
+
+func (lf *File) open() error {
+	f, err := os.OpenFile(lf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	lf.f, lf.size = f, info.Size()
+	return nil
+}
+
+// Path 返回日志文件路径
+func (lf *File) Path() string {
+	return lf.path
+}
+
+// Size 返回当前文件已写入的字节数
+func (lf *File) Size() int64 {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	return lf.size
+}
+
+func (lf *File) Write(p []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	n, err := lf.f.Write(p)
+	lf.size += int64(n)
+	return n, err
+}
+
+// Rotate 把当前文件改名为 path.1，已有的 path.1 ~ path.(keep-1) 依次后移，超出 keep 份的删除
+func (lf *File) Rotate(keep int) error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if err := lf.f.Close(); err != nil {
+		return err
+	}
+	os.Remove(fmt.Sprintf("%s.%d", lf.path, keep))
+	for i := keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", lf.path, i), fmt.Sprintf("%s.%d", lf.path, i+1))
+	}
+	renameErr := os.Rename(lf.path, lf.path+".1")
+	// 改名失败时也要重新打开，否则之后的日志全部丢失
+	if err := lf.open(); err != nil {
+		return err
+	}
+	return renameErr
+}
+
+// Close 关闭日志文件
+func (lf *File) Close() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	return lf.f.Close()
+}
@@ -0,0 +1,227 @@
+// Package mdns 实现局域网内的多播 DNS（Bonjour/zeroconf）服务发现，
+// 只包含本项目需要的部分：按服务类型查找实例，例如 AirPlay 音箱（_raop._tcp），以及宣告本机的服务
+package mdns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// groupAddr mDNS 的 IPv4 多播地址和端口
+var groupAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+const (
+	// domain 局域网服务的域名
+	domain = "local."
+	// queryInterval 查找期间重发查询的间隔，多播包可能丢失
+	queryInterval = time.Second
+	// maxPacketSize mDNS 报文的最大长度
+	maxPacketSize = 9000
+)
+
+// Service 一个被发现的服务实例
+type Service struct {
+	// Instance 实例名，不含服务类型和域名，例如 "A1B2C3D4E5F6@Living Room"
+	Instance string
+	// Host 主机名，Addrs 为其 IPv4 地址
+	Host  string
+	Addrs []net.IP
+	Port  int
+	// Text TXT 记录中的键值对
+	Text map[string]string
+}
+
+// Browse 在局域网内查找指定类型（例如 "_raop._tcp"）的服务，直到 ctx 结束，返回期间收到应答的实例
+// 查询从临时端口发出，响应方按 RFC 6762 的传统单播查询直接回复到该端口，不需要占用 5353 端口
+func Browse(ctx context.Context, service string) ([]Service, error) {
+	serviceName := strings.TrimSuffix(service, ".") + "." + domain
+	query, err := buildQuery(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	go func() {
+		ticker := time.NewTicker(queryInterval)
+		defer ticker.Stop()
+		for {
+			conn.WriteToUDP(query, groupAddr)
+			select {
+			case <-ctx.Done():
+				// 让下面的读取立即返回
+				conn.SetReadDeadline(time.Now())
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	records := newRecordSet()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return nil, err
+		}
+		records.add(buf[:n])
+	}
+	return records.services(serviceName), nil
+}
+
+// buildQuery 生成查找服务实例的 PTR 查询
+func buildQuery(serviceName string) ([]byte, error) {
+	name, err := dnsmessage.NewName(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+type srvRecord struct {
+	target string
+	port   int
+}
+
+// recordSet 收集应答中的记录，名字统一为小写
+type recordSet struct {
+	ptr  map[string][]string
+	srv  map[string]srvRecord
+	txt  map[string][]string
+	addr map[string][]net.IP
+}
+
+func newRecordSet() *recordSet {
+	return &recordSet{
+		ptr:  make(map[string][]string),
+		srv:  make(map[string]srvRecord),
+		txt:  make(map[string][]string),
+		addr: make(map[string][]net.IP),
+	}
+}
+
+// add 解析一个应答报文，无法解析的报文直接忽略
+func (r *recordSet) add(msg []byte) {
+	var p dnsmessage.Parser
+	header, err := p.Start(msg)
+	if err != nil || !header.Response {
+		return
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return
+	}
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return
+	}
+	if err := p.SkipAllAuthorities(); err != nil {
+		return
+	}
+	additionals, _ := p.AllAdditionals()
+	for _, res := range append(answers, additionals...) {
+		name := strings.ToLower(res.Header.Name.String())
+		switch body := res.Body.(type) {
+		case *dnsmessage.PTRResource:
+			target := body.PTR.String()
+			if !contains(r.ptr[name], target) {
+				r.ptr[name] = append(r.ptr[name], target)
+			}
+		case *dnsmessage.SRVResource:
+			r.srv[name] = srvRecord{target: strings.ToLower(body.Target.String()), port: int(body.Port)}
+		case *dnsmessage.TXTResource:
+			r.txt[name] = body.TXT
+		case *dnsmessage.AResource:
+			ip := net.IP(body.A[:])
+			if !containsIP(r.addr[name], ip) {
+				r.addr[name] = append(r.addr[name], ip)
+			}
+		}
+	}
+}
+
+// services 返回指定服务类型下已经知道端口的实例
+func (r *recordSet) services(serviceName string) []Service {
+	suffix := "." + strings.ToLower(serviceName)
+	var services []Service
+	for _, instance := range r.ptr[strings.ToLower(serviceName)] {
+		key := strings.ToLower(instance)
+		srv, ok := r.srv[key]
+		if !ok {
+			continue
+		}
+		services = append(services, Service{
+			Instance: unescapeInstance(instance[:len(instance)-len(suffix)]),
+			Host:     srv.target,
+			Addrs:    r.addr[srv.target],
+			Port:     srv.port,
+			Text:     parseText(r.txt[key]),
+		})
+	}
+	return services
+}
+
+// parseText 把 TXT 记录中的 "key=value" 转为键值对，键不区分大小写
+func parseText(entries []string) map[string]string {
+	text := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		key, value, _ := strings.Cut(entry, "=")
+		text[strings.ToLower(key)] = value
+	}
+	return text
+}
+
+// unescapeInstance 还原实例名中转义的点、空格等字符（例如 "Living\ Room"）
+func unescapeInstance(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			if i+3 < len(s) && isDigit(s[i+1]) && isDigit(s[i+2]) && isDigit(s[i+3]) {
+				b.WriteByte((s[i+1]-'0')*100 + (s[i+2]-'0')*10 + (s[i+3] - '0'))
+				i += 3
+				continue
+			}
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(list []net.IP, ip net.IP) bool {
+	for _, v := range list {
+		if v.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
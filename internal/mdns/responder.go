@@ -0,0 +1,331 @@
+package mdns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	// hostTTL 主机地址和 SRV 记录的有效期（秒），其余记录使用 serviceTTL，取值参考 RFC 6762
+	hostTTL    = 120
+	serviceTTL = 4500
+	// legacyTTL 回复传统单播查询时的最大有效期
+	legacyTTL = 10
+	// cacheFlush 唯一记录 class 的最高位，告诉接收方替换缓存中的旧记录；在问题中同一位表示希望单播回复
+	cacheFlush = 1 << 15
+	// servicesName 列出本机全部服务类型的名字
+	servicesName = "_services._dns-sd._udp." + domain
+	// announceCount 启动时发送通告的次数，间隔 announceInterval
+	announceCount    = 2
+	announceInterval = time.Second
+)
+
+// Entry 一个要宣告的服务实例
+type Entry struct {
+	// Instance 实例名，例如 "SyncJukebox (livingroom)"，其中的点会替换为空格
+	Instance string
+	// Service 服务类型，例如 "_http._tcp"
+	Service string
+	Port    int
+	// Text TXT 记录中的 "key=value" 条目
+	Text []string
+}
+
+// Advertise 在局域网内宣告服务并回应查询，直到 ctx 结束，结束时发送有效期为 0 的告别通告
+// host 是不含 .local 的主机名，地址记录使用收到查询的网卡的 IPv4 地址
+func Advertise(ctx context.Context, host string, entries []Entry) error {
+	r, err := newResponder(host, entries)
+	if err != nil {
+		return err
+	}
+	// ListenMulticastUDP 以地址复用的方式绑定 5353 端口，可以与系统的 mDNS 服务共存
+	conn, err := net.ListenMulticastUDP("udp4", nil, groupAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	p := ipv4.NewPacketConn(conn)
+	// 默认网卡已经加入多播组，重复加入返回的错误可以忽略
+	for _, iface := range multicastInterfaces() {
+		p.JoinGroup(&iface, groupAddr)
+	}
+	if err := p.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+		return err
+	}
+	// ListenMulticastUDP 关闭了多播回环，打开后同一台机器上的客户端和系统的 mDNS 服务也能收到多播回复，自己的回复按响应报文忽略
+	p.SetMulticastLoopback(true)
+	r.conn = p
+
+	go func() {
+		r.announce(false)
+		for i := 1; i < announceCount; i++ {
+			select {
+			case <-ctx.Done():
+			case <-time.After(announceInterval):
+				r.announce(false)
+			}
+		}
+		<-ctx.Done()
+		r.announce(true)
+		// 让下面的读取立即返回
+		conn.SetReadDeadline(time.Now())
+	}()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, cm, src, err := p.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if addr, ok := src.(*net.UDPAddr); ok {
+			ifIndex := 0
+			if cm != nil {
+				ifIndex = cm.IfIndex
+			}
+			r.handle(buf[:n], addr, ifIndex)
+		}
+	}
+}
+
+// responder 保存要宣告的记录，名字在比较时统一为小写
+type responder struct {
+	conn    *ipv4.PacketConn
+	host    dnsmessage.Name
+	entries []entry
+}
+
+type entry struct {
+	service  dnsmessage.Name
+	instance dnsmessage.Name
+	port     uint16
+	text     []string
+}
+
+func newResponder(host string, entries []Entry) (*responder, error) {
+	hostName, err := dnsmessage.NewName(host + "." + domain)
+	if err != nil {
+		return nil, err
+	}
+	r := &responder{host: hostName}
+	for _, e := range entries {
+		serviceName := strings.TrimSuffix(e.Service, ".") + "." + domain
+		service, err := dnsmessage.NewName(serviceName)
+		if err != nil {
+			return nil, err
+		}
+		// 名字按点分隔标签，实例名中的点无法表示
+		instance, err := dnsmessage.NewName(strings.ReplaceAll(e.Instance, ".", " ") + "." + serviceName)
+		if err != nil {
+			return nil, err
+		}
+		text := e.Text
+		if len(text) == 0 {
+			// TXT 记录不能为空
+			text = []string{""}
+		}
+		r.entries = append(r.entries, entry{service: service, instance: instance, port: uint16(e.Port), text: text})
+	}
+	return r, nil
+}
+
+// handle 回应一个查询：来自非 5353 端口的传统查询和要求单播回复的查询直接回复发送方，其余在收到查询的网卡上多播
+func (r *responder) handle(packet []byte, src *net.UDPAddr, ifIndex int) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(packet); err != nil || msg.Header.Response {
+		return
+	}
+	ip := interfaceAddr(ifIndex, src)
+	legacy := src.Port != groupAddr.Port
+	unicast := legacy
+	var answers, additionals []dnsmessage.Resource
+	for _, q := range msg.Questions {
+		if q.Class&cacheFlush != 0 {
+			unicast = true
+		}
+		a, extra := r.answer(q, ip)
+		answers = append(answers, a...)
+		additionals = append(additionals, extra...)
+	}
+	if len(answers) == 0 {
+		return
+	}
+
+	resp := dnsmessage.Message{
+		Header:      dnsmessage.Header{Response: true, Authoritative: true},
+		Answers:     answers,
+		Additionals: additionals,
+	}
+	if legacy {
+		// 传统查询方不理解 mDNS 的扩展，按普通 DNS 回复
+		resp.Header.ID = msg.Header.ID
+		resp.Questions = msg.Questions
+		for _, list := range [][]dnsmessage.Resource{resp.Answers, resp.Additionals} {
+			for i := range list {
+				list[i].Header.Class &^= cacheFlush
+				list[i].Header.TTL = min(list[i].Header.TTL, legacyTTL)
+			}
+		}
+	}
+	out, err := resp.Pack()
+	if err != nil {
+		return
+	}
+	if unicast {
+		r.conn.WriteTo(out, nil, src)
+		return
+	}
+	r.conn.WriteTo(out, &ipv4.ControlMessage{IfIndex: ifIndex}, groupAddr)
+}
+
+// answer 返回一个问题的答案和附加记录，与本机无关的问题两者都为空
+func (r *responder) answer(q dnsmessage.Question, ip net.IP) (answers, additionals []dnsmessage.Resource) {
+	name := strings.ToLower(q.Name.String())
+	matches := func(t dnsmessage.Type) bool { return q.Type == t || q.Type == dnsmessage.TypeALL }
+	for _, e := range r.entries {
+		switch name {
+		case servicesName:
+			if matches(dnsmessage.TypePTR) {
+				answers = append(answers, r.servicesRecord(e, serviceTTL))
+			}
+		case strings.ToLower(e.service.String()):
+			if matches(dnsmessage.TypePTR) {
+				answers = append(answers, r.ptrRecord(e, serviceTTL))
+				additionals = append(additionals, r.srvRecord(e, hostTTL), r.txtRecord(e, serviceTTL))
+				additionals = append(additionals, r.addrRecords(ip, hostTTL)...)
+			}
+		case strings.ToLower(e.instance.String()):
+			if matches(dnsmessage.TypeSRV) {
+				answers = append(answers, r.srvRecord(e, hostTTL))
+				additionals = append(additionals, r.addrRecords(ip, hostTTL)...)
+			}
+			if matches(dnsmessage.TypeTXT) {
+				answers = append(answers, r.txtRecord(e, serviceTTL))
+			}
+		}
+	}
+	if name == strings.ToLower(r.host.String()) && matches(dnsmessage.TypeA) {
+		answers = append(answers, r.addrRecords(ip, hostTTL)...)
+	}
+	return answers, additionals
+}
+
+// announce 在每个网卡上多播全部记录，goodbye 为 true 时有效期为 0，通知其他设备服务已下线
+func (r *responder) announce(goodbye bool) {
+	ttl := func(t uint32) uint32 {
+		if goodbye {
+			return 0
+		}
+		return t
+	}
+	for _, iface := range multicastInterfaces() {
+		ip := interfaceIPv4(&iface)
+		if ip == nil {
+			continue
+		}
+		msg := dnsmessage.Message{Header: dnsmessage.Header{Response: true, Authoritative: true}}
+		for _, e := range r.entries {
+			msg.Answers = append(msg.Answers,
+				r.servicesRecord(e, ttl(serviceTTL)),
+				r.ptrRecord(e, ttl(serviceTTL)),
+				r.srvRecord(e, ttl(hostTTL)),
+				r.txtRecord(e, ttl(serviceTTL)))
+		}
+		msg.Answers = append(msg.Answers, r.addrRecords(ip, ttl(hostTTL))...)
+		out, err := msg.Pack()
+		if err != nil {
+			return
+		}
+		r.conn.WriteTo(out, &ipv4.ControlMessage{IfIndex: iface.Index}, groupAddr)
+	}
+}
+
+func (r *responder) servicesRecord(e entry, ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(servicesName), Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   &dnsmessage.PTRResource{PTR: e.service},
+	}
+}
+
+func (r *responder) ptrRecord(e entry, ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: e.service, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   &dnsmessage.PTRResource{PTR: e.instance},
+	}
+}
+
+func (r *responder) srvRecord(e entry, ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: e.instance, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET | cacheFlush, TTL: ttl},
+		Body:   &dnsmessage.SRVResource{Target: r.host, Port: e.port},
+	}
+}
+
+func (r *responder) txtRecord(e entry, ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: e.instance, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET | cacheFlush, TTL: ttl},
+		Body:   &dnsmessage.TXTResource{TXT: e.text},
+	}
+}
+
+// addrRecords 主机的地址记录，不知道地址时为空
+func (r *responder) addrRecords(ip net.IP, ttl uint32) []dnsmessage.Resource {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil
+	}
+	return []dnsmessage.Resource{{
+		Header: dnsmessage.ResourceHeader{Name: r.host, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET | cacheFlush, TTL: ttl},
+		Body:   &dnsmessage.AResource{A: [4]byte(ip4)},
+	}}
+}
+
+// interfaceAddr 收到查询的网卡的 IPv4 地址，不知道网卡时取访问查询方使用的本机地址
+func interfaceAddr(ifIndex int, src *net.UDPAddr) net.IP {
+	if iface, err := net.InterfaceByIndex(ifIndex); err == nil {
+		if ip := interfaceIPv4(iface); ip != nil {
+			return ip
+		}
+	}
+	conn, err := net.DialUDP("udp4", nil, src)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP
+}
+
+// multicastInterfaces 返回已启用、支持多播的非回环网卡
+func multicastInterfaces() []net.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var result []net.Interface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 && iface.Flags&net.FlagLoopback == 0 {
+			result = append(result, iface)
+		}
+	}
+	return result
+}
+
+func interfaceIPv4(iface *net.Interface) net.IP {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return ipNet.IP.To4()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+// Package mpris 在 Linux 桌面的会话总线上注册 MPRIS 播放器：桌面的播放控件显示点歌台正在播放的歌曲，
+// 媒体键和控件上的按钮通过 Controls 转发给点歌台，控制的是所有人共享的播放队列
+package mpris
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+const (
+	// BusNamePrefix 播放器在会话总线上的名字前缀，后面接 New 的 name 参数
+	BusNamePrefix = "org.mpris.MediaPlayer2."
+
+	objectPath  = dbus.ObjectPath("/org/mpris/MediaPlayer2")
+	rootIface   = "org.mpris.MediaPlayer2"
+	playerIface = "org.mpris.MediaPlayer2.Player"
+	propsIface  = "org.freedesktop.DBus.Properties"
+	// noTrack 没有歌曲时的 mpris:trackid
+	noTrack = dbus.ObjectPath("/org/mpris/MediaPlayer2/TrackList/NoTrack")
+	// trackPathPrefix 歌曲的 mpris:trackid 前缀，对象路径只允许字母数字和下划线，歌曲 ID 以十六进制编码
+	trackPathPrefix = "/org/syncjukebox/track/"
+	// seekTolerance 新状态的进度与按时钟推算的进度相差超过该值时认为发生了跳转，发出 Seeked 信号
+	seekTolerance = 1500 * time.Millisecond
+	// 服务端允许的播放速度范围
+	minimumRate = 0.5
+	maximumRate = 2.0
+)
+
+// Controls 桌面发来的控制命令，由调用方转发给点歌台
+type Controls interface {
+	Play() error
+	Pause() error
+	Next() error
+	Previous() error
+	// SeekTo 跳到当前歌曲的 position 处
+	SeekTo(position time.Duration) error
+}
+
+// Status 点歌台的播放状态，SongID 为空表示没有正在播放的歌曲
+type Status struct {
+	Playing  bool
+	SongID   string
+	Title    string
+	Artist   string
+	Album    string
+	ArtURL   string
+	Length   time.Duration
+	Position time.Duration
+	Rate     float64
+}
+
+// Player 注册在会话总线上的 MPRIS 播放器
+type Player struct {
+	conn     *dbus.Conn
+	identity string
+	controls Controls
+
+	mu     sync.Mutex
+	status Status
+	// updatedAt 收到 status 的时间，播放中的进度按时钟从这里推算
+	updatedAt time.Time
+}
+
+// New 连接会话总线并以 BusNamePrefix+name 注册播放器，identity 是桌面控件上显示的播放器名称
+func New(name, identity string, controls Controls) (*Player, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, err
+	}
+	p := &Player{conn: conn, identity: identity, controls: controls, status: Status{Rate: 1}, updatedAt: time.Now()}
+	exports := []struct {
+		v       any
+		mapping map[string]string
+		iface   string
+	}{
+		{rootMethods{p}, nil, rootIface},
+		// Seek 与 io.Seeker 同名但签名不同，Go 中换个名字导出
+		{playerMethods{p}, map[string]string{"SeekBy": "Seek"}, playerIface},
+		{propertyMethods{p}, nil, propsIface},
+		{introspect.Introspectable(introspection), nil, "org.freedesktop.DBus.Introspectable"},
+	}
+	for _, e := range exports {
+		if err := conn.ExportWithMap(e.v, e.mapping, objectPath, e.iface); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	reply, err := conn.RequestName(BusNamePrefix+name, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		conn.Close()
+		return nil, fmt.Errorf("%s%s is already registered on the session bus", BusNamePrefix, name)
+	}
+	return p, nil
+}
+
+// Close 从会话总线注销播放器
+func (p *Player) Close() error {
+	return p.conn.Close()
+}
+
+// Update 更新播放状态，并通知桌面发生变化的属性；同一首歌的进度跳变时发出 Seeked 信号
+func (p *Player) Update(s Status) {
+	if s.Rate <= 0 {
+		s.Rate = 1
+	}
+	p.mu.Lock()
+	before := p.playerProperties()
+	expected := p.positionLocked()
+	sameSong := p.status.SongID == s.SongID
+	p.status = s
+	p.updatedAt = time.Now()
+	after := p.playerProperties()
+	p.mu.Unlock()
+
+	changed := make(map[string]dbus.Variant)
+	for name, v := range after {
+		if old, ok := before[name]; !ok || old.String() != v.String() {
+			changed[name] = v
+		}
+	}
+	if len(changed) > 0 {
+		p.conn.Emit(objectPath, propsIface+".PropertiesChanged", playerIface, changed, []string{})
+	}
+	if sameSong && s.SongID != "" && (s.Position-expected).Abs() > seekTolerance {
+		p.conn.Emit(objectPath, playerIface+".Seeked", s.Position.Microseconds())
+	}
+}
+
+// positionLocked 按最近一次状态和经过的时间推算当前进度
+func (p *Player) positionLocked() time.Duration {
+	pos := p.status.Position
+	if p.status.Playing {
+		pos += time.Duration(float64(time.Since(p.updatedAt)) * p.status.Rate)
+	}
+	if p.status.Length > 0 {
+		pos = min(pos, p.status.Length)
+	}
+	return pos
+}
+
+// trackIDLocked 当前歌曲的 mpris:trackid
+func (p *Player) trackIDLocked() dbus.ObjectPath {
+	if p.status.SongID == "" {
+		return noTrack
+	}
+	return dbus.ObjectPath(trackPathPrefix + hex.EncodeToString([]byte(p.status.SongID)))
+}
+
+// playerProperties 播放器接口中会发出变化通知的属性；Position 不在其中，桌面按需读取
+func (p *Player) playerProperties() map[string]dbus.Variant {
+	s := p.status
+	hasSong := s.SongID != ""
+	status := "Stopped"
+	if hasSong && s.Playing {
+		status = "Playing"
+	} else if hasSong {
+		status = "Paused"
+	}
+	metadata := map[string]dbus.Variant{"mpris:trackid": dbus.MakeVariant(p.trackIDLocked())}
+	if hasSong {
+		metadata["xesam:title"] = dbus.MakeVariant(s.Title)
+		if s.Artist != "" {
+			metadata["xesam:artist"] = dbus.MakeVariant([]string{s.Artist})
+		}
+		if s.Album != "" {
+			metadata["xesam:album"] = dbus.MakeVariant(s.Album)
+		}
+		if s.Length > 0 {
+			metadata["mpris:length"] = dbus.MakeVariant(s.Length.Microseconds())
+		}
+		if s.ArtURL != "" {
+			metadata["mpris:artUrl"] = dbus.MakeVariant(s.ArtURL)
+		}
+	}
+	return map[string]dbus.Variant{
+		"PlaybackStatus": dbus.MakeVariant(status),
+		"Rate":           dbus.MakeVariant(s.Rate),
+		"Metadata":       dbus.MakeVariant(metadata),
+		"Volume":         dbus.MakeVariant(1.0),
+		"MinimumRate":    dbus.MakeVariant(minimumRate),
+		"MaximumRate":    dbus.MakeVariant(maximumRate),
+		"CanGoNext":      dbus.MakeVariant(hasSong),
+		"CanGoPrevious":  dbus.MakeVariant(hasSong),
+		"CanPlay":        dbus.MakeVariant(hasSong),
+		"CanPause":       dbus.MakeVariant(hasSong),
+		"CanSeek":        dbus.MakeVariant(hasSong && s.Length > 0),
+		"CanControl":     dbus.MakeVariant(true),
+	}
+}
+
+func (p *Player) rootProperties() map[string]dbus.Variant {
+	return map[string]dbus.Variant{
+		"CanQuit":             dbus.MakeVariant(false),
+		"CanRaise":            dbus.MakeVariant(false),
+		"HasTrackList":        dbus.MakeVariant(false),
+		"Identity":            dbus.MakeVariant(p.identity),
+		"SupportedUriSchemes": dbus.MakeVariant([]string{}),
+		"SupportedMimeTypes":  dbus.MakeVariant([]string{}),
+	}
+}
+
+// command 执行控制命令，失败时以 D-Bus 错误返回给桌面
+func command(err error) *dbus.Error {
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+// rootMethods org.mpris.MediaPlayer2 接口，点歌台没有可以唤起或退出的窗口
+type rootMethods struct{ p *Player }
+
+func (rootMethods) Raise() *dbus.Error { return nil }
+func (rootMethods) Quit() *dbus.Error  { return nil }
+
+// playerMethods org.mpris.MediaPlayer2.Player 接口
+type playerMethods struct{ p *Player }
+
+func (m playerMethods) Play() *dbus.Error     { return command(m.p.controls.Play()) }
+func (m playerMethods) Pause() *dbus.Error    { return command(m.p.controls.Pause()) }
+func (m playerMethods) Next() *dbus.Error     { return command(m.p.controls.Next()) }
+func (m playerMethods) Previous() *dbus.Error { return command(m.p.controls.Previous()) }
+
+// Stop 点歌台没有停止状态，按暂停处理
+func (m playerMethods) Stop() *dbus.Error { return command(m.p.controls.Pause()) }
+
+func (m playerMethods) PlayPause() *dbus.Error {
+	m.p.mu.Lock()
+	playing := m.p.status.Playing
+	m.p.mu.Unlock()
+	if playing {
+		return command(m.p.controls.Pause())
+	}
+	return command(m.p.controls.Play())
+}
+
+// SeekBy 即 Seek 方法，相对当前进度跳转 offset 微秒，跳过结尾时切到下一首
+func (m playerMethods) SeekBy(offset int64) *dbus.Error {
+	m.p.mu.Lock()
+	hasSong := m.p.status.SongID != ""
+	length := m.p.status.Length
+	target := m.p.positionLocked() + time.Duration(offset)*time.Microsecond
+	m.p.mu.Unlock()
+	if !hasSong {
+		return nil
+	}
+	if length > 0 && target > length {
+		return command(m.p.controls.Next())
+	}
+	return command(m.p.controls.SeekTo(max(target, 0)))
+}
+
+// SetPosition 跳到 trackID 对应歌曲的 position 微秒处，歌曲已经切换或位置超出范围时忽略
+func (m playerMethods) SetPosition(trackID dbus.ObjectPath, position int64) *dbus.Error {
+	m.p.mu.Lock()
+	current := m.p.trackIDLocked()
+	length := m.p.status.Length
+	m.p.mu.Unlock()
+	target := time.Duration(position) * time.Microsecond
+	if trackID != current || current == noTrack || target < 0 || (length > 0 && target > length) {
+		return nil
+	}
+	return command(m.p.controls.SeekTo(target))
+}
+
+// OpenUri 不支持从桌面打开文件
+func (playerMethods) OpenUri(string) *dbus.Error {
+	return dbus.MakeFailedError(errOpenURI)
+}
+
+var errOpenURI = errors.New("opening URIs is not supported, queue songs in the jukebox")
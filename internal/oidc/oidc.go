@@ -0,0 +1,302 @@
+// Package oidc 实现 OpenID Connect 授权码流程（带 PKCE）的客户端部分：
+// 生成登录地址、用授权码换取令牌并校验 ID Token，得到登录者的身份和声明
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yeeeck/sync-jukebox/internal/config"
+)
+
+const (
+	requestTimeout = 15 * time.Second
+	// clockSkew 校验过期时间时允许的时钟偏差
+	clockSkew = time.Minute
+	// maxResponseBytes 端点响应的大小上限
+	maxResponseBytes = 1 << 20
+)
+
+var defaultScopes = []string{"openid", "profile", "email"}
+
+// Identity 登录者的身份，Claims 是 ID Token 与 userinfo 合并后的全部声明
+type Identity struct {
+	Subject string
+	Claims  map[string]interface{}
+}
+
+// String 返回字符串类型的声明，不存在或不是字符串时为空
+func (id *Identity) String(claim string) string {
+	s, _ := id.Claims[claim].(string)
+	return s
+}
+
+// Strings 返回字符串或字符串数组类型的声明
+func (id *Identity) Strings(claim string) []string {
+	switch v := id.Claims[claim].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Provider 一个身份提供方，端点在第一次使用时从发现文档读取并缓存
+type Provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	client       *http.Client
+
+	mu        sync.Mutex
+	endpoints *endpoints
+}
+
+// endpoints 发现文档中用到的字段
+type endpoints struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// New 按配置创建，没有配置 Issuer 时返回 nil，配置不完整时返回错误
+func New(cfg config.OIDCConfig) (*Provider, error) {
+	if cfg.Issuer == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(cfg.Issuer)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid oidc issuer %q", cfg.Issuer)
+	}
+	if cfg.ClientID == "" {
+		return nil, errors.New("oidc clientId is required")
+	}
+	p := &Provider{
+		issuer:       strings.TrimSuffix(cfg.Issuer, "/"),
+		clientID:     cfg.ClientID,
+		clientSecret: cfg.ClientSecret,
+		scopes:       cfg.Scopes,
+		client:       &http.Client{Timeout: requestTimeout},
+	}
+	if len(p.scopes) == 0 {
+		p.scopes = defaultScopes
+	}
+	return p, nil
+}
+
+// discover 读取发现文档，成功后缓存，失败时下次重试
+func (p *Provider) discover(ctx context.Context) (*endpoints, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.endpoints != nil {
+		return p.endpoints, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var e endpoints
+	if err := p.doJSON(req, &e); err != nil {
+		return nil, fmt.Errorf("failed to read oidc discovery document: %w", err)
+	}
+	if e.AuthorizationEndpoint == "" || e.TokenEndpoint == "" {
+		return nil, errors.New("oidc discovery document has no authorization or token endpoint")
+	}
+	// 发现文档中的 issuer 必须与配置一致，忽略末尾的斜杠
+	if strings.TrimSuffix(e.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("oidc issuer mismatch: configured %q, provider reports %q", p.issuer, e.Issuer)
+	}
+	p.endpoints = &e
+	return p.endpoints, nil
+}
+
+// NewChallenge 生成一次登录使用的 state、nonce 和 PKCE verifier
+func NewChallenge() (state, nonce, verifier string) {
+	return randomString(), randomString(), randomString()
+}
+
+// AuthURL 返回跳转到身份提供方登录的地址
+func (p *Provider) AuthURL(ctx context.Context, state, nonce, verifier, redirectURI string) (string, error) {
+	e, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.clientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(p.scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(e.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return e.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange 用回调收到的授权码换取令牌，校验 ID Token 后合并 userinfo 中的声明
+// ID Token 直接从令牌端点经 TLS 取得，按规范可以不验证签名，只校验 iss、aud、exp 和 nonce
+func (p *Provider) Exchange(ctx context.Context, code, verifier, redirectURI, nonce string) (*Identity, error) {
+	e, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.clientID},
+		"code_verifier": {verifier},
+	}
+	if p.clientSecret != "" {
+		form.Set("client_secret", p.clientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var tokens struct {
+		IDToken     string `json:"id_token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := p.doJSON(req, &tokens); err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+	claims, err := decodeJWT(tokens.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.validate(claims, e.Issuer, nonce); err != nil {
+		return nil, err
+	}
+	id := &Identity{Claims: claims}
+	id.Subject = id.String("sub")
+	if id.Subject == "" {
+		return nil, errors.New("id_token has no subject")
+	}
+
+	// 组等声明常常只在 userinfo 中返回，ID Token 中已有的声明优先
+	if e.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		info, err := p.userinfo(ctx, e.UserinfoEndpoint, tokens.AccessToken)
+		if err != nil {
+			return nil, err
+		}
+		if sub, _ := info["sub"].(string); sub != id.Subject {
+			return nil, errors.New("userinfo subject does not match id_token")
+		}
+		for k, v := range info {
+			if _, ok := id.Claims[k]; !ok {
+				id.Claims[k] = v
+			}
+		}
+	}
+	return id, nil
+}
+
+func (p *Provider) validate(claims map[string]interface{}, issuer, nonce string) error {
+	if iss, _ := claims["iss"].(string); iss != issuer {
+		return fmt.Errorf("id_token issuer %q does not match %q", iss, issuer)
+	}
+	if !slices.Contains((&Identity{Claims: claims}).Strings("aud"), p.clientID) {
+		return errors.New("id_token was not issued for this client")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || time.Unix(int64(exp), 0).Add(clockSkew).Before(time.Now()) {
+		return errors.New("id_token has expired")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return errors.New("id_token nonce does not match")
+	}
+	return nil
+}
+
+func (p *Provider) userinfo(ctx context.Context, endpoint, accessToken string) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	var info map[string]interface{}
+	if err := p.doJSON(req, &info); err != nil {
+		return nil, fmt.Errorf("userinfo request failed: %w", err)
+	}
+	return info, nil
+}
+
+// doJSON 发送请求并解析 JSON 响应，非 2xx 时带上响应中的错误说明
+func (p *Provider) doJSON(req *http.Request, out interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var oauthErr struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		if json.Unmarshal(body, &oauthErr) == nil && oauthErr.Error != "" {
+			return fmt.Errorf("%s: %s %s", resp.Status, oauthErr.Error, oauthErr.Description)
+		}
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// decodeJWT 取出 JWT 的载荷部分
+func decodeJWT(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id_token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("malformed id_token payload: %w", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed id_token payload: %w", err)
+	}
+	return claims, nil
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
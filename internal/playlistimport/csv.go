@@ -0,0 +1,63 @@
+package playlistimport
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+)
+
+// 常见导出工具（Exportify、TuneMyMusic、Soundiiz 等）使用的列名，比较时忽略大小写
+var (
+	titleColumns  = []string{"track name", "track", "title", "song", "song name", "name"}
+	artistColumns = []string{"artist name(s)", "artist name", "artist names", "artists", "artist"}
+)
+
+// ParseCSV 读取导出的歌单 CSV，第一行必须是表头，至少包含标题列
+func ParseCSV(r io.Reader) ([]Track, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("CSV file is empty or malformed")
+	}
+	titleIdx := findColumn(header, titleColumns)
+	if titleIdx == -1 {
+		return nil, errors.New("CSV has no track title column")
+	}
+	artistIdx := findColumn(header, artistColumns)
+
+	var tracks []Track
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if titleIdx >= len(record) || strings.TrimSpace(record[titleIdx]) == "" {
+			continue
+		}
+		track := Track{Title: strings.TrimSpace(record[titleIdx])}
+		if artistIdx != -1 && artistIdx < len(record) {
+			track.Artists = splitArtists(record[artistIdx])
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, nil
+}
+
+// findColumn 按优先级查找列，返回列下标，找不到时返回 -1
+func findColumn(header []string, names []string) int {
+	for _, name := range names {
+		for i, col := range header {
+			// 去掉 Excel 导出时可能带的 BOM
+			if strings.EqualFold(strings.TrimSpace(strings.TrimPrefix(col, "\ufeff")), name) {
+				return i
+			}
+		}
+	}
+	return -1
+}
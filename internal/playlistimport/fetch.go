@@ -0,0 +1,215 @@
+package playlistimport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/yeeeck/sync-jukebox/internal/config"
+)
+
+// 抓取外部歌单的超时时间和页面大小上限
+const (
+	fetchTimeout = 30 * time.Second
+	maxPageBytes = 5 << 20
+)
+
+var httpClient = &http.Client{Timeout: fetchTimeout}
+
+// Fetch 读取 Spotify 或 Apple Music 歌单链接，返回歌单名和歌曲
+func Fetch(ctx context.Context, rawURL string, cfg config.ImportConfig) (string, []Track, error) {
+	if id, ok := spotifyPlaylistID(rawURL); ok {
+		if cfg.SpotifyClientID == "" || cfg.SpotifyClientSecret == "" {
+			return "", nil, errors.New("Spotify import is not configured, export the playlist as CSV instead")
+		}
+		return fetchSpotify(ctx, id, cfg)
+	}
+	u, err := url.Parse(rawURL)
+	if err == nil && u.Scheme == "https" && u.Host == "music.apple.com" && strings.Contains(u.Path, "/playlist/") {
+		return fetchAppleMusic(ctx, u.String())
+	}
+	return "", nil, errors.New("unsupported playlist URL, expected an open.spotify.com or music.apple.com playlist")
+}
+
+// spotifyPlaylistID 解析 https://open.spotify.com/playlist/<id> 或 spotify:playlist:<id>
+func spotifyPlaylistID(rawURL string) (string, bool) {
+	if id, ok := strings.CutPrefix(rawURL, "spotify:playlist:"); ok && id != "" {
+		return id, true
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host != "open.spotify.com" {
+		return "", false
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	// 可能带地区前缀，例如 /intl-de/playlist/<id>
+	for i := 0; i+1 < len(parts); i++ {
+		if parts[i] == "playlist" && parts[i+1] != "" {
+			return parts[i+1], true
+		}
+	}
+	return "", false
+}
+
+// fetchSpotify 使用 Client Credentials 授权读取公开歌单
+func fetchSpotify(ctx context.Context, id string, cfg config.ImportConfig) (string, []Track, error) {
+	token, err := spotifyToken(ctx, cfg)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to authenticate with Spotify: %w", err)
+	}
+	type page struct {
+		Items []struct {
+			Track *struct {
+				Name    string `json:"name"`
+				Artists []struct {
+					Name string `json:"name"`
+				} `json:"artists"`
+			} `json:"track"`
+		} `json:"items"`
+		Next string `json:"next"`
+	}
+	var playlist struct {
+		Name   string `json:"name"`
+		Tracks page   `json:"tracks"`
+	}
+	playlistURL := "https://api.spotify.com/v1/playlists/" + url.PathEscape(id) + "?fields=" + url.QueryEscape("name,tracks(items(track(name,artists(name))),next)")
+	if err := getJSON(ctx, playlistURL, token, &playlist); err != nil {
+		return "", nil, err
+	}
+	var tracks []Track
+	current := playlist.Tracks
+	for {
+		for _, item := range current.Items {
+			// 已下架的歌曲 track 为 null
+			if item.Track == nil || item.Track.Name == "" {
+				continue
+			}
+			track := Track{Title: item.Track.Name}
+			for _, artist := range item.Track.Artists {
+				track.Artists = append(track.Artists, artist.Name)
+			}
+			tracks = append(tracks, track)
+		}
+		if current.Next == "" {
+			break
+		}
+		next := current.Next
+		current = page{}
+		if err := getJSON(ctx, next, token, &current); err != nil {
+			return "", nil, err
+		}
+	}
+	return playlist.Name, tracks, nil
+}
+
+func spotifyToken(ctx context.Context, cfg config.ImportConfig) (string, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://accounts.spotify.com/api/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(cfg.SpotifyClientID, cfg.SpotifyClientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(resp.Status)
+	}
+	var body struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	return body.AccessToken, nil
+}
+
+func getJSON(ctx context.Context, rawURL, token string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Spotify API: %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Apple Music 的公开歌单页面内嵌 schema.org 的 MusicPlaylist 数据
+var ldJSONPattern = regexp.MustCompile(`(?s)<script[^>]*type="application/ld\+json"[^>]*>(.*?)</script>`)
+
+// fetchAppleMusic 解析公开歌单页面，不需要开发者凭证
+func fetchAppleMusic(ctx context.Context, pageURL string) (string, []Track, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("Apple Music: %s", resp.Status)
+	}
+	page, err := io.ReadAll(io.LimitReader(resp.Body, maxPageBytes))
+	if err != nil {
+		return "", nil, err
+	}
+	for _, m := range ldJSONPattern.FindAllSubmatch(page, -1) {
+		var playlist struct {
+			Type  string `json:"@type"`
+			Name  string `json:"name"`
+			Track []struct {
+				Name     string          `json:"name"`
+				ByArtist json.RawMessage `json:"byArtist"`
+			} `json:"track"`
+		}
+		if json.Unmarshal(m[1], &playlist) != nil || playlist.Type != "MusicPlaylist" {
+			continue
+		}
+		tracks := make([]Track, 0, len(playlist.Track))
+		for _, t := range playlist.Track {
+			tracks = append(tracks, Track{Title: t.Name, Artists: ldArtists(t.ByArtist)})
+		}
+		return playlist.Name, tracks, nil
+	}
+	return "", nil, errors.New("no playlist data found on the Apple Music page, export the playlist as CSV instead")
+}
+
+// ldArtists byArtist 可能是单个对象，也可能是数组
+func ldArtists(raw json.RawMessage) []string {
+	type artist struct {
+		Name string `json:"name"`
+	}
+	var many []artist
+	if json.Unmarshal(raw, &many) != nil {
+		var one artist
+		if json.Unmarshal(raw, &one) != nil {
+			return nil
+		}
+		many = []artist{one}
+	}
+	var names []string
+	for _, a := range many {
+		if a.Name != "" {
+			names = append(names, a.Name)
+		}
+	}
+	return names
+}
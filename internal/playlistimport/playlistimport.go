@@ -0,0 +1,103 @@
+// Package playlistimport 从 Spotify、Apple Music 或导出的 CSV 读取歌单，
+// 按规范化后的标题和歌手与本地曲库匹配
+package playlistimport
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// Track 外部歌单中的一首歌
+type Track struct {
+	Title   string   `json:"title"`
+	Artists []string `json:"artists"`
+}
+
+// Result 匹配结果，Matched 按歌单顺序排列，同一首本地歌曲只出现一次
+type Result struct {
+	Matched   []db.Song `json:"matched"`
+	Unmatched []Track   `json:"unmatched"`
+}
+
+var (
+	// 括号里的附加信息，例如 (feat. X)、[Remastered]
+	bracketPattern = regexp.MustCompile(`\([^)]*\)|\[[^\]]*\]`)
+	// " - Remastered 2011"、" - Live"、" - Radio Edit" 这类后缀
+	suffixPattern = regexp.MustCompile(`\s+-\s+.*(remaster|live|version|edit|mix|mono|stereo|acoustic).*$`)
+	// 标题中的 feat. 部分
+	featPattern = regexp.MustCompile(`\s(feat\.?|ft\.?|featuring)\s.*$`)
+)
+
+// normalize 把标题或歌手规范化：小写、去掉附加信息和标点、合并空白
+func normalize(s string) string {
+	s = strings.ToLower(s)
+	s = suffixPattern.ReplaceAllString(s, "")
+	s = bracketPattern.ReplaceAllString(s, "")
+	s = featPattern.ReplaceAllString(s, "")
+	s = strings.ReplaceAll(s, "&", " and ")
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			return r
+		}
+		return ' '
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// Match 按规范化后的标题和歌手在 library 中查找歌单里的歌曲
+// 标题相同且任一歌手与本地歌手相同（或互相包含，应对 "A & B" 这类合写）即视为匹配
+func Match(tracks []Track, library []db.Song) *Result {
+	byTitle := make(map[string][]db.Song)
+	for _, song := range library {
+		key := normalize(song.Title)
+		byTitle[key] = append(byTitle[key], song)
+	}
+	result := &Result{Matched: []db.Song{}, Unmatched: []Track{}}
+	seen := make(map[string]bool)
+	for _, track := range tracks {
+		song, ok := matchTrack(track, byTitle[normalize(track.Title)])
+		if !ok {
+			result.Unmatched = append(result.Unmatched, track)
+			continue
+		}
+		if !seen[song.ID] {
+			seen[song.ID] = true
+			result.Matched = append(result.Matched, song)
+		}
+	}
+	return result
+}
+
+func matchTrack(track Track, candidates []db.Song) (db.Song, bool) {
+	for _, song := range candidates {
+		local := normalize(song.Artist)
+		for _, artist := range track.Artists {
+			artist = normalize(artist)
+			if artist == "" || local == "" {
+				continue
+			}
+			if artist == local || strings.Contains(local, artist) || strings.Contains(artist, local) {
+				return song, true
+			}
+		}
+	}
+	// 没有歌手信息时，标题唯一匹配也接受
+	if len(candidates) == 1 && len(track.Artists) == 0 {
+		return candidates[0], true
+	}
+	return db.Song{}, false
+}
+
+// splitArtists 拆分 "A, B"、"A; B" 形式的多歌手字段
+func splitArtists(s string) []string {
+	var artists []string
+	for _, a := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ';' }) {
+		if a = strings.TrimSpace(a); a != "" {
+			artists = append(artists, a)
+		}
+	}
+	return artists
+}
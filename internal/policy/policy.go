@@ -0,0 +1,202 @@
+// Package policy 运行运维人员提供的 Lua 脚本来决定点歌准入和下一首的选择，
+// 例如“同一歌手不能连续超过两首”。
+//
+// 脚本可以定义以下全局函数，未定义的使用默认行为：
+//
+//	admit(song, ctx)          返回 false[, 原因] 拒绝点歌，其他返回值表示允许
+//	next_song(candidates, ctx) 返回 candidates 中的序号（从 1 开始），返回 nil 使用默认顺序
+//
+// song 和 candidates 中的元素为 {id, title, artist, album, duration_ms, explicit, added_by}，
+// ctx 为 {user, is_admin, queue, history}，queue 为当前歌曲之后的待播歌曲，
+// history 为最近播放的歌曲（最近的在前）。
+//
+// 每次调用都在全新的沙箱中执行：只开放 base、table、string、math 库，
+// 不能读写文件或加载其他代码，并且有执行时间上限。
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// DefaultTimeout 单次调用脚本的默认时间上限
+const DefaultTimeout = 50 * time.Millisecond
+
+// 沙箱中移除的 base 库函数，它们可以加载任意代码或访问文件
+var unsafeGlobals = []string{"dofile", "loadfile", "load", "loadstring", "require", "module", "collectgarbage"}
+
+// Entry 是传给脚本的一首歌及其点歌人
+type Entry struct {
+	Song    *db.Song
+	AddedBy string
+}
+
+// Context 是脚本决策时可见的房间状态
+type Context struct {
+	User    string
+	IsAdmin bool
+	Queue   []Entry
+	History []Entry
+}
+
+// Script 是编译好的策略脚本，nil Script 的所有方法都返回默认结果
+type Script struct {
+	name    string
+	proto   *lua.FunctionProto
+	timeout time.Duration
+}
+
+// Load 读取并编译脚本，path 为空时返回 nil（不启用脚本）
+func Load(path string, timeout time.Duration) (*Script, error) {
+	if path == "" {
+		return nil, nil
+	}
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy script: %w", err)
+	}
+	chunk, err := parse.Parse(strings.NewReader(string(source)), path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy script: %w", err)
+	}
+	proto, err := lua.Compile(chunk, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile policy script: %w", err)
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Script{name: path, proto: proto, timeout: timeout}, nil
+}
+
+// Admit 判断是否允许点这首歌，拒绝时返回原因
+func (s *Script) Admit(song *db.Song, ctx Context) (bool, string, error) {
+	if s == nil {
+		return true, "", nil
+	}
+	var allowed = true
+	var reason string
+	err := s.call("admit", func(L *lua.LState) []lua.LValue {
+		return []lua.LValue{entryTable(L, Entry{Song: song, AddedBy: ctx.User}), contextTable(L, ctx)}
+	}, 2, func(L *lua.LState) error {
+		if L.Get(-2) == lua.LFalse {
+			allowed = false
+			reason = lua.LVAsString(L.Get(-1))
+		}
+		return nil
+	})
+	return allowed, reason, err
+}
+
+// PickNext 从候选歌曲中选出下一首，返回其下标，-1 表示使用默认顺序
+func (s *Script) PickNext(candidates []Entry, ctx Context) (int, error) {
+	if s == nil || len(candidates) == 0 {
+		return -1, nil
+	}
+	picked := -1
+	err := s.call("next_song", func(L *lua.LState) []lua.LValue {
+		return []lua.LValue{entriesTable(L, candidates), contextTable(L, ctx)}
+	}, 1, func(L *lua.LState) error {
+		ret := L.Get(-1)
+		if ret == lua.LNil {
+			return nil
+		}
+		n, ok := ret.(lua.LNumber)
+		if !ok || int(n) < 1 || int(n) > len(candidates) {
+			return fmt.Errorf("next_song returned %s, want an index between 1 and %d", ret, len(candidates))
+		}
+		picked = int(n) - 1
+		return nil
+	})
+	return picked, err
+}
+
+// call 在新的沙箱中执行脚本并调用指定函数，函数未定义时直接返回
+func (s *Script) call(fn string, args func(*lua.LState) []lua.LValue, nret int, handle func(*lua.LState) error) error {
+	L := newSandbox()
+	defer L.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	L.SetContext(ctx)
+
+	L.Push(L.NewFunctionFromProto(s.proto))
+	if err := L.PCall(0, 0, nil); err != nil {
+		return s.wrapError(ctx, err)
+	}
+	f := L.GetGlobal(fn)
+	if f == lua.LNil {
+		return nil
+	}
+	if err := L.CallByParam(lua.P{Fn: f, NRet: nret, Protect: true}, args(L)...); err != nil {
+		return s.wrapError(ctx, err)
+	}
+	return handle(L)
+}
+
+func (s *Script) wrapError(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("policy script %s exceeded %v", s.name, s.timeout)
+	}
+	return fmt.Errorf("policy script %s: %w", s.name, err)
+}
+
+// newSandbox 创建只开放安全库的 Lua 状态
+func newSandbox() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true, CallStackSize: 256, RegistryMaxSize: 1 << 16})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range unsafeGlobals {
+		L.SetGlobal(name, lua.LNil)
+	}
+	return L
+}
+
+func entryTable(L *lua.LState, e Entry) *lua.LTable {
+	t := L.NewTable()
+	if e.Song != nil {
+		t.RawSetString("id", lua.LString(e.Song.ID))
+		t.RawSetString("title", lua.LString(e.Song.Title))
+		t.RawSetString("artist", lua.LString(e.Song.Artist))
+		t.RawSetString("album", lua.LString(e.Song.Album))
+		t.RawSetString("duration_ms", lua.LNumber(e.Song.DurationMs))
+		t.RawSetString("explicit", lua.LBool(e.Song.Explicit))
+	}
+	t.RawSetString("added_by", lua.LString(e.AddedBy))
+	return t
+}
+
+func entriesTable(L *lua.LState, entries []Entry) *lua.LTable {
+	t := L.CreateTable(len(entries), 0)
+	for _, e := range entries {
+		t.Append(entryTable(L, e))
+	}
+	return t
+}
+
+func contextTable(L *lua.LState, ctx Context) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString("user", lua.LString(ctx.User))
+	t.RawSetString("is_admin", lua.LBool(ctx.IsAdmin))
+	t.RawSetString("queue", entriesTable(L, ctx.Queue))
+	t.RawSetString("history", entriesTable(L, ctx.History))
+	return t
+}
@@ -0,0 +1,122 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 公告的级别，客户端据此决定横幅的颜色
+const (
+	AnnouncementInfo    = "info"
+	AnnouncementWarning = "warning"
+)
+
+// MaxAnnouncementLength 公告内容的最大长度（字符）
+const MaxAnnouncementLength = 500
+
+// Announcement 管理员发布的公告，所有客户端以横幅显示，例如“服务器将在 22:00 重启”
+// ExpiresAt 为空时一直显示到被清除
+type Announcement struct {
+	Message   string     `json:"message"`
+	Level     string     `json:"level"`
+	CreatedBy string     `json:"createdBy"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+func (a *Announcement) expired(now time.Time) bool {
+	return a.ExpiresAt != nil && !a.ExpiresAt.After(now)
+}
+
+// SetAnnouncement 发布公告，替换之前的公告并广播给所有客户端
+func (m *Manager) SetAnnouncement(message, level string, expiresAt *time.Time, actor Actor) (*Announcement, error) {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return nil, errors.New("message is required")
+	}
+	if utf8.RuneCountInString(message) > MaxAnnouncementLength {
+		return nil, fmt.Errorf("message must be at most %d characters", MaxAnnouncementLength)
+	}
+	switch level {
+	case "":
+		level = AnnouncementInfo
+	case AnnouncementInfo, AnnouncementWarning:
+	default:
+		return nil, fmt.Errorf("unknown announcement level %q", level)
+	}
+	announcement := &Announcement{Message: message, Level: level, CreatedBy: actor.Username, CreatedAt: time.Now(), ExpiresAt: expiresAt}
+	if announcement.expired(announcement.CreatedAt) {
+		return nil, errors.New("expiresAt must be in the future")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.State.Announcement = announcement
+	if data, err := json.Marshal(announcement); err == nil {
+		m.store.Set("announcement", string(data))
+	}
+	m.scheduleAnnouncementExpiry()
+	m.broadcast()
+	log.Printf("Action: Announcement set by %s", actor.Username)
+	return announcement, nil
+}
+
+// ClearAnnouncement 撤下当前公告
+func (m *Manager) ClearAnnouncement() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.State.Announcement == nil {
+		return
+	}
+	m.clearAnnouncementLocked()
+	log.Println("Action: Announcement cleared")
+}
+
+// clearAnnouncementLocked 清除公告并广播，调用方需持有锁
+func (m *Manager) clearAnnouncementLocked() {
+	m.State.Announcement = nil
+	m.store.Set("announcement", "")
+	m.scheduleAnnouncementExpiry()
+	m.broadcast()
+}
+
+// scheduleAnnouncementExpiry 在当前公告过期时自动撤下，调用方需持有锁
+func (m *Manager) scheduleAnnouncementExpiry() {
+	if m.announcementTimer != nil {
+		m.announcementTimer.Stop()
+		m.announcementTimer = nil
+	}
+	announcement := m.State.Announcement
+	if announcement == nil || announcement.ExpiresAt == nil {
+		return
+	}
+	m.announcementTimer = time.AfterFunc(time.Until(*announcement.ExpiresAt), func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		// 定时器触发前公告可能已被替换，或者本实例已不是主实例
+		if !m.active || m.State.Announcement != announcement {
+			return
+		}
+		m.clearAnnouncementLocked()
+		log.Println("Announcement expired")
+	})
+}
+
+// loadAnnouncement 恢复保存的公告，已过期的丢弃，调用方需持有锁
+func (m *Manager) loadAnnouncement() {
+	data, _ := m.store.Get("announcement")
+	if data == "" {
+		return
+	}
+	var saved Announcement
+	if err := json.Unmarshal([]byte(data), &saved); err != nil || saved.expired(time.Now()) {
+		return
+	}
+	m.State.Announcement = &saved
+	m.scheduleAnnouncementExpiry()
+}
@@ -0,0 +1,75 @@
+package state
+
+import (
+	"cmp"
+	"log"
+	"math"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// 未配置时自动 DJ 的默认条件
+const (
+	defaultAutoDJMaxBPMDelta = 6
+	defaultAutoDJLookahead   = 8
+)
+
+// autoDJNextIdx 把自动 DJ 选中的歌曲移到当前歌曲之后并返回其索引，调用方需持有锁
+func (m *Manager) autoDJNextIdx() int {
+	next := m.nextPlayableIdx(m.State.CurrentPlaylistIdx, 1)
+	idx := m.autoDJPickIdx()
+	if idx == next {
+		return idx
+	}
+	item := m.State.Playlist[idx]
+	current := m.State.CurrentSong
+	log.Printf("Auto-DJ: moving %s (%.1f BPM, %s) up after %s (%.1f BPM, %s)", item.SongID, item.Song.BPM, item.Song.MusicalKey, current.ID, current.BPM, current.MusicalKey)
+	return m.insertAfterCurrent(item)
+}
+
+// autoDJPickIdx 按队列顺序在接下来的若干首可播放歌曲中找第一首能与当前歌曲衔接的，返回其索引，不移动位置；
+// 当前歌曲没有 BPM 或找不到合适的歌曲时返回按顺序的下一首，调用方需持有锁
+func (m *Manager) autoDJPickIdx() int {
+	next := m.nextPlayableIdx(m.State.CurrentPlaylistIdx, 1)
+	current := m.State.CurrentSong
+	if next == -1 || current == nil || current.BPM <= 0 {
+		return next
+	}
+	lookahead := cmp.Or(m.cfg.Queue.AutoDJ.Lookahead, defaultAutoDJLookahead)
+	n := len(m.State.Playlist)
+	checked := 0
+	for i := 1; i < n && checked < lookahead; i++ {
+		idx := (m.State.CurrentPlaylistIdx + i) % n
+		item := m.State.Playlist[idx]
+		if !m.isPlayable(item.Song) {
+			continue
+		}
+		checked++
+		if m.transitionFits(current, item.Song) {
+			return idx
+		}
+	}
+	return next
+}
+
+// transitionFits 判断能否从 from 平滑过渡到 to：节奏相近，且调性和谐（任一首调性未知时只看节奏）
+func (m *Manager) transitionFits(from, to *db.Song) bool {
+	cfg := m.cfg.Queue.AutoDJ
+	if to.BPM <= 0 || !bpmClose(from.BPM, to.BPM, cmp.Or(cfg.MaxBPMDelta, defaultAutoDJMaxBPMDelta)) {
+		return false
+	}
+	if cfg.IgnoreKey || from.MusicalKey == "" || to.MusicalKey == "" {
+		return true
+	}
+	return db.KeysCompatible(from.MusicalKey, to.MusicalKey)
+}
+
+// bpmClose 节奏相差不超过 delta，半速和倍速（例如 70 与 140）也算相近
+func bpmClose(a, b, delta float64) bool {
+	for _, target := range []float64{b, b * 2, b / 2} {
+		if math.Abs(a-target) <= delta {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package state
+
+import (
+	"errors"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// blockedBy 返回屏蔽该歌曲的黑名单条目，未被屏蔽返回 nil，调用方需持有锁
+func (m *Manager) blockedBy(song *db.Song) *db.BlocklistEntry {
+	if song == nil {
+		return nil
+	}
+	artist := strings.ToLower(song.Artist)
+	for i, entry := range m.blocklist {
+		if entry.SongID != "" && entry.SongID == song.ID {
+			return &m.blocklist[i]
+		}
+		if entry.ArtistPattern != "" && artist != "" {
+			if ok, _ := path.Match(strings.ToLower(entry.ArtistPattern), artist); ok {
+				return &m.blocklist[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Blocklist 返回当前黑名单
+func (m *Manager) Blocklist() []db.BlocklistEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]db.BlocklistEntry(nil), m.blocklist...)
+}
+
+// AddBlocklistEntry 新增黑名单条目，当前歌曲被屏蔽时立即切歌
+func (m *Manager) AddBlocklistEntry(entry *db.BlocklistEntry) error {
+	if entry.SongID == "" && entry.ArtistPattern == "" {
+		return errors.New("songId or artistPattern is required")
+	}
+	if _, err := path.Match(entry.ArtistPattern, ""); err != nil {
+		return errors.New("invalid artist pattern")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.db.AddBlocklistEntry(entry); err != nil {
+		return err
+	}
+	m.blocklist = append(m.blocklist, *entry)
+	log.Printf("Action: Blocklist entry %d added by %s", entry.ID, entry.CreatedBy)
+	m.skipIfUnplayable()
+	m.broadcast()
+	return nil
+}
+
+// RemoveBlocklistEntry 删除黑名单条目
+func (m *Manager) RemoveBlocklistEntry(id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.db.DeleteBlocklistEntry(id); err != nil {
+		return err
+	}
+	for i, entry := range m.blocklist {
+		if entry.ID == id {
+			m.blocklist = append(m.blocklist[:i], m.blocklist[i+1:]...)
+			break
+		}
+	}
+	log.Printf("Action: Blocklist entry %d removed", id)
+	return nil
+}
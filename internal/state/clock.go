@@ -0,0 +1,170 @@
+package state
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// 歌曲切换事件，时间均为服务端毫秒时间戳，客户端可据此预加载并对齐切换时刻
+const (
+	EventTrackStarting = "TRACK_STARTING"
+	EventTrackEnded    = "TRACK_ENDED"
+)
+
+// trackStartingLeadMs 提前多久预告下一首歌曲
+const trackStartingLeadMs = 2000
+
+// TrackEvent 是 TRACK_STARTING / TRACK_ENDED 事件的数据
+type TrackEvent struct {
+	SongID string   `json:"songId"`
+	Song   *db.Song `json:"song,omitempty"`
+	// At 为歌曲预计开始（TRACK_STARTING）或实际结束（TRACK_ENDED）的时刻
+	At int64 `json:"at"`
+}
+
+// 播放进度不靠定时器累加：记录一个锚点（anchorAt 时刻进度为 anchorMs），
+// 需要时用经过的墙上时间乘以播放速度推算，定时器抖动或漏触发都不会造成漂移。
+// 歌曲结束由按剩余时长安排的定时器触发。
+
+// positionLocked 返回当前的播放进度，调用方需持有锁
+func (m *Manager) positionLocked() int64 {
+	pos := m.anchorMs
+	if m.clockRunning() {
+		pos += int64(float64(time.Since(m.anchorAt).Milliseconds()) * m.State.PlaybackRate)
+	}
+	if song := m.State.CurrentSong; song != nil && song.DurationMs > 0 && pos > int64(song.DurationMs) {
+		pos = int64(song.DurationMs)
+	}
+	return pos
+}
+
+// setPosition 以当前时刻为锚点重设进度，并重新安排歌曲结束定时器
+// 播放状态或播放速度变化后也需要调用，调用方需持有锁
+func (m *Manager) setPosition(positionMs int64) {
+	m.anchorMs = positionMs
+	m.anchorAt = time.Now()
+	m.State.ProgressMs = positionMs
+	m.scheduleSongEnd()
+}
+
+// persistPosition 将进度锚点写入数据库，重启后据此恢复进度
+func (m *Manager) persistPosition() {
+	m.store.Set("progress_ms", strconv.FormatInt(m.anchorMs, 10))
+	m.store.Set("last_update_unix", strconv.FormatInt(m.anchorAt.Unix(), 10))
+}
+
+// scheduleSongEnd 按剩余时长安排歌曲结束定时器，未在播放时只取消旧定时器，调用方需持有锁
+func (m *Manager) scheduleSongEnd() {
+	if m.endTimer != nil {
+		m.endTimer.Stop()
+		m.endTimer = nil
+	}
+	if m.startingTimer != nil {
+		m.startingTimer.Stop()
+		m.startingTimer = nil
+	}
+	if m.skipTimer != nil {
+		m.skipTimer.Stop()
+		m.skipTimer = nil
+	}
+	if m.upNextTimer != nil {
+		m.upNextTimer.Stop()
+		m.upNextTimer = nil
+	}
+	// 已经触发但还在等锁的旧定时器靠代数判断自行作废
+	m.clockGen++
+	song := m.State.CurrentSong
+	// 镜像远程实例时由远程决定何时切歌
+	if !m.active || m.State.MirroringFrom != "" || !m.clockRunning() || song == nil || song.DurationMs <= 0 {
+		return
+	}
+	remaining := time.Duration(float64(songEndMs(song)-m.positionLocked()) / m.State.PlaybackRate * float64(time.Millisecond))
+	if remaining < 0 {
+		remaining = 0
+	}
+	endsAt := time.Now().Add(remaining)
+	gen := m.clockGen
+	m.scheduleSkip(song, gen)
+	m.scheduleUpNext(remaining, endsAt, gen)
+	m.endTimer = time.AfterFunc(remaining, func() {
+		m.onSongEnd(gen)
+	})
+	if lead := remaining - trackStartingLeadMs*time.Millisecond; lead > 0 {
+		m.startingTimer = time.AfterFunc(lead, func() {
+			m.onTrackStarting(gen, endsAt)
+		})
+	}
+}
+
+// onTrackStarting 在当前歌曲结束前预告下一首
+func (m *Manager) onTrackStarting(gen uint64, startsAt time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if gen != m.clockGen {
+		return
+	}
+	if next := m.upNextLocked(); next != nil {
+		// 下一首切过去后先缓冲 loadingWindow 才开始播放
+		startsAt = startsAt.Add(loadingWindow)
+		m.hub.BroadcastEvent(EventTrackStarting, TrackEvent{SongID: next.ID, Song: copySong(next), At: startsAt.UnixMilli()})
+	}
+}
+
+// upNextLocked 返回当前歌曲结束后将要播放的歌曲，与 advance 的选择顺序一致，调用方需持有锁
+// 穿插的被遗忘的好歌是随机选出的，无法预告
+func (m *Manager) upNextLocked() *db.Song {
+	if m.State.PlayMode == RepeatOne && !m.State.PlayingJingle && m.isPlayable(m.State.CurrentSong) {
+		return m.State.CurrentSong
+	}
+	if song := m.jinglePeekSong(); song != nil {
+		return song
+	}
+	return m.upNextSongLocked()
+}
+
+// upNextSongLocked 返回 advance 不插入台标时将要播放的歌曲，调用方需持有锁
+func (m *Manager) upNextSongLocked() *db.Song {
+	if poll := m.State.Poll; poll != nil && poll.Closed && poll.WinnerID != "" {
+		if song, err := m.db.GetSong(poll.WinnerID); err == nil && m.isPlayable(song) {
+			return song
+		}
+	}
+	if idx := m.policyNextIdx(); idx != -1 {
+		return m.State.Playlist[idx].Song
+	}
+	if song := m.pinnedPeekSong(); song != nil {
+		return song
+	}
+	if idx := m.modePeekIdx(); idx != -1 {
+		return m.State.Playlist[idx].Song
+	}
+	return nil
+}
+
+// onSongEnd 当前歌曲播放完毕，自动切到下一首
+func (m *Manager) onSongEnd(gen uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if gen != m.clockGen {
+		return
+	}
+	m.hub.BroadcastEvent(EventTrackEnded, TrackEvent{SongID: m.State.CurrentSongID, At: time.Now().UnixMilli()})
+	if m.repeatCurrent() {
+		m.broadcast()
+		return
+	}
+	m.advance()
+	m.broadcast()
+}
+
+// broadcast 向所有客户端和订阅者广播当前状态的快照，调用方需持有锁
+func (m *Manager) broadcast() {
+	if !m.active {
+		return
+	}
+	snapshot := m.snapshotLocked()
+	m.hub.Broadcast(snapshot)
+	m.publish(snapshot)
+}
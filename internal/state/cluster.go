@@ -0,0 +1,34 @@
+package state
+
+import (
+	"log"
+)
+
+// SetActive 切换本实例在集群中的角色
+// 成为主实例时从数据库重新加载状态并接管时钟，失去主实例身份时停止时钟和广播
+func (m *Manager) SetActive(active bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.active == active {
+		return nil
+	}
+	m.active = active
+	if !active {
+		m.stopProgressTicker()
+		m.stopLoadingTimer()
+		// active 已为 false，这里只会取消定时器并作废已触发的回调
+		m.scheduleSongEnd()
+		log.Println("State manager deactivated.")
+		return nil
+	}
+	// 之前的主实例可能已经修改过数据库，内存中的状态不再可信
+	m.State = newGlobalState()
+	m.devices = make(map[string]*Device)
+	if err := m.loadFromDB(); err != nil {
+		m.active = false
+		return err
+	}
+	log.Println("State manager activated and reloaded from DB.")
+	m.broadcast()
+	return nil
+}
@@ -0,0 +1,32 @@
+package state
+
+import (
+	"log"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// EventSongComments 歌曲开始播放时广播作者选择闪现的评论，只发给声明了 chat 功能的客户端
+const EventSongComments = "SONG_COMMENTS"
+
+// SongCommentsEvent 是 SONG_COMMENTS 事件的数据
+type SongCommentsEvent struct {
+	SongID   string           `json:"songId"`
+	Comments []db.SongComment `json:"comments"`
+}
+
+// flashCommentsLocked 广播新歌曲要闪现的评论，没有时不广播，调用方需持有锁
+func (m *Manager) flashCommentsLocked(songID string) {
+	if !m.active {
+		return
+	}
+	comments, err := m.db.GetFlashComments(songID)
+	if err != nil {
+		log.Printf("Warning: failed to get comments of %s: %v", songID, err)
+		return
+	}
+	if len(comments) == 0 {
+		return
+	}
+	m.hub.BroadcastEvent(EventSongComments, SongCommentsEvent{SongID: songID, Comments: comments})
+}
@@ -0,0 +1,149 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// 设备角色
+const (
+	// DeviceOutput 实际发出声音的客户端
+	DeviceOutput = "output"
+	// DeviceRemote 只用来控制的遥控端
+	DeviceRemote = "remote"
+)
+
+// 设备事件类型
+const (
+	EventDeviceJoined = "DEVICE_JOINED"
+	EventDeviceLeft   = "DEVICE_LEFT"
+)
+
+// maxDevicesPerConnection 每个连接最多登记的设备数，正常客户端只登记一个
+const maxDevicesPerConnection = 4
+
+// Device 是通过 WebSocket 注册的客户端设备
+type Device struct {
+	ID          string    `json:"id"` // 客户端生成并持久保存的设备 ID
+	Name        string    `json:"name"`
+	Role        string    `json:"role"`
+	Username    string    `json:"username,omitempty"`
+	Volume      float64   `json:"volume"` // 0.0 - 1.0，由客户端自行应用
+	ConnectedAt time.Time `json:"connectedAt"`
+
+	connID string
+}
+
+// RegisterDevice 登记一个连接上的设备角色。只有登录用户可以登记；
+// 同一用户的设备重连会替换旧的登记，其他用户登记的设备 ID 会被拒绝
+func (m *Manager) RegisterDevice(connID, deviceID, name, role, username string) error {
+	if username == "" {
+		return errors.New("sign in to register a device")
+	}
+	if deviceID == "" {
+		return errors.New("deviceId is required")
+	}
+	if role != DeviceOutput && role != DeviceRemote {
+		return fmt.Errorf("unknown device role %q", role)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.devices[deviceID]; ok {
+		if existing.Username != username {
+			return errors.New("device is registered by another user")
+		}
+		// 同一连接重复登记且没有变化时不再广播
+		if existing.connID == connID && existing.Name == name && existing.Role == role {
+			return nil
+		}
+	}
+	registered := 0
+	for id, device := range m.devices {
+		if device.connID == connID && id != deviceID {
+			registered++
+		}
+	}
+	if registered >= maxDevicesPerConnection {
+		return fmt.Errorf("at most %d devices per connection", maxDevicesPerConnection)
+	}
+
+	volume := 1.0
+	if v, ok := m.deviceVolumes[deviceID]; ok {
+		volume = v
+	}
+	device := &Device{
+		ID:          deviceID,
+		Name:        name,
+		Role:        role,
+		Username:    username,
+		Volume:      volume,
+		ConnectedAt: time.Now(),
+		connID:      connID,
+	}
+	m.devices[deviceID] = device
+	m.refreshOutputDevices()
+
+	m.hub.BroadcastEvent(EventDeviceJoined, device)
+	m.broadcast()
+	log.Printf("Device %s (%s) registered as %s", deviceID, name, role)
+	return nil
+}
+
+// DisconnectDevices 移除某个连接登记的所有设备
+func (m *Manager) DisconnectDevices(connID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var left []*Device
+	for id, device := range m.devices {
+		if device.connID == connID {
+			delete(m.devices, id)
+			left = append(left, device)
+		}
+	}
+	if len(left) == 0 {
+		return
+	}
+	m.refreshOutputDevices()
+	for _, device := range left {
+		m.hub.BroadcastEvent(EventDeviceLeft, device)
+		log.Printf("Device %s disconnected", device.ID)
+	}
+	m.broadcast()
+}
+
+// SetDeviceVolume 设置指定设备的音量
+func (m *Manager) SetDeviceVolume(deviceID string, volume float64) error {
+	if volume < 0 || volume > 1 {
+		return errors.New("volume must be between 0 and 1")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	device, ok := m.devices[deviceID]
+	if !ok {
+		return errors.New("device not connected")
+	}
+	device.Volume = volume
+	m.deviceVolumes[deviceID] = volume
+	m.refreshOutputDevices()
+	m.broadcast()
+	log.Printf("Action: Device %s volume set to %.2f", deviceID, volume)
+	return nil
+}
+
+// refreshOutputDevices 重新生成状态中的输出设备列表，调用方需持有锁
+func (m *Manager) refreshOutputDevices() {
+	outputs := make([]Device, 0, len(m.devices))
+	for _, device := range m.devices {
+		if device.Role == DeviceOutput {
+			outputs = append(outputs, *device)
+		}
+	}
+	sort.Slice(outputs, func(i, j int) bool {
+		return outputs[i].ConnectedAt.Before(outputs[j].ConnectedAt)
+	})
+	m.State.OutputDevices = outputs
+}
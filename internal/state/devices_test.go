@@ -0,0 +1,68 @@
+package state
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRegisterDevice(t *testing.T) {
+	m, _, backend := newTestManager(t)
+
+	if err := m.RegisterDevice("conn-1", "dev-1", "Browser", DeviceOutput, ""); err == nil {
+		t.Error("anonymous registration succeeded, want error")
+	}
+	if err := m.RegisterDevice("conn-1", "dev-1", "Browser", DeviceOutput, "alice"); err != nil {
+		t.Fatalf("RegisterDevice: %v", err)
+	}
+
+	// 其他用户不能接管已登记的设备
+	if err := m.RegisterDevice("conn-2", "dev-1", "Other", DeviceOutput, "bob"); err == nil {
+		t.Error("registration of another user's device succeeded, want error")
+	}
+	m.mu.RLock()
+	owner, conn := m.devices["dev-1"].Username, m.devices["dev-1"].connID
+	m.mu.RUnlock()
+	if owner != "alice" || conn != "conn-1" {
+		t.Errorf("dev-1 owned by %q on %q, want alice on conn-1", owner, conn)
+	}
+
+	// 同一连接重复登记不再广播
+	backend.reset()
+	if err := m.RegisterDevice("conn-1", "dev-1", "Browser", DeviceOutput, "alice"); err != nil {
+		t.Fatalf("RegisterDevice: %v", err)
+	}
+	if n := backend.stateBroadcasts(); n != 0 {
+		t.Errorf("state broadcasts = %d, want 0", n)
+	}
+
+	// 同一用户重连时替换旧的登记
+	if err := m.RegisterDevice("conn-3", "dev-1", "Browser", DeviceOutput, "alice"); err != nil {
+		t.Fatalf("RegisterDevice after reconnect: %v", err)
+	}
+	m.mu.RLock()
+	conn = m.devices["dev-1"].connID
+	m.mu.RUnlock()
+	if conn != "conn-3" {
+		t.Errorf("dev-1 on %q, want conn-3", conn)
+	}
+}
+
+func TestRegisterDeviceLimit(t *testing.T) {
+	m, _, _ := newTestManager(t)
+	for i := range maxDevicesPerConnection {
+		if err := m.RegisterDevice("conn-1", fmt.Sprintf("dev-%d", i), "Browser", DeviceRemote, "alice"); err != nil {
+			t.Fatalf("RegisterDevice %d: %v", i, err)
+		}
+	}
+	if err := m.RegisterDevice("conn-1", "dev-extra", "Browser", DeviceRemote, "alice"); err == nil {
+		t.Error("registration beyond the per-connection limit succeeded, want error")
+	}
+	// 已登记的设备仍然可以更新
+	if err := m.RegisterDevice("conn-1", "dev-0", "Browser", DeviceOutput, "alice"); err != nil {
+		t.Errorf("re-registering an existing device: %v", err)
+	}
+	// 其他连接不受影响
+	if err := m.RegisterDevice("conn-2", "dev-extra", "Browser", DeviceRemote, "alice"); err != nil {
+		t.Errorf("RegisterDevice on another connection: %v", err)
+	}
+}
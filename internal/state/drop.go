@@ -0,0 +1,178 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// EventCountdown 预定的歌曲开始前 dropCountdownWindow 内每秒广播一次，数据为 Countdown
+const EventCountdown = "COUNTDOWN"
+
+const (
+	// MaxDropAhead 最多提前多久预定
+	MaxDropAhead = 7 * 24 * time.Hour
+	// dropCountdownWindow 开始前多久起每秒广播倒计时
+	dropCountdownWindow = 60 * time.Second
+)
+
+// Drop 预定在某个精确时刻开始播放的歌曲（例如零点的新年歌），到点时打断当前歌曲
+type Drop struct {
+	SongID      string    `json:"songId"`
+	Title       string    `json:"title"`
+	Artist      string    `json:"artist"`
+	At          time.Time `json:"at"`
+	ScheduledBy string    `json:"scheduledBy"`
+}
+
+// Countdown 是 COUNTDOWN 事件的数据，At 为歌曲开始播放的时刻（Unix 毫秒）
+type Countdown struct {
+	SongID       string `json:"songId"`
+	At           int64  `json:"at"`
+	RemainingSec int    `json:"remainingSec"`
+}
+
+// untilNextTick 距离下一次需要处理的时间：倒计时之前等到倒计时开始，倒计时中等到下一个整秒，
+// 最后一秒等到切歌的时刻；切歌后还要缓冲 loadingWindow，所以提前这么久切歌，使歌曲正好在 At 开始
+func (d *Drop) untilNextTick(now time.Time) time.Duration {
+	remaining := d.At.Sub(now)
+	switch {
+	case remaining > dropCountdownWindow:
+		return remaining - dropCountdownWindow
+	case remaining > time.Second:
+		return remaining - time.Duration(math.Ceil(remaining.Seconds())-1)*time.Second
+	default:
+		return remaining - loadingWindow
+	}
+}
+
+// ScheduleDrop 预定在 at 时刻开始播放歌曲，替换之前的预定
+func (m *Manager) ScheduleDrop(songID string, at time.Time, actor Actor) (*Drop, error) {
+	now := time.Now()
+	if !at.After(now.Add(loadingWindow)) {
+		return nil, errors.New("at must be in the future")
+	}
+	if at.After(now.Add(MaxDropAhead)) {
+		return nil, fmt.Errorf("at must be within %d days", int(MaxDropAhead.Hours()/24))
+	}
+	song, err := m.db.GetSong(songID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkContent(song); err != nil {
+		return nil, err
+	}
+	drop := &Drop{SongID: song.ID, Title: song.Title, Artist: song.Artist, At: at, ScheduledBy: actor.Username}
+	m.State.Drop = drop
+	m.saveDrop()
+	m.scheduleDropLocked()
+	m.broadcast()
+	log.Printf("Action: Song %s scheduled to start at %s by %s", song.ID, at.Format(time.RFC3339), actor.Username)
+	d := *drop
+	return &d, nil
+}
+
+// CancelDrop 取消预定的歌曲
+func (m *Manager) CancelDrop() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.State.Drop == nil {
+		return errors.New("no drop is scheduled")
+	}
+	m.State.Drop = nil
+	m.saveDrop()
+	m.scheduleDropLocked()
+	m.broadcast()
+	log.Println("Action: Scheduled drop cancelled")
+	return nil
+}
+
+// scheduleDropLocked 为当前的预定设置下一次处理的定时器，调用方需持有锁
+func (m *Manager) scheduleDropLocked() {
+	if m.dropTimer != nil {
+		m.dropTimer.Stop()
+		m.dropTimer = nil
+	}
+	drop := m.State.Drop
+	if drop == nil || !m.active {
+		return
+	}
+	m.dropTimer = time.AfterFunc(drop.untilNextTick(time.Now()), func() {
+		m.onDropTick(drop)
+	})
+}
+
+// onDropTick 广播倒计时，到点时打断当前歌曲播放预定的歌曲
+func (m *Manager) onDropTick(drop *Drop) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// 定时器触发前预定可能已被替换或取消，或者本实例已不是主实例
+	if !m.active || m.State.Drop != drop {
+		return
+	}
+	if remaining := time.Until(drop.At); remaining > loadingWindow {
+		m.hub.BroadcastEvent(EventCountdown, Countdown{SongID: drop.SongID, At: drop.At.UnixMilli(), RemainingSec: int(math.Ceil(remaining.Seconds()))})
+		m.scheduleDropLocked()
+		return
+	}
+	m.dropTimer = nil
+	m.State.Drop = nil
+	m.saveDrop()
+	m.playDropLocked(drop)
+	m.broadcast()
+}
+
+// playDropLocked 把预定的歌曲插到当前歌曲之后并立即切过去，调用方需持有锁
+// 镜像远程实例时播放由远程实例控制，不打断
+func (m *Manager) playDropLocked(drop *Drop) {
+	if m.State.MirroringFrom != "" {
+		log.Printf("Warning: scheduled drop %s skipped while mirroring", drop.SongID)
+		return
+	}
+	song, err := m.db.GetSong(drop.SongID)
+	if err != nil || !m.isPlayable(song) {
+		log.Printf("Warning: scheduled drop %s can no longer be played", drop.SongID)
+		return
+	}
+	idx := m.insertAfterCurrent(db.PlaylistItem{SongID: song.ID, AddedBy: drop.ScheduledBy, Song: song})
+	m.changeSong(idx)
+	log.Printf("Action: Dropped scheduled song %s (%s)", song.ID, song.Title)
+}
+
+// saveDrop 持久化预定，调用方需持有锁
+func (m *Manager) saveDrop() {
+	if m.State.Drop == nil {
+		m.store.Set("scheduled_drop", "")
+		return
+	}
+	if data, err := json.Marshal(m.State.Drop); err == nil {
+		m.store.Set("scheduled_drop", string(data))
+	}
+}
+
+// loadDrop 恢复保存的预定，重启期间已经错过的丢弃，调用方需持有锁
+func (m *Manager) loadDrop() {
+	data, _ := m.store.Get("scheduled_drop")
+	if data == "" {
+		return
+	}
+	var saved Drop
+	if err := json.Unmarshal([]byte(data), &saved); err != nil {
+		return
+	}
+	if time.Until(saved.At) <= loadingWindow {
+		log.Printf("Warning: scheduled drop %s at %s was missed", saved.SongID, saved.At.Format(time.RFC3339))
+		m.store.Set("scheduled_drop", "")
+		return
+	}
+	m.State.Drop = &saved
+	m.scheduleDropLocked()
+}
@@ -0,0 +1,109 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+)
+
+// EQPreset 均衡器预设
+type EQPreset string
+
+const (
+	EQFlat        EQPreset = "FLAT"
+	EQBassBoost   EQPreset = "BASS_BOOST"
+	EQTrebleBoost EQPreset = "TREBLE_BOOST"
+	EQVocal       EQPreset = "VOCAL"
+	// EQCustom 使用请求中给出的各频段增益
+	EQCustom EQPreset = "CUSTOM"
+)
+
+// MaxEQGainDb 单个频段增益的绝对值上限
+const MaxEQGainDb = 12.0
+
+// EQFrequencies 均衡器的固定频段（Hz），客户端为每个频段创建一个 peaking 滤波器
+var EQFrequencies = []float64{32, 64, 125, 250, 500, 1000, 2000, 4000, 8000, 16000}
+
+// eqPresetGains 内置预设的各频段增益，与 EQFrequencies 一一对应
+var eqPresetGains = map[EQPreset][]float64{
+	EQFlat:        {0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	EQBassBoost:   {6, 5, 4, 2, 0, 0, 0, 0, 0, 0},
+	EQTrebleBoost: {0, 0, 0, 0, 0, 0, 2, 4, 5, 6},
+	EQVocal:       {-2, -2, -1, 0, 2, 4, 4, 2, 0, -1},
+}
+
+// EQBand 一个频段的中心频率和增益
+type EQBand struct {
+	FrequencyHz float64 `json:"frequencyHz"`
+	GainDb      float64 `json:"gainDb"`
+}
+
+// Equalizer 所有客户端共享的均衡器设置，支持 Web Audio 的客户端按 Bands 渲染
+type Equalizer struct {
+	Preset EQPreset `json:"preset"`
+	Bands  []EQBand `json:"bands"`
+}
+
+// newEqualizer 按预设生成均衡器，custom 预设使用 gains
+func newEqualizer(preset EQPreset, gains []float64) (Equalizer, error) {
+	if preset != EQCustom {
+		presetGains, ok := eqPresetGains[preset]
+		if !ok {
+			return Equalizer{}, fmt.Errorf("unknown equalizer preset %q", preset)
+		}
+		gains = presetGains
+	} else if len(gains) != len(EQFrequencies) {
+		return Equalizer{}, fmt.Errorf("custom equalizer needs %d band gains", len(EQFrequencies))
+	}
+	eq := Equalizer{Preset: preset, Bands: make([]EQBand, len(EQFrequencies))}
+	for i, freq := range EQFrequencies {
+		if math.IsNaN(gains[i]) || math.Abs(gains[i]) > MaxEQGainDb {
+			return Equalizer{}, fmt.Errorf("band gains must be between -%.0f and %.0f dB", MaxEQGainDb, MaxEQGainDb)
+		}
+		eq.Bands[i] = EQBand{FrequencyHz: freq, GainDb: gains[i]}
+	}
+	return eq, nil
+}
+
+// flatEqualizer 默认的均衡器设置
+func flatEqualizer() Equalizer {
+	eq, _ := newEqualizer(EQFlat, nil)
+	return eq
+}
+
+// SetEqualizer 切换所有客户端共享的均衡器，custom 预设需要给出每个频段的增益
+func (m *Manager) SetEqualizer(preset EQPreset, gains []float64) error {
+	eq, err := newEqualizer(preset, gains)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.State.Equalizer = eq
+	if data, err := json.Marshal(eq); err == nil {
+		m.store.Set("equalizer", string(data))
+	}
+	m.broadcast()
+	log.Printf("Action: Equalizer set to %s", preset)
+	return nil
+}
+
+// loadEqualizer 恢复保存的均衡器，数据无效时保持默认，调用方需持有锁
+func (m *Manager) loadEqualizer() {
+	data, _ := m.store.Get("equalizer")
+	if data == "" {
+		return
+	}
+	var saved Equalizer
+	if err := json.Unmarshal([]byte(data), &saved); err != nil {
+		return
+	}
+	gains := make([]float64, len(saved.Bands))
+	for i, band := range saved.Bands {
+		gains[i] = band.GainDb
+	}
+	if eq, err := newEqualizer(saved.Preset, gains); err == nil {
+		m.State.Equalizer = eq
+	}
+}
@@ -0,0 +1,46 @@
+package state
+
+import (
+	"time"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// estimateStartTimes 按当前进度和歌曲时长推算每首待播歌曲的开始时间，写入快照的播放列表
+// 只在播放中且顺序可预测时推算：随机和单曲循环无法预知，会被跳过的歌曲和时长未知之后的歌曲不给出时间
+// 调用方需持有（读）锁
+func (m *Manager) estimateStartTimes(playlist []db.PlaylistItem, progressMs int64) {
+	if !m.State.IsPlaying || m.State.PlayMode != RepeatAll || m.State.CurrentSong == nil || len(playlist) == 0 {
+		return
+	}
+	if m.State.CurrentSong.DurationMs <= 0 {
+		return
+	}
+	rate := m.State.PlaybackRate
+	end := songEndMs(m.State.CurrentSong)
+	remaining := end - progressMs
+	// 扣除当前歌曲剩余部分中将被跳过的区间
+	for _, r := range m.State.CurrentSong.SkipRegions {
+		if r.EndMs > progressMs && r.StartMs < end {
+			remaining -= r.EndMs - max(r.StartMs, progressMs)
+		}
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	start := time.Now().Add(time.Duration(float64(remaining) / rate * float64(time.Millisecond)))
+	// 列表循环播放，从当前歌曲的下一首开始绕一圈
+	n := len(playlist)
+	for i := 1; i < n; i++ {
+		item := &playlist[(m.State.CurrentPlaylistIdx+i)%n]
+		if !m.isPlayable(item.Song) {
+			continue
+		}
+		at := start.Truncate(time.Second)
+		item.EstimatedStartAt = &at
+		if item.Song.DurationMs <= 0 {
+			return
+		}
+		start = start.Add(time.Duration(float64(playableDurationMs(item.Song)) / rate * float64(time.Millisecond)))
+	}
+}
@@ -0,0 +1,70 @@
+package state
+
+import (
+	"time"
+)
+
+// EventFade 手动切歌、暂停和继续播放时广播，客户端按 FadeMs 淡入淡出而不是直接切断
+// 淡出期间客户端继续播放，结束后再停止或切歌；进度仍以服务端为准，客户端不必补偿淡出的这段时间
+const EventFade = "FADE"
+
+// defaultFadeMs 没有保存过房间设置时的淡入淡出时长
+const defaultFadeMs = 300
+
+// FadeDirection 淡入或淡出
+type FadeDirection string
+
+const (
+	FadeOut FadeDirection = "out"
+	FadeIn  FadeDirection = "in"
+)
+
+// 触发淡入淡出的操作
+const (
+	FadeReasonSkip   = "skip"
+	FadeReasonPause  = "pause"
+	FadeReasonResume = "resume"
+)
+
+// FadeEvent 是 FADE 事件的数据，At 为淡入淡出开始的服务端毫秒时间戳
+type FadeEvent struct {
+	DurationMs int           `json:"durationMs"`
+	Direction  FadeDirection `json:"direction"`
+	Reason     string        `json:"reason"`
+	SongID     string        `json:"songId"`
+	At         int64         `json:"at"`
+}
+
+// fadeOutLocked 当前歌曲正在播放时广播淡出，调用方需持有锁
+func (m *Manager) fadeOutLocked(reason string) {
+	if m.State.Status != Playing {
+		return
+	}
+	m.broadcastFade(FadeOut, reason, time.Now())
+}
+
+// fadeInLocked 开始播放或切到新歌曲后广播淡入，新歌曲在 Loading 结束时才开始淡入，调用方需持有锁
+func (m *Manager) fadeInLocked(reason string) {
+	at := time.Now()
+	switch m.State.Status {
+	case Loading:
+		at = at.Add(loadingWindow)
+	case Playing:
+	default:
+		return
+	}
+	m.broadcastFade(FadeIn, reason, at)
+}
+
+func (m *Manager) broadcastFade(direction FadeDirection, reason string, at time.Time) {
+	if !m.active || m.State.Settings.FadeMs == 0 {
+		return
+	}
+	m.hub.BroadcastEvent(EventFade, FadeEvent{
+		DurationMs: m.State.Settings.FadeMs,
+		Direction:  direction,
+		Reason:     reason,
+		SongID:     m.State.CurrentSongID,
+		At:         at.UnixMilli(),
+	})
+}
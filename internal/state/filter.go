@@ -0,0 +1,186 @@
+package state
+
+import (
+	"log"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// isPlayable 判断歌曲当前是否允许被自动播放
+func (m *Manager) isPlayable(song *db.Song) bool {
+	if song == nil {
+		return false
+	}
+	if song.Unavailable {
+		return false
+	}
+	if m.State.FamilyMode && song.Explicit {
+		return false
+	}
+	return m.blockedBy(song) == nil
+}
+
+// nextPlayableIdx 从 from 开始按 step 方向循环查找下一首可播放的歌曲，找不到返回 -1
+// 调用方需持有锁
+func (m *Manager) nextPlayableIdx(from, step int) int {
+	n := len(m.State.Playlist)
+	for i := 1; i <= n; i++ {
+		idx := ((from+step*i)%n + n) % n
+		if m.isPlayable(m.State.Playlist[idx].Song) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// SetFamilyMode 开启或关闭家庭模式
+// 开启时如果当前歌曲是露骨内容，立即切到下一首可播放的歌曲
+func (m *Manager) SetFamilyMode(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setFamilyModeLocked(enabled)
+	m.broadcast()
+}
+
+// setFamilyModeLocked 开启或关闭家庭模式并持久化，不广播，调用方需持有锁
+func (m *Manager) setFamilyModeLocked(enabled bool) {
+	m.State.FamilyMode = enabled
+	m.State.Settings.FamilyMode = enabled
+	m.store.Set("family_mode", boolString(enabled))
+	log.Printf("Action: Family mode set to %v", enabled)
+	m.skipIfUnplayable()
+}
+
+// SetSongExplicit 手动标记歌曲，并同步内存中播放列表里的副本
+func (m *Manager) SetSongExplicit(songID string, explicit bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.db.SetSongExplicit(songID, explicit); err != nil {
+		return err
+	}
+	for i := range m.State.Playlist {
+		if song := m.State.Playlist[i].Song; song != nil && song.ID == songID {
+			song.Explicit = explicit
+		}
+	}
+	if m.State.CurrentSong != nil && m.State.CurrentSong.ID == songID {
+		m.State.CurrentSong.Explicit = explicit
+	}
+	log.Printf("Action: Song %s marked explicit=%v", songID, explicit)
+	m.skipIfUnplayable()
+	m.broadcast()
+	return nil
+}
+
+// SetSongUnavailable 标记歌曲暂时不能播放（例如源文件所在的挂载点丢失），并同步内存中的副本
+func (m *Manager) SetSongUnavailable(songID string, unavailable bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.db.SetSongUnavailable(songID, unavailable); err != nil {
+		return err
+	}
+	for i := range m.State.Playlist {
+		if song := m.State.Playlist[i].Song; song != nil && song.ID == songID {
+			song.Unavailable = unavailable
+		}
+	}
+	if m.State.CurrentSong != nil && m.State.CurrentSong.ID == songID {
+		m.State.CurrentSong.Unavailable = unavailable
+	}
+	log.Printf("Action: Song %s marked unavailable=%v", songID, unavailable)
+	m.skipIfUnplayable()
+	m.broadcast()
+	return nil
+}
+
+// SetSongGain 保存歌曲的音量补偿并同步内存中的副本，当前歌曲的补偿随广播下发给客户端
+func (m *Manager) SetSongGain(songID string, gainDb float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.db.SetSongGain(songID, gainDb); err != nil {
+		return err
+	}
+	changed := false
+	for i := range m.State.Playlist {
+		if song := m.State.Playlist[i].Song; song != nil && song.ID == songID {
+			song.GainDb = &gainDb
+			changed = true
+		}
+	}
+	if m.State.CurrentSong != nil && m.State.CurrentSong.ID == songID {
+		m.State.CurrentSong.GainDb = &gainDb
+		changed = true
+	}
+	// 分析曲库时大部分歌曲不在播放列表中，无需广播
+	if changed {
+		m.broadcast()
+	}
+	return nil
+}
+
+// SetSongYear 保存重新扫描得到的发行年份，并同步播放列表中的副本
+func (m *Manager) SetSongYear(songID string, year int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.db.SetSongYear(songID, year); err != nil {
+		return err
+	}
+	changed := false
+	for i := range m.State.Playlist {
+		if song := m.State.Playlist[i].Song; song != nil && song.ID == songID {
+			song.Year = year
+			changed = true
+		}
+	}
+	if m.State.CurrentSong != nil && m.State.CurrentSong.ID == songID {
+		m.State.CurrentSong.Year = year
+		changed = true
+	}
+	if changed {
+		m.broadcast()
+	}
+	return nil
+}
+
+// SetSongTags 保存流派和情绪标签（自动分类或手动修改），并同步播放列表中的副本
+func (m *Manager) SetSongTags(songID string, tags db.SongTags) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.db.SetSongTags(songID, tags); err != nil {
+		return err
+	}
+	changed := false
+	for i := range m.State.Playlist {
+		if song := m.State.Playlist[i].Song; song != nil && song.ID == songID {
+			song.SetTags(tags)
+			changed = true
+		}
+	}
+	if m.State.CurrentSong != nil && m.State.CurrentSong.ID == songID {
+		m.State.CurrentSong.SetTags(tags)
+		changed = true
+	}
+	if changed {
+		m.broadcast()
+	}
+	return nil
+}
+
+// skipIfUnplayable 当前歌曲不再允许播放时切到下一首，调用方需持有锁
+func (m *Manager) skipIfUnplayable() {
+	if m.State.CurrentSong == nil || m.isPlayable(m.State.CurrentSong) {
+		return
+	}
+	if nextIdx := m.nextPlayableIdx(m.State.CurrentPlaylistIdx, 1); nextIdx != -1 {
+		m.changeSong(nextIdx)
+	} else {
+		m.stopPlayback()
+	}
+}
+
+func boolString(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
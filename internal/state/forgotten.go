@@ -0,0 +1,61 @@
+package state
+
+import (
+	"cmp"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// 未配置时被遗忘的好歌的标准
+const (
+	DefaultForgottenDays     = 90
+	DefaultForgottenMinPlays = 3
+	// forgottenGemCandidates 穿插时从排名靠前的多少首中随机挑选，避免每次都是同一首
+	forgottenGemCandidates = 20
+)
+
+// ForgottenSongs 按配置的标准返回被遗忘的好歌，days 或 minPlays 为 0 时使用配置值
+func (m *Manager) ForgottenSongs(days, minPlays, limit int) ([]db.ForgottenSong, error) {
+	cfg := m.cfg.Library.ForgottenGems
+	days = cmp.Or(days, cfg.Days, DefaultForgottenDays)
+	minPlays = cmp.Or(minPlays, cfg.MinPlays, DefaultForgottenMinPlays)
+	return m.db.GetForgottenSongs(time.Now().AddDate(0, 0, -days), minPlays, limit)
+}
+
+// playForgottenGem 自动切歌时按配置每隔若干首插入一首被遗忘的好歌并切过去，没有插入时返回 false
+// 已在播放列表中或当前不可播放的歌曲不选，调用方需持有锁
+func (m *Manager) playForgottenGem() bool {
+	every := m.cfg.Library.ForgottenGems.Every
+	if every <= 0 {
+		return false
+	}
+	m.songsSinceGem++
+	if m.songsSinceGem < every {
+		return false
+	}
+	gems, err := m.ForgottenSongs(0, 0, forgottenGemCandidates)
+	if err != nil {
+		log.Printf("Warning: failed to get forgotten songs: %v", err)
+		return false
+	}
+	var candidates []*db.Song
+	for i := range gems {
+		song := &gems[i].Song
+		if m.playlistIndex(song.ID) == -1 && m.isPlayable(song) {
+			candidates = append(candidates, song)
+		}
+	}
+	if len(candidates) == 0 {
+		// 下一次切歌时再试
+		return false
+	}
+	m.songsSinceGem = 0
+	song := candidates[rand.Intn(len(candidates))]
+	idx := m.insertAfterCurrent(db.PlaylistItem{SongID: song.ID, Song: song})
+	log.Printf("Action: Playing forgotten gem %s (%s)", song.ID, song.Title)
+	m.changeSong(idx)
+	return true
+}
@@ -0,0 +1,71 @@
+package state
+
+import (
+	"time"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// 无缝切歌约定：歌曲结束前 upNextLeadMs 广播 UP_NEXT，客户端提前缓冲下一首的音频；
+// 真正切歌时广播 SWITCH，所有客户端在 At 时刻同时切到新歌曲。UP_NEXT 只是预告，
+// 其后投票、重排或手动切歌都可能改变下一首，以 SWITCH 的 SongID 为准，与预告不同时客户端按普通切歌加载
+const (
+	EventUpNext = "UP_NEXT"
+	EventSwitch = "SWITCH"
+)
+
+// upNextLeadMs 提前多久预告下一首，留给客户端下载 HLS 索引和前几个分片
+const upNextLeadMs = 10000
+
+// UpNext 是 UP_NEXT 事件的数据，StartAt 为下一首预计开始播放的服务端毫秒时间戳
+type UpNext struct {
+	SongID    string   `json:"songId"`
+	Song      *db.Song `json:"song,omitempty"`
+	StreamURL string   `json:"streamUrl"`
+	StartAt   int64    `json:"startAt"`
+}
+
+// Switch 是 SWITCH 事件的数据，At 为新歌曲开始播放的服务端毫秒时间戳，即 Loading 结束的时刻
+type Switch struct {
+	FromSongID string `json:"fromSongId,omitempty"`
+	SongID     string `json:"songId"`
+	StreamURL  string `json:"streamUrl"`
+	At         int64  `json:"at"`
+}
+
+// scheduleUpNext 安排 UP_NEXT 预告，剩余时长已不足 upNextLeadMs 时立即预告，调用方需持有锁
+// 暂停后继续、跳转或改变速度都会重新安排，预告的开始时间随之更新
+func (m *Manager) scheduleUpNext(remaining time.Duration, endsAt time.Time, gen uint64) {
+	lead := remaining - upNextLeadMs*time.Millisecond
+	if lead < 0 {
+		lead = 0
+	}
+	m.upNextTimer = time.AfterFunc(lead, func() {
+		m.onUpNext(gen, endsAt)
+	})
+}
+
+// onUpNext 广播下一首的预告
+func (m *Manager) onUpNext(gen uint64, endsAt time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if gen != m.clockGen {
+		return
+	}
+	next := m.upNextLocked()
+	if next == nil {
+		return
+	}
+	// 与 TRACK_STARTING 相同，下一首切过去后先缓冲 loadingWindow 才开始播放
+	startAt := endsAt.Add(loadingWindow)
+	m.hub.BroadcastEvent(EventUpNext, UpNext{SongID: next.ID, Song: copySong(next), StreamURL: next.PlayURL(), StartAt: startAt.UnixMilli()})
+}
+
+// announceSwitchLocked 切歌时广播 SWITCH，调用方需持有锁，并已进入 Loading
+func (m *Manager) announceSwitchLocked(fromSongID string, song *db.Song) {
+	if !m.active || song == nil {
+		return
+	}
+	at := time.Now().Add(loadingWindow)
+	m.hub.BroadcastEvent(EventSwitch, Switch{FromSongID: fromSongID, SongID: song.ID, StreamURL: song.PlayURL(), At: at.UnixMilli()})
+}
@@ -0,0 +1,107 @@
+package state
+
+import (
+	"log"
+	"math/rand"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// MaxJingleEvery 最多每隔多少首歌插入一段台标
+const MaxJingleEvery = 100
+
+// jingleDue 下一次自动切歌时是否该插入台标，调用方需持有锁
+func (m *Manager) jingleDue() bool {
+	every := m.State.Settings.JingleEvery
+	return every > 0 && !m.State.PlayingJingle && m.songsSinceJingle+1 >= every
+}
+
+// pickJingleLocked 选出下一段台标，插入之前保持不变，使 UP_NEXT 的预告与实际播放一致
+// 没有可播放的台标时返回 nil，调用方需持有锁
+func (m *Manager) pickJingleLocked() *db.Song {
+	if m.nextJingle != nil && m.isPlayable(m.nextJingle) {
+		return m.nextJingle
+	}
+	m.nextJingle = nil
+	jingles, err := m.db.GetJingles()
+	if err != nil {
+		log.Printf("Warning: failed to get jingles: %v", err)
+		return nil
+	}
+	var candidates []*db.Song
+	for i := range jingles {
+		if song := &jingles[i]; song.ID != m.State.CurrentSongID && m.isPlayable(song) {
+			candidates = append(candidates, song)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	m.nextJingle = candidates[rand.Intn(len(candidates))]
+	return m.nextJingle
+}
+
+// jinglePeekSong 下一次自动切歌会插入的台标，不插入时返回 nil，调用方需持有锁
+func (m *Manager) jinglePeekSong() *db.Song {
+	if !m.jingleDue() || m.upNextSongLocked() == nil {
+		return nil
+	}
+	return m.pickJingleLocked()
+}
+
+// playJingle 自动切歌时按房间设置每隔 JingleEvery 首歌插入一段台标，没有插入时返回 false
+// 台标不加入播放列表，CurrentPlaylistIdx 保持不变，播完后从原来的位置继续；
+// 台标之后没有歌可播时不插入，调用方需持有锁
+func (m *Manager) playJingle() bool {
+	every := m.State.Settings.JingleEvery
+	if every <= 0 || m.State.PlayingJingle {
+		return false
+	}
+	m.songsSinceJingle++
+	if m.songsSinceJingle < every || m.upNextSongLocked() == nil {
+		return false
+	}
+	song := m.pickJingleLocked()
+	if song == nil {
+		// 下一次切歌时再试
+		return false
+	}
+	m.songsSinceJingle = 0
+	m.nextJingle = nil
+	m.startSongLocked(song, "", true)
+	log.Printf("Action: Playing jingle %s (%s)", song.ID, song.Title)
+	return true
+}
+
+// queueSongID 播放列表中标记当前位置的歌曲，正在播放台标时是台标之前的那首歌，调用方需持有锁
+func (m *Manager) queueSongID() string {
+	if !m.State.PlayingJingle {
+		return m.State.CurrentSongID
+	}
+	if idx := m.State.CurrentPlaylistIdx; idx >= 0 && idx < len(m.State.Playlist) {
+		return m.State.Playlist[idx].SongID
+	}
+	return ""
+}
+
+// SetSongJingle 标记歌曲是否为台标，并同步内存中的副本
+func (m *Manager) SetSongJingle(songID string, jingle bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.db.SetSongJingle(songID, jingle); err != nil {
+		return err
+	}
+	for i := range m.State.Playlist {
+		if song := m.State.Playlist[i].Song; song != nil && song.ID == songID {
+			song.Jingle = jingle
+		}
+	}
+	if m.State.CurrentSong != nil && m.State.CurrentSong.ID == songID {
+		m.State.CurrentSong.Jingle = jingle
+	}
+	// 重新挑选下一段台标
+	m.nextJingle = nil
+	log.Printf("Action: Song %s marked jingle=%v", songID, jingle)
+	m.broadcast()
+	return nil
+}
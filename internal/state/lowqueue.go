@@ -0,0 +1,267 @@
+package state
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// EventQueueLow 待播歌曲不多时广播，数据为 QueueSuggestions
+const EventQueueLow = "QUEUE_LOW"
+
+// 未配置时的推荐条件
+const (
+	defaultLowQueueMinutes     = 10
+	defaultLowQueueSuggestions = 5
+	// suggestRecentWindow 最近这段时间播放过的歌曲不推荐
+	suggestRecentWindow = 3 * time.Hour
+	// suggestSeedPlays 除了当前和待播的歌曲，再参考最近播放过的多少首
+	suggestSeedPlays = 10
+	// suggestBPMDelta 节奏相差不超过该值算相近
+	suggestBPMDelta = 8
+)
+
+// 推荐的理由，客户端据此显示说明
+const (
+	SuggestArtist = "artist"
+	SuggestGenre  = "genre"
+	SuggestMood   = "mood"
+	SuggestTempo  = "tempo"
+	// SuggestLibrary 与最近的歌曲都不相似，从曲库中随机补充
+	SuggestLibrary = "library"
+)
+
+// QueueSuggestions 一次推荐：RemainingMs 是推荐时剩余的待播时长，确认过的歌曲从 Songs 中移除
+type QueueSuggestions struct {
+	ID          string       `json:"id"`
+	RemainingMs int64        `json:"remainingMs"`
+	Songs       []Suggestion `json:"songs"`
+	CreatedAt   time.Time    `json:"createdAt"`
+}
+
+// Suggestion 推荐的一首歌及推荐理由
+type Suggestion struct {
+	SongID     string `json:"songId"`
+	Title      string `json:"title"`
+	Artist     string `json:"artist"`
+	DurationMs int    `json:"durationMs"`
+	Reason     string `json:"reason"`
+}
+
+// queuedMsLocked 当前歌曲剩余的时长加上之后所有可播放歌曲的时长，调用方需持有锁
+func (m *Manager) queuedMsLocked() int64 {
+	var total int64
+	if song := m.State.CurrentSong; song != nil {
+		total += max(0, songEndMs(song)-m.positionLocked())
+	}
+	for _, item := range m.State.Playlist[m.upcomingStart():] {
+		if m.isPlayable(item.Song) {
+			total += playableDurationMs(item.Song)
+		}
+	}
+	return int64(float64(total) / cmp.Or(m.State.PlaybackRate, 1))
+}
+
+// checkQueueLowLocked 待播时长低于配置的分钟数且没有未处理的推荐时推荐歌曲并广播 QUEUE_LOW；
+// 待播时长恢复后撤下推荐。不广播状态，调用方需持有锁
+func (m *Manager) checkQueueLowLocked() {
+	cfg := m.cfg.Queue.LowQueue
+	if cfg.Disabled || !m.active || m.State.MirroringFrom != "" || m.State.Maintenance != nil {
+		return
+	}
+	remaining := m.queuedMsLocked()
+	// 播放列表播完后还会从固定歌单取歌，不需要推荐
+	if remaining >= int64(cmp.Or(cfg.Minutes, defaultLowQueueMinutes))*60*1000 || m.pinnedHasSongs() {
+		m.State.Suggestions = nil
+		m.suggestionsDismissed = false
+		return
+	}
+	if m.State.Suggestions != nil || m.suggestionsDismissed {
+		return
+	}
+	songs, err := m.suggestSongsLocked(cmp.Or(cfg.Suggestions, defaultLowQueueSuggestions))
+	if err != nil {
+		log.Printf("Warning: failed to suggest songs: %v", err)
+		return
+	}
+	if len(songs) == 0 {
+		return
+	}
+	id, _ := uuid.NewV4()
+	m.State.Suggestions = &QueueSuggestions{ID: id.String(), RemainingMs: remaining, Songs: songs, CreatedAt: time.Now()}
+	log.Printf("Queue is low (%ds left), suggesting %d songs", remaining/1000, len(songs))
+	m.hub.BroadcastEvent(EventQueueLow, *m.State.Suggestions)
+}
+
+// suggestSongsLocked 按当前、待播和最近播放的歌曲推荐 n 首相似的歌：同一歌手优先，其次是流派、情绪和节奏，
+// 每位歌手最多一首；已在播放列表中、最近播放过或不能播放的歌曲不推荐，相似的不够时从曲库中随机补充
+// 调用方需持有锁
+func (m *Manager) suggestSongsLocked(n int) ([]Suggestion, error) {
+	seeds := make([]*db.Song, 0, len(m.State.Playlist)+suggestSeedPlays)
+	if m.State.CurrentSong != nil {
+		seeds = append(seeds, m.State.CurrentSong)
+	}
+	for _, item := range m.State.Playlist[m.upcomingStart():] {
+		if item.Song != nil {
+			seeds = append(seeds, item.Song)
+		}
+	}
+	plays, err := m.db.RecentPlays(0, suggestSeedPlays, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i := range plays {
+		seeds = append(seeds, &plays[i].Song)
+	}
+	recent, err := m.db.SongIDsPlayedSince(time.Now().Add(-suggestRecentWindow))
+	if err != nil {
+		return nil, err
+	}
+	excluded := make(map[string]bool, len(recent)+len(m.State.Playlist))
+	for _, id := range recent {
+		excluded[id] = true
+	}
+	for _, item := range m.State.Playlist {
+		excluded[item.SongID] = true
+	}
+	library, err := m.db.GetAllSongs()
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		song   *db.Song
+		score  int
+		reason string
+	}
+	var candidates []candidate
+	for i := range library {
+		song := &library[i]
+		// 推荐的歌曲要经听众确认加入，不推荐台标和按房间设置无法点播的歌曲
+		if excluded[song.ID] || song.Jingle || !m.isPlayable(song) || m.checkQueueRules(song, Actor{}) != nil {
+			continue
+		}
+		best := candidate{song: song, reason: SuggestLibrary}
+		for _, seed := range seeds {
+			if score, reason := similarity(seed, song); score > best.score {
+				best.score, best.reason = score, reason
+			}
+		}
+		candidates = append(candidates, best)
+	}
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+
+	suggestions := make([]Suggestion, 0, n)
+	artists := make(map[string]bool)
+	for _, c := range candidates {
+		if len(suggestions) == n {
+			break
+		}
+		artist := db.ArtistKey(c.song.PrimaryArtist())
+		if artists[artist] {
+			continue
+		}
+		artists[artist] = true
+		suggestions = append(suggestions, Suggestion{
+			SongID:     c.song.ID,
+			Title:      c.song.Title,
+			Artist:     c.song.Artist,
+			DurationMs: c.song.DurationMs,
+			Reason:     c.reason,
+		})
+	}
+	return suggestions, nil
+}
+
+// similarity 两首歌的相似程度及主要理由：同一歌手 3 分，流派、情绪相同各 2 分，节奏相近、调性和谐各 1 分
+func similarity(seed, song *db.Song) (int, string) {
+	score, reason, top := 0, "", 0
+	add := func(points int, r string) {
+		score += points
+		if points > top {
+			top, reason = points, r
+		}
+	}
+	if db.ArtistKey(seed.PrimaryArtist()) == db.ArtistKey(song.PrimaryArtist()) {
+		add(3, SuggestArtist)
+	}
+	if seed.Genre != "" && db.ArtistKey(seed.Genre) == db.ArtistKey(song.Genre) {
+		add(2, SuggestGenre)
+	}
+	if seed.Mood != "" && db.ArtistKey(seed.Mood) == db.ArtistKey(song.Mood) {
+		add(2, SuggestMood)
+	}
+	if seed.BPM > 0 && song.BPM > 0 && math.Abs(seed.BPM-song.BPM) <= suggestBPMDelta {
+		add(1, SuggestTempo)
+		if seed.MusicalKey != "" && db.KeysCompatible(seed.MusicalKey, song.MusicalKey) {
+			score++
+		}
+	}
+	if score == 0 {
+		return 0, SuggestLibrary
+	}
+	return score, reason
+}
+
+// ApproveSuggestion 听众确认一首推荐的歌曲，按正常点歌的规则加入播放列表，以确认的听众作为点歌人
+func (m *Manager) ApproveSuggestion(songID string, actor Actor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	suggestions := m.State.Suggestions
+	idx := -1
+	if suggestions != nil {
+		for i, s := range suggestions.Songs {
+			if s.SongID == songID {
+				idx = i
+				break
+			}
+		}
+	}
+	if idx == -1 {
+		return errors.New("song is not a current suggestion")
+	}
+	items, err := m.prepareItems([]string{songID}, actor)
+	if err != nil {
+		return err
+	}
+	suggestions.Songs = append(suggestions.Songs[:idx], suggestions.Songs[idx+1:]...)
+	if len(suggestions.Songs) == 0 {
+		m.State.Suggestions = nil
+	}
+	if len(items) == 0 {
+		// 已经有人把它加入了播放列表
+		m.broadcast()
+		return nil
+	}
+	wasEmpty := len(m.State.Playlist) == 0
+	insertAt := len(m.State.Playlist)
+	if m.State.QueueMode == QueueRoundRobin {
+		insertAt = m.fairInsertIdx(actor.Username)
+	}
+	m.insertItemAt(items[0], insertAt)
+	m.commitAddedItems(wasEmpty)
+	log.Printf("Action: Suggested song %s approved by %s", songID, actor.Username)
+	return nil
+}
+
+// DismissSuggestions 撤下当前的推荐，待播时长恢复后再次不足时才会重新推荐
+func (m *Manager) DismissSuggestions() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.State.Suggestions == nil {
+		return errors.New("no songs are being suggested")
+	}
+	m.State.Suggestions = nil
+	m.suggestionsDismissed = true
+	m.broadcast()
+	log.Printf("Action: Queue suggestions dismissed")
+	return nil
+}
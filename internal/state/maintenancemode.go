@@ -0,0 +1,94 @@
+package state
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+)
+
+// EventMaintenance 维护模式开启或关闭时广播，数据为 MaintenanceMode
+const EventMaintenance = "MAINTENANCE"
+
+// MaintenanceMode 维护模式：播放暂停，修改请求被拒绝，只读访问和 WebSocket 连接不受影响
+// 用于备份和迁移数据库；ResumePlayback 记录开启前是否在播放，关闭时据此恢复
+type MaintenanceMode struct {
+	Enabled        bool       `json:"enabled"`
+	Message        string     `json:"message,omitempty"`
+	StartedBy      string     `json:"startedBy,omitempty"`
+	StartedAt      *time.Time `json:"startedAt,omitempty"`
+	ResumePlayback bool       `json:"resumePlayback,omitempty"`
+}
+
+// SetMaintenanceMode 开启或关闭维护模式，已经开启时只更新说明
+func (m *Manager) SetMaintenanceMode(enabled bool, message string, actor Actor) MaintenanceMode {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	message = strings.TrimSpace(message)
+	current := m.State.Maintenance
+	switch {
+	case enabled && current != nil:
+		updated := *current
+		updated.Message = message
+		m.State.Maintenance = &updated
+	case enabled:
+		now := time.Now()
+		m.State.Maintenance = &MaintenanceMode{
+			Enabled:        true,
+			Message:        message,
+			StartedBy:      actor.Username,
+			StartedAt:      &now,
+			ResumePlayback: m.State.IsPlaying,
+		}
+		m.pauseLocked()
+		log.Printf("Action: Maintenance mode enabled by %s", actor.Username)
+	case current != nil:
+		m.State.Maintenance = nil
+		if current.ResumePlayback {
+			m.playLocked()
+		}
+		log.Printf("Action: Maintenance mode disabled by %s", actor.Username)
+	default:
+		return MaintenanceMode{}
+	}
+	m.saveMaintenanceMode()
+
+	status := MaintenanceMode{}
+	if m.State.Maintenance != nil {
+		status = *m.State.Maintenance
+	}
+	m.hub.BroadcastEvent(EventMaintenance, status)
+	m.broadcast()
+	return status
+}
+
+// InMaintenance 是否处于维护模式
+func (m *Manager) InMaintenance() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.State.Maintenance != nil
+}
+
+// saveMaintenanceMode 保存维护模式，重启后仍然生效，调用方需持有锁
+func (m *Manager) saveMaintenanceMode() {
+	if m.State.Maintenance == nil {
+		m.store.Set("maintenance_mode", "")
+		return
+	}
+	if data, err := json.Marshal(m.State.Maintenance); err == nil {
+		m.store.Set("maintenance_mode", string(data))
+	}
+}
+
+// loadMaintenanceMode 恢复保存的维护模式，调用方需持有锁
+func (m *Manager) loadMaintenanceMode() {
+	data, _ := m.store.Get("maintenance_mode")
+	if data == "" {
+		return
+	}
+	var saved MaintenanceMode
+	if err := json.Unmarshal([]byte(data), &saved); err != nil || !saved.Enabled {
+		return
+	}
+	m.State.Maintenance = &saved
+}
@@ -0,0 +1,94 @@
+package state
+
+import (
+	"log"
+	"time"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// StartMirror 进入镜像模式，之后由 Mirror 同步远程实例的播放状态
+// 镜像期间本地不会自动切歌，播放控制接口也应拒绝请求
+func (m *Manager) StartMirror(source string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// 本地正在播放的歌曲到此为止，之后播放的远程歌曲不记入收听记录
+	m.recordScrobbleLocked()
+	m.songStartedAt = time.Time{}
+	m.State.MirroringFrom = source
+	m.scheduleSongEnd()
+	log.Printf("Action: Mirroring playback from %s", source)
+	m.broadcast()
+}
+
+// StopMirror 退出镜像模式，正在播放的远程歌曲不在本地播放列表中时停止播放
+func (m *Manager) StopMirror() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.State.MirroringFrom == "" {
+		return
+	}
+	log.Printf("Action: Stopped mirroring %s", m.State.MirroringFrom)
+	m.State.MirroringFrom = ""
+	if m.State.CurrentSongID != "" && m.playlistIndex(m.State.CurrentSongID) == -1 {
+		m.stopPlayback()
+	} else {
+		m.scheduleSongEnd()
+	}
+	m.broadcast()
+}
+
+// Mirroring 返回正在镜像的远程实例地址，未镜像时为空字符串
+func (m *Manager) Mirroring() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.State.MirroringFrom
+}
+
+// Mirror 把远程实例的播放状态应用到本地
+// song 可以是本地曲库中匹配到的歌曲，也可以是带 StreamURL 的远程歌曲
+func (m *Manager) Mirror(song *db.Song, isPlaying bool, progressMs int64, rate float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.State.MirroringFrom == "" {
+		return
+	}
+	if song == nil {
+		if m.State.CurrentSongID != "" {
+			m.stopPlayback()
+			m.broadcast()
+		}
+		return
+	}
+	if song.ID != m.State.CurrentSongID {
+		m.State.CurrentSongID = song.ID
+		m.State.CurrentSong = song
+		m.State.PlayingJingle = false
+		// 不在本地播放列表中的歌曲索引为 -1
+		m.State.CurrentPlaylistIdx = m.playlistIndex(song.ID)
+		log.Printf("Mirror: now playing %s - %s", song.Artist, song.Title)
+	}
+	if rate >= MinPlaybackRate && rate <= MaxPlaybackRate {
+		m.State.PlaybackRate = rate
+	}
+	// 远程实例已经缓冲好，直接按远程的状态播放或暂停
+	if isPlaying {
+		m.setStatusLocked(Playing)
+		m.startProgressTicker()
+	} else {
+		m.setStatusLocked(Paused)
+		m.stopProgressTicker()
+	}
+	m.setPosition(progressMs)
+	m.broadcast()
+}
+
+// playlistIndex 返回歌曲在播放列表中的位置，不存在时返回 -1，调用方需持有锁
+func (m *Manager) playlistIndex(songID string) int {
+	for i, item := range m.State.Playlist {
+		if item.SongID == songID {
+			return i
+		}
+	}
+	return -1
+}
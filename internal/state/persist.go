@@ -0,0 +1,138 @@
+package state
+
+import (
+	"sort"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// orderGap 相邻播放列表项 item_order 的默认间隔
+const orderGap = 1024
+
+// persistPlaylist 将内存中的播放列表顺序以差异方式写入数据库并递增版本，调用方需持有锁
+// 只有新增、被删除以及相对顺序发生变化的行会被写入，移动一首歌通常只更新一行
+func (m *Manager) persistPlaylist() error {
+	assignOrders(m.State.Playlist)
+	m.State.PlaylistVersion++
+
+	var changes db.PlaylistChanges
+	var insertedIdx []int
+	present := make(map[int]bool, len(m.State.Playlist))
+	for i, item := range m.State.Playlist {
+		if item.ID == 0 {
+			changes.Inserts = append(changes.Inserts, &db.PlaylistItem{
+				SongID:  item.SongID,
+				Order:   item.Order,
+				AddedBy: item.AddedBy,
+			})
+			insertedIdx = append(insertedIdx, i)
+			continue
+		}
+		present[item.ID] = true
+		if order, ok := m.persistedOrders[item.ID]; !ok || order != item.Order {
+			changes.Updates = append(changes.Updates, db.PlaylistItem{ID: item.ID, Order: item.Order})
+		}
+	}
+	for id := range m.persistedOrders {
+		if !present[id] {
+			changes.Deletes = append(changes.Deletes, id)
+		}
+	}
+
+	if err := m.db.ApplyPlaylistChanges(changes); err != nil {
+		return err
+	}
+	// 回填新行的 ID
+	for k, row := range changes.Inserts {
+		m.State.Playlist[insertedIdx[k]].ID = row.ID
+		m.State.Playlist[insertedIdx[k]].CreatedAt = row.CreatedAt
+	}
+	m.snapshotPersistedOrders()
+	return nil
+}
+
+// snapshotPersistedOrders 记录当前已写入数据库的顺序，调用方需持有锁
+func (m *Manager) snapshotPersistedOrders() {
+	m.persistedOrders = make(map[int]int, len(m.State.Playlist))
+	for _, item := range m.State.Playlist {
+		m.persistedOrders[item.ID] = item.Order
+	}
+}
+
+// assignOrders 为顺序被打乱或新加入的项分配 Order：
+// 已有项中 Order 严格递增的最长子序列保持不变，其余项在相邻保留项之间均匀取值，
+// 没有空间时整体重新编号
+func assignOrders(items []db.PlaylistItem) {
+	keep := longestIncreasing(items)
+	for i := 0; i < len(items); {
+		if keep[i] {
+			i++
+			continue
+		}
+		j := i
+		for j < len(items) && !keep[j] {
+			j++
+		}
+		// items[i:j] 需要重新取值，i-1 和 j（如果存在）是保留项
+		count := j - i
+		var lo, hi int
+		switch {
+		case i > 0 && j < len(items):
+			lo, hi = items[i-1].Order, items[j].Order
+		case i > 0:
+			lo = items[i-1].Order
+			hi = lo + (count+1)*orderGap
+		case j < len(items):
+			hi = items[j].Order
+			lo = hi - (count+1)*orderGap
+		default:
+			lo, hi = 0, (count+1)*orderGap
+		}
+		step := (hi - lo) / (count + 1)
+		if step < 1 {
+			renumber(items)
+			return
+		}
+		for k := 0; k < count; k++ {
+			items[i+k].Order = lo + step*(k+1)
+		}
+		i = j
+	}
+}
+
+// renumber 按固定间隔重新编号全部项
+func renumber(items []db.PlaylistItem) {
+	for i := range items {
+		items[i].Order = (i + 1) * orderGap
+	}
+}
+
+// longestIncreasing 标记已持久化的项中 Order 严格递增的最长子序列
+func longestIncreasing(items []db.PlaylistItem) []bool {
+	keep := make([]bool, len(items))
+	prev := make([]int, len(items))
+	var tails []int // tails[k] 是长度为 k+1 的递增子序列末尾元素的下标
+	for i, item := range items {
+		if item.ID == 0 {
+			continue // 新项没有有效的 Order
+		}
+		pos := sort.Search(len(tails), func(k int) bool {
+			return items[tails[k]].Order >= item.Order
+		})
+		prev[i] = -1
+		if pos > 0 {
+			prev[i] = tails[pos-1]
+		}
+		if pos == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[pos] = i
+		}
+	}
+	if len(tails) > 0 {
+		for i := tails[len(tails)-1]; i != -1; i = prev[i] {
+			keep[i] = true
+		}
+	}
+	return keep
+}
@@ -0,0 +1,122 @@
+package state
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// PinnedPlaylist 固定的“今晚主题”歌单：播放列表中没有待播的歌曲时，按顺序从这里取歌接着播放
+// 歌曲在固定时确定，智能歌单在固定时按条件选好，之后修改歌单不影响已固定的内容
+type PinnedPlaylist struct {
+	ID      uint     `json:"id"`
+	Name    string   `json:"name"`
+	SongIDs []string `json:"songIds"`
+	// Position 下一首要取的歌曲在 SongIDs 中的位置，取完后按播放模式继续
+	Position int `json:"position"`
+}
+
+// PinPlaylist 固定一个命名歌单作为后备歌源，替换之前固定的歌单
+func (m *Manager) PinPlaylist(id uint, name string, songIDs []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.State.PinnedPlaylist = &PinnedPlaylist{ID: id, Name: name, SongIDs: append([]string{}, songIDs...)}
+	m.savePinnedPlaylist()
+	m.broadcast()
+	log.Printf("Action: Pinned playlist %d (%s) with %d song(s)", id, name, len(songIDs))
+}
+
+// UnpinPlaylist 取消固定的歌单
+func (m *Manager) UnpinPlaylist() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.State.PinnedPlaylist = nil
+	m.savePinnedPlaylist()
+	m.broadcast()
+	log.Println("Action: Unpinned playlist")
+}
+
+// pinnedApplies 是否应从固定歌单取歌：有固定的歌单，且当前歌曲之后没有可播放的歌曲，调用方需持有锁
+// 随机播放没有“之后”的概念，不从固定歌单取歌
+func (m *Manager) pinnedApplies() bool {
+	if m.State.PinnedPlaylist == nil || m.State.PlayMode == Shuffle {
+		return false
+	}
+	for _, item := range m.State.Playlist[m.upcomingStart():] {
+		if m.isPlayable(item.Song) {
+			return false
+		}
+	}
+	return true
+}
+
+// pinnedHasSongs 固定歌单是否还有没取的歌曲，有时不推荐歌曲，调用方需持有锁
+func (m *Manager) pinnedHasSongs() bool {
+	pinned := m.State.PinnedPlaylist
+	return pinned != nil && m.State.PlayMode != Shuffle && pinned.Position < len(pinned.SongIDs)
+}
+
+// pinnedNextSong 返回固定歌单中从 Position 起第一首可播放的歌曲及其位置，都不可播放时返回 nil，调用方需持有锁
+func (m *Manager) pinnedNextSong() (*db.Song, int) {
+	pinned := m.State.PinnedPlaylist
+	for pos := pinned.Position; pos < len(pinned.SongIDs); pos++ {
+		song, err := m.db.GetSong(pinned.SongIDs[pos])
+		if err != nil || !m.isPlayable(song) || m.checkQueueRules(song, Actor{}) != nil {
+			continue
+		}
+		return song, pos
+	}
+	return nil, len(pinned.SongIDs)
+}
+
+// pinnedNextIdx 需要时从固定歌单取下一首放到当前歌曲之后，返回其索引，不需要或已取完时返回 -1，调用方需持有锁
+func (m *Manager) pinnedNextIdx() int {
+	if !m.pinnedApplies() {
+		return -1
+	}
+	song, pos := m.pinnedNextSong()
+	m.State.PinnedPlaylist.Position = pos
+	if song == nil {
+		m.savePinnedPlaylist()
+		return -1
+	}
+	m.State.PinnedPlaylist.Position++
+	m.savePinnedPlaylist()
+	log.Printf("Action: Playing %s (%s) from pinned playlist %s", song.ID, song.Title, m.State.PinnedPlaylist.Name)
+	return m.insertAfterCurrent(db.PlaylistItem{SongID: song.ID, Song: song})
+}
+
+// pinnedPeekSong 返回 pinnedNextIdx 将取出的歌曲，不修改状态，调用方需持有锁
+func (m *Manager) pinnedPeekSong() *db.Song {
+	if !m.pinnedApplies() {
+		return nil
+	}
+	song, _ := m.pinnedNextSong()
+	return song
+}
+
+// savePinnedPlaylist 持久化固定的歌单及取歌的位置，调用方需持有锁
+func (m *Manager) savePinnedPlaylist() {
+	if m.State.PinnedPlaylist == nil {
+		m.store.Set("pinned_playlist", "")
+		return
+	}
+	if data, err := json.Marshal(m.State.PinnedPlaylist); err == nil {
+		m.store.Set("pinned_playlist", string(data))
+	}
+}
+
+// loadPinnedPlaylist 恢复固定的歌单，调用方需持有锁
+func (m *Manager) loadPinnedPlaylist() {
+	data, _ := m.store.Get("pinned_playlist")
+	if data == "" {
+		return
+	}
+	var pinned PinnedPlaylist
+	if err := json.Unmarshal([]byte(data), &pinned); err != nil {
+		log.Printf("Warning: failed to load pinned playlist: %v", err)
+		return
+	}
+	m.State.PinnedPlaylist = &pinned
+}
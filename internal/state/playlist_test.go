@@ -0,0 +1,204 @@
+package state
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/yeeeck/sync-jukebox/internal/config"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/store"
+	"github.com/yeeeck/sync-jukebox/internal/websocket"
+)
+
+// fakeBackend 代替 Redis 接收 Hub 发布的广播，只统计完整状态帧的数量
+type fakeBackend struct {
+	mu     sync.Mutex
+	states int
+}
+
+func (b *fakeBackend) PublishBroadcast(data []byte, state bool) error {
+	if state {
+		b.mu.Lock()
+		b.states++
+		b.mu.Unlock()
+	}
+	return nil
+}
+
+func (b *fakeBackend) PublishInbound(websocket.InboundMessage) error { return nil }
+
+func (b *fakeBackend) Run(ctx context.Context, _ func([]byte, bool), _ func(websocket.InboundMessage)) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (b *fakeBackend) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.states = 0
+}
+
+func (b *fakeBackend) stateBroadcasts() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.states
+}
+
+var testAdmin = Actor{Username: "admin", IsAdmin: true}
+
+// newTestManager 用临时 SQLite 数据库创建 Manager，songIDs 依次加入播放列表
+func newTestManager(t *testing.T, songIDs ...string) (*Manager, *db.DB, *fakeBackend) {
+	t.Helper()
+	database, err := db.New(filepath.Join(t.TempDir(), "jukebox.db"))
+	if err != nil {
+		t.Fatalf("db.New: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	for _, id := range songIDs {
+		song := &db.Song{ID: id, Title: id, Artist: "Artist", DurationMs: 180000, FilePath: id + ".mp3"}
+		if err := database.AddSong(song); err != nil {
+			t.Fatalf("AddSong(%s): %v", id, err)
+		}
+	}
+
+	backend := &fakeBackend{}
+	hub := websocket.NewHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	hub.UseBackend(ctx, backend)
+
+	m, err := NewManager(database, hub, config.Default(), nil, store.NewSQLite(database))
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	// 在关闭数据库之前停止定时器
+	t.Cleanup(func() { m.SetActive(false) })
+	if _, err := m.AddManyToPlaylist(songIDs, testAdmin); err != nil {
+		t.Fatalf("AddManyToPlaylist: %v", err)
+	}
+	return m, database, backend
+}
+
+func persistedSongIDs(t *testing.T, database *db.DB) []string {
+	t.Helper()
+	items, err := database.GetPlaylistItems()
+	if err != nil {
+		t.Fatalf("GetPlaylistItems: %v", err)
+	}
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.SongID)
+	}
+	return ids
+}
+
+func TestRemoveFromPlaylist(t *testing.T) {
+	tests := []struct {
+		name     string
+		playlist []string
+		current  string
+		remove   string
+		wantIdx  int
+		wantSong string
+		wantRows []string
+	}{
+		{
+			name:     "remove current",
+			playlist: []string{"a", "b", "c"},
+			current:  "b",
+			remove:   "b",
+			wantIdx:  1,
+			wantSong: "c",
+			wantRows: []string{"a", "c"},
+		},
+		{
+			name:     "remove before current",
+			playlist: []string{"a", "b", "c"},
+			current:  "b",
+			remove:   "a",
+			wantIdx:  0,
+			wantSong: "b",
+			wantRows: []string{"b", "c"},
+		},
+		{
+			name:     "remove after current",
+			playlist: []string{"a", "b", "c"},
+			current:  "b",
+			remove:   "c",
+			wantIdx:  1,
+			wantSong: "b",
+			wantRows: []string{"a", "b"},
+		},
+		{
+			// 最后一首是当前歌曲时按列表循环回到第一首
+			name:     "remove last while current",
+			playlist: []string{"a", "b", "c"},
+			current:  "c",
+			remove:   "c",
+			wantIdx:  0,
+			wantSong: "a",
+			wantRows: []string{"a", "b"},
+		},
+		{
+			// 移除最后剩下的歌曲后停止播放
+			name:     "remove last remaining song",
+			playlist: []string{"a"},
+			current:  "a",
+			remove:   "a",
+			wantIdx:  -1,
+			wantSong: "",
+			wantRows: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, database, backend := newTestManager(t, tt.playlist...)
+			if err := m.PlaySpecificSong(tt.current, testAdmin); err != nil {
+				t.Fatalf("PlaySpecificSong: %v", err)
+			}
+			backend.reset()
+
+			if err := m.RemoveFromPlaylist(tt.remove, testAdmin); err != nil {
+				t.Fatalf("RemoveFromPlaylist: %v", err)
+			}
+
+			m.mu.RLock()
+			idx, songID, status := m.State.CurrentPlaylistIdx, m.State.CurrentSongID, m.State.Status
+			m.mu.RUnlock()
+			if tt.wantSong == "" {
+				if songID != "" || status != Stopped {
+					t.Errorf("current = %q (%s), want stopped", songID, status)
+				}
+			} else if idx != tt.wantIdx || songID != tt.wantSong {
+				t.Errorf("current = %d %q, want %d %q", idx, songID, tt.wantIdx, tt.wantSong)
+			}
+			if rows := persistedSongIDs(t, database); !slices.Equal(rows, tt.wantRows) {
+				t.Errorf("persisted playlist = %v, want %v", rows, tt.wantRows)
+			}
+			if n := backend.stateBroadcasts(); n != 1 {
+				t.Errorf("state broadcasts = %d, want 1", n)
+			}
+		})
+	}
+}
+
+func TestRemoveFromPlaylistNotInPlaylist(t *testing.T) {
+	m, database, backend := newTestManager(t, "a", "b")
+	if err := m.PlaySpecificSong("a", testAdmin); err != nil {
+		t.Fatalf("PlaySpecificSong: %v", err)
+	}
+	backend.reset()
+
+	if err := m.RemoveFromPlaylist("missing", testAdmin); err != nil {
+		t.Fatalf("RemoveFromPlaylist: %v", err)
+	}
+	if rows := persistedSongIDs(t, database); !slices.Equal(rows, []string{"a", "b"}) {
+		t.Errorf("persisted playlist = %v, want [a b]", rows)
+	}
+	if n := backend.stateBroadcasts(); n != 0 {
+		t.Errorf("state broadcasts = %d, want 0", n)
+	}
+}
@@ -0,0 +1,63 @@
+package state
+
+import (
+	"fmt"
+	"log"
+)
+
+func validPlayMode(mode PlayMode) bool {
+	switch mode {
+	case RepeatAll, RepeatOne, Shuffle, AutoDJ:
+		return true
+	}
+	return false
+}
+
+// SetPlayMode 切换播放模式，影响歌曲结束后自动切到哪一首
+func (m *Manager) SetPlayMode(mode PlayMode) error {
+	if !validPlayMode(mode) {
+		return fmt.Errorf("unknown play mode %q", mode)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.State.PlayMode = mode
+	m.store.Set("play_mode", string(mode))
+	m.broadcast()
+	log.Printf("Action: Play mode set to %s", mode)
+	return nil
+}
+
+// repeatCurrent 单曲循环时当前歌曲结束后从头重播，没有重播时返回 false；手动切歌不受影响，调用方需持有锁
+func (m *Manager) repeatCurrent() bool {
+	if m.State.PlayMode != RepeatOne || !m.isPlayable(m.State.CurrentSong) {
+		return false
+	}
+	idx := m.playlistIndex(m.State.CurrentSongID)
+	if idx == -1 {
+		return false
+	}
+	m.changeSong(idx)
+	return true
+}
+
+// modeNextIdx 按播放模式选出下一首的索引，没有可播放的歌曲时返回 -1，调用方需持有锁
+func (m *Manager) modeNextIdx() int {
+	switch m.State.PlayMode {
+	case Shuffle:
+		return m.shuffleNextIdx()
+	case AutoDJ:
+		return m.autoDJNextIdx()
+	}
+	return m.nextPlayableIdx(m.State.CurrentPlaylistIdx, 1)
+}
+
+// modePeekIdx 预测 modeNextIdx 将选出的歌曲当前的索引，不移动歌曲、不推进随机播放的轮次，调用方需持有锁
+func (m *Manager) modePeekIdx() int {
+	switch m.State.PlayMode {
+	case Shuffle:
+		return m.shufflePeekIdx()
+	case AutoDJ:
+		return m.autoDJPickIdx()
+	}
+	return m.nextPlayableIdx(m.State.CurrentPlaylistIdx, 1)
+}
@@ -0,0 +1,209 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// 投票的候选数量与时长限制
+const (
+	MinPollCandidates   = 2
+	MaxPollCandidates   = 4
+	DefaultPollDuration = 60 * time.Second
+	MinPollDuration     = 10 * time.Second
+	MaxPollDuration     = 10 * time.Minute
+)
+
+// Poll 是“下一首放什么”的投票，胜出的歌曲会在当前歌曲结束时自动插入播放
+type Poll struct {
+	ID           string          `json:"id"`
+	Candidates   []PollCandidate `json:"candidates"`
+	CreatedBy    string          `json:"createdBy"`
+	EndsAt       time.Time       `json:"endsAt"`
+	RemainingSec int             `json:"remainingSec"` // 倒计时，每秒广播一次
+	Closed       bool            `json:"closed"`
+	WinnerID     string          `json:"winnerId,omitempty"`
+
+	votes map[string]string // 用户名 -> 所投的 songID
+}
+
+// PollCandidate 候选歌曲及当前票数
+type PollCandidate struct {
+	SongID string `json:"songId"`
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+	Votes  int    `json:"votes"`
+}
+
+// StartPoll 由 DJ 发起投票
+func (m *Manager) StartPoll(songIDs []string, duration time.Duration, actor Actor) error {
+	if len(songIDs) < MinPollCandidates || len(songIDs) > MaxPollCandidates {
+		return fmt.Errorf("a poll needs %d to %d candidates", MinPollCandidates, MaxPollCandidates)
+	}
+	if duration == 0 {
+		duration = DefaultPollDuration
+	}
+	if duration < MinPollDuration || duration > MaxPollDuration {
+		return fmt.Errorf("poll duration must be between %v and %v", MinPollDuration, MaxPollDuration)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.State.Poll != nil && !m.State.Poll.Closed {
+		return errors.New("a poll is already running")
+	}
+
+	seen := make(map[string]bool)
+	candidates := make([]PollCandidate, 0, len(songIDs))
+	for _, songID := range songIDs {
+		if seen[songID] {
+			return errors.New("duplicate candidate")
+		}
+		seen[songID] = true
+		song, err := m.db.GetSong(songID)
+		if err != nil {
+			return fmt.Errorf("song %s not found", songID)
+		}
+		if err := m.checkContent(song); err != nil {
+			return err
+		}
+		candidates = append(candidates, PollCandidate{SongID: song.ID, Title: song.Title, Artist: song.Artist})
+	}
+
+	pollID, _ := uuid.NewV4()
+	poll := &Poll{
+		ID:           pollID.String(),
+		Candidates:   candidates,
+		CreatedBy:    actor.Username,
+		EndsAt:       time.Now().Add(duration),
+		RemainingSec: int(duration.Seconds()),
+		votes:        make(map[string]string),
+	}
+	m.State.Poll = poll
+	go m.runPollCountdown(poll)
+
+	m.broadcast()
+	log.Printf("Action: Poll %s started by %s with %d candidates", poll.ID, actor.Username, len(candidates))
+	return nil
+}
+
+// Vote 为当前投票中的候选歌曲投票，重复投票会改投
+func (m *Manager) Vote(username, songID string) error {
+	if username == "" {
+		return errors.New("login required to vote")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	poll := m.State.Poll
+	if poll == nil || poll.Closed {
+		return errors.New("no poll is running")
+	}
+	target := -1
+	for i, c := range poll.Candidates {
+		if c.SongID == songID {
+			target = i
+			break
+		}
+	}
+	if target == -1 {
+		return errors.New("song is not a candidate in this poll")
+	}
+	previous := poll.votes[username]
+	if previous == songID {
+		return nil
+	}
+	for i, c := range poll.Candidates {
+		if c.SongID == previous {
+			poll.Candidates[i].Votes--
+		}
+	}
+	poll.Candidates[target].Votes++
+	poll.votes[username] = songID
+
+	m.broadcast()
+	return nil
+}
+
+// CancelPoll 取消当前投票
+func (m *Manager) CancelPoll() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.State.Poll == nil {
+		return errors.New("no poll is running")
+	}
+	log.Printf("Action: Poll %s cancelled", m.State.Poll.ID)
+	m.State.Poll = nil
+	m.broadcast()
+	return nil
+}
+
+// runPollCountdown 每秒广播一次倒计时，到期后结束投票
+// 投票被取消或替换后自行退出
+func (m *Manager) runPollCountdown(poll *Poll) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.mu.Lock()
+		if m.State.Poll != poll || poll.Closed {
+			m.mu.Unlock()
+			return
+		}
+		remaining := time.Until(poll.EndsAt)
+		if remaining <= 0 {
+			m.closePoll(poll)
+		} else {
+			poll.RemainingSec = int(math.Ceil(remaining.Seconds()))
+		}
+		m.broadcast()
+		closed := poll.Closed
+		m.mu.Unlock()
+		if closed {
+			return
+		}
+	}
+}
+
+// closePoll 结算投票，票数相同时先提名的候选胜出，调用方需持有锁
+func (m *Manager) closePoll(poll *Poll) {
+	poll.Closed = true
+	poll.RemainingSec = 0
+	best := 0
+	for _, c := range poll.Candidates {
+		if c.Votes > best {
+			best = c.Votes
+			poll.WinnerID = c.SongID
+		}
+	}
+	log.Printf("Action: Poll %s closed, winner: %q", poll.ID, poll.WinnerID)
+	// 当前没有在放的歌曲时，不必等待，直接播放胜出的歌曲
+	if m.State.CurrentSong == nil {
+		m.playPollWinner()
+	}
+}
+
+// playPollWinner 如果有已结束的投票，把胜出歌曲插到当前歌曲之后并播放
+// 返回是否已切歌，调用方需持有锁
+func (m *Manager) playPollWinner() bool {
+	poll := m.State.Poll
+	if poll == nil || !poll.Closed {
+		return false
+	}
+	m.State.Poll = nil
+	if poll.WinnerID == "" {
+		return false
+	}
+	song, err := m.db.GetSong(poll.WinnerID)
+	if err != nil || !m.isPlayable(song) {
+		log.Printf("Warning: poll winner %s can no longer be played", poll.WinnerID)
+		return false
+	}
+	idx := m.insertAfterCurrent(db.PlaylistItem{SongID: song.ID, AddedBy: poll.CreatedBy, Song: song})
+	m.changeSong(idx)
+	return true
+}
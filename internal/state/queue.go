@@ -0,0 +1,98 @@
+package state
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// QueueMode 定义点歌的排队方式
+type QueueMode string
+
+const (
+	// QueueFIFO 按点歌先后顺序排队
+	QueueFIFO QueueMode = "FIFO"
+	// QueueRoundRobin 派对模式：按用户轮流排队，避免一个人点的歌霸占后面几个小时
+	QueueRoundRobin QueueMode = "ROUND_ROBIN"
+)
+
+// SetQueueMode 切换排队方式，切换到轮流模式时会重新排列尚未播放的歌曲
+func (m *Manager) SetQueueMode(mode QueueMode) error {
+	if mode != QueueFIFO && mode != QueueRoundRobin {
+		return fmt.Errorf("unknown queue mode %q", mode)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setQueueModeLocked(mode)
+	m.broadcast()
+	return nil
+}
+
+// setQueueModeLocked 切换排队方式并持久化，不广播，调用方需持有锁
+func (m *Manager) setQueueModeLocked(mode QueueMode) {
+	m.State.QueueMode = mode
+	m.State.Settings.QueueMode = mode
+	m.store.Set("queue_mode", string(mode))
+
+	if mode == QueueRoundRobin {
+		start := m.upcomingStart()
+		upcoming := interleaveByUser(m.State.Playlist[start:])
+		copy(m.State.Playlist[start:], upcoming)
+		if err := m.persistPlaylist(); err != nil {
+			log.Printf("Error updating playlist order in DB: %v", err)
+		}
+	}
+	log.Printf("Action: Queue mode set to %s", mode)
+}
+
+// upcomingStart 返回第一首尚未播放的歌曲的索引，调用方需持有锁
+func (m *Manager) upcomingStart() int {
+	if m.State.CurrentSongID == "" {
+		return 0
+	}
+	return m.State.CurrentPlaylistIdx + 1
+}
+
+// fairInsertIdx 计算轮流模式下新点的歌应插入的位置：
+// 某用户的第 k 首待播歌曲排在第 k 轮的末尾，已有歌曲的相对顺序保持不变
+func (m *Manager) fairInsertIdx(addedBy string) int {
+	start := m.upcomingStart()
+	counts := make(map[string]int)
+	for _, item := range m.State.Playlist[start:] {
+		counts[item.AddedBy]++
+	}
+	round := counts[addedBy]
+
+	insertAt := start
+	seen := make(map[string]int)
+	for i := start; i < len(m.State.Playlist); i++ {
+		user := m.State.Playlist[i].AddedBy
+		if seen[user] <= round {
+			insertAt = i + 1
+		}
+		seen[user]++
+	}
+	return insertAt
+}
+
+// interleaveByUser 按用户轮流重新排列，用户顺序按其第一首歌出现的先后决定
+func interleaveByUser(items []db.PlaylistItem) []db.PlaylistItem {
+	var users []string
+	byUser := make(map[string][]db.PlaylistItem)
+	for _, item := range items {
+		if _, ok := byUser[item.AddedBy]; !ok {
+			users = append(users, item.AddedBy)
+		}
+		byUser[item.AddedBy] = append(byUser[item.AddedBy], item)
+	}
+	result := make([]db.PlaylistItem, 0, len(items))
+	for round := 0; len(result) < len(items); round++ {
+		for _, user := range users {
+			if round < len(byUser[user]) {
+				result = append(result, byUser[user][round])
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,121 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// 规则违例代码，返回给前端用于展示具体原因
+const (
+	CodeSongCooldown    = "SONG_COOLDOWN"
+	CodeExplicitContent = "EXPLICIT_CONTENT"
+	CodeBlocked         = "BLOCKED"
+	// CodeSongTooLong 歌曲超过房间设置的最大时长
+	CodeSongTooLong = "SONG_TOO_LONG"
+	// CodeSourceNotAllowed 歌曲来源不在房间设置允许的来源中
+	CodeSourceNotAllowed = "SOURCE_NOT_ALLOWED"
+)
+
+// RuleViolation 表示点歌或播放请求违反了某条房间规则
+type RuleViolation struct {
+	Code    string
+	Message string
+}
+
+func (e *RuleViolation) Error() string {
+	return e.Message
+}
+
+// Actor 描述发起操作的用户，用于规则校验
+type Actor struct {
+	Username string
+	IsAdmin  bool
+	// TrySkip 操作会提前结束当前歌曲时调用，消耗一次切歌额度，返回 false 表示额度已用完；为 nil 时不限制
+	TrySkip func() bool
+}
+
+// ErrSkipLimit 操作会提前结束当前歌曲，但用户的切歌额度已用完
+var ErrSkipLimit = errors.New("skip limit reached, try again later")
+
+// beginSkipLocked 操作将提前结束当前歌曲时消耗 actor 的切歌额度，并记下切歌的用户写入收听记录
+// 没有正在播放的歌曲时什么也不做；调用方需持有锁，并在操作结束后清除 m.skipping
+func (m *Manager) beginSkipLocked(actor Actor, reason string) error {
+	if m.State.CurrentSongID == "" {
+		return nil
+	}
+	if actor.TrySkip != nil && !actor.TrySkip() {
+		return ErrSkipLimit
+	}
+	m.skipping = &skipRecord{by: actor.Username, reason: reason}
+	return nil
+}
+
+// checkCooldown 检查歌曲是否仍处于冷却期内，管理员不受限制
+func (m *Manager) checkCooldown(songID string, actor Actor) error {
+	minutes := m.cfg.Queue.SongCooldownMinutes
+	if minutes <= 0 || actor.IsAdmin {
+		return nil
+	}
+	lastPlayed, err := m.db.LastPlayedAt(songID)
+	if err != nil || lastPlayed.IsZero() {
+		return nil
+	}
+	remaining := time.Until(lastPlayed.Add(time.Duration(minutes) * time.Minute))
+	if remaining <= 0 {
+		return nil
+	}
+	return &RuleViolation{
+		Code:    CodeSongCooldown,
+		Message: fmt.Sprintf("song was played recently, it can be requested again in %d min", int(remaining.Minutes())+1),
+	}
+}
+
+// checkQueueRules 按房间设置检查歌曲时长和来源，管理员不受限制
+// 时长未知的歌曲不受时长限制，来源为空的旧歌曲按本地上传处理
+func (m *Manager) checkQueueRules(song *db.Song, actor Actor) error {
+	if actor.IsAdmin {
+		return nil
+	}
+	settings := m.State.Settings
+	if limit := settings.MaxSongMinutes; limit > 0 && song.DurationMs > limit*60*1000 {
+		return &RuleViolation{
+			Code:    CodeSongTooLong,
+			Message: fmt.Sprintf("songs longer than %d min cannot be requested", limit),
+		}
+	}
+	if len(settings.AllowedSources) > 0 {
+		source := song.Source
+		if source == "" {
+			source = db.SourceLocal
+		}
+		if !slices.Contains(settings.AllowedSources, source) {
+			return &RuleViolation{
+				Code:    CodeSourceNotAllowed,
+				Message: fmt.Sprintf("songs from %s cannot be requested right now", source),
+			}
+		}
+	}
+	return nil
+}
+
+// checkContent 拒绝家庭模式下的露骨内容以及黑名单中的歌曲
+func (m *Manager) checkContent(song *db.Song) error {
+	if m.State.FamilyMode && song != nil && song.Explicit {
+		return &RuleViolation{
+			Code:    CodeExplicitContent,
+			Message: "explicit songs are not allowed while family mode is on",
+		}
+	}
+	if entry := m.blockedBy(song); entry != nil {
+		msg := "this song is blocked"
+		if entry.Reason != "" {
+			msg += ": " + entry.Reason
+		}
+		return &RuleViolation{Code: CodeBlocked, Message: msg}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package state
+
+import (
+	"log"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/policy"
+)
+
+// CodePolicyRejected 点歌被运维脚本拒绝
+const CodePolicyRejected = "POLICY_REJECTED"
+
+// policyHistorySize 传给脚本的最近播放记录条数
+const policyHistorySize = 20
+
+// policyContext 构造脚本可见的房间状态，调用方需持有锁
+func (m *Manager) policyContext(actor Actor) policy.Context {
+	ctx := policy.Context{User: actor.Username, IsAdmin: actor.IsAdmin}
+	for _, item := range m.State.Playlist[m.upcomingStart():] {
+		ctx.Queue = append(ctx.Queue, policy.Entry{Song: item.Song, AddedBy: item.AddedBy})
+	}
+	plays, err := m.db.RecentPlays(0, policyHistorySize, 0)
+	if err != nil {
+		log.Printf("Warning: failed to load play history for policy script: %v", err)
+	}
+	for i := range plays {
+		ctx.History = append(ctx.History, policy.Entry{Song: &plays[i].Song, AddedBy: plays[i].RequestedBy})
+	}
+	return ctx
+}
+
+// checkPolicy 让脚本决定是否接受点歌，脚本出错时放行，调用方需持有锁
+func (m *Manager) checkPolicy(song *db.Song, actor Actor) error {
+	if m.policy == nil {
+		return nil
+	}
+	allowed, reason, err := m.policy.Admit(song, m.policyContext(actor))
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return nil
+	}
+	if allowed {
+		return nil
+	}
+	msg := "this song is not allowed by the room policy"
+	if reason != "" {
+		msg += ": " + reason
+	}
+	return &RuleViolation{Code: CodePolicyRejected, Message: msg}
+}
+
+// policyNextIdx 让脚本从之后可播放的歌曲中选出下一首，
+// 返回 -1 表示使用默认顺序，调用方需持有锁
+func (m *Manager) policyNextIdx() int {
+	if m.policy == nil || len(m.State.Playlist) == 0 {
+		return -1
+	}
+	var candidates []policy.Entry
+	var indexes []int
+	n := len(m.State.Playlist)
+	for i := 1; i <= n; i++ {
+		idx := ((m.State.CurrentPlaylistIdx+i)%n + n) % n
+		item := m.State.Playlist[idx]
+		if m.isPlayable(item.Song) {
+			candidates = append(candidates, policy.Entry{Song: item.Song, AddedBy: item.AddedBy})
+			indexes = append(indexes, idx)
+		}
+	}
+	picked, err := m.policy.PickNext(candidates, m.policyContext(Actor{}))
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return -1
+	}
+	if picked == -1 {
+		return -1
+	}
+	return indexes[picked]
+}
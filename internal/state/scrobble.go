@@ -0,0 +1,41 @@
+package state
+
+import (
+	"log"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// skipRecord 正在进行的手动切歌，写入被切走歌曲的收听记录
+type skipRecord struct {
+	by     string
+	reason string
+}
+
+// recordScrobbleLocked 当前歌曲结束、被跳过或停止时写入一条收听记录，调用方需持有锁
+// 跟随远程实例时的播放由远程实例记录（镜像的歌曲没有 songStartedAt），从实例不记录，避免重复；台标不记录
+func (m *Manager) recordScrobbleLocked() {
+	song := m.State.CurrentSong
+	if song == nil || m.songStartedAt.IsZero() || !m.active || m.State.MirroringFrom != "" || m.State.PlayingJingle {
+		return
+	}
+	scrobble := &db.Scrobble{
+		SongID:      song.ID,
+		Title:       song.Title,
+		Artist:      song.Artist,
+		Album:       song.Album,
+		AlbumArtist: song.AlbumArtist,
+		DurationMs:  song.DurationMs,
+		ListenedMs:  m.positionLocked(),
+		RequestedBy: m.songRequestedBy,
+		PlayedAt:    m.songStartedAt,
+	}
+	if skip := m.skipping; skip != nil {
+		scrobble.Skipped = true
+		scrobble.SkippedBy = skip.by
+		scrobble.SkipReason = skip.reason
+	}
+	if err := m.db.AddScrobble(scrobble); err != nil {
+		log.Printf("Warning: failed to record scrobble: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package state
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// seekCoalesceWindow 拖动进度条时连续的跳转在这段时间内合并，只应用最后一次，只广播一次
+	seekCoalesceWindow = 150 * time.Millisecond
+	// scrubTimeout 拖动中的客户端超过这段时间没有再发送跳转时按最后的位置结束拖动，例如页面被关闭
+	scrubTimeout = 3 * time.Second
+)
+
+// Scrub 有人正在拖动进度条，其他客户端据此显示预览位置，松开后才真正跳转
+type Scrub struct {
+	By         string    `json:"by"`
+	PositionMs int64     `json:"positionMs"`
+	StartedAt  time.Time `json:"startedAt"`
+}
+
+// pendingSeek 等待合并窗口结束后应用的跳转
+type pendingSeek struct {
+	songID     string
+	positionMs int64
+}
+
+// RequestSeek 处理客户端的跳转请求：scrubbing 为 true 表示仍在拖动，只更新预览位置；
+// 为 false 时跳转到 positionMs 并结束拖动。两种请求都在 seekCoalesceWindow 内合并
+func (m *Manager) RequestSeek(positionMs int64, scrubbing bool, username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.State.CurrentSong == nil {
+		return fmt.Errorf("no song is currently playing")
+	}
+	positionMs = m.clampPositionLocked(positionMs)
+	if scrubbing {
+		if m.State.Scrubbing == nil || m.State.Scrubbing.By != username {
+			m.State.Scrubbing = &Scrub{By: username, StartedAt: time.Now()}
+		}
+		m.State.Scrubbing.PositionMs = positionMs
+		m.resetScrubTimer()
+	} else {
+		m.stopScrubTimer()
+		m.State.Scrubbing = nil
+		m.pendingSeek = &pendingSeek{songID: m.State.CurrentSongID, positionMs: positionMs}
+	}
+	if m.seekTimer == nil {
+		m.seekTimer = time.AfterFunc(seekCoalesceWindow, m.flushSeek)
+	}
+	return nil
+}
+
+// flushSeek 合并窗口结束，应用最后一次跳转或广播拖动的预览位置
+func (m *Manager) flushSeek() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seekTimer = nil
+	seek := m.pendingSeek
+	m.pendingSeek = nil
+	// 期间切了歌的跳转作废
+	if seek != nil && seek.songID == m.State.CurrentSongID && m.State.CurrentSong != nil {
+		m.seekLocked(seek.positionMs)
+		log.Printf("Action: Seek to %dms", seek.positionMs)
+		return
+	}
+	m.broadcast()
+}
+
+// onScrubTimeout 拖动的客户端没有发送松开的请求，按最后的预览位置跳转
+func (m *Manager) onScrubTimeout(scrub *Scrub) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.State.Scrubbing != scrub {
+		return
+	}
+	m.scrubTimer = nil
+	m.State.Scrubbing = nil
+	m.seekLocked(scrub.PositionMs)
+	log.Printf("Action: Scrub by %s timed out, seek to %dms", scrub.By, scrub.PositionMs)
+}
+
+// resetScrubTimer 重新开始拖动超时计时，调用方需持有锁
+func (m *Manager) resetScrubTimer() {
+	m.stopScrubTimer()
+	scrub := m.State.Scrubbing
+	m.scrubTimer = time.AfterFunc(scrubTimeout, func() {
+		m.onScrubTimeout(scrub)
+	})
+}
+
+func (m *Manager) stopScrubTimer() {
+	if m.scrubTimer != nil {
+		m.scrubTimer.Stop()
+		m.scrubTimer = nil
+	}
+}
+
+// cancelSeekLocked 切歌或停止时丢弃等待中的跳转和拖动，调用方需持有锁
+func (m *Manager) cancelSeekLocked() {
+	m.pendingSeek = nil
+	m.stopScrubTimer()
+	m.State.Scrubbing = nil
+}
+
+// clampPositionLocked 把跳转位置限制在当前歌曲的时长之内，调用方需持有锁
+func (m *Manager) clampPositionLocked(positionMs int64) int64 {
+	if positionMs < 0 {
+		positionMs = 0
+	}
+	// 时长未知时不限制上限
+	if m.State.CurrentSong.DurationMs > 0 && positionMs > int64(m.State.CurrentSong.DurationMs) {
+		positionMs = int64(m.State.CurrentSong.DurationMs)
+	}
+	return positionMs
+}
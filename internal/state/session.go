@@ -0,0 +1,52 @@
+package state
+
+import (
+	"errors"
+	"log"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// ErrNoSession 没有进行中的派对
+var ErrNoSession = errors.New("no party session is running")
+
+// StartSession 开始一场派对，之后的播放历史归属于它，直到 EndSession
+func (m *Manager) StartSession(name, startedBy string) (*db.PartySession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, err := m.db.StartPartySession(name, startedBy)
+	if err != nil {
+		return nil, err
+	}
+	m.State.Session = session
+	m.broadcast()
+	log.Printf("Action: Party session %d (%q) started by %s", session.ID, name, startedBy)
+	return session, nil
+}
+
+// EndSession 结束进行中的派对，返回带回顾的派对记录
+func (m *Manager) EndSession() (*db.PartySession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.State.Session == nil {
+		return nil, ErrNoSession
+	}
+	// 旧快照可能还引用着当前的派对，修改副本
+	ended := *m.State.Session
+	if err := m.db.EndPartySession(&ended); err != nil {
+		return nil, err
+	}
+	m.State.Session = nil
+	m.broadcast()
+	log.Printf("Action: Party session %d ended, %d plays", ended.ID, ended.Recap.Plays)
+	return &ended, nil
+}
+
+// sessionIDLocked 返回进行中派对的 ID，用于记录播放历史，调用方需持有锁
+func (m *Manager) sessionIDLocked() *uint {
+	if m.State.Session == nil {
+		return nil
+	}
+	id := m.State.Session.ID
+	return &id
+}
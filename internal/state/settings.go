@@ -0,0 +1,126 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// 设置的取值范围
+const (
+	MaxCrossfadeMs = 12000
+	MaxFadeMs      = 5000
+	// MaxSongMinutesLimit 歌曲时长上限最多设置为一天
+	MaxSongMinutesLimit = 24 * 60
+)
+
+// Settings 房间级别的设置，管理员通过 /api/settings 修改，随完整状态广播，客户端据此调整界面
+// FamilyMode 和 QueueMode 与 GlobalState 中的同名字段一致，保留后者兼容旧客户端
+type Settings struct {
+	// CrossfadeMs 客户端在两首歌之间交叉淡入淡出的时长，0 表示不淡入淡出
+	CrossfadeMs int `json:"crossfadeMs"`
+	// FadeMs 手动切歌、暂停和继续播放时 FADE 事件的淡入淡出时长，0 表示直接切断，见 fade.go
+	FadeMs     int       `json:"fadeMs"`
+	FamilyMode bool      `json:"familyMode"`
+	QueueMode  QueueMode `json:"queueMode"`
+	// MaxSkipsPerHour 和 MaxPendingRequests 每个用户每小时最多切歌次数和最多同时排队的歌曲数，0 表示不限制
+	// 没有保存过设置时取配置文件中 fairness 的值
+	MaxSkipsPerHour    int `json:"maxSkipsPerHour"`
+	MaxPendingRequests int `json:"maxPendingRequests"`
+	// MaxSongMinutes 点歌时歌曲的最大时长，0 表示不限制；这条和 AllowedSources 对管理员不生效，见 rules.go
+	MaxSongMinutes int `json:"maxSongMinutes"`
+	// AllowedSources 允许点播的歌曲来源（local、url、reference、remote），为空表示不限制
+	AllowedSources []string `json:"allowedSources"`
+	// JingleEvery 每播放多少首歌插入一段台标，0 表示不插入，见 jingle.go
+	JingleEvery int `json:"jingleEvery"`
+}
+
+// Validate 检查设置的取值
+func (s Settings) Validate() error {
+	if s.CrossfadeMs < 0 || s.CrossfadeMs > MaxCrossfadeMs {
+		return fmt.Errorf("crossfadeMs must be between 0 and %d", MaxCrossfadeMs)
+	}
+	if s.FadeMs < 0 || s.FadeMs > MaxFadeMs {
+		return fmt.Errorf("fadeMs must be between 0 and %d", MaxFadeMs)
+	}
+	if s.QueueMode != QueueFIFO && s.QueueMode != QueueRoundRobin {
+		return fmt.Errorf("unknown queue mode %q", s.QueueMode)
+	}
+	if s.MaxSkipsPerHour < 0 || s.MaxPendingRequests < 0 {
+		return fmt.Errorf("maxSkipsPerHour and maxPendingRequests must not be negative")
+	}
+	if s.MaxSongMinutes < 0 || s.MaxSongMinutes > MaxSongMinutesLimit {
+		return fmt.Errorf("maxSongMinutes must be between 0 and %d", MaxSongMinutesLimit)
+	}
+	if s.JingleEvery < 0 || s.JingleEvery > MaxJingleEvery {
+		return fmt.Errorf("jingleEvery must be between 0 and %d", MaxJingleEvery)
+	}
+	for _, source := range s.AllowedSources {
+		if !db.ValidSource(source) {
+			return fmt.Errorf("unknown source %q, use local, url, reference or remote", source)
+		}
+	}
+	return nil
+}
+
+// Settings 返回当前的房间设置
+func (m *Manager) Settings() Settings {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.settingsLocked()
+}
+
+func (m *Manager) settingsLocked() Settings {
+	s := m.State.Settings
+	s.AllowedSources = append([]string{}, s.AllowedSources...)
+	return s
+}
+
+// SetSettings 保存房间设置并广播，家庭模式和排队方式的变化与单独修改时的效果相同
+func (m *Manager) SetSettings(settings Settings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	settings.AllowedSources = append([]string{}, settings.AllowedSources...)
+	previous := m.State.Settings
+	m.State.Settings = settings
+	if settings.QueueMode != previous.QueueMode {
+		m.setQueueModeLocked(settings.QueueMode)
+	}
+	if settings.FamilyMode != previous.FamilyMode {
+		m.setFamilyModeLocked(settings.FamilyMode)
+	}
+	m.saveSettings()
+	m.broadcast()
+	log.Println("Action: Room settings updated")
+	return nil
+}
+
+// saveSettings 持久化房间设置，家庭模式和排队方式另有单独的键，调用方需持有锁
+func (m *Manager) saveSettings() {
+	if data, err := json.Marshal(m.State.Settings); err == nil {
+		m.store.Set("room_settings", string(data))
+	}
+}
+
+// loadSettings 恢复保存的房间设置，没有保存过时使用配置文件中的值，调用方需持有锁
+// 需在恢复家庭模式和排队方式之后调用
+func (m *Manager) loadSettings() {
+	settings := Settings{
+		FadeMs:             defaultFadeMs,
+		MaxSkipsPerHour:    m.cfg.Fairness.MaxSkipsPerHour,
+		MaxPendingRequests: m.cfg.Fairness.MaxPendingRequests,
+	}
+	if data, _ := m.store.Get("room_settings"); data != "" {
+		if err := json.Unmarshal([]byte(data), &settings); err != nil {
+			log.Printf("Warning: failed to load room settings: %v", err)
+		}
+	}
+	settings.FamilyMode = m.State.FamilyMode
+	settings.QueueMode = m.State.QueueMode
+	m.State.Settings = settings
+}
@@ -0,0 +1,134 @@
+package state
+
+import (
+	"encoding/json"
+	"math/rand"
+)
+
+// shuffleCycle 随机播放的一轮：Order 是打乱后的歌曲 ID，Pos 之前的已经轮到过
+// 一轮内每首歌只播一次，全部轮到后再打乱开始下一轮，持久化在 system_state 中，重启后接着这一轮
+type shuffleCycle struct {
+	Order []string `json:"order"`
+	Pos   int      `json:"pos"`
+}
+
+// shuffleNextIdx 从本轮还没轮到的歌曲中取下一首可播放的，本轮用完时开始新的一轮
+// 只有当前一首可播放时仍返回它，调用方需持有锁
+func (m *Manager) shuffleNextIdx() int {
+	m.addNewSongsToCycle()
+	for round := 0; round < 2; round++ {
+		for m.shuffle.Pos < len(m.shuffle.Order) {
+			songID := m.shuffle.Order[m.shuffle.Pos]
+			m.shuffle.Pos++
+			// 已移出播放列表或不可播放的歌曲本轮跳过
+			if idx := m.playlistIndex(songID); idx != -1 && m.isPlayable(m.State.Playlist[idx].Song) {
+				m.saveShuffleCycle()
+				return idx
+			}
+		}
+		m.newShuffleCycle()
+	}
+	m.saveShuffleCycle()
+	return m.nextPlayableIdx(m.State.CurrentPlaylistIdx, 1)
+}
+
+// shufflePeekIdx 返回 shuffleNextIdx 将选出的歌曲，不推进本轮的位置，调用方需持有锁
+// 本轮已用完时提前开始新的一轮，之后 shuffleNextIdx 沿用这一轮
+func (m *Manager) shufflePeekIdx() int {
+	m.addNewSongsToCycle()
+	for round := 0; round < 2; round++ {
+		for _, songID := range m.shuffle.Order[m.shuffle.Pos:] {
+			if idx := m.playlistIndex(songID); idx != -1 && m.isPlayable(m.State.Playlist[idx].Song) {
+				return idx
+			}
+		}
+		if round == 0 {
+			m.newShuffleCycle()
+			m.saveShuffleCycle()
+		}
+	}
+	return m.nextPlayableIdx(m.State.CurrentPlaylistIdx, 1)
+}
+
+// newShuffleCycle 打乱当前播放列表开始新的一轮，调用方需持有锁
+// 当前歌曲算作新一轮已经播过的第一首，避免跨轮连续重复
+func (m *Manager) newShuffleCycle() {
+	order := make([]string, 0, len(m.State.Playlist))
+	for _, item := range m.State.Playlist {
+		if item.SongID != m.State.CurrentSongID {
+			order = append(order, item.SongID)
+		}
+	}
+	rand.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	m.shuffle = shuffleCycle{}
+	if m.playlistIndex(m.State.CurrentSongID) != -1 {
+		m.shuffle.Order = append(m.shuffle.Order, m.State.CurrentSongID)
+		m.shuffle.Pos = 1
+	}
+	m.shuffle.Order = append(m.shuffle.Order, order...)
+}
+
+// markShufflePlayed 手动点播等不经过 shuffleNextIdx 切到的歌曲也算作本轮已经播过，调用方需持有锁
+func (m *Manager) markShufflePlayed(songID string) {
+	if m.State.PlayMode != Shuffle || len(m.shuffle.Order) == 0 {
+		return
+	}
+	for i, id := range m.shuffle.Order {
+		if id != songID {
+			continue
+		}
+		if i >= m.shuffle.Pos {
+			m.shuffle.Order[i], m.shuffle.Order[m.shuffle.Pos] = m.shuffle.Order[m.shuffle.Pos], m.shuffle.Order[i]
+			m.shuffle.Pos++
+		}
+		m.saveShuffleCycle()
+		return
+	}
+	m.shuffle.Order = append(m.shuffle.Order, "")
+	copy(m.shuffle.Order[m.shuffle.Pos+1:], m.shuffle.Order[m.shuffle.Pos:])
+	m.shuffle.Order[m.shuffle.Pos] = songID
+	m.shuffle.Pos++
+	m.saveShuffleCycle()
+}
+
+// addNewSongsToCycle 把本轮开始后加入播放列表的歌曲随机插入本轮还没轮到的部分，调用方需持有锁
+func (m *Manager) addNewSongsToCycle() {
+	if len(m.shuffle.Order) == 0 {
+		return
+	}
+	known := make(map[string]bool, len(m.shuffle.Order))
+	for _, songID := range m.shuffle.Order {
+		known[songID] = true
+	}
+	for _, item := range m.State.Playlist {
+		if known[item.SongID] {
+			continue
+		}
+		known[item.SongID] = true
+		at := m.shuffle.Pos + rand.Intn(len(m.shuffle.Order)-m.shuffle.Pos+1)
+		m.shuffle.Order = append(m.shuffle.Order, "")
+		copy(m.shuffle.Order[at+1:], m.shuffle.Order[at:])
+		m.shuffle.Order[at] = item.SongID
+	}
+}
+
+// saveShuffleCycle 持久化本轮的顺序和位置，调用方需持有锁
+func (m *Manager) saveShuffleCycle() {
+	if data, err := json.Marshal(m.shuffle); err == nil {
+		m.store.Set("shuffle_cycle", string(data))
+	}
+}
+
+// loadShuffleCycle 恢复保存的随机播放轮次，数据无效时从新的一轮开始，调用方需持有锁
+func (m *Manager) loadShuffleCycle() {
+	m.shuffle = shuffleCycle{}
+	data, _ := m.store.Get("shuffle_cycle")
+	if data == "" {
+		return
+	}
+	var saved shuffleCycle
+	if err := json.Unmarshal([]byte(data), &saved); err != nil || saved.Pos < 0 || saved.Pos > len(saved.Order) {
+		return
+	}
+	m.shuffle = saved
+}
@@ -0,0 +1,111 @@
+package state
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// maxSkipRegions 每首歌最多的跳过区间数
+const maxSkipRegions = 20
+
+// SetSkipRegions 替换歌曲的跳过区间（长静音、口播开场等），播放到区间开始时自动跳到区间末尾
+// 区间延伸到歌曲末尾时视为片尾，歌曲在区间开始时直接结束
+func (m *Manager) SetSkipRegions(songID string, regions []db.SkipRegion) error {
+	if len(regions) > maxSkipRegions {
+		return fmt.Errorf("at most %d skip regions per song", maxSkipRegions)
+	}
+	sort.Slice(regions, func(i, j int) bool { return regions[i].StartMs < regions[j].StartMs })
+	for i, r := range regions {
+		if r.StartMs < 0 || r.EndMs <= r.StartMs {
+			return fmt.Errorf("skip region %d-%d is invalid", r.StartMs, r.EndMs)
+		}
+		if i > 0 && r.StartMs < regions[i-1].EndMs {
+			return fmt.Errorf("skip regions must not overlap")
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.db.SetSkipRegions(songID, regions); err != nil {
+		return err
+	}
+	for i := range m.State.Playlist {
+		if song := m.State.Playlist[i].Song; song != nil && song.ID == songID {
+			song.SkipRegions = append([]db.SkipRegion(nil), regions...)
+		}
+	}
+	if song := m.State.CurrentSong; song != nil && song.ID == songID {
+		song.SkipRegions = append([]db.SkipRegion(nil), regions...)
+		// 按新的区间重新安排跳过和结束定时器
+		m.setPosition(m.positionLocked())
+	}
+	log.Printf("Action: %d skip regions set for song %s", len(regions), songID)
+	m.broadcast()
+	return nil
+}
+
+// songEndMs 歌曲实际结束的位置：有片尾区间时在片尾开始处结束
+func songEndMs(song *db.Song) int64 {
+	end := int64(song.DurationMs)
+	if n := len(song.SkipRegions); n > 0 && song.SkipRegions[n-1].EndMs >= end && song.SkipRegions[n-1].StartMs < end {
+		end = song.SkipRegions[n-1].StartMs
+	}
+	return end
+}
+
+// playableDurationMs 扣除跳过区间后实际播放的时长
+func playableDurationMs(song *db.Song) int64 {
+	end := songEndMs(song)
+	played := end
+	for _, r := range song.SkipRegions {
+		if r.StartMs >= end {
+			break
+		}
+		played -= min(r.EndMs, end) - r.StartMs
+	}
+	return played
+}
+
+// scheduleSkip 安排在下一个跳过区间开始时跳到区间末尾，当前已在区间内时立即跳过，调用方需持有锁
+func (m *Manager) scheduleSkip(song *db.Song, gen uint64) {
+	pos := m.positionLocked()
+	end := songEndMs(song)
+	for _, r := range song.SkipRegions {
+		if r.EndMs <= pos {
+			continue
+		}
+		if r.StartMs >= end {
+			return // 片尾由结束定时器处理
+		}
+		var delay time.Duration
+		if r.StartMs > pos {
+			delay = time.Duration(float64(r.StartMs-pos) / m.State.PlaybackRate * float64(time.Millisecond))
+		}
+		m.skipTimer = time.AfterFunc(delay, func() {
+			m.onSkipRegion(gen)
+		})
+		return
+	}
+}
+
+// onSkipRegion 到达跳过区间，跳到区间末尾并广播新的进度，客户端据此 seek
+func (m *Manager) onSkipRegion(gen uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if gen != m.clockGen || m.State.CurrentSong == nil {
+		return
+	}
+	pos := m.positionLocked()
+	for _, r := range m.State.CurrentSong.SkipRegions {
+		if r.EndMs > pos {
+			m.setPosition(r.EndMs)
+			m.persistPosition()
+			m.broadcast()
+			log.Printf("Action: Skipped region %d-%d of song %s", r.StartMs, r.EndMs, m.State.CurrentSongID)
+			return
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package state
+
+import (
+	"sync"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// Snapshot 是某一时刻全局状态的深拷贝，JSON 结构与 GlobalState 相同
+// 在锁内生成，之后可以在锁外安全地读取和序列化
+type Snapshot GlobalState
+
+// Snapshot 返回当前状态的快照
+func (m *Manager) Snapshot() *Snapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.snapshotLocked()
+}
+
+// Subscribe 订阅状态变化，每次广播时收到最新的快照
+// 通道只保留最新的一份，读取慢的订阅者会跳过中间状态；调用返回的函数取消订阅并关闭通道
+func (m *Manager) Subscribe() (<-chan *Snapshot, func()) {
+	ch := make(chan *Snapshot, 1)
+	m.mu.Lock()
+	m.subscribers[ch] = struct{}{}
+	m.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			m.mu.Lock()
+			delete(m.subscribers, ch)
+			m.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// publish 把快照发给所有订阅者，调用方需持有锁
+func (m *Manager) publish(snapshot *Snapshot) {
+	for ch := range m.subscribers {
+		select {
+		case ch <- snapshot:
+		default:
+			// 丢弃未读取的旧快照，只有持锁的一方会写入，因此再次发送不会阻塞
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- snapshot
+		}
+	}
+}
+
+// snapshotLocked 生成当前状态的快照，调用方需持有（读）锁
+func (m *Manager) snapshotLocked() *Snapshot {
+	s := Snapshot(*m.State)
+	s.ProgressMs = m.positionLocked()
+	s.CurrentSong = copySong(m.State.CurrentSong)
+	s.Playlist = make([]db.PlaylistItem, len(m.State.Playlist))
+	for i, item := range m.State.Playlist {
+		item.Song = copySong(item.Song)
+		s.Playlist[i] = item
+	}
+	m.estimateStartTimes(s.Playlist, s.ProgressMs)
+	if poll := m.State.Poll; poll != nil {
+		p := *poll
+		p.Candidates = append([]PollCandidate(nil), poll.Candidates...)
+		p.votes = nil
+		s.Poll = &p
+	}
+	s.Settings = m.settingsLocked()
+	if pinned := m.State.PinnedPlaylist; pinned != nil {
+		p := *pinned
+		p.SongIDs = append([]string{}, pinned.SongIDs...)
+		s.PinnedPlaylist = &p
+	}
+	if scrub := m.State.Scrubbing; scrub != nil {
+		sc := *scrub
+		s.Scrubbing = &sc
+	}
+	if suggestions := m.State.Suggestions; suggestions != nil {
+		qs := *suggestions
+		qs.Songs = append([]Suggestion(nil), suggestions.Songs...)
+		s.Suggestions = &qs
+	}
+	if announcement := m.State.Announcement; announcement != nil {
+		a := *announcement
+		s.Announcement = &a
+	}
+	if drop := m.State.Drop; drop != nil {
+		d := *drop
+		s.Drop = &d
+	}
+	if maintenance := m.State.Maintenance; maintenance != nil {
+		mm := *maintenance
+		s.Maintenance = &mm
+	}
+	s.OutputDevices = append([]Device{}, m.State.OutputDevices...)
+	s.Equalizer.Bands = append([]EQBand(nil), m.State.Equalizer.Bands...)
+	return &s
+}
+
+// copySong 复制歌曲及其章节，避免快照与内存状态共享可变数据
+func copySong(song *db.Song) *db.Song {
+	if song == nil {
+		return nil
+	}
+	c := *song
+	c.Chapters = append([]db.Chapter(nil), song.Chapters...)
+	c.SkipRegions = append([]db.SkipRegion(nil), song.SkipRegions...)
+	c.Credits = append([]db.SongCredit(nil), song.Credits...)
+	if song.GainDb != nil {
+		gain := *song.GainDb
+		c.GainDb = &gain
+	}
+	return &c
+}
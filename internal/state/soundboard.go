@@ -0,0 +1,40 @@
+package state
+
+import (
+	"log"
+	"time"
+
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// EventSoundboard DJ 触发音效板片段时广播，客户端把片段叠加在当前歌曲上播放，
+// 只发给声明了 soundboard 功能的客户端
+const EventSoundboard = "SOUNDBOARD"
+
+// SoundboardEvent 是 SOUNDBOARD 事件的数据，At 为触发的时刻（Unix 毫秒）
+type SoundboardEvent struct {
+	ClipID      uint   `json:"clipId"`
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	DurationMs  int    `json:"durationMs"`
+	TriggeredBy string `json:"triggeredBy"`
+	At          int64  `json:"at"`
+}
+
+// PlayClip 广播音效板片段，不改变播放状态，也不广播完整状态
+func (m *Manager) PlayClip(clip *db.SoundClip, actor Actor) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.active {
+		return
+	}
+	m.hub.BroadcastEvent(EventSoundboard, SoundboardEvent{
+		ClipID:      clip.ID,
+		Name:        clip.Name,
+		URL:         clip.URL,
+		DurationMs:  clip.DurationMs,
+		TriggeredBy: actor.Username,
+		At:          time.Now().UnixMilli(),
+	})
+	log.Printf("Action: Soundboard clip %d (%s) played by %s", clip.ID, clip.Name, actor.Username)
+}
@@ -9,7 +9,11 @@ import (
 	"sync"
 	"time"
 
+	"github.com/yeeeck/sync-jukebox/internal/config"
 	"github.com/yeeeck/sync-jukebox/internal/db"
+	"github.com/yeeeck/sync-jukebox/internal/hooks"
+	"github.com/yeeeck/sync-jukebox/internal/policy"
+	"github.com/yeeeck/sync-jukebox/internal/store"
 	"github.com/yeeeck/sync-jukebox/internal/websocket"
 )
 
@@ -20,38 +24,151 @@ const (
 	RepeatAll PlayMode = "REPEAT_ALL"
 	RepeatOne PlayMode = "REPEAT_ONE"
 	Shuffle   PlayMode = "SHUFFLE"
+	// AutoDJ 自动切歌时优先选节奏和调性能够衔接的歌曲，见 autodj.go
+	AutoDJ PlayMode = "AUTO_DJ"
+)
+
+// 播放速度的允许范围，用于播客、有声书等场景
+const (
+	MinPlaybackRate = 0.5
+	MaxPlaybackRate = 2.0
 )
 
 // GlobalState 是应用唯一的实时状态来源
 type GlobalState struct {
+	// Status 播放状态，IsPlaying 为兼容旧客户端保留，Playing 和 Loading 时为 true，见 status.go
+	Status             PlaybackStatus    `json:"status"`
 	IsPlaying          bool              `json:"isPlaying"`
 	CurrentSongID      string            `json:"currentSongId"`
 	CurrentSong        *db.Song          `json:"currentSong"`
 	Playlist           []db.PlaylistItem `json:"playlist"`
 	CurrentPlaylistIdx int               `json:"currentPlaylistIdx"`
-	ProgressMs         int64             `json:"progressMs"` // 当前歌曲播放进度
-	LastUpdate         time.Time         `json:"-"`          // 服务端进度更新时间
+	ProgressMs         int64             `json:"progressMs"` // 当前歌曲播放进度，广播时由时钟推算
 	PlayMode           PlayMode          `json:"playMode"`
+	PlaybackRate       float64           `json:"playbackRate"`   // 播放速度，1.0 为原速
+	Equalizer          Equalizer         `json:"equalizer"`      // 共享的均衡器，派对中所有客户端音色一致
+	FamilyMode         bool              `json:"familyMode"`     // 家庭模式：禁止点播和自动播放露骨内容
+	Poll               *Poll             `json:"poll,omitempty"` // 正在进行或刚结束的“下一首”投票
+	// Settings 房间设置，见 settings.go
+	Settings Settings `json:"settings"`
+	// Scrubbing 有人正在拖动进度条，见 seek.go
+	Scrubbing *Scrub `json:"scrubbing,omitempty"`
+	// PinnedPlaylist 播放列表播完后接着播放的固定歌单，见 pinned.go
+	PinnedPlaylist *PinnedPlaylist `json:"pinnedPlaylist,omitempty"`
+	// PlayingJingle 正在播放的是台标，台标不在播放列表中，CurrentPlaylistIdx 仍指向台标之前的那首歌，见 jingle.go
+	PlayingJingle bool `json:"playingJingle,omitempty"`
+	// Suggestions 待播歌曲不多时推荐的歌曲，等待听众确认，见 lowqueue.go
+	Suggestions   *QueueSuggestions `json:"suggestions,omitempty"`
+	QueueMode     QueueMode         `json:"queueMode"`
+	OutputDevices []Device          `json:"outputDevices"` // 正在发声的设备
+	// ActiveOutputID 转移播放后唯一应当发声的输出设备，其他输出设备静音；为空时所有输出设备一起发声，见 transfer.go
+	ActiveOutputID string `json:"activeOutputId,omitempty"`
+	// PlaylistVersion 每次播放列表变化时递增，批量重排时用于检测并发修改
+	PlaylistVersion int64 `json:"playlistVersion"`
+	// Session 进行中的派对，播放历史和回顾按派对划分
+	Session *db.PartySession `json:"session,omitempty"`
+	// MirroringFrom 正在镜像的远程实例地址，非空时播放由远程实例控制，见 mirror.go
+	MirroringFrom string `json:"mirroringFrom,omitempty"`
+	// Announcement 管理员发布的公告，客户端以横幅显示，见 announcement.go
+	Announcement *Announcement `json:"announcement,omitempty"`
+	// Drop 预定在某个精确时刻开始播放的歌曲，客户端据此显示倒计时，见 drop.go
+	Drop *Drop `json:"drop,omitempty"`
+	// Maintenance 维护模式，非空时播放暂停且修改请求被拒绝，见 maintenancemode.go
+	Maintenance *MaintenanceMode `json:"maintenance,omitempty"`
+	// ReadOnly 本实例是只读副本，客户端据此隐藏点歌和播放控制
+	ReadOnly bool `json:"readOnly,omitempty"`
 }
 
 // Manager 封装了状态以及其依赖
 type Manager struct {
-	State  *GlobalState
-	db     *db.DB
-	hub    *websocket.Hub
-	mu     sync.RWMutex
-	ticker *time.Ticker
+	State *GlobalState
+	db    *db.DB
+	hub   *websocket.Hub
+	cfg   *config.Config
+	hooks *hooks.Dispatcher
+	// store 保存进度、当前歌曲等运行时状态，可以是 SQLite 或 Redis
+	store store.Store
+	mu    sync.RWMutex
+	// policy 运维提供的队列策略脚本，未配置时为 nil
+	policy *policy.Script
+	// blocklist 黑名单的内存副本，避免每次校验都查库
+	blocklist []db.BlocklistEntry
+	// ticker 播放期间的进度广播，tickerDone 关闭时广播协程退出，见 startProgressTicker
+	ticker     *time.Ticker
+	tickerDone chan struct{}
+	// 进度时钟：anchorAt 时刻的进度为 anchorMs，endTimer 在歌曲结束时触发，见 clock.go
+	anchorMs int64
+	anchorAt time.Time
+	endTimer *time.Timer
+	clockGen uint64
+	// loadingTimer 切歌后的缓冲时间结束时开始播放，见 status.go
+	loadingTimer *time.Timer
+	loadingGen   uint64
+	// seekTimer 合并连续跳转的窗口，pendingSeek 是窗口内最后一次跳转，scrubTimer 在拖动超时时触发，见 seek.go
+	seekTimer   *time.Timer
+	pendingSeek *pendingSeek
+	scrubTimer  *time.Timer
+	// shuffle 随机播放模式当前的一轮，见 shuffle.go
+	shuffle shuffleCycle
+	// startingTimer 在歌曲结束前触发 TRACK_STARTING 预告
+	startingTimer *time.Timer
+	// skipping 手动切歌期间不为空，见 scrobble.go
+	skipping *skipRecord
+	// upNextTimer 在歌曲结束前 upNextLeadMs 触发 UP_NEXT，见 gapless.go
+	upNextTimer *time.Timer
+	// skipTimer 在跳过区间开始时触发，见 skip.go
+	skipTimer *time.Timer
+	// announcementTimer 在公告过期时撤下公告
+	announcementTimer *time.Timer
+	// dropTimer 预定歌曲的倒计时和切歌，见 drop.go
+	dropTimer *time.Timer
+	// persistedOrders 数据库中播放列表各行的 item_order，用于计算差异写入
+	persistedOrders map[int]int
+	// devices 已登记的设备，deviceVolumes 记住设备音量以便重连后恢复
+	devices       map[string]*Device
+	deviceVolumes map[string]float64
+	// subscribers 通过 Subscribe 订阅状态快照的通道
+	subscribers map[chan *Snapshot]struct{}
+	// songStartedAt 和 songRequestedBy 当前歌曲开始播放的时间和点歌用户，写入收听记录，见 scrobble.go
+	songStartedAt   time.Time
+	songRequestedBy string
+	// suggestionsDismissed 推荐被撤下，待播时长恢复之前不再推荐，见 lowqueue.go
+	suggestionsDismissed bool
+	// songsSinceGem 上次穿插被遗忘的好歌之后切过的歌曲数，见 forgotten.go
+	songsSinceGem int
+	// songsSinceJingle 和 nextJingle 上次插入台标之后切过的歌曲数和选好的下一段台标，见 jingle.go
+	songsSinceJingle int
+	nextJingle       *db.Song
+	// active 为 false 时本实例是集群中的从实例，不运行时钟也不广播，见 cluster.go
+	active bool
 }
 
 // NewManager 创建并从数据库加载状态
-func NewManager(db *db.DB, hub *websocket.Hub) (*Manager, error) {
+func NewManager(db *db.DB, hub *websocket.Hub, cfg *config.Config, hookDispatcher *hooks.Dispatcher, stateStore store.Store) (*Manager, error) {
 	m := &Manager{
-		State: &GlobalState{
-			IsPlaying: false,
-			PlayMode:  RepeatAll,
-		},
-		db:  db,
-		hub: hub,
+		State:         newGlobalState(),
+		db:            db,
+		hub:           hub,
+		cfg:           cfg,
+		hooks:         hookDispatcher,
+		store:         stateStore,
+		devices:       make(map[string]*Device),
+		deviceVolumes: make(map[string]float64),
+		subscribers:   make(map[chan *Snapshot]struct{}),
+		active:        !cfg.Cluster.Enabled,
+	}
+	script, err := policy.Load(cfg.Queue.PolicyScript, time.Duration(cfg.Queue.PolicyTimeoutMs)*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	m.policy = script
+	hub.RequireCapability(EventSongComments, websocket.CapChat)
+	hub.RequireCapability(EventSoundboard, websocket.CapSoundboard)
+	if !m.active {
+		// 集群模式下等成为主实例后再从数据库加载，房间设置先读出来供本实例的接口使用
+		m.loadSettings()
+		log.Println("State manager initialized, waiting for leadership.")
+		return m, nil
 	}
 	if err := m.loadFromDB(); err != nil {
 		return nil, err
@@ -60,6 +177,19 @@ func NewManager(db *db.DB, hub *websocket.Hub) (*Manager, error) {
 	return m, nil
 }
 
+func newGlobalState() *GlobalState {
+	return &GlobalState{
+		Status:        Stopped,
+		IsPlaying:     false,
+		PlayMode:      RepeatAll,
+		PlaybackRate:  1.0,
+		Equalizer:     flatEqualizer(),
+		QueueMode:     QueueFIFO,
+		Settings:      Settings{QueueMode: QueueFIFO},
+		OutputDevices: []Device{},
+	}
+}
+
 func (m *Manager) loadFromDB() error {
 	// 加载播放列表
 	playlist, err := m.db.GetPlaylistItems()
@@ -67,23 +197,57 @@ func (m *Manager) loadFromDB() error {
 		return err
 	}
 	m.State.Playlist = playlist
+	m.snapshotPersistedOrders()
+
+	// 加载黑名单
+	if m.blocklist, err = m.db.GetBlocklist(); err != nil {
+		return err
+	}
+
+	// 加载进行中的派对
+	if m.State.Session, err = m.db.GetActivePartySession(); err != nil {
+		return err
+	}
 
 	// 加载系统状态
-	m.State.CurrentSongID, _ = m.db.GetSystemState("current_song_id")
-	isPlayingStr, _ := m.db.GetSystemState("is_playing")
-	m.State.IsPlaying = isPlayingStr == "true"
+	m.State.CurrentSongID, _ = m.store.Get("current_song_id")
+	isPlayingStr, _ := m.store.Get("is_playing")
 
-	progressStr, _ := m.db.GetSystemState("progress_ms")
+	progressStr, _ := m.store.Get("progress_ms")
 	progress, _ := strconv.ParseInt(progressStr, 10, 64)
-	m.State.ProgressMs = progress
 
-	lastUpdateStr, _ := m.db.GetSystemState("last_update_unix")
+	rateStr, _ := m.store.Get("playback_rate")
+	if rate, err := strconv.ParseFloat(rateStr, 64); err == nil && rate >= MinPlaybackRate && rate <= MaxPlaybackRate {
+		m.State.PlaybackRate = rate
+	}
+
+	m.loadEqualizer()
+	m.loadShuffleCycle()
+	m.loadPinnedPlaylist()
+
+	if queueMode, _ := m.store.Get("queue_mode"); queueMode == string(QueueRoundRobin) {
+		m.State.QueueMode = QueueRoundRobin
+	}
+	if playMode, _ := m.store.Get("play_mode"); validPlayMode(PlayMode(playMode)) {
+		m.State.PlayMode = PlayMode(playMode)
+	}
+
+	familyModeStr, _ := m.store.Get("family_mode")
+	m.State.FamilyMode = familyModeStr == "true"
+
+	m.loadSettings()
+	m.loadAnnouncement()
+	m.loadDrop()
+	m.loadMaintenanceMode()
+	m.State.ReadOnly = m.cfg.ReadOnly
+
+	lastUpdateStr, _ := m.store.Get("last_update_unix")
 	lastUpdateUnix, _ := strconv.ParseInt(lastUpdateStr, 10, 64)
 
 	// 计算自上次保存以来的进度
-	if m.State.IsPlaying && lastUpdateUnix > 0 {
-		elapsed := time.Now().Unix() - lastUpdateUnix
-		m.State.ProgressMs += elapsed * 1000
+	if isPlayingStr == "true" && lastUpdateUnix > 0 {
+		elapsed := time.Since(time.Unix(lastUpdateUnix, 0)).Milliseconds()
+		progress += int64(float64(elapsed) * m.State.PlaybackRate)
 	}
 
 	// 找到当前歌曲在播放列表中的索引
@@ -91,22 +255,34 @@ func (m *Manager) loadFromDB() error {
 		if item.SongID == m.State.CurrentSongID {
 			m.State.CurrentPlaylistIdx = i
 			m.State.CurrentSong = item.Song
+			// 重启前的开始时间没有保存，按进度推算
+			m.songStartedAt = time.Now().Add(-time.Duration(progress) * time.Millisecond)
+			m.songRequestedBy = item.AddedBy
 			break
 		}
 	}
+	// 重启后直接恢复播放或暂停，不再经过 Loading
+	switch {
+	case m.State.CurrentSong == nil:
+		m.setStatusLocked(Stopped)
+	case isPlayingStr == "true":
+		m.setStatusLocked(Playing)
+	default:
+		m.setStatusLocked(Paused)
+	}
 
-	if m.State.IsPlaying {
+	// 恢复进度时钟，已经超出歌曲时长时结束定时器会立即触发
+	m.setPosition(progress)
+	if m.State.Status == Playing {
 		m.startProgressTicker()
 	}
 
 	return nil
 }
 
-// GetFullState 返回当前状态的副本，用于新连接
+// GetFullState 返回当前状态的快照，用于新连接
 func (m *Manager) GetFullState() interface{} {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return m.State
+	return m.Snapshot()
 }
 
 // --- 核心操作方法 ---
@@ -115,67 +291,84 @@ func (m *Manager) GetFullState() interface{} {
 func (m *Manager) Play() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.playLocked()
+}
 
-	if m.State.IsPlaying {
-		return
-	}
-	if len(m.State.Playlist) == 0 {
+// playLocked 开始播放，调用方需持有锁
+func (m *Manager) playLocked() {
+	if m.State.Status != Paused {
+		// 停止状态下从第一首可播放的歌曲开始，播放列表中没有可播放的歌曲时从固定歌单取歌
+		if m.State.Status == Stopped {
+			idx := m.nextPlayableIdx(-1, 1)
+			if idx == -1 {
+				idx = m.pinnedNextIdx()
+			}
+			if idx != -1 {
+				m.changeSong(idx)
+				m.broadcast()
+				log.Println("Action: Play")
+			}
+		}
 		return
 	}
 
-	// 将 IsPlaying 状态设置为 true
-	m.State.IsPlaying = true
-	// 重置 LastUpdate 时间戳，从现在开始计算播放时长
-	m.State.LastUpdate = time.Now()
-	// 重新启动进度更新定时器
+	m.setStatusLocked(Playing)
+	m.fadeInLocked(FadeReasonResume)
+	// 从暂停时的进度开始重新计时
+	m.setPosition(m.anchorMs)
+	// 重新启动进度广播定时器
 	m.startProgressTicker()
 	// 持久化当前状态到数据库
-	m.db.SetSystemState("is_playing", "true")
-	m.db.SetSystemState("last_update_unix", strconv.FormatInt(m.State.LastUpdate.Unix(), 10))
+	m.store.Set("is_playing", "true")
+	m.persistPosition()
 	// 通过 WebSocket 广播状态更新
-	m.hub.Broadcast(m.State)
+	m.broadcast()
 	log.Println("Action: Play")
 }
 
 func (m *Manager) Pause() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	// 如果当前没有在播放，则直接返回
-	if !m.State.IsPlaying {
+	m.pauseLocked()
+}
+
+// pauseLocked 暂停播放，调用方需持有锁
+func (m *Manager) pauseLocked() {
+	// 如果当前没有在播放或缓冲，则直接返回
+	if m.State.Status != Playing && m.State.Status != Loading {
 		return
 	}
 	// 停止进度更新定时器
 	m.stopProgressTicker() // 假设存在一个停止定时器的函数
-	// 核心修复：
-	// 1. 计算从上次更新到现在的增量时间并累加到进度中
-	//elapsed := time.Since(m.State.LastUpdate).Milliseconds()
-	//m.State.ProgressMs += elapsed
-	// 2. 将 IsPlaying 状态设置为 false
-	m.State.IsPlaying = false
-	// 3. 更新 LastUpdate 时间戳，为下一次播放做准备
-	m.State.LastUpdate = time.Now()
+	m.fadeOutLocked(FadeReasonPause)
+	// 先按时钟算出当前进度，再停止计时
+	position := m.positionLocked()
+	m.setStatusLocked(Paused)
+	m.setPosition(position)
 	// 持久化当前状态到数据库
-	m.db.SetSystemState("is_playing", "false")
-	m.db.SetSystemState("progress_ms", strconv.FormatInt(m.State.ProgressMs, 10))
-	m.db.SetSystemState("last_update_unix", strconv.FormatInt(m.State.LastUpdate.Unix(), 10))
+	m.store.Set("is_playing", "false")
+	m.persistPosition()
 	// 通过 WebSocket 广播状态更新
-	m.hub.Broadcast(m.State)
+	m.broadcast()
 	log.Println("Action: Pause")
 }
 
-func (m *Manager) NextSong() {
+// NextSong 手动切到下一首，by 为切歌的用户，reason 为可选的原因（见 db.ValidSkipReason），记录在被切走歌曲的收听记录中
+func (m *Manager) NextSong(by, reason string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	m.skipping = &skipRecord{by: by, reason: reason}
+	defer func() { m.skipping = nil }()
+	m.fadeOutLocked(FadeReasonSkip)
 	if len(m.State.Playlist) == 0 {
 		m.stopPlayback()
-		return
+	} else {
+		// TODO: 实现不同播放模式的逻辑
+		m.advance()
 	}
-
-	// TODO: 实现不同播放模式的逻辑
-	nextIdx := (m.State.CurrentPlaylistIdx + 1) % len(m.State.Playlist)
-
-	m.changeSong(nextIdx)
+	m.fadeInLocked(FadeReasonSkip)
+	m.broadcast()
 	log.Println("Action: Next Song")
 }
 
@@ -183,19 +376,23 @@ func (m *Manager) PrevSong() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if len(m.State.Playlist) == 0 {
+	m.fadeOutLocked(FadeReasonSkip)
+	from := m.State.CurrentPlaylistIdx
+	if m.State.PlayingJingle {
+		from++ // 台标之前的那首歌
+	}
+	if nextIdx := m.nextPlayableIdx(from, -1); nextIdx != -1 {
+		m.changeSong(nextIdx)
+	} else {
 		m.stopPlayback()
-		return
 	}
-
-	nextIdx := (m.State.CurrentPlaylistIdx - 1 + len(m.State.Playlist)) % len(m.State.Playlist)
-
-	m.changeSong(nextIdx)
+	m.fadeInLocked(FadeReasonSkip)
+	m.broadcast()
 	log.Println("Action: Previous Song")
 }
 
 // PlaySpecificSong 播放播放列表中指定的歌曲
-func (m *Manager) PlaySpecificSong(songID string) error {
+func (m *Manager) PlaySpecificSong(songID string, actor Actor) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	targetIdx := -1
@@ -208,13 +405,66 @@ func (m *Manager) PlaySpecificSong(songID string) error {
 	if targetIdx == -1 {
 		return errors.New("song not found in playlist")
 	}
+	if err := m.checkContent(m.State.Playlist[targetIdx].Song); err != nil {
+		return err
+	}
+	// 当前正在播放的歌曲重新播放不受冷却限制，也不算切歌
+	if songID != m.State.CurrentSongID {
+		if err := m.checkCooldown(songID, actor); err != nil {
+			return err
+		}
+		if err := m.beginSkipLocked(actor, ""); err != nil {
+			return err
+		}
+		defer func() { m.skipping = nil }()
+	}
 	// 如果点击的就是当前正在放的，且正在播放，是否需要重头开始？
 	// 这里逻辑设定为：直接切歌（也就是重头播放该曲目）
+	m.fadeOutLocked(FadeReasonSkip)
 	m.changeSong(targetIdx)
+	m.fadeInLocked(FadeReasonSkip)
+	m.broadcast()
 	log.Printf("Action: Play specific song, songId: %s", songID)
 	return nil
 }
 
+// PlayFrom 从播放列表的第 index 项开始播放，之后按播放模式继续
+// 与 PlaySpecificSong 不同，按位置而不是歌曲定位，该位置不可播放时从其后第一首可播放的开始
+// 切走当前歌曲算一次切歌，reason 与 NextSong 相同，记录在被切走歌曲的收听记录中
+func (m *Manager) PlayFrom(index int, actor Actor, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if index < 0 || index >= len(m.State.Playlist) {
+		return errors.New("index out of bounds")
+	}
+	targetIdx := -1
+	for i := index; i < len(m.State.Playlist); i++ {
+		if m.isPlayable(m.State.Playlist[i].Song) {
+			targetIdx = i
+			break
+		}
+	}
+	if targetIdx == -1 {
+		return errors.New("no playable song at or after this position")
+	}
+	songID := m.State.Playlist[targetIdx].SongID
+	if songID != m.State.CurrentSongID {
+		if err := m.checkCooldown(songID, actor); err != nil {
+			return err
+		}
+		if err := m.beginSkipLocked(actor, reason); err != nil {
+			return err
+		}
+		defer func() { m.skipping = nil }()
+	}
+	m.fadeOutLocked(FadeReasonSkip)
+	m.changeSong(targetIdx)
+	m.fadeInLocked(FadeReasonSkip)
+	m.broadcast()
+	log.Printf("Action: Play from index %d, songId: %s", targetIdx, songID)
+	return nil
+}
+
 // ReorderPlaylist 修改歌曲在播放列表中的位置
 func (m *Manager) ReorderPlaylist(songID string, newIndex int) error {
 	m.mu.Lock()
@@ -238,6 +488,7 @@ func (m *Manager) ReorderPlaylist(songID string, newIndex int) error {
 		return nil // 位置没变
 	}
 	// 2. 调整 Slice 顺序
+	currentID := m.queueSongID()
 	item := m.State.Playlist[oldIndex]
 	// 先移除
 	tempPlaylist := append(m.State.Playlist[:oldIndex], m.State.Playlist[oldIndex+1:]...)
@@ -250,7 +501,7 @@ func (m *Manager) ReorderPlaylist(songID string, newIndex int) error {
 	m.State.Playlist = newPlaylist
 	// 3. 关键：修正 CurrentPlaylistIdx
 	// 如果被移动的是当前正在播放的歌曲，它的索引变成了 newIndex
-	if m.State.CurrentSongID == songID {
+	if currentID == songID {
 		m.State.CurrentPlaylistIdx = newIndex
 	} else {
 		// 如果被移动的不是当前歌曲，我们需要判断当前歌曲相对于移动操作的位置变化
@@ -263,97 +514,257 @@ func (m *Manager) ReorderPlaylist(songID string, newIndex int) error {
 			m.State.CurrentPlaylistIdx++
 		}
 	}
-	// 4. 更新内存中 Order 字段并准备存库
-	var songIDs []string
-	for i := range m.State.Playlist {
-		m.State.Playlist[i].Order = i
-		songIDs = append(songIDs, m.State.Playlist[i].SongID)
-	}
-	// 5. 更新数据库
-	if err := m.db.UpdatePlaylist(songIDs); err != nil {
+	// 4. 更新 Order 字段并写库
+	if err := m.persistPlaylist(); err != nil {
 		log.Printf("Error updating playlist order in DB: %v", err)
 		// 即使DB失败，内存状态已更新，可以返回错误也可以忽略
 		return err
 	}
-	m.hub.Broadcast(m.State)
+	m.broadcast()
 	log.Printf("Action: Reorder song %s from %d to %d", songID, oldIndex, newIndex)
 	return nil
 }
 
-func (m *Manager) AddToPlaylist(songID string) error {
+// ErrVersionConflict 批量重排时提交的基础版本已过期
+var ErrVersionConflict = errors.New("playlist was modified concurrently, refresh and retry")
+
+// SetPlaylistOrder 按给定的完整顺序原子地重排播放列表，用于拖拽排序
+// baseVersion 必须等于当前的 PlaylistVersion，songIDs 必须恰好包含当前列表中的每首歌
+func (m *Manager) SetPlaylistOrder(songIDs []string, baseVersion int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if baseVersion != m.State.PlaylistVersion {
+		return ErrVersionConflict
+	}
+	if len(songIDs) != len(m.State.Playlist) {
+		return errors.New("ordering must contain every song in the playlist exactly once")
+	}
+	byID := make(map[string]db.PlaylistItem, len(m.State.Playlist))
+	for _, item := range m.State.Playlist {
+		byID[item.SongID] = item
+	}
+	currentID := m.queueSongID()
+	newPlaylist := make([]db.PlaylistItem, 0, len(songIDs))
+	for _, songID := range songIDs {
+		item, ok := byID[songID]
+		if !ok {
+			return errors.New("ordering must contain every song in the playlist exactly once")
+		}
+		delete(byID, songID)
+		newPlaylist = append(newPlaylist, item)
+	}
+	m.State.Playlist = newPlaylist
+	// 修正当前歌曲的索引
+	for i, item := range m.State.Playlist {
+		if item.SongID == currentID {
+			m.State.CurrentPlaylistIdx = i
+			break
+		}
+	}
+	if err := m.persistPlaylist(); err != nil {
+		log.Printf("Error updating playlist order in DB: %v", err)
+		return err
+	}
+	m.broadcast()
+	log.Printf("Action: Playlist reordered (version %d)", m.State.PlaylistVersion)
+	return nil
+}
+
+// AddToPlaylist 将歌曲加入播放列表（FIFO 模式追加到末尾，轮流模式插入到该用户对应的轮次）
+func (m *Manager) AddToPlaylist(songID string, actor Actor) error {
+	_, err := m.AddManyToPlaylist([]string{songID}, actor)
+	return err
+}
+
+// AddManyToPlaylist 一次加入多首歌曲，只写一次数据库、广播一次
+// 任意一首违反规则则整体拒绝，已在列表中的歌曲会被跳过，返回实际加入的数量
+func (m *Manager) AddManyToPlaylist(songIDs []string, actor Actor) (int, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	song, err := m.db.GetSong(songID)
-	if err != nil {
+	items, err := m.prepareItems(songIDs, actor)
+	if err != nil || len(items) == 0 {
+		return 0, err
+	}
+	wasEmpty := len(m.State.Playlist) == 0
+	for _, item := range items {
+		insertAt := len(m.State.Playlist)
+		if m.State.QueueMode == QueueRoundRobin {
+			insertAt = m.fairInsertIdx(actor.Username)
+		}
+		m.insertItemAt(item, insertAt)
+	}
+	m.commitAddedItems(wasEmpty)
+	log.Printf("Action: Add %d song(s) to playlist by %s", len(items), actor.Username)
+	return len(items), nil
+}
+
+// AddToPlaylistAt 将歌曲插入到播放列表的指定位置
+func (m *Manager) AddToPlaylistAt(songID string, index int, actor Actor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if index < 0 || index > len(m.State.Playlist) {
+		return errors.New("index out of bounds")
+	}
+	items, err := m.prepareItems([]string{songID}, actor)
+	if err != nil || len(items) == 0 {
 		return err
 	}
+	wasEmpty := len(m.State.Playlist) == 0
+	m.insertItemAt(items[0], index)
+	m.commitAddedItems(wasEmpty)
+	log.Printf("Action: Add to playlist at %d, songId: %s", index, songID)
+	return nil
+}
 
-	// 检查是否已在播放列表
+// prepareItems 校验并构造待加入的播放列表项，跳过已在列表中或重复的歌曲，调用方需持有锁
+func (m *Manager) prepareItems(songIDs []string, actor Actor) ([]db.PlaylistItem, error) {
+	inPlaylist := make(map[string]bool, len(m.State.Playlist))
 	for _, item := range m.State.Playlist {
-		if item.SongID == songID {
-			return nil // 已存在，不重复添加
+		inPlaylist[item.SongID] = true
+	}
+	var items []db.PlaylistItem
+	for _, songID := range songIDs {
+		if inPlaylist[songID] {
+			continue // 已存在，不重复添加
+		}
+		song, err := m.db.GetSong(songID)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.checkContent(song); err != nil {
+			return nil, err
+		}
+		if err := m.checkCooldown(songID, actor); err != nil {
+			return nil, err
 		}
+		if err := m.checkQueueRules(song, actor); err != nil {
+			return nil, err
+		}
+		if err := m.checkPolicy(song, actor); err != nil {
+			return nil, err
+		}
+		inPlaylist[songID] = true
+		items = append(items, db.PlaylistItem{SongID: songID, AddedBy: actor.Username, Song: song})
 	}
+	return items, nil
+}
 
-	newOrderItem := db.PlaylistItem{
-		SongID: songID,
-		Order:  len(m.State.Playlist),
-		Song:   song,
+// insertItemAt 在内存播放列表中插入一项并修正当前索引，调用方需持有锁
+func (m *Manager) insertItemAt(item db.PlaylistItem, index int) {
+	m.State.Playlist = append(m.State.Playlist[:index], append([]db.PlaylistItem{item}, m.State.Playlist[index:]...)...)
+	if m.State.CurrentSongID != "" && index <= m.State.CurrentPlaylistIdx {
+		m.State.CurrentPlaylistIdx++
 	}
-	m.State.Playlist = append(m.State.Playlist, newOrderItem)
+}
 
-	// 更新数据库
-	var songIDs []string
-	for _, item := range m.State.Playlist {
-		songIDs = append(songIDs, item.SongID)
+// commitAddedItems 重新编号、写库并广播；如果列表原本为空则自动开始播放，调用方需持有锁
+func (m *Manager) commitAddedItems(wasEmpty bool) {
+	// 更新数据库（单个事务）
+	if err := m.persistPlaylist(); err != nil {
+		log.Printf("Error updating playlist in DB: %v", err)
+	}
+	// 如果之前列表为空，自动开始播放
+	if wasEmpty {
+		if idx := m.nextPlayableIdx(-1, 1); idx != -1 {
+			m.changeSong(idx)
+		}
 	}
-	m.db.UpdatePlaylist(songIDs)
+	m.checkQueueLowLocked()
+	m.broadcast()
+}
 
-	// 如果这是第一首歌，自动开始播放
-	if len(m.State.Playlist) == 1 {
-		m.changeSong(0)
+// PendingRequestCount 返回某个用户点的、尚未播放到的歌曲数量
+func (m *Manager) PendingRequestCount(username string) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	count := 0
+	for i, item := range m.State.Playlist {
+		// 当前歌曲及之前的歌曲视为已播放
+		if m.State.CurrentSongID != "" && i <= m.State.CurrentPlaylistIdx {
+			continue
+		}
+		if item.AddedBy == username {
+			count++
+		}
 	}
+	return count
+}
 
-	m.hub.Broadcast(m.State)
-	log.Printf("Action: Add to playlist, songId: %s", songID)
-	return nil
+// ForgetRequester 清除内存播放列表中某个用户的点歌记录（账号删除时调用），之后播放的这些歌曲视为自动播放
+// 数据库中的记录由 db.DeleteUser 负责匿名化
+func (m *Manager) ForgetRequester(username string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changed := false
+	for i := range m.State.Playlist {
+		if m.State.Playlist[i].AddedBy == username {
+			m.State.Playlist[i].AddedBy = ""
+			changed = true
+		}
+	}
+	if changed {
+		m.broadcast()
+	}
 }
 
 // RemoveFromPlaylist removes a song from the playlist and updates the state
-func (m *Manager) RemoveFromPlaylist(songID string) error {
+// 整个过程在一次加锁内完成，客户端只会收到一次广播；移除正在播放的歌曲算一次切歌
+func (m *Manager) RemoveFromPlaylist(songID string, actor Actor) error {
 	m.mu.Lock()
-	isPlayingDeletedSong := m.State.CurrentSong != nil && m.State.CurrentSong.ID == songID
-	m.mu.Unlock()
-
-	if isPlayingDeletedSong {
-		// 调用播放器的 Next 方法切歌
-		// 如果切歌失败（比如列表只有这一首了），Next() 通常会处理为停止播放
-		m.NextSong()
-		// 切歌后，稍微等待一下或确认状态更新，确保 CurrentSong 已经变了
+	defer m.mu.Unlock()
+	if songID == m.State.CurrentSongID && !m.State.PlayingJingle {
+		if err := m.beginSkipLocked(actor, ""); err != nil {
+			return err
+		}
+		defer func() { m.skipping = nil }()
+	}
+	if !m.removeFromPlaylistLocked(songID) {
+		return nil // 不在列表中，无需改动
 	}
-
 	// 从数据库删除
-	if err := m.db.RemoveSongFromPlaylist(songID); err != nil {
+	if err := m.persistPlaylist(); err != nil {
 		return err
 	}
+	m.broadcast()
+	log.Printf("Action: Removed song %s from playlist", songID)
+	return nil
+}
 
-	// 更新内存状态
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	newPlaylist := make([]db.PlaylistItem, 0)
-	for _, item := range m.State.Playlist {
-		// 过滤掉匹配 songID 的项
-		if item.SongID != songID {
-			newPlaylist = append(newPlaylist, item)
+// removeFromPlaylistLocked 从内存播放列表移除歌曲并修正当前索引，返回是否找到该歌曲
+// 如果移除的是当前歌曲，则切到其后第一首可播放的歌曲，没有则停止播放
+// 不写播放列表、不广播，调用方需持有锁
+func (m *Manager) removeFromPlaylistLocked(songID string) bool {
+	removedIdx := -1
+	for i, item := range m.State.Playlist {
+		if item.SongID == songID {
+			removedIdx = i
+			break
 		}
 	}
-	m.State.Playlist = newPlaylist
-
-	// 更新最后修改时间，触发前端同步（假设有相关逻辑）
-	m.State.LastUpdate = time.Now()
+	if removedIdx == -1 {
+		return false
+	}
+	playlist := make([]db.PlaylistItem, 0, len(m.State.Playlist)-1)
+	playlist = append(playlist, m.State.Playlist[:removedIdx]...)
+	m.State.Playlist = append(playlist, m.State.Playlist[removedIdx+1:]...)
 
-	return nil
+	switch {
+	case m.State.PlayingJingle && removedIdx <= m.State.CurrentPlaylistIdx:
+		// 台标播完后从被移除歌曲的前一首之后继续
+		m.State.CurrentPlaylistIdx--
+	case m.State.CurrentSongID == songID:
+		// 原来的下一首现在位于 removedIdx，从它前一个位置开始查找
+		if nextIdx := m.nextPlayableIdx(removedIdx-1, 1); nextIdx != -1 {
+			m.changeSong(nextIdx)
+		} else {
+			m.stopPlayback()
+		}
+	case m.State.CurrentSongID != "" && removedIdx < m.State.CurrentPlaylistIdx:
+		m.State.CurrentPlaylistIdx--
+	}
+	return true
 }
 
 // ShufflePlaylist 随机打乱播放列表
@@ -366,16 +777,17 @@ func (m *Manager) ShufflePlaylist() error {
 	}
 	// 初始化随机数生成器
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	currentID := m.queueSongID()
 	// 使用 Fisher-Yates 算法打乱切片
 	r.Shuffle(length, func(i, j int) {
 		m.State.Playlist[i], m.State.Playlist[j] = m.State.Playlist[j], m.State.Playlist[i]
 	})
 	// 打乱后，必须重新计算当前正在播放歌曲的索引 (CurrentPlaylistIdx)
 	// 否则切歌或暂停逻辑会出错
-	if m.State.CurrentSongID != "" {
+	if currentID != "" {
 		newIdx := -1
 		for i, item := range m.State.Playlist {
-			if item.SongID == m.State.CurrentSongID {
+			if item.SongID == currentID {
 				newIdx = i
 				break
 			}
@@ -389,170 +801,316 @@ func (m *Manager) ShufflePlaylist() error {
 			log.Println("Warning: Current song ID not found after shuffle")
 		}
 	}
-	// 更新内存中每个 Item 的 Order 字段，并准备更新数据库
-	var songIDs []string
-	for i := range m.State.Playlist {
-		m.State.Playlist[i].Order = i
-		songIDs = append(songIDs, m.State.Playlist[i].SongID)
-	}
-	// 更新数据库中的顺序
-	if err := m.db.UpdatePlaylist(songIDs); err != nil {
+	// 更新 Order 字段和数据库中的顺序
+	if err := m.persistPlaylist(); err != nil {
 		log.Printf("Error updating playlist order in DB after shuffle: %v", err)
 		return err
 	}
 	// 广播新状态给前端
-	m.hub.Broadcast(m.State)
+	m.broadcast()
 	log.Println("Action: Playlist shuffled")
 	return nil
 }
 
 // --- 内部辅助方法 ---
 
+// changeSong 切到指定索引的歌曲并持久化，不广播，调用方需持有锁
 func (m *Manager) changeSong(playlistIndex int) {
-	// 这个方法假设锁已经被持有
 	item := m.State.Playlist[playlistIndex]
 	m.State.CurrentPlaylistIdx = playlistIndex
-	m.State.CurrentSongID = item.SongID
-	m.State.CurrentSong = item.Song
-	m.State.ProgressMs = 0
-	m.State.LastUpdate = time.Now()
+	m.startSongLocked(item.Song, item.AddedBy, false)
+	m.markShufflePlayed(item.SongID)
+	m.flashCommentsLocked(item.SongID)
 
-	if !m.State.IsPlaying {
-		m.State.IsPlaying = true
-		m.startProgressTicker()
+	// 记录播放历史，供冷却规则等使用
+	if err := m.db.AddPlayHistory(item.SongID, item.AddedBy, m.sessionIDLocked()); err != nil {
+		log.Printf("Warning: failed to record play history: %v", err)
 	}
+	m.hooks.Fire(hooks.SongChanged, map[string]interface{}{"song": copySong(item.Song), "requestedBy": item.AddedBy})
+	m.checkQueueLowLocked()
+}
+
+// startSongLocked 结束上一首并从头开始播放 song，changeSong 和插入台标共用，调用方需持有锁
+// 台标不在播放列表中，不保存为当前歌曲，重启后从台标之前的那首歌恢复
+func (m *Manager) startSongLocked(song *db.Song, requestedBy string, jingle bool) {
+	fromSongID := m.State.CurrentSongID
+	m.recordScrobbleLocked()
+	m.cancelSeekLocked()
+	m.songStartedAt = time.Now()
+	m.songRequestedBy = requestedBy
+	m.State.CurrentSongID = song.ID
+	m.State.CurrentSong = song
+	m.State.PlayingJingle = jingle
+
+	// 先进入 Loading，缓冲时间结束后才开始计时
+	m.startLoadingLocked()
+	m.startProgressTicker()
+	m.setPosition(0)
+	m.announceSwitchLocked(fromSongID, song)
 
 	// 持久化
-	m.db.SetSystemState("current_song_id", m.State.CurrentSongID)
-	m.db.SetSystemState("progress_ms", "0")
-	m.db.SetSystemState("last_update_unix", strconv.FormatInt(m.State.LastUpdate.Unix(), 10))
-	m.db.SetSystemState("is_playing", "true")
+	if !jingle {
+		m.store.Set("current_song_id", song.ID)
+	}
+	m.persistPosition()
+	m.store.Set("is_playing", "true")
+}
 
-	m.hub.Broadcast(m.State)
+// advance 当前歌曲结束或被跳过时切到下一首，调用方需持有锁
+// 按设置穿插的台标最先，然后投票胜出的歌曲优先，其次是按配置穿插的被遗忘的好歌，然后是策略脚本或播放模式选出的歌曲
+func (m *Manager) advance() {
+	if m.playJingle() {
+		return
+	}
+	if m.playPollWinner() {
+		return
+	}
+	if m.playForgottenGem() {
+		return
+	}
+	if idx := m.policyNextIdx(); idx != -1 {
+		m.changeSong(idx)
+		return
+	}
+	if idx := m.pinnedNextIdx(); idx != -1 {
+		m.changeSong(idx)
+		return
+	}
+	if nextIdx := m.modeNextIdx(); nextIdx != -1 {
+		m.changeSong(nextIdx)
+	} else {
+		m.stopPlayback()
+		m.checkQueueLowLocked()
+	}
 }
 
+// insertAfterCurrent 将歌曲放到当前歌曲之后（已在列表中则移动过去），
+// 持久化并返回其新索引，调用方需持有锁
+func (m *Manager) insertAfterCurrent(item db.PlaylistItem) int {
+	currentID := m.queueSongID()
+	playlist := make([]db.PlaylistItem, 0, len(m.State.Playlist)+1)
+	for _, existing := range m.State.Playlist {
+		if existing.SongID != item.SongID {
+			playlist = append(playlist, existing)
+		} else {
+			item = existing // 沿用已有的行，只移动位置
+		}
+	}
+	// 重新定位当前歌曲（移除重复项后索引可能变化）
+	target := 0
+	for i, existing := range playlist {
+		if existing.SongID == currentID {
+			m.State.CurrentPlaylistIdx = i
+			target = i + 1
+			break
+		}
+	}
+	playlist = append(playlist[:target], append([]db.PlaylistItem{item}, playlist[target:]...)...)
+	m.State.Playlist = playlist
+	if err := m.persistPlaylist(); err != nil {
+		log.Printf("Error updating playlist in DB: %v", err)
+	}
+	return target
+}
+
+// stopPlayback 停止播放并持久化，不广播，调用方需持有锁
 func (m *Manager) stopPlayback() {
-	// 假设锁已被持有
+	m.recordScrobbleLocked()
+	m.cancelSeekLocked()
+	m.songStartedAt = time.Time{}
 	m.stopProgressTicker()
-	m.State.IsPlaying = false
+	m.setStatusLocked(Stopped)
 	m.State.CurrentSongID = ""
 	m.State.CurrentSong = nil
-	m.State.ProgressMs = 0
-
-	m.db.SetSystemState("is_playing", "false")
-	m.db.SetSystemState("current_song_id", "")
-	m.db.SetSystemState("progress_ms", "0")
+	m.State.PlayingJingle = false
+	m.setPosition(0)
 
-	m.hub.Broadcast(m.State)
+	m.store.Set("is_playing", "false")
+	m.store.Set("current_song_id", "")
+	m.store.Set("progress_ms", "0")
 }
 
+// startProgressTicker 播放期间每秒广播一次，让客户端的进度条平滑更新
+// 进度本身由时钟推算，歌曲结束由 endTimer 处理，这里只负责广播
+// 协程只使用自己的 ticker 和 done：Stop 不会关闭 ticker.C，停止时靠关闭 done 让协程退出
 func (m *Manager) startProgressTicker() {
-	if m.ticker != nil {
+	if m.ticker != nil || !m.active {
 		return
 	}
-	m.ticker = time.NewTicker(1 * time.Second)
+	ticker := time.NewTicker(1 * time.Second)
+	done := make(chan struct{})
+	m.ticker, m.tickerDone = ticker, done
 	go func() {
-		for range m.ticker.C {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
 			m.mu.Lock()
-			if !m.State.IsPlaying {
+			select {
+			case <-done:
+				// 等待锁期间已经停止
 				m.mu.Unlock()
 				return
+			default:
 			}
-			m.State.ProgressMs += 1000
-
-			// 如果歌曲结束，自动下一首
-			if m.State.CurrentSong != nil && m.State.ProgressMs >= int64(m.State.CurrentSong.DurationMs) {
-				// 调用内部的next方法，避免死锁
-				if len(m.State.Playlist) > 0 {
-					nextIdx := (m.State.CurrentPlaylistIdx + 1) % len(m.State.Playlist)
-					m.changeSong(nextIdx)
-				} else {
-					m.stopPlayback()
-				}
+			if !m.State.IsPlaying {
+				m.stopProgressTicker()
+				m.mu.Unlock()
+				return
 			}
+			m.broadcast()
 			m.mu.Unlock()
-
-			// 定期广播，减少频率以降低网络负载
-			// 这里我们每秒都广播，以便进度条平滑
-			m.hub.Broadcast(m.State)
 		}
 	}()
 }
 
+// stopProgressTicker 停止进度广播，调用方需持有锁
 func (m *Manager) stopProgressTicker() {
 	if m.ticker != nil {
 		m.ticker.Stop()
-		m.ticker = nil
+		close(m.tickerDone)
+		m.ticker, m.tickerDone = nil, nil
 	}
 }
 
 // RemoveSongFromLibrary 处理从媒体库删除歌曲的逻辑
+// 歌曲只是移入回收站，文件在保留期过后由 API 清理
 func (m *Manager) RemoveSongFromLibrary(songID string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	// 1. 从数据库删除
-	// 我们需要先获取文件路径，以便稍后删除文件
-	_, err := m.db.GetSong(songID)
+	// 1. 移入回收站
+	if err := m.db.TrashSong(songID); err != nil {
+		return fmt.Errorf("failed to delete song from db: %w", err)
+	}
+	// 2. 更新内存中的播放列表状态（与 RemoveFromPlaylist 共用同一套逻辑）
+	if m.removeFromPlaylistLocked(songID) {
+		if err := m.persistPlaylist(); err != nil {
+			log.Printf("Error updating playlist in DB: %v", err)
+		}
+	}
+	m.broadcast()
+	log.Printf("Action: Moved song %s to trash.", songID)
+	return nil
+}
+
+// RemoveSongsFromLibrary 批量把歌曲移入回收站并移出播放列表，只写一次播放列表、广播一次
+func (m *Manager) RemoveSongsFromLibrary(songIDs []string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	removed, err := m.db.TrashSongs(songIDs)
 	if err != nil {
-		return fmt.Errorf("song not found in db: %w", err)
+		return 0, fmt.Errorf("failed to delete songs from db: %w", err)
 	}
-	if err := m.db.DeleteSong(songID); err != nil {
-		return fmt.Errorf("failed to delete song from db: %w", err)
+	changed := false
+	for _, songID := range songIDs {
+		if m.removeFromPlaylistLocked(songID) {
+			changed = true
+		}
 	}
-	// 2. 从文件系统删除
-	// 注意：这里的 filePath 是相对路径，需要拼接
-	// 我们将在 API handler 中处理文件删除，因为它持有 mediaDir 的路径
-	// 3. 更新内存中的播放列表状态
-	var newPlaylist []db.PlaylistItem
-	var wasPlayingRemoved bool
-	var songIDs []string
-	for _, item := range m.State.Playlist {
-		if item.SongID != songID {
-			newPlaylist = append(newPlaylist, item)
-			songIDs = append(songIDs, item.SongID)
-		} else {
-			// 标记被删除的歌曲是否是当前正在播放的
-			if m.State.CurrentSongID == songID {
-				wasPlayingRemoved = true
-			}
+	if changed {
+		if err := m.persistPlaylist(); err != nil {
+			log.Printf("Error updating playlist in DB: %v", err)
 		}
 	}
-	// 如果播放列表发生了变化
-	if len(newPlaylist) != len(m.State.Playlist) {
-		m.State.Playlist = newPlaylist
-		// 更新数据库中的播放列表
-		m.db.UpdatePlaylist(songIDs)
-		if wasPlayingRemoved {
-			// 如果被删除的是当前歌曲，则播放下一首
-			if len(m.State.Playlist) > 0 {
-				// 播放当前索引的歌曲（它现在是新的歌曲了），或者从头开始
-				nextIdx := m.State.CurrentPlaylistIdx
-				if nextIdx >= len(m.State.Playlist) {
-					nextIdx = 0
-				}
-				m.changeSong(nextIdx)
-			} else {
-				// 播放列表空了，停止播放
-				m.stopPlayback()
-			}
-		} else {
-			// 如果删除的不是当前歌曲，只需更新当前播放索引
-			newIdx := -1
-			for i, item := range m.State.Playlist {
-				if item.SongID == m.State.CurrentSongID {
-					newIdx = i
-					break
-				}
+	m.broadcast()
+	log.Printf("Action: Moved %d songs to trash.", removed)
+	return int(removed), nil
+}
+
+// RetagSongs 批量修改歌手、专辑歌手和流派（nil 表示不修改），同步内存中的副本并广播一次
+// 专辑歌手是群星时标记为合辑，其他值会清除合辑标记
+// 改歌手后可能命中黑名单，当前歌曲因此不能播放时会切歌
+func (m *Manager) RetagSongs(songIDs []string, artist, albumArtist, genre *string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	updates := make(map[string]interface{})
+	if artist != nil {
+		normalized := db.NormalizeArtist(*artist)
+		artist = &normalized
+		updates["artist"] = normalized
+	}
+	var compilation bool
+	if albumArtist != nil {
+		normalized, isCompilation := db.AlbumArtistTag(*albumArtist, false)
+		albumArtist, compilation = &normalized, isCompilation
+		updates["album_artist"] = normalized
+		updates["compilation"] = compilation
+	}
+	if genre != nil {
+		// 手动修改的流派不再由自动分类改动
+		updates["genre"] = *genre
+		updates["genre_confidence"] = 0
+		updates["manual_tags"] = true
+	}
+	if len(updates) == 0 {
+		return 0, errors.New("nothing to change, set artist, album artist or genre")
+	}
+	updated, err := m.db.RetagSongs(songIDs, updates)
+	if err != nil {
+		return 0, err
+	}
+	ids := make(map[string]bool, len(songIDs))
+	for _, id := range songIDs {
+		ids[id] = true
+	}
+	retag := func(song *db.Song) {
+		if song == nil || !ids[song.ID] {
+			return
+		}
+		if artist != nil {
+			song.Artist = *artist
+			// 署名由数据库重建，重新读取以获得歌手 ID
+			if fresh, err := m.db.GetSong(song.ID); err == nil {
+				song.Credits = fresh.Credits
 			}
-			m.State.CurrentPlaylistIdx = newIdx
-			m.hub.Broadcast(m.State) // 广播播放列表的变化
 		}
+		if albumArtist != nil {
+			song.AlbumArtist = *albumArtist
+			song.Compilation = compilation
+		}
+		if genre != nil {
+			song.Genre = *genre
+			song.GenreConfidence = 0
+			song.ManualTags = true
+		}
+	}
+	for i := range m.State.Playlist {
+		retag(m.State.Playlist[i].Song)
 	}
-	log.Printf("Action: Removed song %s from library.", songID)
-	// 因为状态可能已在 changeSong 或 stopPlayback 中广播，这里可以不重复广播
-	// 但为了确保，广播一次总是安全的
-	m.hub.Broadcast(m.State)
+	retag(m.State.CurrentSong)
+	log.Printf("Action: Retagged %d songs.", updated)
+	m.skipIfUnplayable()
+	m.broadcast()
+	return int(updated), nil
+}
 
+// RefreshSong 歌曲文件被替换或分析结果更新后重新读取，更新播放列表中的副本
+// 正在播放时保留当前进度，并按新的时长重新安排切歌
+func (m *Manager) RefreshSong(songID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	song, err := m.db.GetSong(songID)
+	if err != nil {
+		return err
+	}
+	for i := range m.State.Playlist {
+		if m.State.Playlist[i].SongID == songID {
+			m.State.Playlist[i].Song = song
+		}
+	}
+	if m.State.CurrentSongID == songID {
+		m.State.CurrentSong = song
+		position := m.positionLocked()
+		if song.DurationMs > 0 && position > int64(song.DurationMs) {
+			position = int64(song.DurationMs)
+		}
+		m.setPosition(position)
+		m.persistPosition()
+	}
+	log.Printf("Action: Refreshed song %s.", songID)
+	m.broadcast()
 	return nil
 }
 
@@ -562,25 +1120,85 @@ func (m *Manager) SeekTo(positionMs int64) error {
 	if m.State.CurrentSong == nil {
 		return fmt.Errorf("no song is currently playing")
 	}
-	// Clamp the position to be within the song's duration
-	if positionMs < 0 {
-		positionMs = 0
+	m.seekLocked(positionMs)
+	return nil
+}
+
+// SetPlaybackRate 设置所有客户端共享的播放速度
+func (m *Manager) SetPlaybackRate(rate float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rate < MinPlaybackRate || rate > MaxPlaybackRate {
+		return fmt.Errorf("playback rate must be between %.1f and %.1f", MinPlaybackRate, MaxPlaybackRate)
 	}
-	// Ensure duration is not zero to avoid division by zero or invalid seek
-	if m.State.CurrentSong.DurationMs > 0 && positionMs > int64(m.State.CurrentSong.DurationMs) {
-		positionMs = int64(m.State.CurrentSong.DurationMs)
+	// 以旧速度结算到现在的进度，之后按新速度计时
+	position := m.positionLocked()
+	m.State.PlaybackRate = rate
+	m.setPosition(position)
+	m.store.Set("playback_rate", strconv.FormatFloat(rate, 'f', -1, 64))
+	m.persistPosition()
+	m.broadcast()
+	log.Printf("Action: Set playback rate to %.2f", rate)
+	return nil
+}
+
+// SeekToChapter 跳转到当前歌曲的指定章节
+func (m *Manager) SeekToChapter(index int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.State.CurrentSong == nil {
+		return fmt.Errorf("no song is currently playing")
 	}
-	m.State.ProgressMs = positionMs
-	m.State.LastUpdate = time.Now()
-	// Persist the new progress and update time
-	if err := m.db.SetSystemState("progress_ms", strconv.FormatInt(positionMs, 10)); err != nil {
-		// Log the error but continue to broadcast, as the in-memory state is updated
-		// log.Printf("Warning: failed to persist seek progress: %v", err)
+	chapters := m.State.CurrentSong.Chapters
+	if index < 0 || index >= len(chapters) {
+		return errors.New("chapter index out of bounds")
+	}
+	m.seekLocked(chapters[index].StartMs)
+	log.Printf("Action: Seek to chapter %d", index)
+	return nil
+}
+
+// chapterRestartThresholdMs 在章节开头这段时间之内按“上一章”会跳到上一章，否则回到本章开头
+const chapterRestartThresholdMs = 3000
+
+// StepChapter 相对当前进度跳转章节，delta 为 1 表示下一章，-1 表示上一章
+func (m *Manager) StepChapter(delta int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.State.CurrentSong == nil {
+		return fmt.Errorf("no song is currently playing")
 	}
-	if err := m.db.SetSystemState("last_update_unix", strconv.FormatInt(m.State.LastUpdate.Unix(), 10)); err != nil {
-		// log.Printf("Warning: failed to persist seek update time: %v", err)
+	chapters := m.State.CurrentSong.Chapters
+	if len(chapters) == 0 {
+		return errors.New("current song has no chapters")
 	}
-	// Broadcast the new state to all clients
-	m.hub.Broadcast(m.State)
+	// 找到当前进度所在的章节
+	position := m.positionLocked()
+	current := 0
+	for i, ch := range chapters {
+		if position >= ch.StartMs {
+			current = i
+		}
+	}
+	target := current + delta
+	// 与常见播放器一致：章节已播放一段时间时，“上一章”先回到本章开头
+	if delta < 0 && position-chapters[current].StartMs > chapterRestartThresholdMs {
+		target = current
+	}
+	if target < 0 || target >= len(chapters) {
+		return errors.New("no more chapters in that direction")
+	}
+	m.seekLocked(chapters[target].StartMs)
+	log.Printf("Action: Step to chapter %d", target)
 	return nil
 }
+
+// seekLocked 设置播放进度并持久化、广播，调用方需持有锁
+func (m *Manager) seekLocked(positionMs int64) {
+	// Clamp the position to be within the song's duration
+	m.setPosition(m.clampPositionLocked(positionMs))
+	// Persist the new progress and update time
+	m.persistPosition()
+	// Broadcast the new state to all clients
+	m.broadcast()
+}
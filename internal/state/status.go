@@ -0,0 +1,90 @@
+package state
+
+import (
+	"time"
+)
+
+// PlaybackStatus 播放状态，比 IsPlaying 更细：客户端据此区分暂停、停止和正在缓冲下一首
+type PlaybackStatus string
+
+const (
+	// Stopped 没有当前歌曲，播放列表为空或已播完
+	Stopped PlaybackStatus = "STOPPED"
+	// Loading 刚切到新歌曲，等待客户端缓冲，进度时钟尚未开始走
+	Loading PlaybackStatus = "LOADING"
+	Playing PlaybackStatus = "PLAYING"
+	Paused  PlaybackStatus = "PAUSED"
+)
+
+// EventPlaybackStatus 播放状态变化时广播，数据为 StatusEvent
+const EventPlaybackStatus = "PLAYBACK_STATUS"
+
+// loadingWindow 切歌后保持 Loading 的时长，留给客户端加载音频，之后所有客户端从同一时刻开始播放
+const loadingWindow = 500 * time.Millisecond
+
+// StatusEvent 是 PLAYBACK_STATUS 事件的数据
+type StatusEvent struct {
+	From   PlaybackStatus `json:"from"`
+	To     PlaybackStatus `json:"to"`
+	SongID string         `json:"songId,omitempty"`
+	// At 状态变化的服务端毫秒时间戳
+	At int64 `json:"at"`
+}
+
+// clockRunning 进度时钟是否在走，只有 Playing 时进度才随时间增长
+func (m *Manager) clockRunning() bool {
+	return m.State.Status == Playing
+}
+
+// setStatusLocked 切换播放状态，同步 IsPlaying 并广播 PLAYBACK_STATUS 事件，调用方需持有锁
+// 不重新安排进度时钟，调用方随后应调用 setPosition
+func (m *Manager) setStatusLocked(status PlaybackStatus) {
+	from := m.State.Status
+	if status != Loading {
+		m.stopLoadingTimer()
+	}
+	m.State.Status = status
+	m.State.IsPlaying = status == Playing || status == Loading
+	if from == status || !m.active {
+		return
+	}
+	m.hub.BroadcastEvent(EventPlaybackStatus, StatusEvent{From: from, To: status, SongID: m.State.CurrentSongID, At: time.Now().UnixMilli()})
+}
+
+// startLoadingLocked 进入 Loading，loadingWindow 之后从当前进度开始播放，调用方需持有锁
+func (m *Manager) startLoadingLocked() {
+	m.setStatusLocked(Loading)
+	m.stopLoadingTimer()
+	if !m.active {
+		return
+	}
+	m.loadingGen++
+	gen := m.loadingGen
+	m.loadingTimer = time.AfterFunc(loadingWindow, func() {
+		m.onLoaded(gen)
+	})
+}
+
+// onLoaded 缓冲时间结束，开始计时播放
+func (m *Manager) onLoaded(gen uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// 期间暂停、停止或又切了歌时作废
+	if gen != m.loadingGen || m.State.Status != Loading {
+		return
+	}
+	m.loadingTimer = nil
+	m.setStatusLocked(Playing)
+	m.setPosition(m.anchorMs)
+	m.persistPosition()
+	m.broadcast()
+}
+
+// stopLoadingTimer 取消等待中的缓冲定时器，已经触发但还在等锁的回调靠代数判断自行作废
+func (m *Manager) stopLoadingTimer() {
+	if m.loadingTimer != nil {
+		m.loadingTimer.Stop()
+		m.loadingTimer = nil
+	}
+	m.loadingGen++
+}
@@ -0,0 +1,53 @@
+package state
+
+import (
+	"errors"
+	"log"
+)
+
+// EventPlaybackTransferred 发声的设备改变时广播，数据为 PlaybackTransfer
+const EventPlaybackTransferred = "PLAYBACK_TRANSFERRED"
+
+// PlaybackTransfer 一次播放转移，ToDeviceID 为空表示恢复所有输出设备一起发声
+type PlaybackTransfer struct {
+	FromDeviceID string `json:"fromDeviceId,omitempty"`
+	ToDeviceID   string `json:"toDeviceId,omitempty"`
+	// ProgressMs 转移时的播放进度，目标设备从这里接着播放
+	ProgressMs int64  `json:"progressMs"`
+	By         string `json:"by"`
+}
+
+// TransferPlayback 把发声的角色交给指定的输出设备，例如从笔记本的耳机换到客厅的音箱
+// 其他输出设备继续同步播放但保持静音，所以切换时不需要重新缓冲；deviceID 为空时恢复所有输出设备一起发声
+// 发声的设备断开后仍然保留，重新连接（例如刷新页面）后继续发声
+func (m *Manager) TransferPlayback(deviceID string, actor Actor) (PlaybackTransfer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if deviceID != "" {
+		device, ok := m.devices[deviceID]
+		if !ok {
+			return PlaybackTransfer{}, errors.New("device not connected")
+		}
+		if device.Role != DeviceOutput {
+			return PlaybackTransfer{}, errors.New("device is not an output device")
+		}
+	}
+	transfer := PlaybackTransfer{
+		FromDeviceID: m.State.ActiveOutputID,
+		ToDeviceID:   deviceID,
+		ProgressMs:   m.positionLocked(),
+		By:           actor.Username,
+	}
+	if transfer.FromDeviceID == deviceID {
+		return transfer, nil
+	}
+	m.State.ActiveOutputID = deviceID
+	m.hub.BroadcastEvent(EventPlaybackTransferred, transfer)
+	m.broadcast()
+	if deviceID == "" {
+		log.Printf("Action: %s returned playback to all output devices", actor.Username)
+	} else {
+		log.Printf("Action: %s transferred playback to device %s", actor.Username, deviceID)
+	}
+	return transfer, nil
+}
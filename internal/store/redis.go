@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// DefaultSnapshotInterval 未配置时快照回 SQLite 的间隔
+const DefaultSnapshotInterval = 30 * time.Second
+
+// RedisStore 把运行时状态保存在 Redis 哈希中，写入不涉及磁盘 I/O
+// 多个实例共享同一个 Redis 时，新的主实例可以直接接着上一个主实例的进度
+type RedisStore struct {
+	rdb  *redis.Client
+	key  string
+	db   *db.DB
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// NewRedis 创建 Redis 存储并开始定期快照，interval 为 0 时使用默认值
+func NewRedis(rdb *redis.Client, prefix string, database *db.DB, interval time.Duration) *RedisStore {
+	if interval <= 0 {
+		interval = DefaultSnapshotInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &RedisStore{
+		rdb:  rdb,
+		key:  prefix + ":state",
+		db:   database,
+		stop: cancel,
+		done: make(chan struct{}),
+	}
+	go s.runSnapshots(ctx, interval)
+	return s
+}
+
+// Get 优先读 Redis，Redis 中没有时读 SQLite 快照，便于从单机部署迁移
+func (s *RedisStore) Get(key string) (string, error) {
+	value, err := s.rdb.HGet(context.Background(), s.key, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return s.db.GetSystemState(key)
+	}
+	return value, err
+}
+
+func (s *RedisStore) Set(key, value string) error {
+	return s.rdb.HSet(context.Background(), s.key, key, value).Err()
+}
+
+// Close 停止定期快照并做最后一次快照
+func (s *RedisStore) Close() error {
+	s.stop()
+	<-s.done
+	return s.Snapshot()
+}
+
+// Snapshot 把 Redis 中的全部状态写回 SQLite，Redis 数据丢失时可以从快照恢复
+func (s *RedisStore) Snapshot() error {
+	values, err := s.rdb.HGetAll(context.Background(), s.key).Result()
+	if err != nil {
+		return err
+	}
+	return s.db.SetSystemStates(values)
+}
+
+func (s *RedisStore) runSnapshots(ctx context.Context, interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Snapshot(); err != nil {
+				log.Printf("Failed to snapshot state to SQLite: %v", err)
+			}
+		}
+	}
+}
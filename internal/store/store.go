@@ -0,0 +1,38 @@
+// Package store 保存播放进度、当前歌曲等运行时状态
+// 默认直接写 SQLite；配置 Redis 后写入 Redis，并定期快照回 SQLite
+package store
+
+import (
+	"github.com/yeeeck/sync-jukebox/internal/db"
+)
+
+// Store 运行时状态的键值存储
+type Store interface {
+	// Get 读取一个键，不存在时返回空字符串
+	Get(key string) (string, error)
+	Set(key, value string) error
+	// Close 关闭存储，有缓冲的实现在这里写回剩余数据
+	Close() error
+}
+
+// SQLiteStore 直接读写数据库的 system_states 表
+type SQLiteStore struct {
+	db *db.DB
+}
+
+// NewSQLite 创建基于数据库的存储
+func NewSQLite(database *db.DB) *SQLiteStore {
+	return &SQLiteStore{db: database}
+}
+
+func (s *SQLiteStore) Get(key string) (string, error) {
+	return s.db.GetSystemState(key)
+}
+
+func (s *SQLiteStore) Set(key, value string) error {
+	return s.db.SetSystemState(key, value)
+}
+
+func (s *SQLiteStore) Close() error {
+	return nil
+}
@@ -0,0 +1,123 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// Backend 在多个服务实例之间转发消息，使连接在任意实例上的客户端都能收到广播
+type Backend interface {
+	// PublishBroadcast 发布一条广播帧，所有实例（包括自己）都会通过 Run 收到并推送给本地客户端
+	PublishBroadcast(data []byte, state bool) error
+	// PublishInbound 把本地客户端的上行消息转发给主实例处理
+	PublishInbound(msg InboundMessage) error
+	// Run 接收其他实例发布的消息，直到 ctx 结束
+	Run(ctx context.Context, onBroadcast func(data []byte, state bool), onInbound func(InboundMessage)) error
+}
+
+// InboundMessage 是从其他实例转发过来的客户端上行消息或断开通知
+type InboundMessage struct {
+	ClientID   string          `json:"clientId"`
+	Username   string          `json:"username"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	Disconnect bool            `json:"disconnect,omitempty"`
+}
+
+// clusterState 是 Hub 在多实例模式下的附加状态
+type clusterState struct {
+	backend Backend
+	// forward 为 true 时上行消息转发给主实例，而不是在本地处理
+	forward atomic.Bool
+
+	mu        sync.RWMutex
+	lastState []byte // 最近收到的完整状态帧，从实例用它作为新连接的初始状态
+}
+
+// UseBackend 启用多实例广播，需在接受连接之前调用
+func (h *Hub) UseBackend(ctx context.Context, backend Backend) {
+	h.cluster = &clusterState{backend: backend}
+	go func() {
+		err := backend.Run(ctx, h.deliver, h.handleInbound)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Hub backend stopped: %v", err)
+		}
+	}()
+}
+
+// SetForwardInbound 设置是否把上行消息转发给主实例（从实例为 true）
+func (h *Hub) SetForwardInbound(forward bool) {
+	if h.cluster != nil {
+		h.cluster.forward.Store(forward)
+	}
+}
+
+// LastState 返回最近广播的完整状态，尚未收到时返回 nil
+func (h *Hub) LastState() interface{} {
+	if h.cluster == nil {
+		return nil
+	}
+	h.cluster.mu.RLock()
+	defer h.cluster.mu.RUnlock()
+	if h.cluster.lastState == nil {
+		return nil
+	}
+	return json.RawMessage(h.cluster.lastState)
+}
+
+// deliver 把一条广播帧推送给本地客户端，事件帧先编号并写入补发缓冲区
+func (h *Hub) deliver(data []byte, state bool) {
+	if state {
+		if h.cluster != nil {
+			h.cluster.mu.Lock()
+			h.cluster.lastState = data
+			h.cluster.mu.Unlock()
+		}
+		h.broadcast <- frame{data: data, state: true}
+		return
+	}
+	// 编号和交给事件循环在同一把锁内完成，客户端收到的序号才是递增的
+	h.replay.mu.Lock()
+	defer h.replay.mu.Unlock()
+	h.broadcast <- frame{data: h.replay.record(data)}
+}
+
+// handleInbound 在主实例上处理其他实例转发的上行消息
+func (h *Hub) handleInbound(msg InboundMessage) {
+	if h.cluster.forward.Load() {
+		return // 只有主实例处理
+	}
+	client := &Client{hub: h, id: msg.ClientID, username: msg.Username}
+	if msg.Disconnect {
+		h.callDisconnect(client)
+		return
+	}
+	h.callMessage(client, msg.Data)
+}
+
+// dispatchMessage 处理本地客户端的上行消息，从实例转发给主实例
+func (h *Hub) dispatchMessage(c *Client, message []byte) {
+	if h.cluster != nil && h.cluster.forward.Load() {
+		if !json.Valid(message) {
+			return
+		}
+		if err := h.cluster.backend.PublishInbound(InboundMessage{ClientID: c.id, Username: c.username, Data: message}); err != nil {
+			log.Printf("Failed to forward client message: %v", err)
+		}
+		return
+	}
+	h.callMessage(c, message)
+}
+
+// dispatchDisconnect 处理本地客户端断开，从实例转发给主实例
+func (h *Hub) dispatchDisconnect(c *Client) {
+	if h.cluster != nil && h.cluster.forward.Load() {
+		if err := h.cluster.backend.PublishInbound(InboundMessage{ClientID: c.id, Username: c.username, Disconnect: true}); err != nil {
+			log.Printf("Failed to forward client disconnect: %v", err)
+		}
+		return
+	}
+	h.callDisconnect(c)
+}
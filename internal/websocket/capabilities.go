@@ -0,0 +1,179 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"log"
+	"reflect"
+	"time"
+)
+
+// 客户端连接后可以发送 {"type":"HELLO","capabilities":[...],"client":"名称/版本"} 声明支持的功能，
+// 服务端以 WELCOME 事件回复实际启用的功能；不发送 HELLO 的旧客户端按原来的方式收到所有消息
+const (
+	msgTypeHello = "HELLO"
+	EventWelcome = "WELCOME"
+	// EventStatePatch 启用 delta 后，除第一帧外的完整状态改为发送相对上一帧的 JSON Merge Patch（RFC 7396）
+	EventStatePatch = "STATE_PATCH"
+)
+
+// 客户端可以声明的功能
+const (
+	// CapDelta 完整状态以 STATE_PATCH 增量发送
+	CapDelta = "delta"
+	// CapBinary 所有消息以二进制帧发送，内容为 gzip 压缩的 JSON
+	CapBinary = "binary"
+	// CapChat 接收聊天、表情等互动事件，见 RequireCapability
+	CapChat = "chat"
+	// CapSoundboard 接收音效板事件并把片段叠加在当前歌曲上播放，不发声的客户端不必声明
+	CapSoundboard = "soundboard"
+	// CapHLSLossless 可以播放无损 HLS，本服务器尚不提供无损转码，不会启用
+	CapHLSLossless = "hls-lossless"
+)
+
+// supportedCapabilities 本服务器能够按客户端定制的功能
+var supportedCapabilities = map[string]bool{CapDelta: true, CapBinary: true, CapChat: true, CapSoundboard: true}
+
+// Welcome 是 WELCOME 事件的数据
+type Welcome struct {
+	ClientID     string   `json:"clientId"`
+	Capabilities []string `json:"capabilities"`
+	// LastSeq 当前的事件序号，客户端断线重连后用它发送 RESUME，见 resume.go
+	LastSeq int64 `json:"lastSeq"`
+}
+
+// RequireCapability 指定某类事件只发给声明了 capability 的客户端，旧客户端不会收到不认识的事件
+// 需在广播该事件之前调用，通常在启动时
+func (h *Hub) RequireCapability(eventType, capability string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.eventCapabilities == nil {
+		h.eventCapabilities = make(map[string]string)
+	}
+	h.eventCapabilities[eventType] = capability
+}
+
+// requiredCapability 返回事件帧需要的功能，不需要时返回空字符串，调用方需持有 h.mu
+func (h *Hub) requiredCapability(f frame) string {
+	if f.state || len(h.eventCapabilities) == 0 {
+		return ""
+	}
+	var event struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(f.data, &event) != nil {
+		return ""
+	}
+	return h.eventCapabilities[event.Type]
+}
+
+// allowed 返回客户端是否应收到该帧
+func (h *Hub) allowed(c *Client, f frame) bool {
+	h.mu.RLock()
+	required := h.requiredCapability(f)
+	h.mu.RUnlock()
+	return required == "" || c.HasCapability(required)
+}
+
+// HasCapability 返回客户端是否声明并启用了某项功能
+func (c *Client) HasCapability(capability string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.capabilities[capability]
+}
+
+// handleHello 处理客户端的 HELLO 消息，不是 HELLO 时返回 false
+func (c *Client) handleHello(message []byte) bool {
+	var msg struct {
+		Type         string   `json:"type"`
+		Capabilities []string `json:"capabilities"`
+		Client       string   `json:"client"`
+	}
+	if err := json.Unmarshal(message, &msg); err != nil || msg.Type != msgTypeHello {
+		return false
+	}
+	welcome := Welcome{ClientID: c.id, Capabilities: []string{}, LastSeq: c.hub.replay.lastSeq()}
+	capabilities := make(map[string]bool)
+	for _, capability := range msg.Capabilities {
+		if supportedCapabilities[capability] && !capabilities[capability] {
+			capabilities[capability] = true
+			welcome.Capabilities = append(welcome.Capabilities, capability)
+		}
+	}
+	data, err := json.Marshal(Event{Type: EventWelcome, Data: welcome, ServerTime: time.Now().UnixMilli()})
+	if err != nil {
+		return true
+	}
+	c.mu.Lock()
+	// WELCOME 放到队首，它和之后的消息都按新的功能发送
+	c.events = append([][]byte{data}, c.events...)
+	c.capabilities = capabilities
+	// 下一帧完整状态不做增量，客户端从它开始应用 STATE_PATCH
+	c.sentState = nil
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	log.Printf("Client %s (%s) enabled capabilities %v", c.id, msg.Client, welcome.Capabilities)
+	return true
+}
+
+// stateMessageLocked 按客户端的功能把完整状态转换为要发送的消息，没有变化时返回 nil，调用方需持有 c.mu
+func (c *Client) stateMessageLocked(state []byte) []byte {
+	if !c.capabilities[CapDelta] {
+		return state
+	}
+	var current map[string]interface{}
+	if json.Unmarshal(state, &current) != nil {
+		return state
+	}
+	previous := c.sentState
+	c.sentState = current
+	if previous == nil {
+		return state
+	}
+	patch := mergePatch(previous, current)
+	if len(patch) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(Event{Type: EventStatePatch, Data: patch, ServerTime: time.Now().UnixMilli()})
+	if err != nil {
+		return state
+	}
+	return data
+}
+
+// mergePatch 计算从 from 到 to 的 JSON Merge Patch：删除的字段为 null，对象逐层比较，数组整体替换
+func mergePatch(from, to map[string]interface{}) map[string]interface{} {
+	patch := make(map[string]interface{})
+	for key := range from {
+		if _, ok := to[key]; !ok {
+			patch[key] = nil
+		}
+	}
+	for key, value := range to {
+		old, ok := from[key]
+		if ok && reflect.DeepEqual(old, value) {
+			continue
+		}
+		oldObject, oldIsObject := old.(map[string]interface{})
+		newObject, newIsObject := value.(map[string]interface{})
+		if ok && oldIsObject && newIsObject {
+			patch[key] = mergePatch(oldObject, newObject)
+			continue
+		}
+		patch[key] = value
+	}
+	return patch
+}
+
+// encodeBinary 把消息压缩为 gzip，供声明了 binary 的客户端以二进制帧接收
+func encodeBinary(message []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	w.Write(message)
+	w.Close()
+	return buf.Bytes()
+}
@@ -5,10 +5,25 @@ import (
 	"log"
 	"net/http"
 	"sync"
+	"time"
 
+	"github.com/gofrs/uuid"
 	"github.com/gorilla/websocket"
 )
 
+const (
+	// writeWait 单次写入的超时时间
+	writeWait = 10 * time.Second
+	// pongWait 等待客户端 pong 的时间，超时视为断开
+	pongWait = 60 * time.Second
+	// pingPeriod 发送 ping 的间隔，必须小于 pongWait
+	pingPeriod = pongWait * 9 / 10
+	// maxMessageSize 上行消息的最大字节数
+	maxMessageSize = 4096
+	// maxQueuedEvents 每个客户端最多积压的事件数，超过后断开该客户端
+	maxQueuedEvents = 256
+)
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -16,64 +31,172 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
+// Event 是推送给客户端的事件消息
+// 完整状态消息没有 type 字段，客户端据此区分两类消息；广播的事件发送时加上递增的 seq 字段，见 resume.go
+type Event struct {
+	Type       string      `json:"type"`
+	Data       interface{} `json:"data,omitempty"`
+	ServerTime int64       `json:"serverTime"` // 服务端毫秒时间戳
+}
+
+// frame 是一条待发送的消息
+// 完整状态帧可以被更新的状态覆盖，事件帧必须按顺序送达
+type frame struct {
+	data  []byte
+	state bool
+}
+
 // Client 是一个websocket连接的封装
 type Client struct {
-	hub  *Hub
-	conn *websocket.Conn
-	send chan []byte
+	hub      *Hub
+	conn     *websocket.Conn
+	id       string // 连接 ID，每次连接唯一
+	username string // 已认证的用户名，匿名连接为空
+
+	// 发送队列：事件排队发送，状态帧只保留最新的一帧，
+	// 慢客户端因此只会跳过中间的进度更新而不会丢事件
+	mu     sync.Mutex
+	events [][]byte
+	latest []byte
+	// rtts 最近的往返时间样本，见 latency.go
+	rtts []time.Duration
+	// snapshot 获取完整状态，RESUME 无法补发时重新发送，见 resume.go
+	snapshot func() interface{}
+	// capabilities 客户端在 HELLO 中声明并启用的功能，sentState 是启用 delta 后上一次发送的完整状态，见 capabilities.go
+	capabilities map[string]bool
+	sentState    map[string]interface{}
+	wake         chan struct{} // 有新消息时唤醒 writePump
+	done         chan struct{} // 关闭后 writePump 退出
+	once         sync.Once
+}
+
+// ID 返回连接的唯一 ID
+func (c *Client) ID() string {
+	return c.id
+}
+
+// Username 返回连接对应的用户名，匿名连接返回空字符串
+func (c *Client) Username() string {
+	return c.username
 }
 
 // Hub 维护了所有活跃的客户端，并向他们广播消息
 type Hub struct {
 	clients    map[*Client]bool
-	broadcast  chan []byte
+	broadcast  chan frame
 	register   chan *Client
 	unregister chan *Client
 	mu         sync.RWMutex
+	// onMessage 处理客户端上行消息，为 nil 时丢弃所有消息
+	onMessage func(client *Client, message []byte)
+	// onDisconnect 在客户端断开时调用
+	onDisconnect func(client *Client)
+	// cluster 多实例模式下的广播后端，单实例时为 nil
+	cluster *clusterState
+	// onPanic 处理协程中捕获的 panic，见 recover.go
+	onPanic PanicHandler
+	// replay 最近广播的事件，断线重连的客户端据此补发，见 resume.go
+	replay *replayBuffer
+	// eventCapabilities 只发给声明了相应功能的客户端的事件类型，见 capabilities.go
+	eventCapabilities map[string]string
 }
 
 func NewHub() *Hub {
 	return &Hub{
-		broadcast:  make(chan []byte),
+		broadcast:  make(chan frame),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		clients:    make(map[*Client]bool),
+		replay:     newReplayBuffer(),
 	}
 }
 
-// Run 启动Hub的事件循环
+// Run 启动Hub的事件循环，循环中发生 panic 时重新启动
 func (h *Hub) Run() {
+	for {
+		h.loop()
+	}
+}
+
+func (h *Hub) loop() {
+	defer h.recoverPanic("websocket hub")
 	for {
 		select {
 		case client := <-h.register:
-			h.mu.Lock()
-			h.clients[client] = true
-			h.mu.Unlock()
+			h.addClient(client)
 			log.Println("New client registered")
 		case client := <-h.unregister:
-			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
+			if h.removeClient(client) {
 				log.Println("Client unregistered")
 			}
-			h.mu.Unlock()
-		case message := <-h.broadcast:
-			h.mu.RLock()
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					// 如果发送缓冲区已满，则关闭连接
-					close(client.send)
-					delete(h.clients, client)
-				}
-			}
-			h.mu.RUnlock()
+		case f := <-h.broadcast:
+			h.fanOut(f)
 		}
 	}
 }
 
+// 以下几个方法用 defer 解锁，事件循环 panic 后重新启动时锁不会一直被占用
+
+func (h *Hub) addClient(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[client] = true
+}
+
+func (h *Hub) removeClient(client *Client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.removeLocked(client)
+}
+
+func (h *Hub) fanOut(f frame) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	required := h.requiredCapability(f)
+	for client := range h.clients {
+		if required != "" && !client.HasCapability(required) {
+			continue
+		}
+		if !client.enqueue(f) {
+			// 事件积压过多，说明客户端跟不上，断开它让其重连后重新获取完整状态
+			log.Printf("Evicting slow client %s", client.id)
+			h.removeLocked(client)
+		}
+	}
+}
+
+// removeLocked 移除客户端并通知其写协程退出，返回客户端是否仍在列表中，调用方需持有 h.mu
+func (h *Hub) removeLocked(client *Client) bool {
+	if _, ok := h.clients[client]; !ok {
+		return false
+	}
+	delete(h.clients, client)
+	client.close()
+	return true
+}
+
+// ClientCount 返回当前连接到本实例的客户端数量
+func (h *Hub) ClientCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
+// OnMessage 设置客户端上行消息的处理函数，需在接受连接之前调用
+func (h *Hub) OnMessage(handler func(client *Client, message []byte)) {
+	h.onMessage = handler
+}
+
+// OnDisconnect 设置客户端断开时的回调，需在接受连接之前调用
+func (h *Hub) OnDisconnect(handler func(client *Client)) {
+	h.onDisconnect = handler
+}
+
+// BroadcastEvent 广播一个带类型的事件
+func (h *Hub) BroadcastEvent(eventType string, data interface{}) {
+	h.Broadcast(Event{Type: eventType, Data: data, ServerTime: time.Now().UnixMilli()})
+}
+
 // Broadcast 广播消息给所有客户端
 func (h *Hub) Broadcast(message interface{}) {
 	jsonMsg, err := json.Marshal(message)
@@ -81,17 +204,35 @@ func (h *Hub) Broadcast(message interface{}) {
 		log.Printf("Error marshalling broadcast message: %v", err)
 		return
 	}
-	h.broadcast <- jsonMsg
+	_, isEvent := message.(Event)
+	if h.cluster != nil {
+		// 经由后端发布，所有实例（包括自己）收到后再推送给本地客户端
+		err := h.cluster.backend.PublishBroadcast(jsonMsg, !isEvent)
+		if err == nil {
+			return
+		}
+		log.Printf("Failed to publish broadcast, delivering locally only: %v", err)
+	}
+	h.deliver(jsonMsg, !isEvent)
 }
 
-// ServeWs 处理websocket请求
-func (h *Hub) ServeWs(w http.ResponseWriter, r *http.Request, onConnect func() interface{}) {
+// ServeWs 处理websocket请求，username 为已认证的用户名（可为空）
+func (h *Hub) ServeWs(w http.ResponseWriter, r *http.Request, username string, onConnect func() interface{}) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	client := &Client{hub: h, conn: conn, send: make(chan []byte, 256)}
+	clientID, _ := uuid.NewV4()
+	client := &Client{
+		hub:      h,
+		conn:     conn,
+		id:       clientID.String(),
+		username: username,
+		snapshot: onConnect,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
 	h.register <- client
 
 	// 当新客户端连接时，立即发送当前状态
@@ -99,33 +240,133 @@ func (h *Hub) ServeWs(w http.ResponseWriter, r *http.Request, onConnect func() i
 	if initialState != nil {
 		jsonState, err := json.Marshal(initialState)
 		if err == nil {
-			client.send <- jsonState
+			client.enqueue(frame{data: jsonState, state: true})
 		}
 	}
 
 	go client.writePump()
-	// 我们主要通过HTTP API控制，所以readPump可以很简单
+	// 控制主要通过HTTP API，上行消息只用于投票等轻量交互
 	go client.readPump()
 }
 
+// enqueue 把消息放入发送队列，事件积压超过上限时返回 false
+func (c *Client) enqueue(f frame) bool {
+	c.mu.Lock()
+	if f.state {
+		c.latest = f.data
+	} else {
+		if len(c.events) >= maxQueuedEvents {
+			c.mu.Unlock()
+			return false
+		}
+		c.events = append(c.events, f.data)
+	}
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// drain 取出所有待发送的消息，事件在前，最新的状态帧在最后，状态帧带上该客户端的播放提前量
+// binary 为 true 时消息已压缩，应以二进制帧发送
+func (c *Client) drain() (messages [][]byte, binary bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	messages = c.events
+	if c.latest != nil {
+		if state := c.stateMessageLocked(withLatencyOffset(c.latest, c.latencyLocked().OffsetMs)); state != nil {
+			messages = append(messages, state)
+		}
+	}
+	c.events = nil
+	c.latest = nil
+	if c.capabilities[CapBinary] {
+		for i, message := range messages {
+			messages[i] = encodeBinary(message)
+		}
+		return messages, true
+	}
+	return messages, false
+}
+
+// close 通知 writePump 退出，可重复调用
+func (c *Client) close() {
+	c.once.Do(func() { close(c.done) })
+}
+
 func (c *Client) readPump() {
+	defer c.hub.recoverPanic("websocket read")
 	defer func() {
+		c.hub.dispatchDisconnect(c)
 		c.hub.unregister <- c
 		c.conn.Close()
 	}()
-	// 简单地丢弃所有收到的消息，只用于检测连接是否断开
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(payload string) error {
+		if c.recordPong(payload) == calibrationPings {
+			c.sendCalibrated()
+		}
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	// 收到的消息交给 onMessage 处理，同时用于检测连接是否断开
 	for {
-		if _, _, err := c.conn.ReadMessage(); err != nil {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
 			break
 		}
+		// HELLO 和 RESUME 由 Hub 处理，不交给 onMessage
+		if c.handleHello(message) || c.handleResume(message) {
+			continue
+		}
+		c.hub.dispatchMessage(c, message)
 	}
 }
 
 func (c *Client) writePump() {
-	defer c.conn.Close()
-	for message := range c.send {
-		if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+	ticker := time.NewTicker(pingPeriod)
+	// 连接建立后先连续发送几次 ping 测量往返时间
+	calibration := time.NewTicker(calibrationInterval)
+	calibrationLeft := calibrationPings
+	defer c.hub.recoverPanic("websocket write")
+	defer func() {
+		ticker.Stop()
+		calibration.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case <-calibration.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, pingPayload()); err != nil {
+				return
+			}
+			if calibrationLeft--; calibrationLeft == 0 {
+				calibration.Stop()
+			}
+		case <-c.done:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 			return
+		case <-c.wake:
+			messages, binary := c.drain()
+			messageType := websocket.TextMessage
+			if binary {
+				messageType = websocket.BinaryMessage
+			}
+			for _, message := range messages {
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := c.conn.WriteMessage(messageType, message); err != nil {
+					return
+				}
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, pingPayload()); err != nil {
+				return
+			}
 		}
 	}
 }
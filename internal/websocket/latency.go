@@ -0,0 +1,93 @@
+package websocket
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// calibrationPings 连接建立后连续发送的测量 ping 数
+	calibrationPings = 5
+	// calibrationInterval 测量 ping 之间的间隔
+	calibrationInterval = 200 * time.Millisecond
+	// maxRTTSamples 保留最近的往返时间样本数，之后的保活 ping 继续更新
+	maxRTTSamples = 10
+)
+
+// EventLatencyCalibrated 测量完成后只发给该客户端的事件
+const EventLatencyCalibrated = "LATENCY_CALIBRATED"
+
+// LatencyInfo 客户端的往返时间和建议的播放提前量
+type LatencyInfo struct {
+	RTTMs int64 `json:"rttMs"`
+	// OffsetMs 状态消息到达客户端时已经过去的时间（单程延迟），客户端应在进度上加上它，
+	// 即提前这么多开始播放 HLS，才能与其他客户端对齐
+	OffsetMs int64 `json:"offsetMs"`
+}
+
+// pingPayload 测量 ping 携带发送时间，浏览器会原样放进 pong 中返回，前端无需任何代码
+func pingPayload() []byte {
+	return []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+}
+
+// recordPong 根据 pong 中的发送时间记录一次往返时间，返回样本数
+func (c *Client) recordPong(payload string) int {
+	sent, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		return 0
+	}
+	rtt := time.Since(time.Unix(0, sent))
+	if rtt < 0 || rtt > pongWait {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rtts = append(c.rtts, rtt)
+	if len(c.rtts) > maxRTTSamples {
+		c.rtts = c.rtts[len(c.rtts)-maxRTTSamples:]
+	}
+	return len(c.rtts)
+}
+
+// Latency 返回测得的往返时间中位数及建议的提前量，尚未测量时返回零值
+// 用中位数而不是平均值，偶尔一次 Wi-Fi 重传不会拉偏结果
+func (c *Client) Latency() LatencyInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.latencyLocked()
+}
+
+func (c *Client) latencyLocked() LatencyInfo {
+	if len(c.rtts) == 0 {
+		return LatencyInfo{}
+	}
+	samples := append([]time.Duration(nil), c.rtts...)
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	median := samples[len(samples)/2]
+	return LatencyInfo{RTTMs: median.Milliseconds(), OffsetMs: (median / 2).Milliseconds()}
+}
+
+// sendCalibrated 测量完成后把结果发给该客户端
+func (c *Client) sendCalibrated() {
+	data, err := json.Marshal(Event{Type: EventLatencyCalibrated, Data: c.Latency(), ServerTime: time.Now().UnixMilli()})
+	if err == nil {
+		c.enqueue(frame{data: data})
+	}
+}
+
+// withLatencyOffset 在完整状态消息中加入该客户端的 latencyOffsetMs 字段
+// 状态帧由所有客户端共享，这里在发送时拼接，不重新序列化
+func withLatencyOffset(state []byte, offsetMs int64) []byte {
+	if offsetMs <= 0 || len(state) < 2 || state[0] != '{' {
+		return state
+	}
+	out := make([]byte, 0, len(state)+32)
+	out = append(out, `{"latencyOffsetMs":`...)
+	out = strconv.AppendInt(out, offsetMs, 10)
+	if state[1] != '}' {
+		out = append(out, ',')
+	}
+	return append(out, state[1:]...)
+}
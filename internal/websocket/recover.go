@@ -0,0 +1,47 @@
+package websocket
+
+import (
+	"log"
+	"runtime/debug"
+)
+
+// PanicHandler 处理 Hub 协程中捕获的 panic，source 为发生的位置
+type PanicHandler func(source string, value interface{}, stack []byte)
+
+// OnPanic 设置 Hub 协程中发生 panic 时的回调，需在 Run 之前调用，未设置时只写日志
+// 发生 panic 的连接被断开，事件循环重新启动，其他连接不受影响
+func (h *Hub) OnPanic(handler PanicHandler) {
+	h.onPanic = handler
+}
+
+// recoverPanic 以 defer h.recoverPanic("位置") 调用
+func (h *Hub) recoverPanic(source string) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	stack := debug.Stack()
+	if h.onPanic != nil {
+		h.onPanic(source, v, stack)
+		return
+	}
+	log.Printf("Panic recovered in %s: %v\n%s", source, v, stack)
+}
+
+// callMessage 调用上行消息的处理函数，处理函数 panic 时只丢弃这条消息
+func (h *Hub) callMessage(c *Client, message []byte) {
+	if h.onMessage == nil {
+		return
+	}
+	defer h.recoverPanic("websocket message")
+	h.onMessage(c, message)
+}
+
+// callDisconnect 调用断开连接的回调
+func (h *Hub) callDisconnect(c *Client) {
+	if h.onDisconnect == nil {
+		return
+	}
+	defer h.recoverPanic("websocket disconnect")
+	h.onDisconnect(c)
+}
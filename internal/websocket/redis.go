@@ -0,0 +1,83 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisBackend 通过 Redis 发布/订阅在实例之间转发消息
+type RedisBackend struct {
+	rdb              *redis.Client
+	broadcastChannel string
+	inboundChannel   string
+}
+
+// redisFrame 是 Redis 广播频道中的消息
+type redisFrame struct {
+	State bool            `json:"state"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// NewRedisBackend 创建 Redis 后端，prefix 用于区分同一 Redis 上的多个点歌台
+func NewRedisBackend(rdb *redis.Client, prefix string) *RedisBackend {
+	return &RedisBackend{
+		rdb:              rdb,
+		broadcastChannel: prefix + ":broadcast",
+		inboundChannel:   prefix + ":inbound",
+	}
+}
+
+func (b *RedisBackend) PublishBroadcast(data []byte, state bool) error {
+	payload, err := json.Marshal(redisFrame{State: state, Data: data})
+	if err != nil {
+		return err
+	}
+	return b.rdb.Publish(context.Background(), b.broadcastChannel, payload).Err()
+}
+
+func (b *RedisBackend) PublishInbound(msg InboundMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return b.rdb.Publish(context.Background(), b.inboundChannel, payload).Err()
+}
+
+func (b *RedisBackend) Run(ctx context.Context, onBroadcast func(data []byte, state bool), onInbound func(InboundMessage)) error {
+	sub := b.rdb.Subscribe(ctx, b.broadcastChannel, b.inboundChannel)
+	defer sub.Close()
+	// 等待订阅生效，之后发布的消息都不会丢失
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			switch msg.Channel {
+			case b.broadcastChannel:
+				var f redisFrame
+				if err := json.Unmarshal([]byte(msg.Payload), &f); err != nil {
+					log.Printf("Invalid broadcast frame from Redis: %v", err)
+					continue
+				}
+				onBroadcast(f.Data, f.State)
+			case b.inboundChannel:
+				var in InboundMessage
+				if err := json.Unmarshal([]byte(msg.Payload), &in); err != nil {
+					log.Printf("Invalid inbound message from Redis: %v", err)
+					continue
+				}
+				onInbound(in)
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package websocket
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// replayPerTopic 每种事件保留最近多少条用于断线重连后补发，各类事件分开保留，
+	// 频繁的进度事件不会把聊天等少量事件挤出缓冲区
+	replayPerTopic = 64
+	// maxReplayEvents 一次最多补发的事件数，超过时改为发送完整状态
+	maxReplayEvents = maxQueuedEvents / 2
+)
+
+// 客户端重连后发送 {"type":"RESUME","lastSeq":N} 补发错过的事件，服务端以 RESUMED 事件回复
+const (
+	msgTypeResume = "RESUME"
+	EventResumed  = "RESUMED"
+)
+
+// ResumeResult 是 RESUMED 事件的数据
+// Complete 为 false 表示错过的事件已不在缓冲区中，服务端改为重新发送完整状态
+type ResumeResult struct {
+	LastSeq  int64 `json:"lastSeq"`
+	Replayed int   `json:"replayed"`
+	Complete bool  `json:"complete"`
+}
+
+// replayBuffer 保存最近广播的事件，按事件类型分别保留
+// 序号从启动时刻的毫秒时间戳乘以 1000 开始编号，带着重启前或其他实例的序号来恢复时会落在范围之外，按过期处理
+type replayBuffer struct {
+	mu     sync.Mutex
+	base   int64
+	seq    int64
+	topics map[string]*topicRing
+}
+
+// topicRing 一种事件最近的若干条，evictedSeq 是已被挤出的最大序号
+type topicRing struct {
+	events     []bufferedEvent
+	evictedSeq int64
+}
+
+type bufferedEvent struct {
+	seq  int64
+	data []byte
+}
+
+func newReplayBuffer() *replayBuffer {
+	base := time.Now().UnixMilli() * 1000
+	return &replayBuffer{base: base, seq: base, topics: make(map[string]*topicRing)}
+}
+
+// record 为事件分配序号并写入缓冲区，返回带 seq 字段的消息；无法识别类型的消息原样返回
+// 调用方需持有 b.mu，并在释放之前把消息交给 Hub，保证客户端收到的序号递增
+func (b *replayBuffer) record(data []byte) []byte {
+	var event struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil || event.Type == "" || len(data) < 2 || data[0] != '{' {
+		return data
+	}
+	b.seq++
+	out := make([]byte, 0, len(data)+32)
+	out = append(out, `{"seq":`...)
+	out = strconv.AppendInt(out, b.seq, 10)
+	if data[1] != '}' {
+		out = append(out, ',')
+	}
+	out = append(out, data[1:]...)
+
+	ring, ok := b.topics[event.Type]
+	if !ok {
+		ring = &topicRing{}
+		b.topics[event.Type] = ring
+	}
+	ring.events = append(ring.events, bufferedEvent{seq: b.seq, data: out})
+	if len(ring.events) > replayPerTopic {
+		ring.evictedSeq = ring.events[0].seq
+		ring.events = append(ring.events[:0], ring.events[1:]...)
+	}
+	return out
+}
+
+// lastSeq 返回最近分配的序号
+func (b *replayBuffer) lastSeq() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.seq
+}
+
+// since 返回序号大于 lastSeq 的事件，按序号排列；有错过的事件已被挤出或序号无法识别时返回 false
+func (b *replayBuffer) since(lastSeq int64) ([][]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if lastSeq < b.base || lastSeq > b.seq {
+		return nil, false
+	}
+	var missed []bufferedEvent
+	for _, ring := range b.topics {
+		if ring.evictedSeq > lastSeq {
+			return nil, false
+		}
+		for _, event := range ring.events {
+			if event.seq > lastSeq {
+				missed = append(missed, event)
+			}
+		}
+	}
+	if len(missed) > maxReplayEvents {
+		return nil, false
+	}
+	sort.Slice(missed, func(i, j int) bool { return missed[i].seq < missed[j].seq })
+	messages := make([][]byte, len(missed))
+	for i, event := range missed {
+		messages[i] = event.data
+	}
+	return messages, true
+}
+
+// handleResume 处理客户端的 RESUME 消息，不是 RESUME 时返回 false
+// 补发与实时广播可能重叠，客户端应按序号去重
+func (c *Client) handleResume(message []byte) bool {
+	var msg struct {
+		Type    string `json:"type"`
+		LastSeq int64  `json:"lastSeq"`
+	}
+	if err := json.Unmarshal(message, &msg); err != nil || msg.Type != msgTypeResume {
+		return false
+	}
+	result := ResumeResult{LastSeq: msg.LastSeq}
+	missed, ok := c.hub.replay.since(msg.LastSeq)
+	if ok {
+		for _, data := range missed {
+			f := frame{data: data}
+			if !c.hub.allowed(c, f) {
+				continue
+			}
+			c.enqueue(f)
+			result.Replayed++
+		}
+		result.Complete = true
+	} else if c.snapshot != nil {
+		// 连接时已经发过完整状态，这里再发一次最新的，客户端据此丢弃本地可能过期的数据
+		if state := c.snapshot(); state != nil {
+			if data, err := json.Marshal(state); err == nil {
+				c.enqueue(frame{data: data, state: true})
+			}
+		}
+	}
+	data, err := json.Marshal(Event{Type: EventResumed, Data: result, ServerTime: time.Now().UnixMilli()})
+	if err == nil {
+		c.enqueue(frame{data: data})
+	}
+	return true
+}